@@ -0,0 +1,67 @@
+// Command benchcmp compares go test benchmark output read from standard
+// input against a baseline file and exits with status 1 when a benchmark
+// regressed beyond the thresholds
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cksidharthan/net-tools/pkg/benchcmp"
+)
+
+func main() {
+	baselinePath := flag.String("baseline", "testdata/bench/baseline.txt", "go test -bench output to compare against")
+	timeThreshold := flag.Float64("time", benchcmp.DefaultThresholds[benchcmp.UnitTime], "allowed ns/op increase in percent")
+	bytesThreshold := flag.Float64("bytes", benchcmp.DefaultThresholds[benchcmp.UnitBytes], "allowed B/op increase in percent")
+	allocsThreshold := flag.Float64("allocs", benchcmp.DefaultThresholds[benchcmp.UnitAllocs], "allowed allocs/op increase in percent")
+	flag.Parse()
+
+	file, err := os.Open(*baselinePath)
+	if err != nil {
+		log.Fatalf("Failed to open baseline: %v", err)
+	}
+	baseline, err := benchcmp.Parse(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Failed to read baseline: %v", err)
+	}
+	current, err := benchcmp.Parse(os.Stdin)
+	if err != nil {
+		log.Fatalf("Failed to read benchmark results: %v", err)
+	}
+	if len(current) == 0 {
+		log.Fatal("No benchmark results on standard input")
+	}
+
+	thresholds := benchcmp.Thresholds{
+		benchcmp.UnitTime:   *timeThreshold,
+		benchcmp.UnitBytes:  *bytesThreshold,
+		benchcmp.UnitAllocs: *allocsThreshold,
+	}
+	deltas := benchcmp.Compare(baseline, current, thresholds)
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "BENCHMARK\tUNIT\tBASELINE\tCURRENT\tCHANGE\t")
+	regressions := 0
+	for _, d := range deltas {
+		status := ""
+		if d.Regression {
+			status = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(table, "%s\t%s\t%.6g\t%.6g\t%+.1f%%\t%s\n", d.Name, d.Unit, d.Baseline, d.Current, d.Change, status)
+	}
+	table.Flush()
+	for _, name := range benchcmp.Missing(baseline, current) {
+		fmt.Printf("%s is not in the baseline\n", name)
+	}
+
+	if regressions > 0 {
+		fmt.Printf("%d results regressed beyond %.0f%% ns/op, %.0f%% B/op or %.0f%% allocs/op\n", regressions, *timeThreshold, *bytesThreshold, *allocsThreshold)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,31 @@
+// Command mock serves recorded sessions in place of the real tools, for
+// testing clients without network access
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/cksidharthan/net-tools/pkg/mock"
+)
+
+func main() {
+	addr := flag.String("addr", ":3001", "address to listen on")
+	speed := flag.Float64("speed", 0, "replay speed multiplier (0 sends messages without delay)")
+	flag.Parse()
+
+	server := mock.NewServer()
+	server.Speed = *speed
+	for _, path := range flag.Args() {
+		if err := server.Load(path); err != nil {
+			log.Fatalf("Failed to load recording: %v", err)
+		}
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("Usage: mock [-addr :3001] [-speed 1] recording.json...")
+	}
+
+	log.Printf("Replaying %d recordings on %s", flag.NArg(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -1,20 +1,301 @@
 package main
 
 import (
+	"expvar"
+	"flag"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/cksidharthan/net-tools/pkg"
+	"github.com/cksidharthan/net-tools/pkg/clockskew"
+	"github.com/cksidharthan/net-tools/pkg/console"
+	"github.com/cksidharthan/net-tools/pkg/ct"
+	"github.com/cksidharthan/net-tools/pkg/dns"
+	"github.com/cksidharthan/net-tools/pkg/echosvc"
+	"github.com/cksidharthan/net-tools/pkg/httpbench"
+	"github.com/cksidharthan/net-tools/pkg/httpcheck"
+	"github.com/cksidharthan/net-tools/pkg/httpver"
+	"github.com/cksidharthan/net-tools/pkg/inbound"
+	"github.com/cksidharthan/net-tools/pkg/iptools"
+	"github.com/cksidharthan/net-tools/pkg/ipv6ready"
+	"github.com/cksidharthan/net-tools/pkg/knock"
+	"github.com/cksidharthan/net-tools/pkg/lbdist"
+	"github.com/cksidharthan/net-tools/pkg/mailsec"
+	"github.com/cksidharthan/net-tools/pkg/marking"
+	"github.com/cksidharthan/net-tools/pkg/mirror"
+	"github.com/cksidharthan/net-tools/pkg/mtu"
+	"github.com/cksidharthan/net-tools/pkg/pac"
+	"github.com/cksidharthan/net-tools/pkg/pcap"
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/redirects"
+	"github.com/cksidharthan/net-tools/pkg/script"
+	"github.com/cksidharthan/net-tools/pkg/stamp"
+	"github.com/cksidharthan/net-tools/pkg/tlsinspect"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/cksidharthan/net-tools/pkg/traceroute"
+	"github.com/cksidharthan/net-tools/pkg/tunnel"
+	"github.com/cksidharthan/net-tools/pkg/vhost"
+	"github.com/cksidharthan/net-tools/pkg/whois"
+	"github.com/cksidharthan/net-tools/pkg/wsdebug"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 func main() {
+	addr := flag.String("addr", ":3000", "address to listen on")
+	stateFile := flag.String("state-file", "net-tools-state.json", "file to persist runtime tool state in (empty to disable)")
+	templatesFile := flag.String("templates-file", "net-tools-templates.json", "file to persist users' request templates in (empty to disable)")
+	adminToken := flag.String("admin-token", "", "bearer token for the admin API (admin API is disabled when empty)")
+	adminSecret := flag.String("admin-hmac-secret", "", "secret for HMAC signed admin API requests (signed requests are refused when empty)")
+	operatorToken := flag.String("operator-token", "", "bearer token for operator tools such as the raw TCP console (signed requests use -admin-hmac-secret)")
+	consoleAllow := flag.String("console-allow", "", "comma separated host:ports the raw TCP console may connect to, e.g. \"10.0.0.0/8:25,*.example.com:80-443\" (disabled when empty)")
+	users := flag.String("users", "", "comma separated name=token pairs of users whose runs are kept in /api/history (history is disabled when empty)")
+	history := flag.Int("history", 50, "number of recent runs to keep per user")
+	recordings := flag.Int("recordings", 100, "number of recent sessions to keep recordings of (0 disables recording)")
+	observeInbound := flag.Bool("observe-inbound", false, "record inbound ICMP echo requests and traceroute probes (requires CAP_NET_RAW)")
+	echoAddr := flag.String("echo-addr", "", "address for the TCP/UDP echo service, e.g. :7 (disabled when empty)")
+	discardAddr := flag.String("discard-addr", "", "address for the TCP/UDP discard service, e.g. :9 (disabled when empty)")
+	timestampEchoAddr := flag.String("timestamp-echo-addr", "", "address for the timestamped UDP echo service (disabled when empty)")
+	stampAddr := flag.String("stamp-addr", "", "UDP address for the STAMP session-reflector, e.g. :862 (disabled when empty)")
+	mirrorVXLANAddr := flag.String("mirror-vxlan-addr", "", "UDP address receiving VXLAN mirrored traffic, e.g. :4789 (disabled when empty)")
+	mirrorERSPAN := flag.Bool("mirror-erspan", false, "receive ERSPAN and GRE mirrored traffic (requires CAP_NET_RAW)")
+	mirrorBuffer := flag.Int("mirror-buffer", mirror.DefaultCapacity, "number of mirrored frames kept for download and analysis")
+	proxyProtocol := flag.String("proxy-protocol", "", "comma separated networks of load balancers whose TCP connections start with a PROXY protocol header (disabled when empty)")
+	userAgent := flag.String("user-agent", tool.DefaultUserAgent, "User-Agent sent with outbound HTTP probes")
+	probeFrom := flag.String("probe-from", "", "contact address or URL sent in the From header of outbound HTTP probes")
+	icmpSignature := flag.String("icmp-signature", probe.DefaultPayloadSignature, "signature written to the start of ICMP echo payloads")
+	egressIPs := flag.String("egress-ips", "", "comma separated source IPs or interfaces probes rotate through (kernel default when empty)")
+	egressPools := flag.String("egress-pools", "", "named egress pools requests can select, e.g. \"eu=10.0.0.1,10.0.0.2;us=eth1\"")
+	shedCPU := flag.Float64("shed-cpu", 0.9, "fraction of all CPUs at which new sessions are rejected (0 disables)")
+	shedMemory := flag.Int("shed-memory-mb", 0, "heap size in MiB at which new sessions are rejected (0 disables)")
+	shedFDs := flag.Float64("shed-fds", 0.9, "fraction of the open file limit at which new sessions are rejected (0 disables)")
+	shedSockets := flag.Int("shed-sockets", 0, "open sockets at which new sessions are rejected (0 disables)")
+	messageLimits := flag.String("message-limits", "", "per tool WebSocket read:write limits in bytes, e.g. \"ping=4096:65536;script=:4194304\"")
+	minWait := flag.Duration("min-wait", pkg.DefaultMinWait, "shortest interval between pings clients may ask for")
+	simulate := flag.Bool("simulate", false, "answer ping probes with synthetic results instead of sending them")
+	simulateProfile := flag.String("simulate-profile", "", "simulated results, e.g. \"dist=normal,latency=20ms,jitter=4ms,loss=0.02,down=0.5\"")
+	simulateSeed := flag.Uint64("simulate-seed", 1, "seed for simulated results; the same seed repeats the same results")
+	demo := flag.Bool("demo", false, "run as a public demo: only ping, dns and traceroute to public targets, rate limited per client")
+	demoLimits := flag.String("demo-limits", "", "demo limits, e.g. \"requests=20,concurrent=2,duration=1m,count=10,targets=3,wait=1s\"")
+	flag.Parse()
+
+	tool.SetIdentity(*userAgent, *probeFrom)
+	probe.SetPayloadSignature(*icmpSignature)
+	if *minWait <= 0 {
+		log.Fatalf("Invalid -min-wait %s: must be positive", *minWait)
+	}
+	pkg.SetMinWait(*minWait)
+	if err := tool.Egress.Configure(*egressIPs, *egressPools); err != nil {
+		log.Fatalf("Failed to configure egress pools: %v", err)
+	}
+	if err := tool.ProxyProtocol.Configure(*proxyProtocol); err != nil {
+		log.Fatalf("Failed to configure PROXY protocol: %v", err)
+	}
+	if err := tool.Limits.Configure(*messageLimits); err != nil {
+		log.Fatalf("Failed to configure message limits: %v", err)
+	}
+	if *simulate {
+		if err := tool.Simulation.Configure(*simulateProfile, *simulateSeed); err != nil {
+			log.Fatalf("Failed to configure simulation: %v", err)
+		}
+		log.Print("Simulation mode: ping probes return synthetic results")
+	}
+	if *demo {
+		if *consoleAllow != "" || *observeInbound || *mirrorVXLANAddr != "" || *mirrorERSPAN {
+			log.Fatalf("Invalid -demo: the console, inbound observer and mirror receiver are not available in demo mode")
+		}
+		if err := tool.Demo.Configure(*demoLimits); err != nil {
+			log.Fatalf("Failed to configure demo mode: %v", err)
+		}
+		pkg.SetMinWait(max(*minWait, tool.Demo.Limits().Wait))
+		log.Printf("Demo mode: serving only %s to public targets", strings.Join(tool.DemoTools, ", "))
+	}
+
+	tool.Recordings.SetCapacity(*recordings)
+	if err := tool.Users.Configure(*users); err != nil {
+		log.Fatalf("Failed to configure users: %v", err)
+	}
+	tool.History.SetCapacity(*history)
+	tool.Load.Start(tool.LoadLimits{
+		CPU:     *shedCPU,
+		Memory:  uint64(*shedMemory) << 20,
+		FDs:     *shedFDs,
+		Sockets: *shedSockets,
+	})
+
+	registry, err := tool.NewRegistry(*stateFile)
+	if err != nil {
+		log.Fatalf("Failed to load tool state: %v", err)
+	}
+	registry.Register(tool.Tool{Name: "ping", Path: "/ping", Description: "Ping a host over HTTP, ICMP, TCP connect or UDP", Handler: pkg.PingHandler})
+	registry.Register(tool.Tool{Name: "mtu", Path: "/mtu", Description: "Discover the path MTU to a host with a DF bit binary search", Handler: mtu.Handler})
+	registry.Register(tool.Tool{Name: "marking", Path: "/marking", Description: "Detect DSCP and ECN markings being remarked or bleached along a path", Handler: marking.Handler})
+	registry.Register(tool.Tool{Name: "traceroute", Path: "/traceroute", Description: "Trace the route to a host with ICMP, UDP or TCP probes", Handler: traceroute.Handler})
+	registry.Register(tool.Tool{Name: "mtr", Path: "/mtr", Description: "Monitor per-hop loss and latency along a path continuously, like mtr", Handler: traceroute.MTRHandler})
+	registry.Register(tool.Tool{Name: "tunnel", Path: "/tunnel", Description: "Check WireGuard handshakes and ping through a tunnel interface to measure its overhead", Handler: tunnel.Handler})
+	registry.Register(tool.Tool{Name: "knock", Path: "/knock", Description: "Send a TCP/UDP port knock sequence and test whether the protected port opened", Handler: knock.Handler})
+	registry.Register(tool.Tool{Name: "wsdebug", Path: "/wsdebug", Description: "Debug WebSocket handshakes step by step", Handler: wsdebug.Handler})
+	registry.Register(tool.Tool{Name: "pac", Path: "/pac", Description: "Evaluate proxy auto-config files", Handler: pac.Handler})
+	registry.Register(tool.Tool{Name: "dns", Path: "/dns", Description: "Query DNS records, optionally tracing delegation from the root", Handler: dns.Handler})
+	registry.Register(tool.Tool{Name: "axfr", Path: "/axfr", Description: "Test whether nameservers allow zone transfers", Handler: dns.TransferHandler})
+	registry.Register(tool.Tool{Name: "dnsbench", Path: "/dnsbench", Description: "Benchmark query latency of a zone's authoritative nameservers and chosen resolvers", Handler: dns.BenchHandler})
+	registry.Register(tool.Tool{Name: "dnswatch", Path: "/dnswatch", Description: "Watch a DNS record and report every change to its answer set", Handler: dns.WatchHandler})
+	registry.Register(tool.Tool{Name: "propagation", Path: "/propagation", Description: "Compare the answers of public resolvers to watch a DNS change propagate", Handler: dns.PropagationHandler})
+	registry.Register(tool.Tool{Name: "anycast", Path: "/anycast", Description: "Identify the anycast instance or POP reached for DNS and CDN services", Handler: dns.AnycastHandler})
+	registry.Register(tool.Tool{Name: "mailsec", Path: "/mailsec", Description: "Analyze SPF, DKIM, DMARC, MTA-STS and TLS-RPT policies", Handler: mailsec.Handler})
+	registry.Register(tool.Tool{Name: "vhost", Path: "/vhost", Description: "Compare certificates and content across SNI names and Host headers on one IP", Handler: vhost.Handler})
+	registry.Register(tool.Tool{Name: "tls", Path: "/tls", Description: "Inspect a server's TLS handshake and certificate chain", Handler: tlsinspect.Handler})
+	registry.Register(tool.Tool{Name: "ipv6", Path: "/ipv6", Description: "Audit whether a service is fully usable over IPv6 only", Handler: ipv6ready.Handler})
+	registry.Register(tool.Tool{Name: "httpver", Path: "/httpver", Description: "Check HTTP/2 (ALPN and h2c) and HTTP/3 (Alt-Svc, QUIC and 0-RTT) support", Handler: httpver.Handler})
+	registry.Register(tool.Tool{Name: "bench", Path: "/bench", Description: "Load test a URL with concurrent requests, reporting throughput and latency percentiles", Handler: httpbench.Handler})
+	registry.Register(tool.Tool{Name: "lb", Path: "/lb", Description: "Estimate the backends behind a load balancer and how evenly they are used", Handler: lbdist.Handler})
+	registry.Register(tool.Tool{Name: "redirects", Path: "/redirects", Description: "Follow a URL's redirect chain hop by hop, flagging downgrades, loops and cookies", Handler: redirects.Handler})
+	registry.Register(tool.Tool{Name: "clockskew", Path: "/clockskew", Description: "Estimate a remote host's clock offset via NTP, ICMP timestamps and HTTP Date", Handler: clockskew.Handler})
+	registry.Register(tool.Tool{Name: "script", Path: "/script", Description: "Run sandboxed Starlark check scripts", Handler: script.Handler})
+
+	var observer *inbound.Observer
+	if *observeInbound {
+		observer = inbound.NewObserver()
+		if err := observer.Start(); err != nil {
+			log.Fatalf("Failed to start inbound observer: %v", err)
+		}
+		registry.Register(tool.Tool{Name: "inbound", Path: "/inbound", Description: "Stream inbound ICMP echo requests and traceroute probes hitting this server", Handler: observer.Handler})
+	}
+
+	if *consoleAllow != "" {
+		if *operatorToken == "" && *adminSecret == "" {
+			log.Fatalf("Invalid -console-allow: the console requires -operator-token or -admin-hmac-secret")
+		}
+		relay, err := console.New(*consoleAllow)
+		if err != nil {
+			log.Fatalf("Invalid -console-allow: %v", err)
+		}
+		handler := tool.RequireAuth(*operatorToken, *adminSecret)(http.HandlerFunc(relay.Handler))
+		registry.Register(tool.Tool{Name: "console", Path: "/console", Description: "Relay an interactive raw TCP session to an allowlisted host and port", Handler: handler.ServeHTTP})
+	}
+
+	if *demo {
+		registry.Restrict(tool.DemoTools...)
+	}
+
+	services := []struct {
+		addr   string
+		listen func(string) error
+	}{
+		{*echoAddr, echosvc.ListenEcho},
+		{*discardAddr, echosvc.ListenDiscard},
+		{*timestampEchoAddr, echosvc.ListenTimestampEcho},
+		{*stampAddr, stamp.ListenReflector},
+	}
+	for _, service := range services {
+		if service.addr == "" {
+			continue
+		}
+		if err := service.listen(service.addr); err != nil {
+			log.Fatalf("Failed to start test service: %v", err)
+		}
+	}
+
+	var receiver *mirror.Receiver
+	if *mirrorVXLANAddr != "" || *mirrorERSPAN {
+		if *operatorToken == "" && *adminSecret == "" {
+			log.Fatalf("Invalid mirror receiver: mirrored traffic requires -operator-token or -admin-hmac-secret")
+		}
+		if *mirrorBuffer <= 0 {
+			log.Fatalf("Invalid -mirror-buffer: must be positive")
+		}
+		receiver = mirror.NewReceiver(*mirrorBuffer)
+		if *mirrorVXLANAddr != "" {
+			if err := receiver.ListenVXLAN(*mirrorVXLANAddr); err != nil {
+				log.Fatalf("Failed to start mirror receiver: %v", err)
+			}
+		}
+		if *mirrorERSPAN {
+			if err := receiver.ListenERSPAN(); err != nil {
+				log.Fatalf("Failed to start mirror receiver: %v", err)
+			}
+		}
+	}
+
 	chiRouter := chi.NewRouter()
+	chiRouter.Use(tool.BearerFromQuery)
 	chiRouter.Use(middleware.Logger)
 	chiRouter.Use(middleware.Recoverer)
 	chiRouter.Use(middleware.URLFormat)
+	if *demo {
+		chiRouter.Use(tool.Demo.Limit)
+	}
+
+	registry.Mount(chiRouter)
+	chiRouter.Get("/api/capabilities", registry.CapabilitiesHandler)
+	// Demo instances serve nothing beyond their tools, as the other APIs
+	// store state or reach targets on behalf of callers
+	if !*demo {
+		chiRouter.Get("/api/sessions/{id}", tool.Recordings.GetHandler)
+		chiRouter.Get("/api/sessions/{id}/replay", tool.Recordings.ReplayHandler)
+		chiRouter.Get("/api/sessions/{id}/export", tool.Recordings.ExportHandler)
+		runner := tool.NewRunner(chiRouter)
+		templates, err := tool.NewTemplateStore(*templatesFile, registry, runner)
+		if err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+		chiRouter.Get("/api/history", tool.History.Handler)
+		chiRouter.Post("/api/history/{id}/rerun", runner.RerunHandler)
+		chiRouter.Route("/api/templates", func(r chi.Router) {
+			r.Get("/", templates.ListHandler)
+			r.Get("/{name}", templates.GetHandler)
+			r.Put("/{name}", templates.PutHandler)
+			r.Delete("/{name}", templates.DeleteHandler)
+			r.Post("/{name}/run", templates.RunHandler)
+		})
+		chiRouter.Post("/api/sessions/{id}/share", tool.SharedSessions.CreateHandler)
+		chiRouter.Get("/api/share/{token}", tool.SharedSessions.JSONHandler)
+		chiRouter.Get("/share/{token}", tool.SharedSessions.PageHandler)
+		chiRouter.Route("/api/iptools", func(r chi.Router) {
+			r.Get("/parse", iptools.ParseHandler)
+			r.Get("/cidr", iptools.CIDRHandler)
+			r.Get("/contains", iptools.ContainsHandler)
+			r.Get("/range", iptools.RangeHandler)
+			r.Get("/ptr", iptools.PTRHandler)
+		})
+		chiRouter.Get("/api/mtu", mtu.APIHandler)
+		chiRouter.Post("/api/dns/ptr", dns.PTRHandler)
+		chiRouter.Post("/api/dns/resolve", dns.ResolveHandler)
+		chiRouter.Get("/api/whois", whois.Handler)
+		chiRouter.Get("/api/ct", ct.Handler)
+		chiRouter.Post("/api/httpcheck", httpcheck.Handler)
+		chiRouter.Post("/api/pcap", pcap.Handler)
+		if observer != nil {
+			chiRouter.Get("/api/inbound", observer.CountersHandler)
+		}
+		if receiver != nil {
+			chiRouter.Route("/api/mirror", func(r chi.Router) {
+				r.Use(tool.RequireAuth(*operatorToken, *adminSecret))
+				r.Get("/", receiver.StatusHandler)
+				r.Get("/pcap", receiver.PcapHandler)
+				r.Get("/analysis", receiver.AnalysisHandler)
+			})
+		}
+	}
 
-	chiRouter.Get("/ping", pkg.PingHandler)
+	if *adminToken != "" || *adminSecret != "" {
+		chiRouter.Route("/api/admin", func(r chi.Router) {
+			r.Use(tool.RequireAuth(*adminToken, *adminSecret))
+			r.Get("/tools", registry.CapabilitiesHandler)
+			r.Put("/tools/{name}", registry.SetEnabledHandler)
+			r.Get("/sessions", tool.ActiveSessions.ListHandler)
+			r.Delete("/sessions/{id}", tool.ActiveSessions.KillHandler)
+			// expvar always publishes the command line, tokens included
+			r.Handle("/vars", expvar.Handler())
+		})
+	} else {
+		log.Printf("No admin token or HMAC secret set, admin API is disabled")
+	}
 
-	http.ListenAndServe(":3000", chiRouter)
+	ln, err := tool.ProxyProtocol.Listen(*addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+	http.Serve(ln, chiRouter)
 }
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/conformance"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/gorilla/websocket"
+)
+
+// simulate enables simulation with probes that answer at once, so sweep
+// benchmarks measure scheduling rather than the network
+var simulate = sync.OnceValue(func() error {
+	return tool.Simulation.Configure("dist=constant,latency=50us,jitter=0,loss=0", 1)
+})
+
+func BenchmarkResolvePingOptions(b *testing.B) {
+	cases := []struct {
+		name    string
+		request string
+	}{
+		{"icmp", `{"address":"192.0.2.1","count":10,"wait":"500ms"}`},
+		{"http", `{"address":"https://user@example.com:8443/health?check=1","count":10,"extract":["status=(?P<status>\\w+)"],"track_changes":true}`},
+		{"sweep", `{"address":"10.0.0.0/20","protocol":"tcp","port":443,"concurrency":128}`},
+		{"targets", `{"targets":["192.0.2.1","192.0.2.2","192.0.2.3","192.0.2.4","192.0.2.5","192.0.2.6","192.0.2.7","192.0.2.8"],"protocol":"udp","port":53}`},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				var msg PingMessage
+				if err := tool.DecodeJSON([]byte(c.request), &msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := resolvePingOptions(&msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// quiet discards log output for the rest of the benchmark, which would
+// otherwise break up its result line
+func quiet(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// dial connects to the tool served by server
+func dial(b *testing.B, server *httptest.Server) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn
+}
+
+func BenchmarkWritePong(b *testing.B) {
+	for _, format := range []string{tool.FormatJSON, tool.FormatText} {
+		b.Run(format, func(b *testing.B) {
+			quiet(b)
+			start := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				session, err := tool.Upgrade(w, r, "ping")
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer session.Close()
+				<-start
+				for i := range b.N {
+					pong := createPongMessage("192.0.2.1", i, 12.345, true)
+					pong.TTL, pong.Jitter, pong.MovingAvg = 57, 0.42, 12.3
+					if err := sendPongMessage(session, pong); err != nil {
+						b.Error(err)
+						return
+					}
+					if format == tool.FormatText {
+						if err := session.WriteText(formatPingResult(pong.Address, i, pong.Bytes, pong.TTL, pong.Latency, true)); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}
+			}))
+			defer server.Close()
+			conn := dial(b, server)
+			defer conn.Close()
+			if _, _, err := conn.ReadMessage(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			close(start)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					break
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSharedBroadcast(b *testing.B) {
+	for _, subscribers := range []int{1, 16, 256} {
+		b.Run(strconv.Itoa(subscribers), func(b *testing.B) {
+			shared := &sharedProbes{streams: make(map[sharedKey]*sharedStream)}
+			stream := &sharedStream{subscribers: make(map[chan probeResult]struct{})}
+			var wg sync.WaitGroup
+			for range subscribers {
+				ch := make(chan probeResult, sharedQueue)
+				stream.subscribers[ch] = struct{}{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range ch {
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				shared.broadcast(stream, probeResult{latency: float64(i), ttl: 57})
+			}
+			b.StopTimer()
+			for ch := range stream.subscribers {
+				close(ch)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkSweepHosts(b *testing.B) {
+	for _, network := range []string{"192.0.2.0/24", "10.0.0.0/20", "2001:db8::/116"} {
+		b.Run(network, func(b *testing.B) {
+			_, ipNet, err := net.ParseCIDR(network)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			for range b.N {
+				sweepHosts(ipNet)
+			}
+		})
+	}
+}
+
+// BenchmarkSweep runs whole sweeps of simulated hosts through the ping
+// handler, measuring how hosts are scheduled over the workers and reported
+func BenchmarkSweep(b *testing.B) {
+	if err := simulate(); err != nil {
+		b.Fatal(err)
+	}
+	quiet(b)
+	server := httptest.NewServer(http.HandlerFunc(PingHandler))
+	defer server.Close()
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, concurrency := range []int{16, 256} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			c := conformance.Case{
+				Request: map[string]any{"address": "192.0.2.0/24", "protocol": "icmp", "concurrency": concurrency},
+				Expect:  "session( host){254} sweep",
+				Timeout: time.Minute,
+			}
+			b.ReportAllocs()
+			for range b.N {
+				if err := conformance.Check(baseURL, c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package benchcmp compares go test benchmark results against a baseline,
+// so that performance regressions of the hot paths fail the build instead of
+// going unnoticed
+package benchcmp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Units compared, as go test -benchmem reports them
+const (
+	UnitTime   = "ns/op"
+	UnitBytes  = "B/op"
+	UnitAllocs = "allocs/op"
+)
+
+// Units are the units Compare looks at, in the order they are reported
+var Units = []string{UnitTime, UnitBytes, UnitAllocs}
+
+// ErrFailed is returned by Parse when the output reports a failed benchmark
+var ErrFailed = errors.New("benchmarks failed")
+
+// procSuffix is the GOMAXPROCS suffix go test appends to benchmark names
+var procSuffix = regexp.MustCompile(`-\d+$`)
+
+// Results holds the values of every run of each benchmark by unit. Names
+// are the last element of the package path and the benchmark name without
+// its GOMAXPROCS suffix, e.g. pkg.BenchmarkSweep/16.
+type Results map[string]map[string][]float64
+
+// Parse reads the output of go test -bench, which may hold several runs of
+// each benchmark (-count) and several packages
+func Parse(r io.Reader) (Results, error) {
+	results := make(Results)
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "--- FAIL") || strings.HasPrefix(line, "FAIL") {
+			return nil, fmt.Errorf("%w: %s", ErrFailed, line)
+		}
+		if name, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = path.Base(strings.TrimSpace(name))
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := procSuffix.ReplaceAllString(fields[0], "")
+		if pkg != "" {
+			name = pkg + "." + name
+		}
+		// Values and units alternate after the iteration count
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s of %s: %q", fields[i+1], name, fields[i])
+			}
+			if results[name] == nil {
+				results[name] = make(map[string][]float64)
+			}
+			results[name][fields[i+1]] = append(results[name][fields[i+1]], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// median returns the median of values, which is robust against the odd
+// slow run of a noisy machine
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Thresholds are the increases over the baseline, in percent, from which a
+// unit counts as regressed
+type Thresholds map[string]float64
+
+// DefaultThresholds allow for timing noise between runs, while memory use is
+// nearly deterministic and held to a tighter bound
+var DefaultThresholds = Thresholds{UnitTime: 25, UnitBytes: 10, UnitAllocs: 10}
+
+// Delta is the change of one unit of a benchmark
+type Delta struct {
+	Name       string  // Benchmark name
+	Unit       string  // Unit compared
+	Baseline   float64 // Median of the baseline runs
+	Current    float64 // Median of the current runs
+	Change     float64 // Change in percent, positive when worse
+	Regression bool    // Whether the change exceeds the threshold of the unit
+}
+
+// Compare compares the medians of every benchmark and unit in current with
+// the baseline. Benchmarks or units missing from either side are left out,
+// so a filtered run is compared against a full baseline.
+func Compare(baseline, current Results, thresholds Thresholds) []Delta {
+	var deltas []Delta
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, unit := range Units {
+			base, cur := baseline[name][unit], current[name][unit]
+			if len(base) == 0 || len(cur) == 0 {
+				continue
+			}
+			d := Delta{Name: name, Unit: unit, Baseline: median(base), Current: median(cur)}
+			// Growing from zero, e.g. a first allocation, counts in full
+			d.Change = (d.Current - d.Baseline) / math.Max(d.Baseline, 1) * 100
+			threshold, ok := thresholds[unit]
+			d.Regression = ok && d.Change > threshold
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
+
+// Missing returns the benchmarks of current the baseline has no results for
+func Missing(baseline, current Results) []string {
+	var missing []string
+	for name := range current {
+		if _, ok := baseline[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,197 @@
+package benchcmp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    Results
+		wantErr error
+	}{
+		{
+			name: "runs of several packages",
+			output: `goos: linux
+goarch: amd64
+pkg: github.com/cksidharthan/net-tools/pkg
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkSweep/16-8   	  1000	      1500 ns/op	     256 B/op	       4 allocs/op
+BenchmarkSweep/16-8   	  1000	      1700 ns/op	     256 B/op	       4 allocs/op
+BenchmarkEncode       	  5000	       300.5 ns/op
+PASS
+ok  	github.com/cksidharthan/net-tools/pkg	3.012s
+pkg: github.com/cksidharthan/net-tools/pkg/tool
+BenchmarkDecode-16    	   200	     90000 ns/op	    4096 B/op	      12 allocs/op
+PASS
+`,
+			want: Results{
+				"pkg.BenchmarkSweep/16": {UnitTime: {1500, 1700}, UnitBytes: {256, 256}, UnitAllocs: {4, 4}},
+				"pkg.BenchmarkEncode":   {UnitTime: {300.5}},
+				"tool.BenchmarkDecode":  {UnitTime: {90000}, UnitBytes: {4096}, UnitAllocs: {12}},
+			},
+		},
+		{
+			name:   "without a package line",
+			output: "BenchmarkSweep-4 10 20 ns/op\n",
+			want:   Results{"BenchmarkSweep": {UnitTime: {20}}},
+		},
+		{
+			name:   "only the gomaxprocs suffix is removed",
+			output: "BenchmarkSweep/size-16-4 10 20 ns/op\n",
+			want:   Results{"BenchmarkSweep/size-16": {UnitTime: {20}}},
+		},
+		{
+			name:   "extra metrics",
+			output: "BenchmarkSweep 10 20 ns/op 1.5 MB/s 3 hosts/op\n",
+			want:   Results{"BenchmarkSweep": {UnitTime: {20}, "MB/s": {1.5}, "hosts/op": {3}}},
+		},
+		{
+			name: "lines that are not results",
+			output: `BenchmarkSweep
+BenchmarkSweep 10
+BenchmarkSweep many 20 ns/op
+    bench_test.go:12: BenchmarkSweep 10 20 ns/op
+Benchmarks 10 20 ns/op
+`,
+			want: Results{"Benchmarks": {UnitTime: {20}}},
+		},
+		{name: "empty", output: "", want: Results{}},
+		{name: "no benchmarks", output: "PASS\nok  \tgithub.com/cksidharthan/net-tools/pkg\t0.003s\n", want: Results{}},
+		{name: "invalid value", output: "BenchmarkSweep 10 fast ns/op\n", wantErr: errors.New("invalid ns/op of BenchmarkSweep")},
+		{name: "failed benchmark", output: "BenchmarkSweep 10 20 ns/op\n--- FAIL: BenchmarkSweep\n", wantErr: ErrFailed},
+		{name: "failed package", output: "FAIL\tgithub.com/cksidharthan/net-tools/pkg\t0.010s\n", wantErr: ErrFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.output))
+			if tt.wantErr != nil {
+				if err == nil || !errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{[]float64{5}, 5},
+		{[]float64{9, 1, 5}, 5},
+		{[]float64{4, 1, 3, 2}, 2.5},
+		{[]float64{1, 1, 1000, 1, 1}, 1}, // One slow run does not move it
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	thresholds := Thresholds{UnitTime: 25, UnitAllocs: 10}
+	tests := []struct {
+		name     string
+		baseline map[string][]float64
+		current  map[string][]float64
+		want     []Delta
+	}{
+		{
+			name:     "at the threshold",
+			baseline: map[string][]float64{UnitTime: {100}},
+			current:  map[string][]float64{UnitTime: {125}},
+			want:     []Delta{{Unit: UnitTime, Baseline: 100, Current: 125, Change: 25}},
+		},
+		{
+			name:     "over the threshold",
+			baseline: map[string][]float64{UnitTime: {100}},
+			current:  map[string][]float64{UnitTime: {125.5}},
+			want:     []Delta{{Unit: UnitTime, Baseline: 100, Current: 125.5, Change: 25.5, Regression: true}},
+		},
+		{
+			name:     "improvement",
+			baseline: map[string][]float64{UnitTime: {200}, UnitAllocs: {10}},
+			current:  map[string][]float64{UnitTime: {100}, UnitAllocs: {10}},
+			want: []Delta{
+				{Unit: UnitTime, Baseline: 200, Current: 100, Change: -50},
+				{Unit: UnitAllocs, Baseline: 10, Current: 10, Change: 0},
+			},
+		},
+		{
+			name:     "medians of several runs",
+			baseline: map[string][]float64{UnitTime: {90, 100, 500}},
+			current:  map[string][]float64{UnitTime: {110, 120, 130, 2000}},
+			want:     []Delta{{Unit: UnitTime, Baseline: 100, Current: 125, Change: 25}},
+		},
+		{
+			name:     "first allocation",
+			baseline: map[string][]float64{UnitAllocs: {0}},
+			current:  map[string][]float64{UnitAllocs: {1}},
+			want:     []Delta{{Unit: UnitAllocs, Baseline: 0, Current: 1, Change: 100, Regression: true}},
+		},
+		{
+			name:     "zero to zero",
+			baseline: map[string][]float64{UnitAllocs: {0}},
+			current:  map[string][]float64{UnitAllocs: {0}},
+			want:     []Delta{{Unit: UnitAllocs, Baseline: 0, Current: 0, Change: 0}},
+		},
+		{
+			name:     "unit without a threshold",
+			baseline: map[string][]float64{UnitBytes: {100}},
+			current:  map[string][]float64{UnitBytes: {1000}},
+			want:     []Delta{{Unit: UnitBytes, Baseline: 100, Current: 1000, Change: 900}},
+		},
+		{
+			name:     "units missing on either side",
+			baseline: map[string][]float64{UnitTime: {100}},
+			current:  map[string][]float64{UnitAllocs: {3}, "MB/s": {10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compare(Results{"BenchmarkX": tt.baseline}, Results{"BenchmarkX": tt.current}, thresholds)
+			for i := range tt.want {
+				tt.want[i].Name = "BenchmarkX"
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareMissingBenchmarks(t *testing.T) {
+	baseline := Results{
+		"pkg.BenchmarkA": {UnitTime: {100}},
+		"pkg.BenchmarkB": {UnitTime: {100}},
+	}
+	current := Results{
+		"pkg.BenchmarkC": {UnitTime: {100}},
+		"pkg.BenchmarkA": {UnitTime: {150}},
+		"pkg.BenchmarkD": {UnitTime: {100}},
+	}
+	deltas := Compare(baseline, current, DefaultThresholds)
+	if len(deltas) != 1 || deltas[0].Name != "pkg.BenchmarkA" || !deltas[0].Regression {
+		t.Errorf("Compare() = %+v, want only the regression of BenchmarkA", deltas)
+	}
+	if got, want := Missing(baseline, current), []string{"pkg.BenchmarkC", "pkg.BenchmarkD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Missing() = %v, want %v", got, want)
+	}
+	if got := Missing(baseline, Results{}); got != nil {
+		t.Errorf("Missing() of an empty run = %v", got)
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import "strings"
+
+// Limits for body diffs
+const (
+	maxDiffLines = 200     // Diff lines reported per change
+	maxDiffCells = 4000000 // Largest line count product that is diffed
+)
+
+// diffLines returns a line diff from old to new, with removed lines
+// prefixed by "-" and added lines by "+". It returns nil when the bodies are
+// too large to diff.
+func diffLines(old, new string) []string {
+	a := strings.Split(old, "\n")
+	b := strings.Split(new, "\n")
+	if len(a)*len(b) > maxDiffCells {
+		return nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for (i < len(a) || j < len(b)) && len(diff) < maxDiffLines {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,315 @@
+package clockskew
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for clock skew options
+const (
+	defaultThreshold = 1000 // Offsets beyond one second are flagged
+	defaultTimeout   = 5    // 5 second timeout per method
+	ntpPort          = "123"
+	ntpPacketSize    = 48
+	// ntpEpochOffset is the number of seconds between 1900 and 1970
+	ntpEpochOffset = 2208988800
+)
+
+// Supported clock sources
+const (
+	MethodHTTP = "http" // HTTP Date header
+	MethodNTP  = "ntp"  // SNTP query
+	MethodICMP = "icmp" // ICMP timestamp request
+)
+
+// defaultMethods are the clock sources tried when none are given
+var defaultMethods = []string{MethodNTP, MethodICMP, MethodHTTP}
+
+// ClockMessage represents the incoming clock skew request
+type ClockMessage struct {
+	// Required
+	Host string `json:"host"` // Host whose clock is checked
+
+	// Optional parameters
+	Methods   []string `json:"methods,omitempty"`   // Clock sources to query (http, ntp, icmp)
+	URL       *string  `json:"url,omitempty"`       // URL for the HTTP Date check (defaults to https://host/)
+	Threshold *int     `json:"threshold,omitempty"` // Flag offsets larger than this many milliseconds
+	Timeout   *int     `json:"timeout,omitempty"`   // Timeout per method in seconds
+}
+
+// OffsetMessage reports the clock offset measured by one method
+type OffsetMessage struct {
+	Type        string  `json:"type"`             // Message type ("offset")
+	Method      string  `json:"method"`           // Clock source (http, ntp, icmp)
+	Server      string  `json:"server"`           // Address that was queried
+	Success     bool    `json:"success"`          // Whether an offset could be measured
+	Offset      float64 `json:"offset"`           // Remote clock minus local clock in milliseconds
+	RTT         float64 `json:"rtt"`              // Round-trip time in milliseconds
+	Uncertainty float64 `json:"uncertainty"`      // Error bound of the offset in milliseconds
+	Skewed      bool    `json:"skewed"`           // Whether the offset exceeds the threshold
+	Detail      string  `json:"detail,omitempty"` // Extra information such as the NTP stratum
+	Error       string  `json:"error,omitempty"`  // Error when the method failed
+}
+
+// SummaryMessage reports the overall verdict for the host
+type SummaryMessage struct {
+	Type      string  `json:"type"`      // Message type ("summary")
+	Host      string  `json:"host"`      // Host that was checked
+	Offset    float64 `json:"offset"`    // Offset of the most precise successful method in milliseconds
+	Method    string  `json:"method"`    // Method the offset comes from
+	Threshold int     `json:"threshold"` // Threshold in milliseconds
+	Skewed    bool    `json:"skewed"`    // Whether any method found the clock skewed
+}
+
+// ClockOptions contains the resolved clock skew options
+type ClockOptions struct {
+	Host      string
+	Methods   []string
+	URL       string
+	Threshold int
+	Timeout   int
+}
+
+// resolveClockOptions converts ClockMessage to ClockOptions with defaults
+func resolveClockOptions(msg *ClockMessage) (ClockOptions, error) {
+	opts := ClockOptions{
+		Host:      strings.TrimSpace(msg.Host),
+		Methods:   msg.Methods,
+		URL:       tool.GetOrDefault(msg.URL, ""),
+		Threshold: tool.GetOrDefault(msg.Threshold, defaultThreshold),
+		Timeout:   tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = defaultMethods
+	}
+	for _, method := range opts.Methods {
+		if method != MethodHTTP && method != MethodNTP && method != MethodICMP {
+			return opts, fmt.Errorf("unsupported method %q", method)
+		}
+	}
+	if opts.URL == "" {
+		opts.URL = "https://" + opts.Host + "/"
+	} else if u, err := url.Parse(opts.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return opts, fmt.Errorf("invalid url %q", opts.URL)
+	}
+	if opts.Threshold <= 0 {
+		return opts, fmt.Errorf("threshold must be positive")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// checkHTTP compares the Date header to the midpoint of the request. Dates
+// have a resolution of one second, which is added to the uncertainty.
+func checkHTTP(ctx context.Context, opts ClockOptions, result *OffsetMessage) error {
+	result.Server = opts.URL
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, opts.URL, nil)
+	if err != nil {
+		return err
+	}
+	tool.Identify(req)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	end := time.Now()
+	resp.Body.Close()
+
+	header := resp.Header.Get("Date")
+	if header == "" {
+		return fmt.Errorf("response has no Date header")
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return fmt.Errorf("invalid Date header %q", header)
+	}
+	rtt := end.Sub(start)
+	// The Date header is truncated to the second, so on average it lags the
+	// real server time by half a second
+	remote := date.Add(500 * time.Millisecond)
+	result.Offset = milliseconds(remote.Sub(start.Add(rtt / 2)))
+	result.RTT = milliseconds(rtt)
+	result.Uncertainty = milliseconds(rtt/2) + 500
+	result.Detail = header
+	return nil
+}
+
+// ntpTime decodes a 64 bit NTP timestamp
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:])
+	fraction := binary.BigEndian.Uint32(b[4:])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+// checkNTP queries the host with SNTP (RFC 4330)
+func checkNTP(opts ClockOptions, result *OffsetMessage) error {
+	addr := net.JoinHostPort(strings.Trim(opts.Host, "[]"), ntpPort)
+	result.Server = addr
+	conn, err := net.DialTimeout("udp", addr, time.Duration(opts.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Duration(opts.Timeout) * time.Second))
+
+	request := make([]byte, ntpPacketSize)
+	request[0] = 0<<6 | 4<<3 | 3 // LI 0, version 4, client mode
+	t1 := time.Now()
+	seconds := uint64(t1.Unix() + ntpEpochOffset)
+	fraction := uint64(t1.Nanosecond()) << 32 / 1e9
+	binary.BigEndian.PutUint64(request[40:], seconds<<32|fraction)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	response := make([]byte, ntpPacketSize)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return err
+		}
+		t4 := time.Now()
+		if n < ntpPacketSize || response[0]&0x7 != 4 {
+			continue
+		}
+		// The originate timestamp must echo our transmit timestamp
+		if binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+			continue
+		}
+		stratum := response[1]
+		if stratum == 0 {
+			return fmt.Errorf("kiss-of-death response %q", strings.TrimRight(string(response[12:16]), "\x00"))
+		}
+		t2 := ntpTime(response[32:])
+		t3 := ntpTime(response[40:])
+
+		rtt := t4.Sub(t1) - t3.Sub(t2)
+		result.Offset = milliseconds((t2.Sub(t1) + t3.Sub(t4)) / 2)
+		result.RTT = milliseconds(rtt)
+		result.Uncertainty = milliseconds(rtt / 2)
+		result.Detail = fmt.Sprintf("stratum %d", stratum)
+		return nil
+	}
+}
+
+// checkICMP sends an ICMP timestamp request. Timestamps have millisecond
+// resolution, which is added to the uncertainty.
+func checkICMP(opts ClockOptions, result *OffsetMessage) error {
+	ipAddr, err := net.ResolveIPAddr("ip4", opts.Host)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", opts.Host, err)
+	}
+	result.Server = ipAddr.IP.String()
+
+	conn, err := probe.ListenRawICMP()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reply, err := conn.Timestamp(ipAddr.IP, 0, time.Duration(opts.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	if reply.NonStandard {
+		return fmt.Errorf("host reports a non-standard timestamp")
+	}
+	result.Offset = milliseconds(reply.Offset())
+	result.RTT = milliseconds(reply.RTT)
+	result.Uncertainty = milliseconds(reply.RTT/2) + 1
+	return nil
+}
+
+// precision orders methods from most to least precise
+var precision = map[string]int{MethodNTP: 0, MethodICMP: 1, MethodHTTP: 2}
+
+// Handler handles WebSocket clock skew requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "clockskew")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg ClockMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading clockskew message: %v", err)
+		return
+	}
+
+	opts, err := resolveClockOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid clockskew options: %v", err)
+		return
+	}
+
+	summary := SummaryMessage{Type: "summary", Host: opts.Host, Threshold: opts.Threshold}
+	var results []OffsetMessage
+	for _, method := range opts.Methods {
+		result := OffsetMessage{Type: "offset", Method: method}
+		switch method {
+		case MethodHTTP:
+			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+			err = checkHTTP(ctx, opts, &result)
+			cancel()
+		case MethodNTP:
+			err = checkNTP(opts, &result)
+		case MethodICMP:
+			err = checkICMP(opts, &result)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+			// Only flag offsets that exceed the threshold beyond doubt
+			result.Skewed = math.Abs(result.Offset)-result.Uncertainty > float64(opts.Threshold)
+			summary.Skewed = summary.Skewed || result.Skewed
+			results = append(results, result)
+		}
+
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send offset: %v", err)
+			return
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return precision[results[i].Method] < precision[results[j].Method]
+	})
+	if len(results) > 0 {
+		summary.Offset = results[0].Offset
+		summary.Method = results[0].Method
+	}
+
+	log.Printf("Clock of %s offset %.3f ms (%s), skewed=%t", opts.Host, summary.Offset, summary.Method, summary.Skewed)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package conformance checks that WebSocket tools follow their message
+// protocol. A Case sends one request and matches the sequence of message
+// types the tool answers with, so the harness runs unchanged against the real
+// server, the mock server or any other implementation of the API.
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultTimeout bounds a case without a timeout of its own
+const defaultTimeout = 30 * time.Second
+
+// Case is one request to a tool and the message sequence it must produce
+type Case struct {
+	Name    string        // Name of the case
+	Path    string        // Route of the tool, e.g. /ping
+	Request any           // First message sent to the tool
+	Expect  string        // Regexp the space separated message types must match in full, e.g. "session( pong){2} summary"
+	After   string        // Message type after whose first arrival Control is sent
+	Control any           // Optional message sent once After was received
+	Timeout time.Duration // Time the case may take, 30 seconds when zero
+}
+
+// Frame is a message received from a tool
+type Frame struct {
+	Type string          // Value of the type field
+	Data json.RawMessage // The message as received
+}
+
+// Run sends the request of c to the server at baseURL, e.g.
+// ws://localhost:3000, and returns the frames received until the server
+// closes the connection. Every frame must be a JSON object with a type.
+func Run(baseURL string, c Case) ([]Frame, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: timeout}
+	conn, _, err := dialer.Dial(strings.TrimSuffix(baseURL, "/")+c.Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", c.Path, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	if err := conn.WriteJSON(c.Request); err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	var frames []Frame
+	sent := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, fmt.Errorf("error reading frame %d: %w", len(frames), err)
+		}
+		var msg struct {
+			Type *string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == nil {
+			return frames, fmt.Errorf("frame %d is not a JSON object with a type: %s", len(frames), data)
+		}
+		frames = append(frames, Frame{Type: *msg.Type, Data: data})
+
+		if c.Control != nil && !sent && *msg.Type == c.After {
+			if err := conn.WriteJSON(c.Control); err != nil {
+				return frames, fmt.Errorf("error sending control message: %w", err)
+			}
+			sent = true
+		}
+	}
+}
+
+// Types returns the message types of frames separated by spaces
+func Types(frames []Frame) string {
+	types := make([]string, len(frames))
+	for i, frame := range frames {
+		types[i] = frame.Type
+	}
+	return strings.Join(types, " ")
+}
+
+// Check runs c against the server at baseURL and verifies the sequence of
+// message types matches c.Expect
+func Check(baseURL string, c Case) error {
+	frames, err := Run(baseURL, c)
+	if err != nil {
+		return err
+	}
+	return Match(c, frames)
+}
+
+// Match verifies the sequence of message types of frames matches c.Expect
+func Match(c Case, frames []Frame) error {
+	expect, err := regexp.Compile("^(?:" + c.Expect + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid expectation %q: %w", c.Expect, err)
+	}
+	if types := Types(frames); !expect.MatchString(types) {
+		return fmt.Errorf("message sequence %q does not match %q", types, c.Expect)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package conformance
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cksidharthan/net-tools/pkg"
+	"github.com/cksidharthan/net-tools/pkg/clockskew"
+	"github.com/cksidharthan/net-tools/pkg/dns"
+	"github.com/cksidharthan/net-tools/pkg/lbdist"
+	"github.com/cksidharthan/net-tools/pkg/mailsec"
+	"github.com/cksidharthan/net-tools/pkg/pac"
+	"github.com/cksidharthan/net-tools/pkg/script"
+	"github.com/cksidharthan/net-tools/pkg/vcr"
+	"github.com/cksidharthan/net-tools/pkg/vhost"
+	"github.com/cksidharthan/net-tools/pkg/wsdebug"
+	"github.com/gorilla/websocket"
+)
+
+// newToolServer serves every tool on its usual route
+func newToolServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", pkg.PingHandler)
+	mux.HandleFunc("/wsdebug", wsdebug.Handler)
+	mux.HandleFunc("/pac", pac.Handler)
+	mux.HandleFunc("/dns", dns.Handler)
+	mux.HandleFunc("/axfr", dns.TransferHandler)
+	mux.HandleFunc("/anycast", dns.AnycastHandler)
+	mux.HandleFunc("/mailsec", mailsec.Handler)
+	mux.HandleFunc("/vhost", vhost.Handler)
+	mux.HandleFunc("/lb", lbdist.Handler)
+	mux.HandleFunc("/clockskew", clockskew.Handler)
+	mux.HandleFunc("/script", script.Handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTargets starts the local HTTP and WebSocket echo servers probed by the
+// cases and returns their URLs
+func newTargets(t *testing.T) (httpURL, wsURL string) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}))
+	t.Cleanup(web.Close)
+
+	upgrader := websocket.Upgrader{}
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil || conn.WriteMessage(kind, data) != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(echo.Close)
+	return web.URL, "ws" + strings.TrimPrefix(echo.URL, "http")
+}
+
+// closedUDPPort returns a local UDP address nothing listens on
+func closedUDPPort(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func TestToolConformance(t *testing.T) {
+	server := newToolServer(t)
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	httpURL, wsURL := newTargets(t)
+	httpHost := strings.TrimPrefix(httpURL, "http://")
+	_, port, _ := net.SplitHostPort(httpHost)
+	httpPort, _ := strconv.Atoi(port)
+
+	cases := []Case{
+		{
+			Name:    "ping",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpURL, "count": 2},
+			Expect:  "session pong pong summary",
+		},
+		{
+			Name:    "ping text",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpURL, "count": 1, "format": "text"},
+			Expect:  "session text pong text summary text text text",
+		},
+		{
+			Name:    "ping tcp",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpHost, "protocol": "tcp", "count": 1},
+			Expect:  "session pong summary",
+		},
+		{
+			Name:    "ping targets",
+			Path:    "/ping",
+			Request: map[string]any{"targets": []string{httpURL, httpURL + "/b"}, "count": 1},
+			Expect:  "session( pong| summary){4}",
+		},
+		{
+			Name:    "ping stop",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpURL},
+			After:   "pong",
+			Control: map[string]any{"action": "stop"},
+			Expect:  "session pong summary",
+		},
+		{
+			Name:    "ping update",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpURL},
+			After:   "pong",
+			Control: map[string]any{"action": "update", "count": 1},
+			Expect:  "session pong control summary",
+		},
+		{
+			Name:    "ping invalid options",
+			Path:    "/ping",
+			Request: map[string]any{"address": httpURL, "count": -1},
+			Expect:  "session",
+		},
+		{
+			Name:    "ping sweep",
+			Path:    "/ping",
+			Request: map[string]any{"address": "127.0.0.0/30", "protocol": "tcp", "port": httpPort, "timeout": 1},
+			Expect:  "session host host sweep",
+		},
+		{
+			Name:    "wsdebug",
+			Path:    "/wsdebug",
+			Request: map[string]any{"url": wsURL},
+			Expect:  "session( step)+ result",
+		},
+		{
+			Name:    "pac",
+			Path:    "/pac",
+			Request: map[string]any{"urls": []string{"http://a.test/", "http://b.test/"}, "script": `function FindProxyForURL(url, host) { return "DIRECT"; }`},
+			Expect:  "session fetch result result",
+		},
+		{
+			Name:    "dns",
+			Path:    "/dns",
+			Request: map[string]any{"name": "conformance.invalid", "timeout": 1},
+			Expect:  "session answer",
+		},
+		{
+			Name:    "axfr",
+			Path:    "/axfr",
+			Request: map[string]any{"zone": "conformance.invalid", "nameservers": []string{"127.0.0.1"}, "timeout": 1},
+			Expect:  "session( transfer)+ summary",
+		},
+		{
+			Name:    "anycast",
+			Path:    "/anycast",
+			Request: map[string]any{"targets": []string{closedUDPPort(t)}, "urls": []string{httpURL}, "timeout": 1},
+			Expect:  "session pop pop",
+		},
+		{
+			Name:    "mailsec",
+			Path:    "/mailsec",
+			Request: map[string]any{"domain": "conformance.invalid", "timeout": 1},
+			Expect:  "session( check)+ summary",
+		},
+		{
+			Name:    "vhost",
+			Path:    "/vhost",
+			Request: map[string]any{"address": httpHost, "names": []string{"a.test", "b.test"}, "tls": false},
+			Expect:  "session host host summary",
+		},
+		{
+			Name:    "lb",
+			Path:    "/lb",
+			Request: map[string]any{"url": httpURL, "count": 3, "wait": 0},
+			Expect:  "session( response){3} summary",
+		},
+		{
+			Name:    "clockskew",
+			Path:    "/clockskew",
+			Request: map[string]any{"host": "127.0.0.1", "methods": []string{"http"}, "url": httpURL},
+			Expect:  "session offset summary",
+		},
+		{
+			Name:    "script",
+			Path:    "/script",
+			Request: map[string]any{"script": `print("checking")`},
+			Expect:  "session log done",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+			if err := Check(baseURL, c); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestRecordedUpstreams runs tools that talk to Internet upstreams from the
+// cassette testdata/cassettes/upstreams.json. Run it with VCR_RECORD=1 to
+// record the cassette again.
+func TestRecordedUpstreams(t *testing.T) {
+	vcr.Start(t, "upstreams")
+	server := newToolServer(t)
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	cases := []struct {
+		Case
+		contains []string // Strings the received messages must contain
+	}{
+		{
+			Case: Case{
+				Name:    "dns",
+				Path:    "/dns",
+				Request: map[string]any{"name": "example.com", "type": "MX"},
+				Expect:  "session answer",
+			},
+			contains: []string{`"data":"10 mail.example.com."`},
+		},
+		{
+			Case: Case{
+				Name: "pac",
+				Path: "/pac",
+				Request: map[string]any{
+					"pac_url": "http://pac.example.com/proxy.pac",
+					"urls":    []string{"http://intranet.example.com/", "http://www.example.com/"},
+				},
+				Expect: "session fetch result result",
+			},
+			contains: []string{`"status":200`, `"result":"DIRECT"`, `"result":"PROXY proxy.example.com:3128; DIRECT"`},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			frames, err := Run(baseURL, c.Case)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Match(c.Case, frames); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(frames)
+			for _, want := range c.contains {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("messages do not contain %s: %s", want, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for console options
+const (
+	defaultTimeout = 5   // Seconds to wait for the connection
+	defaultIdle    = 300 // Seconds without data in either direction before the session ends
+	maxIdle        = 3600
+	readBuffer     = 4096 // Largest chunk of received data sent in one message
+)
+
+// Reasons a console session ended
+const (
+	ClosedByRemote = "remote" // The remote host closed the connection
+	ClosedByClient = "client" // The WebSocket client went away
+	ClosedByIdle   = "idle"   // Nothing was sent or received for the idle timeout
+	ClosedByError  = "error"  // Reading from the remote host failed
+)
+
+// Encodings of relayed data
+const (
+	EncodingText   = "text"   // Data is UTF-8 text
+	EncodingBase64 = "base64" // Data is base64, for bytes that are not valid UTF-8
+)
+
+// ConsoleMessage represents the incoming console request
+type ConsoleMessage struct {
+	// Required
+	Host string `json:"host"` // Host to connect to
+	Port int    `json:"port"` // TCP port to connect to
+
+	// Optional parameters
+	Family  *string `json:"family,omitempty"`  // Address family to use ("ip4" or "ip6")
+	Timeout *int    `json:"timeout,omitempty"` // Seconds to wait for the connection
+	Idle    *int    `json:"idle,omitempty"`    // Seconds without data in either direction before the session ends
+	CRLF    *bool   `json:"crlf,omitempty"`    // Send bare line feeds of text input as CRLF, as telnet does
+}
+
+// InputMessage carries data typed by the client. Messages are sent after
+// the connection is announced.
+type InputMessage struct {
+	Data     string `json:"data,omitempty"`     // Data to send to the remote host
+	Encoding string `json:"encoding,omitempty"` // "text" (default) or "base64"
+	Close    bool   `json:"close,omitempty"`    // Close the sending half of the connection, e.g. after an HTTP/1.0 request
+}
+
+// ConnectedMessage announces the connection to the remote host
+type ConnectedMessage struct {
+	Type    string  `json:"type"`    // Message type ("connected")
+	Host    string  `json:"host"`    // Host as requested
+	Address string  `json:"address"` // Address connected to
+	Latency float64 `json:"latency"` // Handshake time in milliseconds
+}
+
+// DataMessage carries data received from the remote host
+type DataMessage struct {
+	Type     string `json:"type"`     // Message type ("data")
+	Data     string `json:"data"`     // Received bytes
+	Encoding string `json:"encoding"` // "text" or "base64"
+}
+
+// SummaryMessage reports the end of a console session
+type SummaryMessage struct {
+	Type     string  `json:"type"`                // Message type ("summary")
+	Address  string  `json:"address,omitempty"`   // Address that was connected to
+	Sent     int64   `json:"sent"`                // Bytes sent to the remote host
+	Received int64   `json:"received"`            // Bytes received from the remote host
+	Duration float64 `json:"duration"`            // Milliseconds the connection was open
+	ClosedBy string  `json:"closed_by,omitempty"` // Why the session ended (remote, client, idle or error)
+	Error    string  `json:"error,omitempty"`     // Error that ended the session
+}
+
+// ConsoleOptions contains the resolved console options
+type ConsoleOptions struct {
+	Host    string
+	Port    int
+	Family  string
+	Timeout time.Duration
+	Idle    time.Duration
+	CRLF    bool
+}
+
+// rule is one allowlist entry: a host name, optionally with a leading
+// wildcard label, or a network, and a port range
+type rule struct {
+	name    string
+	prefix  netip.Prefix
+	minPort int
+	maxPort int
+}
+
+// Console relays raw TCP sessions to allowlisted destinations
+type Console struct {
+	rules []rule
+}
+
+// New parses a comma separated allowlist of destinations given as
+// host:ports, where host is a name such as mail.example.com or
+// *.example.com, an address or a network, and ports is a port, a range such
+// as 8000-8080 or *
+func New(spec string) (*Console, error) {
+	c := &Console{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid console destination %q: want host:ports", entry)
+		}
+		host, ports := strings.Trim(entry[:i], "[]"), entry[i+1:]
+
+		r := rule{minPort: 1, maxPort: 65535}
+		if ports != "*" {
+			lo, hi, isRange := strings.Cut(ports, "-")
+			if !isRange {
+				hi = lo
+			}
+			var loErr, hiErr error
+			r.minPort, loErr = strconv.Atoi(lo)
+			r.maxPort, hiErr = strconv.Atoi(hi)
+			if loErr != nil || hiErr != nil || r.minPort < 1 || r.maxPort > 65535 || r.minPort > r.maxPort {
+				return nil, fmt.Errorf("invalid console destination %q: ports must be a port, a range or *", entry)
+			}
+		}
+
+		if prefix, err := netip.ParsePrefix(host); err == nil {
+			r.prefix = prefix.Masked()
+		} else if addr, err := netip.ParseAddr(host); err == nil {
+			r.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		} else if name := strings.ToLower(strings.TrimSuffix(host, ".")); name != "" && !strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			r.name = name
+		} else {
+			return nil, fmt.Errorf("invalid console destination %q: host must be a name, address or network", entry)
+		}
+		c.rules = append(c.rules, r)
+	}
+	if len(c.rules) == 0 {
+		return nil, errors.New("console allowlist is empty")
+	}
+	return c, nil
+}
+
+// allowsName reports whether a rule for host names allows the host and port
+func (c *Console) allowsName(host string, port int) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range c.rules {
+		if r.name == "" || port < r.minPort || port > r.maxPort {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(r.name, "*."); ok && strings.HasSuffix(host, "."+suffix) || r.name == host {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAddr reports whether a rule for networks allows the address and port
+func (c *Console) allowsAddr(addr netip.Addr, port int) bool {
+	addr = addr.Unmap()
+	for _, r := range c.rules {
+		if r.prefix.IsValid() && r.prefix.Contains(addr) && port >= r.minPort && port <= r.maxPort {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveConsoleOptions converts ConsoleMessage to ConsoleOptions with defaults
+func resolveConsoleOptions(msg *ConsoleMessage) (ConsoleOptions, error) {
+	opts := ConsoleOptions{
+		Host:    strings.Trim(strings.TrimSpace(msg.Host), "[]"),
+		Port:    msg.Port,
+		Family:  tool.GetOrDefault(msg.Family, "ip"),
+		Timeout: time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+		Idle:    time.Duration(tool.GetOrDefault(msg.Idle, defaultIdle)) * time.Second,
+		CRLF:    tool.GetOrDefault(msg.CRLF, true),
+	}
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if opts.Port <= 0 || opts.Port > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535")
+	}
+	if opts.Family != "ip" && opts.Family != "ip4" && opts.Family != "ip6" {
+		return opts, fmt.Errorf("family must be ip4 or ip6")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if opts.Idle <= 0 || opts.Idle > maxIdle*time.Second {
+		return opts, fmt.Errorf("idle must be between 1 and %d seconds", maxIdle)
+	}
+	return opts, nil
+}
+
+// destination resolves the host and picks the first address the allowlist
+// allows. The checked address is dialled, so the host cannot resolve to
+// another address in between.
+func (c *Console) destination(ctx context.Context, opts ConsoleOptions) (netip.Addr, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, opts.Family, opts.Host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("error resolving %s: %w", opts.Host, err)
+	}
+	byName := c.allowsName(opts.Host, opts.Port)
+	for _, addr := range addrs {
+		if byName || c.allowsAddr(addr, opts.Port) {
+			return addr.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%s port %d is not in the console allowlist", opts.Host, opts.Port)
+}
+
+// decodeInput returns the bytes of an input message
+func decodeInput(input InputMessage, crlf bool) ([]byte, error) {
+	switch input.Encoding {
+	case "", EncodingText:
+		data := []byte(input.Data)
+		if crlf {
+			data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+		}
+		return data, nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(input.Data)
+	}
+	return nil, fmt.Errorf("unsupported encoding %q, want text or base64", input.Encoding)
+}
+
+// encodeData wraps received bytes in a DataMessage, as text when they are
+// valid UTF-8
+func encodeData(data []byte) DataMessage {
+	if utf8.Valid(data) {
+		return DataMessage{Type: "data", Data: string(data), Encoding: EncodingText}
+	}
+	return DataMessage{Type: "data", Data: base64.StdEncoding.EncodeToString(data), Encoding: EncodingBase64}
+}
+
+// Handler handles WebSocket console sessions. After the connection is
+// announced, input messages are sent to the remote host and received data
+// is relayed back until either side closes or the session idles.
+func (c *Console) Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "console")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg ConsoleMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading console message: %v", err)
+		return
+	}
+
+	opts, err := resolveConsoleOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid console options: %v", err)
+		return
+	}
+
+	summary := SummaryMessage{Type: "summary"}
+	addr, err := c.destination(r.Context(), opts)
+	if err != nil {
+		summary.Error = err.Error()
+		session.WriteJSON(summary)
+		return
+	}
+	summary.Address = net.JoinHostPort(addr.String(), strconv.Itoa(opts.Port))
+
+	session.CountProbe()
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", summary.Address, opts.Timeout)
+	if err != nil {
+		summary.Error = err.Error()
+		session.WriteJSON(summary)
+		return
+	}
+	defer conn.Close()
+	log.Printf("Console session %s from %s connected to %s", session.ID, r.RemoteAddr, summary.Address)
+
+	connected := ConnectedMessage{
+		Type:    "connected",
+		Host:    opts.Host,
+		Address: summary.Address,
+		Latency: float64(time.Since(start).Microseconds()) / 1000.0,
+	}
+	if err := session.WriteJSON(connected); err != nil {
+		log.Printf("Failed to send connected message: %v", err)
+		return
+	}
+
+	start = time.Now()
+	var idled, clientGone atomic.Bool
+	idle := time.AfterFunc(opts.Idle, func() {
+		idled.Store(true)
+		conn.Close()
+	})
+	defer idle.Stop()
+
+	var sent atomic.Int64
+	session.Go(func() {
+		for {
+			var input InputMessage
+			err := session.ReadJSON(&input)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				continue
+			}
+			if err != nil {
+				clientGone.Store(true)
+				conn.Close()
+				return
+			}
+			data, err := decodeInput(input, opts.CRLF)
+			if err != nil {
+				log.Printf("Invalid console input: %v", err)
+				continue
+			}
+			if len(data) > 0 {
+				idle.Reset(opts.Idle)
+				n, _ := conn.Write(data)
+				sent.Add(int64(n))
+			}
+			if input.Close {
+				conn.(*net.TCPConn).CloseWrite()
+			}
+		}
+	})
+
+	buf := make([]byte, readBuffer)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			idle.Reset(opts.Idle)
+			summary.Received += int64(n)
+			if err := session.WriteJSON(encodeData(buf[:n])); err != nil {
+				clientGone.Store(true)
+				break
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if !clientGone.Load() && !idled.Load() && !errors.Is(err, io.EOF) {
+			summary.Error = err.Error()
+		}
+		break
+	}
+	switch {
+	case clientGone.Load():
+		summary.ClosedBy = ClosedByClient
+	case idled.Load():
+		summary.ClosedBy = ClosedByIdle
+	case summary.Error != "":
+		summary.ClosedBy = ClosedByError
+	default:
+		summary.ClosedBy = ClosedByRemote
+	}
+
+	summary.Sent = sent.Load()
+	summary.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	log.Printf("Console session %s to %s closed by %s after %d bytes sent and %d received", session.ID, summary.Address, summary.ClosedBy, summary.Sent, summary.Received)
+	if err := session.WriteJSON(summary); err != nil && !clientGone.Load() {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
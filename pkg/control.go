@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Control actions accepted while a ping runs
+const (
+	actionStop   = "stop"   // End the run and send the summary
+	actionUpdate = "update" // Change the interval or count of the run
+)
+
+// errStopped is the cause of a ping context canceled by a stop action
+var errStopped = errors.New("stopped by client")
+
+// ControlMessage changes a running ping. It may be sent at any time after
+// the PingMessage.
+type ControlMessage struct {
+	Action   string         `json:"action"`          // "stop" or "update"
+	Wait     *tool.Duration `json:"wait,omitempty"`  // New interval between pings (-i), for updates
+	Interval *tool.Duration `json:"i,omitempty"`     // Alias of wait, named after the -i flag
+	Count    *int           `json:"count,omitempty"` // New number of pings to send (-c), for updates
+}
+
+// ControlAckMessage acknowledges an update, or reports why a control
+// message was rejected
+type ControlAckMessage struct {
+	Type   string        `json:"type"`            // Message type ("control")
+	Action string        `json:"action"`          // Action that was acknowledged
+	Wait   tool.Duration `json:"wait"`            // Interval in seconds in effect after the action
+	Count  int           `json:"count"`           // Count in effect after the action
+	Error  string        `json:"error,omitempty"` // Why the action was rejected
+}
+
+// readControls reads control messages until the client disconnects. Stop
+// actions and read errors cancel the ping context, updates are passed to the
+// handler, which acknowledges them.
+func readControls(ctx context.Context, session *tool.Session, cancel context.CancelCauseFunc, updates chan<- ControlMessage) {
+	for {
+		var msg ControlMessage
+		err := session.ReadJSON(&msg)
+		if errors.Is(err, tool.ErrInvalidMessage) {
+			log.Printf("Ignoring control message: %v", err)
+			continue
+		}
+		if err != nil {
+			cancel(fmt.Errorf("client disconnected: %w", err))
+			return
+		}
+		if msg.Action == actionStop {
+			cancel(errStopped)
+			return
+		}
+		select {
+		case updates <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pingControl holds the settings of a running ping that control messages
+// change, shared by the runs of every target of the session
+type pingControl struct {
+	mu      sync.Mutex
+	wait    time.Duration
+	count   int
+	shared  bool
+	changed chan struct{} // Closed and replaced on every change
+}
+
+// newPingControl returns the control settings of a ping started with opts
+func newPingControl(opts PingOptions) *pingControl {
+	return &pingControl{wait: opts.Wait, count: opts.Count, shared: opts.IsShared, changed: make(chan struct{})}
+}
+
+// settings returns the current interval and count, and a channel closed
+// when either changes
+func (c *pingControl) settings() (wait time.Duration, count int, changed <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wait, c.count, c.changed
+}
+
+// apply applies an update and returns its acknowledgement. Shared streams
+// probe at the interval of the first subscriber, so their interval cannot
+// be changed.
+func (c *pingControl) apply(msg ControlMessage) ControlAckMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.Wait == nil {
+		msg.Wait = msg.Interval
+	}
+	ack := ControlAckMessage{Type: "control", Action: msg.Action}
+	switch {
+	case msg.Action != actionUpdate:
+		ack.Error = fmt.Sprintf("unknown action %q", msg.Action)
+	case msg.Wait != nil && time.Duration(*msg.Wait) < minWait:
+		ack.Error = fmt.Sprintf("wait must be at least %s", minWait)
+	case msg.Wait != nil && c.shared:
+		ack.Error = "wait cannot be changed on a shared probe stream"
+	case msg.Count != nil && *msg.Count < 0:
+		ack.Error = "count cannot be negative"
+	case msg.Count != nil && !tool.Demo.AllowCount(*msg.Count):
+		ack.Error = fmt.Sprintf("count must be between 1 and %d in demo mode", tool.Demo.Limits().Count)
+	default:
+		c.wait = time.Duration(tool.GetOrDefault(msg.Wait, tool.Duration(c.wait)))
+		c.count = tool.GetOrDefault(msg.Count, c.count)
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	ack.Wait, ack.Count = tool.Duration(c.wait), c.count
+	return ack
+}
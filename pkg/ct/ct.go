@@ -0,0 +1,336 @@
+package ct
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"golang.org/x/net/idna"
+)
+
+// Default values and limits of CT lookups
+const (
+	defaultTimeout  = 60 // crt.sh often takes tens of seconds for busy domains
+	maxTimeout      = 300
+	maxJSONResults  = 10000 // Certificates buffered for a JSON report; ndjson streams any number
+	formatJSON      = "json"
+	formatNDJSON    = "ndjson"
+	dateLayout      = "2006-01-02"
+	crtshTimeLayout = "2006-01-02T15:04:05.999999999"
+)
+
+// crtshURL is the crt.sh endpoint certificates are searched on
+var crtshURL = "https://crt.sh/"
+
+// ctClient queries crt.sh. Timeouts come from the request context, as
+// responses are streamed for as long as they take.
+var ctClient = &http.Client{}
+
+// crtshEntry is one certificate in a crt.sh JSON response
+type crtshEntry struct {
+	ID             int64  `json:"id"`
+	IssuerCAID     int    `json:"issuer_ca_id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"` // Names of the certificate, one per line
+	EntryTimestamp string `json:"entry_timestamp"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	SerialNumber   string `json:"serial_number"`
+}
+
+// Certificate is a certificate logged for a domain
+type Certificate struct {
+	Type       string    `json:"type"`        // Message type ("certificate")
+	ID         int64     `json:"id"`          // crt.sh ID, shown at https://crt.sh/?id=<id>
+	Logged     time.Time `json:"logged"`      // When the certificate was first logged
+	NotBefore  time.Time `json:"not_before"`  // Start of validity, about when it was issued
+	NotAfter   time.Time `json:"not_after"`   // End of validity
+	Expired    bool      `json:"expired"`     // Whether not_after has passed
+	CommonName string    `json:"common_name"` // Subject common name
+	Names      []string  `json:"names"`       // Subject alternative names and common name
+	Issuer     string    `json:"issuer"`      // Issuer distinguished name
+	IssuerID   int       `json:"issuer_id"`   // crt.sh ID of the issuing CA
+	Serial     string    `json:"serial"`      // Serial number in hex
+}
+
+// Summary describes the certificates found for a domain
+type Summary struct {
+	Type      string         `json:"type"`            // Message type ("summary")
+	Domain    string         `json:"domain"`          // Domain searched
+	Count     int            `json:"count"`           // Certificates matching the filters
+	Issuers   map[string]int `json:"issuers"`         // Certificates per issuer
+	Truncated bool           `json:"truncated"`       // Whether results beyond the limit were left out
+	Error     string         `json:"error,omitempty"` // Error that ended the search early
+	Duration  float64        `json:"duration"`        // Search time in milliseconds
+	Source    string         `json:"source"`          // Log search service queried
+}
+
+// Report is the JSON answer of a search
+type Report struct {
+	Summary      Summary       `json:"summary"`      // What was found
+	Certificates []Certificate `json:"certificates"` // Matching certificates, newest first
+}
+
+// SearchOptions contains the resolved options of a search
+type SearchOptions struct {
+	Domain     string
+	Subdomains bool      // Include certificates of names below the domain
+	Expired    bool      // Include expired certificates
+	Since      time.Time // Only certificates valid from this day on, when set
+	Until      time.Time // Only certificates valid from before this day, when set
+	Limit      int       // Most certificates returned, 0 for no limit
+	Timeout    time.Duration
+}
+
+// match reports whether a certificate passes the date and expiry filters
+func (opts SearchOptions) match(cert Certificate) bool {
+	switch {
+	case !opts.Expired && cert.Expired:
+		return false
+	case !opts.Since.IsZero() && cert.NotBefore.Before(opts.Since):
+		return false
+	case !opts.Until.IsZero() && !cert.NotBefore.Before(opts.Until):
+		return false
+	}
+	return true
+}
+
+// parseTime parses a crt.sh timestamp, which is UTC without a zone
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(crtshTimeLayout, value)
+	return t
+}
+
+// certificate converts a crt.sh entry
+func (e crtshEntry) certificate() Certificate {
+	cert := Certificate{
+		Type:       "certificate",
+		ID:         e.ID,
+		Logged:     parseTime(e.EntryTimestamp),
+		NotBefore:  parseTime(e.NotBefore),
+		NotAfter:   parseTime(e.NotAfter),
+		CommonName: e.CommonName,
+		Names:      []string{},
+		Issuer:     e.IssuerName,
+		IssuerID:   e.IssuerCAID,
+		Serial:     e.SerialNumber,
+	}
+	cert.Expired = cert.NotAfter.Before(time.Now())
+	for _, name := range strings.Split(e.NameValue, "\n") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			cert.Names = append(cert.Names, name)
+		}
+	}
+	return cert
+}
+
+// search streams the certificates crt.sh has logged for an identity to
+// emit as they are decoded, without holding the whole response in memory
+func search(ctx context.Context, identity string, expired bool, emit func(crtshEntry) error) error {
+	query := url.Values{"q": {identity}, "output": {"json"}, "deduplicate": {"Y"}}
+	if !expired {
+		query.Set("exclude", "expired")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, crtshURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	tool.Identify(req)
+	resp, err := ctClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("crt.sh returned %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return errors.New("crt.sh returned an unexpected response")
+	}
+	for decoder.More() {
+		var entry crtshEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("error decoding crt.sh response: %w", err)
+		}
+		if err := emit(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errLimit stops a search once the limit is reached
+var errLimit = errors.New("limit reached")
+
+// Search finds the certificates logged for the domain of opts, calling emit
+// with each that passes the filters in the order crt.sh returns them, and
+// returns the summary. Subdomain searches also query the domain itself, as
+// wildcard identities do not match it.
+func Search(ctx context.Context, opts SearchOptions, emit func(Certificate) error) Summary {
+	summary := Summary{Type: "summary", Domain: opts.Domain, Issuers: make(map[string]int), Source: crtshURL}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	identities := []string{opts.Domain}
+	if opts.Subdomains {
+		identities = append(identities, "%."+opts.Domain)
+	}
+	seen := make(map[int64]bool)
+	for _, identity := range identities {
+		err := search(ctx, identity, opts.Expired, func(entry crtshEntry) error {
+			if seen[entry.ID] {
+				return nil
+			}
+			seen[entry.ID] = true
+			cert := entry.certificate()
+			if !opts.match(cert) {
+				return nil
+			}
+			if opts.Limit > 0 && summary.Count >= opts.Limit {
+				summary.Truncated = true
+				return errLimit
+			}
+			summary.Count++
+			summary.Issuers[cert.Issuer]++
+			return emit(cert)
+		})
+		if errors.Is(err, errLimit) {
+			break
+		}
+		if err != nil {
+			summary.Error = err.Error()
+			break
+		}
+	}
+	summary.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	return summary
+}
+
+// parseDate parses a day such as 2024-01-31
+func parseDate(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date such as 2024-01-31", name)
+	}
+	return t, nil
+}
+
+// parseBool parses an optional boolean query parameter
+func parseBool(params url.Values, name string, fallback bool) (bool, error) {
+	value := params.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return b, nil
+}
+
+// resolveSearchOptions reads the options of a search from query parameters
+func resolveSearchOptions(params url.Values) (SearchOptions, error) {
+	opts := SearchOptions{Timeout: defaultTimeout * time.Second}
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(params.Get("domain")), "."))
+	if err != nil || !strings.Contains(domain, ".") || strings.ContainsAny(domain, "%*") {
+		return opts, fmt.Errorf("invalid domain %q", params.Get("domain"))
+	}
+	opts.Domain = strings.ToLower(domain)
+	if opts.Subdomains, err = parseBool(params, "subdomains", true); err != nil {
+		return opts, err
+	}
+	if opts.Expired, err = parseBool(params, "expired", true); err != nil {
+		return opts, err
+	}
+	if opts.Since, err = parseDate(params, "since"); err != nil {
+		return opts, err
+	}
+	if opts.Until, err = parseDate(params, "until"); err != nil {
+		return opts, err
+	}
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && !opts.Since.Before(opts.Until) {
+		return opts, errors.New("since must be before until")
+	}
+	if value := params.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit <= 0 {
+			return opts, errors.New("limit must be a positive number")
+		}
+	}
+	if value := params.Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || seconds > maxTimeout {
+			return opts, fmt.Errorf("timeout must be between 1 and %d seconds", maxTimeout)
+		}
+		opts.Timeout = time.Duration(seconds) * time.Second
+	}
+	return opts, nil
+}
+
+// Handler serves GET /api/ct?domain=, searching Certificate Transparency
+// logs for the certificates issued for a domain. format=ndjson streams one
+// certificate per line followed by the summary, for result sets too large
+// to buffer.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	opts, err := resolveSearchOptions(params)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := params.Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+
+	switch format {
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		summary := Search(r.Context(), opts, func(cert Certificate) error {
+			if err := encoder.Encode(cert); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		encoder.Encode(summary)
+	case formatJSON:
+		if opts.Limit == 0 || opts.Limit > maxJSONResults {
+			opts.Limit = maxJSONResults
+		}
+		report := Report{Certificates: []Certificate{}}
+		report.Summary = Search(r.Context(), opts, func(cert Certificate) error {
+			report.Certificates = append(report.Certificates, cert)
+			return nil
+		})
+		if report.Summary.Count == 0 && report.Summary.Error != "" {
+			tool.WriteError(w, http.StatusBadGateway, errors.New(report.Summary.Error))
+			return
+		}
+		sort.SliceStable(report.Certificates, func(i, j int) bool {
+			return report.Certificates[i].NotBefore.After(report.Certificates[j].NotBefore)
+		})
+		tool.WriteJSON(w, http.StatusOK, report)
+	default:
+		tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, want json or ndjson", format))
+	}
+}
@@ -0,0 +1,285 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Defaults for anycast POP identification
+const (
+	maxAnycastTargets = 32
+	maxTraceBody      = 16 * 1024
+)
+
+// anycastServices are well-known anycast DNS services probed by default
+var anycastServices = []nameserver{
+	{"cloudflare", "1.1.1.1"},
+	{"google", "8.8.8.8"},
+	{"quad9", "9.9.9.9"},
+	{"opendns", "208.67.222.222"},
+	{"a.root-servers.net", "198.41.0.4"},
+	{"k.root-servers.net", "193.0.14.129"},
+}
+
+// chaosNames are the CHAOS class TXT names that identify a server instance
+var chaosNames = []string{"id.server.", "hostname.bind."}
+
+// popHeaders are CDN response headers that name the serving POP, in order of preference
+var popHeaders = []string{
+	"CF-Ray", "X-Amz-Cf-Pop", "X-Served-By", "X-Vercel-Id", "Fly-Request-Id",
+	"X-Edge-Location", "X-Cache-Pop", "X-Akamai-Edge-Ip", "Server-Timing",
+}
+
+// AnycastMessage represents the incoming anycast POP identification request
+type AnycastMessage struct {
+	// Optional parameters
+	Targets []string `json:"targets,omitempty"` // DNS servers to query (defaults to well-known anycast services)
+	URLs    []string `json:"urls,omitempty"`    // HTTP(S) URLs whose CDN headers identify the POP
+	Timeout *int     `json:"timeout,omitempty"` // Timeout per probe in seconds
+}
+
+// PopMessage reports the instance identifiers returned by one target
+type PopMessage struct {
+	Type        string            `json:"type"`            // Message type ("pop")
+	Target      string            `json:"target"`          // Server address or URL probed
+	Name        string            `json:"name,omitempty"`  // Service name for well-known targets
+	Kind        string            `json:"kind"`            // Probe kind ("dns" or "http")
+	POP         string            `json:"pop,omitempty"`   // Best guess at the instance or POP reached
+	Identifiers map[string]string `json:"identifiers"`     // Every identifier found
+	Duration    float64           `json:"duration"`        // Probe duration in milliseconds
+	Error       string            `json:"error,omitempty"` // Error when nothing could be queried
+}
+
+// AnycastOptions contains the resolved anycast identification options
+type AnycastOptions struct {
+	Targets []nameserver
+	URLs    []string
+	Timeout int
+}
+
+// resolveAnycastOptions converts AnycastMessage to AnycastOptions with defaults
+func resolveAnycastOptions(msg *AnycastMessage) (AnycastOptions, error) {
+	opts := AnycastOptions{
+		URLs:    msg.URLs,
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	for _, target := range msg.Targets {
+		opts.Targets = append(opts.Targets, nameserver{addr: target})
+	}
+	if len(opts.Targets) == 0 && len(opts.URLs) == 0 {
+		opts.Targets = anycastServices
+	}
+	if len(opts.Targets)+len(opts.URLs) > maxAnycastTargets {
+		return opts, fmt.Errorf("at most %d targets can be probed", maxAnycastTargets)
+	}
+	for _, raw := range opts.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return opts, fmt.Errorf("invalid url %q", raw)
+		}
+	}
+	return opts, nil
+}
+
+// printable returns s when it only contains printable characters and its hex
+// encoding otherwise
+func printable(b []byte) string {
+	s := string(b)
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return hex.EncodeToString(b)
+		}
+	}
+	return s
+}
+
+// probeDNSInstance asks a DNS server to identify itself through CHAOS TXT
+// queries and the NSID option
+func probeDNSInstance(server nameserver, timeout time.Duration) PopMessage {
+	start := time.Now()
+	result := PopMessage{Type: "pop", Target: server.addr, Name: server.name, Kind: "dns", Identifiers: map[string]string{}}
+	addr := server.addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultDNSPort)
+	}
+
+	var lastErr error
+	for _, name := range chaosNames {
+		query := new(dns.Msg)
+		query.SetQuestion(name, dns.TypeTXT)
+		query.Question[0].Qclass = dns.ClassCHAOS
+		resp, _, err := exchange(query, addr, timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if txt, ok := rr.(*dns.TXT); ok {
+				result.Identifiers[strings.TrimSuffix(name, ".")] = strings.Join(txt.Txt, "")
+			}
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(".", dns.TypeNS)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	resp, _, err := exchange(query, addr, timeout)
+	if err != nil {
+		lastErr = err
+	} else if opt := resp.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if nsid, ok := o.(*dns.EDNS0_NSID); ok && nsid.Nsid != "" {
+				raw, err := hex.DecodeString(nsid.Nsid)
+				if err != nil {
+					raw = []byte(nsid.Nsid)
+				}
+				result.Identifiers["nsid"] = printable(raw)
+			}
+		}
+	}
+
+	for _, key := range []string{"nsid", "id.server", "hostname.bind"} {
+		if value := result.Identifiers[key]; value != "" {
+			result.POP = value
+			break
+		}
+	}
+	if len(result.Identifiers) == 0 && lastErr != nil {
+		result.Error = lastErr.Error()
+	}
+	result.Duration = milliseconds(time.Since(start))
+	return result
+}
+
+// popFromHeader extracts the POP code from a CDN header value
+func popFromHeader(name, value string) string {
+	switch name {
+	case "Cf-Ray":
+		// 8a1b2c3d4e5f6789-FRA
+		if i := strings.LastIndex(value, "-"); i >= 0 {
+			return value[i+1:]
+		}
+	case "X-Vercel-Id":
+		// fra1::iad1::abcde-1234
+		before, _, _ := strings.Cut(value, "::")
+		return before
+	case "X-Served-By":
+		// cache-fra-eddf8230089-FRA, cache-iad-kiad7000032-IAD
+		parts := strings.Split(value, ",")
+		last := strings.TrimSpace(parts[len(parts)-1])
+		if i := strings.LastIndex(last, "-"); i >= 0 {
+			return last[i+1:]
+		}
+	case "Fly-Request-Id":
+		// 01HXYZ...-fra
+		if i := strings.LastIndex(value, "-"); i >= 0 {
+			return strings.ToUpper(value[i+1:])
+		}
+	}
+	return value
+}
+
+// probeHTTPInstance fetches a URL and reads the POP from CDN headers and
+// Cloudflare style /cdn-cgi/trace bodies
+func probeHTTPInstance(ctx context.Context, target string, timeout time.Duration) PopMessage {
+	start := time.Now()
+	result := PopMessage{Type: "pop", Target: target, Kind: "http", Identifiers: map[string]string{}}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		result.Duration = milliseconds(time.Since(start))
+		return result
+	}
+	tool.Identify(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		result.Duration = milliseconds(time.Since(start))
+		return result
+	}
+	defer resp.Body.Close()
+
+	for _, name := range popHeaders {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		result.Identifiers[canonical] = value
+		if result.POP == "" {
+			result.POP = popFromHeader(canonical, value)
+		}
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxTraceBody))
+	for scanner.Scan() {
+		if colo, ok := strings.CutPrefix(scanner.Text(), "colo="); ok {
+			result.Identifiers["colo"] = colo
+			result.POP = colo
+		}
+	}
+	result.Duration = milliseconds(time.Since(start))
+	return result
+}
+
+// AnycastHandler handles WebSocket anycast POP identification requests
+func AnycastHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "anycast")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg AnycastMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading anycast message: %v", err)
+		return
+	}
+
+	opts, err := resolveAnycastOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid anycast options: %v", err)
+		return
+	}
+	timeout := time.Duration(opts.Timeout) * time.Second
+
+	for _, target := range opts.Targets {
+		result := probeDNSInstance(target, timeout)
+		log.Printf("Anycast %s reached %q", target.addr, result.POP)
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send pop: %v", err)
+			return
+		}
+	}
+	for _, target := range opts.URLs {
+		result := probeHTTPInstance(r.Context(), target, timeout)
+		log.Printf("Anycast %s reached %q", target, result.POP)
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send pop: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Default values for zone transfer options
+const (
+	defaultTransferTimeout = 10 // 10 second timeout per transfer
+	defaultTSIGAlgorithm   = dns.HmacSHA256
+)
+
+// TSIGKey is the key used to authorize a zone transfer
+type TSIGKey struct {
+	Name      string  `json:"name"`                // Key name
+	Secret    string  `json:"secret"`              // Base64 encoded secret
+	Algorithm *string `json:"algorithm,omitempty"` // Algorithm, defaults to hmac-sha256
+}
+
+// TransferMessage represents the incoming zone transfer test request
+type TransferMessage struct {
+	// Required
+	Zone string `json:"zone"` // Zone to transfer
+
+	// Optional parameters
+	Nameservers []string `json:"nameservers,omitempty"` // Servers to try, defaults to the zone's NS set
+	Serial      *uint32  `json:"serial,omitempty"`      // Attempt IXFR from this serial instead of AXFR
+	TSIG        *TSIGKey `json:"tsig,omitempty"`        // Key to authorize the transfer
+	Timeout     *int     `json:"timeout,omitempty"`     // Timeout per transfer in seconds
+}
+
+// TransferResultMessage reports the outcome of a transfer from one server
+type TransferResultMessage struct {
+	Type     string         `json:"type"`             // Message type ("transfer")
+	Server   string         `json:"server"`           // Nameserver name
+	Addr     string         `json:"addr"`             // Address the transfer was attempted on
+	Kind     string         `json:"kind"`             // AXFR or IXFR
+	Allowed  bool           `json:"allowed"`          // Whether the server sent the zone
+	Rcode    string         `json:"rcode,omitempty"`  // Response code when the transfer was refused
+	Serial   uint32         `json:"serial,omitempty"` // SOA serial of the transferred zone
+	Records  int            `json:"records"`          // Number of records received
+	Names    int            `json:"names"`            // Number of distinct owner names
+	Types    map[string]int `json:"types,omitempty"`  // Records received per type
+	Duration float64        `json:"duration"`         // Transfer duration in milliseconds
+	Error    string         `json:"error,omitempty"`  // Error when the transfer failed
+}
+
+// TransferSummaryMessage summarises the transfer attempts for a zone
+type TransferSummaryMessage struct {
+	Type       string `json:"type"`       // Message type ("summary")
+	Zone       string `json:"zone"`       // Zone that was tested
+	Servers    int    `json:"servers"`    // Number of server addresses tried
+	Allowed    int    `json:"allowed"`    // Servers that allowed the transfer
+	Authorized bool   `json:"authorized"` // Whether a TSIG key was used
+	Exposed    bool   `json:"exposed"`    // Whether the zone can be transferred without a key
+}
+
+// TransferOptions contains the resolved zone transfer options
+type TransferOptions struct {
+	Zone        string
+	Nameservers []string
+	Serial      uint32
+	IsIXFR      bool
+	TSIG        *TSIGKey
+	Timeout     int
+}
+
+// resolveTransferOptions converts TransferMessage to TransferOptions with defaults
+func resolveTransferOptions(msg *TransferMessage) (TransferOptions, error) {
+	opts := TransferOptions{
+		Zone:        dns.Fqdn(strings.TrimSpace(msg.Zone)),
+		Nameservers: msg.Nameservers,
+		Serial:      tool.GetOrDefault(msg.Serial, 0),
+		IsIXFR:      msg.Serial != nil,
+		TSIG:        msg.TSIG,
+		Timeout:     tool.GetOrDefault(msg.Timeout, defaultTransferTimeout),
+	}
+
+	if _, ok := dns.IsDomainName(opts.Zone); !ok || opts.Zone == "." {
+		return opts, fmt.Errorf("invalid zone %q", msg.Zone)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if opts.TSIG != nil {
+		if opts.TSIG.Name == "" || opts.TSIG.Secret == "" {
+			return opts, fmt.Errorf("tsig requires a name and secret")
+		}
+		opts.TSIG.Name = dns.Fqdn(opts.TSIG.Name)
+		algorithm := dns.Fqdn(strings.ToLower(tool.GetOrDefault(opts.TSIG.Algorithm, defaultTSIGAlgorithm)))
+		opts.TSIG.Algorithm = &algorithm
+	}
+	return opts, nil
+}
+
+// transferTarget is one nameserver address to attempt a transfer from
+type transferTarget struct {
+	name string
+	addr string
+}
+
+// transferTargets resolves the nameservers to try for a zone
+func transferTargets(ctx context.Context, opts TransferOptions) ([]transferTarget, error) {
+	names := opts.Nameservers
+	if len(names) == 0 {
+		nss, err := net.DefaultResolver.LookupNS(ctx, opts.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up nameservers for %s: %w", opts.Zone, err)
+		}
+		for _, ns := range nss {
+			names = append(names, ns.Host)
+		}
+	}
+
+	var targets []transferTarget
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			targets = append(targets, transferTarget{name: name, addr: name})
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", name)
+		if err != nil {
+			log.Printf("Failed to resolve nameserver %s: %v", name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			targets = append(targets, transferTarget{name: name, addr: addr.String()})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no nameserver addresses found for %s", opts.Zone)
+	}
+	return targets, nil
+}
+
+// attemptTransfer tries an AXFR or IXFR from one server and summarises the records
+func attemptTransfer(target transferTarget, opts TransferOptions) TransferResultMessage {
+	result := TransferResultMessage{Type: "transfer", Server: target.name, Addr: target.addr, Kind: "AXFR"}
+	timeout := time.Duration(opts.Timeout) * time.Second
+
+	msg := new(dns.Msg)
+	if opts.IsIXFR {
+		result.Kind = "IXFR"
+		msg.SetIxfr(opts.Zone, opts.Serial, ".", ".")
+	} else {
+		msg.SetAxfr(opts.Zone)
+	}
+
+	transfer := &dns.Transfer{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	if opts.TSIG != nil {
+		transfer.TsigSecret = map[string]string{opts.TSIG.Name: opts.TSIG.Secret}
+		msg.SetTsig(opts.TSIG.Name, *opts.TSIG.Algorithm, 300, time.Now().Unix())
+	}
+
+	start := time.Now()
+	envelopes, err := transfer.In(msg, net.JoinHostPort(target.addr, defaultDNSPort))
+	if err != nil {
+		result.Error = err.Error()
+		result.Duration = milliseconds(time.Since(start))
+		return result
+	}
+
+	names := make(map[string]bool)
+	result.Types = make(map[string]int)
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			result.Error = envelope.Error.Error()
+			// miekg/dns reports refusals as errors carrying the numeric rcode
+			var rcode int
+			if _, err := fmt.Sscanf(result.Error, "dns: bad xfr rcode: %d", &rcode); err == nil {
+				result.Rcode = dns.RcodeToString[rcode]
+			}
+			break
+		}
+		for _, rr := range envelope.RR {
+			if soa, ok := rr.(*dns.SOA); ok && result.Serial == 0 {
+				result.Serial = soa.Serial
+			}
+			names[strings.ToLower(rr.Header().Name)] = true
+			result.Types[dns.TypeToString[rr.Header().Rrtype]]++
+			result.Records++
+		}
+	}
+	result.Names = len(names)
+	result.Allowed = result.Error == "" && result.Records > 0
+	result.Duration = milliseconds(time.Since(start))
+	return result
+}
+
+// TransferHandler handles WebSocket zone transfer test requests
+func TransferHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "axfr")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg TransferMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading transfer message: %v", err)
+		return
+	}
+
+	opts, err := resolveTransferOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid transfer options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+	targets, err := transferTargets(ctx, opts)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to find nameservers: %v", err)
+		return
+	}
+
+	summary := TransferSummaryMessage{
+		Type:       "summary",
+		Zone:       opts.Zone,
+		Servers:    len(targets),
+		Authorized: opts.TSIG != nil,
+	}
+	for _, target := range targets {
+		result := attemptTransfer(target, opts)
+		log.Printf("%s %s from %s (%s): allowed=%t records=%d %s",
+			result.Kind, opts.Zone, target.name, target.addr, result.Allowed, result.Records, result.Error)
+		if result.Allowed {
+			summary.Allowed++
+		}
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send transfer result: %v", err)
+			return
+		}
+	}
+	summary.Exposed = summary.Allowed > 0 && !summary.Authorized
+
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send transfer summary: %v", err)
+	}
+}
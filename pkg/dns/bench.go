@@ -0,0 +1,344 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Defaults for nameserver benchmarks
+const (
+	defaultBenchQueries  = 20                     // Queries sent to each server
+	defaultBenchInterval = 100 * time.Millisecond // Pause between queries to one server
+	maxBenchQueries      = 500
+	maxBenchServers      = 32
+)
+
+// Roles of benchmarked servers
+const (
+	RoleAuthoritative = "authoritative" // A nameserver of the zone
+	RoleRecursive     = "recursive"     // A resolver given in the request
+)
+
+// BenchMessage represents the incoming nameserver benchmark request
+type BenchMessage struct {
+	// Required
+	Zone string `json:"zone"` // Zone whose nameservers are benchmarked
+
+	// Optional parameters
+	Name        *string        `json:"name,omitempty"`        // Name to query (defaults to the zone)
+	Type        *string        `json:"type,omitempty"`        // Record type to query (defaults to SOA)
+	Nameservers []string       `json:"nameservers,omitempty"` // Authoritative servers to time, defaults to the zone's NS set
+	Resolvers   []string       `json:"resolvers,omitempty"`   // Recursive resolvers to time as well, as host, host:port or label=host
+	Queries     *int           `json:"queries,omitempty"`     // Queries per server
+	Interval    *tool.Duration `json:"interval,omitempty"`    // Pause between queries to one server, e.g. "100ms"
+	TCP         *bool          `json:"tcp,omitempty"`         // Query over TCP instead of UDP
+	Timeout     *int           `json:"timeout,omitempty"`     // Timeout per query in seconds
+}
+
+// BenchControlMessage stops a running benchmark. It may be sent at any
+// time after the BenchMessage.
+type BenchControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// ServerBenchMessage reports the query latency of one server
+type ServerBenchMessage struct {
+	Type             string         `json:"type"`              // Message type ("server")
+	Server           string         `json:"server"`            // Nameserver name or resolver label
+	Addr             string         `json:"addr"`              // Address queried
+	Role             string         `json:"role"`              // "authoritative" or "recursive"
+	Sent             int            `json:"sent"`              // Queries sent
+	Answered         int            `json:"answered"`          // Queries answered with NOERROR or NXDOMAIN
+	Failures         int            `json:"failures"`          // Queries that timed out, failed or were answered with another rcode
+	Timeouts         int            `json:"timeouts"`          // Queries that timed out
+	Rcodes           map[string]int `json:"rcodes"`            // Responses per rcode
+	NotAuthoritative int            `json:"not_authoritative"` // Answers of authoritative servers without the AA bit, a sign of lame delegation
+	Min              float64        `json:"min"`               // Fastest response in milliseconds
+	Avg              float64        `json:"avg"`               // Mean response time in milliseconds
+	P50              float64        `json:"p50"`               // Median response time in milliseconds
+	P95              float64        `json:"p95"`               // 95th percentile response time in milliseconds
+	Max              float64        `json:"max"`               // Slowest response in milliseconds
+	StdDev           float64        `json:"stddev"`            // Standard deviation of response times in milliseconds
+	Error            string         `json:"error,omitempty"`   // Last query error
+}
+
+// BenchSummaryMessage compares the benchmarked servers
+type BenchSummaryMessage struct {
+	Type       string `json:"type"`              // Message type ("summary")
+	Zone       string `json:"zone"`              // Zone that was benchmarked
+	Name       string `json:"name"`              // Name that was queried
+	RecordType string `json:"record_type"`       // Record type that was queried
+	Servers    int    `json:"servers"`           // Servers benchmarked
+	Queries    int    `json:"queries"`           // Queries sent in total
+	Failures   int    `json:"failures"`          // Failed queries in total
+	Fastest    string `json:"fastest,omitempty"` // Address with the lowest p95 of the servers that answered
+	Slowest    string `json:"slowest,omitempty"` // Address with the highest p95 of the servers that answered
+}
+
+// BenchOptions contains the resolved nameserver benchmark options
+type BenchOptions struct {
+	Zone        string
+	Name        string
+	Type        uint16
+	Nameservers []string
+	Resolvers   []nameserver
+	Queries     int
+	Interval    time.Duration
+	Network     string
+	Timeout     time.Duration
+}
+
+// benchTarget is one server address to benchmark
+type benchTarget struct {
+	nameserver
+	role string
+}
+
+// resolveBenchOptions converts BenchMessage to BenchOptions with defaults
+func resolveBenchOptions(msg *BenchMessage) (BenchOptions, error) {
+	opts := BenchOptions{
+		Zone:        dns.Fqdn(strings.TrimSpace(msg.Zone)),
+		Nameservers: msg.Nameservers,
+		Queries:     tool.GetOrDefault(msg.Queries, defaultBenchQueries),
+		Interval:    time.Duration(tool.GetOrDefault(msg.Interval, tool.Duration(defaultBenchInterval))),
+		Network:     "udp",
+		Timeout:     time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+	}
+
+	if _, ok := dns.IsDomainName(opts.Zone); !ok {
+		return opts, fmt.Errorf("invalid zone %q", msg.Zone)
+	}
+	opts.Name = dns.Fqdn(strings.TrimSpace(tool.GetOrDefault(msg.Name, opts.Zone)))
+	if _, ok := dns.IsDomainName(opts.Name); !ok {
+		return opts, fmt.Errorf("invalid name %q", *msg.Name)
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(tool.GetOrDefault(msg.Type, "SOA"))]
+	if !ok {
+		return opts, fmt.Errorf("unsupported record type %q", *msg.Type)
+	}
+	opts.Type = qtype
+	if tool.GetOrDefault(msg.TCP, false) {
+		opts.Network = "tcp"
+	}
+	if opts.Queries <= 0 || opts.Queries > maxBenchQueries {
+		return opts, fmt.Errorf("queries must be between 1 and %d", maxBenchQueries)
+	}
+	if opts.Interval < 0 {
+		return opts, fmt.Errorf("interval cannot be negative")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if len(opts.Nameservers)+len(msg.Resolvers) > maxBenchServers {
+		return opts, fmt.Errorf("at most %d servers can be benchmarked", maxBenchServers)
+	}
+	resolvers, err := parseResolvers(msg.Resolvers)
+	if err != nil {
+		return opts, err
+	}
+	opts.Resolvers = resolvers
+	return opts, nil
+}
+
+// benchTargets lists the addresses of the zone's nameservers followed by
+// the resolvers
+func benchTargets(ctx context.Context, opts BenchOptions) ([]benchTarget, error) {
+	nameservers, err := transferTargets(ctx, TransferOptions{Zone: opts.Zone, Nameservers: opts.Nameservers})
+	if err != nil {
+		return nil, err
+	}
+	var targets []benchTarget
+	for _, ns := range nameservers {
+		targets = append(targets, benchTarget{nameserver{name: ns.name, addr: serverAddress(ns.addr)}, RoleAuthoritative})
+	}
+	for _, resolver := range opts.Resolvers {
+		targets = append(targets, benchTarget{resolver, RoleRecursive})
+	}
+	if len(targets) > maxBenchServers {
+		return nil, fmt.Errorf("%s has %d nameserver addresses, more than %d can be benchmarked", opts.Zone, len(nameservers), maxBenchServers)
+	}
+	return targets, nil
+}
+
+// percentile returns the p-th percentile of sorted values by the nearest
+// rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// benchServer times opts.Queries queries against one server, one at a time,
+// until ctx is done
+func benchServer(ctx context.Context, target benchTarget, opts BenchOptions) ServerBenchMessage {
+	result := ServerBenchMessage{Type: "server", Server: target.name, Addr: target.addr, Role: target.role, Rcodes: map[string]int{}}
+	var latencies []float64
+	for i := 0; i < opts.Queries && ctx.Err() == nil; i++ {
+		if i > 0 && opts.Interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(opts.Interval):
+			}
+		}
+
+		query := new(dns.Msg)
+		query.SetQuestion(opts.Name, opts.Type)
+		// Authoritative servers are asked the way resolvers ask them
+		query.RecursionDesired = target.role == RoleRecursive
+		result.Sent++
+		resp, rtt, err := tool.UpstreamDNS.Exchange(query, opts.Network, target.addr, opts.Timeout)
+		if err != nil {
+			result.Failures++
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				result.Timeouts++
+			}
+			result.Error = err.Error()
+			continue
+		}
+		result.Rcodes[dns.RcodeToString[resp.Rcode]]++
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			result.Failures++
+			continue
+		}
+		result.Answered++
+		if target.role == RoleAuthoritative && !resp.Authoritative {
+			result.NotAuthoritative++
+		}
+		latencies = append(latencies, milliseconds(rtt))
+	}
+
+	if len(latencies) == 0 {
+		return result
+	}
+	slices.Sort(latencies)
+	var sum float64
+	for _, latency := range latencies {
+		sum += latency
+	}
+	mean := sum / float64(len(latencies))
+	var variance float64
+	for _, latency := range latencies {
+		variance += (latency - mean) * (latency - mean)
+	}
+	result.Min, result.Max = latencies[0], latencies[len(latencies)-1]
+	result.Avg = math.Round(mean*1000) / 1000
+	result.P50, result.P95 = percentile(latencies, 50), percentile(latencies, 95)
+	result.StdDev = math.Round(math.Sqrt(variance/float64(len(latencies)))*1000) / 1000
+	return result
+}
+
+// Benchmark times every target concurrently, calling report for each
+// server as it finishes, and compares them in the summary. An error
+// returned by report stops reporting but not the remaining servers.
+func Benchmark(ctx context.Context, opts BenchOptions, targets []benchTarget, report func(ServerBenchMessage) error) BenchSummaryMessage {
+	summary := BenchSummaryMessage{
+		Type:       "summary",
+		Zone:       opts.Zone,
+		Name:       opts.Name,
+		RecordType: dns.TypeToString[opts.Type],
+		Servers:    len(targets),
+	}
+
+	results := make(chan ServerBenchMessage, len(targets))
+	for _, target := range targets {
+		go func() {
+			results <- benchServer(ctx, target, opts)
+		}()
+	}
+
+	var fastest, slowest *ServerBenchMessage
+	reporting := true
+	for range targets {
+		result := <-results
+		summary.Queries += result.Sent
+		summary.Failures += result.Failures
+		if result.Answered > 0 {
+			if fastest == nil || result.P95 < fastest.P95 {
+				fastest = &result
+			}
+			if slowest == nil || result.P95 > slowest.P95 {
+				slowest = &result
+			}
+		}
+		if reporting && report(result) != nil {
+			reporting = false
+		}
+	}
+	if fastest != nil {
+		summary.Fastest, summary.Slowest = fastest.Addr, slowest.Addr
+	}
+	return summary
+}
+
+// BenchHandler handles WebSocket nameserver benchmark requests. A stop
+// control message, or the client disconnecting, ends the benchmark early.
+func BenchHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "dnsbench")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg BenchMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading dnsbench message: %v", err)
+		return
+	}
+
+	opts, err := resolveBenchOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid dnsbench options: %v", err)
+		return
+	}
+
+	lookupCtx, cancelLookup := context.WithTimeout(r.Context(), opts.Timeout)
+	targets, err := benchTargets(lookupCtx, opts)
+	cancelLookup()
+	if err != nil {
+		log.Printf("Failed to find nameservers: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control BenchControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	summary := Benchmark(ctx, opts, targets, func(result ServerBenchMessage) error {
+		for range result.Sent {
+			session.CountProbe()
+		}
+		log.Printf("DNS benchmark of %s (%s): %d/%d answered, p95 %.3f ms", result.Server, result.Addr, result.Answered, result.Sent, result.P95)
+		return session.WriteJSON(result)
+	})
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/extract"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Default values for DNS options
+const (
+	defaultType     = "A"
+	defaultTimeout  = 5 // 5 second timeout per query
+	resolvConf      = "/etc/resolv.conf"
+	fallbackServer  = "127.0.0.1"
+	defaultDNSPort  = "53"
+	maxReferrals    = 16 // Delegations followed before giving up
+	maxTraceServers = 4  // Servers tried per delegation step
+)
+
+// DNSMessage represents the incoming DNS query request
+type DNSMessage struct {
+	// Required
+	Name string `json:"name"` // Name to resolve
+
+	// Optional flags
+	Trace  *bool `json:"trace,omitempty"`  // Resolve iteratively from the root (+trace)
+	DNSSEC *bool `json:"dnssec,omitempty"` // Request DNSSEC records (+dnssec) and validate the chain of trust from the root
+
+	// Optional parameters with values
+	Type         *string      `json:"type,omitempty"`          // Record type, e.g. A, AAAA, MX
+	Server       *string      `json:"server,omitempty"`        // Resolver to query (host or host:port), defaults to the system resolver
+	Timeout      *int         `json:"timeout,omitempty"`       // Timeout per query in seconds
+	Format       *string      `json:"format,omitempty"`        // Output format ("json" or "text")
+	ClientSubnet *string      `json:"client_subnet,omitempty"` // EDNS client subnet, e.g. 203.0.113.0/24
+	UDPSize      *int         `json:"udp_size,omitempty"`      // EDNS UDP payload size
+	EDNSOptions  []EDNSOption `json:"edns_options,omitempty"`  // Additional raw EDNS options
+	Extract      []string     `json:"extract,omitempty"`       // Regex or grok patterns applied to TXT answers
+}
+
+// Record is a single resource record
+type Record struct {
+	Name  string `json:"name"`  // Owner name
+	Type  string `json:"type"`  // Record type
+	Class string `json:"class"` // Record class
+	TTL   uint32 `json:"ttl"`   // Time to live in seconds
+	Data  string `json:"data"`  // Record data in presentation format
+}
+
+// AnswerMessage is the response to a regular lookup
+type AnswerMessage struct {
+	Type       string         `json:"type"`             // Message type ("answer")
+	Server     string         `json:"server"`           // Server that answered
+	Rcode      string         `json:"rcode"`            // Response code, e.g. NOERROR
+	Answers    []Record       `json:"answers"`          // Answer section
+	Authority  []Record       `json:"authority"`        // Authority section
+	Additional []Record       `json:"additional"`       // Additional section, without the OPT record
+	Bytes      int            `json:"bytes"`            // Response size in bytes
+	Duration   float64        `json:"duration"`         // Query time in milliseconds
+	EDNS       *EDNSInfo      `json:"edns,omitempty"`   // OPT record of the response
+	Values     map[string]any `json:"values,omitempty"` // Values extracted from TXT answers
+	Error      string         `json:"error,omitempty"`  // Error when the query failed
+}
+
+// DNSOptions contains the resolved DNS options
+type DNSOptions struct {
+	Name         string
+	Type         uint16
+	Server       string
+	Timeout      int
+	Format       string
+	UDPSize      uint16
+	ClientSubnet *dns.EDNS0_SUBNET
+	EDNSOptions  []dns.EDNS0
+	Extractor    *extract.Extractor
+	IsTrace      bool
+	DNSSEC       bool
+}
+
+// usesEDNS reports whether queries need an OPT record
+func (opts DNSOptions) usesEDNS() bool {
+	return opts.UDPSize != 0 || opts.ClientSubnet != nil || len(opts.EDNSOptions) > 0 || opts.DNSSEC
+}
+
+// resolveDNSOptions converts DNSMessage to DNSOptions with defaults
+func resolveDNSOptions(msg *DNSMessage) (DNSOptions, error) {
+	opts := DNSOptions{
+		Name:    dns.Fqdn(strings.TrimSpace(msg.Name)),
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		Format:  tool.GetOrDefault(msg.Format, tool.FormatJSON),
+		IsTrace: tool.GetOrDefault(msg.Trace, false),
+		DNSSEC:  tool.GetOrDefault(msg.DNSSEC, false),
+	}
+
+	if _, ok := dns.IsDomainName(opts.Name); !ok || opts.Name == "." {
+		return opts, fmt.Errorf("invalid name %q", msg.Name)
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(tool.GetOrDefault(msg.Type, defaultType))]
+	if !ok {
+		return opts, fmt.Errorf("unsupported record type %q", *msg.Type)
+	}
+	opts.Type = qtype
+	// PTR lookups accept an address in place of its reverse name
+	if ip := net.ParseIP(strings.TrimSpace(msg.Name)); ip != nil && qtype == dns.TypePTR {
+		opts.Name, _ = dns.ReverseAddr(ip.String())
+	}
+	if server := strings.TrimSpace(tool.GetOrDefault(msg.Server, "")); server != "" {
+		if opts.IsTrace {
+			return opts, fmt.Errorf("server cannot be used with trace")
+		}
+		opts.Server = serverAddress(server)
+	}
+	if opts.DNSSEC && opts.IsTrace {
+		return opts, fmt.Errorf("dnssec cannot be used with trace")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if err := tool.ValidateFormat(opts.Format); err != nil {
+		return opts, err
+	}
+
+	udpSize := tool.GetOrDefault(msg.UDPSize, 0)
+	if udpSize != 0 && (udpSize < 512 || udpSize > 65535) {
+		return opts, fmt.Errorf("udp size must be between 512 and 65535")
+	}
+	opts.UDPSize = uint16(udpSize)
+
+	ecs, options, err := parseEDNSOptions(tool.GetOrDefault(msg.ClientSubnet, ""), msg.EDNSOptions)
+	if err != nil {
+		return opts, err
+	}
+	opts.ClientSubnet = ecs
+	opts.EDNSOptions = options
+	if opts.UDPSize == 0 && opts.usesEDNS() {
+		opts.UDPSize = defaultUDPSize
+	}
+
+	extractor, err := extract.Compile(msg.Extract)
+	if err != nil {
+		return opts, err
+	}
+	opts.Extractor = extractor
+	return opts, nil
+}
+
+// extractTXT applies the extractor to the text of every TXT record
+func extractTXT(extractor *extract.Extractor, rrs []dns.RR) map[string]any {
+	if extractor == nil {
+		return nil
+	}
+	values := make(map[string]any)
+	for _, rr := range rrs {
+		if txt, ok := rr.(*dns.TXT); ok {
+			for name, value := range extractor.Extract(strings.Join(txt.Txt, "")) {
+				values[name] = value
+			}
+		}
+	}
+	return values
+}
+
+// newRecords converts resource records to their JSON representation
+func newRecords(rrs []dns.RR) []Record {
+	records := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		records = append(records, Record{
+			Name:  hdr.Name,
+			Type:  dns.TypeToString[hdr.Rrtype],
+			Class: dns.ClassToString[hdr.Class],
+			TTL:   hdr.Ttl,
+			Data:  strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return records
+}
+
+// serverAddress adds the DNS port to a server given without one
+func serverAddress(server string) string {
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultDNSPort)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(server, defaultDNSPort)
+	}
+	return server
+}
+
+// systemResolver returns the first nameserver from resolv.conf
+func systemResolver() string {
+	config, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil || len(config.Servers) == 0 {
+		return net.JoinHostPort(fallbackServer, defaultDNSPort)
+	}
+	return net.JoinHostPort(config.Servers[0], config.Port)
+}
+
+// exchange sends a query over UDP, retrying over TCP when the answer is truncated
+func exchange(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, time.Duration, error) {
+	resp, rtt, err := tool.UpstreamDNS.Exchange(msg, "udp", server, timeout)
+	if err == nil && resp.Truncated {
+		resp, rtt, err = tool.UpstreamDNS.Exchange(msg, "tcp", server, timeout)
+	}
+	return resp, rtt, err
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// lookup performs a regular recursive query against the chosen or the
+// system resolver
+func lookup(session *tool.Session, opts DNSOptions) error {
+	server := opts.Server
+	if server == "" {
+		server = systemResolver()
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(opts.Name, opts.Type)
+	applyEDNS(query, opts)
+
+	answer := AnswerMessage{Type: "answer", Server: server, Answers: []Record{}, Authority: []Record{}, Additional: []Record{}}
+	resp, rtt, err := exchange(query, server, time.Duration(opts.Timeout)*time.Second)
+	answer.Duration = milliseconds(rtt)
+	if err != nil {
+		answer.Error = err.Error()
+		return session.WriteJSON(answer)
+	}
+
+	answer.Rcode = dns.RcodeToString[resp.Rcode]
+	answer.Answers = newRecords(resp.Answer)
+	answer.Authority = newRecords(resp.Ns)
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			answer.Additional = append(answer.Additional, newRecords([]dns.RR{rr})...)
+		}
+	}
+	answer.Bytes = resp.Len()
+	answer.EDNS = ednsInfo(resp)
+	answer.Values = extractTXT(opts.Extractor, resp.Answer)
+	if err := session.WriteJSON(answer); err != nil {
+		return err
+	}
+	var validation DNSSECMessage
+	if opts.DNSSEC {
+		validation = validate(server, opts.Name, opts.Type, time.Duration(opts.Timeout)*time.Second)
+		if err := session.WriteJSON(validation); err != nil {
+			return err
+		}
+	}
+
+	if opts.Format == tool.FormatText {
+		for _, line := range strings.Split(strings.TrimSpace(resp.String()), "\n") {
+			if err := session.WriteText(line); err != nil {
+				return err
+			}
+		}
+		if err := session.WriteText(fmt.Sprintf(";; Query time: %d msec", rtt.Milliseconds())); err != nil {
+			return err
+		}
+		if err := session.WriteText(";; SERVER: " + server); err != nil {
+			return err
+		}
+		if opts.DNSSEC {
+			line := ";; DNSSEC: " + validation.Status
+			if failed := validation.FailedLink; failed != nil {
+				line += fmt.Sprintf(" at %s %s: %s", failed.Name, failed.RecordType, failed.Detail)
+			}
+			return session.WriteText(line)
+		}
+	}
+	return nil
+}
+
+// checkDemoDNS applies the limits of demo mode to a query. Traces are
+// refused, as they follow delegations wherever a zone points them. The
+// server is pinned to the address checked.
+func checkDemoDNS(ctx context.Context, opts *DNSOptions) error {
+	if !tool.Demo.Enabled() {
+		return nil
+	}
+	if opts.IsTrace {
+		return fmt.Errorf("trace is not available in demo mode")
+	}
+	if opts.Server != "" {
+		server, err := tool.Demo.PinTarget(ctx, opts.Server)
+		if err != nil {
+			return err
+		}
+		opts.Server = server
+	}
+	return nil
+}
+
+// Handler handles WebSocket DNS query requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "dns")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg DNSMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading DNS message: %v", err)
+		return
+	}
+
+	opts, err := resolveDNSOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid DNS options: %v", err)
+		return
+	}
+	if err := checkDemoDNS(r.Context(), &opts); err != nil {
+		log.Printf("Refused demo DNS query: %v", err)
+		return
+	}
+
+	log.Printf("DNS %s %s (trace=%t, dnssec=%t)", opts.Name, dns.TypeToString[opts.Type], opts.IsTrace, opts.DNSSEC)
+	if opts.IsTrace {
+		err = trace(session, opts)
+	} else {
+		err = lookup(session, opts)
+	}
+	if err != nil {
+		log.Printf("Failed to send DNS result: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSEC validation results (RFC 4035 section 4.3)
+const (
+	StatusSecure        = "SECURE"        // Every link from the root trust anchor to the answer verified
+	StatusInsecure      = "INSECURE"      // A parent zone proved that a delegation below it is unsigned
+	StatusBogus         = "BOGUS"         // A signature, digest or denial of existence failed to verify
+	StatusIndeterminate = "INDETERMINATE" // A record needed for validation could not be fetched
+)
+
+// rootAnchors are the DS records of the root zone's key signing keys,
+// KSK-2017 and KSK-2024, as published by IANA
+var rootAnchors = []string{
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// supportedAlgorithms are the DNSKEY algorithms signatures can be verified
+// with. Zones signed only with others are treated as insecure.
+var supportedAlgorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+// DNSSECLink is one step of the chain of trust
+type DNSSECLink struct {
+	Zone       string   `json:"zone"`               // Zone whose keys the records are checked against
+	Name       string   `json:"name"`               // Owner of the records that were checked
+	RecordType string   `json:"record_type"`        // Type of the records that were checked, e.g. DNSKEY or DS
+	Status     string   `json:"status"`             // Result of the link (SECURE, INSECURE, BOGUS, INDETERMINATE)
+	KeyTags    []uint16 `json:"key_tags,omitempty"` // Keys whose signatures or digests verified
+	Detail     string   `json:"detail,omitempty"`   // What was verified, or why the link failed
+}
+
+// DNSSECMessage reports the validation of an answer against the root
+// trust anchor
+type DNSSECMessage struct {
+	Type       string       `json:"type"`                  // Message type ("dnssec")
+	Name       string       `json:"name"`                  // Name that was validated
+	RecordType string       `json:"record_type"`           // Record type that was validated
+	Server     string       `json:"server"`                // Resolver the records were fetched from
+	Status     string       `json:"status"`                // Overall result (SECURE, INSECURE, BOGUS, INDETERMINATE)
+	Chain      []DNSSECLink `json:"chain"`                 // Links from the root down to the answer
+	FailedLink *DNSSECLink  `json:"failed_link,omitempty"` // First link that was not SECURE
+}
+
+// validator fetches the records of a chain of trust from a resolver and
+// verifies them, top down from the root
+type validator struct {
+	server  string
+	timeout time.Duration
+	now     time.Time
+	result  DNSSECMessage
+}
+
+// query asks the resolver for name with DO set, and CD set so that bogus
+// records are returned for inspection instead of SERVFAIL
+func (v *validator) query(name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.CheckingDisabled = true
+	msg.SetEdns0(4096, true)
+	resp, _, err := exchange(msg, v.server, v.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s %s: %w", name, dns.TypeToString[qtype], err)
+	}
+	return resp, nil
+}
+
+// link records a step of the chain and reports whether it was secure
+func (v *validator) link(l DNSSECLink) bool {
+	v.result.Chain = append(v.result.Chain, l)
+	if l.Status != StatusSecure && v.result.FailedLink == nil {
+		failed := l
+		v.result.FailedLink = &failed
+		v.result.Status = l.Status
+	}
+	return l.Status == StatusSecure
+}
+
+// rrset returns the records of type qtype owned by name, and the signatures
+// covering them
+func rrset(rrs []dns.RR, name string, qtype uint16) ([]dns.RR, []*dns.RRSIG) {
+	var set []dns.RR
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qtype {
+				sigs = append(sigs, sig)
+			}
+		} else if rr.Header().Rrtype == qtype {
+			set = append(set, rr)
+		}
+	}
+	return set, sigs
+}
+
+// verify checks that a signature by one of keys, made by zone and valid
+// now, covers set. It returns the tag of the key that verified.
+func (v *validator) verify(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, zone string) (uint16, error) {
+	if len(sigs) == 0 {
+		return 0, fmt.Errorf("no RRSIG records")
+	}
+	err := fmt.Errorf("no signature by a DNSKEY of %s", zone)
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, zone) {
+			continue
+		}
+		if !sig.ValidityPeriod(v.now) {
+			err = fmt.Errorf("signature by key %d is outside its validity period (%s to %s)",
+				sig.KeyTag, dns.TimeToString(sig.Inception), dns.TimeToString(sig.Expiration))
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if verifyErr := sig.Verify(key, set); verifyErr != nil {
+				err = fmt.Errorf("signature by key %d does not verify: %w", sig.KeyTag, verifyErr)
+				continue
+			}
+			return sig.KeyTag, nil
+		}
+	}
+	return 0, err
+}
+
+// zoneKeys fetches the DNSKEY records of zone and verifies that one matching
+// a trusted DS record signs them all
+func (v *validator) zoneKeys(zone string, trusted []*dns.DS) ([]*dns.DNSKEY, bool) {
+	l := DNSSECLink{Zone: zone, Name: zone, RecordType: "DNSKEY", Status: StatusBogus}
+	resp, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		l.Status, l.Detail = StatusIndeterminate, err.Error()
+		return nil, v.link(l)
+	}
+	set, sigs := rrset(resp.Answer, zone, dns.TypeDNSKEY)
+	var keys, anchored []*dns.DNSKEY
+	for _, rr := range set {
+		key := rr.(*dns.DNSKEY)
+		keys = append(keys, key)
+		for _, ds := range trusted {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			if digest := key.ToDS(ds.DigestType); digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+				anchored = append(anchored, key)
+			}
+		}
+	}
+	switch {
+	case len(keys) == 0:
+		l.Detail = fmt.Sprintf("no DNSKEY records (rcode %s)", dns.RcodeToString[resp.Rcode])
+	case len(anchored) == 0:
+		l.Detail = "no DNSKEY matches a DS record of the parent zone"
+	default:
+		tag, err := v.verify(set, sigs, anchored, zone)
+		if err != nil {
+			l.Detail = "DNSKEY set is not signed by a key with a DS record: " + err.Error()
+			break
+		}
+		l.Status, l.KeyTags = StatusSecure, []uint16{tag}
+		l.Detail = fmt.Sprintf("%d keys, signed by key %d which matches a DS record", len(keys), tag)
+	}
+	return keys, v.link(l)
+}
+
+// denial verifies the signatures of the NSEC and NSEC3 records a negative
+// response carries. When proveNoDS is set, the records must also prove that
+// name is a delegation without a DS record.
+func (v *validator) denial(resp *dns.Msg, name string, keys []*dns.DNSKEY, zone string, proveNoDS bool) ([]uint16, bool, error) {
+	var tags []uint16
+	proven := false
+	for _, rr := range resp.Ns {
+		owner := rr.Header().Name
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			set, sigs := rrset(resp.Ns, owner, dns.TypeNSEC)
+			tag, err := v.verify(set, sigs, keys, zone)
+			if err != nil {
+				return tags, false, fmt.Errorf("NSEC %s: %w", owner, err)
+			}
+			tags = append(tags, tag)
+			if strings.EqualFold(owner, name) && hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeDS) && !hasType(rr.TypeBitMap, dns.TypeSOA) {
+				proven = true
+			}
+		case *dns.NSEC3:
+			set, sigs := rrset(resp.Ns, owner, dns.TypeNSEC3)
+			tag, err := v.verify(set, sigs, keys, zone)
+			if err != nil {
+				return tags, false, fmt.Errorf("NSEC3 %s: %w", owner, err)
+			}
+			tags = append(tags, tag)
+			if rr.Match(name) && hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeDS) && !hasType(rr.TypeBitMap, dns.TypeSOA) {
+				proven = true
+			} else if rr.Cover(name) && rr.Flags&0x01 != 0 {
+				// An opt-out span may hold unsigned delegations
+				proven = true
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return tags, false, fmt.Errorf("no signed NSEC or NSEC3 records")
+	}
+	if proveNoDS && !proven {
+		return tags, false, fmt.Errorf("NSEC records do not prove that the delegation is unsigned")
+	}
+	return tags, true, nil
+}
+
+// hasType reports whether a type bitmap lists qtype
+func hasType(bitmap []uint16, qtype uint16) bool {
+	return slices.Contains(bitmap, qtype)
+}
+
+// supportedDS returns the DS records whose algorithm and digest type can
+// be verified
+func supportedDS(set []dns.RR) []*dns.DS {
+	var supported []*dns.DS
+	for _, rr := range set {
+		ds := rr.(*dns.DS)
+		switch ds.DigestType {
+		case dns.SHA1, dns.SHA256, dns.SHA384:
+			if supportedAlgorithms[ds.Algorithm] {
+				supported = append(supported, ds)
+			}
+		}
+	}
+	return supported
+}
+
+// delegate follows the delegation from zone to child, returning the keys of
+// child when it is signed. The chain ends when child is unsigned or a link
+// fails.
+func (v *validator) delegate(zone, child string, keys []*dns.DNSKEY) ([]*dns.DNSKEY, bool) {
+	l := DNSSECLink{Zone: zone, Name: child, RecordType: "DS", Status: StatusBogus}
+	resp, err := v.query(child, dns.TypeDS)
+	if err != nil {
+		l.Status, l.Detail = StatusIndeterminate, err.Error()
+		return nil, v.link(l)
+	}
+	set, sigs := rrset(resp.Answer, child, dns.TypeDS)
+	if len(set) == 0 {
+		tags, ok, err := v.denial(resp, child, keys, zone, true)
+		if !ok {
+			l.Detail = "no DS records and no valid proof that the delegation is unsigned: " + err.Error()
+			return nil, v.link(l)
+		}
+		l.Status, l.KeyTags, l.Detail = StatusInsecure, tags, "unsigned delegation, proven by signed NSEC records"
+		return nil, v.link(l)
+	}
+
+	tag, err := v.verify(set, sigs, keys, zone)
+	if err != nil {
+		l.Detail = err.Error()
+		return nil, v.link(l)
+	}
+	trusted := supportedDS(set)
+	if len(trusted) == 0 {
+		l.Status, l.KeyTags, l.Detail = StatusInsecure, []uint16{tag}, "DS records use only unsupported algorithms"
+		return nil, v.link(l)
+	}
+	l.Status, l.KeyTags = StatusSecure, []uint16{tag}
+	l.Detail = fmt.Sprintf("%d DS records, signed by key %d", len(set), tag)
+	v.link(l)
+	return v.zoneKeys(child, trusted)
+}
+
+// answer verifies the answer to the query, or the signed denial of its
+// existence, against the keys of the zone holding name
+func (v *validator) answer(name string, qtype uint16, keys []*dns.DNSKEY, zone string) {
+	l := DNSSECLink{Zone: zone, Name: name, RecordType: dns.TypeToString[qtype], Status: StatusBogus}
+	resp, err := v.query(name, qtype)
+	if err != nil {
+		l.Status, l.Detail = StatusIndeterminate, err.Error()
+		v.link(l)
+		return
+	}
+
+	type key struct {
+		name  string
+		qtype uint16
+	}
+	var sets []key
+	for _, rr := range resp.Answer {
+		k := key{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		if k.qtype != dns.TypeRRSIG && !slices.Contains(sets, k) {
+			sets = append(sets, k)
+		}
+	}
+	if len(sets) == 0 {
+		l.Detail = "denial of existence (rcode " + dns.RcodeToString[resp.Rcode] + ")"
+		tags, _, err := v.denial(resp, name, keys, zone, false)
+		if err != nil {
+			l.Detail += ": " + err.Error()
+		} else {
+			l.Status, l.KeyTags = StatusSecure, tags
+		}
+		v.link(l)
+		return
+	}
+	for _, k := range sets {
+		l := DNSSECLink{Zone: zone, Name: k.name, RecordType: dns.TypeToString[k.qtype], Status: StatusBogus}
+		if !dns.IsSubDomain(zone, k.name) {
+			// Aliases into other zones have chains of their own
+			l.Status, l.Detail = StatusIndeterminate, "outside "+zone+", not validated"
+			v.link(l)
+			continue
+		}
+		set, sigs := rrset(resp.Answer, k.name, k.qtype)
+		tag, err := v.verify(set, sigs, keys, zone)
+		if err != nil {
+			l.Detail = err.Error()
+		} else {
+			l.Status, l.KeyTags = StatusSecure, []uint16{tag}
+			l.Detail = fmt.Sprintf("%d records, signed by key %d", len(set), tag)
+		}
+		if !v.link(l) {
+			return
+		}
+	}
+}
+
+// validate walks the chain of trust from the root trust anchor down each
+// zone cut above name, then verifies the answer for name and qtype. Zone
+// cuts are found by asking for the NS records of every ancestor of name.
+func validate(server, name string, qtype uint16, timeout time.Duration) DNSSECMessage {
+	v := &validator{server: server, timeout: timeout, now: time.Now()}
+	v.result = DNSSECMessage{
+		Type:       "dnssec",
+		Name:       name,
+		RecordType: dns.TypeToString[qtype],
+		Server:     server,
+		Status:     StatusSecure,
+		Chain:      []DNSSECLink{},
+	}
+
+	anchors := make([]*dns.DS, 0, len(rootAnchors))
+	for _, anchor := range rootAnchors {
+		rr, err := dns.NewRR(anchor)
+		if err == nil {
+			anchors = append(anchors, rr.(*dns.DS))
+		}
+	}
+	zone := "."
+	keys, ok := v.zoneKeys(zone, anchors)
+	if !ok {
+		return v.result
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+		resp, err := v.query(child, dns.TypeNS)
+		if err != nil {
+			v.link(DNSSECLink{Zone: zone, Name: child, RecordType: "NS", Status: StatusIndeterminate, Detail: err.Error()})
+			return v.result
+		}
+		if resp.Rcode == dns.RcodeNameError {
+			// Nothing exists below, the answer's denial is checked in zone
+			break
+		}
+		if ns, _ := rrset(resp.Answer, child, dns.TypeNS); len(ns) == 0 {
+			continue
+		}
+		if keys, ok = v.delegate(zone, child, keys); !ok {
+			return v.result
+		}
+		zone = child
+	}
+	v.answer(name, qtype, keys, zone)
+	return v.result
+}
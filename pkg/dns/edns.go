@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// defaultUDPSize is the EDNS0 UDP payload size advertised when EDNS is used
+const defaultUDPSize = 1232
+
+// EDNSOption is a raw EDNS0 option given as a code and hex encoded data
+type EDNSOption struct {
+	Code uint16 `json:"code"`           // Option code
+	Data string `json:"data,omitempty"` // Option data, hex encoded
+	Text string `json:"text,omitempty"` // Presentation form, only set in responses
+}
+
+// ClientSubnet describes an EDNS client subnet option (RFC 7871)
+type ClientSubnet struct {
+	Address      string `json:"address"`       // Subnet address
+	SourcePrefix uint8  `json:"source_prefix"` // Prefix length sent by the client
+	ScopePrefix  uint8  `json:"scope_prefix"`  // Prefix length the answer is valid for
+}
+
+// EDNSInfo describes the OPT record of a response
+type EDNSInfo struct {
+	UDPSize      uint16        `json:"udp_size"`                // Advertised UDP payload size
+	ClientSubnet *ClientSubnet `json:"client_subnet,omitempty"` // Client subnet echoed by the server
+	Options      []EDNSOption  `json:"options,omitempty"`       // Other options returned by the server
+}
+
+// parseEDNSOptions validates the client subnet and raw options of a request
+func parseEDNSOptions(subnet string, raw []EDNSOption) (*dns.EDNS0_SUBNET, []dns.EDNS0, error) {
+	var ecs *dns.EDNS0_SUBNET
+	if subnet != "" {
+		ip, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			// A bare address is treated as a host route
+			ip = net.ParseIP(subnet)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("invalid client subnet %q", subnet)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		ones, _ := ipNet.Mask.Size()
+		ecs = &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(ones),
+			Address:       ipNet.IP,
+		}
+		if ip.To4() == nil {
+			ecs.Family = 2
+		}
+	}
+
+	options := make([]dns.EDNS0, 0, len(raw))
+	for _, option := range raw {
+		data, err := hex.DecodeString(option.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid data for EDNS option %d: %w", option.Code, err)
+		}
+		options = append(options, &dns.EDNS0_LOCAL{Code: option.Code, Data: data})
+	}
+	return ecs, options, nil
+}
+
+// applyEDNS adds an OPT record carrying the requested options to msg
+func applyEDNS(msg *dns.Msg, opts DNSOptions) {
+	if !opts.usesEDNS() {
+		return
+	}
+	msg.SetEdns0(opts.UDPSize, opts.DNSSEC)
+	opt := msg.IsEdns0()
+	if opts.ClientSubnet != nil {
+		opt.Option = append(opt.Option, opts.ClientSubnet)
+	}
+	opt.Option = append(opt.Option, opts.EDNSOptions...)
+}
+
+// ednsInfo extracts the OPT record of a response
+func ednsInfo(msg *dns.Msg) *EDNSInfo {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	info := &EDNSInfo{UDPSize: opt.UDPSize()}
+	for _, option := range opt.Option {
+		if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+			info.ClientSubnet = &ClientSubnet{
+				Address:      ecs.Address.String(),
+				SourcePrefix: ecs.SourceNetmask,
+				ScopePrefix:  ecs.SourceScope,
+			}
+			continue
+		}
+		returned := EDNSOption{Code: option.Option(), Text: option.String()}
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok {
+			returned.Data = hex.EncodeToString(local.Data)
+		}
+		info.Options = append(info.Options, returned)
+	}
+	return info
+}
@@ -0,0 +1,351 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Defaults for DNS propagation checks
+const (
+	defaultPropagationRounds   = 1  // Rounds of queries, each asking every resolver once
+	defaultPropagationInterval = 30 // Seconds between rounds
+	maxPropagationResolvers    = 32
+)
+
+// publicResolvers are the global public resolvers queried by default
+var publicResolvers = []nameserver{
+	{"google", "8.8.8.8"},
+	{"cloudflare", "1.1.1.1"},
+	{"quad9", "9.9.9.9"},
+	{"opendns", "208.67.222.222"},
+	{"adguard", "94.140.14.14"},
+	{"cleanbrowsing", "185.228.168.9"},
+	{"controld", "76.76.2.0"},
+	{"yandex", "77.88.8.8"},
+	{"alidns", "223.5.5.5"},
+	{"dnspod", "119.29.29.29"},
+}
+
+// PropagationMessage represents the incoming DNS propagation check request
+type PropagationMessage struct {
+	// Required
+	Name string `json:"name"` // Name to check
+
+	// Optional parameters
+	Type      *string  `json:"type,omitempty"`      // Record type, e.g. A, AAAA, MX
+	Resolvers []string `json:"resolvers,omitempty"` // Resolvers as host, host:port or label=host (defaults to well-known public resolvers)
+	Expected  *string  `json:"expected,omitempty"`  // Record data the change propagates, e.g. a new address; the check ends once every resolver returns it
+	Rounds    *int     `json:"rounds,omitempty"`    // Rounds of queries (0 repeats until stopped or propagated)
+	Interval  *int     `json:"interval,omitempty"`  // Seconds between rounds
+	Timeout   *int     `json:"timeout,omitempty"`   // Timeout per query in seconds
+}
+
+// PropagationControlMessage stops a running check. It may be sent at any
+// time after the PropagationMessage.
+type PropagationControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// ResolverAnswer is the answer of one resolver in one round
+type ResolverAnswer struct {
+	Type     string  `json:"type"`              // Message type ("answer")
+	Round    int     `json:"round"`             // Round of the query, counting from 1
+	Resolver string  `json:"resolver"`          // Label of the resolver
+	Server   string  `json:"server"`            // Address of the resolver
+	TTL      uint32  `json:"ttl"`               // Lowest TTL of the answer, or the negative caching TTL
+	Duration float64 `json:"duration"`          // Query time in milliseconds
+	Changed  bool    `json:"changed"`           // Whether the answer differs from the resolver's previous one
+	Matches  *bool   `json:"matches,omitempty"` // Whether the answer holds the expected data
+	AnswerSet
+}
+
+// AnswerGroup lists the resolvers that returned the same answer
+type AnswerGroup struct {
+	Rcode     string   `json:"rcode,omitempty"` // Response code, e.g. NOERROR
+	Answers   []string `json:"answers"`         // Answer records as "name type data", sorted and without TTLs
+	Error     string   `json:"error,omitempty"` // Error when the queries failed
+	Resolvers []string `json:"resolvers"`       // Labels of the resolvers
+}
+
+// RoundMessage summarises the answers of one round
+type RoundMessage struct {
+	Type       string        `json:"type"`                 // Message type ("round")
+	Round      int           `json:"round"`                // Round number, counting from 1
+	Consistent bool          `json:"consistent"`           // Whether every resolver returned the same answer
+	Groups     []AnswerGroup `json:"groups"`               // Distinct answers, the most common first
+	Propagated *int          `json:"propagated,omitempty"` // Resolvers returning the expected data
+	Resolvers  int           `json:"resolvers"`            // Resolvers queried
+}
+
+// PropagationSummary reports the outcome of a finished check
+type PropagationSummary struct {
+	Type       string   `json:"type"`               // Message type ("summary")
+	Name       string   `json:"name"`               // Name that was checked
+	RecordType string   `json:"record_type"`        // Record type that was checked
+	Rounds     int      `json:"rounds"`             // Rounds completed
+	Consistent bool     `json:"consistent"`         // Whether the resolvers agreed in the last round
+	Complete   *bool    `json:"complete,omitempty"` // Whether every resolver returned the expected data
+	Pending    []string `json:"pending,omitempty"`  // Resolvers not returning the expected data in the last round
+	Duration   float64  `json:"duration"`           // Seconds until the expected data reached every resolver, or the check ended
+}
+
+// PropagationOptions contains the resolved DNS propagation check options
+type PropagationOptions struct {
+	Name      string
+	Type      uint16
+	Resolvers []nameserver
+	Expected  string
+	Rounds    int
+	Interval  time.Duration
+	Timeout   time.Duration
+}
+
+// resolvePropagationOptions converts PropagationMessage to
+// PropagationOptions with defaults
+func resolvePropagationOptions(msg *PropagationMessage) (PropagationOptions, error) {
+	opts := PropagationOptions{
+		Name:     dns.Fqdn(strings.TrimSpace(msg.Name)),
+		Expected: normalizeData(tool.GetOrDefault(msg.Expected, "")),
+		Rounds:   tool.GetOrDefault(msg.Rounds, defaultPropagationRounds),
+		Interval: time.Duration(tool.GetOrDefault(msg.Interval, defaultPropagationInterval)) * time.Second,
+		Timeout:  time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+	}
+
+	if _, ok := dns.IsDomainName(opts.Name); !ok || opts.Name == "." {
+		return opts, fmt.Errorf("invalid name %q", msg.Name)
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(tool.GetOrDefault(msg.Type, defaultType))]
+	if !ok {
+		return opts, fmt.Errorf("unsupported record type %q", *msg.Type)
+	}
+	opts.Type = qtype
+	if opts.Rounds < 0 {
+		return opts, fmt.Errorf("rounds cannot be negative")
+	}
+	if opts.Rounds == 0 && opts.Expected == "" {
+		return opts, fmt.Errorf("unlimited rounds require expected data to finish on")
+	}
+	if opts.Interval <= 0 {
+		return opts, fmt.Errorf("interval must be positive")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+
+	if len(msg.Resolvers) > maxPropagationResolvers {
+		return opts, fmt.Errorf("at most %d resolvers can be queried", maxPropagationResolvers)
+	}
+	resolvers, err := parseResolvers(msg.Resolvers)
+	if err != nil {
+		return opts, err
+	}
+	opts.Resolvers = resolvers
+	if len(opts.Resolvers) == 0 {
+		for _, resolver := range publicResolvers {
+			opts.Resolvers = append(opts.Resolvers, nameserver{name: resolver.name, addr: serverAddress(resolver.addr)})
+		}
+	}
+	return opts, nil
+}
+
+// parseResolvers parses resolvers given as host, host:port or label=host,
+// adding the DNS port where it is missing
+func parseResolvers(entries []string) ([]nameserver, error) {
+	var resolvers []nameserver
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		label, addr, ok := strings.Cut(entry, "=")
+		if !ok {
+			label, addr = entry, entry
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("invalid resolver %q", entry)
+		}
+		resolvers = append(resolvers, nameserver{name: label, addr: serverAddress(addr)})
+	}
+	return resolvers, nil
+}
+
+// normalizeData lowercases record data and drops the trailing dot of names,
+// so "MX.Example.com." and "mx.example.com" compare equal
+func normalizeData(data string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(data)), ".")
+}
+
+// holdsData reports whether an answer set contains a record with the data
+func holdsData(set AnswerSet, data string) bool {
+	for _, answer := range set.Answers {
+		if fields := strings.SplitN(answer, " ", 3); len(fields) == 3 && normalizeData(fields[2]) == data {
+			return true
+		}
+	}
+	return false
+}
+
+// groupAnswers groups the resolvers of a round by the answer they returned,
+// the most common answer first
+func groupAnswers(answers []ResolverAnswer) []AnswerGroup {
+	groups := []AnswerGroup{}
+	for _, answer := range answers {
+		i := slices.IndexFunc(groups, func(g AnswerGroup) bool {
+			return sameAnswers(AnswerSet{Rcode: g.Rcode, Answers: g.Answers, Error: g.Error}, answer.AnswerSet) ||
+				(g.Error != "" && answer.Error != "")
+		})
+		if i < 0 {
+			groups = append(groups, AnswerGroup{Rcode: answer.Rcode, Answers: answer.Answers, Error: answer.Error})
+			i = len(groups) - 1
+		}
+		groups[i].Resolvers = append(groups[i].Resolvers, answer.Resolver)
+	}
+	slices.SortStableFunc(groups, func(a, b AnswerGroup) int {
+		return len(b.Resolvers) - len(a.Resolvers)
+	})
+	return groups
+}
+
+// Propagation queries every resolver concurrently once per round, calling
+// answer for each answer as it arrives and round once the round is
+// complete. It stops after the configured rounds, once every resolver
+// returns the expected data, or when ctx is done. An error returned by a
+// callback ends the check.
+func Propagation(ctx context.Context, opts PropagationOptions, answer func(ResolverAnswer) error, round func(RoundMessage) error) PropagationSummary {
+	start := time.Now()
+	summary := PropagationSummary{
+		Type:       "summary",
+		Name:       opts.Name,
+		RecordType: dns.TypeToString[opts.Type],
+	}
+
+	previous := make(map[string]AnswerSet)
+	for n := 1; opts.Rounds == 0 || n <= opts.Rounds; n++ {
+		results := make(chan ResolverAnswer, len(opts.Resolvers))
+		for _, resolver := range opts.Resolvers {
+			go func() {
+				queried := time.Now()
+				set, ttl := answerSet(WatchOptions{Name: opts.Name, Type: opts.Type, Server: resolver.addr, Timeout: opts.Timeout})
+				results <- ResolverAnswer{
+					Type:      "answer",
+					Round:     n,
+					Resolver:  resolver.name,
+					Server:    resolver.addr,
+					TTL:       ttl,
+					Duration:  milliseconds(time.Since(queried)),
+					AnswerSet: set,
+				}
+			}()
+		}
+
+		answers := make([]ResolverAnswer, 0, len(opts.Resolvers))
+		stopped := false
+		for range opts.Resolvers {
+			result := <-results
+			if last, ok := previous[result.Server]; ok {
+				// A resolver that keeps failing has not changed its answer
+				result.Changed = !sameAnswers(last, result.AnswerSet) && (last.Error == "" || result.Error == "")
+			}
+			previous[result.Server] = result.AnswerSet
+			if opts.Expected != "" {
+				matches := holdsData(result.AnswerSet, opts.Expected)
+				result.Matches = &matches
+			}
+			answers = append(answers, result)
+			if !stopped && answer(result) != nil {
+				stopped = true
+			}
+		}
+		if stopped {
+			break
+		}
+
+		message := RoundMessage{Type: "round", Round: n, Groups: groupAnswers(answers), Resolvers: len(answers)}
+		message.Consistent = len(message.Groups) == 1
+		summary.Rounds, summary.Consistent, summary.Pending = n, message.Consistent, nil
+		if opts.Expected != "" {
+			propagated := 0
+			for _, result := range answers {
+				if *result.Matches {
+					propagated++
+				} else {
+					summary.Pending = append(summary.Pending, result.Resolver)
+				}
+			}
+			message.Propagated = &propagated
+			complete := propagated == len(answers)
+			summary.Complete = &complete
+		}
+		summary.Duration = time.Since(start).Seconds()
+		if err := round(message); err != nil || (summary.Complete != nil && *summary.Complete) {
+			break
+		}
+		if opts.Rounds != 0 && n == opts.Rounds {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return summary
+		case <-time.After(opts.Interval):
+		}
+	}
+	return summary
+}
+
+// PropagationHandler handles WebSocket DNS propagation check requests. A
+// stop control message, or the client disconnecting, ends a check.
+func PropagationHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "propagation")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg PropagationMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading propagation message: %v", err)
+		return
+	}
+
+	opts, err := resolvePropagationOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid propagation options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control PropagationControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	summary := Propagation(ctx, opts, func(answer ResolverAnswer) error {
+		session.CountProbe()
+		return session.WriteJSON(answer)
+	}, func(round RoundMessage) error {
+		return session.WriteJSON(round)
+	})
+	log.Printf("DNS propagation of %s %s: %d rounds across %d resolvers, consistent %t", opts.Name, summary.RecordType, summary.Rounds, len(opts.Resolvers), summary.Consistent)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
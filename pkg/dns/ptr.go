@@ -0,0 +1,221 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// PTR batch lookup limits
+const (
+	defaultPTRConcurrency = 16
+	maxPTRConcurrency     = 64
+	maxPTRAddresses       = 1024 // Most addresses looked up per request (a /22 for IPv4)
+)
+
+// PTRRequest is the body of a batch reverse DNS lookup
+type PTRRequest struct {
+	IPs         []string `json:"ips,omitempty"`         // Addresses to look up
+	CIDR        string   `json:"cidr,omitempty"`        // Network whose every address is looked up
+	Server      *string  `json:"server,omitempty"`      // Resolver to query (host or host:port), defaults to the system resolver
+	Concurrency *int     `json:"concurrency,omitempty"` // Lookups in flight at once
+	Timeout     *int     `json:"timeout,omitempty"`     // Timeout per query in seconds
+	Confirm     *bool    `json:"confirm,omitempty"`     // Check that each name resolves back to its address (forward-confirmed rDNS)
+}
+
+// PTRResult is the reverse DNS lookup of one address
+type PTRResult struct {
+	IP               string   `json:"ip"`                          // Address that was looked up
+	Name             string   `json:"name"`                        // Reverse DNS name queried
+	PTR              []string `json:"ptr"`                         // Names the PTR records point to
+	Rcode            string   `json:"rcode,omitempty"`             // Response code, e.g. NOERROR or NXDOMAIN
+	TTL              uint32   `json:"ttl,omitempty"`               // Lowest TTL of the PTR records
+	ForwardConfirmed *bool    `json:"forward_confirmed,omitempty"` // Whether a PTR name resolves back to the address
+	Duration         float64  `json:"duration"`                    // Lookup time in milliseconds
+	Error            string   `json:"error,omitempty"`             // Error when the query failed
+}
+
+// PTRReport is the response to a batch reverse DNS lookup
+type PTRReport struct {
+	Server   string      `json:"server"`   // Resolver that was queried
+	Count    int         `json:"count"`    // Addresses looked up
+	Resolved int         `json:"resolved"` // Addresses with PTR records
+	Missing  []string    `json:"missing"`  // Addresses without PTR records or whose lookup failed
+	Results  []PTRResult `json:"results"`  // Lookups in the order the addresses were given
+}
+
+// PTROptions contains the resolved batch reverse DNS lookup options
+type PTROptions struct {
+	Addresses   []netip.Addr
+	Server      string
+	Concurrency int
+	Timeout     time.Duration
+	Confirm     bool
+}
+
+// resolvePTROptions converts PTRRequest to PTROptions with defaults
+func resolvePTROptions(req *PTRRequest) (PTROptions, error) {
+	opts := PTROptions{
+		Server:      strings.TrimSpace(tool.GetOrDefault(req.Server, "")),
+		Concurrency: tool.GetOrDefault(req.Concurrency, defaultPTRConcurrency),
+		Timeout:     time.Duration(tool.GetOrDefault(req.Timeout, defaultTimeout)) * time.Second,
+		Confirm:     tool.GetOrDefault(req.Confirm, false),
+	}
+	if opts.Server == "" {
+		opts.Server = systemResolver()
+	} else {
+		opts.Server = serverAddress(opts.Server)
+	}
+
+	for _, value := range req.IPs {
+		addr, err := netip.ParseAddr(strings.TrimSpace(value))
+		if err != nil {
+			return opts, fmt.Errorf("invalid ip %q", value)
+		}
+		opts.Addresses = append(opts.Addresses, addr.WithZone(""))
+	}
+	if cidr := strings.TrimSpace(req.CIDR); cidr != "" {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid cidr %q", req.CIDR)
+		}
+		prefix = prefix.Masked()
+		if hostBits := prefix.Addr().BitLen() - prefix.Bits(); hostBits > 30 || 1<<hostBits > maxPTRAddresses {
+			return opts, fmt.Errorf("cidr must hold at most %d addresses", maxPTRAddresses)
+		}
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			opts.Addresses = append(opts.Addresses, addr)
+		}
+	}
+
+	if len(opts.Addresses) == 0 {
+		return opts, errors.New("ips or cidr is required")
+	}
+	if len(opts.Addresses) > maxPTRAddresses {
+		return opts, fmt.Errorf("at most %d addresses can be looked up", maxPTRAddresses)
+	}
+	if opts.Concurrency <= 0 || opts.Concurrency > maxPTRConcurrency {
+		return opts, fmt.Errorf("concurrency must be between 1 and %d", maxPTRConcurrency)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// lookupPTR queries the PTR records of addr
+func lookupPTR(opts PTROptions, addr netip.Addr) (result PTRResult) {
+	start := time.Now()
+	name, _ := dns.ReverseAddr(addr.String())
+	result = PTRResult{IP: addr.String(), Name: name, PTR: []string{}}
+	defer func() {
+		result.Duration = milliseconds(time.Since(start))
+	}()
+
+	query := new(dns.Msg)
+	query.SetQuestion(name, dns.TypePTR)
+	resp, _, err := exchange(query, opts.Server, opts.Timeout)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Rcode = dns.RcodeToString[resp.Rcode]
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			if len(result.PTR) == 0 || ptr.Hdr.Ttl < result.TTL {
+				result.TTL = ptr.Hdr.Ttl
+			}
+			result.PTR = append(result.PTR, ptr.Ptr)
+		}
+	}
+
+	if opts.Confirm && len(result.PTR) > 0 {
+		confirmed := false
+		for _, target := range result.PTR {
+			if confirmed = resolvesTo(opts, target, addr); confirmed {
+				break
+			}
+		}
+		result.ForwardConfirmed = &confirmed
+	}
+	return result
+}
+
+// resolvesTo reports whether name has an A or AAAA record for addr
+func resolvesTo(opts PTROptions, name string, addr netip.Addr) bool {
+	qtype := dns.TypeA
+	if addr.Is6() && !addr.Is4In6() {
+		qtype = dns.TypeAAAA
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	resp, _, err := exchange(query, opts.Server, opts.Timeout)
+	if err != nil {
+		return false
+	}
+	for _, rr := range resp.Answer {
+		var ip []byte
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		if found, ok := netip.AddrFromSlice(ip); ok && found.Unmap() == addr.Unmap() {
+			return true
+		}
+	}
+	return false
+}
+
+// PTRHandler looks up the PTR records of a list of addresses or of every
+// address in a network, several at a time
+func PTRHandler(w http.ResponseWriter, r *http.Request) {
+	var req PTRRequest
+	if err := tool.DecodeRequest(w, r, &req); err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := resolvePTROptions(&req)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	results := make([]PTRResult, len(opts.Addresses))
+	slots := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, addr := range opts.Addresses {
+		if r.Context().Err() != nil {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = lookupPTR(opts, addr)
+		}()
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		return
+	}
+
+	report := PTRReport{Server: opts.Server, Count: len(results), Missing: []string{}, Results: results}
+	for _, result := range results {
+		if len(result.PTR) > 0 {
+			report.Resolved++
+		} else {
+			report.Missing = append(report.Missing, result.IP)
+		}
+	}
+	tool.WriteJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,349 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Bulk resolution limits
+const (
+	defaultResolveConcurrency = 32
+	maxResolveConcurrency     = 128
+	maxResolveHostnames       = 10000
+	maxResolveUpload          = 1 << 20 // Largest hostname list upload in bytes
+)
+
+// resolveColumns is the header row of CSV results
+var resolveColumns = []string{"hostname", "addresses", "cnames", "rcode", "ttl", "duration_ms", "error"}
+
+// ResolveRequest is the body of a bulk hostname resolution
+type ResolveRequest struct {
+	Hostnames   []string `json:"hostnames"`             // Names to resolve
+	Types       []string `json:"types,omitempty"`       // Address types to query, A and/or AAAA (defaults to both)
+	Server      *string  `json:"server,omitempty"`      // Resolver to query (host or host:port), defaults to the system resolver
+	Concurrency *int     `json:"concurrency,omitempty"` // Names resolved at once
+	Timeout     *int     `json:"timeout,omitempty"`     // Timeout per query in seconds
+}
+
+// HostResult is the resolution of one hostname
+type HostResult struct {
+	Hostname  string   `json:"hostname"`        // Name as given
+	Addresses []string `json:"addresses"`       // A and AAAA addresses
+	CNAMEs    []string `json:"cnames"`          // Aliases followed on the way to the addresses
+	Rcode     string   `json:"rcode,omitempty"` // Response code, e.g. NOERROR or NXDOMAIN
+	TTL       uint32   `json:"ttl,omitempty"`   // Lowest TTL of the address records
+	Duration  float64  `json:"duration"`        // Resolution time in milliseconds
+	Error     string   `json:"error,omitempty"` // Error when the name is invalid or a query failed
+}
+
+// ResolveReport is the JSON response to a bulk hostname resolution
+type ResolveReport struct {
+	Server     string       `json:"server"`     // Resolver that was queried
+	Count      int          `json:"count"`      // Names resolved
+	Resolved   int          `json:"resolved"`   // Names with at least one address
+	Unresolved []string     `json:"unresolved"` // Names without addresses or whose queries failed
+	Results    []HostResult `json:"results"`    // Results in the order the names were given
+}
+
+// ResolveOptions contains the resolved bulk resolution options
+type ResolveOptions struct {
+	Hostnames   []string
+	Types       []uint16
+	Server      string
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// resolveResolveOptions converts ResolveRequest to ResolveOptions with defaults
+func resolveResolveOptions(req *ResolveRequest) (ResolveOptions, error) {
+	opts := ResolveOptions{
+		Hostnames:   req.Hostnames,
+		Server:      strings.TrimSpace(tool.GetOrDefault(req.Server, "")),
+		Concurrency: tool.GetOrDefault(req.Concurrency, defaultResolveConcurrency),
+		Timeout:     time.Duration(tool.GetOrDefault(req.Timeout, defaultTimeout)) * time.Second,
+	}
+	if opts.Server == "" {
+		opts.Server = systemResolver()
+	} else {
+		opts.Server = serverAddress(opts.Server)
+	}
+
+	for _, name := range req.Types {
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case "A":
+			opts.Types = append(opts.Types, dns.TypeA)
+		case "AAAA":
+			opts.Types = append(opts.Types, dns.TypeAAAA)
+		default:
+			return opts, fmt.Errorf("unsupported type %q, want A or AAAA", name)
+		}
+	}
+	if len(opts.Types) == 0 {
+		opts.Types = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	if len(opts.Hostnames) == 0 {
+		return opts, errors.New("hostnames is required")
+	}
+	if len(opts.Hostnames) > maxResolveHostnames {
+		return opts, fmt.Errorf("at most %d hostnames can be resolved", maxResolveHostnames)
+	}
+	if opts.Concurrency <= 0 || opts.Concurrency > maxResolveConcurrency {
+		return opts, fmt.Errorf("concurrency must be between 1 and %d", maxResolveConcurrency)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// readHostnames reads one hostname per line from an uploaded list. For CSV
+// files the first column is used and a header row naming it hostname, host
+// or name is skipped; blank lines and # comments are skipped too.
+func readHostnames(r io.Reader) ([]string, error) {
+	var hostnames []string
+	scanner := bufio.NewScanner(r)
+	for header := true; scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		first, _, _ := strings.Cut(line, ",")
+		first = strings.Trim(strings.TrimSpace(first), `"`)
+		if header {
+			header = false
+			switch strings.ToLower(first) {
+			case "hostname", "host", "name":
+				continue
+			}
+		}
+		if first != "" {
+			hostnames = append(hostnames, first)
+		}
+		if len(hostnames) > maxResolveHostnames {
+			return nil, fmt.Errorf("at most %d hostnames can be resolved", maxResolveHostnames)
+		}
+	}
+	return hostnames, scanner.Err()
+}
+
+// queryRequest builds the options of an uploaded hostname list from the
+// query parameters
+func queryRequest(query url.Values) (ResolveRequest, error) {
+	var req ResolveRequest
+	if types := query.Get("types"); types != "" {
+		req.Types = strings.Split(types, ",")
+	}
+	if server := query.Get("server"); server != "" {
+		req.Server = &server
+	}
+	for key, target := range map[string]**int{"concurrency": &req.Concurrency, "timeout": &req.Timeout} {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s %q", key, value)
+			}
+			*target = &n
+		}
+	}
+	return req, nil
+}
+
+// decodeResolveRequest reads a JSON request, or a hostname list uploaded as
+// the body or as the file field of a multipart form with the options in the
+// query parameters
+func decodeResolveRequest(w http.ResponseWriter, r *http.Request) (ResolveRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" && mediaType != "text/plain" && mediaType != "text/csv" {
+		var req ResolveRequest
+		err := tool.DecodeRequest(w, r, &req)
+		return req, err
+	}
+
+	req, err := queryRequest(r.URL.Query())
+	if err != nil {
+		return req, err
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxResolveUpload)
+	body := io.Reader(r.Body)
+	if mediaType == "multipart/form-data" {
+		form, err := r.MultipartReader()
+		if err != nil {
+			return req, err
+		}
+		for {
+			part, err := form.NextPart()
+			if err == io.EOF {
+				return req, errors.New("multipart form has no file field")
+			}
+			if err != nil {
+				return req, err
+			}
+			if part.FormName() == "file" {
+				body = part
+				break
+			}
+		}
+	}
+	req.Hostnames, err = readHostnames(body)
+	return req, err
+}
+
+// resolveHost queries the address records of one hostname
+func resolveHost(opts ResolveOptions, hostname string) (result HostResult) {
+	start := time.Now()
+	result = HostResult{Hostname: hostname, Addresses: []string{}, CNAMEs: []string{}}
+	defer func() {
+		result.Duration = milliseconds(time.Since(start))
+	}()
+
+	name := dns.Fqdn(strings.TrimSpace(hostname))
+	if _, ok := dns.IsDomainName(name); !ok || name == "." {
+		result.Error = "invalid hostname"
+		return result
+	}
+
+	for _, qtype := range opts.Types {
+		query := new(dns.Msg)
+		query.SetQuestion(name, qtype)
+		resp, _, err := exchange(query, opts.Server, opts.Timeout)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		if result.Rcode == "" || resp.Rcode != dns.RcodeSuccess {
+			result.Rcode = dns.RcodeToString[resp.Rcode]
+		}
+		for _, rr := range resp.Answer {
+			var addr string
+			switch rr := rr.(type) {
+			case *dns.A:
+				addr = rr.A.String()
+			case *dns.AAAA:
+				addr = rr.AAAA.String()
+			case *dns.CNAME:
+				// Both queries follow the same chain
+				if qtype == opts.Types[0] {
+					result.CNAMEs = append(result.CNAMEs, rr.Target)
+				}
+				continue
+			default:
+				continue
+			}
+			if len(result.Addresses) == 0 || rr.Header().Ttl < result.TTL {
+				result.TTL = rr.Header().Ttl
+			}
+			result.Addresses = append(result.Addresses, addr)
+		}
+	}
+	return result
+}
+
+// csvRow formats a result as a CSV record, addresses and aliases separated
+// by spaces
+func (result HostResult) csvRow() []string {
+	return []string{
+		result.Hostname,
+		strings.Join(result.Addresses, " "),
+		strings.Join(result.CNAMEs, " "),
+		result.Rcode,
+		strconv.FormatUint(uint64(result.TTL), 10),
+		strconv.FormatFloat(result.Duration, 'f', 3, 64),
+		result.Error,
+	}
+}
+
+// ResolveHandler resolves a list of hostnames to their addresses, several at
+// a time. With ?format=csv the results are streamed as a CSV download in the
+// order the names were given; otherwise a JSON report is returned once all
+// are done.
+func ResolveHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, want json or csv", format))
+		return
+	}
+	req, err := decodeResolveRequest(w, r)
+	if err != nil {
+		tool.WriteError(w, uploadStatus(err), err)
+		return
+	}
+	opts, err := resolveResolveOptions(&req)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Each result has a channel of its own, so results can be written in
+	// order while later names are still being resolved
+	results := make([]chan HostResult, len(opts.Hostnames))
+	for i := range results {
+		results[i] = make(chan HostResult, 1)
+	}
+	go func() {
+		slots := make(chan struct{}, opts.Concurrency)
+		for i, hostname := range opts.Hostnames {
+			if r.Context().Err() != nil {
+				results[i] <- HostResult{Hostname: hostname, Error: r.Context().Err().Error()}
+				continue
+			}
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				results[i] <- resolveHost(opts, hostname)
+			}()
+		}
+	}()
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="resolve.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write(resolveColumns)
+		flusher, _ := w.(http.Flusher)
+		for _, result := range results {
+			writer.Write((<-result).csvRow())
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return
+	}
+
+	report := ResolveReport{Server: opts.Server, Count: len(results), Unresolved: []string{}, Results: make([]HostResult, 0, len(results))}
+	for _, ch := range results {
+		result := <-ch
+		if len(result.Addresses) > 0 {
+			report.Resolved++
+		} else {
+			report.Unresolved = append(report.Unresolved, result.Hostname)
+		}
+		report.Results = append(report.Results, result)
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	tool.WriteJSON(w, http.StatusOK, report)
+}
+
+// uploadStatus returns the status code for an error reading a request
+func uploadStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) || errors.Is(err, tool.ErrMessageTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
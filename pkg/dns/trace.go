@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// TraceStepMessage reports one server queried during iterative resolution
+type TraceStepMessage struct {
+	Type       string         `json:"type"`               // Message type ("step")
+	Zone       string         `json:"zone"`               // Zone the queried server is authoritative for
+	Server     string         `json:"server"`             // Name of the server that was asked
+	ServerAddr string         `json:"server_addr"`        // Address of the server that was asked
+	Rcode      string         `json:"rcode,omitempty"`    // Response code
+	Referral   string         `json:"referral,omitempty"` // Zone the server delegated to
+	Records    []Record       `json:"records"`            // Answer or delegation records returned
+	Bytes      int            `json:"bytes"`              // Response size in bytes
+	Duration   float64        `json:"duration"`           // Query time in milliseconds
+	EDNS       *EDNSInfo      `json:"edns,omitempty"`     // OPT record of the response
+	Final      bool           `json:"final"`              // Whether this step ended the resolution
+	Values     map[string]any `json:"values,omitempty"`   // Values extracted from TXT answers of the final step
+	Error      string         `json:"error,omitempty"`    // Error when no server could be queried
+}
+
+// errLameDelegation is returned when a server refers to a zone that is not below its own
+var errLameDelegation = errors.New("lame or upward referral")
+
+// tracer performs iterative resolution from the root, like dig +trace
+type tracer struct {
+	session *tool.Session
+	opts    DNSOptions
+	timeout time.Duration
+}
+
+// query asks the given servers in random order until one responds
+func (t *tracer) query(servers []nameserver) (*dns.Msg, nameserver, time.Duration, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(t.opts.Name, t.opts.Type)
+	msg.RecursionDesired = false
+	applyEDNS(msg, t.opts)
+
+	order := rand.Perm(len(servers))
+	if len(order) > maxTraceServers {
+		order = order[:maxTraceServers]
+	}
+
+	var lastErr error
+	for _, i := range order {
+		server := servers[i]
+		resp, rtt, err := exchange(msg, net.JoinHostPort(server.addr, defaultDNSPort), t.timeout)
+		if err != nil {
+			lastErr = fmt.Errorf("%s (%s): %w", server.name, server.addr, err)
+			continue
+		}
+		return resp, server, rtt, nil
+	}
+	return nil, nameserver{}, 0, lastErr
+}
+
+// delegation extracts the zone and nameservers a referral points to
+func delegation(resp *dns.Msg, zone string) (string, []string, error) {
+	var child string
+	var names []string
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		child = ns.Hdr.Name
+		names = append(names, ns.Ns)
+	}
+	if child == "" {
+		return "", nil, nil
+	}
+	if child == zone || !dns.IsSubDomain(zone, child) {
+		return child, nil, errLameDelegation
+	}
+	return child, names, nil
+}
+
+// nextServers resolves the nameservers of a referral, preferring glue records
+func (t *tracer) nextServers(resp *dns.Msg, names []string) []nameserver {
+	var servers []nameserver
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+	for _, rr := range resp.Extra {
+		if a, ok := rr.(*dns.A); ok && wanted[strings.ToLower(a.Hdr.Name)] {
+			servers = append(servers, nameserver{name: a.Hdr.Name, addr: a.A.String()})
+		}
+	}
+	if len(servers) > 0 {
+		return servers
+	}
+
+	// Out-of-bailiwick nameservers come without glue, so resolve them
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	for _, name := range names {
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			servers = append(servers, nameserver{name: name, addr: addr.String()})
+		}
+	}
+	return servers
+}
+
+// send writes a trace step and, in text mode, the dig +trace style lines
+func (t *tracer) send(step TraceStepMessage, resp *dns.Msg) error {
+	if err := t.session.WriteJSON(step); err != nil {
+		return err
+	}
+	if t.opts.Format != tool.FormatText {
+		return nil
+	}
+
+	var lines []string
+	if resp != nil {
+		rrs := resp.Answer
+		if len(rrs) == 0 {
+			rrs = resp.Ns
+		}
+		for _, rr := range rrs {
+			lines = append(lines, rr.String())
+		}
+		lines = append(lines, fmt.Sprintf(";; Received %d bytes from %s#%s(%s) in %d ms",
+			step.Bytes, step.ServerAddr, defaultDNSPort, step.Server, int(step.Duration)), "")
+	}
+	if step.Error != "" {
+		lines = append(lines, ";; "+step.Error)
+	}
+	for _, line := range lines {
+		if err := t.session.WriteText(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trace resolves opts.Name iteratively from the root, streaming each step
+func trace(session *tool.Session, opts DNSOptions) error {
+	t := &tracer{session: session, opts: opts, timeout: time.Duration(opts.Timeout) * time.Second}
+	if opts.Format == tool.FormatText {
+		header := fmt.Sprintf("; <<>> net-tools <<>> +trace %s %s", opts.Name, dns.TypeToString[opts.Type])
+		if err := session.WriteText(header); err != nil {
+			return err
+		}
+	}
+
+	zone := "."
+	servers := rootServers
+	for i := 0; i < maxReferrals; i++ {
+		step := TraceStepMessage{Type: "step", Zone: zone, Records: []Record{}}
+
+		resp, server, rtt, err := t.query(servers)
+		if err != nil {
+			step.Final = true
+			step.Error = fmt.Sprintf("no server for %s responded: %v", zone, err)
+			return t.send(step, nil)
+		}
+		step.Server = server.name
+		step.ServerAddr = server.addr
+		step.Rcode = dns.RcodeToString[resp.Rcode]
+		step.Bytes = resp.Len()
+		step.Duration = milliseconds(rtt)
+		step.EDNS = ednsInfo(resp)
+
+		if len(resp.Answer) > 0 || resp.Rcode != dns.RcodeSuccess {
+			step.Records = newRecords(resp.Answer)
+			if len(resp.Answer) == 0 {
+				step.Records = newRecords(resp.Ns)
+			}
+			step.Final = true
+			step.Values = extractTXT(t.opts.Extractor, resp.Answer)
+			return t.send(step, resp)
+		}
+
+		child, names, err := delegation(resp, zone)
+		step.Records = newRecords(resp.Ns)
+		switch {
+		case err != nil:
+			step.Final = true
+			step.Error = fmt.Sprintf("%s referred to %s: %v", server.name, child, err)
+			return t.send(step, resp)
+		case child == "":
+			// An authoritative answer without records, e.g. NODATA
+			step.Final = true
+			return t.send(step, resp)
+		}
+		step.Referral = child
+
+		next := t.nextServers(resp, names)
+		if len(next) == 0 {
+			step.Final = true
+			step.Error = fmt.Sprintf("could not resolve any nameserver for %s", child)
+			return t.send(step, resp)
+		}
+		if err := t.send(step, resp); err != nil {
+			return err
+		}
+		zone, servers = child, next
+	}
+
+	return t.send(TraceStepMessage{
+		Type:    "step",
+		Zone:    zone,
+		Records: []Record{},
+		Final:   true,
+		Error:   fmt.Sprintf("gave up after %d referrals", maxReferrals),
+	}, nil)
+}
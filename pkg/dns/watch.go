@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// Default values for DNS watch options
+const (
+	defaultMinInterval = 5   // Seconds between queries at least, however low the TTL
+	defaultMaxInterval = 300 // Seconds between queries at most, however high the TTL
+	maxHistory         = 100 // Answer sets kept in the history
+)
+
+// Kinds of watch events
+const (
+	EventInitial = "initial" // The first answer set
+	EventChanged = "changed" // The answer set differs from the previous one
+	EventError   = "error"   // The query failed where the previous one did not
+)
+
+// WatchMessage represents the incoming DNS watch request
+type WatchMessage struct {
+	// Required
+	Name string `json:"name"` // Name to watch
+
+	// Optional parameters
+	Type        *string `json:"type,omitempty"`         // Record type, e.g. A, AAAA, MX
+	Server      *string `json:"server,omitempty"`       // Resolver or authoritative server (host or host:port) to query
+	MinInterval *int    `json:"min_interval,omitempty"` // Seconds between queries at least
+	MaxInterval *int    `json:"max_interval,omitempty"` // Seconds between queries at most
+	Timeout     *int    `json:"timeout,omitempty"`      // Timeout per query in seconds
+}
+
+// WatchControlMessage stops a running watch. It may be sent at any time
+// after the WatchMessage.
+type WatchControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// AnswerSet is the answer to one query, as kept in the history
+type AnswerSet struct {
+	Timestamp time.Time `json:"timestamp"`       // Time of the query
+	Rcode     string    `json:"rcode,omitempty"` // Response code, e.g. NOERROR
+	Answers   []string  `json:"answers"`         // Answer records as "name type data", sorted and without TTLs
+	Error     string    `json:"error,omitempty"` // Error when the query failed
+}
+
+// WatchEvent reports an answer set that differs from the previous one
+type WatchEvent struct {
+	Type    string   `json:"type"`    // Message type ("event")
+	Kind    string   `json:"kind"`    // Event kind (initial, changed, error)
+	Added   []string `json:"added"`   // Records in the answer set that were not in the previous one
+	Removed []string `json:"removed"` // Records of the previous answer set that are gone
+	TTL     uint32   `json:"ttl"`     // Lowest TTL of the answer, or the negative caching TTL
+	Next    float64  `json:"next"`    // Seconds until the next query
+	AnswerSet
+}
+
+// WatchSummary reports the history of a finished watch
+type WatchSummary struct {
+	Type       string      `json:"type"`        // Message type ("summary")
+	Name       string      `json:"name"`        // Name that was watched
+	RecordType string      `json:"record_type"` // Record type that was watched
+	Server     string      `json:"server"`      // Server that was queried
+	Queries    int         `json:"queries"`     // Queries sent
+	Changes    int         `json:"changes"`     // Times the answer set changed after the initial one
+	History    []AnswerSet `json:"history"`     // Distinct answer sets in order, the latest 100
+}
+
+// WatchOptions contains the resolved DNS watch options
+type WatchOptions struct {
+	Name        string
+	Type        uint16
+	Server      string
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	Timeout     time.Duration
+}
+
+// resolveWatchOptions converts WatchMessage to WatchOptions with defaults
+func resolveWatchOptions(msg *WatchMessage) (WatchOptions, error) {
+	opts := WatchOptions{
+		Name:        dns.Fqdn(strings.TrimSpace(msg.Name)),
+		Server:      strings.TrimSpace(tool.GetOrDefault(msg.Server, "")),
+		MinInterval: time.Duration(tool.GetOrDefault(msg.MinInterval, defaultMinInterval)) * time.Second,
+		MaxInterval: time.Duration(tool.GetOrDefault(msg.MaxInterval, defaultMaxInterval)) * time.Second,
+		Timeout:     time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+	}
+
+	if _, ok := dns.IsDomainName(opts.Name); !ok || opts.Name == "." {
+		return opts, fmt.Errorf("invalid name %q", msg.Name)
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(tool.GetOrDefault(msg.Type, defaultType))]
+	if !ok {
+		return opts, fmt.Errorf("unsupported record type %q", *msg.Type)
+	}
+	opts.Type = qtype
+	if opts.Server == "" {
+		opts.Server = systemResolver()
+	} else {
+		opts.Server = serverAddress(opts.Server)
+	}
+	if opts.MinInterval <= 0 {
+		return opts, fmt.Errorf("min_interval must be positive")
+	}
+	if opts.MaxInterval < opts.MinInterval {
+		return opts, fmt.Errorf("max_interval must not be smaller than min_interval")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// answerSet queries the server once. The TTL is the lowest of the answer
+// records, or the negative caching TTL of the SOA record for empty answers.
+func answerSet(opts WatchOptions) (AnswerSet, uint32) {
+	set := AnswerSet{Timestamp: time.Now(), Answers: []string{}}
+	query := new(dns.Msg)
+	query.SetQuestion(opts.Name, opts.Type)
+	resp, _, err := exchange(query, opts.Server, opts.Timeout)
+	if err != nil {
+		set.Error = err.Error()
+		return set, 0
+	}
+	set.Rcode = dns.RcodeToString[resp.Rcode]
+
+	var ttl uint32
+	for i, record := range newRecords(resp.Answer) {
+		if i == 0 || record.TTL < ttl {
+			ttl = record.TTL
+		}
+		set.Answers = append(set.Answers, strings.ToLower(record.Name)+" "+record.Type+" "+record.Data)
+	}
+	if len(resp.Answer) == 0 {
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = min(soa.Hdr.Ttl, soa.Minttl)
+			}
+		}
+	}
+	slices.Sort(set.Answers)
+	set.Answers = slices.Compact(set.Answers)
+	return set, ttl
+}
+
+// sameAnswers reports whether two answer sets hold the same result
+func sameAnswers(a, b AnswerSet) bool {
+	return a.Rcode == b.Rcode && a.Error == "" && b.Error == "" && slices.Equal(a.Answers, b.Answers)
+}
+
+// difference returns the records of a that are not in b
+func difference(a, b []string) []string {
+	diff := []string{}
+	for _, record := range a {
+		if !slices.Contains(b, record) {
+			diff = append(diff, record)
+		}
+	}
+	return diff
+}
+
+// Watch queries the record again whenever its TTL runs out, within the
+// interval bounds, and calls report for every answer set that differs from
+// the previous one until ctx is done. Failed queries are reported once, when
+// they start failing. An error returned by report ends the watch.
+func Watch(ctx context.Context, opts WatchOptions, report func(WatchEvent) error) WatchSummary {
+	summary := WatchSummary{
+		Type:       "summary",
+		Name:       opts.Name,
+		RecordType: dns.TypeToString[opts.Type],
+		Server:     opts.Server,
+		History:    []AnswerSet{},
+	}
+
+	var previous *AnswerSet
+	for {
+		set, ttl := answerSet(opts)
+		summary.Queries++
+		next := min(max(time.Duration(ttl)*time.Second, opts.MinInterval), opts.MaxInterval)
+		if set.Error != "" {
+			// Failures are retried at the shortest interval
+			next = opts.MinInterval
+		}
+
+		event := WatchEvent{Type: "event", TTL: ttl, Next: next.Seconds(), AnswerSet: set}
+		switch {
+		case previous == nil:
+			event.Kind = EventInitial
+		case set.Error != "" && previous.Error != "":
+			// Still failing
+		case set.Error != "":
+			event.Kind = EventError
+		case !sameAnswers(set, *previous):
+			event.Kind = EventChanged
+			summary.Changes++
+		}
+		if event.Kind != "" {
+			event.Added, event.Removed = set.Answers, []string{}
+			if previous != nil {
+				event.Added, event.Removed = difference(set.Answers, previous.Answers), difference(previous.Answers, set.Answers)
+			}
+			if len(summary.History) == maxHistory {
+				summary.History = summary.History[1:]
+			}
+			summary.History = append(summary.History, set)
+			if err := report(event); err != nil {
+				return summary
+			}
+		}
+		previous = &set
+
+		select {
+		case <-ctx.Done():
+			return summary
+		case <-time.After(next):
+		}
+	}
+}
+
+// WatchHandler handles WebSocket DNS watch requests. A stop control
+// message, or the client disconnecting, ends a watch.
+func WatchHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "dnswatch")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg WatchMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading dnswatch message: %v", err)
+		return
+	}
+
+	opts, err := resolveWatchOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid dnswatch options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control WatchControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	summary := Watch(ctx, opts, func(event WatchEvent) error {
+		return session.WriteJSON(event)
+	})
+	log.Printf("DNS watch of %s %s: %d queries, %d changes", opts.Name, summary.RecordType, summary.Queries, summary.Changes)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
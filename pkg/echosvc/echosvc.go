@@ -0,0 +1,193 @@
+package echosvc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Limits for the test services
+const (
+	maxDatagramSize = 65535
+	maxConns        = 64              // Concurrent TCP connections per service
+	idleTimeout     = 5 * time.Minute // TCP connections idle this long are closed
+	timestampSize   = 16              // Receive and transmit timestamps appended by the timestamp echo
+	peerRate        = 100             // UDP datagrams answered per peer and second
+	maxPeers        = 10000           // UDP peers counted per second, further peers are not answered
+	reservedPorts   = 1024            // UDP source ports below this are not answered
+)
+
+// connLimiter caps the number of concurrent TCP connections of a service
+type connLimiter struct {
+	mu     sync.Mutex
+	active int
+}
+
+// acquire reserves a connection slot, reporting false when none is free
+func (l *connLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active >= maxConns {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// release frees a slot reserved with acquire
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+}
+
+// idleConn extends the deadline of a connection on every read and write
+type idleConn struct {
+	net.Conn
+}
+
+func (c idleConn) Read(p []byte) (int, error) {
+	c.SetDeadline(time.Now().Add(idleTimeout))
+	return c.Conn.Read(p)
+}
+
+func (c idleConn) Write(p []byte) (int, error) {
+	c.SetDeadline(time.Now().Add(idleTimeout))
+	return c.Conn.Write(p)
+}
+
+// serveTCP accepts connections on addr and handles each with handle
+func serveTCP(name, addr string, handle func(net.Conn)) error {
+	ln, err := tool.ProxyProtocol.Listen(addr)
+	if err != nil {
+		return fmt.Errorf("error starting %s service: %w", name, err)
+	}
+
+	var limiter connLimiter
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("%s service stopped: %v", name, err)
+				return
+			}
+			if !limiter.acquire() {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer limiter.release()
+				defer conn.Close()
+				handle(idleConn{conn})
+			}()
+		}
+	}()
+	return nil
+}
+
+// peerLimiter caps the UDP datagrams answered per peer in each second
+type peerLimiter struct {
+	window time.Time
+	counts map[netip.Addr]int
+}
+
+// allow counts a datagram of peer received at now, reporting false when the
+// peer is over its rate or too many peers were seen in the window
+func (l *peerLimiter) allow(peer netip.Addr, now time.Time) bool {
+	if now.Sub(l.window) >= time.Second {
+		l.window = now
+		clear(l.counts)
+	}
+	count, ok := l.counts[peer]
+	if (!ok && len(l.counts) >= maxPeers) || count >= peerRate {
+		return false
+	}
+	l.counts[peer] = count + 1
+	return true
+}
+
+// serveUDP reads datagrams on addr and answers with the reply built by
+// respond, sending nothing when it returns nil
+func serveUDP(name, addr string, respond func(payload []byte, received time.Time) []byte) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting %s service: %w", name, err)
+	}
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	limiter := peerLimiter{counts: make(map[netip.Addr]int)}
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.Printf("%s service stopped: %v", name, err)
+				return
+			}
+			// Datagrams from well known ports are spoofed or sent by other
+			// services such as echo or chargen, and answering them would
+			// start a loop between the two (CVE-1999-0103)
+			from := peer.(*net.UDPAddr).AddrPort()
+			if from.Port() < reservedPorts || int(from.Port()) == port {
+				continue
+			}
+			received := time.Now()
+			if !limiter.allow(from.Addr().Unmap(), received) {
+				continue
+			}
+			if reply := respond(buf[:n], received); reply != nil {
+				conn.WriteTo(reply, peer)
+			}
+		}
+	}()
+	return nil
+}
+
+// ListenEcho runs the echo service (RFC 862) on TCP and UDP, sending back
+// everything it receives
+func ListenEcho(addr string) error {
+	if err := serveTCP("echo", addr, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	}); err != nil {
+		return err
+	}
+	return serveUDP("echo", addr, func(payload []byte, _ time.Time) []byte {
+		return payload
+	})
+}
+
+// ListenDiscard runs the discard service (RFC 863) on TCP and UDP, throwing
+// away everything it receives
+func ListenDiscard(addr string) error {
+	if err := serveTCP("discard", addr, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	}); err != nil {
+		return err
+	}
+	return serveUDP("discard", addr, func([]byte, time.Time) []byte {
+		return nil
+	})
+}
+
+// ListenTimestampEcho runs a UDP echo service that appends the time each
+// datagram was received and the time the reply was sent, as big endian Unix
+// nanoseconds, so clients can separate the two directions of the round trip
+func ListenTimestampEcho(addr string) error {
+	return serveUDP("timestamp echo", addr, func(payload []byte, received time.Time) []byte {
+		if len(payload) > maxDatagramSize-timestampSize {
+			payload = payload[:maxDatagramSize-timestampSize]
+		}
+		reply := make([]byte, len(payload)+timestampSize)
+		copy(reply, payload)
+		binary.BigEndian.PutUint64(reply[len(payload):], uint64(received.UnixNano()))
+		binary.BigEndian.PutUint64(reply[len(payload)+8:], uint64(time.Now().UnixNano()))
+		return reply
+	})
+}
@@ -0,0 +1,115 @@
+package extract
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPatterns bounds the number of patterns a request can define
+const maxPatterns = 16
+
+// grokPatterns are the named building blocks available as %{NAME} or %{NAME:field}
+var grokPatterns = map[string]string{
+	"INT":          `[+-]?\d+`,
+	"NUMBER":       `[+-]?(?:\d+(?:\.\d+)?|\.\d+)(?:[eE][+-]?\d+)?`,
+	"WORD":         `\w+`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"UUID":         `[0-9A-Fa-f]{8}-(?:[0-9A-Fa-f]{4}-){3}[0-9A-Fa-f]{12}`,
+	"IPV4":         `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":         `[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+`,
+	"IP":           `(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+)`,
+	"HOSTNAME":     `[0-9A-Za-z](?:[0-9A-Za-z-]{0,62})(?:\.[0-9A-Za-z](?:[0-9A-Za-z-]{0,62}))*\.?`,
+}
+
+// grokReference matches %{NAME} and %{NAME:field} in a grok expression
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// Extractor pulls named values out of probe output
+type Extractor struct {
+	patterns []*regexp.Regexp
+}
+
+// expandGrok turns a grok expression into a regular expression
+func expandGrok(pattern string) (string, error) {
+	var missing string
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		re, ok := grokPatterns[m[1]]
+		if !ok {
+			missing = m[1]
+			return ref
+		}
+		if m[2] != "" {
+			return "(?P<" + m[2] + ">" + re + ")"
+		}
+		return "(?:" + re + ")"
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown grok pattern %q", missing)
+	}
+	return expanded, nil
+}
+
+// Compile builds an extractor from regular expressions with named groups or
+// grok expressions. It returns nil when no patterns are given.
+func Compile(patterns []string) (*Extractor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if len(patterns) > maxPatterns {
+		return nil, fmt.Errorf("at most %d extract patterns are allowed", maxPatterns)
+	}
+
+	e := &Extractor{}
+	for _, pattern := range patterns {
+		expr := pattern
+		if strings.Contains(pattern, "%{") {
+			var err error
+			if expr, err = expandGrok(pattern); err != nil {
+				return nil, err
+			}
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extract pattern %q: %w", pattern, err)
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			named = named || name != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("extract pattern %q has no named fields", pattern)
+		}
+		e.patterns = append(e.patterns, re)
+	}
+	return e, nil
+}
+
+// Extract applies every pattern to text and returns the named fields of the
+// first match of each. Values that parse as numbers are returned as float64.
+func (e *Extractor) Extract(text string) map[string]any {
+	values := make(map[string]any)
+	for _, re := range e.patterns {
+		m := re.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name == "" || i >= len(m) {
+				continue
+			}
+			if n, err := strconv.ParseFloat(m[i], 64); err == nil {
+				values[name] = n
+			} else {
+				values[name] = m[i]
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,470 @@
+package httpbench
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values and limits of HTTP benchmarks
+const (
+	defaultRequests    = 200
+	defaultConcurrency = 10
+	defaultTimeout     = 10 // Seconds per request
+	maxRequests        = 1000000
+	maxConcurrency     = 512
+	maxDuration        = 10 * time.Minute
+	maxRate            = 100000
+	maxTimeout         = 60
+	maxRequestBody     = 1 << 20
+	progressInterval   = time.Second
+)
+
+// BenchMessage represents the incoming HTTP benchmark request
+type BenchMessage struct {
+	// Required
+	URL string `json:"url"` // http:// or https:// URL to request
+
+	// Optional parameters
+	Method            *string           `json:"method,omitempty"`             // Request method (-m), GET by default
+	Headers           map[string]string `json:"headers,omitempty"`            // Request headers (-H)
+	Body              *string           `json:"body,omitempty"`               // Request body (-d)
+	Requests          *int              `json:"requests,omitempty"`           // Requests to send (-n), 200 unless duration is set
+	Concurrency       *int              `json:"concurrency,omitempty"`        // Requests in flight at once (-c)
+	Duration          *tool.Duration    `json:"duration,omitempty"`           // Send requests for this long instead of a count (-z)
+	Rate              *float64          `json:"rate,omitempty"`               // Most requests per second over all workers, 0 for no limit
+	Timeout           *int              `json:"timeout,omitempty"`            // Timeout in seconds per request (-t)
+	Insecure          *bool             `json:"insecure,omitempty"`           // Skip TLS certificate verification
+	DisableKeepAlives *bool             `json:"disable_keepalives,omitempty"` // Open a new connection for every request
+}
+
+// BenchControlMessage stops a running benchmark. It may be sent at any time
+// after the BenchMessage.
+type BenchControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// ProgressMessage is sent every second while the benchmark runs
+type ProgressMessage struct {
+	Type      string    `json:"type"`      // Message type ("progress")
+	Timestamp time.Time `json:"timestamp"` // Time of the report
+	Elapsed   float64   `json:"elapsed"`   // Seconds since the benchmark started
+	Requests  int       `json:"requests"`  // Requests completed so far
+	Errors    int       `json:"errors"`    // Requests that got no response so far
+	RPS       float64   `json:"rps"`       // Requests completed per second since the last report
+	Latency   float64   `json:"latency"`   // Mean latency of the responses since the last report in milliseconds
+}
+
+// Latency is the latency distribution of the responses in milliseconds
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// SummaryMessage reports the whole benchmark
+type SummaryMessage struct {
+	Type        string         `json:"type"`         // Message type ("summary")
+	Timestamp   time.Time      `json:"timestamp"`    // When the benchmark ended
+	URL         string         `json:"url"`          // URL requested
+	Requests    int            `json:"requests"`     // Requests completed
+	Responses   int            `json:"responses"`    // Requests that got a response, whatever its status
+	Errors      int            `json:"errors"`       // Requests that got no response
+	Duration    float64        `json:"duration"`     // Seconds from the first request to the last response
+	RPS         float64        `json:"rps"`          // Requests completed per second
+	Bytes       int64          `json:"bytes"`        // Response body bytes read
+	Latency     Latency        `json:"latency"`      // Latency of the responses, body included
+	Status      map[int]int    `json:"status"`       // Responses by status code
+	ErrorCounts map[string]int `json:"error_counts"` // Errors by message
+	Stopped     bool           `json:"stopped"`      // Whether the client stopped the benchmark early
+}
+
+// BenchOptions contains the resolved benchmark options
+type BenchOptions struct {
+	URL               string
+	Method            string
+	Headers           map[string]string
+	Body              string
+	Requests          int
+	Concurrency       int
+	Duration          time.Duration
+	Rate              float64
+	Timeout           time.Duration
+	IsInsecure        bool
+	DisableKeepAlives bool
+}
+
+// resolveBenchOptions converts BenchMessage to BenchOptions with defaults
+func resolveBenchOptions(msg *BenchMessage) (BenchOptions, error) {
+	opts := BenchOptions{
+		URL:               strings.TrimSpace(msg.URL),
+		Method:            strings.ToUpper(strings.TrimSpace(tool.GetOrDefault(msg.Method, http.MethodGet))),
+		Headers:           msg.Headers,
+		Body:              tool.GetOrDefault(msg.Body, ""),
+		Requests:          tool.GetOrDefault(msg.Requests, defaultRequests),
+		Concurrency:       tool.GetOrDefault(msg.Concurrency, defaultConcurrency),
+		Duration:          time.Duration(tool.GetOrDefault(msg.Duration, 0)),
+		Rate:              tool.GetOrDefault(msg.Rate, 0),
+		Timeout:           time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+		IsInsecure:        tool.GetOrDefault(msg.Insecure, false),
+		DisableKeepAlives: tool.GetOrDefault(msg.DisableKeepAlives, false),
+	}
+
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return opts, fmt.Errorf("invalid url %q, want an http:// or https:// URL", msg.URL)
+	}
+	if opts.Method == "" || strings.ContainsAny(opts.Method, " \t\r\n") {
+		return opts, fmt.Errorf("invalid method %q", opts.Method)
+	}
+	for name, value := range opts.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return opts, fmt.Errorf("invalid header %q", name)
+		}
+	}
+	if len(opts.Body) > maxRequestBody {
+		return opts, fmt.Errorf("body must be at most %d bytes", maxRequestBody)
+	}
+	if msg.Duration != nil {
+		if msg.Requests != nil {
+			return opts, fmt.Errorf("requests and duration cannot both be set")
+		}
+		if opts.Duration <= 0 || opts.Duration > maxDuration {
+			return opts, fmt.Errorf("duration must be positive and at most %s", maxDuration)
+		}
+		opts.Requests = maxRequests
+	}
+	if opts.Requests <= 0 || opts.Requests > maxRequests {
+		return opts, fmt.Errorf("requests must be between 1 and %d", maxRequests)
+	}
+	if opts.Concurrency <= 0 || opts.Concurrency > maxConcurrency {
+		return opts, fmt.Errorf("concurrency must be between 1 and %d", maxConcurrency)
+	}
+	if opts.Rate < 0 || opts.Rate > maxRate {
+		return opts, fmt.Errorf("rate must be between 0 and %d requests per second", maxRate)
+	}
+	if opts.Timeout <= 0 || opts.Timeout > maxTimeout*time.Second {
+		return opts, fmt.Errorf("timeout must be between 1 and %d seconds", maxTimeout)
+	}
+	return opts, nil
+}
+
+// result is the outcome of one request
+type result struct {
+	latency float64 // Milliseconds, body included
+	status  int
+	bytes   int64
+	err     error
+}
+
+// stats collects the results of the workers
+type stats struct {
+	mu        sync.Mutex
+	latencies []float64
+	status    map[int]int
+	errors    map[string]int
+	errored   int
+	bytes     int64
+
+	// Since the last progress report
+	interval          int
+	intervalResponses int
+	intervalLatency   float64
+}
+
+// add records a result
+func (s *stats) add(r result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval++
+	if r.err != nil {
+		s.errored++
+		s.errors[r.err.Error()]++
+		return
+	}
+	s.latencies = append(s.latencies, r.latency)
+	s.status[r.status]++
+	s.bytes += r.bytes
+	s.intervalResponses++
+	s.intervalLatency += r.latency
+}
+
+// progress returns the report of the interval since the last one, which
+// lasted seconds, and starts the next
+func (s *stats) progress(elapsed, seconds float64) ProgressMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := ProgressMessage{
+		Type:      "progress",
+		Timestamp: time.Now(),
+		Elapsed:   round(elapsed),
+		Requests:  len(s.latencies) + s.errored,
+		Errors:    s.errored,
+		RPS:       round(float64(s.interval) / seconds),
+	}
+	if s.intervalResponses > 0 {
+		msg.Latency = round(s.intervalLatency / float64(s.intervalResponses))
+	}
+	s.interval, s.intervalResponses, s.intervalLatency = 0, 0, 0
+	return msg
+}
+
+// round rounds to microseconds when applied to milliseconds, and keeps rates
+// and seconds readable
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// percentile returns the p-th percentile of sorted values by the nearest
+// rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// latency returns the distribution of latencies
+func latency(latencies []float64) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sort.Float64s(latencies)
+	var sum float64
+	for _, l := range latencies {
+		sum += l
+	}
+	return Latency{
+		Min:  latencies[0],
+		Mean: round(sum / float64(len(latencies))),
+		P50:  percentile(latencies, 50),
+		P90:  percentile(latencies, 90),
+		P99:  percentile(latencies, 99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// newClient returns the client the workers share, keeping a connection per
+// worker open unless keep-alives are disabled
+func newClient(opts BenchOptions) *http.Client {
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: opts.IsInsecure},
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        opts.Concurrency,
+			MaxIdleConnsPerHost: opts.Concurrency,
+			DisableKeepAlives:   opts.DisableKeepAlives,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// send performs one request and reads its response body
+func send(ctx context.Context, client *http.Client, opts BenchOptions) result {
+	body := io.Reader(http.NoBody)
+	if opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, opts.Method, opts.URL, body)
+	if err != nil {
+		return result{err: err}
+	}
+	tool.Identify(req)
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return result{err: err}
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return result{err: fmt.Errorf("error reading body: %w", err)}
+	}
+	return result{latency: float64(time.Since(start).Microseconds()) / 1000.0, status: resp.StatusCode, bytes: n}
+}
+
+// dispatch hands out opts.Requests requests to the workers, or as many as
+// they take within opts.Duration, no faster than opts.Rate, until ctx is done
+func dispatch(ctx context.Context, opts BenchOptions, jobs chan<- struct{}) {
+	defer close(jobs)
+	var tick, end <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	if opts.Duration > 0 {
+		timer := time.NewTimer(opts.Duration)
+		defer timer.Stop()
+		end = timer.C
+	}
+	for i := 0; i < opts.Requests; i++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-end:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case jobs <- struct{}{}:
+		case <-end:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Bench runs the benchmark of opts with opts.Concurrency workers until every
+// request is done, the duration is over or ctx is done. report is called
+// with the progress every second; an error from it stops the benchmark.
+// count is called for every request sent. Requests cut off by stopping are
+// not counted in the results.
+func Bench(ctx context.Context, opts BenchOptions, report func(ProgressMessage) error, count func()) SummaryMessage {
+	summary := SummaryMessage{Type: "summary", URL: opts.URL}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client := newClient(opts)
+	defer client.CloseIdleConnections()
+
+	s := &stats{status: make(map[int]int), errors: make(map[string]int)}
+	jobs := make(chan struct{})
+	go dispatch(ctx, opts, jobs)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(opts.Concurrency, opts.Requests) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				count()
+				r := send(ctx, client, opts)
+				if ctx.Err() != nil {
+					continue
+				}
+				s.add(r)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	last := start
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case now := <-ticker.C:
+			if ctx.Err() != nil {
+				continue
+			}
+			if err := report(s.progress(now.Sub(start).Seconds(), now.Sub(last).Seconds())); err != nil {
+				log.Printf("Stopping benchmark of %s: %v", opts.URL, err)
+				cancel()
+			}
+			last = now
+		}
+	}
+
+	elapsed := time.Since(start)
+	summary.Timestamp = time.Now()
+	summary.Stopped = ctx.Err() != nil
+	summary.Duration = round(elapsed.Seconds())
+	summary.Responses = len(s.latencies)
+	summary.Errors = s.errored
+	summary.Requests = summary.Responses + summary.Errors
+	summary.RPS = round(float64(summary.Requests) / elapsed.Seconds())
+	summary.Bytes = s.bytes
+	summary.Latency = latency(s.latencies)
+	summary.Status = s.status
+	summary.ErrorCounts = s.errors
+	return summary
+}
+
+// Handler handles WebSocket HTTP benchmark requests. A stop control
+// message, or the client disconnecting, ends a run early.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "bench")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg BenchMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading bench message: %v", err)
+		return
+	}
+	opts, err := resolveBenchOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid bench options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control BenchControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	summary := Bench(ctx, opts, func(progress ProgressMessage) error {
+		return session.WriteJSON(progress)
+	}, session.CountProbe)
+	log.Printf("Benchmark of %s: %d requests in %.1fs, %.1f/s, p99 %.1fms, %d errors", opts.URL, summary.Requests, summary.Duration, summary.RPS, summary.Latency.P99, summary.Errors)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
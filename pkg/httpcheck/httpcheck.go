@@ -0,0 +1,288 @@
+package httpcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values and limits of HTTP checks
+const (
+	defaultTimeout = 10 // Seconds for the whole exchange, body included
+	maxTimeout     = 60
+	maxRedirects   = 10
+	maxRequestBody = 1 << 20 // Largest request body sent
+)
+
+// CheckRequest is the body of an HTTP check
+type CheckRequest struct {
+	// Required
+	URL string `json:"url"` // http:// or https:// URL to request
+
+	// Optional parameters
+	Method   *string           `json:"method,omitempty"`   // Request method (-X), GET by default
+	Headers  map[string]string `json:"headers,omitempty"`  // Request headers (-H), overriding the default User-Agent
+	Body     *string           `json:"body,omitempty"`     // Request body (-d)
+	Timeout  *int              `json:"timeout,omitempty"`  // Timeout of the whole exchange in seconds (-m)
+	Follow   *bool             `json:"follow,omitempty"`   // Follow redirects (-L)
+	Insecure *bool             `json:"insecure,omitempty"` // Skip certificate verification (-k)
+}
+
+// CheckOptions contains the resolved options of a check
+type CheckOptions struct {
+	URL      *url.URL
+	Method   string
+	Headers  http.Header
+	Body     string
+	Timeout  time.Duration
+	Follow   bool
+	Insecure bool
+}
+
+// Timing is the breakdown of an exchange in milliseconds, like the time_*
+// variables of curl -w but per phase instead of cumulative. Phases that did
+// not happen, such as TLS for http:// URLs, are 0.
+type Timing struct {
+	DNS      float64 `json:"dns"`      // Resolving the host
+	Connect  float64 `json:"connect"`  // TCP handshake
+	TLS      float64 `json:"tls"`      // TLS handshake
+	TTFB     float64 `json:"ttfb"`     // From the request being sent to the first response byte
+	Transfer float64 `json:"transfer"` // From the first response byte to the end of the body
+	Total    float64 `json:"total"`    // From the start of the final request to the end of the body
+}
+
+// Redirect is a response that was followed
+type Redirect struct {
+	URL    string `json:"url"`    // URL that answered with the redirect
+	Status int    `json:"status"` // Status code of the redirect
+}
+
+// CheckResult is the outcome of an HTTP check; the response fields describe
+// the final response when redirects were followed
+type CheckResult struct {
+	URL        string              `json:"url"`                   // URL of the final request
+	Method     string              `json:"method"`                // Method of the request
+	Status     int                 `json:"status,omitempty"`      // Status code
+	StatusText string              `json:"status_text,omitempty"` // Status line text, e.g. 200 OK
+	Protocol   string              `json:"protocol,omitempty"`    // Negotiated protocol, e.g. HTTP/2.0
+	TLSVersion string              `json:"tls_version,omitempty"` // TLS version, for https:// URLs
+	TLSCipher  string              `json:"tls_cipher,omitempty"`  // TLS cipher suite, for https:// URLs
+	RemoteAddr string              `json:"remote_addr,omitempty"` // Address connected to
+	Headers    map[string][]string `json:"headers,omitempty"`     // Every response header
+	BodySize   int64               `json:"body_size"`             // Bytes of the response body as sent, before decompression
+	Redirects  []Redirect          `json:"redirects,omitempty"`   // Redirects followed on the way
+	Timing     Timing              `json:"timing"`                // Breakdown of the final request
+	Error      string              `json:"error,omitempty"`       // Error that ended the check
+}
+
+// resolveCheckOptions converts CheckRequest to CheckOptions with defaults
+func resolveCheckOptions(req *CheckRequest) (CheckOptions, error) {
+	opts := CheckOptions{
+		Method:   strings.ToUpper(strings.TrimSpace(tool.GetOrDefault(req.Method, http.MethodGet))),
+		Headers:  make(http.Header),
+		Body:     tool.GetOrDefault(req.Body, ""),
+		Timeout:  time.Duration(tool.GetOrDefault(req.Timeout, defaultTimeout)) * time.Second,
+		Follow:   tool.GetOrDefault(req.Follow, false),
+		Insecure: tool.GetOrDefault(req.Insecure, false),
+	}
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return opts, fmt.Errorf("invalid url %q, want an http:// or https:// URL", req.URL)
+	}
+	opts.URL = u
+	if opts.Method == "" || strings.ContainsAny(opts.Method, " \t\r\n") {
+		return opts, fmt.Errorf("invalid method %q", opts.Method)
+	}
+	if opts.Timeout <= 0 || opts.Timeout > maxTimeout*time.Second {
+		return opts, fmt.Errorf("timeout must be between 1 and %d seconds", maxTimeout)
+	}
+	if len(opts.Body) > maxRequestBody {
+		return opts, fmt.Errorf("body must be at most %d bytes", maxRequestBody)
+	}
+	for name, value := range req.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return opts, fmt.Errorf("invalid header %q", name)
+		}
+		opts.Headers.Set(name, value)
+	}
+	return opts, nil
+}
+
+// milliseconds returns the time from start to end in milliseconds, or 0 when
+// either was not reached
+func milliseconds(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000.0
+}
+
+// tracer records when the phases of the latest request started and ended.
+// A followed redirect starts a new request, which resets it. Hooks run on
+// the goroutines of the transport, so fields are guarded by mu.
+type tracer struct {
+	mu     sync.Mutex
+	phases phases
+}
+
+// phases are the times the phases of a request started and ended
+type phases struct {
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, wrote, firstByte                 time.Time
+	remote                                              string // Address connected to
+}
+
+// mark sets a phase time to now
+func (t *tracer) mark(phase *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*phase = time.Now()
+}
+
+// trace returns the client trace feeding t
+func (t *tracer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.phases = phases{start: time.Now()}
+		},
+		DNSStart:          func(httptrace.DNSStartInfo) { t.mark(&t.phases.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.mark(&t.phases.dnsDone) },
+		ConnectStart:      func(string, string) { t.mark(&t.phases.connectStart) },
+		ConnectDone:       func(string, string, error) { t.mark(&t.phases.connectDone) },
+		TLSHandshakeStart: func() { t.mark(&t.phases.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.mark(&t.phases.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.phases.remote = info.Conn.RemoteAddr().String()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.phases.wrote) },
+		GotFirstResponseByte: func() { t.mark(&t.phases.firstByte) },
+	}
+}
+
+// timing returns the breakdown of the latest request, whose body was read
+// until end, and the address it connected to
+func (t *tracer) timing(end time.Time) (Timing, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.phases
+	return Timing{
+		DNS:      milliseconds(p.dnsStart, p.dnsDone),
+		Connect:  milliseconds(p.connectStart, p.connectDone),
+		TLS:      milliseconds(p.tlsStart, p.tlsDone),
+		TTFB:     milliseconds(p.wrote, p.firstByte),
+		Transfer: milliseconds(p.firstByte, end),
+		Total:    milliseconds(p.start, end),
+	}, p.remote
+}
+
+// Check performs the request of opts on a connection of its own, so every
+// phase is measured, and reads the whole response body
+func Check(ctx context.Context, opts CheckOptions) CheckResult {
+	result := CheckResult{URL: opts.URL.String(), Method: opts.Method}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	// Compression is left to the server and the client, so the body size is
+	// what went over the wire
+	transport := &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
+		DialContext:        (&net.Dialer{}).DialContext,
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: opts.Insecure},
+		ForceAttemptHTTP2:  true,
+		DisableCompression: true,
+		DisableKeepAlives:  true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !opts.Follow {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			result.Redirects = append(result.Redirects, Redirect{URL: req.Response.Request.URL.String(), Status: req.Response.StatusCode})
+			return nil
+		},
+	}
+
+	var t tracer
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, t.trace()), opts.Method, opts.URL.String(), strings.NewReader(opts.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if opts.Body == "" {
+		req.Body, req.ContentLength = http.NoBody, 0
+	}
+	tool.Identify(req)
+	for name, values := range opts.Headers {
+		req.Header[name] = values
+	}
+	if host := opts.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Timing, result.RemoteAddr = t.timing(time.Now())
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result.BodySize, err = io.Copy(io.Discard, resp.Body)
+	end := time.Now()
+	if err != nil {
+		result.Error = fmt.Sprintf("error reading body: %v", err)
+	}
+
+	result.URL = resp.Request.URL.String()
+	result.Status = resp.StatusCode
+	result.StatusText = resp.Status
+	result.Protocol = resp.Proto
+	result.Headers = resp.Header
+	if resp.TLS != nil {
+		result.TLSVersion = tls.VersionName(resp.TLS.Version)
+		result.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+	result.Timing, result.RemoteAddr = t.timing(end)
+	return result
+}
+
+// Handler serves POST /api/httpcheck, performing the HTTP request described
+// by the body and reporting the response and its timing. Failed requests
+// are answered with 200 and the error in the result, as they are a finding
+// rather than a fault of the check.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var req CheckRequest
+	if err := tool.DecodeRequest(w, r, &req); err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := resolveCheckOptions(&req)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	tool.WriteJSON(w, http.StatusOK, Check(r.Context(), opts))
+}
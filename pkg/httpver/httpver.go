@@ -0,0 +1,437 @@
+package httpver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// Default values for HTTP version probe options
+const (
+	defaultPort    = 443
+	defaultH2CPort = 80
+	defaultPath    = "/"
+	defaultTimeout = 5 // 5 second timeout per check
+	maxBodySize    = 64 * 1024
+)
+
+// Protocols probed
+const (
+	ProtocolH2  = "h2"  // HTTP/2 over TLS, negotiated with ALPN
+	ProtocolH2C = "h2c" // Cleartext HTTP/2 with prior knowledge
+	ProtocolH3  = "h3"  // HTTP/3 over QUIC
+)
+
+// HTTPVersionMessage represents the incoming HTTP version probe request
+type HTTPVersionMessage struct {
+	// Required
+	Host string `json:"host"` // Host to probe, optionally with a port
+
+	// Optional parameters
+	Port     *int    `json:"port,omitempty"`     // TLS and QUIC port, 443 unless host has one
+	H2CPort  *int    `json:"h2c_port,omitempty"` // Cleartext port for h2c, 80 by default
+	Path     *string `json:"path,omitempty"`     // Path requested, / by default
+	Timeout  *int    `json:"timeout,omitempty"`  // Timeout in seconds per check
+	Insecure *bool   `json:"insecure,omitempty"` // Skip TLS certificate verification
+}
+
+// ZeroRTT reports a resumed QUIC connection sending its request as early data
+type ZeroRTT struct {
+	Resumed   bool    `json:"resumed"`         // Whether the TLS session was resumed
+	Accepted  bool    `json:"accepted"`        // Whether the server accepted the 0-RTT data
+	Handshake float64 `json:"handshake"`       // Resumed handshake in milliseconds
+	Error     string  `json:"error,omitempty"` // Error of the resumed connection
+}
+
+// ProtocolMessage reports the check of one protocol
+type ProtocolMessage struct {
+	Type      string   `json:"type"`                // Message type ("protocol")
+	Protocol  string   `json:"protocol"`            // Protocol checked ("h2", "h2c" or "h3")
+	Address   string   `json:"address"`             // Address connected to
+	Supported bool     `json:"supported"`           // Whether a response came back over the protocol
+	ALPN      string   `json:"alpn,omitempty"`      // Protocol negotiated with ALPN, for h2 and h3
+	Status    int      `json:"status,omitempty"`    // HTTP status code of the response
+	Connect   float64  `json:"connect,omitempty"`   // TCP connect in milliseconds, for h2 and h2c
+	Handshake float64  `json:"handshake,omitempty"` // TLS handshake for h2, QUIC handshake for h3, in milliseconds
+	TTFB      float64  `json:"ttfb,omitempty"`      // From the request to the response headers in milliseconds
+	AltSvc    string   `json:"alt_svc,omitempty"`   // Alt-Svc header of the response
+	ZeroRTT   *ZeroRTT `json:"zero_rtt,omitempty"`  // Resumed connection, for h3
+	Error     string   `json:"error,omitempty"`     // Why the protocol is not supported
+}
+
+// SummaryMessage lists the protocols the server supports
+type SummaryMessage struct {
+	Type         string   `json:"type"`          // Message type ("summary")
+	Host         string   `json:"host"`          // Host probed
+	Protocols    []string `json:"protocols"`     // Protocols supported
+	H3Advertised bool     `json:"h3_advertised"` // Whether Alt-Svc advertised h3
+	ZeroRTT      bool     `json:"zero_rtt"`      // Whether the server accepted 0-RTT over QUIC
+}
+
+// HTTPVersionOptions contains the resolved HTTP version probe options
+type HTTPVersionOptions struct {
+	Host       string
+	Address    string // Host and TLS port
+	H2CAddress string // Host and cleartext port
+	SNI        string
+	Path       string
+	Timeout    time.Duration
+	IsInsecure bool
+}
+
+// resolveHTTPVersionOptions converts HTTPVersionMessage to
+// HTTPVersionOptions with defaults
+func resolveHTTPVersionOptions(msg *HTTPVersionMessage) (HTTPVersionOptions, error) {
+	opts := HTTPVersionOptions{
+		Host:       strings.TrimSpace(msg.Host),
+		Path:       tool.GetOrDefault(msg.Path, defaultPath),
+		Timeout:    time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+		IsInsecure: tool.GetOrDefault(msg.Insecure, false),
+	}
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	host, port := opts.Host, tool.GetOrDefault(msg.Port, defaultPort)
+	if h, p, err := net.SplitHostPort(opts.Host); err == nil {
+		if msg.Port != nil {
+			return opts, fmt.Errorf("port cannot be set when host has one")
+		}
+		if port, err = strconv.Atoi(p); err != nil {
+			return opts, fmt.Errorf("invalid port %q", p)
+		}
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	h2cPort := tool.GetOrDefault(msg.H2CPort, defaultH2CPort)
+	if port <= 0 || port > 65535 || h2cPort <= 0 || h2cPort > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535")
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		return opts, fmt.Errorf("path must start with /")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	opts.Host = host
+	opts.Address = net.JoinHostPort(host, strconv.Itoa(port))
+	opts.H2CAddress = net.JoinHostPort(host, strconv.Itoa(h2cPort))
+	if net.ParseIP(host) == nil {
+		opts.SNI = host
+	}
+	return opts, nil
+}
+
+// milliseconds returns d in milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// newRequest returns the request for the path of opts at origin sent over
+// every protocol. It is sent on connections already open, which may be to
+// another address advertised by Alt-Svc.
+func newRequest(ctx context.Context, opts HTTPVersionOptions, scheme, origin string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+origin+opts.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	tool.Identify(req)
+	return req, nil
+}
+
+// roundTrip sends the request and reads the response, recording its status
+// and time to first byte
+func roundTrip(rt http.RoundTripper, req *http.Request, result *ProtocolMessage) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.RoundTrip(req)
+	result.TTFB = milliseconds(time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+	resp.Body.Close()
+	result.Status = resp.StatusCode
+	return resp, nil
+}
+
+// checkH2 connects over TLS offering h2 and http/1.1 and sends a request over
+// whichever the server picked, so the Alt-Svc header is read either way
+func checkH2(ctx context.Context, opts HTTPVersionOptions) ProtocolMessage {
+	result := ProtocolMessage{Type: "protocol", Protocol: ProtocolH2, Address: opts.Address}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	raw, err := (&net.Dialer{}).DialContext(ctx, "tcp", opts.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Connect = milliseconds(time.Since(start))
+	conn := tls.Client(raw, &tls.Config{
+		ServerName:         opts.SNI,
+		NextProtos:         []string{ProtocolH2, "http/1.1"},
+		InsecureSkipVerify: opts.IsInsecure,
+	})
+	start = time.Now()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		result.Error = err.Error()
+		return result
+	}
+	result.Handshake = milliseconds(time.Since(start))
+	result.ALPN = conn.ConnectionState().NegotiatedProtocol
+	result.Supported = result.ALPN == ProtocolH2
+
+	var rt http.RoundTripper
+	if result.Supported {
+		h2, err := (&http2.Transport{}).NewClientConn(conn)
+		if err != nil {
+			conn.Close()
+			result.Supported, result.Error = false, err.Error()
+			return result
+		}
+		defer h2.Close()
+		rt = h2
+	} else {
+		result.Error = "server did not select h2 with ALPN"
+		// The handshaken connection is handed to a one-off HTTP/1.1 transport
+		transport := &http.Transport{
+			DialTLSContext: func(context.Context, string, string) (net.Conn, error) {
+				return conn, nil
+			},
+			DisableKeepAlives: true,
+		}
+		defer transport.CloseIdleConnections()
+		rt = transport
+	}
+	req, err := newRequest(ctx, opts, "https", opts.Address)
+	if err != nil {
+		conn.Close()
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := roundTrip(rt, req, &result)
+	if err != nil {
+		conn.Close()
+		if result.Supported {
+			result.Supported, result.Error = false, err.Error()
+		}
+		return result
+	}
+	result.AltSvc = resp.Header.Get("Alt-Svc")
+	return result
+}
+
+// checkH2C sends a cleartext HTTP/2 request with prior knowledge, which
+// servers only speaking HTTP/1.1 answer with an error or by closing
+func checkH2C(ctx context.Context, opts HTTPVersionOptions) ProtocolMessage {
+	result := ProtocolMessage{Type: "protocol", Protocol: ProtocolH2C, Address: opts.H2CAddress}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", opts.H2CAddress)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Connect = milliseconds(time.Since(start))
+	h2c, err := (&http2.Transport{AllowHTTP: true}).NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		result.Error = err.Error()
+		return result
+	}
+	defer h2c.Close()
+	req, err := newRequest(ctx, opts, "http", opts.H2CAddress)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if _, err := roundTrip(h2c, req, &result); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Supported = true
+	return result
+}
+
+// altSvcH3 returns the authority an Alt-Svc header advertises h3 on, such as
+// ":443", and whether it advertises h3 at all
+func altSvcH3(header string) (string, bool) {
+	for _, entry := range strings.Split(header, ",") {
+		alternative, _, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		protocol, authority, ok := strings.Cut(alternative, "=")
+		if ok && strings.TrimSpace(protocol) == ProtocolH3 {
+			return strings.Trim(strings.TrimSpace(authority), `"`), true
+		}
+	}
+	return "", false
+}
+
+// h3Address returns the address to probe h3 on: the authority advertised by
+// Alt-Svc, defaulting to the host, or the TLS address when none is
+func h3Address(opts HTTPVersionOptions, authority string) string {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return opts.Address
+	}
+	if host == "" {
+		host = opts.Host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// quicHandshake completes a QUIC handshake with address and times it
+func quicHandshake(ctx context.Context, address string, tlsConf *tls.Config, timeout time.Duration) (quic.EarlyConnection, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := quic.DialAddrEarly(ctx, address, tlsConf, &quic.Config{HandshakeIdleTimeout: timeout})
+	if err != nil {
+		return nil, 0, err
+	}
+	select {
+	case <-conn.HandshakeComplete():
+		return conn, time.Since(start), nil
+	case <-ctx.Done():
+		conn.CloseWithError(0, "")
+		return nil, 0, ctx.Err()
+	}
+}
+
+// checkH3 completes a QUIC handshake offering h3 and sends a request over
+// it. When that succeeds, a second connection resumes the TLS session and
+// sends its request as 0-RTT data.
+func checkH3(ctx context.Context, opts HTTPVersionOptions, address string) ProtocolMessage {
+	result := ProtocolMessage{Type: "protocol", Protocol: ProtocolH3, Address: address}
+	tlsConf := &tls.Config{
+		ServerName:         opts.SNI,
+		NextProtos:         []string{http3.NextProtoH3},
+		InsecureSkipVerify: opts.IsInsecure,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	transport := &http3.Transport{}
+	defer transport.Close()
+
+	conn, handshake, err := quicHandshake(ctx, address, tlsConf, opts.Timeout)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Handshake = milliseconds(handshake)
+	result.ALPN = conn.ConnectionState().TLS.NegotiatedProtocol
+	reqCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := newRequest(reqCtx, opts, "https", opts.Address)
+	if err == nil {
+		_, err = roundTrip(transport.NewClientConn(conn), req, &result)
+	}
+	conn.CloseWithError(0, "")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Supported = true
+
+	result.ZeroRTT = zeroRTT(ctx, opts, address, tlsConf, transport)
+	return result
+}
+
+// zeroRTT resumes the TLS session of a previous connection to address and
+// sends the request before the handshake completes, which goes out as 0-RTT
+// data when the session allows it
+func zeroRTT(ctx context.Context, opts HTTPVersionOptions, address string, tlsConf *tls.Config, transport *http3.Transport) *ZeroRTT {
+	result := &ZeroRTT{}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := quic.DialAddrEarly(ctx, address, tlsConf, &quic.Config{HandshakeIdleTimeout: opts.Timeout})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.CloseWithError(0, "")
+	req, err := newRequest(ctx, opts, "https", opts.Address)
+	if err == nil {
+		_, err = roundTrip(transport.NewClientConn(conn), req, &ProtocolMessage{})
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	select {
+	case <-conn.HandshakeComplete():
+	case <-ctx.Done():
+		result.Error = ctx.Err().Error()
+		return result
+	}
+	result.Handshake = milliseconds(time.Since(start))
+	state := conn.ConnectionState()
+	result.Resumed = state.TLS.DidResume
+	result.Accepted = state.Used0RTT
+	return result
+}
+
+// Handler handles WebSocket HTTP version probe requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "httpver")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg HTTPVersionMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading httpver message: %v", err)
+		return
+	}
+
+	opts, err := resolveHTTPVersionOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid httpver options: %v", err)
+		return
+	}
+
+	summary := SummaryMessage{Type: "summary", Host: opts.Host, Protocols: []string{}}
+	h2 := checkH2(r.Context(), opts)
+	authority, advertised := altSvcH3(h2.AltSvc)
+	summary.H3Advertised = advertised
+	checks := []func() ProtocolMessage{
+		func() ProtocolMessage { return h2 },
+		func() ProtocolMessage { return checkH2C(r.Context(), opts) },
+		func() ProtocolMessage { return checkH3(r.Context(), opts, h3Address(opts, authority)) },
+	}
+	for _, check := range checks {
+		session.CountProbe()
+		result := check()
+		if result.Supported {
+			summary.Protocols = append(summary.Protocols, result.Protocol)
+		}
+		if result.ZeroRTT != nil && result.ZeroRTT.Accepted {
+			summary.ZeroRTT = true
+		}
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send protocol: %v", err)
+			return
+		}
+	}
+
+	log.Printf("HTTP versions of %s: %v, h3 advertised=%t", opts.Host, summary.Protocols, summary.H3Advertised)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package inbound
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Limits for the inbound probe observer
+const (
+	maxPacketSize   = 65535
+	maxSources      = 10000 // Distinct sources tracked before new ones are only counted
+	subscriberQueue = 256   // Events buffered per live subscriber
+	protocolICMP    = 1
+	tracerouteFirst = 33434 // First UDP port used by classic traceroute
+	tracerouteLast  = 33534 // Last UDP port used by classic traceroute
+)
+
+// Kinds of inbound probes
+const (
+	KindEcho       = "echo"       // ICMP echo request
+	KindTraceroute = "traceroute" // UDP probe to the traceroute port range
+)
+
+// initialTTLs are common initial TTLs used to estimate the hop distance of a sender
+var initialTTLs = []int{32, 64, 128, 255}
+
+// ProbeEvent describes a single inbound probe
+type ProbeEvent struct {
+	Type      string    `json:"type"`           // Message type ("probe")
+	Timestamp time.Time `json:"timestamp"`      // Time the probe arrived
+	Kind      string    `json:"kind"`           // Probe kind (echo, traceroute)
+	Source    string    `json:"source"`         // Sender address
+	TTL       int       `json:"ttl"`            // TTL remaining on arrival
+	Hops      int       `json:"hops,omitempty"` // Estimated hop distance of echo senders
+	Size      int       `json:"size"`           // Payload size in bytes
+	ID        int       `json:"id,omitempty"`   // Echo identifier
+	Seq       int       `json:"seq,omitempty"`  // Echo sequence number
+	Port      int       `json:"port,omitempty"` // Destination port of traceroute probes
+}
+
+// SourceStats counts the probes seen from one sender
+type SourceStats struct {
+	Source     string    `json:"source"`     // Sender address
+	Echo       int       `json:"echo"`       // ICMP echo requests received
+	Traceroute int       `json:"traceroute"` // Traceroute probes received
+	Hops       int       `json:"hops"`       // Estimated hop distance of the last probe
+	FirstSeen  time.Time `json:"first_seen"` // Time of the first probe
+	LastSeen   time.Time `json:"last_seen"`  // Time of the latest probe
+}
+
+// Counters is a snapshot of the observer counters
+type Counters struct {
+	Started    time.Time     `json:"started"`    // Time the observer started
+	Echo       int           `json:"echo"`       // Total ICMP echo requests
+	Traceroute int           `json:"traceroute"` // Total traceroute probes
+	Untracked  int           `json:"untracked"`  // Probes from sources beyond the tracking limit
+	Sources    []SourceStats `json:"sources"`    // Per sender counts, busiest first
+}
+
+// Observer records inbound ICMP echo requests and traceroute probes by
+// reading copies of incoming packets from raw sockets. The kernel still
+// answers the probes itself.
+type Observer struct {
+	mu          sync.Mutex
+	started     time.Time
+	echo        int
+	traceroute  int
+	untracked   int
+	sources     map[string]*SourceStats
+	subscribers map[chan ProbeEvent]struct{}
+}
+
+// NewObserver creates an observer that has not started listening yet
+func NewObserver() *Observer {
+	return &Observer{
+		sources:     make(map[string]*SourceStats),
+		subscribers: make(map[chan ProbeEvent]struct{}),
+	}
+}
+
+// listen opens a raw socket for protocol that reports the TTL of each packet
+func listen(network string) (*ipv4.PacketConn, error) {
+	conn, err := net.ListenPacket(network, "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s socket: %w", network, err)
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if err := pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("error enabling TTL reporting: %w", err)
+	}
+	return pc, nil
+}
+
+// Start opens the raw sockets and begins recording probes in the background
+func (o *Observer) Start() error {
+	icmpConn, err := listen("ip4:icmp")
+	if err != nil {
+		return err
+	}
+	udpConn, err := listen("ip4:udp")
+	if err != nil {
+		icmpConn.Close()
+		return err
+	}
+
+	o.mu.Lock()
+	o.started = time.Now()
+	o.mu.Unlock()
+
+	go o.read(icmpConn, o.parseICMP)
+	go o.read(udpConn, o.parseUDP)
+	return nil
+}
+
+// read receives packets until the socket fails and records those parse accepts
+func (o *Observer) read(conn *ipv4.PacketConn, parse func([]byte) (ProbeEvent, bool)) {
+	defer conn.Close()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, cm, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("Inbound observer stopped: %v", err)
+			return
+		}
+		event, ok := parse(buf[:n])
+		if !ok {
+			continue
+		}
+		event.Type = "probe"
+		event.Timestamp = time.Now()
+		if addr, ok := peer.(*net.IPAddr); ok {
+			event.Source = addr.IP.String()
+		}
+		if cm != nil {
+			event.TTL = cm.TTL
+			// Traceroute probes are sent with deliberately low TTLs, so only
+			// echo requests say anything about the sender's distance
+			if event.Kind == KindEcho {
+				event.Hops = hops(cm.TTL)
+			}
+		}
+		o.record(event)
+	}
+}
+
+// parseICMP accepts ICMP echo requests
+func (o *Observer) parseICMP(packet []byte) (ProbeEvent, bool) {
+	msg, err := icmp.ParseMessage(protocolICMP, packet)
+	if err != nil || msg.Type != ipv4.ICMPTypeEcho {
+		return ProbeEvent{}, false
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok {
+		return ProbeEvent{}, false
+	}
+	return ProbeEvent{Kind: KindEcho, ID: echo.ID, Seq: echo.Seq, Size: len(echo.Data)}, true
+}
+
+// parseUDP accepts UDP datagrams sent to the classic traceroute port range
+func (o *Observer) parseUDP(packet []byte) (ProbeEvent, bool) {
+	if len(packet) < 8 {
+		return ProbeEvent{}, false
+	}
+	port := int(binary.BigEndian.Uint16(packet[2:]))
+	if port < tracerouteFirst || port > tracerouteLast {
+		return ProbeEvent{}, false
+	}
+	return ProbeEvent{Kind: KindTraceroute, Port: port, Size: len(packet) - 8}, true
+}
+
+// hops estimates how far away a sender is from the TTL left on arrival
+func hops(ttl int) int {
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial - ttl
+		}
+	}
+	return 0
+}
+
+// record updates the counters and forwards the event to live subscribers
+func (o *Observer) record(event ProbeEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if event.Kind == KindEcho {
+		o.echo++
+	} else {
+		o.traceroute++
+	}
+
+	stats, ok := o.sources[event.Source]
+	if !ok {
+		if len(o.sources) >= maxSources {
+			o.untracked++
+		} else {
+			stats = &SourceStats{Source: event.Source, FirstSeen: event.Timestamp}
+			o.sources[event.Source] = stats
+		}
+	}
+	if stats != nil {
+		if event.Kind == KindEcho {
+			stats.Echo++
+		} else {
+			stats.Traceroute++
+		}
+		if event.Kind == KindEcho {
+			stats.Hops = event.Hops
+		}
+		stats.LastSeen = event.Timestamp
+	}
+
+	for ch := range o.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Slow subscribers miss events rather than blocking the reader
+		}
+	}
+}
+
+// Counters returns a snapshot of the counters with the busiest sources first
+func (o *Observer) Counters() Counters {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	counters := Counters{
+		Started:    o.started,
+		Echo:       o.echo,
+		Traceroute: o.traceroute,
+		Untracked:  o.untracked,
+		Sources:    make([]SourceStats, 0, len(o.sources)),
+	}
+	for _, stats := range o.sources {
+		counters.Sources = append(counters.Sources, *stats)
+	}
+	sort.Slice(counters.Sources, func(i, j int) bool {
+		a, b := counters.Sources[i], counters.Sources[j]
+		if a.Echo+a.Traceroute != b.Echo+b.Traceroute {
+			return a.Echo+a.Traceroute > b.Echo+b.Traceroute
+		}
+		return a.Source < b.Source
+	})
+	return counters
+}
+
+// subscribe registers a channel receiving every new probe event
+func (o *Observer) subscribe() chan ProbeEvent {
+	ch := make(chan ProbeEvent, subscriberQueue)
+	o.mu.Lock()
+	o.subscribers[ch] = struct{}{}
+	o.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a channel added with subscribe
+func (o *Observer) unsubscribe(ch chan ProbeEvent) {
+	o.mu.Lock()
+	delete(o.subscribers, ch)
+	o.mu.Unlock()
+}
+
+// CountersHandler returns the observer counters as JSON
+func (o *Observer) CountersHandler(w http.ResponseWriter, r *http.Request) {
+	tool.WriteJSON(w, http.StatusOK, o.Counters())
+}
+
+// Handler streams inbound probes to the WebSocket client as they arrive
+func (o *Observer) Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "inbound")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	events := o.subscribe()
+	defer o.unsubscribe(events)
+
+	// The client sends nothing, reading only detects when it goes away
+	closed := make(chan struct{})
+	session.Go(func() {
+		defer close(closed)
+		var discard any
+		for session.ReadJSON(&discard) == nil {
+		}
+	})
+
+	for {
+		select {
+		case event := <-events:
+			if err := session.WriteJSON(event); err != nil {
+				log.Printf("Failed to send probe event: %v", err)
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package iptools
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// maxRangeCIDRs bounds the number of prefixes a range can expand to
+const maxRangeCIDRs = 256
+
+// AddressInfo describes a parsed IP address
+type AddressInfo struct {
+	Input         string `json:"input"`                 // Address as given
+	Canonical     string `json:"canonical"`             // Canonical text form (RFC 5952 for IPv6)
+	Expanded      string `json:"expanded"`              // Fully expanded form
+	Version       int    `json:"version"`               // 4 or 6
+	Decimal       string `json:"decimal"`               // Address as an unsigned integer
+	PTR           string `json:"ptr"`                   // Reverse DNS name
+	Private       bool   `json:"private"`               // RFC 1918 or RFC 4193 address
+	Loopback      bool   `json:"loopback"`              // Loopback address
+	LinkLocal     bool   `json:"link_local"`            // Link-local unicast address
+	Multicast     bool   `json:"multicast"`             // Multicast address
+	Unspecified   bool   `json:"unspecified"`           // All zeros address
+	GlobalUnicast bool   `json:"global_unicast"`        // Global unicast address
+	IPv4Mapped    string `json:"ipv4_mapped,omitempty"` // Embedded IPv4 address of ::ffff:a.b.c.d addresses
+	Zone          string `json:"zone,omitempty"`        // IPv6 zone
+}
+
+// PrefixInfo describes a CIDR prefix
+type PrefixInfo struct {
+	Input       string `json:"input"`             // Prefix as given
+	Prefix      string `json:"prefix"`            // Prefix with host bits cleared
+	Network     string `json:"network"`           // First address
+	Last        string `json:"last"`              // Last address (broadcast for IPv4)
+	Netmask     string `json:"netmask,omitempty"` // Dotted netmask for IPv4
+	Length      int    `json:"length"`            // Prefix length in bits
+	Size        string `json:"size"`              // Number of addresses
+	HostBitsSet bool   `json:"host_bits_set"`     // Whether the input had host bits set
+}
+
+// ContainsResult reports which prefixes contain an address
+type ContainsResult struct {
+	IP       string   `json:"ip"`       // Address that was checked
+	Contains bool     `json:"contains"` // Whether any prefix contains the address
+	Matches  []string `json:"matches"`  // Prefixes that contain the address
+}
+
+// RangeResult is the CIDR cover of an address range
+type RangeResult struct {
+	Start string   `json:"start"` // First address of the range
+	End   string   `json:"end"`   // Last address of the range
+	CIDRs []string `json:"cidrs"` // Smallest set of prefixes covering exactly the range
+}
+
+// parseAddr parses an address query parameter
+func parseAddr(r *http.Request, name string) (netip.Addr, error) {
+	value := strings.TrimSpace(r.URL.Query().Get(name))
+	if value == "" {
+		return netip.Addr{}, fmt.Errorf("%s is required", name)
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return addr, nil
+}
+
+// expanded returns the address with every IPv6 group written out
+func expanded(addr netip.Addr) string {
+	if addr.Is4() {
+		return addr.String()
+	}
+	b := addr.As16()
+	groups := make([]string, 8)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%02x%02x", b[2*i], b[2*i+1])
+	}
+	return strings.Join(groups, ":")
+}
+
+// ptrName returns the reverse DNS name of an address
+func ptrName(addr netip.Addr) string {
+	addr = addr.WithZone("")
+	if addr.Is4() {
+		b := addr.As4()
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", b[3], b[2], b[1], b[0])
+	}
+	b := addr.As16()
+	var sb strings.Builder
+	for i := len(b) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%x.%x.", b[i]&0xf, b[i]>>4)
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
+
+// lastAddr returns the last address of a prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+// setHostBits sets every bit after the first bits bits of b
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= 8*(i+1):
+		case bits <= 8*i:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> (bits - 8*i)
+		}
+	}
+}
+
+// rangeToCIDRs returns the smallest set of prefixes covering start to end
+func rangeToCIDRs(start, end netip.Addr) ([]string, error) {
+	cidrs := []string{}
+	bitLen := start.BitLen()
+	for {
+		// Grow the block from start while it stays aligned and inside the range
+		bits := bitLen
+		for bits > 0 {
+			p := netip.PrefixFrom(start, bits-1)
+			if p.Masked().Addr() != start || end.Less(lastAddr(p)) {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(start, bits)
+		cidrs = append(cidrs, p.String())
+		if len(cidrs) > maxRangeCIDRs {
+			return nil, fmt.Errorf("range expands to more than %d prefixes", maxRangeCIDRs)
+		}
+
+		last := lastAddr(p)
+		if last == end {
+			return cidrs, nil
+		}
+		start = last.Next()
+	}
+}
+
+// ParseHandler describes the address in the ip query parameter
+func ParseHandler(w http.ResponseWriter, r *http.Request) {
+	addr, err := parseAddr(r, "ip")
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	info := AddressInfo{
+		Input:         r.URL.Query().Get("ip"),
+		Canonical:     addr.String(),
+		Expanded:      expanded(addr),
+		Version:       6,
+		Decimal:       new(big.Int).SetBytes(addr.AsSlice()).String(),
+		PTR:           ptrName(addr),
+		Private:       addr.IsPrivate(),
+		Loopback:      addr.IsLoopback(),
+		LinkLocal:     addr.IsLinkLocalUnicast(),
+		Multicast:     addr.IsMulticast(),
+		Unspecified:   addr.IsUnspecified(),
+		GlobalUnicast: addr.IsGlobalUnicast(),
+		Zone:          addr.Zone(),
+	}
+	if addr.Is4() {
+		info.Version = 4
+	}
+	if addr.Is4In6() {
+		info.IPv4Mapped = addr.Unmap().String()
+	}
+	tool.WriteJSON(w, http.StatusOK, info)
+}
+
+// CIDRHandler describes the prefix in the cidr query parameter
+func CIDRHandler(w http.ResponseWriter, r *http.Request) {
+	input := strings.TrimSpace(r.URL.Query().Get("cidr"))
+	p, err := netip.ParsePrefix(input)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cidr %q", input))
+		return
+	}
+
+	masked := p.Masked()
+	info := PrefixInfo{
+		Input:       input,
+		Prefix:      masked.String(),
+		Network:     masked.Addr().String(),
+		Last:        lastAddr(masked).String(),
+		Length:      p.Bits(),
+		Size:        new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-p.Bits())).String(),
+		HostBitsSet: masked.Addr() != p.Addr(),
+	}
+	if p.Addr().Is4() {
+		mask := netip.PrefixFrom(netip.IPv4Unspecified(), p.Bits())
+		b := lastAddr(mask).As4()
+		for i := range b {
+			b[i] = ^b[i]
+		}
+		info.Netmask = netip.AddrFrom4(b).String()
+	}
+	tool.WriteJSON(w, http.StatusOK, info)
+}
+
+// ContainsHandler checks whether the ip query parameter lies in any of the
+// cidr parameters, which may be repeated or comma separated
+func ContainsHandler(w http.ResponseWriter, r *http.Request) {
+	addr, err := parseAddr(r, "ip")
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := ContainsResult{IP: addr.String(), Matches: []string{}}
+	var prefixes []string
+	for _, value := range r.URL.Query()["cidr"] {
+		prefixes = append(prefixes, strings.Split(value, ",")...)
+	}
+	if len(prefixes) == 0 {
+		tool.WriteError(w, http.StatusBadRequest, errors.New("cidr is required"))
+		return
+	}
+	for _, value := range prefixes {
+		p, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil {
+			tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cidr %q", value))
+			return
+		}
+		if p.Contains(addr.Unmap()) || p.Contains(addr) {
+			result.Matches = append(result.Matches, p.String())
+		}
+	}
+	result.Contains = len(result.Matches) > 0
+	tool.WriteJSON(w, http.StatusOK, result)
+}
+
+// RangeHandler converts the start to end address range into CIDR prefixes
+func RangeHandler(w http.ResponseWriter, r *http.Request) {
+	start, err := parseAddr(r, "start")
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	end, err := parseAddr(r, "end")
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	start, end = start.WithZone(""), end.WithZone("")
+	if start.Is4() != end.Is4() {
+		tool.WriteError(w, http.StatusBadRequest, errors.New("start and end must be the same address family"))
+		return
+	}
+	if end.Less(start) {
+		tool.WriteError(w, http.StatusBadRequest, errors.New("end must not be before start"))
+		return
+	}
+
+	cidrs, err := rangeToCIDRs(start, end)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	tool.WriteJSON(w, http.StatusOK, RangeResult{Start: start.String(), End: end.String(), CIDRs: cidrs})
+}
+
+// PTRHandler returns the reverse DNS names of the ip query parameters
+func PTRHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()["ip"]
+	if len(values) == 0 {
+		tool.WriteError(w, http.StatusBadRequest, errors.New("ip is required"))
+		return
+	}
+
+	names := make(map[string]string, len(values))
+	for _, value := range values {
+		addr, err := netip.ParseAddr(strings.TrimSpace(value))
+		if err != nil {
+			tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid ip %q", value))
+			return
+		}
+		names[value] = ptrName(addr)
+	}
+	tool.WriteJSON(w, http.StatusOK, names)
+}
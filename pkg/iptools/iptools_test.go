@@ -0,0 +1,184 @@
+package iptools
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRangeToCIDRs(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		want       []string
+	}{
+		{name: "single address", start: "192.0.2.7", end: "192.0.2.7", want: []string{"192.0.2.7/32"}},
+		{name: "aligned block", start: "192.0.2.0", end: "192.0.2.255", want: []string{"192.0.2.0/24"}},
+		{name: "unaligned range", start: "192.0.2.1", end: "192.0.2.6", want: []string{"192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/31", "192.0.2.6/32"}},
+		{name: "across octets", start: "10.0.0.255", end: "10.0.2.0", want: []string{"10.0.0.255/32", "10.0.1.0/24", "10.0.2.0/32"}},
+		{name: "every ipv4 address", start: "0.0.0.0", end: "255.255.255.255", want: []string{"0.0.0.0/0"}},
+		{name: "up to the last ipv4 address", start: "255.255.255.254", end: "255.255.255.255", want: []string{"255.255.255.254/31"}},
+		{name: "last ipv4 address", start: "255.255.255.255", end: "255.255.255.255", want: []string{"255.255.255.255/32"}},
+		{name: "ipv6", start: "2001:db8::", end: "2001:db8::1:2", want: []string{"2001:db8::/112", "2001:db8::1:0/127", "2001:db8::1:2/128"}},
+		{name: "every ipv6 address", start: "::", end: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: []string{"::/0"}},
+		{name: "up to the last ipv6 address", start: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00", end: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00/120"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rangeToCIDRs(netip.MustParseAddr(tt.start), netip.MustParseAddr(tt.end))
+			if err != nil {
+				t.Fatalf("rangeToCIDRs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rangeToCIDRs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The widest covers, one address short of each end, stay under the limit
+	for start, end := range map[string]string{"0.0.0.1": "255.255.255.254", "::1": "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"} {
+		s, e := netip.MustParseAddr(start), netip.MustParseAddr(end)
+		got, err := rangeToCIDRs(s, e)
+		if want := 2 * (s.BitLen() - 1); err != nil || len(got) != want {
+			t.Errorf("rangeToCIDRs(%s, %s) = %d prefixes, %v, want %d", start, end, len(got), err, want)
+		}
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.0/24":    "192.0.2.255",
+		"192.0.2.77/26":   "192.0.2.127",
+		"10.0.0.0/13":     "10.7.255.255",
+		"0.0.0.0/0":       "255.255.255.255",
+		"192.0.2.1/32":    "192.0.2.1",
+		"2001:db8::/32":   "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+		"2001:db8::/61":   "2001:db8:0:7:ffff:ffff:ffff:ffff",
+		"2001:db8::1/128": "2001:db8::1",
+	}
+	for prefix, want := range tests {
+		if got := lastAddr(netip.MustParsePrefix(prefix)).String(); got != want {
+			t.Errorf("lastAddr(%s) = %s, want %s", prefix, got, want)
+		}
+	}
+}
+
+func TestPTRName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":          "1.2.0.192.in-addr.arpa.",
+		"2001:db8::567:89ab": "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"fe80::1%eth0":       "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.",
+	}
+	for addr, want := range tests {
+		if got := ptrName(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("ptrName(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestExpanded(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":        "192.0.2.1",
+		"2001:db8::1":      "2001:0db8:0000:0000:0000:0000:0000:0001",
+		"::ffff:192.0.2.1": "0000:0000:0000:0000:0000:ffff:c000:0201",
+	}
+	for addr, want := range tests {
+		if got := expanded(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("expanded(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestRangeHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+	}{
+		{name: "range", query: "start=192.0.2.0&end=192.0.2.9", status: http.StatusOK, want: []string{"192.0.2.0/29", "192.0.2.8/31"}},
+		{name: "missing end", query: "start=192.0.2.0", status: http.StatusBadRequest},
+		{name: "invalid start", query: "start=192.0.2&end=192.0.2.9", status: http.StatusBadRequest},
+		{name: "mixed families", query: "start=192.0.2.0&end=2001:db8::1", status: http.StatusBadRequest},
+		{name: "reversed", query: "start=192.0.2.9&end=192.0.2.0", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RangeHandler(w, httptest.NewRequest(http.MethodGet, "/api/ip/range?"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var result RangeResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.CIDRs, tt.want) {
+				t.Errorf("cidrs = %v, want %v", result.CIDRs, tt.want)
+			}
+		})
+	}
+}
+
+func TestCIDRHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	CIDRHandler(w, httptest.NewRequest(http.MethodGet, "/api/ip/cidr?cidr=192.0.2.77/26", nil))
+	var info PrefixInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	want := PrefixInfo{Input: "192.0.2.77/26", Prefix: "192.0.2.64/26", Network: "192.0.2.64", Last: "192.0.2.127",
+		Netmask: "255.255.255.192", Length: 26, Size: "64", HostBitsSet: true}
+	if info != want {
+		t.Errorf("CIDRHandler() = %+v, want %+v", info, want)
+	}
+
+	w = httptest.NewRecorder()
+	CIDRHandler(w, httptest.NewRequest(http.MethodGet, "/api/ip/cidr?cidr=192.0.2.0/33", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid prefix = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func FuzzRangeToCIDRs(f *testing.F) {
+	f.Add([]byte{192, 0, 2, 1}, []byte{192, 0, 2, 6})
+	f.Add([]byte{0, 0, 0, 0}, []byte{255, 255, 255, 255})
+	f.Add(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::1:0").AsSlice())
+
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		start, ok1 := netip.AddrFromSlice(a)
+		end, ok2 := netip.AddrFromSlice(b)
+		if !ok1 || !ok2 || start.Is4() != end.Is4() {
+			return
+		}
+		if end.Less(start) {
+			start, end = end, start
+		}
+		cidrs, err := rangeToCIDRs(start, end)
+		if err != nil {
+			if !strings.Contains(err.Error(), "more than") {
+				t.Fatalf("rangeToCIDRs(%s, %s) error = %v", start, end, err)
+			}
+			return
+		}
+		// The prefixes must follow each other from start to exactly end
+		next := start
+		for i, cidr := range cidrs {
+			p := netip.MustParsePrefix(cidr)
+			if p.Addr() != next || p.Masked() != p {
+				t.Fatalf("rangeToCIDRs(%s, %s)[%d] = %s, want an aligned prefix at %s", start, end, i, cidr, next)
+			}
+			next = lastAddr(p).Next()
+		}
+		if last := lastAddr(netip.MustParsePrefix(cidrs[len(cidrs)-1])); last != end {
+			t.Fatalf("rangeToCIDRs(%s, %s) ends at %s", start, end, last)
+		}
+	})
+}
@@ -0,0 +1,478 @@
+package ipv6ready
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for IPv6 readiness audit options
+const (
+	defaultPath     = "/"
+	defaultTimeout  = 10 // 10 second timeout per check
+	maxBodySize     = 2 << 20
+	maxResourceHost = 50
+)
+
+// Checks in the order they run
+const (
+	CheckAAAA         = "aaaa"         // The host has AAAA records
+	CheckTCP          = "tcp"          // A TCP connection succeeds over IPv6
+	CheckTLS          = "tls"          // A verified TLS handshake succeeds over IPv6
+	CheckHTTP         = "http"         // The page loads over IPv6
+	CheckSubresources = "subresources" // Every host the page loads resources from has AAAA records
+)
+
+// weights are the points each check adds to the readiness score. The
+// subresources check earns its points in proportion to the ready hosts.
+var weights = map[string]float64{
+	CheckAAAA:         25,
+	CheckTCP:          20,
+	CheckTLS:          15,
+	CheckHTTP:         20,
+	CheckSubresources: 20,
+}
+
+// resourceAttributes maps HTML elements to the attributes holding the URLs
+// of resources a browser loads with the page
+var resourceAttributes = map[string][]string{
+	"script": {"src"},
+	"img":    {"src", "srcset"},
+	"link":   {"href"},
+	"iframe": {"src"},
+	"source": {"src", "srcset"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"embed":  {"src"},
+	"object": {"data"},
+}
+
+// loadedLinks are the rel values of link elements that load resources,
+// as opposed to ones such as canonical or alternate
+var loadedLinks = []string{"stylesheet", "icon", "preload", "modulepreload", "manifest", "apple-touch-icon", "shortcut"}
+
+// IPv6Message represents the incoming IPv6 readiness audit request
+type IPv6Message struct {
+	// Required
+	Host string `json:"host"` // Hostname of the service
+
+	// Optional parameters
+	Port    *int    `json:"port,omitempty"`    // Port to connect to (defaults to 443, or 80 without TLS)
+	Path    *string `json:"path,omitempty"`    // Path of the page whose subresources are checked
+	TLS     *bool   `json:"tls,omitempty"`     // Use TLS and HTTPS (false checks plain HTTP)
+	Timeout *int    `json:"timeout,omitempty"` // Timeout in seconds per check
+}
+
+// CheckMessage reports the result of one check
+type CheckMessage struct {
+	Type     string   `json:"type"`               // Message type ("check")
+	Check    string   `json:"check"`              // Check name (aaaa, tcp, tls, http, subresources)
+	Passed   bool     `json:"passed"`             // Whether the check passed
+	Skipped  bool     `json:"skipped,omitempty"`  // Whether the check could not run because an earlier one failed
+	Detail   string   `json:"detail,omitempty"`   // What was found
+	Error    string   `json:"error,omitempty"`    // Why the check failed
+	Duration float64  `json:"duration"`           // Check duration in milliseconds
+	Score    float64  `json:"score"`              // Points earned
+	Weight   float64  `json:"weight"`             // Points available
+	Address  string   `json:"address,omitempty"`  // IPv6 address connected to
+	AAAA     []string `json:"aaaa,omitempty"`     // AAAA records of the host
+	Status   int      `json:"status,omitempty"`   // HTTP status code
+	Hosts    []Host   `json:"hosts,omitempty"`    // Subresource hosts
+	Version  string   `json:"version,omitempty"`  // Negotiated TLS version
+	Redirect string   `json:"redirect,omitempty"` // Final URL after redirects, when it differs
+}
+
+// Host reports whether a subresource host is reachable over IPv6
+type Host struct {
+	Host      string   `json:"host"`            // Hostname
+	AAAA      []string `json:"aaaa"`            // AAAA records
+	Ready     bool     `json:"ready"`           // Whether the host has AAAA records
+	Resources int      `json:"resources"`       // Resources the page loads from the host
+	Error     string   `json:"error,omitempty"` // Lookup error
+}
+
+// SummaryMessage reports the readiness score
+type SummaryMessage struct {
+	Type   string   `json:"type"`   // Message type ("summary")
+	Host   string   `json:"host"`   // Host that was audited
+	Score  int      `json:"score"`  // IPv6 readiness score from 0 to 100
+	Ready  bool     `json:"ready"`  // Whether every check passed
+	Failed []string `json:"failed"` // Checks that failed or were skipped
+}
+
+// IPv6Options contains the resolved IPv6 readiness audit options
+type IPv6Options struct {
+	Host    string
+	Port    int
+	Path    string
+	UseTLS  bool
+	Timeout int
+}
+
+// resolveIPv6Options converts IPv6Message to IPv6Options with defaults
+func resolveIPv6Options(msg *IPv6Message) (IPv6Options, error) {
+	opts := IPv6Options{
+		Host:    strings.TrimSuffix(strings.TrimSpace(msg.Host), "."),
+		Path:    tool.GetOrDefault(msg.Path, defaultPath),
+		UseTLS:  tool.GetOrDefault(msg.TLS, true),
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+	defaultPort := 443
+	if !opts.UseTLS {
+		defaultPort = 80
+	}
+	opts.Port = tool.GetOrDefault(msg.Port, defaultPort)
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if net.ParseIP(strings.Trim(opts.Host, "[]")) != nil || strings.ContainsAny(opts.Host, " /:") {
+		return opts, fmt.Errorf("host must be a hostname")
+	}
+	if opts.Port <= 0 || opts.Port > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535")
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		return opts, fmt.Errorf("path must start with /")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// auditor runs the checks of one audit
+type auditor struct {
+	opts    IPv6Options
+	timeout time.Duration
+	dialer  *net.Dialer
+	body    []byte
+	base    *url.URL
+}
+
+// dial6 connects over IPv6 only, whatever network is asked for
+func (a *auditor) dial6(ctx context.Context, _, address string) (net.Conn, error) {
+	return a.dialer.DialContext(ctx, "tcp6", address)
+}
+
+// lookupAAAA returns the AAAA records of host
+func lookupAAAA(ctx context.Context, host string) ([]string, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", host)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		records = append(records, ip.String())
+	}
+	return records, nil
+}
+
+// checkAAAA looks up the AAAA records of the host
+func (a *auditor) checkAAAA(ctx context.Context, check *CheckMessage) {
+	records, err := lookupAAAA(ctx, a.opts.Host)
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	check.Passed, check.AAAA = true, records
+	check.Detail = fmt.Sprintf("%d AAAA records", len(records))
+}
+
+// checkTCP connects to the host over IPv6
+func (a *auditor) checkTCP(ctx context.Context, check *CheckMessage) {
+	conn, err := a.dial6(ctx, "", net.JoinHostPort(a.opts.Host, strconv.Itoa(a.opts.Port)))
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	conn.Close()
+	check.Passed = true
+	check.Address = conn.RemoteAddr().String()
+	check.Detail = "connected to " + check.Address
+}
+
+// checkTLS completes a verified TLS handshake with the host over IPv6
+func (a *auditor) checkTLS(ctx context.Context, check *CheckMessage) {
+	tlsDialer := &tls.Dialer{NetDialer: a.dialer, Config: &tls.Config{ServerName: a.opts.Host}}
+	conn, err := tlsDialer.DialContext(ctx, "tcp6", net.JoinHostPort(a.opts.Host, strconv.Itoa(a.opts.Port)))
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	check.Passed = true
+	check.Address = conn.RemoteAddr().String()
+	check.Version = tls.VersionName(state.Version)
+	check.Detail = fmt.Sprintf("%s handshake with a certificate valid for %s", check.Version, a.opts.Host)
+}
+
+// checkHTTP loads the page over IPv6, following redirects, and keeps the
+// body for the subresources check. Redirects to other hosts have to work
+// over IPv6 too.
+func (a *auditor) checkHTTP(ctx context.Context, check *CheckMessage) {
+	scheme := "http"
+	if a.opts.UseTLS {
+		scheme = "https"
+	}
+	target := &url.URL{Scheme: scheme, Host: net.JoinHostPort(a.opts.Host, strconv.Itoa(a.opts.Port)), Path: a.opts.Path}
+	transport := &http.Transport{DialContext: a.dial6, DisableKeepAlives: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	tool.Identify(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+
+	check.Status = resp.StatusCode
+	if final := resp.Request.URL.String(); final != target.String() {
+		check.Redirect = final
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		check.Error = "server responded " + resp.Status
+		return
+	}
+	check.Passed = true
+	check.Detail = "server responded " + resp.Status
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		a.body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			check.Passed, check.Error = false, fmt.Sprintf("error reading body: %v", err)
+			return
+		}
+		a.base = resp.Request.URL
+	}
+}
+
+// subresourceHosts returns the hosts other than the audited one that the
+// page loads resources from, with the number of resources per host
+func (a *auditor) subresourceHosts() ([]string, map[string]int) {
+	doc, err := html.Parse(strings.NewReader(string(a.body)))
+	if err != nil {
+		return nil, nil
+	}
+	base := a.base
+	var hosts []string
+	counts := make(map[string]int)
+	add := func(ref string) {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		host := strings.ToLower(u.Hostname())
+		if host == "" || host == a.opts.Host {
+			return
+		}
+		if counts[host] == 0 {
+			hosts = append(hosts, host)
+		}
+		counts[host]++
+	}
+
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		if n.Data == "base" {
+			if href := attribute(n, "href"); href != "" {
+				if u, err := a.base.Parse(href); err == nil {
+					base = u
+				}
+			}
+			continue
+		}
+		names, ok := resourceAttributes[n.Data]
+		if !ok {
+			continue
+		}
+		if n.Data == "link" && !loadsResource(attribute(n, "rel")) {
+			continue
+		}
+		for _, name := range names {
+			value := attribute(n, name)
+			if value == "" {
+				continue
+			}
+			if name == "srcset" {
+				// Candidates are URLs followed by an optional descriptor
+				for _, candidate := range strings.Split(value, ",") {
+					if fields := strings.Fields(candidate); len(fields) > 0 {
+						add(fields[0])
+					}
+				}
+				continue
+			}
+			add(value)
+		}
+	}
+	return hosts, counts
+}
+
+// attribute returns the value of the named attribute of n
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// loadsResource reports whether a link element with the given rel loads a
+// resource with the page
+func loadsResource(rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(rel)) {
+		if slices.Contains(loadedLinks, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubresources looks up the AAAA records of every host the page loads
+// resources from
+func (a *auditor) checkSubresources(ctx context.Context, check *CheckMessage) {
+	if a.body == nil {
+		check.Passed, check.Score = true, check.Weight
+		check.Detail = "the page is not HTML"
+		return
+	}
+	names, counts := a.subresourceHosts()
+	if len(names) > maxResourceHost {
+		names = names[:maxResourceHost]
+	}
+	check.Hosts = make([]Host, 0, len(names))
+	var missing []string
+	ready := 0
+	for _, name := range names {
+		host := Host{Host: name, AAAA: []string{}, Resources: counts[name]}
+		records, err := lookupAAAA(ctx, name)
+		if err != nil {
+			host.Error = err.Error()
+			missing = append(missing, name)
+		} else {
+			host.AAAA, host.Ready = records, true
+			ready++
+		}
+		check.Hosts = append(check.Hosts, host)
+	}
+
+	check.Passed = ready == len(names)
+	check.Detail = fmt.Sprintf("%d of %d subresource hosts have AAAA records", ready, len(names))
+	if len(names) == 0 {
+		check.Score = check.Weight
+	} else {
+		check.Score = check.Weight * float64(ready) / float64(len(names))
+	}
+	if !check.Passed {
+		check.Error = "no AAAA records for " + strings.Join(missing, ", ")
+	}
+}
+
+// Audit runs the checks in order, calling report after each. A check whose
+// prerequisite failed is reported as skipped. An error returned by report
+// ends the audit.
+func Audit(ctx context.Context, opts IPv6Options, report func(CheckMessage) error) SummaryMessage {
+	summary := SummaryMessage{Type: "summary", Host: opts.Host, Failed: []string{}}
+	a := &auditor{opts: opts, timeout: time.Duration(opts.Timeout) * time.Second, dialer: &net.Dialer{}}
+
+	checks := []struct {
+		name string
+		run  func(context.Context, *CheckMessage)
+	}{
+		{CheckAAAA, a.checkAAAA},
+		{CheckTCP, a.checkTCP},
+		{CheckTLS, a.checkTLS},
+		{CheckHTTP, a.checkHTTP},
+		{CheckSubresources, a.checkSubresources},
+	}
+	var earned, possible float64
+	failed := false
+	for _, c := range checks {
+		if c.name == CheckTLS && !opts.UseTLS {
+			continue
+		}
+		check := CheckMessage{Type: "check", Check: c.name, Weight: weights[c.name]}
+		possible += check.Weight
+		if failed {
+			check.Skipped, check.Error = true, "an earlier check failed"
+		} else {
+			ctx, cancel := context.WithTimeout(ctx, a.timeout)
+			start := time.Now()
+			c.run(ctx, &check)
+			check.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+			cancel()
+			if check.Passed && check.Score == 0 {
+				check.Score = check.Weight
+			}
+		}
+		earned += check.Score
+		if !check.Passed {
+			summary.Failed = append(summary.Failed, c.name)
+			// Subresources do not gate anything after them
+			failed = failed || c.name != CheckSubresources
+		}
+
+		if err := report(check); err != nil {
+			break
+		}
+	}
+	summary.Score = int(math.Round(earned / possible * 100))
+	summary.Ready = len(summary.Failed) == 0
+	return summary
+}
+
+// Handler handles WebSocket IPv6 readiness audit requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "ipv6")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg IPv6Message
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading ipv6 message: %v", err)
+		return
+	}
+
+	opts, err := resolveIPv6Options(&msg)
+	if err != nil {
+		log.Printf("Invalid ipv6 options: %v", err)
+		return
+	}
+
+	summary := Audit(r.Context(), opts, func(check CheckMessage) error {
+		return session.WriteJSON(check)
+	})
+	log.Printf("IPv6 readiness of %s: %d", opts.Host, summary.Score)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package knock
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for port knocking options
+const (
+	defaultDelay   = 200 * time.Millisecond // Pause between knocks
+	defaultWait    = 500 * time.Millisecond // Pause after the last knock before testing the port
+	defaultTimeout = 3                      // Seconds to wait for the protected port to accept
+	knockTimeout   = 100 * time.Millisecond // Wait for a TCP knock's handshake, short enough that the SYN is not retransmitted
+	maxKnocks      = 32
+	maxDelay       = 30 * time.Second
+)
+
+// Outcomes of knocks and port tests
+const (
+	OutcomeOpen    = "open"    // The port accepted the connection
+	OutcomeRefused = "refused" // The host reset the connection
+	OutcomeTimeout = "timeout" // Nothing answered, the port is filtered
+	OutcomeSent    = "sent"    // A UDP knock was sent; UDP gets no answer to tell
+)
+
+// KnockMessage represents the incoming port knocking request
+type KnockMessage struct {
+	// Required
+	Host     string   `json:"host"`     // Host to knock on
+	Sequence []string `json:"sequence"` // Knocks in order, as "port" or "port/tcp" or "port/udp"
+	Port     int      `json:"port"`     // Protected TCP port that the sequence opens
+
+	// Optional parameters
+	Family   *string        `json:"family,omitempty"`    // Address family to use ("ip4" or "ip6")
+	Delay    *tool.Duration `json:"delay,omitempty"`     // Pause between knocks, e.g. "500ms"
+	Wait     *tool.Duration `json:"wait,omitempty"`      // Pause after the last knock before testing the port
+	Payload  *string        `json:"payload,omitempty"`   // Data sent in UDP knocks
+	PreCheck *bool          `json:"pre_check,omitempty"` // Test the port before knocking, so an open port afterwards is known to be the sequence's doing
+	Timeout  *int           `json:"timeout,omitempty"`   // Seconds to wait for the protected port to accept
+}
+
+// Knock is one knock of a sequence
+type Knock struct {
+	Port     int
+	Protocol string
+}
+
+// KnockResult reports one knock sent
+type KnockResult struct {
+	Type     string  `json:"type"`     // Message type ("knock")
+	Index    int     `json:"index"`    // Position in the sequence, counting from 1
+	Port     int     `json:"port"`     // Port knocked on
+	Protocol string  `json:"protocol"` // "tcp" or "udp"
+	Outcome  string  `json:"outcome"`  // What the host did (open, refused, timeout or sent)
+	Elapsed  float64 `json:"elapsed"`  // Milliseconds from the first knock to this one
+}
+
+// CheckMessage reports a test of the protected port
+type CheckMessage struct {
+	Type    string  `json:"type"`              // Message type ("check")
+	Stage   string  `json:"stage"`             // "before" or "after" the sequence
+	Port    int     `json:"port"`              // Protected port
+	Open    bool    `json:"open"`              // Whether the port accepted a connection
+	Outcome string  `json:"outcome"`           // open, refused or timeout
+	Latency float64 `json:"latency,omitempty"` // Handshake time in milliseconds when open
+	Error   string  `json:"error,omitempty"`   // Unexpected dial error
+}
+
+// SummaryMessage reports whether the sequence opened the port
+type SummaryMessage struct {
+	Type       string  `json:"type"`                  // Message type ("summary")
+	Host       string  `json:"host"`                  // Host that was knocked on
+	Address    string  `json:"address"`               // Resolved address
+	Knocks     int     `json:"knocks"`                // Knocks sent
+	OpenBefore *bool   `json:"open_before,omitempty"` // Whether the port was open before the sequence
+	OpenAfter  bool    `json:"open_after"`            // Whether the port was open after the sequence
+	Opened     bool    `json:"opened"`                // Whether the sequence opened the port
+	Duration   float64 `json:"duration"`              // Milliseconds from the first knock to the end of the test
+	Error      string  `json:"error,omitempty"`       // Error that ended the run
+}
+
+// KnockOptions contains the resolved port knocking options
+type KnockOptions struct {
+	Host     string
+	Family   string
+	Sequence []Knock
+	Port     int
+	Delay    time.Duration
+	Wait     time.Duration
+	Payload  []byte
+	PreCheck bool
+	Timeout  time.Duration
+}
+
+// parseKnock parses a knock given as "port", "port/tcp" or "port/udp"
+func parseKnock(s string) (Knock, error) {
+	port, protocol, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "/")
+	if !ok {
+		protocol = "tcp"
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return Knock{}, fmt.Errorf("invalid knock %q: port must be between 1 and 65535", s)
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		return Knock{}, fmt.Errorf("invalid knock %q: protocol must be tcp or udp", s)
+	}
+	return Knock{Port: n, Protocol: protocol}, nil
+}
+
+// resolveKnockOptions converts KnockMessage to KnockOptions with defaults
+func resolveKnockOptions(msg *KnockMessage) (KnockOptions, error) {
+	opts := KnockOptions{
+		Host:     strings.Trim(strings.TrimSpace(msg.Host), "[]"),
+		Family:   tool.GetOrDefault(msg.Family, "ip"),
+		Port:     msg.Port,
+		Delay:    time.Duration(tool.GetOrDefault(msg.Delay, tool.Duration(defaultDelay))),
+		Wait:     time.Duration(tool.GetOrDefault(msg.Wait, tool.Duration(defaultWait))),
+		Payload:  []byte(tool.GetOrDefault(msg.Payload, "")),
+		PreCheck: tool.GetOrDefault(msg.PreCheck, true),
+		Timeout:  time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+	}
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if opts.Family != "ip" && opts.Family != "ip4" && opts.Family != "ip6" {
+		return opts, fmt.Errorf("family must be ip4 or ip6")
+	}
+	if len(msg.Sequence) == 0 || len(msg.Sequence) > maxKnocks {
+		return opts, fmt.Errorf("sequence must hold between 1 and %d knocks", maxKnocks)
+	}
+	for _, s := range msg.Sequence {
+		knock, err := parseKnock(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Sequence = append(opts.Sequence, knock)
+	}
+	if opts.Port <= 0 || opts.Port > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535")
+	}
+	if opts.Delay < 0 || opts.Delay > maxDelay || opts.Wait < 0 || opts.Wait > maxDelay {
+		return opts, fmt.Errorf("delay and wait must be between 0 and %s", maxDelay)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// dialOutcome classifies the result of a TCP connection attempt
+func dialOutcome(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return OutcomeOpen
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return OutcomeRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return OutcomeTimeout
+	}
+	return ""
+}
+
+// send sends one knock. TCP knocks are a single SYN, as knock daemons only
+// watch for the connection attempt; UDP knocks are a single datagram.
+func send(addr *net.IPAddr, knock Knock, payload []byte) (string, error) {
+	target := net.JoinHostPort(addr.String(), strconv.Itoa(knock.Port))
+	if knock.Protocol == "udp" {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		if _, err := conn.Write(payload); err != nil {
+			return "", err
+		}
+		return OutcomeSent, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", target, knockTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	if outcome := dialOutcome(err); outcome != "" {
+		return outcome, nil
+	}
+	return "", err
+}
+
+// check tests whether the protected port accepts a connection
+func check(addr *net.IPAddr, opts KnockOptions, stage string) CheckMessage {
+	result := CheckMessage{Type: "check", Stage: stage, Port: opts.Port}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr.String(), strconv.Itoa(opts.Port)), opts.Timeout)
+	if err == nil {
+		result.Latency = float64(time.Since(start).Microseconds()) / 1000.0
+		conn.Close()
+	}
+	result.Outcome = dialOutcome(err)
+	result.Open = result.Outcome == OutcomeOpen
+	if result.Outcome == "" {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// run tests the port, sends the sequence and tests the port again, calling
+// report with each check and knock. An error returned by report ends the run.
+func run(opts KnockOptions, report func(any) error) SummaryMessage {
+	summary := SummaryMessage{Type: "summary", Host: opts.Host}
+	addr, err := net.ResolveIPAddr(opts.Family, opts.Host)
+	if err != nil {
+		summary.Error = fmt.Sprintf("error resolving %s: %v", opts.Host, err)
+		return summary
+	}
+	summary.Address = addr.String()
+
+	if opts.PreCheck {
+		before := check(addr, opts, "before")
+		summary.OpenBefore = &before.Open
+		if err := report(before); err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+	}
+
+	start := time.Now()
+	for i, knock := range opts.Sequence {
+		if i > 0 {
+			time.Sleep(opts.Delay)
+		}
+		elapsed := float64(time.Since(start).Microseconds()) / 1000.0
+		outcome, err := send(addr, knock, opts.Payload)
+		if err != nil {
+			summary.Error = fmt.Sprintf("error sending knock %d: %v", i+1, err)
+			return summary
+		}
+		summary.Knocks++
+		result := KnockResult{
+			Type:     "knock",
+			Index:    i + 1,
+			Port:     knock.Port,
+			Protocol: knock.Protocol,
+			Outcome:  outcome,
+			Elapsed:  elapsed,
+		}
+		if err := report(result); err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+	}
+
+	time.Sleep(opts.Wait)
+	after := check(addr, opts, "after")
+	summary.OpenAfter = after.Open
+	summary.Opened = after.Open && (summary.OpenBefore == nil || !*summary.OpenBefore)
+	summary.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	if err := report(after); err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// Handler handles WebSocket port knocking requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "knock")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg KnockMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading knock message: %v", err)
+		return
+	}
+
+	opts, err := resolveKnockOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid knock options: %v", err)
+		return
+	}
+
+	summary := run(opts, func(msg any) error {
+		session.CountProbe()
+		return session.WriteJSON(msg)
+	})
+	log.Printf("Knocked %d times on %s, port %d opened: %t", summary.Knocks, opts.Host, opts.Port, summary.Opened)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
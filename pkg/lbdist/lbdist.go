@@ -0,0 +1,314 @@
+package lbdist
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for load balancer analysis options
+const (
+	defaultCount   = 50 // Number of requests to send
+	defaultWait    = 0  // Milliseconds between requests
+	defaultTimeout = 5  // 5 second timeout per request
+	maxCount       = 1000
+	maxBodySize    = 64 * 1024
+	// evenThreshold is the largest coefficient of variation of the per
+	// backend request counts that is still considered an even distribution
+	evenThreshold = 0.25
+)
+
+// backendHeaders are response headers that commonly identify a backend
+var backendHeaders = []string{
+	"Server", "Via", "ETag",
+	"X-Served-By", "X-Server", "X-Backend", "X-Backend-Server", "X-Upstream",
+	"X-Host", "X-Node", "X-Instance", "X-Pod", "X-Powered-By",
+}
+
+// affinityCookies are cookie names whose value identifies a backend. Cookies
+// starting with BIGipServer carry the encoded backend address.
+var affinityCookies = []string{"SERVERID", "ROUTEID", "ROUTE", "BACKEND", "AWSALB"}
+
+// LBMessage represents the incoming load balancer analysis request
+type LBMessage struct {
+	// Required
+	URL string `json:"url"` // URL of the virtual IP to analyze
+
+	// Optional parameters
+	Count    *int              `json:"count,omitempty"`    // Number of requests to send
+	Wait     *int              `json:"wait,omitempty"`     // Milliseconds between requests
+	Headers  map[string]string `json:"headers,omitempty"`  // Extra request headers
+	Signals  []string          `json:"signals,omitempty"`  // Extra response headers identifying a backend
+	Timeout  *int              `json:"timeout,omitempty"`  // Timeout in seconds per request
+	Insecure *bool             `json:"insecure,omitempty"` // Skip TLS certificate verification
+}
+
+// ResponseMessage reports a single request and the backend it was attributed to
+type ResponseMessage struct {
+	Type     string            `json:"type"`              // Message type ("response")
+	Sequence int               `json:"sequence"`          // Request number
+	Success  bool              `json:"success"`           // Whether a response was received
+	Error    string            `json:"error,omitempty"`   // Error when the request failed
+	Status   int               `json:"status,omitempty"`  // HTTP status code
+	Backend  int               `json:"backend,omitempty"` // Backend cluster the response belongs to
+	Signals  map[string]string `json:"signals,omitempty"` // Identifying signals seen in the response
+	Latency  float64           `json:"latency"`           // Request duration in milliseconds
+}
+
+// Backend describes one cluster of responses sharing the same signals
+type Backend struct {
+	ID        int               `json:"id"`         // Backend cluster number
+	Signals   map[string]string `json:"signals"`    // Signals shared by every response
+	Count     int               `json:"count"`      // Responses served
+	Share     float64           `json:"share"`      // Fraction of successful responses
+	LatencyMs float64           `json:"latency_ms"` // Mean latency in milliseconds
+	StdDevMs  float64           `json:"stddev_ms"`  // Latency standard deviation in milliseconds
+}
+
+// SummaryMessage estimates the backend pool behind the virtual IP
+type SummaryMessage struct {
+	Type       string    `json:"type"`       // Message type ("summary")
+	Requests   int       `json:"requests"`   // Requests sent
+	Failed     int       `json:"failed"`     // Requests that got no response
+	Identified bool      `json:"identified"` // Whether any identifying signal was seen
+	Backends   []Backend `json:"backends"`   // Distinct backends, most used first
+	Estimated  int       `json:"estimated"`  // Estimated number of backends
+	Variation  float64   `json:"variation"`  // Coefficient of variation of the counts
+	Even       bool      `json:"even"`       // Whether requests were spread evenly
+}
+
+// LBOptions contains the resolved load balancer analysis options
+type LBOptions struct {
+	URL        string
+	Count      int
+	Wait       int
+	Headers    map[string]string
+	Signals    []string
+	Timeout    int
+	IsInsecure bool
+}
+
+// resolveLBOptions converts LBMessage to LBOptions with defaults
+func resolveLBOptions(msg *LBMessage) (LBOptions, error) {
+	opts := LBOptions{
+		URL:        msg.URL,
+		Count:      tool.GetOrDefault(msg.Count, defaultCount),
+		Wait:       tool.GetOrDefault(msg.Wait, defaultWait),
+		Headers:    msg.Headers,
+		Signals:    append(append([]string{}, backendHeaders...), msg.Signals...),
+		Timeout:    tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		IsInsecure: tool.GetOrDefault(msg.Insecure, false),
+	}
+
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return opts, fmt.Errorf("invalid url %q", opts.URL)
+	}
+	if opts.Count <= 0 || opts.Count > maxCount {
+		return opts, fmt.Errorf("count must be between 1 and %d", maxCount)
+	}
+	if opts.Wait < 0 {
+		return opts, fmt.Errorf("wait cannot be negative")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// isAffinityCookie reports whether a cookie name identifies a backend
+func isAffinityCookie(name string) bool {
+	upper := strings.ToUpper(name)
+	if strings.HasPrefix(upper, "BIGIPSERVER") {
+		return true
+	}
+	for _, cookie := range affinityCookies {
+		if upper == cookie {
+			return true
+		}
+	}
+	return false
+}
+
+// signals extracts the backend identifying signals from a response
+func signals(opts LBOptions, resp *http.Response) map[string]string {
+	found := make(map[string]string)
+	for _, name := range opts.Signals {
+		if value := resp.Header.Get(name); value != "" {
+			found[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	for _, cookie := range resp.Cookies() {
+		if isAffinityCookie(cookie.Name) {
+			found["cookie:"+cookie.Name] = cookie.Value
+		}
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		found["tls_serial"] = resp.TLS.PeerCertificates[0].SerialNumber.Text(16)
+	}
+	return found
+}
+
+// signature returns a stable key for a set of signals
+func signature(found map[string]string) string {
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, found[key])
+	}
+	return b.String()
+}
+
+// cluster collects the responses of one backend
+type cluster struct {
+	backend   Backend
+	latencies []float64
+}
+
+// summarize estimates the backend pool from the clustered responses
+func summarize(summary *SummaryMessage, clusters []*cluster) {
+	successful := summary.Requests - summary.Failed
+	summary.Backends = []Backend{}
+	for _, c := range clusters {
+		var sum, sq float64
+		for _, l := range c.latencies {
+			sum += l
+		}
+		mean := sum / float64(len(c.latencies))
+		for _, l := range c.latencies {
+			sq += (l - mean) * (l - mean)
+		}
+		c.backend.LatencyMs = mean
+		c.backend.StdDevMs = math.Sqrt(sq / float64(len(c.latencies)))
+		c.backend.Share = float64(c.backend.Count) / float64(successful)
+		summary.Backends = append(summary.Backends, c.backend)
+		if len(c.backend.Signals) > 0 {
+			summary.Identified = true
+		}
+	}
+	sort.SliceStable(summary.Backends, func(i, j int) bool {
+		return summary.Backends[i].Count > summary.Backends[j].Count
+	})
+	summary.Estimated = len(summary.Backends)
+
+	if n := len(summary.Backends); n > 1 {
+		mean := float64(successful) / float64(n)
+		var sq float64
+		for _, b := range summary.Backends {
+			sq += (float64(b.Count) - mean) * (float64(b.Count) - mean)
+		}
+		summary.Variation = math.Sqrt(sq/float64(n)) / mean
+	}
+	summary.Even = summary.Estimated > 0 && summary.Variation <= evenThreshold
+}
+
+// Handler handles WebSocket load balancer analysis requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "lb")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg LBMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading lb message: %v", err)
+		return
+	}
+
+	opts, err := resolveLBOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid lb options: %v", err)
+		return
+	}
+
+	// Every request uses a new connection so the load balancer makes a
+	// fresh backend choice each time
+	client := &http.Client{
+		Timeout: time.Duration(opts.Timeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: opts.IsInsecure},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	summary := SummaryMessage{Type: "summary"}
+	clusters := make(map[string]*cluster)
+	var order []*cluster
+
+	for sequence := 0; sequence < opts.Count; sequence++ {
+		if sequence > 0 && opts.Wait > 0 {
+			time.Sleep(time.Duration(opts.Wait) * time.Millisecond)
+		}
+		summary.Requests++
+		result := ResponseMessage{Type: "response", Sequence: sequence}
+
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, opts.URL, nil)
+		if err != nil {
+			log.Printf("Failed to create request: %v", err)
+			return
+		}
+		tool.Identify(req)
+		for name, value := range opts.Headers {
+			req.Header.Set(name, value)
+		}
+
+		session.CountProbe()
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Error = err.Error()
+			summary.Failed++
+		} else {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+			resp.Body.Close()
+			result.Success = true
+			result.Status = resp.StatusCode
+			result.Signals = signals(opts, resp)
+		}
+		result.Latency = float64(time.Since(start).Microseconds()) / 1000.0
+
+		if result.Success {
+			key := signature(result.Signals)
+			c, ok := clusters[key]
+			if !ok {
+				c = &cluster{backend: Backend{ID: len(order) + 1, Signals: result.Signals}}
+				clusters[key] = c
+				order = append(order, c)
+			}
+			c.backend.Count++
+			c.latencies = append(c.latencies, result.Latency)
+			result.Backend = c.backend.ID
+		}
+
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send response: %v", err)
+			return
+		}
+	}
+
+	summarize(&summary, order)
+	log.Printf("Load balancer %s: %d backends from %d requests", opts.URL, summary.Estimated, summary.Requests)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
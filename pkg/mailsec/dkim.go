@@ -0,0 +1,119 @@
+package mailsec
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+)
+
+// Minimum RSA key sizes for DKIM keys
+const (
+	minRSABits         = 1024 // Keys below this size are broken
+	recommendedRSABits = 2048 // Keys below this size are weak
+)
+
+// DKIMSelector describes the key published for one selector
+type DKIMSelector struct {
+	Selector string `json:"selector"`           // Selector name
+	KeyType  string `json:"key_type,omitempty"` // Key algorithm (rsa, ed25519)
+	KeyBits  int    `json:"key_bits,omitempty"` // Key size in bits
+	Testing  bool   `json:"testing"`            // Whether the t=y flag is set
+	Revoked  bool   `json:"revoked"`            // Whether the public key is empty
+}
+
+// DKIMDetails lists the selectors that were found
+type DKIMDetails struct {
+	Selectors []DKIMSelector `json:"selectors"` // Selectors with a published key
+}
+
+// checkDKIM looks up the configured DKIM selectors and validates their keys
+func checkDKIM(ctx context.Context, opts MailSecOptions) *CheckMessage {
+	check := newCheck(CheckDKIM)
+	details := &DKIMDetails{Selectors: []DKIMSelector{}}
+	check.Details = details
+
+	for _, selector := range opts.DKIMSelectors {
+		name := selector + "._domainkey." + opts.Domain
+		records, err := lookupTXT(ctx, name, "")
+		if err != nil {
+			check.errorf("error looking up %s: %v", name, err)
+			continue
+		}
+		for _, record := range records {
+			tags, _ := parseTags(record)
+			if _, ok := tags["p"]; !ok {
+				continue
+			}
+			check.Found = true
+			check.Records = append(check.Records, record)
+			details.Selectors = append(details.Selectors, parseDKIMKey(check, selector, tags))
+		}
+	}
+
+	if !check.Found {
+		check.warnf("no DKIM key found for selectors %s", strings.Join(opts.DKIMSelectors, ", "))
+	}
+	return check
+}
+
+// parseDKIMKey validates the tags of a DKIM key record
+func parseDKIMKey(check *CheckMessage, selector string, tags map[string]string) DKIMSelector {
+	key := DKIMSelector{Selector: selector, KeyType: "rsa"}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		check.errorf("%s: invalid version %q", selector, v)
+	}
+	if k, ok := tags["k"]; ok {
+		key.KeyType = strings.ToLower(k)
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			key.Testing = true
+			check.warnf("%s: key is in testing mode (t=y)", selector)
+		}
+	}
+
+	p := strings.Join(strings.Fields(tags["p"]), "")
+	if p == "" {
+		key.Revoked = true
+		check.warnf("%s: key is revoked (empty p=)", selector)
+		return key
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		check.errorf("%s: public key is not valid base64: %v", selector, err)
+		return key
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			check.errorf("%s: invalid RSA public key: %v", selector, err)
+			return key
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			check.errorf("%s: k=rsa but the key is not an RSA key", selector)
+			return key
+		}
+		key.KeyBits = rsaKey.N.BitLen()
+		if key.KeyBits < minRSABits {
+			check.errorf("%s: %d bit RSA key is too short", selector, key.KeyBits)
+		} else if key.KeyBits < recommendedRSABits {
+			check.warnf("%s: %d bit RSA key is weak, use %d bits", selector, key.KeyBits, recommendedRSABits)
+		}
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			check.errorf("%s: ed25519 key must be %d bytes", selector, ed25519.PublicKeySize)
+			return key
+		}
+		key.KeyBits = ed25519.PublicKeySize * 8
+	default:
+		check.errorf("%s: unknown key type %q", selector, key.KeyType)
+	}
+	return key
+}
@@ -0,0 +1,120 @@
+package mailsec
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// DMARCDetails describes a parsed DMARC policy
+type DMARCDetails struct {
+	Policy          string   `json:"policy"`                     // Policy for the domain (p=)
+	SubdomainPolicy string   `json:"subdomain_policy,omitempty"` // Policy for subdomains (sp=)
+	Percent         int      `json:"percent"`                    // Share of mail the policy applies to (pct=)
+	AlignDKIM       string   `json:"align_dkim"`                 // DKIM alignment mode (adkim=)
+	AlignSPF        string   `json:"align_spf"`                  // SPF alignment mode (aspf=)
+	AggregateURIs   []string `json:"rua"`                        // Aggregate report URIs
+	ForensicURIs    []string `json:"ruf"`                        // Failure report URIs
+}
+
+// validPolicy reports whether p is a DMARC policy value
+func validPolicy(p string) bool {
+	return p == "none" || p == "quarantine" || p == "reject"
+}
+
+// parseURIs splits a DMARC or TLS-RPT report URI list and validates each URI
+func parseURIs(check *CheckMessage, tag, value string, schemes ...string) []string {
+	uris := []string{}
+	for _, uri := range strings.Split(value, ",") {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		valid := false
+		for _, scheme := range schemes {
+			if strings.HasPrefix(strings.ToLower(uri), scheme+":") {
+				valid = true
+			}
+		}
+		if !valid {
+			check.errorf("%s: unsupported report URI %q", tag, uri)
+		}
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// checkDMARC validates the DMARC policy of the domain
+func checkDMARC(ctx context.Context, opts MailSecOptions) *CheckMessage {
+	check := newCheck(CheckDMARC)
+
+	name := "_dmarc." + opts.Domain
+	records, err := lookupTXT(ctx, name, "v=DMARC1")
+	if err != nil {
+		check.errorf("error looking up %s: %v", name, err)
+		return check
+	}
+	if len(records) == 0 {
+		check.errorf("no DMARC record published at %s", name)
+		return check
+	}
+	if len(records) > 1 {
+		check.errorf("%d DMARC records published, only one is allowed", len(records))
+	}
+	check.Found = true
+	check.Records = records
+
+	tags, order := parseTags(records[0])
+	details := &DMARCDetails{Percent: 100, AlignDKIM: "r", AlignSPF: "r"}
+	check.Details = details
+
+	if len(order) == 0 || order[0] != "v" || tags["v"] != "DMARC1" {
+		check.errorf("record must start with v=DMARC1")
+	}
+	if len(order) < 2 || order[1] != "p" {
+		check.errorf("p= must directly follow the version tag")
+	}
+
+	details.Policy = strings.ToLower(tags["p"])
+	switch {
+	case !validPolicy(details.Policy):
+		check.errorf("invalid policy p=%q", tags["p"])
+	case details.Policy == "none":
+		check.warnf("p=none only monitors, failing mail is still delivered")
+	}
+	if sp, ok := tags["sp"]; ok {
+		details.SubdomainPolicy = strings.ToLower(sp)
+		if !validPolicy(details.SubdomainPolicy) {
+			check.errorf("invalid subdomain policy sp=%q", sp)
+		} else if details.SubdomainPolicy == "none" && details.Policy != "none" {
+			check.warnf("sp=none leaves subdomains unprotected")
+		}
+	}
+	if pct, ok := tags["pct"]; ok {
+		n, err := strconv.Atoi(pct)
+		if err != nil || n < 0 || n > 100 {
+			check.errorf("pct must be between 0 and 100, got %q", pct)
+		} else {
+			details.Percent = n
+			if n < 100 {
+				check.warnf("pct=%d applies the policy to only part of the mail", n)
+			}
+		}
+	}
+	for tag, mode := range map[string]*string{"adkim": &details.AlignDKIM, "aspf": &details.AlignSPF} {
+		if v, ok := tags[tag]; ok {
+			if v != "r" && v != "s" {
+				check.errorf("%s must be r or s, got %q", tag, v)
+				continue
+			}
+			*mode = v
+		}
+	}
+
+	details.AggregateURIs = parseURIs(check, "rua", tags["rua"], "mailto", "https")
+	details.ForensicURIs = parseURIs(check, "ruf", tags["ruf"], "mailto", "https")
+	if len(details.AggregateURIs) == 0 {
+		check.warnf("no rua= address, aggregate reports are not collected")
+	}
+	return check
+}
@@ -0,0 +1,206 @@
+package mailsec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for email security analysis options
+const (
+	defaultTimeout = 10 // 10 second timeout for the whole analysis
+)
+
+// defaultSelectors are commonly used DKIM selectors tried when none are given
+var defaultSelectors = []string{
+	"default", "dkim", "google", "k1", "k2", "mail", "s1", "s2",
+	"selector1", "selector2", "smtp", "mxvault",
+}
+
+// Names of the individual checks
+const (
+	CheckSPF    = "spf"
+	CheckDKIM   = "dkim"
+	CheckDMARC  = "dmarc"
+	CheckMTASTS = "mta-sts"
+	CheckTLSRPT = "tls-rpt"
+)
+
+// MailSecMessage represents the incoming email security analysis request
+type MailSecMessage struct {
+	// Required
+	Domain string `json:"domain"` // Domain to analyze
+
+	// Optional parameters
+	DKIMSelectors []string `json:"dkim_selectors,omitempty"` // DKIM selectors to look up
+	Timeout       *int     `json:"timeout,omitempty"`        // Timeout in seconds
+}
+
+// CheckMessage reports the result of a single policy check
+type CheckMessage struct {
+	Type     string   `json:"type"`              // Message type ("check")
+	Check    string   `json:"check"`             // Check name (spf, dkim, dmarc, mta-sts, tls-rpt)
+	Found    bool     `json:"found"`             // Whether a policy was published
+	Records  []string `json:"records"`           // Raw records that were evaluated
+	Errors   []string `json:"errors"`            // Syntax errors and broken policies
+	Warnings []string `json:"warnings"`          // Weak but valid policies
+	Details  any      `json:"details,omitempty"` // Check specific parsed values
+}
+
+// SummaryMessage summarises all checks for a domain
+type SummaryMessage struct {
+	Type     string   `json:"type"`     // Message type ("summary")
+	Domain   string   `json:"domain"`   // Domain that was analyzed
+	Errors   int      `json:"errors"`   // Total number of errors
+	Warnings int      `json:"warnings"` // Total number of warnings
+	Missing  []string `json:"missing"`  // Checks with no published policy
+}
+
+// MailSecOptions contains the resolved email security analysis options
+type MailSecOptions struct {
+	Domain        string
+	DKIMSelectors []string
+	Timeout       int
+}
+
+// resolveMailSecOptions converts MailSecMessage to MailSecOptions with defaults
+func resolveMailSecOptions(msg *MailSecMessage) (MailSecOptions, error) {
+	opts := MailSecOptions{
+		Domain:        strings.TrimSuffix(strings.ToLower(strings.TrimSpace(msg.Domain)), "."),
+		DKIMSelectors: msg.DKIMSelectors,
+		Timeout:       tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if opts.Domain == "" || strings.ContainsAny(opts.Domain, " /:@") {
+		return opts, fmt.Errorf("invalid domain %q", msg.Domain)
+	}
+	if len(opts.DKIMSelectors) == 0 {
+		opts.DKIMSelectors = defaultSelectors
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// newCheck creates an empty check result
+func newCheck(name string) *CheckMessage {
+	return &CheckMessage{
+		Type:     "check",
+		Check:    name,
+		Records:  []string{},
+		Errors:   []string{},
+		Warnings: []string{},
+	}
+}
+
+// errorf records a syntax error or broken policy
+func (c *CheckMessage) errorf(format string, args ...any) {
+	c.Errors = append(c.Errors, fmt.Sprintf(format, args...))
+}
+
+// warnf records a weak policy
+func (c *CheckMessage) warnf(format string, args ...any) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// lookupTXT returns the TXT records at name that start with the version tag
+// prefix. A name that does not exist is not an error.
+func lookupTXT(ctx context.Context, name, prefix string) ([]string, error) {
+	txts, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []string
+	for _, txt := range txts {
+		if len(txt) < len(prefix) || !strings.EqualFold(txt[:len(prefix)], prefix) {
+			continue
+		}
+		if rest := txt[len(prefix):]; prefix != "" && rest != "" && !strings.ContainsAny(rest[:1], " ;") {
+			continue
+		}
+		records = append(records, txt)
+	}
+	return records, nil
+}
+
+// parseTags parses a tag=value list such as "v=DMARC1; p=reject"
+func parseTags(record string) (map[string]string, []string) {
+	tags := make(map[string]string)
+	var order []string
+	for _, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		tags[name] = strings.TrimSpace(value)
+		order = append(order, name)
+	}
+	return tags, order
+}
+
+// Handler handles WebSocket email security analysis requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "mailsec")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg MailSecMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading mailsec message: %v", err)
+		return
+	}
+
+	opts, err := resolveMailSecOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid mailsec options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+
+	checks := []func(context.Context, MailSecOptions) *CheckMessage{
+		checkSPF,
+		checkDKIM,
+		checkDMARC,
+		checkMTASTS,
+		checkTLSRPT,
+	}
+
+	summary := SummaryMessage{Type: "summary", Domain: opts.Domain, Missing: []string{}}
+	for _, check := range checks {
+		result := check(ctx, opts)
+		summary.Errors += len(result.Errors)
+		summary.Warnings += len(result.Warnings)
+		if !result.Found {
+			summary.Missing = append(summary.Missing, result.Check)
+		}
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send check result: %v", err)
+			return
+		}
+	}
+
+	log.Printf("Mail security for %s: %d errors, %d warnings", opts.Domain, summary.Errors, summary.Warnings)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
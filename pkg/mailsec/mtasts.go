@@ -0,0 +1,208 @@
+package mailsec
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Limits on MTA-STS policies from RFC 8461
+const (
+	maxPolicySize = 64 * 1024 // Largest policy file that is read
+	minMaxAge     = 86400     // Policies cached for less than a day are weak
+	maxMaxAge     = 31557600  // Largest allowed max_age (about one year)
+)
+
+// MTASTSDetails describes a published MTA-STS policy
+type MTASTSDetails struct {
+	ID        string   `json:"id"`                // Policy id from the TXT record
+	PolicyURL string   `json:"policy_url"`        // URL the policy was fetched from
+	Mode      string   `json:"mode,omitempty"`    // enforce, testing or none
+	MaxAge    int      `json:"max_age,omitempty"` // Cache lifetime in seconds
+	MX        []string `json:"mx"`                // Allowed MX patterns
+	Unmatched []string `json:"unmatched_mx"`      // MX hosts not covered by the policy
+}
+
+// TLSRPTDetails describes a published TLS-RPT policy
+type TLSRPTDetails struct {
+	URIs []string `json:"rua"` // Report destinations
+}
+
+// matchMX reports whether host matches an MTA-STS mx pattern
+func matchMX(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		label, rest, found := strings.Cut(host, ".")
+		return found && label != "" && rest == suffix
+	}
+	return pattern == host
+}
+
+// fetchPolicy downloads the MTA-STS policy file
+func fetchPolicy(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	tool.Identify(req)
+	client := &http.Client{
+		// Redirects must not be followed (RFC 8461 section 3.3)
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPolicySize))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// checkMTASTS validates the MTA-STS record and policy of the domain
+func checkMTASTS(ctx context.Context, opts MailSecOptions) *CheckMessage {
+	check := newCheck(CheckMTASTS)
+
+	name := "_mta-sts." + opts.Domain
+	records, err := lookupTXT(ctx, name, "v=STSv1")
+	if err != nil {
+		check.errorf("error looking up %s: %v", name, err)
+		return check
+	}
+	if len(records) == 0 {
+		check.warnf("no MTA-STS record published, SMTP TLS can be downgraded")
+		return check
+	}
+	if len(records) > 1 {
+		check.errorf("%d MTA-STS records published, only one is allowed", len(records))
+	}
+	check.Found = true
+	check.Records = records
+
+	tags, _ := parseTags(records[0])
+	details := &MTASTSDetails{
+		ID:        tags["id"],
+		PolicyURL: "https://mta-sts." + opts.Domain + "/.well-known/mta-sts.txt",
+		MX:        []string{},
+		Unmatched: []string{},
+	}
+	check.Details = details
+	if details.ID == "" || len(details.ID) > 32 {
+		check.errorf("id must be 1 to 32 alphanumeric characters, got %q", details.ID)
+	}
+
+	policy, err := fetchPolicy(ctx, details.PolicyURL)
+	if err != nil {
+		check.errorf("failed to fetch policy: %v", err)
+		return check
+	}
+	check.Records = append(check.Records, policy)
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(policy))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "mx" {
+			details.MX = append(details.MX, value)
+			continue
+		}
+		fields[key] = value
+	}
+
+	if fields["version"] != "STSv1" {
+		check.errorf("policy version must be STSv1, got %q", fields["version"])
+	}
+	details.Mode = fields["mode"]
+	switch details.Mode {
+	case "enforce":
+	case "testing":
+		check.warnf("mode is testing, TLS failures do not block delivery")
+	case "none":
+		check.warnf("mode is none, the policy is being withdrawn")
+	default:
+		check.errorf("invalid mode %q", details.Mode)
+	}
+	if age, err := strconv.Atoi(fields["max_age"]); err != nil {
+		check.errorf("invalid max_age %q", fields["max_age"])
+	} else {
+		details.MaxAge = age
+		if age > maxMaxAge {
+			check.errorf("max_age %d exceeds the limit of %d", age, maxMaxAge)
+		} else if age < minMaxAge {
+			check.warnf("max_age %d is shorter than a day", age)
+		}
+	}
+	if len(details.MX) == 0 && details.Mode != "none" {
+		check.errorf("policy lists no mx patterns")
+	}
+
+	mxs, err := net.DefaultResolver.LookupMX(ctx, opts.Domain)
+	if err != nil {
+		check.warnf("error looking up MX records: %v", err)
+		return check
+	}
+	for _, mx := range mxs {
+		matched := false
+		for _, pattern := range details.MX {
+			if matchMX(pattern, mx.Host) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			details.Unmatched = append(details.Unmatched, strings.TrimSuffix(mx.Host, "."))
+		}
+	}
+	if len(details.Unmatched) > 0 {
+		check.errorf("MX hosts not covered by the policy: %s", strings.Join(details.Unmatched, ", "))
+	}
+	return check
+}
+
+// checkTLSRPT validates the SMTP TLS reporting record of the domain
+func checkTLSRPT(ctx context.Context, opts MailSecOptions) *CheckMessage {
+	check := newCheck(CheckTLSRPT)
+
+	name := "_smtp._tls." + opts.Domain
+	records, err := lookupTXT(ctx, name, "v=TLSRPTv1")
+	if err != nil {
+		check.errorf("error looking up %s: %v", name, err)
+		return check
+	}
+	if len(records) == 0 {
+		check.warnf("no TLS-RPT record published, TLS delivery failures are not reported")
+		return check
+	}
+	if len(records) > 1 {
+		check.errorf("%d TLS-RPT records published, only one is allowed", len(records))
+	}
+	check.Found = true
+	check.Records = records
+
+	tags, _ := parseTags(records[0])
+	details := &TLSRPTDetails{URIs: parseURIs(check, "rua", tags["rua"], "mailto", "https")}
+	check.Details = details
+	if len(details.URIs) == 0 {
+		check.errorf("rua= must list at least one report destination")
+	}
+	return check
+}
@@ -0,0 +1,175 @@
+package mailsec
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// maxSPFLookups is the DNS lookup limit from RFC 7208 section 4.6.4
+const maxSPFLookups = 10
+
+// SPFDetails describes a parsed SPF policy
+type SPFDetails struct {
+	All      string   `json:"all,omitempty"`      // Qualifier of the all mechanism, e.g. "-all"
+	Redirect string   `json:"redirect,omitempty"` // Target of the redirect modifier
+	Includes []string `json:"includes"`           // Domains that are included
+	Lookups  int      `json:"lookups"`            // DNS lookups needed to evaluate the policy
+}
+
+// spfMechanisms maps each mechanism to whether it costs a DNS lookup
+var spfMechanisms = map[string]bool{
+	"all":     false,
+	"include": true,
+	"a":       true,
+	"mx":      true,
+	"ptr":     true,
+	"ip4":     false,
+	"ip6":     false,
+	"exists":  true,
+}
+
+// spfEvaluator walks an SPF policy and its includes counting DNS lookups
+type spfEvaluator struct {
+	ctx     context.Context
+	check   *CheckMessage
+	details *SPFDetails
+	seen    map[string]bool
+}
+
+// fetch returns the single SPF record of domain
+func (e *spfEvaluator) fetch(domain string) (string, bool) {
+	records, err := lookupTXT(e.ctx, domain, "v=spf1")
+	if err != nil {
+		e.check.errorf("error looking up SPF record for %s: %v", domain, err)
+		return "", false
+	}
+	switch len(records) {
+	case 0:
+		return "", false
+	case 1:
+		return records[0], true
+	default:
+		e.check.errorf("%s publishes %d SPF records, only one is allowed", domain, len(records))
+		return records[0], true
+	}
+}
+
+// evaluate parses the SPF record of domain. top is true for the analyzed
+// domain itself, whose all and redirect terms are reported.
+func (e *spfEvaluator) evaluate(domain, record string, top bool) {
+	if e.seen[domain] {
+		e.check.errorf("SPF include loop through %s", domain)
+		return
+	}
+	e.seen[domain] = true
+
+	terms := strings.Fields(record)
+	if len(terms) == 0 || !strings.EqualFold(terms[0], "v=spf1") {
+		e.check.errorf("%s: SPF record must start with v=spf1", domain)
+		return
+	}
+
+	for _, term := range terms[1:] {
+		if name, value, ok := strings.Cut(term, "="); ok && !strings.ContainsAny(name, ":/") {
+			e.modifier(domain, strings.ToLower(name), value, top)
+			continue
+		}
+
+		qualifier := "+"
+		if strings.ContainsAny(term[:1], "+-~?") {
+			qualifier, term = term[:1], term[1:]
+		}
+		name, value, _ := strings.Cut(term, ":")
+		name, _, _ = strings.Cut(strings.ToLower(name), "/")
+
+		lookup, known := spfMechanisms[name]
+		if !known {
+			e.check.errorf("%s: unknown SPF mechanism %q", domain, term)
+			continue
+		}
+		if lookup {
+			e.details.Lookups++
+		}
+
+		switch name {
+		case "all":
+			if top {
+				e.details.All = qualifier + "all"
+			}
+		case "include":
+			if value == "" {
+				e.check.errorf("%s: include without a domain", domain)
+				continue
+			}
+			if top {
+				e.details.Includes = append(e.details.Includes, value)
+			}
+			if included, ok := e.fetch(value); ok {
+				e.evaluate(value, included, false)
+			} else {
+				e.check.errorf("%s: included domain %s has no SPF record", domain, value)
+			}
+		case "ip4", "ip6":
+			if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+				e.check.errorf("%s: invalid %s address %q", domain, name, value)
+			}
+		case "ptr":
+			e.check.warnf("%s: the ptr mechanism is deprecated and slow", domain)
+		}
+	}
+}
+
+// modifier handles the redirect and exp modifiers
+func (e *spfEvaluator) modifier(domain, name, value string, top bool) {
+	switch name {
+	case "redirect":
+		e.details.Lookups++
+		if top {
+			e.details.Redirect = value
+		}
+		if target, ok := e.fetch(value); ok {
+			e.evaluate(value, target, false)
+		} else {
+			e.check.errorf("%s: redirect target %s has no SPF record", domain, value)
+		}
+	case "exp":
+	default:
+		// Unknown modifiers must be ignored (RFC 7208 section 6)
+	}
+}
+
+// checkSPF validates the SPF policy of the domain
+func checkSPF(ctx context.Context, opts MailSecOptions) *CheckMessage {
+	check := newCheck(CheckSPF)
+	details := &SPFDetails{Includes: []string{}}
+	e := &spfEvaluator{ctx: ctx, check: check, details: details, seen: make(map[string]bool)}
+
+	record, ok := e.fetch(opts.Domain)
+	if !ok {
+		if len(check.Errors) == 0 {
+			check.errorf("no SPF record published")
+		}
+		return check
+	}
+	check.Found = true
+	check.Records = append(check.Records, record)
+	check.Details = details
+
+	e.evaluate(opts.Domain, record, true)
+
+	if details.Lookups > maxSPFLookups {
+		check.errorf("SPF evaluation needs %d DNS lookups, more than the limit of %d", details.Lookups, maxSPFLookups)
+	}
+	switch details.All {
+	case "+all":
+		check.errorf("+all allows any server to send mail for the domain")
+	case "?all":
+		check.warnf("?all is neutral and gives no protection")
+	case "":
+		if details.Redirect == "" {
+			check.warnf("no all mechanism, unlisted senders get a neutral result")
+		}
+	}
+	return check
+}
@@ -0,0 +1,300 @@
+package marking
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for marking test options
+const (
+	defaultDSCP    = 46 // Expedited Forwarding
+	defaultECN     = ECNECT1
+	defaultPort    = 33434 // First port of classic traceroute
+	defaultMaxHops = 30
+	defaultTimeout = 1 // 1 second timeout per hop
+	maxHops        = 64
+	payloadSize    = 32
+)
+
+// ECN codepoints (RFC 3168), by the names used in messages
+const (
+	ECNNotECT = "not-ect" // Not ECN-capable transport
+	ECNECT1   = "ect1"    // ECN-capable transport (1), used by L4S
+	ECNECT0   = "ect0"    // ECN-capable transport (0)
+	ECNCE     = "ce"      // Congestion experienced
+)
+
+// ecnCodepoints maps ECN names to the low two bits of the TOS byte
+var ecnCodepoints = map[string]int{ECNNotECT: 0, ECNECT1: 1, ECNECT0: 2, ECNCE: 3}
+
+// ecnNames maps the low two bits of the TOS byte to ECN names
+var ecnNames = []string{ECNNotECT, ECNECT1, ECNECT0, ECNCE}
+
+// Outcomes for a marking seen by a node on the path
+const (
+	ResultPreserved  = "preserved"  // The marking arrived as sent
+	ResultRemarked   = "remarked"   // The marking was changed to another non-zero value
+	ResultBleached   = "bleached"   // The marking was cleared
+	ResultCongestion = "congestion" // ECN was set to CE by a congested queue, as intended
+	ResultUnknown    = "unknown"    // No node on the path quoted the probe
+)
+
+// MarkingMessage represents the incoming marking test request
+type MarkingMessage struct {
+	// Required
+	Host string `json:"host"` // Host to send the marked probes towards
+
+	// Optional parameters
+	Family  *string `json:"family,omitempty"`   // Address family to use ("ip4" or "ip6")
+	DSCP    *int    `json:"dscp,omitempty"`     // DSCP to mark probes with (0-63)
+	ECN     *string `json:"ecn,omitempty"`      // ECN codepoint to mark probes with (not-ect, ect1, ect0, ce)
+	Port    *int    `json:"port,omitempty"`     // UDP port of the first hop's probe, incremented per hop
+	MaxHops *int    `json:"max_hops,omitempty"` // Hops probed before giving up on the destination
+	Timeout *int    `json:"timeout,omitempty"`  // Timeout per hop in seconds
+}
+
+// HopMessage reports the markings a node on the path received
+type HopMessage struct {
+	Type       string  `json:"type"`                  // Message type ("hop")
+	TTL        int     `json:"ttl"`                   // TTL the probe was sent with
+	Address    string  `json:"address,omitempty"`     // Node that quoted the probe
+	Kind       string  `json:"kind,omitempty"`        // ICMP error the node sent (time_exceeded, port_unreachable, unreachable)
+	RTT        float64 `json:"rtt,omitempty"`         // Milliseconds until the ICMP error arrived
+	Timeout    bool    `json:"timeout"`               // Whether no node answered
+	TOS        *int    `json:"tos,omitempty"`         // TOS byte or traffic class as received
+	DSCP       *int    `json:"dscp,omitempty"`        // DSCP as received
+	ECN        string  `json:"ecn,omitempty"`         // ECN codepoint as received
+	DSCPResult string  `json:"dscp_result,omitempty"` // What happened to the DSCP up to this node
+	ECNResult  string  `json:"ecn_result,omitempty"`  // What happened to the ECN codepoint up to this node
+}
+
+// SummaryMessage reports the markings at the furthest node that answered
+type SummaryMessage struct {
+	Type       string `json:"type"`               // Message type ("summary")
+	Host       string `json:"host"`               // Host that was tested
+	Address    string `json:"address"`            // Resolved address
+	DSCP       int    `json:"dscp"`               // DSCP the probes were sent with
+	ECN        string `json:"ecn"`                // ECN codepoint the probes were sent with
+	Reached    bool   `json:"reached"`            // Whether the destination itself answered
+	Hops       int    `json:"hops"`               // Hops probed
+	DSCPResult string `json:"dscp_result"`        // What happened to the DSCP along the path
+	ECNResult  string `json:"ecn_result"`         // What happened to the ECN codepoint along the path
+	DSCPHop    int    `json:"dscp_hop,omitempty"` // First hop that received a changed DSCP
+	ECNHop     int    `json:"ecn_hop,omitempty"`  // First hop that received a changed ECN codepoint
+	Error      string `json:"error,omitempty"`    // Error that ended the test
+}
+
+// MarkingOptions contains the resolved marking test options
+type MarkingOptions struct {
+	Host    string
+	Family  string
+	DSCP    int
+	ECN     string
+	Port    int
+	MaxHops int
+	Timeout int
+}
+
+// resolveMarkingOptions converts MarkingMessage to MarkingOptions with defaults
+func resolveMarkingOptions(msg *MarkingMessage) (MarkingOptions, error) {
+	opts := MarkingOptions{
+		Host:    strings.Trim(strings.TrimSpace(msg.Host), "[]"),
+		Family:  tool.GetOrDefault(msg.Family, "ip"),
+		DSCP:    tool.GetOrDefault(msg.DSCP, defaultDSCP),
+		ECN:     tool.GetOrDefault(msg.ECN, defaultECN),
+		Port:    tool.GetOrDefault(msg.Port, defaultPort),
+		MaxHops: tool.GetOrDefault(msg.MaxHops, defaultMaxHops),
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if opts.Family != "ip" && opts.Family != "ip4" && opts.Family != "ip6" {
+		return opts, fmt.Errorf("family must be ip4 or ip6")
+	}
+	if opts.DSCP < 0 || opts.DSCP > 63 {
+		return opts, fmt.Errorf("dscp must be between 0 and 63")
+	}
+	if _, ok := ecnCodepoints[opts.ECN]; !ok {
+		return opts, fmt.Errorf("ecn must be one of not-ect, ect1, ect0 or ce")
+	}
+	if opts.MaxHops <= 0 || opts.MaxHops > maxHops {
+		return opts, fmt.Errorf("max_hops must be between 1 and %d", maxHops)
+	}
+	if opts.Port <= 0 || opts.Port+opts.MaxHops-1 > 65535 {
+		return opts, fmt.Errorf("port must leave room for %d hops below 65536", opts.MaxHops)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// dscpResult compares the DSCP a node received to the one sent
+func dscpResult(sent, received int) string {
+	switch {
+	case received == sent:
+		return ResultPreserved
+	case received == 0:
+		return ResultBleached
+	default:
+		return ResultRemarked
+	}
+}
+
+// ecnResult compares the ECN codepoint a node received to the one sent
+func ecnResult(sent, received int) string {
+	switch {
+	case received == sent:
+		return ResultPreserved
+	case received == 0:
+		return ResultBleached
+	case received == ecnCodepoints[ECNCE]:
+		return ResultCongestion
+	default:
+		return ResultRemarked
+	}
+}
+
+// Handler handles WebSocket marking test requests. Probes are UDP datagrams
+// with increasing TTLs, like traceroute's. Routers that drop them quote the
+// IP header they received in an ICMP time exceeded error, and the
+// destination quotes it in port unreachable, which shows where markings
+// change.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "marking")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg MarkingMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading marking message: %v", err)
+		return
+	}
+
+	opts, err := resolveMarkingOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid marking options: %v", err)
+		return
+	}
+
+	summary := run(opts, func(hop HopMessage) error {
+		session.CountProbe()
+		return session.WriteJSON(hop)
+	})
+	log.Printf("Markings to %s: dscp %s, ecn %s", opts.Host, summary.DSCPResult, summary.ECNResult)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
+
+// run probes hop by hop until the destination answers or the hops run out,
+// calling report for each hop. An error returned by report ends the test.
+func run(opts MarkingOptions, report func(HopMessage) error) SummaryMessage {
+	ecn := ecnCodepoints[opts.ECN]
+	summary := SummaryMessage{
+		Type:       "summary",
+		Host:       opts.Host,
+		DSCP:       opts.DSCP,
+		ECN:        opts.ECN,
+		DSCPResult: ResultUnknown,
+		ECNResult:  ResultUnknown,
+	}
+
+	ipAddr, err := net.ResolveIPAddr(opts.Family, opts.Host)
+	if err != nil {
+		summary.Error = fmt.Sprintf("error resolving %s: %v", opts.Host, err)
+		return summary
+	}
+	summary.Address = ipAddr.String()
+	v6 := ipAddr.IP.To4() == nil
+
+	quotes, err := probe.ListenQuotes(v6)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	defer quotes.Close()
+
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		summary.Error = fmt.Sprintf("error opening UDP socket: %v", err)
+		return summary
+	}
+	defer conn.Close()
+	marked := probe.NewTTLConn(conn, v6)
+	if err := marked.SetTOS(opts.DSCP<<2 | ecn); err != nil {
+		summary.Error = fmt.Sprintf("error setting TOS: %v", err)
+		return summary
+	}
+	srcPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	payload := probe.Payload(payloadSize)
+	timeout := time.Duration(opts.Timeout) * time.Second
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		summary.Hops = ttl
+		hop := HopMessage{Type: "hop", TTL: ttl}
+		if err := marked.SetTTL(ttl); err != nil {
+			summary.Error = fmt.Sprintf("error setting TTL: %v", err)
+			return summary
+		}
+
+		dstPort := opts.Port + ttl - 1
+		start := time.Now()
+		if _, err := conn.WriteTo(payload, &net.UDPAddr{IP: ipAddr.IP, Port: dstPort, Zone: ipAddr.Zone}); err != nil {
+			summary.Error = fmt.Sprintf("error sending probe: %v", err)
+			return summary
+		}
+		quote, err := quotes.Read(start.Add(timeout), probe.MatchUDP(srcPort, dstPort))
+		switch {
+		case errors.Is(err, probe.ErrTimeout):
+			hop.Timeout = true
+		case err != nil:
+			summary.Error = err.Error()
+			return summary
+		default:
+			hop.Address = quote.From.String()
+			hop.Kind = quote.Kind
+			hop.RTT = float64(time.Since(start).Microseconds()) / 1000.0
+			dscp := quote.TOS >> 2
+			hop.TOS, hop.DSCP = &quote.TOS, &dscp
+			hop.ECN = ecnNames[quote.TOS&3]
+			hop.DSCPResult = dscpResult(opts.DSCP, dscp)
+			hop.ECNResult = ecnResult(ecn, quote.TOS&3)
+
+			summary.DSCPResult, summary.ECNResult = hop.DSCPResult, hop.ECNResult
+			if summary.DSCPHop == 0 && hop.DSCPResult != ResultPreserved {
+				summary.DSCPHop = ttl
+			}
+			if summary.ECNHop == 0 && hop.ECNResult != ResultPreserved {
+				summary.ECNHop = ttl
+			}
+		}
+
+		if err := report(hop); err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+		if quote.Kind == probe.QuotePortUnreachable || quote.Kind == probe.QuoteUnreachable {
+			summary.Reached = quote.Kind == probe.QuotePortUnreachable
+			break
+		}
+	}
+	return summary
+}
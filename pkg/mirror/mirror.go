@@ -0,0 +1,425 @@
+package mirror
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/pcap"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Receiver limits
+const (
+	DefaultCapacity = 10000 // Mirrored frames kept by default
+	maxDatagramSize = 65535
+	maxSources      = 10000 // Distinct sensor sessions tracked before new ones are only counted
+	defaultTop      = 10
+	maxTop          = 100
+)
+
+// Encapsulations of mirrored traffic
+const (
+	EncapVXLAN   = "vxlan"   // VXLAN (RFC 7348), identified by its VNI
+	EncapERSPAN1 = "erspan1" // ERSPAN type I, bare GRE without a sequence number or session
+	EncapERSPAN2 = "erspan2" // ERSPAN type II, identified by its session ID
+	EncapERSPAN3 = "erspan3" // ERSPAN type III, identified by its session ID
+	EncapGRE     = "gre"     // Transparent Ethernet bridging over GRE, identified by its key
+)
+
+// GRE protocol types and flags
+const (
+	greProtoERSPAN2 = 0x88be // Also used by type I
+	greProtoERSPAN3 = 0x22eb
+	greProtoTEB     = 0x6558
+	greChecksum     = 0x80
+	greKey          = 0x20
+	greSequence     = 0x10
+	vxlanFlagVNI    = 0x08
+)
+
+// Source counts the frames mirrored by one sensor session
+type Source struct {
+	Address       string    `json:"address"`       // Switch or sensor sending the mirrored traffic
+	Encapsulation string    `json:"encapsulation"` // Encapsulation (vxlan, erspan1, erspan2, erspan3, gre)
+	Session       uint32    `json:"session"`       // VXLAN VNI, ERSPAN session ID or GRE key
+	Packets       int64     `json:"packets"`       // Frames received
+	Bytes         int64     `json:"bytes"`         // Bytes of inner frames received
+	LastSeen      time.Time `json:"last_seen"`     // Time the latest frame arrived
+}
+
+// Status reports the state of the receiver
+type Status struct {
+	Type      string   `json:"type"`      // Message type ("mirror")
+	Listeners []string `json:"listeners"` // Sockets mirrored traffic is received on
+	Received  int64    `json:"received"`  // Frames decapsulated
+	Malformed int64    `json:"malformed"` // Packets that could not be decapsulated
+	Buffered  int      `json:"buffered"`  // Frames kept for download and analysis
+	Capacity  int      `json:"capacity"`  // Most frames kept, the oldest being dropped first
+	Sources   []Source `json:"sources"`   // Sessions by frames received
+	Untracked int64    `json:"untracked"` // Frames from sessions beyond the tracking limit
+}
+
+// frame is a decapsulated mirrored frame
+type frame struct {
+	at      time.Time
+	data    []byte
+	source  netip.Addr
+	session uint32
+}
+
+// sourceKey identifies a sensor session
+type sourceKey struct {
+	addr    netip.Addr
+	encap   string
+	session uint32
+}
+
+// Receiver decapsulates mirrored traffic and keeps the latest frames in a
+// ring buffer for the capture tooling
+type Receiver struct {
+	mu        sync.Mutex
+	frames    []frame
+	next      int // Ring position of the next frame
+	capacity  int
+	sources   map[sourceKey]*Source
+	listeners []string
+	received  int64
+	malformed int64
+	untracked int64
+}
+
+// NewReceiver returns a receiver keeping the latest capacity frames
+func NewReceiver(capacity int) *Receiver {
+	return &Receiver{capacity: capacity, sources: make(map[sourceKey]*Source)}
+}
+
+// ListenVXLAN receives VXLAN encapsulated frames on the UDP address
+func (r *Receiver) ListenVXLAN(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting VXLAN receiver: %w", err)
+	}
+	r.addListener("vxlan " + conn.LocalAddr().String())
+	go r.serve(conn, func(b []byte) (string, uint32, []byte, bool) {
+		vni, inner, ok := decapVXLAN(b)
+		return EncapVXLAN, vni, inner, ok
+	})
+	return nil
+}
+
+// ListenERSPAN receives ERSPAN and GRE encapsulated frames on raw GRE
+// sockets, which need CAP_NET_RAW. IPv6 is skipped when unavailable.
+func (r *Receiver) ListenERSPAN() error {
+	conn, err := net.ListenPacket("ip4:gre", "0.0.0.0")
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("error starting ERSPAN receiver: raw sockets need CAP_NET_RAW: %w", err)
+		}
+		return fmt.Errorf("error starting ERSPAN receiver: %w", err)
+	}
+	r.addListener("gre 0.0.0.0")
+	go r.serve(conn, decapGRE)
+
+	if conn6, err := net.ListenPacket("ip6:gre", "::"); err != nil {
+		log.Printf("ERSPAN receiver over IPv6 unavailable: %v", err)
+	} else {
+		r.addListener("gre ::")
+		go r.serve(conn6, decapGRE)
+	}
+	return nil
+}
+
+// addListener records a socket in the status
+func (r *Receiver) addListener(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, name)
+}
+
+// serve reads encapsulated packets from conn until it fails
+func (r *Receiver) serve(conn net.PacketConn, decap func([]byte) (string, uint32, []byte, bool)) {
+	defer conn.Close()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("Mirror receiver stopped: %v", err)
+			return
+		}
+		encap, session, inner, ok := decap(buf[:n])
+		if !ok || len(inner) == 0 {
+			r.mu.Lock()
+			r.malformed++
+			r.mu.Unlock()
+			continue
+		}
+		r.add(peerAddr(peer), encap, session, inner)
+	}
+}
+
+// peerAddr returns the address of a UDP or IP peer
+func peerAddr(peer net.Addr) netip.Addr {
+	var ip net.IP
+	switch peer := peer.(type) {
+	case *net.UDPAddr:
+		ip = peer.IP
+	case *net.IPAddr:
+		ip = peer.IP
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// add keeps a decapsulated frame, dropping the oldest when full
+func (r *Receiver) add(source netip.Addr, encap string, session uint32, inner []byte) {
+	f := frame{at: time.Now(), data: bytes.Clone(inner), source: source, session: session}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received++
+	if len(r.frames) < r.capacity {
+		r.frames = append(r.frames, f)
+	} else if r.capacity > 0 {
+		r.frames[r.next] = f
+	}
+	if r.capacity > 0 {
+		r.next = (r.next + 1) % r.capacity
+	}
+
+	key := sourceKey{source, encap, session}
+	s, ok := r.sources[key]
+	if !ok {
+		// Spoofed datagrams can carry any source and session, so only so
+		// many are kept
+		if len(r.sources) >= maxSources {
+			r.untracked++
+			return
+		}
+		s = &Source{Address: source.String(), Encapsulation: encap, Session: session}
+		r.sources[key] = s
+	}
+	s.Packets++
+	s.Bytes += int64(len(inner))
+	s.LastSeen = f.at
+}
+
+// decapVXLAN returns the VNI and inner Ethernet frame of a VXLAN packet
+func decapVXLAN(b []byte) (uint32, []byte, bool) {
+	if len(b) < 8 || b[0]&vxlanFlagVNI == 0 {
+		return 0, nil, false
+	}
+	vni := uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	return vni, b[8:], true
+}
+
+// decapGRE returns the encapsulation, session and inner Ethernet frame of
+// a GRE packet carrying ERSPAN or bridged Ethernet
+func decapGRE(b []byte) (string, uint32, []byte, bool) {
+	if len(b) < 4 || b[1]&0x07 != 0 {
+		return "", 0, nil, false
+	}
+	flags, proto := b[0], binary.BigEndian.Uint16(b[2:])
+	offset := 4
+	var key uint32
+	if flags&greChecksum != 0 {
+		offset += 4
+	}
+	if flags&greKey != 0 {
+		if len(b) < offset+4 {
+			return "", 0, nil, false
+		}
+		key = binary.BigEndian.Uint32(b[offset:])
+		offset += 4
+	}
+	sequenced := flags&greSequence != 0
+	if sequenced {
+		offset += 4
+	}
+	if len(b) < offset {
+		return "", 0, nil, false
+	}
+	b = b[offset:]
+
+	switch {
+	case proto == greProtoTEB:
+		return EncapGRE, key, b, true
+	case proto == greProtoERSPAN2 && !sequenced:
+		return EncapERSPAN1, 0, b, true
+	case proto == greProtoERSPAN2:
+		// Version, VLAN, COS, encapsulation, truncation and session ID,
+		// then the port index
+		if len(b) < 8 || b[0]>>4 != 1 {
+			return "", 0, nil, false
+		}
+		return EncapERSPAN2, uint32(binary.BigEndian.Uint16(b[2:]) & 0x3ff), b[8:], true
+	case proto == greProtoERSPAN3:
+		// As type II with a timestamp, security group tag and flags, the
+		// last of which announces an 8 byte platform specific subheader
+		if len(b) < 12 || b[0]>>4 != 2 {
+			return "", 0, nil, false
+		}
+		session := uint32(binary.BigEndian.Uint16(b[2:]) & 0x3ff)
+		header := 12
+		if b[11]&0x01 != 0 {
+			header += 8
+		}
+		if len(b) < header {
+			return "", 0, nil, false
+		}
+		return EncapERSPAN3, session, b[header:], true
+	}
+	return "", 0, nil, false
+}
+
+// Status returns the state of the receiver
+func (r *Receiver) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := Status{
+		Type:      "mirror",
+		Listeners: slices.Clone(r.listeners),
+		Received:  r.received,
+		Malformed: r.malformed,
+		Buffered:  len(r.frames),
+		Capacity:  r.capacity,
+		Sources:   make([]Source, 0, len(r.sources)),
+		Untracked: r.untracked,
+	}
+	for _, s := range r.sources {
+		status.Sources = append(status.Sources, *s)
+	}
+	slices.SortFunc(status.Sources, func(a, b Source) int {
+		return cmp.Or(cmp.Compare(b.Packets, a.Packets), cmp.Compare(a.Address, b.Address), cmp.Compare(a.Session, b.Session))
+	})
+	return status
+}
+
+// filter selects buffered frames by sensor address and session
+type filter struct {
+	source  netip.Addr
+	session *uint32
+}
+
+// parseFilter reads the source and session query parameters
+func parseFilter(r *http.Request) (filter, error) {
+	var f filter
+	query := r.URL.Query()
+	if value := query.Get("source"); value != "" {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return f, fmt.Errorf("invalid source %q", value)
+		}
+		f.source = addr.Unmap()
+	}
+	if value := query.Get("session"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return f, fmt.Errorf("invalid session %q", value)
+		}
+		session := uint32(n)
+		f.session = &session
+	}
+	return f, nil
+}
+
+// snapshot returns the buffered frames matching f, oldest first
+func (r *Receiver) snapshot(f filter) []frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	frames := make([]frame, 0, len(r.frames))
+	start := 0
+	if len(r.frames) == r.capacity {
+		start = r.next
+	}
+	for i := range r.frames {
+		fr := r.frames[(start+i)%len(r.frames)]
+		if f.source.IsValid() && fr.source != f.source {
+			continue
+		}
+		if f.session != nil && fr.session != *f.session {
+			continue
+		}
+		frames = append(frames, fr)
+	}
+	return frames
+}
+
+// capture writes frames as a pcap file of Ethernet frames
+func capture(frames []frame) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf, pcap.LinkTypeEthernet)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range frames {
+		if err := w.WritePacket(f.at, f.data, 0); err != nil {
+			return nil, err
+		}
+	}
+	return &buf, nil
+}
+
+// StatusHandler reports the receiver's listeners, counters and sources
+func (r *Receiver) StatusHandler(w http.ResponseWriter, _ *http.Request) {
+	tool.WriteJSON(w, http.StatusOK, r.Status())
+}
+
+// PcapHandler downloads the buffered frames as a pcap file, optionally
+// only those of the source and session query parameters
+func (r *Receiver) PcapHandler(w http.ResponseWriter, req *http.Request) {
+	f, err := parseFilter(req)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	buf, err := capture(r.snapshot(f))
+	if err != nil {
+		tool.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", `attachment; filename="mirror.pcap"`)
+	w.Write(buf.Bytes())
+}
+
+// AnalysisHandler analyzes the buffered frames like an uploaded capture,
+// optionally only those of the source and session query parameters. The
+// top query parameter sets the number of top talkers.
+func (r *Receiver) AnalysisHandler(w http.ResponseWriter, req *http.Request) {
+	top := defaultTop
+	if value := req.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTop {
+			tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("top must be between 1 and %d", maxTop))
+			return
+		}
+		top = n
+	}
+	f, err := parseFilter(req)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	buf, err := capture(r.snapshot(f))
+	if err == nil {
+		var report pcap.Report
+		if report, err = pcap.Analyze(buf, top); err == nil {
+			tool.WriteJSON(w, http.StatusOK, report)
+			return
+		}
+	}
+	tool.WriteError(w, http.StatusInternalServerError, err)
+}
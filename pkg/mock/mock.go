@@ -0,0 +1,192 @@
+// Package mock serves recorded sessions in place of the real tools, so that
+// clients can be tested against real tool behaviour without network access
+package mock
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/gorilla/websocket"
+)
+
+// writeTimeout bounds how long a replayed message may take to send
+const writeTimeout = 10 * time.Second
+
+// Server replays recorded sessions to WebSocket clients. A client connecting
+// to /<tool> is answered from the recording of that tool whose first request
+// equals the client's, or from the first recording of the tool when none
+// does. Recorded server messages are sent in their recorded order; messages
+// the client sent mid-session, such as control messages, are awaited before
+// the messages recorded after them are sent.
+type Server struct {
+	// Speed is the replay speed multiplier; 0 sends messages without delay
+	Speed float64
+
+	mu         sync.RWMutex
+	recordings map[string][]*tool.Recording
+}
+
+// NewServer creates a mock server without recordings
+func NewServer() *Server {
+	return &Server{recordings: make(map[string][]*tool.Recording)}
+}
+
+// Add adds a recording to the server
+func (s *Server) Add(rec *tool.Recording) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordings[rec.Tool] = append(s.recordings[rec.Tool], rec)
+}
+
+// Load adds the recording stored in a file, in the format served by
+// GET /api/sessions/{id}
+func (s *Server) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading recording: %w", err)
+	}
+	var rec tool.Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("error parsing recording %s: %w", path, err)
+	}
+	if rec.Tool == "" {
+		return fmt.Errorf("recording %s names no tool", path)
+	}
+	s.Add(&rec)
+	return nil
+}
+
+// find returns the recording of the tool to answer request with
+func (s *Server) find(toolName string, request []byte) *tool.Recording {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	recordings := s.recordings[toolName]
+	if len(recordings) == 0 {
+		return nil
+	}
+	for _, rec := range recordings {
+		for _, msg := range rec.Messages {
+			if _, ok := tool.ParseSync(msg.Data); ok {
+				continue
+			}
+			if msg.Direction == tool.DirectionIn {
+				if equalJSON(msg.Data, request) {
+					return rec
+				}
+				break
+			}
+		}
+	}
+	return recordings[0]
+}
+
+// equalJSON reports whether a and b encode the same JSON value
+func equalJSON(a, b []byte) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// ServeHTTP upgrades the request and replays the matching recording
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	toolName := strings.Trim(r.URL.Path, "/")
+	s.mu.RLock()
+	_, ok := s.recordings[toolName]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	conn, err := tool.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(tool.MaxMessageSize)
+
+	// Tools announce the session before reading the request, which decides
+	// the recording that is replayed
+	announced := time.Now()
+	if err := conn.WriteJSON(tool.SessionMessage{Type: "session", ID: newSessionID(), Tool: toolName, ServerTime: announced}); err != nil {
+		return
+	}
+	_, request, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	if msg, ok := tool.ParseSync(request); ok {
+		if err := conn.WriteJSON(tool.SyncReply(msg, announced, time.Now())); err != nil {
+			return
+		}
+		if _, request, err = conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+	rec := s.find(toolName, request)
+
+	start := time.Now()
+	var requestOffset float64
+	requested := false
+	for _, msg := range rec.Messages {
+		// The sync exchange of the recorded client was answered above for
+		// this one
+		if _, ok := tool.ParseSync(msg.Data); ok {
+			continue
+		}
+		switch {
+		case msg.Direction == tool.DirectionIn && !requested:
+			// The first request was read above, and the messages recorded
+			// before it were replaced by the session message
+			requested, requestOffset = true, msg.Offset
+			continue
+		case !requested:
+			continue
+		case msg.Direction == tool.DirectionIn:
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			continue
+		}
+		if s.Speed > 0 {
+			due := time.Duration((msg.Offset - requestOffset) / s.Speed * float64(time.Millisecond))
+			time.Sleep(time.Until(start.Add(due)))
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, msg.Data); err != nil {
+			log.Printf("Failed to replay %s message: %v", toolName, err)
+			return
+		}
+	}
+
+	// Close like the tools do, draining the client's frames so the last
+	// messages are not lost to a reset
+	deadline := time.Now().Add(time.Second)
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+	conn.SetReadDeadline(deadline)
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+// newSessionID returns a random session ID for a replayed session
+func newSessionID() string {
+	p := make([]byte, 16)
+	rand.Read(p)
+	return hex.EncodeToString(p)
+}
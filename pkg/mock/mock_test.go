@@ -0,0 +1,98 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cksidharthan/net-tools/pkg"
+	"github.com/cksidharthan/net-tools/pkg/conformance"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// record runs c against a live ping handler and returns the recording of
+// the session
+func record(t *testing.T, c conformance.Case) *tool.Recording {
+	t.Helper()
+	live := httptest.NewServer(http.HandlerFunc(pkg.PingHandler))
+	defer live.Close()
+
+	frames, err := conformance.Run("ws"+strings.TrimPrefix(live.URL, "http"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session tool.SessionMessage
+	if err := json.Unmarshal(frames[0].Data, &session); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := tool.Recordings.Get(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestServerReplaysRecordings(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	cases := []conformance.Case{
+		{
+			Name:    "count",
+			Path:    "/ping",
+			Request: map[string]any{"address": target.URL, "count": 2},
+			Expect:  "session pong pong summary",
+		},
+		{
+			Name:    "control",
+			Path:    "/ping",
+			Request: map[string]any{"address": target.URL, "count": 5},
+			After:   "pong",
+			Control: map[string]any{"action": "stop"},
+			Expect:  "session pong summary",
+		},
+	}
+
+	// Recordings round trip through the file format of the sessions API
+	mock := NewServer()
+	dir := t.TempDir()
+	for _, c := range cases {
+		data, err := json.Marshal(record(t, c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, c.Name+".json")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.Load(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := conformance.Check(baseURL, c); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("unknown tool", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/dns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("got status %d, want 404", resp.StatusCode)
+		}
+	})
+}
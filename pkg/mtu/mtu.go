@@ -0,0 +1,347 @@
+package mtu
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for MTU discovery options
+const (
+	defaultMax     = 1500 // Ethernet MTU
+	defaultTimeout = 2    // 2 second timeout per probe
+	defaultRetries = 1    // One more attempt before a size is considered too big
+	maxSize        = 65535
+	maxRetries     = 5
+)
+
+// Smallest MTUs that IPv4 (RFC 791) and IPv6 (RFC 8200) links may have,
+// which are also the default lower bounds of the search
+const (
+	minIPv4MTU = 68
+	minIPv6MTU = 1280
+)
+
+// Header sizes making up the difference between packet size and payload
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	echoHeaderSize = 8
+)
+
+// Probe results
+const (
+	ResultReply   = "reply"   // The host answered
+	ResultTooBig  = "too_big" // Sending failed locally or a router reported the packet too big
+	ResultTimeout = "timeout" // No answer on any attempt
+)
+
+// MTUMessage represents the incoming path MTU discovery request
+type MTUMessage struct {
+	// Required
+	Host string `json:"host"` // Host to discover the path MTU to
+
+	// Optional parameters
+	Family  *string `json:"family,omitempty"`  // Address family to use ("ip4" or "ip6")
+	Min     *int    `json:"min,omitempty"`     // Smallest packet size to search (defaults to 68 for IPv4, 1280 for IPv6)
+	Max     *int    `json:"max,omitempty"`     // Largest packet size to search
+	Timeout *int    `json:"timeout,omitempty"` // Timeout per probe in seconds
+	Retries *int    `json:"retries,omitempty"` // Extra attempts before a size without reply is considered too big
+}
+
+// ProbeMessage reports one probe of the search
+type ProbeMessage struct {
+	Type     string  `json:"type"`               // Message type ("probe")
+	Size     int     `json:"size"`               // IP packet size in bytes
+	Fits     bool    `json:"fits"`               // Whether the packet reached the host unfragmented
+	Result   string  `json:"result"`             // Probe result (reply, too_big, timeout)
+	Attempts int     `json:"attempts"`           // Echo requests sent at this size
+	RTT      float64 `json:"rtt,omitempty"`      // Round-trip time in milliseconds
+	Reported int     `json:"reported,omitempty"` // Next hop MTU reported by a router
+	From     string  `json:"from,omitempty"`     // Router that reported the packet too big
+	Error    string  `json:"error,omitempty"`    // Error that ended the search
+}
+
+// ResultMessage reports the discovered path MTU
+type ResultMessage struct {
+	Type    string `json:"type"`            // Message type ("result")
+	Host    string `json:"host"`            // Host that was probed
+	Address string `json:"address"`         // Resolved address
+	MTU     int    `json:"mtu"`             // Path MTU in bytes, 0 when it could not be found
+	Min     int    `json:"min"`             // Smallest packet size searched
+	Max     int    `json:"max"`             // Largest packet size searched
+	Sent    int    `json:"sent"`            // Echo requests sent
+	Error   string `json:"error,omitempty"` // Why the path MTU could not be found
+}
+
+// Report is the REST response: the result together with every probe
+type Report struct {
+	ResultMessage
+	Probes []ProbeMessage `json:"probes"` // Probes in the order they were sent
+}
+
+// MTUOptions contains the resolved path MTU discovery options
+type MTUOptions struct {
+	Host    string
+	Family  string
+	Min     int
+	Max     int
+	Timeout int
+	Retries int
+}
+
+// resolveMTUOptions converts MTUMessage to MTUOptions with defaults. A Min
+// of 0 is replaced by the smallest MTU of the address family once the host
+// is resolved.
+func resolveMTUOptions(msg *MTUMessage) (MTUOptions, error) {
+	opts := MTUOptions{
+		Host:    strings.Trim(strings.TrimSpace(msg.Host), "[]"),
+		Family:  tool.GetOrDefault(msg.Family, "ip"),
+		Min:     tool.GetOrDefault(msg.Min, 0),
+		Max:     tool.GetOrDefault(msg.Max, defaultMax),
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		Retries: tool.GetOrDefault(msg.Retries, defaultRetries),
+	}
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if opts.Family != "ip" && opts.Family != "ip4" && opts.Family != "ip6" {
+		return opts, fmt.Errorf("family must be ip4 or ip6")
+	}
+	if opts.Min != 0 && opts.Min < minIPv4MTU {
+		return opts, fmt.Errorf("min must be at least %d", minIPv4MTU)
+	}
+	if opts.Max > maxSize || opts.Max < minIPv4MTU {
+		return opts, fmt.Errorf("max must be between %d and %d", minIPv4MTU, maxSize)
+	}
+	if opts.Min > opts.Max {
+		return opts, fmt.Errorf("min must not be larger than max")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if opts.Retries < 0 || opts.Retries > maxRetries {
+		return opts, fmt.Errorf("retries must be between 0 and %d", maxRetries)
+	}
+	return opts, nil
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// searcher sends the probes of one discovery
+type searcher struct {
+	conn     *probe.ICMPConn
+	dst      *net.IPAddr
+	overhead int
+	opts     MTUOptions
+	result   *ResultMessage
+	sequence int
+}
+
+// probe sends echo requests of size bytes until one is answered, one does
+// not fit or every attempt times out
+func (s *searcher) probe(size int) ProbeMessage {
+	p := ProbeMessage{Type: "probe", Size: size, Result: ResultTimeout}
+	timeout := time.Duration(s.opts.Timeout) * time.Second
+	for p.Attempts <= s.opts.Retries {
+		p.Attempts++
+		s.result.Sent++
+		s.sequence++
+		rtt, _, err := s.conn.Echo(s.dst, s.sequence, size-s.overhead, timeout)
+
+		var tooBig *probe.PacketTooBigError
+		switch {
+		case err == nil:
+			p.Fits, p.Result, p.RTT = true, ResultReply, milliseconds(rtt)
+			return p
+		case errors.As(err, &tooBig):
+			p.Result = ResultTooBig
+			if tooBig.From != nil {
+				p.Reported, p.From = tooBig.MTU, tooBig.From.String()
+			}
+			return p
+		case !errors.Is(err, probe.ErrTimeout):
+			p.Error = err.Error()
+			return p
+		}
+	}
+	return p
+}
+
+// Discover binary searches for the largest packet that reaches the host
+// with the DF bit set, calling report after each probe. An error returned
+// by report ends the search.
+func Discover(opts MTUOptions, report func(ProbeMessage) error) ResultMessage {
+	result := ResultMessage{Type: "result", Host: opts.Host, Min: opts.Min, Max: opts.Max}
+	ipAddr, err := net.ResolveIPAddr(opts.Family, opts.Host)
+	if err != nil {
+		result.Error = fmt.Sprintf("error resolving %s: %v", opts.Host, err)
+		return result
+	}
+	result.Address = ipAddr.String()
+
+	v6 := ipAddr.IP.To4() == nil
+	listen, overhead, floor := probe.ListenICMP, ipv4HeaderSize+echoHeaderSize, minIPv4MTU
+	if v6 {
+		listen, overhead, floor = probe.ListenICMPv6, ipv6HeaderSize+echoHeaderSize, minIPv6MTU
+	}
+	if result.Min == 0 {
+		result.Min = min(floor, result.Max)
+	}
+
+	conn, err := listen(nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	if err := conn.SetDontFragment(true); err != nil {
+		result.Error = fmt.Sprintf("error setting DF bit: %v", err)
+		return result
+	}
+
+	s := &searcher{conn: conn, dst: ipAddr, overhead: overhead, opts: opts, result: &result}
+	send := func(size int) (ProbeMessage, bool) {
+		p := s.probe(size)
+		if err := report(p); err != nil {
+			result.Error = err.Error()
+			return p, false
+		}
+		if p.Error != "" {
+			result.Error = p.Error
+			return p, false
+		}
+		return p, true
+	}
+
+	// The smallest size must get through, or the host does not answer
+	// echo requests at all
+	p, ok := send(result.Min)
+	if !ok {
+		return result
+	}
+	if !p.Fits {
+		result.Error = fmt.Sprintf("no reply at the minimum size of %d bytes", result.Min)
+		return result
+	}
+	if result.Min == result.Max {
+		result.MTU = result.Min
+		return result
+	}
+
+	// Invariant: low fits and high does not. Sizes reported by routers are
+	// tried next, as they are usually right.
+	low, high, next := result.Min, result.Max+1, result.Max
+	for high-low > 1 {
+		size := low + (high-low)/2
+		if next > low && next < high {
+			size = next
+		}
+		p, ok := send(size)
+		if !ok {
+			return result
+		}
+		next = 0
+		if p.Fits {
+			low = size
+		} else {
+			high, next = size, p.Reported
+		}
+	}
+	result.MTU = low
+	return result
+}
+
+// Handler handles WebSocket path MTU discovery requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "mtu")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg MTUMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading mtu message: %v", err)
+		return
+	}
+
+	opts, err := resolveMTUOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid mtu options: %v", err)
+		return
+	}
+
+	result := Discover(opts, func(p ProbeMessage) error {
+		for range p.Attempts {
+			session.CountProbe()
+		}
+		return session.WriteJSON(p)
+	})
+	log.Printf("Path MTU to %s is %d after %d probes", opts.Host, result.MTU, result.Sent)
+	if err := session.WriteJSON(result); err != nil {
+		log.Printf("Failed to send result: %v", err)
+	}
+}
+
+// APIHandler discovers the path MTU to the host query parameter and
+// responds with the result and every probe. The other options are taken
+// from the query parameters of the same names.
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	msg, err := queryMessage(r.URL.Query())
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := resolveMTUOptions(&msg)
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report := Report{Probes: []ProbeMessage{}}
+	report.ResultMessage = Discover(opts, func(p ProbeMessage) error {
+		report.Probes = append(report.Probes, p)
+		return r.Context().Err()
+	})
+	tool.WriteJSON(w, http.StatusOK, report)
+}
+
+// queryMessage builds an MTUMessage from query parameters
+func queryMessage(query url.Values) (MTUMessage, error) {
+	msg := MTUMessage{Host: query.Get("host")}
+	if family := query.Get("family"); family != "" {
+		msg.Family = &family
+	}
+	for name, field := range map[string]**int{
+		"min":     &msg.Min,
+		"max":     &msg.Max,
+		"timeout": &msg.Timeout,
+		"retries": &msg.Retries,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return msg, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*field = &n
+	}
+	return msg, nil
+}
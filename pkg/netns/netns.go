@@ -0,0 +1,138 @@
+// Package netns builds throwaway Linux network namespaces for integration
+// tests. The test process reaches a target namespace through a router
+// namespace over veth pairs, links can be impaired with tc netem and dummy
+// services listen inside the target, so probes cross a real kernel network
+// path instead of a mock.
+package netns
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+// RequireEnv makes Start fail tests instead of skipping them when the
+// namespaces cannot be set up, so CI notices a runner that lost the
+// privileges the harness needs
+const RequireEnv = "NETNS_REQUIRED"
+
+// ErrUnsupported is returned where network namespaces are not available
+var ErrUnsupported = errors.New("netns: network namespaces are not supported")
+
+// Lab is a client, router and target wired up as
+//
+//	test process (Client) <-veth-> router (Router, Gateway) <-veth-> target (Target)
+//
+// The test process stays in its own namespace and reaches Target, Blackhole
+// and Unreachable through a route via Router.
+type Lab struct {
+	Client      netip.Addr   // Address of the test process on the client link
+	Router      netip.Addr   // Address of the router on the client link, the first hop
+	Gateway     netip.Addr   // Address of the router on the target link
+	Target      netip.Addr   // Address of the target namespace
+	TargetNet   netip.Prefix // Target link, holding Gateway, Target and unused addresses
+	Blackhole   netip.Prefix // Network the router drops silently
+	Unreachable netip.Prefix // Network the router rejects with ICMP administratively prohibited
+
+	id     string // Unique suffix of the namespace and link names
+	router string // Name of the router namespace
+	target string // Name of the target namespace
+}
+
+// Start sets up a lab for the rest of the test and removes it when the test
+// ends. The test is skipped when the namespaces cannot be created, e.g.
+// without root or on other systems, unless NETNS_REQUIRED is set.
+func Start(t testing.TB) *Lab {
+	t.Helper()
+	lab, err := start()
+	if err != nil {
+		if os.Getenv(RequireEnv) != "" {
+			t.Fatal(err)
+		}
+		t.Skip(err)
+	}
+	t.Cleanup(lab.Close)
+	return lab
+}
+
+// ErrNoNetem is returned by Impair when the kernel lacks the netem queueing
+// discipline
+var ErrNoNetem = errors.New("netns: tc netem is not available")
+
+// Listen announces on the local network address inside the target
+// namespace, e.g. Listen(t, "tcp", ":7"), and closes the listener when the
+// test ends. A port of 0 picks a free one.
+func (l *Lab) Listen(t testing.TB, network, address string) net.Listener {
+	t.Helper()
+	var ln net.Listener
+	err := l.inTarget(func() (err error) {
+		ln, err = net.Listen(network, address)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// ListenPacket is Listen for packet networks such as udp
+func (l *Lab) ListenPacket(t testing.TB, network, address string) net.PacketConn {
+	t.Helper()
+	var conn net.PacketConn
+	err := l.inTarget(func() (err error) {
+		conn, err = net.ListenPacket(network, address)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Serve serves handler over HTTP inside the target namespace and returns its
+// URL, e.g. http://198.18.7.66:41234
+func (l *Lab) Serve(t testing.TB, handler http.Handler) string {
+	t.Helper()
+	ln := l.Listen(t, "tcp", net.JoinHostPort(l.Target.String(), "0"))
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+// EchoUDP answers every datagram sent to the returned port of Target with
+// the datagram itself
+func (l *Lab) EchoUDP(t testing.TB) int {
+	t.Helper()
+	conn := l.ListenPacket(t, "udp4", net.JoinHostPort(l.Target.String(), "0"))
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Impair applies a tc netem discipline, e.g. Impair(t, "delay", "20ms"), to
+// both directions of the target link, so Router is unaffected and Target
+// sees the impairment once per direction. Calling it again replaces the
+// previous impairment. The test is skipped without netem support.
+func (l *Lab) Impair(t testing.TB, netem ...string) {
+	t.Helper()
+	if err := l.impair(netem); err != nil {
+		if errors.Is(err, ErrNoNetem) && os.Getenv(RequireEnv) == "" {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+}
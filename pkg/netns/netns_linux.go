@@ -0,0 +1,193 @@
+//go:build linux
+
+package netns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// labs counts the labs started by the process, to keep their names and
+// addresses apart
+var labs atomic.Uint32
+
+// sysctls are set in the router so it forwards and answers every probe.
+// The kernel rate limits ICMP errors to one per second by default, which
+// would lose most traceroute replies.
+var sysctls = map[string]string{
+	"net/ipv4/ip_forward":     "1",
+	"net/ipv4/icmp_ratelimit": "0",
+}
+
+// start creates the namespaces and links of a lab. Addresses come from the
+// benchmarking range 198.18.0.0/15 (RFC 2544), which is never routed on real
+// networks, a /24 per lab:
+//
+//	.0/30    client link, Client .1 and Router .2
+//	.64/29   target link, Gateway .65 and Target .66
+//	.128/26  Blackhole
+//	.192/26  Unreachable
+func start() (*Lab, error) {
+	if os.Geteuid() != 0 {
+		return nil, errors.New("netns: network namespaces need root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		return nil, fmt.Errorf("netns: %w", err)
+	}
+
+	n := labs.Add(1)
+	base := netip.AddrFrom4([4]byte{198, 18, byte(os.Getpid() + int(n)), 0})
+	at := func(offset byte) netip.Addr {
+		b := base.As4()
+		b[3] = offset
+		return netip.AddrFrom4(b)
+	}
+	id := fmt.Sprintf("%d%c", os.Getpid()%100000, 'a'+rune(n%26))
+	l := &Lab{
+		Client:      at(1),
+		Router:      at(2),
+		Gateway:     at(65),
+		Target:      at(66),
+		TargetNet:   netip.PrefixFrom(at(64), 29),
+		Blackhole:   netip.PrefixFrom(at(128), 26),
+		Unreachable: netip.PrefixFrom(at(192), 26),
+		id:          id,
+		router:      "nt-router-" + id,
+		target:      "nt-target-" + id,
+	}
+	if err := l.setup(netip.PrefixFrom(base, 24)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Link names: the client end stays in the test process namespace
+func (l *Lab) clientLink() string  { return "ntc" + l.id }
+func (l *Lab) routerLink() string  { return "ntr" + l.id }
+func (l *Lab) gatewayLink() string { return "ntg" + l.id }
+func (l *Lab) targetLink() string  { return "ntt" + l.id }
+
+// setup creates the namespaces, links, addresses and routes of l
+func (l *Lab) setup(network netip.Prefix) error {
+	commands := [][]string{
+		{"netns", "add", l.router},
+		{"netns", "add", l.target},
+		{"link", "add", l.clientLink(), "type", "veth", "peer", "name", l.routerLink(), "netns", l.router},
+		{"link", "add", l.gatewayLink(), "netns", l.router, "type", "veth", "peer", "name", l.targetLink(), "netns", l.target},
+
+		{"addr", "add", l.Client.String() + "/30", "dev", l.clientLink()},
+		{"link", "set", l.clientLink(), "up"},
+		{"route", "add", network.String(), "via", l.Router.String()},
+
+		{"-n", l.router, "link", "set", "lo", "up"},
+		{"-n", l.router, "addr", "add", l.Router.String() + "/30", "dev", l.routerLink()},
+		{"-n", l.router, "addr", "add", l.Gateway.String() + "/29", "dev", l.gatewayLink()},
+		{"-n", l.router, "link", "set", l.routerLink(), "up"},
+		{"-n", l.router, "link", "set", l.gatewayLink(), "up"},
+		{"-n", l.router, "route", "add", "blackhole", l.Blackhole.String()},
+		{"-n", l.router, "route", "add", "prohibit", l.Unreachable.String()},
+
+		{"-n", l.target, "link", "set", "lo", "up"},
+		{"-n", l.target, "addr", "add", l.Target.String() + "/29", "dev", l.targetLink()},
+		{"-n", l.target, "link", "set", l.targetLink(), "up"},
+		{"-n", l.target, "route", "add", "default", "via", l.Gateway.String()},
+	}
+	for _, args := range commands {
+		if err := ip(args...); err != nil {
+			return err
+		}
+	}
+	return inNamespace(l.router, func() error {
+		for key, value := range sysctls {
+			if err := os.WriteFile("/proc/sys/"+key, []byte(value), 0o644); err != nil {
+				return fmt.Errorf("netns: error setting %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ip runs the ip command with args
+func ip(args ...string) error {
+	return command("ip", args...)
+}
+
+// command runs a command, returning its output as the error when it fails
+func command(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("netns: %s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Close removes the namespaces of the lab. Links go with them, and the
+// route of the test process goes with the client link.
+func (l *Lab) Close() {
+	ip("netns", "del", l.router)
+	ip("netns", "del", l.target)
+}
+
+// inTarget runs fn in the target namespace
+func (l *Lab) inTarget(fn func() error) error {
+	return inNamespace(l.target, fn)
+}
+
+// inNamespace runs fn on a thread switched to the named namespace. Sockets
+// fn opens stay in the namespace after the thread switches back, whichever
+// goroutine uses them.
+func inNamespace(name string, fn func() error) error {
+	runtime.LockOSThread()
+	// The thread is only unlocked once it is back in its namespace, so a
+	// failed switch back ends the thread rather than reusing it
+	home, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("netns: %w", err)
+	}
+	defer home.Close()
+	ns, err := os.Open("/run/netns/" + name)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("netns: %w", err)
+	}
+	defer ns.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("netns: error entering %s: %w", name, err)
+	}
+	err = fn()
+	if unix.Setns(int(home.Fd()), unix.CLONE_NEWNET) == nil {
+		runtime.UnlockOSThread()
+	}
+	return err
+}
+
+// impair replaces the root queueing discipline of both ends of the target
+// link with netem
+func (l *Lab) impair(netem []string) error {
+	if _, err := exec.LookPath("tc"); err != nil {
+		return ErrNoNetem
+	}
+	for _, end := range []struct{ ns, link string }{{l.router, l.gatewayLink()}, {l.target, l.targetLink()}} {
+		args := append([]string{"-n", end.ns, "qdisc", "replace", "dev", end.link, "root", "netem"}, netem...)
+		if err := command("tc", args...); err != nil {
+			if strings.Contains(err.Error(), "qdisc kind is unknown") {
+				return ErrNoNetem
+			}
+			return err
+		}
+	}
+	return nil
+}
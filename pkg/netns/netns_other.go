@@ -0,0 +1,21 @@
+//go:build !linux
+
+package netns
+
+// start fails, as network namespaces are Linux only
+func start() (*Lab, error) {
+	return nil, ErrUnsupported
+}
+
+// Close does nothing, as no lab can be started
+func (l *Lab) Close() {}
+
+// inTarget fails, as network namespaces are Linux only
+func (l *Lab) inTarget(fn func() error) error {
+	return ErrUnsupported
+}
+
+// impair fails, as netem is Linux only
+func (l *Lab) impair(netem []string) error {
+	return ErrNoNetem
+}
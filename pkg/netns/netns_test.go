@@ -0,0 +1,206 @@
+//go:build linux
+
+package netns
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg"
+	"github.com/cksidharthan/net-tools/pkg/conformance"
+	"github.com/cksidharthan/net-tools/pkg/traceroute"
+)
+
+// newToolServer serves the probing tools on their usual routes
+func newToolServer(t *testing.T) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", pkg.PingHandler)
+	mux.HandleFunc("/traceroute", traceroute.Handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// run runs c and checks its message sequence
+func run(t *testing.T, baseURL string, c conformance.Case) []conformance.Frame {
+	t.Helper()
+	if c.Timeout == 0 {
+		c.Timeout = 20 * time.Second
+	}
+	frames, err := conformance.Run(baseURL, c)
+	if err == nil {
+		err = conformance.Match(c, frames)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+// decode decodes the frames of type kind
+func decode[T any](t *testing.T, frames []conformance.Frame, kind string) []T {
+	t.Helper()
+	var messages []T
+	for _, frame := range frames {
+		if frame.Type != kind {
+			continue
+		}
+		var msg T
+		if err := json.Unmarshal(frame.Data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// closedPort returns a TCP port nothing listens on in the target namespace
+func closedPort(t *testing.T, lab *Lab) int {
+	ln := lab.Listen(t, "tcp4", net.JoinHostPort(lab.Target.String(), "0"))
+	ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestPing(t *testing.T) {
+	lab := Start(t)
+	baseURL := newToolServer(t)
+	web := lab.Serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}))
+	ln := lab.Listen(t, "tcp4", net.JoinHostPort(lab.Target.String(), "0"))
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	open := ln.Addr().(*net.TCPAddr).Port
+	closed := closedPort(t, lab)
+	echo := lab.EchoUDP(t)
+
+	cases := []struct {
+		name     string
+		request  map[string]any
+		expect   string
+		received int    // Probes the summary must report answered
+		failure  string // Failure or reply every pong must report
+	}{
+		{"icmp", map[string]any{"address": lab.Target.String(), "protocol": "icmp", "count": 3}, "session( pong){3} summary", 3, ""},
+		{"icmp router", map[string]any{"address": lab.Router.String(), "protocol": "icmp", "count": 1}, "session pong summary", 1, ""},
+		{"icmp unreachable", map[string]any{"address": lab.Unreachable.Addr().Next().String(), "protocol": "icmp", "count": 2, "timeout": 1}, "session( pong){2} summary", 0, ""},
+		{"icmp blackhole", map[string]any{"address": lab.Blackhole.Addr().Next().String(), "protocol": "icmp", "count": 2, "timeout": 1}, "session( pong){2} summary", 0, ""},
+		{"tcp open", map[string]any{"address": lab.Target.String(), "protocol": "tcp", "port": open, "count": 2}, "session( pong){2} summary", 2, ""},
+		{"tcp refused", map[string]any{"address": lab.Target.String(), "protocol": "tcp", "port": closed, "count": 2, "timeout": 1}, "session( pong){2} summary", 0, "refused"},
+		{"tcp blackhole", map[string]any{"address": lab.Blackhole.Addr().Next().String(), "protocol": "tcp", "port": open, "count": 1, "timeout": 1}, "session pong summary", 0, "timeout"},
+		{"udp reply", map[string]any{"address": lab.Target.String(), "protocol": "udp", "port": echo, "count": 2}, "session( pong){2} summary", 2, "reply"},
+		{"udp port unreachable", map[string]any{"address": lab.Target.String(), "protocol": "udp", "port": closed, "count": 2, "timeout": 1}, "session( pong){2} summary", 2, "port_unreachable"},
+		{"http", map[string]any{"address": web, "count": 2}, "session( pong){2} summary", 2, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			frames := run(t, baseURL, conformance.Case{Path: "/ping", Request: c.request, Expect: c.expect})
+			summaries := decode[pkg.SummaryMessage](t, frames, "summary")
+			if got := summaries[0].Received; got != c.received {
+				t.Errorf("received %d probes, want %d: %+v", got, c.received, summaries[0])
+			}
+			for _, pong := range decode[pkg.PongMessage](t, frames, "pong") {
+				if c.failure != "" && pong.Failure != c.failure && pong.Reply != c.failure {
+					t.Errorf("pong %d failed with %q and replied %q, want %q", pong.Sequence, pong.Failure, pong.Reply, c.failure)
+				}
+			}
+		})
+	}
+}
+
+func TestTraceroute(t *testing.T) {
+	lab := Start(t)
+	baseURL := newToolServer(t)
+	ln := lab.Listen(t, "tcp4", net.JoinHostPort(lab.Target.String(), "0"))
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	for _, protocol := range []string{"icmp", "udp", "tcp"} {
+		t.Run(protocol, func(t *testing.T) {
+			t.Parallel()
+			request := map[string]any{"host": lab.Target.String(), "protocol": protocol, "port": port, "max_hops": 5, "timeout": 1, "no_dns": true}
+			c := conformance.Case{Path: "/traceroute", Request: request, Expect: "session hop hop summary"}
+			hops := decode[traceroute.HopMessage](t, run(t, baseURL, c), "hop")
+			if hops[0].Address != lab.Router.String() || hops[0].Reached {
+				t.Errorf("first hop %+v, want %s answering", hops[0], lab.Router)
+			}
+			if hops[1].Address != lab.Target.String() || !hops[1].Reached {
+				t.Errorf("second hop %+v, want %s reached", hops[1], lab.Target)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		t.Parallel()
+		request := map[string]any{"host": lab.Unreachable.Addr().Next().String(), "max_hops": 3, "queries": 1, "timeout": 1, "no_dns": true}
+		c := conformance.Case{Path: "/traceroute", Request: request, Expect: "session( hop)+ summary"}
+		summaries := decode[traceroute.SummaryMessage](t, run(t, baseURL, c), "summary")
+		if summaries[0].Reached {
+			t.Errorf("trace to a prohibited network reached it: %+v", summaries[0])
+		}
+	})
+
+	t.Run("blackhole", func(t *testing.T) {
+		t.Parallel()
+		request := map[string]any{"host": lab.Blackhole.Addr().Next().String(), "max_hops": 3, "queries": 1, "timeout": 1, "no_dns": true}
+		c := conformance.Case{Path: "/traceroute", Request: request, Expect: "session( hop){3} summary"}
+		for _, hop := range decode[traceroute.HopMessage](t, run(t, baseURL, c), "hop") {
+			if hop.Address != "" || hop.Loss != 100 {
+				t.Errorf("hop %d into a blackhole was answered: %+v", hop.Hop, hop)
+			}
+		}
+	})
+}
+
+func TestSweep(t *testing.T) {
+	lab := Start(t)
+	baseURL := newToolServer(t)
+
+	request := map[string]any{"address": lab.TargetNet.String(), "protocol": "icmp", "timeout": 1}
+	c := conformance.Case{Path: "/ping", Request: request, Expect: "session( host){6} sweep"}
+	sweeps := decode[pkg.SweepMessage](t, run(t, baseURL, c), "sweep")
+	want := []string{lab.Gateway.String(), lab.Target.String()}
+	if !slices.Equal(sweeps[0].Alive, want) {
+		t.Errorf("sweep of %s found %v alive, want %v", lab.TargetNet, sweeps[0].Alive, want)
+	}
+}
+
+func TestImpairments(t *testing.T) {
+	lab := Start(t)
+	baseURL := newToolServer(t)
+	request := map[string]any{"address": lab.Target.String(), "protocol": "icmp", "count": 3, "timeout": 1}
+	c := conformance.Case{Path: "/ping", Request: request, Expect: "session( pong){3} summary"}
+
+	lab.Impair(t, "delay", "25ms")
+	summary := decode[pkg.SummaryMessage](t, run(t, baseURL, c), "summary")[0]
+	if summary.Received != 3 || summary.Min < 50 {
+		t.Errorf("ping over a link delayed 25ms each way: %+v, want 3 probes of at least 50ms", summary)
+	}
+
+	lab.Impair(t, "loss", "100%")
+	summary = decode[pkg.SummaryMessage](t, run(t, baseURL, c), "summary")[0]
+	if summary.Received != 0 || summary.Loss != 100 {
+		t.Errorf("ping over a link losing every packet: %+v, want 100%% loss", summary)
+	}
+
+	// The router is not behind the impaired link
+	request["address"] = lab.Router.String()
+	summary = decode[pkg.SummaryMessage](t, run(t, baseURL, c), "summary")[0]
+	if summary.Received != 3 {
+		t.Errorf("ping of the router: %+v, want every probe answered", summary)
+	}
+}
@@ -0,0 +1,386 @@
+package pac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/robertkrimen/otto"
+)
+
+// Default values for PAC evaluation options
+const (
+	defaultTimeout = 10       // 10 second timeout for fetching the PAC file
+	evalTimeout    = 5        // 5 second limit for evaluating a single URL
+	maxScriptSize  = 1 << 20  // PAC files larger than 1 MiB are rejected
+	evalMemLimit   = 64 << 20 // Heap growth allowed while evaluating, in bytes
+	memCheckPeriod = 10 * time.Millisecond
+)
+
+// Errors raised inside the JavaScript VM to abort evaluation
+var (
+	errEvalTimeout = errors.New("PAC evaluation timed out")
+	errEvalMemory  = errors.New("PAC evaluation exceeded the memory limit")
+)
+
+// PACMessage represents the incoming PAC evaluation request
+type PACMessage struct {
+	// Required
+	URLs []string `json:"urls"` // URLs to evaluate FindProxyForURL for
+
+	// One of PACURL or Script is required
+	PACURL *string `json:"pac_url,omitempty"` // URL to fetch the PAC file from
+	Script *string `json:"script,omitempty"`  // Inline PAC script
+
+	// Optional parameters
+	ClientIP *string `json:"client_ip,omitempty"` // Address returned by myIpAddress()
+	Timeout  *int    `json:"timeout,omitempty"`   // Fetch timeout in seconds
+}
+
+// Proxy is a single entry of a FindProxyForURL result
+type Proxy struct {
+	Type string `json:"type"`           // DIRECT, PROXY, HTTP, HTTPS, SOCKS, SOCKS4 or SOCKS5
+	Host string `json:"host,omitempty"` // host:port of the proxy
+}
+
+// FetchMessage reports how the PAC file was obtained
+type FetchMessage struct {
+	Type     string  `json:"type"`             // Message type ("fetch")
+	Source   string  `json:"source"`           // PAC URL or "inline"
+	Status   int     `json:"status,omitempty"` // HTTP status code when fetched
+	Bytes    int     `json:"bytes"`            // Size of the PAC script
+	Duration float64 `json:"duration"`         // Fetch duration in milliseconds
+	Error    string  `json:"error,omitempty"`  // Error fetching or compiling the script
+}
+
+// ResultMessage reports the proxy decision for a single URL
+type ResultMessage struct {
+	Type     string   `json:"type"`             // Message type ("result")
+	URL      string   `json:"url"`              // URL that was evaluated
+	Host     string   `json:"host"`             // Host passed to FindProxyForURL
+	Result   string   `json:"result"`           // Raw FindProxyForURL return value
+	Proxies  []Proxy  `json:"proxies"`          // Parsed proxy list in order of preference
+	Alerts   []string `json:"alerts,omitempty"` // Messages passed to alert() during evaluation
+	Duration float64  `json:"duration"`         // Evaluation duration in milliseconds
+	Error    string   `json:"error,omitempty"`  // Evaluation error
+}
+
+// PACOptions contains the resolved PAC evaluation options
+type PACOptions struct {
+	URLs     []string
+	PACURL   string
+	Script   string
+	ClientIP string
+	Timeout  int
+}
+
+// resolvePACOptions converts PACMessage to PACOptions with defaults
+func resolvePACOptions(msg *PACMessage) (PACOptions, error) {
+	opts := PACOptions{
+		URLs:     msg.URLs,
+		PACURL:   tool.GetOrDefault(msg.PACURL, ""),
+		Script:   tool.GetOrDefault(msg.Script, ""),
+		ClientIP: tool.GetOrDefault(msg.ClientIP, ""),
+		Timeout:  tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if len(opts.URLs) == 0 {
+		return opts, fmt.Errorf("at least one url is required")
+	}
+	if (opts.PACURL == "") == (opts.Script == "") {
+		return opts, fmt.Errorf("exactly one of pac_url or script is required")
+	}
+	if len(opts.Script) > maxScriptSize {
+		return opts, fmt.Errorf("script exceeds %d bytes", maxScriptSize)
+	}
+	if opts.ClientIP != "" && net.ParseIP(opts.ClientIP) == nil {
+		return opts, fmt.Errorf("invalid client ip %q", opts.ClientIP)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// fetchScript downloads the PAC file from the given URL
+func fetchScript(ctx context.Context, pacURL string) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pacURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	tool.Identify(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxScriptSize+1))
+	if err != nil {
+		return "", resp.StatusCode, err
+	}
+	if len(body) > maxScriptSize {
+		return "", resp.StatusCode, fmt.Errorf("PAC file exceeds %d bytes", maxScriptSize)
+	}
+	return string(body), resp.StatusCode, nil
+}
+
+// localAddress returns the address of the interface used for outbound traffic
+func localAddress() string {
+	conn, err := net.Dial("udp4", "198.51.100.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// evaluator runs FindProxyForURL inside a JavaScript VM
+type evaluator struct {
+	vm       *otto.Otto
+	clientIP string
+	alerts   []string
+}
+
+// newEvaluator compiles the PAC script together with the PAC helper functions
+func newEvaluator(script, clientIP string) (*evaluator, error) {
+	e := &evaluator{vm: otto.New(), clientIP: clientIP}
+	e.vm.Interrupt = make(chan func(), 1)
+	if e.clientIP == "" {
+		e.clientIP = localAddress()
+	}
+
+	e.vm.Set("dnsResolve", e.dnsResolve)
+	e.vm.Set("myIpAddress", func(call otto.FunctionCall) otto.Value {
+		value, _ := otto.ToValue(e.clientIP)
+		return value
+	})
+	e.vm.Set("alert", func(call otto.FunctionCall) otto.Value {
+		e.alerts = append(e.alerts, call.Argument(0).String())
+		return otto.UndefinedValue()
+	})
+
+	if _, err := e.vm.Run(pacUtils); err != nil {
+		return nil, fmt.Errorf("error loading PAC helpers: %w", err)
+	}
+	if err := e.run(func() error {
+		_, err := e.vm.Run(script)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("error loading PAC script: %w", err)
+	}
+	if fn, err := e.vm.Get("FindProxyForURL"); err != nil || !fn.IsFunction() {
+		return nil, fmt.Errorf("PAC script does not define FindProxyForURL")
+	}
+	return e, nil
+}
+
+// dnsResolve implements the PAC dnsResolve function, preferring IPv4 addresses
+func (e *evaluator) dnsResolve(call otto.FunctionCall) otto.Value {
+	ctx, cancel := context.WithTimeout(context.Background(), evalTimeout*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, call.Argument(0).String())
+	if err != nil || len(addrs) == 0 {
+		return otto.NullValue()
+	}
+	ip := addrs[0].IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ip = addr.IP
+			break
+		}
+	}
+	value, _ := otto.ToValue(ip.String())
+	return value
+}
+
+// heapBytes returns the number of bytes currently held by live heap objects
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// interrupt asks the VM to abort with reason at its next statement
+func (e *evaluator) interrupt(reason error) {
+	select {
+	case e.vm.Interrupt <- func() { panic(reason) }:
+	default: // An interrupt is already pending
+	}
+}
+
+// watch interrupts the VM when ctx times out or the heap has grown by more
+// than evalMemLimit bytes. Other sessions allocate from the same heap, so
+// the limit is an approximation, as for scripts.
+func (e *evaluator) watch(ctx context.Context) {
+	baseline := heapBytes()
+	ticker := time.NewTicker(memCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				e.interrupt(errEvalTimeout)
+			}
+			return
+		case <-ticker.C:
+			if current := heapBytes(); current > baseline && current-baseline > evalMemLimit {
+				e.interrupt(errEvalMemory)
+				return
+			}
+		}
+	}
+}
+
+// run executes fn, interrupting the VM if it exceeds the evaluation timeout
+// or memory limit
+func (e *evaluator) run(fn func() error) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), evalTimeout*time.Second)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		e.watch(ctx)
+	}()
+	defer func() {
+		// Nothing sends once the watchdog is gone, so an interrupt the VM
+		// did not reach is dropped rather than aborting the next run
+		cancel()
+		<-watched
+		select {
+		case <-e.vm.Interrupt:
+		default:
+		}
+	}()
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			if caught != errEvalTimeout && caught != errEvalMemory {
+				panic(caught)
+			}
+			err = caught.(error)
+		}
+	}()
+	return fn()
+}
+
+// evaluate calls FindProxyForURL for a single URL
+func (e *evaluator) evaluate(rawURL string) ResultMessage {
+	start := time.Now()
+	result := ResultMessage{Type: "result", URL: rawURL, Proxies: []Proxy{}}
+	e.alerts = nil
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		result.Error = fmt.Sprintf("invalid url %q", rawURL)
+		return result
+	}
+	result.Host = u.Hostname()
+
+	err = e.run(func() error {
+		value, err := e.vm.Call("FindProxyForURL", nil, rawURL, result.Host)
+		if err != nil {
+			return err
+		}
+		result.Result = value.String()
+		return nil
+	})
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Proxies = parseProxies(result.Result)
+	result.Alerts = e.alerts
+	result.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	return result
+}
+
+// parseProxies splits a FindProxyForURL result such as "PROXY a:8080; DIRECT"
+func parseProxies(result string) []Proxy {
+	proxies := []Proxy{}
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		proxy := Proxy{Type: strings.ToUpper(fields[0])}
+		if len(fields) > 1 {
+			proxy.Host = fields[1]
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// Handler handles WebSocket PAC evaluation requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "pac")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg PACMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading PAC message: %v", err)
+		return
+	}
+
+	opts, err := resolvePACOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid PAC options: %v", err)
+		return
+	}
+
+	start := time.Now()
+	fetch := FetchMessage{Type: "fetch", Source: "inline"}
+	script := opts.Script
+	if opts.PACURL != "" {
+		fetch.Source = opts.PACURL
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+		script, fetch.Status, err = fetchScript(ctx, opts.PACURL)
+		cancel()
+	}
+
+	var e *evaluator
+	if err == nil {
+		e, err = newEvaluator(script, opts.ClientIP)
+	}
+	fetch.Bytes = len(script)
+	fetch.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		fetch.Error = err.Error()
+	}
+
+	if err := session.WriteJSON(fetch); err != nil {
+		log.Printf("Failed to send fetch result: %v", err)
+		return
+	}
+	if e == nil {
+		log.Printf("Failed to load PAC script from %s: %s", fetch.Source, fetch.Error)
+		return
+	}
+
+	for _, rawURL := range opts.URLs {
+		result := e.evaluate(rawURL)
+		log.Printf("PAC %s -> %q", rawURL, result.Result)
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send PAC result: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package pac
+
+// pacUtils implements the standard PAC helper functions that do not need
+// access to the network. dnsResolve, myIpAddress and alert are provided by
+// the Go side of the evaluator.
+const pacUtils = `
+var pacMonths = ['JAN', 'FEB', 'MAR', 'APR', 'MAY', 'JUN', 'JUL', 'AUG', 'SEP', 'OCT', 'NOV', 'DEC'];
+var pacWeekdays = ['SUN', 'MON', 'TUE', 'WED', 'THU', 'FRI', 'SAT'];
+
+function isPlainHostName(host) {
+	return host.indexOf('.') == -1;
+}
+
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length &&
+		host.substring(host.length - domain.length) == domain;
+}
+
+function localHostOrDomainIs(host, hostdom) {
+	return host == hostdom || hostdom.lastIndexOf(host + '.', 0) == 0;
+}
+
+function dnsDomainLevels(host) {
+	return host.split('.').length - 1;
+}
+
+function isResolvable(host) {
+	return dnsResolve(host) != null;
+}
+
+function convert_addr(ipchars) {
+	var bytes = ipchars.split('.');
+	return ((bytes[0] & 0xff) << 24) | ((bytes[1] & 0xff) << 16) |
+		((bytes[2] & 0xff) << 8) | (bytes[3] & 0xff);
+}
+
+function isInNet(ipaddr, pattern, maskstr) {
+	if (!/^\d+\.\d+\.\d+\.\d+$/.test(ipaddr)) {
+		ipaddr = dnsResolve(ipaddr);
+		if (ipaddr == null) {
+			return false;
+		}
+	}
+	var mask = convert_addr(maskstr);
+	return (convert_addr(ipaddr) & mask) == (convert_addr(pattern) & mask);
+}
+
+function shExpMatch(str, shexp) {
+	var pattern = shexp.replace(/[.+^${}()|[\]\\]/g, '\\$&')
+		.replace(/\*/g, '.*')
+		.replace(/\?/g, '.');
+	return new RegExp('^' + pattern + '$').test(str);
+}
+
+function pacArgs(args) {
+	var list = Array.prototype.slice.call(args);
+	var gmt = list.length > 0 && list[list.length - 1] == 'GMT';
+	if (gmt) {
+		list.pop();
+	}
+	return { list: list, gmt: gmt, now: new Date() };
+}
+
+function weekdayRange() {
+	var a = pacArgs(arguments);
+	if (a.list.length < 1 || a.list.length > 2) {
+		return false;
+	}
+	var wd1 = pacWeekdays.indexOf(a.list[0]);
+	var wd2 = a.list.length == 2 ? pacWeekdays.indexOf(a.list[1]) : wd1;
+	if (wd1 == -1 || wd2 == -1) {
+		return false;
+	}
+	var wday = a.gmt ? a.now.getUTCDay() : a.now.getDay();
+	if (wd1 <= wd2) {
+		return wd1 <= wday && wday <= wd2;
+	}
+	return wday >= wd1 || wday <= wd2;
+}
+
+function dateRange() {
+	var a = pacArgs(arguments);
+	var argc = a.list.length;
+	if (argc < 1 || argc > 6 || (argc > 1 && argc % 2 != 0)) {
+		return false;
+	}
+
+	function parse(values) {
+		var p = {};
+		for (var i = 0; i < values.length; i++) {
+			var n = parseInt(values[i], 10);
+			if (isNaN(n)) {
+				p.m = pacMonths.indexOf(values[i]);
+				if (p.m == -1) {
+					return null;
+				}
+			} else if (n < 32) {
+				p.d = n;
+			} else {
+				p.y = n;
+			}
+		}
+		return p;
+	}
+
+	var from = parse(argc == 1 ? a.list : a.list.slice(0, argc / 2));
+	var to = argc == 1 ? from : parse(a.list.slice(argc / 2));
+	if (from == null || to == null) {
+		return false;
+	}
+
+	var cur = {
+		d: a.gmt ? a.now.getUTCDate() : a.now.getDate(),
+		m: a.gmt ? a.now.getUTCMonth() : a.now.getMonth(),
+		y: a.gmt ? a.now.getUTCFullYear() : a.now.getFullYear()
+	};
+
+	function value(p) {
+		return ('y' in from ? p.y : 0) * 10000 +
+			('m' in from ? p.m : 0) * 100 +
+			('d' in from ? p.d : 0);
+	}
+
+	var v1 = value(from), v2 = value(to), v = value(cur);
+	if (v1 <= v2) {
+		return v1 <= v && v <= v2;
+	}
+	return v >= v1 || v <= v2;
+}
+
+function timeRange() {
+	var a = pacArgs(arguments);
+	var argc = a.list.length;
+	var n = [];
+	for (var i = 0; i < argc; i++) {
+		n.push(parseInt(a.list[i], 10));
+	}
+
+	var hour = a.gmt ? a.now.getUTCHours() : a.now.getHours();
+	var cur = hour * 3600 +
+		(a.gmt ? a.now.getUTCMinutes() : a.now.getMinutes()) * 60 +
+		(a.gmt ? a.now.getUTCSeconds() : a.now.getSeconds());
+
+	var v1, v2;
+	switch (argc) {
+	case 1:
+		return hour == n[0];
+	case 2:
+		v1 = n[0] * 3600;
+		v2 = n[1] * 3600 + 3599;
+		break;
+	case 4:
+		v1 = n[0] * 3600 + n[1] * 60;
+		v2 = n[2] * 3600 + n[3] * 60 + 59;
+		break;
+	case 6:
+		v1 = n[0] * 3600 + n[1] * 60 + n[2];
+		v2 = n[3] * 3600 + n[4] * 60 + n[5];
+		break;
+	default:
+		return false;
+	}
+	if (v1 <= v2) {
+		return v1 <= cur && cur <= v2;
+	}
+	return cur >= v1 || cur <= v2;
+}
+`
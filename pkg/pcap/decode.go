@@ -0,0 +1,206 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// Link types of captured frames, from the tcpdump.org registry
+const (
+	linkNull     = 0   // BSD loopback with a host order address family
+	linkEthernet = 1   // Ethernet II, optionally VLAN tagged
+	linkRaw      = 101 // Bare IPv4 or IPv6 packets
+	linkLoop     = 108 // OpenBSD loopback with a network order address family
+	linkSLL      = 113 // Linux cooked capture
+	linkIPv4     = 228 // Bare IPv4 packets
+	linkIPv6     = 229 // Bare IPv6 packets
+	linkSLL2     = 276 // Linux cooked capture v2
+)
+
+// Ethernet types of the frames that are decoded
+const (
+	etherIPv4  = 0x0800
+	etherIPv6  = 0x86dd
+	etherVLAN  = 0x8100
+	etherQinQ  = 0x88a8
+	etherVLAN2 = 0x9100
+)
+
+// IP protocol numbers of the transports that are decoded
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// TCP flags
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// segment is a decoded IP packet
+type segment struct {
+	src, dst netip.Addr
+	proto    int // IP protocol of the transport, or of the last header seen
+
+	// TCP and UDP, when the packet is not a later fragment
+	srcPort, dstPort uint16
+	seq, ack         uint32 // TCP only
+	flags            uint8  // TCP only
+	payload          []byte
+	transport        bool // Whether the transport header was decoded
+}
+
+// decode decodes the IP packet carried in a captured frame. It reports
+// false for frames that do not carry IPv4 or IPv6.
+func decode(linkType int, data []byte) (segment, bool) {
+	switch linkType {
+	case linkEthernet:
+		if len(data) < 14 {
+			return segment{}, false
+		}
+		etherType, offset := binary.BigEndian.Uint16(data[12:]), 14
+		for etherType == etherVLAN || etherType == etherQinQ || etherType == etherVLAN2 {
+			if len(data) < offset+4 {
+				return segment{}, false
+			}
+			etherType, offset = binary.BigEndian.Uint16(data[offset+2:]), offset+4
+		}
+		if etherType != etherIPv4 && etherType != etherIPv6 {
+			return segment{}, false
+		}
+		return decodeIP(data[offset:])
+	case linkSLL:
+		if len(data) < 16 {
+			return segment{}, false
+		}
+		return decodeIP(data[16:])
+	case linkSLL2:
+		if len(data) < 20 {
+			return segment{}, false
+		}
+		return decodeIP(data[20:])
+	case linkNull, linkLoop:
+		if len(data) < 4 {
+			return segment{}, false
+		}
+		return decodeIP(data[4:])
+	case linkRaw, linkIPv4, linkIPv6:
+		return decodeIP(data)
+	}
+	return segment{}, false
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet by its version
+func decodeIP(data []byte) (segment, bool) {
+	if len(data) == 0 {
+		return segment{}, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data)
+	case 6:
+		return decodeIPv6(data)
+	}
+	return segment{}, false
+}
+
+func decodeIPv4(data []byte) (segment, bool) {
+	if len(data) < 20 {
+		return segment{}, false
+	}
+	headerLen := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:]))
+	if headerLen < 20 || len(data) < headerLen {
+		return segment{}, false
+	}
+	// Captures may pad short frames or truncate long ones
+	if total >= headerLen && total < len(data) {
+		data = data[:total]
+	}
+	s := segment{
+		src:   netip.AddrFrom4([4]byte(data[12:16])),
+		dst:   netip.AddrFrom4([4]byte(data[16:20])),
+		proto: int(data[9]),
+	}
+	if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+		// Later fragments carry no transport header
+		return s, true
+	}
+	decodeTransport(&s, data[headerLen:])
+	return s, true
+}
+
+func decodeIPv6(data []byte) (segment, bool) {
+	if len(data) < 40 {
+		return segment{}, false
+	}
+	payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+	if 40+payloadLen < len(data) {
+		data = data[:40+payloadLen]
+	}
+	s := segment{
+		src:   netip.AddrFrom16([16]byte(data[8:24])),
+		dst:   netip.AddrFrom16([16]byte(data[24:40])),
+		proto: int(data[6]),
+	}
+
+	rest := data[40:]
+	for {
+		switch s.proto {
+		case 0, 43, 60: // Hop-by-hop, routing and destination options
+			if len(rest) < 8 {
+				return s, true
+			}
+			next, size := int(rest[0]), (int(rest[1])+1)*8
+			if len(rest) < size {
+				return s, true
+			}
+			s.proto, rest = next, rest[size:]
+		case 44: // Fragment
+			if len(rest) < 8 {
+				return s, true
+			}
+			next, offset := int(rest[0]), binary.BigEndian.Uint16(rest[2:])>>3
+			s.proto, rest = next, rest[8:]
+			if offset != 0 {
+				return s, true
+			}
+		default:
+			decodeTransport(&s, rest)
+			return s, true
+		}
+	}
+}
+
+// decodeTransport decodes the TCP or UDP header at the start of data
+func decodeTransport(s *segment, data []byte) {
+	switch s.proto {
+	case protoTCP:
+		if len(data) < 20 {
+			return
+		}
+		headerLen := int(data[12]>>4) * 4
+		if headerLen < 20 || len(data) < headerLen {
+			return
+		}
+		s.srcPort, s.dstPort = binary.BigEndian.Uint16(data[0:]), binary.BigEndian.Uint16(data[2:])
+		s.seq, s.ack = binary.BigEndian.Uint32(data[4:]), binary.BigEndian.Uint32(data[8:])
+		s.flags = data[13]
+		s.payload = data[headerLen:]
+		s.transport = true
+	case protoUDP:
+		if len(data) < 8 {
+			return
+		}
+		s.srcPort, s.dstPort = binary.BigEndian.Uint16(data[0:]), binary.BigEndian.Uint16(data[2:])
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		s.payload = data[8:]
+		if length >= 8 && length-8 < len(s.payload) {
+			s.payload = s.payload[:length-8]
+		}
+		s.transport = true
+	}
+}
@@ -0,0 +1,156 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+var (
+	testSrc4 = netip.MustParseAddr("192.0.2.1")
+	testDst4 = netip.MustParseAddr("198.51.100.2")
+	testSrc6 = netip.MustParseAddr("2001:db8::1")
+	testDst6 = netip.MustParseAddr("2001:db8::2")
+)
+
+// ethernet wraps payload in an Ethernet II header
+func ethernet(etherType uint16, payload []byte) []byte {
+	b := make([]byte, 12)
+	b = binary.BigEndian.AppendUint16(b, etherType)
+	return append(b, payload...)
+}
+
+// ipv4 wraps payload in an IPv4 header from testSrc4 to testDst4
+func ipv4(proto byte, payload []byte) []byte {
+	b := make([]byte, 20)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(20+len(payload)))
+	b[8], b[9] = 64, proto
+	copy(b[12:], testSrc4.AsSlice())
+	copy(b[16:], testDst4.AsSlice())
+	return append(b, payload...)
+}
+
+// ipv6 wraps payload in an IPv6 header from testSrc6 to testDst6
+func ipv6(next byte, payload []byte) []byte {
+	b := make([]byte, 40)
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(len(payload)))
+	b[6], b[7] = next, 64
+	copy(b[8:], testSrc6.AsSlice())
+	copy(b[24:], testDst6.AsSlice())
+	return append(b, payload...)
+}
+
+// tcp encodes a TCP segment without options
+func tcp(src, dst uint16, flags byte, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, src)
+	b = binary.BigEndian.AppendUint16(b, dst)
+	b = binary.BigEndian.AppendUint32(b, 1000)
+	b = binary.BigEndian.AppendUint32(b, 2000)
+	b = append(b, 5<<4, flags, 0xff, 0xff, 0, 0, 0, 0)
+	return append(b, payload...)
+}
+
+// udp encodes a UDP datagram
+func udp(src, dst uint16, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, src)
+	b = binary.BigEndian.AppendUint16(b, dst)
+	b = binary.BigEndian.AppendUint16(b, uint16(8+len(payload)))
+	b = append(b, 0, 0)
+	return append(b, payload...)
+}
+
+func TestDecode(t *testing.T) {
+	syn := tcp(40000, 443, flagSYN, nil)
+	query := udp(5353, 53, []byte("query"))
+	tagged := func(etherType uint16, payload []byte) []byte {
+		return append(binary.BigEndian.AppendUint16([]byte{0, 100}, etherType), payload...)
+	}
+	later := ipv4(protoUDP, query)
+	binary.BigEndian.PutUint16(later[6:], 185) // Fragment offset 1480
+	padded := append(ethernet(etherIPv4, ipv4(protoUDP, udp(1, 2, []byte("x")))), make([]byte, 17)...)
+	hopByHop := append([]byte{protoTCP, 0, 0, 0, 0, 0, 0, 0}, syn...)
+	firstFragment := append([]byte{protoUDP, 0, 0, 0, 0, 0, 0, 1}, query...)
+	laterFragment := append([]byte{protoUDP, 0, 0x05, 0xc8, 0, 0, 0, 1}, query[:4]...)
+	shortTCP := ipv4(protoTCP, syn[:12])
+	binary.BigEndian.PutUint16(shortTCP[2:], 40)
+
+	tests := []struct {
+		name      string
+		linkType  int
+		data      []byte
+		ok        bool
+		src, dst  netip.Addr
+		proto     int
+		transport bool
+		srcPort   uint16
+		dstPort   uint16
+		payload   string
+	}{
+		{"ethernet tcp", linkEthernet, ethernet(etherIPv4, ipv4(protoTCP, tcp(40000, 443, flagSYN, []byte("hello")))), true, testSrc4, testDst4, protoTCP, true, 40000, 443, "hello"},
+		{"vlan udp", linkEthernet, ethernet(etherVLAN, tagged(etherIPv4, ipv4(protoUDP, query))), true, testSrc4, testDst4, protoUDP, true, 5353, 53, "query"},
+		{"qinq", linkEthernet, ethernet(etherQinQ, tagged(etherVLAN, tagged(etherIPv6, ipv6(protoTCP, syn)))), true, testSrc6, testDst6, protoTCP, true, 40000, 443, ""},
+		{"ethernet padding", linkEthernet, padded, true, testSrc4, testDst4, protoUDP, true, 1, 2, "x"},
+		{"ipv6 hop-by-hop", linkRaw, ipv6(0, hopByHop), true, testSrc6, testDst6, protoTCP, true, 40000, 443, ""},
+		{"ipv6 first fragment", linkIPv6, ipv6(44, firstFragment), true, testSrc6, testDst6, protoUDP, true, 5353, 53, "query"},
+		{"ipv6 later fragment", linkIPv6, ipv6(44, laterFragment), true, testSrc6, testDst6, protoUDP, false, 0, 0, ""},
+		{"ipv4 later fragment", linkIPv4, later, true, testSrc4, testDst4, protoUDP, false, 0, 0, ""},
+		{"linux cooked", linkSLL, append(make([]byte, 16), ipv4(protoTCP, syn)...), true, testSrc4, testDst4, protoTCP, true, 40000, 443, ""},
+		{"linux cooked v2", linkSLL2, append(make([]byte, 20), ipv6(protoUDP, query)...), true, testSrc6, testDst6, protoUDP, true, 5353, 53, "query"},
+		{"bsd loopback", linkNull, append([]byte{2, 0, 0, 0}, ipv4(protoUDP, query)...), true, testSrc4, testDst4, protoUDP, true, 5353, 53, "query"},
+		{"other transport", linkRaw, ipv4(1, []byte{8, 0, 0, 0}), true, testSrc4, testDst4, 1, false, 0, 0, ""},
+		{"truncated tcp header", linkRaw, shortTCP, true, testSrc4, testDst4, protoTCP, false, 0, 0, ""},
+		{"truncated udp header", linkRaw, ipv6(protoUDP, query[:6]), true, testSrc6, testDst6, protoUDP, false, 0, 0, ""},
+		{"truncated extension header", linkRaw, ipv6(60, []byte{protoTCP, 4, 0, 0, 0, 0, 0, 0}), true, testSrc6, testDst6, 60, false, 0, 0, ""},
+		{"short ethernet", linkEthernet, make([]byte, 13), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"short vlan tag", linkEthernet, ethernet(etherVLAN, []byte{0, 100}), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"arp", linkEthernet, ethernet(0x0806, make([]byte, 28)), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"short ipv4", linkRaw, ipv4(protoTCP, nil)[:19], false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"ipv4 options past the packet", linkRaw, append([]byte{0x4f}, ipv4(protoTCP, nil)[1:]...), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"short ipv6", linkRaw, ipv6(protoTCP, nil)[:39], false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"empty packet", linkRaw, nil, false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"short linux cooked", linkSLL, make([]byte, 15), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+		{"unknown link type", 147, ipv4(protoTCP, syn), false, netip.Addr{}, netip.Addr{}, 0, false, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := decode(tt.linkType, tt.data)
+			if ok != tt.ok {
+				t.Fatalf("decode() ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if s.src != tt.src || s.dst != tt.dst || s.proto != tt.proto {
+				t.Errorf("decode() = %s > %s proto %d, want %s > %s proto %d", s.src, s.dst, s.proto, tt.src, tt.dst, tt.proto)
+			}
+			if s.transport != tt.transport || s.srcPort != tt.srcPort || s.dstPort != tt.dstPort || string(s.payload) != tt.payload {
+				t.Errorf("decode() transport %t ports %d > %d payload %q, want %t ports %d > %d payload %q",
+					s.transport, s.srcPort, s.dstPort, s.payload, tt.transport, tt.srcPort, tt.dstPort, tt.payload)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(linkEthernet, ethernet(etherIPv4, ipv4(protoTCP, tcp(1, 2, flagSYN, []byte("data")))))
+	f.Add(linkEthernet, ethernet(etherVLAN, []byte{0, 1, 0x86, 0xdd}))
+	f.Add(linkRaw, ipv6(0, []byte{44, 0, 0, 0, 0, 0, 0, 0, protoUDP, 0, 0, 0, 0, 0, 0, 0}))
+	f.Add(linkSLL, append(make([]byte, 16), 0x4f))
+	f.Add(linkNull, []byte{2, 0, 0, 0, 0x60})
+
+	f.Fuzz(func(t *testing.T, linkType int, data []byte) {
+		s, ok := decode(linkType, data)
+		if !ok {
+			return
+		}
+		if !s.src.IsValid() || !s.dst.IsValid() {
+			t.Fatalf("decode() accepted a packet without addresses")
+		}
+		if len(s.payload) > 0 && !bytes.Contains(data, s.payload) {
+			t.Fatalf("decode() payload %q is not part of the frame", s.payload)
+		}
+	})
+}
@@ -0,0 +1,468 @@
+package pcap
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// MaxUploadSize bounds the size of an uploaded capture
+const MaxUploadSize = 64 << 20
+
+// Bounds of the lists in a report. Totals and statistics still cover every
+// packet in the capture.
+const (
+	defaultTop         = 10
+	maxTop             = 100
+	maxHandshakes      = 100
+	maxFlows           = 100
+	maxDNSTransactions = 1000
+)
+
+// Report is the result of analyzing a capture
+type Report struct {
+	Type            string               `json:"type"`            // Always "pcap"
+	Format          string               `json:"format"`          // "pcap" or "pcapng"
+	Packets         int                  `json:"packets"`         // Packets in the capture
+	Bytes           int64                `json:"bytes"`           // Sum of the packet lengths on the wire
+	Skipped         int                  `json:"skipped"`         // Packets that are not IPv4 or IPv6
+	Start           *time.Time           `json:"start,omitempty"` // Timestamp of the first packet
+	End             *time.Time           `json:"end,omitempty"`   // Timestamp of the last packet
+	Duration        float64              `json:"duration"`        // Seconds between the first and last packet
+	Handshakes      HandshakeReport      `json:"handshakes"`      // TCP handshake round trip times
+	Retransmissions RetransmissionReport `json:"retransmissions"` // TCP retransmissions
+	TopTalkers      []Talker             `json:"top_talkers"`     // Addresses by bytes sent and received
+	DNS             DNSReport            `json:"dns"`             // DNS transactions over UDP
+	Error           string               `json:"error,omitempty"` // Why reading stopped before the end of the capture
+}
+
+// HandshakeReport summarizes the completed TCP handshakes in a capture.
+// The round trip time of a handshake is the time from the SYN to the final
+// ACK, so it covers both sides of the capture point.
+type HandshakeReport struct {
+	Count       int         `json:"count"`       // Completed handshakes
+	Min         float64     `json:"min"`         // Shortest round trip time in milliseconds
+	Avg         float64     `json:"avg"`         // Average round trip time in milliseconds
+	Max         float64     `json:"max"`         // Longest round trip time in milliseconds
+	Connections []Handshake `json:"connections"` // First completed handshakes
+	Truncated   bool        `json:"truncated"`   // Whether connections were left out
+}
+
+// Handshake is a completed TCP handshake
+type Handshake struct {
+	Time   time.Time `json:"time"`    // Timestamp of the SYN
+	Client string    `json:"client"`  // Address and port that sent the SYN
+	Server string    `json:"server"`  // Address and port that answered with a SYN/ACK
+	SynAck float64   `json:"syn_ack"` // Milliseconds from the SYN to the SYN/ACK
+	Ack    float64   `json:"ack"`     // Milliseconds from the SYN/ACK to the ACK
+	RTT    float64   `json:"rtt"`     // Milliseconds from the SYN to the ACK
+}
+
+// RetransmissionReport counts TCP segments that resend sequence space that
+// was already seen in the same direction of a connection
+type RetransmissionReport struct {
+	Segments        int    `json:"segments"`        // TCP segments carrying data, SYN or FIN
+	Retransmissions int    `json:"retransmissions"` // Segments that were retransmitted
+	Flows           []Flow `json:"flows"`           // Flows with the most retransmissions
+	Truncated       bool   `json:"truncated"`       // Whether flows were left out
+}
+
+// Flow counts the segments of one direction of a TCP connection
+type Flow struct {
+	Source          string `json:"source"`          // Sending address and port
+	Destination     string `json:"destination"`     // Receiving address and port
+	Segments        int    `json:"segments"`        // Segments carrying data, SYN or FIN
+	Retransmissions int    `json:"retransmissions"` // Retransmitted segments
+}
+
+// Talker is the traffic sent and received by an address
+type Talker struct {
+	Address  string `json:"address"`  // IP address
+	Packets  int    `json:"packets"`  // Packets sent and received
+	Bytes    int64  `json:"bytes"`    // Bytes sent and received
+	Sent     int64  `json:"sent"`     // Bytes sent
+	Received int64  `json:"received"` // Bytes received
+}
+
+// DNSReport is the log of DNS queries over UDP and their responses
+type DNSReport struct {
+	Queries      int              `json:"queries"`      // Queries seen
+	Responses    int              `json:"responses"`    // Responses matched to a query
+	Unanswered   int              `json:"unanswered"`   // Queries without a response
+	Transactions []DNSTransaction `json:"transactions"` // First queries in capture order
+	Truncated    bool             `json:"truncated"`    // Whether transactions were left out
+}
+
+// DNSTransaction is a DNS query and its response
+type DNSTransaction struct {
+	Time     time.Time `json:"time"`              // Timestamp of the query
+	Client   string    `json:"client"`            // Address and port that sent the query
+	Server   string    `json:"server"`            // Address and port the query was sent to
+	ID       uint16    `json:"id"`                // DNS message ID
+	Name     string    `json:"name"`              // Queried name
+	Qtype    string    `json:"qtype"`             // Queried record type
+	Answered bool      `json:"answered"`          // Whether a response was seen
+	Rcode    string    `json:"rcode,omitempty"`   // Response code
+	Answers  []string  `json:"answers,omitempty"` // Answer records as type and data
+	Latency  float64   `json:"latency"`           // Milliseconds from the query to the response
+}
+
+type flowKey struct {
+	src, dst netip.AddrPort
+}
+
+type dnsKey struct {
+	flowKey
+	id uint16
+}
+
+// handshake is a TCP handshake in progress, keyed by client and server
+type handshake struct {
+	syn, synAck time.Time
+}
+
+// flow tracks the sequence space seen in one direction of a connection
+type flow struct {
+	Flow
+	next uint32 // Sequence number after the highest one seen
+}
+
+type analyzer struct {
+	report     Report
+	talkers    map[netip.Addr]*Talker
+	handshakes map[flowKey]*handshake
+	flows      map[flowKey]*flow
+	queries    map[dnsKey]*DNSTransaction
+	logged     []*DNSTransaction // Transactions kept for the report
+	rttSum     float64
+}
+
+// Analyze reads a pcap or pcapng capture and reports on its traffic.
+// A capture that cannot be read to its end is reported up to the failure
+// with the reason in the report's Error field.
+func Analyze(r io.Reader, top int) (Report, error) {
+	rd, err := newReader(r)
+	if err != nil {
+		return Report{}, err
+	}
+
+	a := &analyzer{
+		report:     Report{Type: "pcap", Format: "pcap"},
+		talkers:    make(map[netip.Addr]*Talker),
+		handshakes: make(map[flowKey]*handshake),
+		flows:      make(map[flowKey]*flow),
+		queries:    make(map[dnsKey]*DNSTransaction),
+	}
+	if rd.ng {
+		a.report.Format = "pcapng"
+	}
+	for {
+		p, err := rd.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return Report{}, err
+			}
+			a.report.Error = err.Error()
+			break
+		}
+		a.add(p)
+	}
+	return a.finish(top), nil
+}
+
+// add accounts for a packet in every analysis
+func (a *analyzer) add(p packet) {
+	a.report.Packets++
+	length := int64(p.length)
+	if length == 0 {
+		length = int64(len(p.data))
+	}
+	a.report.Bytes += length
+	if !p.timestamp.IsZero() {
+		if a.report.Start == nil {
+			start := p.timestamp
+			a.report.Start = &start
+		}
+		end := p.timestamp
+		a.report.End = &end
+	}
+
+	s, ok := decode(p.linkType, p.data)
+	if !ok {
+		a.report.Skipped++
+		return
+	}
+	a.talker(s.src).Sent += length
+	a.talker(s.dst).Received += length
+	for _, addr := range []netip.Addr{s.src, s.dst} {
+		t := a.talker(addr)
+		t.Packets++
+		t.Bytes += length
+	}
+
+	if !s.transport {
+		return
+	}
+	key := flowKey{netip.AddrPortFrom(s.src, s.srcPort), netip.AddrPortFrom(s.dst, s.dstPort)}
+	switch s.proto {
+	case protoTCP:
+		a.handshake(key, s, p.timestamp)
+		a.sequence(key, s)
+	case protoUDP:
+		if s.srcPort == 53 || s.dstPort == 53 {
+			a.dns(key, s, p.timestamp)
+		}
+	}
+}
+
+func (a *analyzer) talker(addr netip.Addr) *Talker {
+	t, ok := a.talkers[addr]
+	if !ok {
+		t = &Talker{Address: addr.String()}
+		a.talkers[addr] = t
+	}
+	return t
+}
+
+// handshake follows the SYN, SYN/ACK and ACK of a connection
+func (a *analyzer) handshake(key flowKey, s segment, at time.Time) {
+	switch {
+	case s.flags&flagRST != 0:
+		delete(a.handshakes, key)
+		delete(a.handshakes, flowKey{key.dst, key.src})
+	case s.flags&(flagSYN|flagACK) == flagSYN:
+		// Retransmitted SYNs keep the time of the first one
+		if _, ok := a.handshakes[key]; !ok {
+			a.handshakes[key] = &handshake{syn: at}
+		}
+	case s.flags&(flagSYN|flagACK) == flagSYN|flagACK:
+		if h, ok := a.handshakes[flowKey{key.dst, key.src}]; ok && h.synAck.IsZero() {
+			h.synAck = at
+		}
+	case s.flags&flagACK != 0:
+		h, ok := a.handshakes[key]
+		if !ok || h.synAck.IsZero() {
+			return
+		}
+		delete(a.handshakes, key)
+		hs := Handshake{
+			Time:   h.syn,
+			Client: key.src.String(),
+			Server: key.dst.String(),
+			SynAck: milliseconds(h.synAck.Sub(h.syn)),
+			Ack:    milliseconds(at.Sub(h.synAck)),
+			RTT:    milliseconds(at.Sub(h.syn)),
+		}
+		report := &a.report.Handshakes
+		if report.Count == 0 || hs.RTT < report.Min {
+			report.Min = hs.RTT
+		}
+		report.Max = max(report.Max, hs.RTT)
+		report.Count++
+		a.rttSum += hs.RTT
+		if len(report.Connections) < maxHandshakes {
+			report.Connections = append(report.Connections, hs)
+		} else {
+			report.Truncated = true
+		}
+	}
+}
+
+// sequence counts a segment as retransmitted when it ends at or before the
+// highest sequence number already seen in its direction
+func (a *analyzer) sequence(key flowKey, s segment) {
+	length := uint32(len(s.payload))
+	if s.flags&flagSYN != 0 {
+		length++
+	}
+	if s.flags&flagFIN != 0 {
+		length++
+	}
+	if length == 0 {
+		return
+	}
+	end := s.seq + length
+
+	f, ok := a.flows[key]
+	if !ok {
+		a.flows[key] = &flow{
+			Flow: Flow{Source: key.src.String(), Destination: key.dst.String(), Segments: 1},
+			next: end,
+		}
+		a.report.Retransmissions.Segments++
+		return
+	}
+	f.Segments++
+	a.report.Retransmissions.Segments++
+	// Serial number arithmetic handles sequence wraparound
+	if int32(end-f.next) <= 0 {
+		f.Retransmissions++
+		a.report.Retransmissions.Retransmissions++
+		return
+	}
+	f.next = end
+}
+
+// dns logs DNS queries and matches responses to them by client, server
+// and message ID
+func (a *analyzer) dns(key flowKey, s segment, at time.Time) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(s.payload); err != nil {
+		return
+	}
+
+	if !msg.Response {
+		a.report.DNS.Queries++
+		t := &DNSTransaction{Time: at, Client: key.src.String(), Server: key.dst.String(), ID: msg.Id}
+		if len(msg.Question) > 0 {
+			t.Name = msg.Question[0].Name
+			t.Qtype = dns.Type(msg.Question[0].Qtype).String()
+		}
+		if len(a.logged) < maxDNSTransactions {
+			a.logged = append(a.logged, t)
+		} else {
+			a.report.DNS.Truncated = true
+		}
+		a.queries[dnsKey{key, msg.Id}] = t
+		return
+	}
+
+	query := dnsKey{flowKey{key.dst, key.src}, msg.Id}
+	t, ok := a.queries[query]
+	if !ok {
+		return
+	}
+	delete(a.queries, query)
+	a.report.DNS.Responses++
+	t.Answered = true
+	t.Rcode = dns.RcodeToString[msg.Rcode]
+	t.Latency = milliseconds(at.Sub(t.Time))
+	for _, rr := range msg.Answer {
+		data := strings.TrimPrefix(rr.String(), rr.Header().String())
+		t.Answers = append(t.Answers, dns.Type(rr.Header().Rrtype).String()+" "+data)
+	}
+}
+
+// finish fills in the summaries of the report
+func (a *analyzer) finish(top int) Report {
+	report := a.report
+	if report.Start != nil {
+		report.Duration = report.End.Sub(*report.Start).Seconds()
+	}
+	if report.Handshakes.Count > 0 {
+		report.Handshakes.Avg = math.Round(a.rttSum/float64(report.Handshakes.Count)*1000) / 1000
+	}
+	report.DNS.Unanswered = report.DNS.Queries - report.DNS.Responses
+
+	report.Handshakes.Connections = nonNil(report.Handshakes.Connections)
+	report.DNS.Transactions = make([]DNSTransaction, len(a.logged))
+	for i, t := range a.logged {
+		report.DNS.Transactions[i] = *t
+	}
+
+	var flows []Flow
+	for _, f := range a.flows {
+		if f.Retransmissions > 0 {
+			flows = append(flows, f.Flow)
+		}
+	}
+	slices.SortFunc(flows, func(x, y Flow) int {
+		return cmp.Or(cmp.Compare(y.Retransmissions, x.Retransmissions), cmp.Compare(x.Source, y.Source), cmp.Compare(x.Destination, y.Destination))
+	})
+	if len(flows) > maxFlows {
+		flows, report.Retransmissions.Truncated = flows[:maxFlows], true
+	}
+	report.Retransmissions.Flows = nonNil(flows)
+
+	talkers := make([]Talker, 0, len(a.talkers))
+	for _, t := range a.talkers {
+		talkers = append(talkers, *t)
+	}
+	slices.SortFunc(talkers, func(x, y Talker) int {
+		return cmp.Or(cmp.Compare(y.Bytes, x.Bytes), cmp.Compare(x.Address, y.Address))
+	})
+	report.TopTalkers = talkers[:min(top, len(talkers))]
+	return report
+}
+
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// Handler analyzes a capture uploaded as the request body or as the file
+// field of a multipart form. The top query parameter sets the number of
+// top talkers.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	top := defaultTop
+	if value := r.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTop {
+			tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("top must be between 1 and %d", maxTop))
+			return
+		}
+		top = n
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	body := io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		form, err := r.MultipartReader()
+		if err != nil {
+			tool.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		for {
+			part, err := form.NextPart()
+			if err == io.EOF {
+				err = errors.New("multipart form has no file field")
+			}
+			if err != nil {
+				tool.WriteError(w, uploadStatus(err), err)
+				return
+			}
+			if part.FormName() == "file" {
+				body = part
+				break
+			}
+		}
+	}
+
+	report, err := Analyze(body, top)
+	if err != nil {
+		tool.WriteError(w, uploadStatus(err), err)
+		return
+	}
+	tool.WriteJSON(w, http.StatusOK, report)
+}
+
+// uploadStatus returns the status code for an error reading an upload
+func uploadStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
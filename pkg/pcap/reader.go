@@ -0,0 +1,246 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Magic numbers identifying capture file formats
+const (
+	magicMicros = 0xa1b2c3d4 // Classic pcap with microsecond timestamps
+	magicNanos  = 0xa1b23c4d // Classic pcap with nanosecond timestamps
+	magicNG     = 0x0a0d0d0a // pcapng section header block
+	ngByteOrder = 0x1a2b3c4d // pcapng byte order magic
+)
+
+// pcapng block types
+const (
+	blockInterface = 0x00000001 // Interface description block
+	blockSimple    = 0x00000003 // Simple packet block
+	blockEnhanced  = 0x00000006 // Enhanced packet block
+)
+
+// maxSnapLen bounds the captured length of a single packet
+const maxSnapLen = 256 << 10
+
+// ErrUnknownFormat is returned for files that are neither pcap nor pcapng
+var ErrUnknownFormat = errors.New("not a pcap or pcapng file")
+
+// packet is a captured frame
+type packet struct {
+	timestamp time.Time
+	linkType  int
+	data      []byte
+	length    int // Length of the frame on the wire
+}
+
+// reader reads packets from a pcap or pcapng file
+type reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// Classic pcap
+	linkType int
+	nanos    bool
+
+	// pcapng interfaces in order of their description blocks
+	links       []int
+	resolutions []uint64 // Timestamp ticks per second
+}
+
+// newReader detects the file format from its first block
+func newReader(r io.Reader) (*reader, error) {
+	rd := &reader{r: bufio.NewReader(r)}
+	header, err := rd.r.Peek(4)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+
+	if binary.BigEndian.Uint32(header) == magicNG {
+		rd.ng = true
+		return rd, nil
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header) {
+		case magicMicros:
+			rd.order = order
+		case magicNanos:
+			rd.order, rd.nanos = order, true
+		default:
+			continue
+		}
+		var file [24]byte
+		if _, err := io.ReadFull(rd.r, file[:]); err != nil {
+			return nil, truncated(err, "pcap header")
+		}
+		rd.linkType = int(order.Uint32(file[20:]) & 0xffff)
+		return rd, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// next returns the next packet, or io.EOF at the end of the file
+func (rd *reader) next() (packet, error) {
+	if rd.ng {
+		return rd.nextBlock()
+	}
+
+	var record [16]byte
+	if _, err := io.ReadFull(rd.r, record[:]); err != nil {
+		return packet{}, truncated(err, "packet record")
+	}
+	sec, frac := rd.order.Uint32(record[0:]), rd.order.Uint32(record[4:])
+	captured, length := rd.order.Uint32(record[8:]), rd.order.Uint32(record[12:])
+	if captured > maxSnapLen {
+		return packet{}, fmt.Errorf("packet of %d bytes exceeds the %d byte limit", captured, maxSnapLen)
+	}
+	data := make([]byte, captured)
+	if _, err := io.ReadFull(rd.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return packet{}, truncated(err, "packet data")
+	}
+	if !rd.nanos {
+		frac *= 1000
+	}
+	return packet{
+		timestamp: time.Unix(int64(sec), int64(frac)).UTC(),
+		linkType:  rd.linkType,
+		data:      data,
+		length:    int(length),
+	}, nil
+}
+
+// nextBlock reads pcapng blocks until the next packet
+func (rd *reader) nextBlock() (packet, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(rd.r, header[:]); err != nil {
+			return packet{}, truncated(err, "pcapng block")
+		}
+
+		// Each section header block sets the byte order of its section
+		if binary.BigEndian.Uint32(header[:]) == magicNG {
+			magic, err := rd.r.Peek(4)
+			if err != nil {
+				return packet{}, fmt.Errorf("truncated section header")
+			}
+			rd.order = binary.LittleEndian
+			if binary.BigEndian.Uint32(magic) == ngByteOrder {
+				rd.order = binary.BigEndian
+			}
+			rd.links, rd.resolutions = nil, nil
+		}
+		if rd.order == nil {
+			return packet{}, ErrUnknownFormat
+		}
+
+		blockType, total := rd.order.Uint32(header[0:]), rd.order.Uint32(header[4:])
+		if total < 12 || total%4 != 0 || total > maxSnapLen+64 {
+			return packet{}, fmt.Errorf("invalid pcapng block length %d", total)
+		}
+		body := make([]byte, total-8)
+		if _, err := io.ReadFull(rd.r, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return packet{}, truncated(err, "pcapng block")
+		}
+		body = body[:len(body)-4] // Trailing copy of the block length
+
+		switch blockType {
+		case blockInterface:
+			if len(body) < 8 {
+				return packet{}, fmt.Errorf("truncated interface description block")
+			}
+			rd.links = append(rd.links, int(rd.order.Uint16(body[0:])))
+			rd.resolutions = append(rd.resolutions, rd.resolution(body[8:]))
+		case blockEnhanced:
+			if len(body) < 20 {
+				return packet{}, fmt.Errorf("truncated enhanced packet block")
+			}
+			iface := int(rd.order.Uint32(body[0:]))
+			if iface >= len(rd.links) {
+				return packet{}, fmt.Errorf("packet on undescribed interface %d", iface)
+			}
+			ticks := uint64(rd.order.Uint32(body[4:]))<<32 | uint64(rd.order.Uint32(body[8:]))
+			captured, length := rd.order.Uint32(body[12:]), rd.order.Uint32(body[16:])
+			if int(captured) > len(body)-20 {
+				return packet{}, fmt.Errorf("enhanced packet block shorter than its packet")
+			}
+			return packet{
+				timestamp: ngTime(ticks, rd.resolutions[iface]),
+				linkType:  rd.links[iface],
+				data:      body[20 : 20+captured],
+				length:    int(length),
+			}, nil
+		case blockSimple:
+			if len(body) < 4 || len(rd.links) == 0 {
+				return packet{}, fmt.Errorf("invalid simple packet block")
+			}
+			length := int(rd.order.Uint32(body[0:]))
+			data := body[4:]
+			if length < len(data) {
+				data = data[:length]
+			}
+			// Simple packets carry no timestamp
+			return packet{linkType: rd.links[0], data: data, length: length}, nil
+		}
+	}
+}
+
+// truncated describes a read that ended early. io.EOF is passed through
+// so the end of the file between records is not an error.
+func truncated(err error, what string) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("truncated %s", what)
+	}
+	return err
+}
+
+// resolution returns the timestamp ticks per second from the if_tsresol
+// option of an interface description block, microseconds by default
+func (rd *reader) resolution(options []byte) uint64 {
+	const micros = 1000000
+	for len(options) >= 4 {
+		code, size := rd.order.Uint16(options[0:]), int(rd.order.Uint16(options[2:]))
+		if code == 0 || 4+size > len(options) {
+			break
+		}
+		if code == 9 && size >= 1 {
+			v := options[4]
+			switch {
+			case v&0x80 != 0 && v&0x7f < 64:
+				return 1 << (v & 0x7f)
+			case v&0x80 == 0 && v < 20:
+				tps := uint64(1)
+				for i := byte(0); i < v; i++ {
+					tps *= 10
+				}
+				return tps
+			}
+			return micros
+		}
+		next := 4 + (size+3)&^3
+		if next > len(options) {
+			break
+		}
+		options = options[next:]
+	}
+	return micros
+}
+
+// ngTime converts a pcapng timestamp in ticks to a time
+func ngTime(ticks, perSecond uint64) time.Time {
+	sec, frac := ticks/perSecond, ticks%perSecond
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nanos, _ := bits.Div64(hi, lo, perSecond)
+	return time.Unix(int64(sec), int64(nanos)).UTC()
+}
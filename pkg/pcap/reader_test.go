@@ -0,0 +1,380 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// byteOrder reads and appends integers in the byte order of a section
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// ngBlock encodes a pcapng block, padding its body to 32 bits
+func ngBlock(order byteOrder, blockType uint32, body []byte) []byte {
+	body = pad(body)
+	total := uint32(12 + len(body))
+	b := order.AppendUint32(nil, blockType)
+	b = order.AppendUint32(b, total)
+	b = append(b, body...)
+	return order.AppendUint32(b, total)
+}
+
+// pad pads b with zeros to a multiple of 4 bytes
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ngOption encodes a pcapng option; code 0 ends the options
+func ngOption(order byteOrder, code uint16, value []byte) []byte {
+	b := order.AppendUint16(nil, code)
+	b = order.AppendUint16(b, uint16(len(value)))
+	return append(b, pad(value)...)
+}
+
+// ngSection encodes a section header block
+func ngSection(order byteOrder) []byte {
+	body := order.AppendUint32(nil, ngByteOrder)
+	body = order.AppendUint16(body, 1)
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint64(body, ^uint64(0)) // Unknown section length
+	return ngBlock(order, magicNG, body)
+}
+
+// ngInterface encodes an interface description block with options
+func ngInterface(order byteOrder, linkType uint16, options ...[]byte) []byte {
+	body := order.AppendUint16(nil, linkType)
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint32(body, maxSnapLen)
+	for _, option := range options {
+		body = append(body, option...)
+	}
+	return ngBlock(order, blockInterface, body)
+}
+
+// ngEnhanced encodes an enhanced packet block with options
+func ngEnhanced(order byteOrder, iface uint32, ticks uint64, data []byte, length uint32, options ...[]byte) []byte {
+	body := order.AppendUint32(nil, iface)
+	body = order.AppendUint32(body, uint32(ticks>>32))
+	body = order.AppendUint32(body, uint32(ticks))
+	body = order.AppendUint32(body, uint32(len(data)))
+	body = order.AppendUint32(body, length)
+	body = append(body, pad(append([]byte{}, data...))...)
+	for _, option := range options {
+		body = append(body, option...)
+	}
+	return ngBlock(order, blockEnhanced, body)
+}
+
+// ngSimple encodes a simple packet block
+func ngSimple(order byteOrder, data []byte, length uint32) []byte {
+	body := order.AppendUint32(nil, length)
+	return ngBlock(order, blockSimple, append(body, data...))
+}
+
+// readAll reads every packet of a capture, returning the error that ended it
+func readAll(data []byte) ([]packet, error) {
+	rd, err := newReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var packets []packet
+	for {
+		p, err := rd.next()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, p)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestNextBlock(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	frame := []byte("frame data")
+	nanos := ngOption(le, 9, []byte{9})
+	ifName := ngOption(le, 2, []byte("eth0"))
+	end := ngOption(le, 0, nil)
+	comment := ngOption(le, 1, []byte("a comment"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []packet
+		wantErr string
+	}{
+		{
+			name: "enhanced packet with microsecond default",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet), ngEnhanced(le, 0, 1_500_000, frame, 60)),
+			want: []packet{{timestamp: time.Unix(1, 500_000_000).UTC(), linkType: linkEthernet, data: frame, length: 60}},
+		},
+		{
+			name: "interface options before if_tsresol",
+			data: concat(ngSection(le), ngInterface(le, linkRaw, ifName, nanos, end), ngEnhanced(le, 0, 2_000_000_123, frame, 10)),
+			want: []packet{{timestamp: time.Unix(2, 123).UTC(), linkType: linkRaw, data: frame, length: 10}},
+		},
+		{
+			name: "binary if_tsresol",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet, ngOption(le, 9, []byte{0x80 | 10}), end), ngEnhanced(le, 0, 3*1024+512, frame, 10)),
+			want: []packet{{timestamp: time.Unix(3, 500_000_000).UTC(), linkType: linkEthernet, data: frame, length: 10}},
+		},
+		{
+			name: "enhanced packet options are not data",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet), ngEnhanced(le, 0, 0, frame[:3], 3, comment, end)),
+			want: []packet{{timestamp: time.Unix(0, 0).UTC(), linkType: linkEthernet, data: frame[:3], length: 3}},
+		},
+		{
+			name: "packets on the second interface",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet), ngInterface(le, linkSLL, nanos, end), ngEnhanced(le, 1, 7, frame, 10)),
+			want: []packet{{timestamp: time.Unix(0, 7).UTC(), linkType: linkSLL, data: frame, length: 10}},
+		},
+		{
+			name: "simple packet trimmed to its length",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet), ngSimple(le, frame[:5], 5)),
+			want: []packet{{linkType: linkEthernet, data: frame[:5], length: 5}},
+		},
+		{
+			name: "simple packet longer on the wire",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet), ngSimple(le, frame[:4], 1500)),
+			want: []packet{{linkType: linkEthernet, data: frame[:4], length: 1500}},
+		},
+		{
+			name: "big endian section",
+			data: concat(ngSection(be), ngInterface(be, linkEthernet, ngOption(be, 9, []byte{6}), ngOption(be, 0, nil)), ngEnhanced(be, 0, 1_000_001, frame, 10)),
+			want: []packet{{timestamp: time.Unix(1, 1000).UTC(), linkType: linkEthernet, data: frame, length: 10}},
+		},
+		{
+			name:    "new section resets the interfaces",
+			data:    concat(ngSection(le), ngInterface(le, linkEthernet), ngSection(be), ngEnhanced(be, 0, 0, frame, 10)),
+			wantErr: "undescribed interface 0",
+		},
+		{
+			name: "unknown blocks are skipped",
+			data: concat(ngSection(le), ngBlock(le, 0x00000005, []byte("statistics")), ngInterface(le, linkEthernet), ngSimple(le, frame, 10)),
+			want: []packet{{linkType: linkEthernet, data: frame, length: 10}},
+		},
+		{
+			name:    "packet before any interface",
+			data:    concat(ngSection(le), ngEnhanced(le, 0, 0, frame, 10)),
+			wantErr: "undescribed interface",
+		},
+		{
+			name:    "simple packet before any interface",
+			data:    concat(ngSection(le), ngSimple(le, frame, 10)),
+			wantErr: "invalid simple packet block",
+		},
+		{
+			name:    "truncated interface description",
+			data:    concat(ngSection(le), ngBlock(le, blockInterface, []byte{1, 0, 0, 0})),
+			wantErr: "truncated interface description block",
+		},
+		{
+			name:    "truncated enhanced packet",
+			data:    concat(ngSection(le), ngInterface(le, linkEthernet), ngBlock(le, blockEnhanced, make([]byte, 16))),
+			wantErr: "truncated enhanced packet block",
+		},
+		{
+			name: "captured length past the block",
+			data: concat(ngSection(le), ngInterface(le, linkEthernet),
+				ngBlock(le, blockEnhanced, concat(make([]byte, 12), le.AppendUint32(nil, 64), le.AppendUint32(nil, 64), frame[:4]))),
+			wantErr: "shorter than its packet",
+		},
+		{
+			name:    "block length too short",
+			data:    concat(ngSection(le), le.AppendUint32(nil, blockEnhanced), le.AppendUint32(nil, 8)),
+			wantErr: "invalid pcapng block length 8",
+		},
+		{
+			name:    "block length not aligned",
+			data:    concat(ngSection(le), le.AppendUint32(nil, blockEnhanced), le.AppendUint32(nil, 13), make([]byte, 5)),
+			wantErr: "invalid pcapng block length 13",
+		},
+		{
+			name:    "block length too large",
+			data:    concat(ngSection(le), le.AppendUint32(nil, blockEnhanced), le.AppendUint32(nil, 1<<30)),
+			wantErr: "invalid pcapng block length",
+		},
+		{
+			name:    "file ends inside a block",
+			data:    concat(ngSection(le), ngInterface(le, linkEthernet), ngEnhanced(le, 0, 0, frame, 10)[:30]),
+			wantErr: "truncated pcapng block",
+		},
+		{
+			name:    "file ends inside a block header",
+			data:    concat(ngSection(le), ngInterface(le, linkEthernet), []byte{6, 0}),
+			wantErr: "truncated pcapng block",
+		},
+		{
+			name:    "section header without byte order",
+			data:    []byte{0x0a, 0x0d, 0x0d, 0x0a, 28, 0, 0, 0},
+			wantErr: "truncated section header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets, err := readAll(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("reading error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading error = %v", err)
+			}
+			comparePackets(t, packets, tt.want)
+		})
+	}
+}
+
+func TestClassicReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := time.Unix(1700000000, 123456789).UTC(), time.Unix(1700000001, 0).UTC()
+	w.WritePacket(first, []byte("first"), 0)
+	w.WritePacket(second, []byte("second"), 1514)
+	written := buf.Bytes()
+
+	// A big endian file with microsecond timestamps
+	micros := concat(
+		binary.BigEndian.AppendUint32(nil, magicMicros), []byte{0, 2, 0, 4}, make([]byte, 12), binary.BigEndian.AppendUint32(nil, linkRaw),
+		binary.BigEndian.AppendUint32(nil, 5), binary.BigEndian.AppendUint32(nil, 250), binary.BigEndian.AppendUint32(nil, 3), binary.BigEndian.AppendUint32(nil, 3), []byte("abc"),
+	)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []packet
+		wantErr string
+	}{
+		{
+			name: "written file",
+			data: written,
+			want: []packet{
+				{timestamp: first, linkType: linkEthernet, data: []byte("first"), length: 5},
+				{timestamp: second, linkType: linkEthernet, data: []byte("second"), length: 1514},
+			},
+		},
+		{
+			name: "big endian microseconds",
+			data: micros,
+			want: []packet{{timestamp: time.Unix(5, 250_000).UTC(), linkType: linkRaw, data: []byte("abc"), length: 3}},
+		},
+		{name: "header only", data: written[:24]},
+		{name: "truncated header", data: written[:20], wantErr: "truncated pcap header"},
+		{name: "truncated record", data: written[:30], wantErr: "truncated packet record"},
+		{name: "truncated data", data: written[:24+16+2], wantErr: "truncated packet data"},
+		{name: "record without data", data: written[:24+16], wantErr: "truncated packet data"},
+		{
+			name:    "oversized packet",
+			data:    concat(written[:24], make([]byte, 8), binary.LittleEndian.AppendUint32(nil, maxSnapLen+1), make([]byte, 4)),
+			wantErr: "exceeds the",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets, err := readAll(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("reading error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading error = %v", err)
+			}
+			comparePackets(t, packets, tt.want)
+		})
+	}
+}
+
+func TestNewReaderUnknownFormat(t *testing.T) {
+	for _, data := range []string{"", "abc", "not a capture file", "\xd4\xc3\xb2"} {
+		if _, err := newReader(strings.NewReader(data)); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("newReader(%q) error = %v, want ErrUnknownFormat", data, err)
+		}
+	}
+}
+
+func TestResolution(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name    string
+		options []byte
+		want    uint64
+	}{
+		{"none", nil, 1_000_000},
+		{"nanoseconds", ngOption(le, 9, []byte{9}), 1_000_000_000},
+		{"power of two", ngOption(le, 9, []byte{0x80 | 20}), 1 << 20},
+		{"after other options", concat(ngOption(le, 2, []byte("eth0")), ngOption(le, 3, []byte("uplink port")), ngOption(le, 9, []byte{3})), 1000},
+		{"after end of options", concat(ngOption(le, 0, nil), ngOption(le, 9, []byte{9})), 1_000_000},
+		{"decimal out of range", ngOption(le, 9, []byte{20}), 1_000_000},
+		{"binary out of range", ngOption(le, 9, []byte{0x80 | 64}), 1_000_000},
+		{"empty value", ngOption(le, 9, nil), 1_000_000},
+		{"option past the block", ngOption(le, 9, []byte{9})[:4], 1_000_000},
+		{"short option header", []byte{9, 0}, 1_000_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := &reader{order: le}
+			if got := rd.resolution(tt.options); got != tt.want {
+				t.Errorf("resolution() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func comparePackets(t *testing.T, got, want []packet) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("read %d packets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.timestamp.Equal(w.timestamp) || g.linkType != w.linkType || !bytes.Equal(g.data, w.data) || g.length != w.length {
+			t.Errorf("packet %d = {%v %d %q %d}, want {%v %d %q %d}", i, g.timestamp, g.linkType, g.data, g.length, w.timestamp, w.linkType, w.data, w.length)
+		}
+	}
+}
+
+func FuzzAnalyze(f *testing.F) {
+	le := binary.LittleEndian
+	var classic bytes.Buffer
+	w, _ := NewWriter(&classic, LinkTypeEthernet)
+	w.WritePacket(time.Unix(1, 0), ethernet(etherIPv4, ipv4(protoTCP, tcp(1234, 80, flagSYN, nil))), 0)
+	w.WritePacket(time.Unix(2, 0), ethernet(etherIPv4, ipv4(protoUDP, udp(5353, 53, []byte("query")))), 0)
+	f.Add(classic.Bytes())
+	f.Add(concat(ngSection(le), ngInterface(le, linkEthernet, ngOption(le, 9, []byte{9}), ngOption(le, 0, nil)),
+		ngEnhanced(le, 0, 1, ethernet(etherIPv6, ipv6(protoTCP, tcp(443, 5555, flagSYN|flagACK, nil))), 74),
+		ngSimple(le, ethernet(etherVLAN, []byte{0, 1, 0x08, 0}), 4)))
+	f.Add(concat(ngSection(binary.BigEndian), ngInterface(binary.BigEndian, linkRaw)))
+	f.Add([]byte{0x0a, 0x0d, 0x0d, 0x0a})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		report, err := Analyze(bytes.NewReader(data), 10)
+		if err != nil {
+			return
+		}
+		if report.Skipped > report.Packets || len(report.TopTalkers) > 10 {
+			t.Fatalf("inconsistent report of %d packets: %d skipped, %d top talkers", report.Packets, report.Skipped, len(report.TopTalkers))
+		}
+	})
+}
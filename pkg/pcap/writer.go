@@ -0,0 +1,50 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeEthernet is the link type of Ethernet frames, for writers
+const LinkTypeEthernet = linkEthernet
+
+// Writer writes packets as a classic pcap file with nanosecond timestamps
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the file header for frames of linkType to w
+func NewWriter(w io.Writer, linkType int) (*Writer, error) {
+	var header [24]byte
+	binary.LittleEndian.PutUint32(header[0:], magicNanos)
+	binary.LittleEndian.PutUint16(header[4:], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], maxSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], uint32(linkType))
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WritePacket writes one frame captured at timestamp. Length is the size
+// of the frame on the wire, 0 when data holds all of it.
+func (w *Writer) WritePacket(timestamp time.Time, data []byte, length int) error {
+	if len(data) > maxSnapLen {
+		data = data[:maxSnapLen]
+	}
+	if length < len(data) {
+		length = len(data)
+	}
+	var record [16]byte
+	binary.LittleEndian.PutUint32(record[0:], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(timestamp.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(length))
+	if _, err := w.w.Write(record[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
@@ -1,35 +1,67 @@
 package pkg
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/cksidharthan/net-tools/pkg/extract"
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/proxyproto"
+	"github.com/cksidharthan/net-tools/pkg/tool"
 )
 
 // Default values for ping options
 const (
-	defaultCount      = 0    // 0 means ping continuously
-	defaultWait       = 1    // 1 second between pings
-	defaultTTL        = 64   // Default TTL value
-	defaultPacketSize = 56   // Default packet size in bytes
-	defaultTimeout    = 5    // 5 second timeout
-	defaultWaitTime   = 10   // 10 second wait time
-	defaultTOS        = 0    // Type of Service
-	defaultPreload    = 0    // Number of packets to preload
-	defaultSweepMin   = 0    // Minimum sweep size
-	defaultSweepMax   = 0    // Maximum sweep size
-	defaultSweepIncr  = 0    // Sweep increment size
-	websocketBuffer   = 1024 // WebSocket buffer size
+	defaultCount      = 0            // 0 means ping continuously
+	defaultWait       = time.Second  // 1 second between pings
+	defaultTTL        = 64           // Default TTL value
+	defaultPacketSize = 56           // Default packet size in bytes
+	defaultTimeout    = 5            // 5 second timeout
+	defaultWaitTime   = 10           // 10 second wait time
+	defaultTOS        = 0            // Type of Service
+	defaultPreload    = 0            // Number of packets to preload
+	defaultSweepMin   = 0            // Minimum sweep size
+	defaultSweepMax   = 0            // Maximum sweep size
+	defaultSweepIncr  = 0            // Sweep increment size
+	defaultProtocol   = protocolHTTP // Probe with HTTP GET requests
+	defaultTCPPort    = 80           // Port for TCP probes of addresses without one (UDP probes need one)
+	maxTargets        = 64           // Most targets one session can ping
 )
 
+// DefaultMinWait is the default shortest interval between pings, the floor
+// ping(8) applies to unprivileged users
+const DefaultMinWait = 200 * time.Millisecond
+
+// minWait is the shortest interval between pings clients may ask for
+var minWait = DefaultMinWait
+
+// SetMinWait sets the shortest interval between pings clients may ask for.
+// It must be called before any ping starts.
+func SetMinWait(wait time.Duration) {
+	minWait = wait
+}
+
 // PingMessage represents the incoming ping request with optional fields
 type PingMessage struct {
-	// Required
-	Address string `json:"address"` // The address to ping (IP or domain)
+	// Required unless targets is set
+	Address string `json:"address"` // The address to ping (IP, domain or CIDR network to sweep)
+
+	// Optional addresses to ping concurrently instead of address
+	Targets []string `json:"targets,omitempty"`
 
 	// Optional flags
 	Adaptive  *bool `json:"adaptive,omitempty"`  // Adaptive ping (-A)
@@ -40,39 +72,87 @@ type PingMessage struct {
 	Quiet     *bool `json:"quiet,omitempty"`     // Quiet output (-q)
 	Timestamp *bool `json:"timestamp,omitempty"` // Print timestamp (-D)
 	Verbose   *bool `json:"verbose,omitempty"`   // Verbose output (-v)
+	IPv4      *bool `json:"ipv4,omitempty"`      // Use IPv4 only (-4)
+	IPv6      *bool `json:"ipv6,omitempty"`      // Use IPv6 only (-6)
 
 	// Optional parameters with values
-	Count         *int    `json:"count,omitempty"`           // Number of pings to send (-c)
-	SweepMaxSize  *int    `json:"sweep_max_size,omitempty"`  // Maximum sweep size (-G)
-	SweepMinSize  *int    `json:"sweep_min_size,omitempty"`  // Minimum sweep size (-g)
-	SweepIncrSize *int    `json:"sweep_incr_size,omitempty"` // Sweep increment size (-h)
-	Wait          *int    `json:"wait,omitempty"`            // Interval between pings (-i)
-	Preload       *int    `json:"preload,omitempty"`         // Number of packets to preload (-l)
-	Mask          *string `json:"mask,omitempty"`            // Mask or time (-M)
-	TTL           *int    `json:"ttl,omitempty"`             // Time to live (-m)
-	Pattern       *string `json:"pattern,omitempty"`         // Pattern to fill packets (-p)
-	SourceAddr    *string `json:"source_addr,omitempty"`     // Source address (-S)
-	PacketSize    *int    `json:"packet_size,omitempty"`     // Packet size (-s)
-	Timeout       *int    `json:"timeout,omitempty"`         // Timeout (-t)
-	WaitTime      *int    `json:"wait_time,omitempty"`       // Wait time for responses (-W)
-	TOS           *int    `json:"tos,omitempty"`             // Type of Service (-z)
+	Count         *int           `json:"count,omitempty"`           // Number of pings to send (-c)
+	SweepMaxSize  *int           `json:"sweep_max_size,omitempty"`  // Maximum sweep size (-G)
+	SweepMinSize  *int           `json:"sweep_min_size,omitempty"`  // Minimum sweep size (-g)
+	SweepIncrSize *int           `json:"sweep_incr_size,omitempty"` // Sweep increment size (-h)
+	Wait          *tool.Duration `json:"wait,omitempty"`            // Interval between pings (-i) in seconds or as a duration string, e.g. "200ms"
+	Preload       *int           `json:"preload,omitempty"`         // Number of packets to preload (-l)
+	Mask          *string        `json:"mask,omitempty"`            // Mask or time (-M)
+	TTL           *int           `json:"ttl,omitempty"`             // Time to live (-m)
+	Pattern       *string        `json:"pattern,omitempty"`         // Pattern to fill packets (-p)
+	SourceAddr    *string        `json:"source_addr,omitempty"`     // Source address (-S)
+	PacketSize    *int           `json:"packet_size,omitempty"`     // Packet size (-s)
+	Timeout       *int           `json:"timeout,omitempty"`         // Timeout (-t)
+	WaitTime      *int           `json:"wait_time,omitempty"`       // Wait time for responses (-W)
+	TOS           *int           `json:"tos,omitempty"`             // Type of Service (-z)
+	Protocol      *string        `json:"protocol,omitempty"`        // Probe protocol ("http", "icmp", "tcp" or "udp")
+	Port          *int           `json:"port,omitempty"`            // Port for tcp and udp probes
+	EgressPool    *string        `json:"egress_pool,omitempty"`     // Named egress pool to pick the source address from
+	Format        *string        `json:"format,omitempty"`          // Output format ("json" or "text")
+	Shared        *bool          `json:"shared,omitempty"`          // Share one probe stream with other clients pinging the same target
+	Concurrency   *int           `json:"concurrency,omitempty"`     // Hosts probed at once when address is a CIDR network
+	ProxyProtocol *string        `json:"proxy_protocol,omitempty"`  // PROXY protocol header sent on tcp and http connections ("v1" or "v2")
+	ProxySource   *string        `json:"proxy_source,omitempty"`    // Client address:port claimed by the PROXY header, the connection's own by default
+
+	// Optional mutual TLS client certificate for https:// addresses
+	ClientCert *tool.ClientCertificate `json:"client_cert,omitempty"` // PEM certificate and key (-E)
+
+	// Optional regex or grok patterns whose named fields are extracted from HTTP bodies
+	Extract []string `json:"extract,omitempty"`
+
+	// Optional HTTP body change tracking
+	TrackChanges *bool    `json:"track_changes,omitempty"` // Hash each body and report when it changes
+	Normalize    []string `json:"normalize,omitempty"`     // Regexes whose matches are removed before hashing
 }
 
 // PongMessage represents the ping response with latency information
 type PongMessage struct {
-	Type      string    `json:"type"`      // Message type ("pong")
-	Timestamp time.Time `json:"timestamp"` // Time when the response was created
-	Bytes     int       `json:"bytes"`     // Number of bytes in the response
-	Sequence  int       `json:"sequence"`  // Sequence number of the ping
-	Address   string    `json:"address"`   // Address that was pinged
-	Latency   float64   `json:"latency"`   // Round-trip time in milliseconds
-	Success   bool      `json:"success"`   // Whether the ping was successful
+	Type      string         `json:"type"`               // Message type ("pong")
+	Timestamp time.Time      `json:"timestamp"`          // Time when the response was created
+	Bytes     int            `json:"bytes"`              // Number of bytes in the response
+	Sequence  int            `json:"sequence"`           // Sequence number of the ping
+	Address   string         `json:"address"`            // Address that was pinged
+	Latency   float64        `json:"latency"`            // Round-trip time in milliseconds
+	Success   bool           `json:"success"`            // Whether the ping was successful
+	Target    string         `json:"target,omitempty"`   // Entry of targets this pong belongs to
+	TTL       int            `json:"ttl,omitempty"`      // TTL or hop limit of the reply, for icmp and udp probes
+	TCPInfo   *probe.TCPInfo `json:"tcp_info,omitempty"` // Kernel TCP statistics of the connection, for tcp and http probes on Linux
+
+	Jitter    float64 `json:"jitter"`     // Rolling RFC 3550 style jitter in milliseconds
+	Loss      float64 `json:"loss"`       // Cumulative packet loss in percent
+	MovingAvg float64 `json:"moving_avg"` // Average of the latest round-trip times in milliseconds
+
+	Source     string                 `json:"source,omitempty"`      // Source address the probe was sent from
+	Port       int                    `json:"port,omitempty"`        // Port of a tcp or udp probe
+	Reply      string                 `json:"reply,omitempty"`       // How a udp probe was answered ("reply" or "port_unreachable")
+	Failure    string                 `json:"failure,omitempty"`     // Why a tcp or udp probe failed ("refused", "timeout" or "error")
+	ClientCert *tool.ClientAuthStatus `json:"client_cert,omitempty"` // Client certificate outcome when one was presented
+	Values     map[string]any         `json:"values,omitempty"`      // Values extracted from the response body
+	BodyHash   string                 `json:"body_hash,omitempty"`   // SHA-256 of the normalized response body
+}
+
+// ChangeMessage reports that the HTTP response body changed between pings
+type ChangeMessage struct {
+	Type         string    `json:"type"`             // Message type ("change")
+	Timestamp    time.Time `json:"timestamp"`        // Time the change was seen
+	Sequence     int       `json:"sequence"`         // Sequence number of the ping that saw the change
+	Address      string    `json:"address"`          // Address that was pinged
+	Target       string    `json:"target,omitempty"` // Entry of targets the change belongs to
+	PreviousHash string    `json:"previous_hash"`    // Hash of the previous body
+	Hash         string    `json:"hash"`             // Hash of the new body
+	Diff         []string  `json:"diff"`             // Line diff of the normalized bodies
 }
 
 // PingOptions contains the resolved ping options
 type PingOptions struct {
+	Targets       []string
 	Count         int
-	Wait          int
+	Wait          time.Duration
 	TTL           int
 	PacketSize    int
 	Timeout       int
@@ -82,9 +162,23 @@ type PingOptions struct {
 	SweepMinSize  int
 	SweepMaxSize  int
 	SweepIncrSize int
+	Port          int
+	Concurrency   int
+	Network       *net.IPNet
 	SourceAddr    string
+	SourceIP      net.IP
 	Pattern       string
 	Mask          string
+	Protocol      string
+	Family        string
+	Format        string
+	ProxyVersion  int
+	ProxySource   netip.AddrPort
+	ClientAuth    *tool.ClientAuth
+	Extractor     *extract.Extractor
+	Normalize     []*regexp.Regexp
+	IsShared      bool
+	TrackChanges  bool
 	IsAdaptive    bool
 	IsAudible     bool
 	IsDebug       bool
@@ -95,20 +189,18 @@ type PingOptions struct {
 	IsVerbose     bool
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  websocketBuffer,
-	WriteBufferSize: websocketBuffer,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// customIP reports whether the TTL or TOS differ from the defaults, which
+// are left to the kernel
+func (opts PingOptions) customIP() bool {
+	return opts.TTL != defaultTTL || opts.TOS != defaultTOS
 }
 
-// getOrDefault is a helper function to handle optional values
-func getOrDefault[T any](value *T, defaultValue T) T {
-	if value == nil {
-		return defaultValue
-	}
-	return *value
+// sweepPacketSize returns the payload size of probe sequence in a size sweep,
+// which steps from the minimum to the maximum size by the increment like
+// ping -g/-G/-h and then starts over
+func sweepPacketSize(opts PingOptions, sequence int) int {
+	steps := (opts.SweepMaxSize-opts.SweepMinSize)/opts.SweepIncrSize + 1
+	return opts.SweepMinSize + (sequence%steps)*opts.SweepIncrSize
 }
 
 // validatePingOptions validates and adjusts ping options if needed
@@ -116,8 +208,8 @@ func validatePingOptions(opts *PingOptions) error {
 	if opts.Count < 0 {
 		return fmt.Errorf("count cannot be negative")
 	}
-	if opts.Wait < 0 {
-		return fmt.Errorf("wait interval cannot be negative")
+	if opts.Wait < minWait {
+		return fmt.Errorf("wait interval must be at least %s", minWait)
 	}
 	if opts.TTL <= 0 || opts.TTL > 255 {
 		return fmt.Errorf("TTL must be between 1 and 255")
@@ -132,6 +224,9 @@ func validatePingOptions(opts *PingOptions) error {
 		if opts.SweepIncrSize <= 0 {
 			return fmt.Errorf("sweep increment size must be positive")
 		}
+		if opts.Protocol != protocolICMP && opts.Protocol != protocolUDP {
+			return fmt.Errorf("sweep requires the icmp or udp protocol")
+		}
 	}
 	if opts.Timeout <= 0 {
 		return fmt.Errorf("timeout must be positive")
@@ -142,65 +237,313 @@ func validatePingOptions(opts *PingOptions) error {
 	if opts.TOS < 0 || opts.TOS > 255 {
 		return fmt.Errorf("TOS must be between 0 and 255")
 	}
+	switch opts.Protocol {
+	case protocolHTTP, protocolICMP, protocolTCP, protocolUDP:
+	default:
+		return fmt.Errorf("unsupported protocol %q", opts.Protocol)
+	}
+	if opts.Port < 0 || opts.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if opts.Port != 0 && opts.Protocol != protocolTCP && opts.Protocol != protocolUDP {
+		return fmt.Errorf("port requires the tcp or udp protocol")
+	}
+	if err := tool.ValidateFormat(opts.Format); err != nil {
+		return err
+	}
 	return nil
 }
 
 // resolvePingOptions converts PingMessage to PingOptions with defaults
 func resolvePingOptions(msg *PingMessage) (PingOptions, error) {
 	opts := PingOptions{
-		Count:         getOrDefault(msg.Count, defaultCount),
-		Wait:          getOrDefault(msg.Wait, defaultWait),
-		TTL:           getOrDefault(msg.TTL, defaultTTL),
-		PacketSize:    getOrDefault(msg.PacketSize, defaultPacketSize),
-		Timeout:       getOrDefault(msg.Timeout, defaultTimeout),
-		WaitTime:      getOrDefault(msg.WaitTime, defaultWaitTime),
-		TOS:           getOrDefault(msg.TOS, defaultTOS),
-		SweepMinSize:  getOrDefault(msg.SweepMinSize, defaultSweepMin),
-		SweepMaxSize:  getOrDefault(msg.SweepMaxSize, defaultSweepMax),
-		SweepIncrSize: getOrDefault(msg.SweepIncrSize, defaultSweepIncr),
-		Preload:       getOrDefault(msg.Preload, defaultPreload),
-		SourceAddr:    getOrDefault(msg.SourceAddr, ""),
-		Pattern:       getOrDefault(msg.Pattern, ""),
-		Mask:          getOrDefault(msg.Mask, ""),
-		IsAdaptive:    getOrDefault(msg.Adaptive, false),
-		IsAudible:     getOrDefault(msg.Audible, false),
-		IsDebug:       getOrDefault(msg.Debug, false),
-		IsFlood:       getOrDefault(msg.Flood, false),
-		IsNumeric:     getOrDefault(msg.Numeric, false),
-		IsQuiet:       getOrDefault(msg.Quiet, false),
-		HasTimestamp:  getOrDefault(msg.Timestamp, false),
-		IsVerbose:     getOrDefault(msg.Verbose, false),
+		Count:         tool.GetOrDefault(msg.Count, defaultCount),
+		Wait:          time.Duration(tool.GetOrDefault(msg.Wait, tool.Duration(defaultWait))),
+		TTL:           tool.GetOrDefault(msg.TTL, defaultTTL),
+		PacketSize:    tool.GetOrDefault(msg.PacketSize, defaultPacketSize),
+		Timeout:       tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		WaitTime:      tool.GetOrDefault(msg.WaitTime, defaultWaitTime),
+		TOS:           tool.GetOrDefault(msg.TOS, defaultTOS),
+		SweepMinSize:  tool.GetOrDefault(msg.SweepMinSize, defaultSweepMin),
+		SweepMaxSize:  tool.GetOrDefault(msg.SweepMaxSize, defaultSweepMax),
+		SweepIncrSize: tool.GetOrDefault(msg.SweepIncrSize, defaultSweepIncr),
+		Preload:       tool.GetOrDefault(msg.Preload, defaultPreload),
+		Port:          tool.GetOrDefault(msg.Port, 0),
+		Concurrency:   tool.GetOrDefault(msg.Concurrency, defaultConcurrency),
+		SourceAddr:    tool.GetOrDefault(msg.SourceAddr, ""),
+		Pattern:       tool.GetOrDefault(msg.Pattern, ""),
+		Mask:          tool.GetOrDefault(msg.Mask, ""),
+		Protocol:      tool.GetOrDefault(msg.Protocol, defaultProtocol),
+		Family:        familyAny,
+		Format:        tool.GetOrDefault(msg.Format, tool.FormatJSON),
+		IsAdaptive:    tool.GetOrDefault(msg.Adaptive, false),
+		IsAudible:     tool.GetOrDefault(msg.Audible, false),
+		IsDebug:       tool.GetOrDefault(msg.Debug, false),
+		IsFlood:       tool.GetOrDefault(msg.Flood, false),
+		IsNumeric:     tool.GetOrDefault(msg.Numeric, false),
+		IsQuiet:       tool.GetOrDefault(msg.Quiet, false),
+		HasTimestamp:  tool.GetOrDefault(msg.Timestamp, false),
+		IsVerbose:     tool.GetOrDefault(msg.Verbose, false),
+		IsShared:      tool.GetOrDefault(msg.Shared, false),
+		TrackChanges:  tool.GetOrDefault(msg.TrackChanges, false),
 	}
 
 	if err := validatePingOptions(&opts); err != nil {
 		return opts, fmt.Errorf("invalid ping options: %w", err)
 	}
 
+	switch {
+	case len(msg.Targets) == 0:
+		opts.Targets = []string{msg.Address}
+	case msg.Address != "":
+		return opts, fmt.Errorf("invalid ping options: address and targets are mutually exclusive")
+	case len(msg.Targets) > maxTargets:
+		return opts, fmt.Errorf("invalid ping options: at most %d targets can be pinged at once", maxTargets)
+	default:
+		for _, target := range msg.Targets {
+			if target == "" {
+				return opts, fmt.Errorf("invalid ping options: targets cannot be empty")
+			}
+		}
+		opts.Targets = msg.Targets
+	}
+
+	switch ipv4, ipv6 := tool.GetOrDefault(msg.IPv4, false), tool.GetOrDefault(msg.IPv6, false); {
+	case ipv4 && ipv6:
+		return opts, fmt.Errorf("invalid ping options: ipv4 and ipv6 are mutually exclusive")
+	case ipv4:
+		opts.Family = familyIPv4
+	case ipv6:
+		opts.Family = familyIPv6
+	}
+
+	if opts.SourceAddr != "" {
+		if msg.EgressPool != nil {
+			return opts, fmt.Errorf("invalid ping options: source_addr and egress_pool are mutually exclusive")
+		}
+		if opts.SourceIP = net.ParseIP(opts.SourceAddr); opts.SourceIP == nil {
+			return opts, fmt.Errorf("invalid ping options: invalid source address %q", opts.SourceAddr)
+		}
+	} else {
+		source, err := tool.Egress.Pick(tool.GetOrDefault(msg.EgressPool, ""))
+		if err != nil {
+			return opts, fmt.Errorf("invalid ping options: %w", err)
+		}
+		opts.SourceIP = source
+	}
+	// A source address decides the family unless one was requested
+	if opts.SourceIP != nil {
+		family := familyIPv6
+		if opts.SourceIP.To4() != nil {
+			family = familyIPv4
+		}
+		if opts.Family != familyAny && opts.Family != family {
+			return opts, fmt.Errorf("invalid ping options: source address %s does not match the requested address family", opts.SourceIP)
+		}
+		opts.Family = family
+	}
+
+	clientAuth, err := tool.NewClientAuth(msg.ClientCert)
+	if err != nil {
+		return opts, fmt.Errorf("invalid ping options: %w", err)
+	}
+	if clientAuth != nil && opts.Protocol != protocolHTTP {
+		return opts, fmt.Errorf("invalid ping options: client certificate requires the http protocol")
+	}
+	opts.ClientAuth = clientAuth
+
+	extractor, err := extract.Compile(msg.Extract)
+	if err != nil {
+		return opts, fmt.Errorf("invalid ping options: %w", err)
+	}
+	if extractor != nil && opts.Protocol != protocolHTTP {
+		return opts, fmt.Errorf("invalid ping options: extract requires the http protocol")
+	}
+	opts.Extractor = extractor
+
+	for _, pattern := range msg.Normalize {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return opts, fmt.Errorf("invalid ping options: invalid normalize pattern %q: %w", pattern, err)
+		}
+		opts.Normalize = append(opts.Normalize, re)
+	}
+	if opts.TrackChanges && opts.Protocol != protocolHTTP {
+		return opts, fmt.Errorf("invalid ping options: track_changes requires the http protocol")
+	}
+
+	if msg.ProxyProtocol != nil {
+		if opts.Protocol != protocolTCP && opts.Protocol != protocolHTTP {
+			return opts, fmt.Errorf("invalid ping options: proxy_protocol requires the tcp or http protocol")
+		}
+		if opts.ProxyVersion, err = proxyproto.ParseVersion(*msg.ProxyProtocol); err != nil {
+			return opts, fmt.Errorf("invalid ping options: %w", err)
+		}
+	}
+	if msg.ProxySource != nil {
+		if opts.ProxyVersion == 0 {
+			return opts, fmt.Errorf("invalid ping options: proxy_source requires proxy_protocol")
+		}
+		if opts.ProxySource, err = netip.ParseAddrPort(*msg.ProxySource); err != nil {
+			return opts, fmt.Errorf("invalid ping options: invalid proxy_source %q, want address:port", *msg.ProxySource)
+		}
+	}
+
+	if opts.IsShared && (opts.SweepMaxSize > 0 || opts.Preload > 0 || opts.ClientAuth != nil || opts.Extractor != nil || opts.TrackChanges) {
+		return opts, fmt.Errorf("invalid ping options: shared mode does not support sweeps, preload, client certificates, extract or track_changes")
+	}
+
+	if _, network, err := net.ParseCIDR(msg.Address); err == nil {
+		if sweepSize(network).Cmp(big.NewInt(maxSweepHosts)) > 0 {
+			return opts, fmt.Errorf("invalid ping options: %s has more than %d hosts", network, maxSweepHosts)
+		}
+		if opts.IsShared || opts.Preload > 0 || opts.SweepMaxSize > 0 || opts.TrackChanges {
+			return opts, fmt.Errorf("invalid ping options: CIDR sweeps do not support shared mode, preload, size sweeps or track_changes")
+		}
+		if (opts.Family == familyIPv4) != (network.IP.To4() != nil) && opts.Family != familyAny {
+			return opts, fmt.Errorf("invalid ping options: %s does not match the requested address family", network)
+		}
+		opts.Network = network
+	}
+	// Malformed HTTP targets are refused up front rather than failing probe
+	// after probe
+	if opts.Protocol == protocolHTTP && opts.Network == nil {
+		for _, target := range opts.Targets {
+			if _, err := formatAddress(target); err != nil {
+				return opts, fmt.Errorf("invalid ping options: %w", err)
+			}
+		}
+	}
+	if opts.Concurrency <= 0 || opts.Concurrency > maxConcurrency {
+		return opts, fmt.Errorf("invalid ping options: concurrency must be between 1 and %d", maxConcurrency)
+	}
+
 	if opts.IsFlood {
-		opts.Wait = 1
+		opts.Wait = minWait
 	}
 
 	return opts, nil
 }
 
-// formatAddress ensures the address has the correct protocol prefix
-func formatAddress(addr string) string {
-	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
-		return "http://" + addr
+// checkDemoPing applies the limits of demo mode to a ping, which sends the
+// most probes allowed when it has no count
+func checkDemoPing(ctx context.Context, opts *PingOptions) error {
+	if !tool.Demo.Enabled() {
+		return nil
 	}
-	return addr
+	limits := tool.Demo.Limits()
+	if opts.Count == 0 {
+		opts.Count = limits.Count
+	}
+	switch {
+	case !tool.Demo.AllowCount(opts.Count):
+		return fmt.Errorf("count must be at most %d in demo mode", limits.Count)
+	case len(opts.Targets) > limits.Targets:
+		return fmt.Errorf("at most %d targets can be pinged in demo mode", limits.Targets)
+	case opts.IsFlood || opts.Preload > 0 || opts.SweepMaxSize > 0:
+		return fmt.Errorf("flood, preload and size sweeps are not available in demo mode")
+	}
+	for _, target := range opts.Targets {
+		if err := tool.Demo.CheckTarget(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostLabel matches one label of a DNS name. Underscores are accepted, as
+// they appear in service names.
+var hostLabel = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+
+// AddressError reports a ping address that cannot be turned into an HTTP URL
+type AddressError struct {
+	Address string // Address as given
+	Reason  string // What is wrong with it
 }
 
-// measureLatency performs the HTTP GET request and measures the round-trip time
-func measureLatency(client *http.Client, address string) (float64, error) {
-	startTime := time.Now()
-	resp, err := client.Get(address)
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid address %q: %s", e.Address, e.Reason)
+}
+
+// escapeZone escapes the % of the zone in a bracketed IPv6 literal, e.g.
+// "[fe80::1%eth0]", as URLs require
+func escapeZone(addr string) string {
+	open, end := strings.Index(addr, "["), strings.Index(addr, "]")
+	if open < 0 || end < open {
+		return addr
+	}
+	literal := addr[open:end]
+	if !strings.Contains(literal, "%") || strings.Contains(literal, "%25") {
+		return addr
+	}
+	return addr[:open] + strings.Replace(literal, "%", "%25", 1) + addr[end:]
+}
+
+// validHost reports whether host is an IPv4 address or a DNS name
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatAddress turns a ping address into the URL of HTTP probes. Addresses
+// without a scheme get http://, bare IPv6 literals are bracketed and zones
+// escaped, and userinfo is stripped. Addresses with another scheme, a bad
+// port or host, or that do not parse are rejected with an AddressError.
+func formatAddress(addr string) (string, error) {
+	raw := strings.TrimSpace(addr)
+	if raw == "" {
+		return "", &AddressError{Address: addr, Reason: "empty address"}
+	}
+	if !strings.Contains(raw, "://") {
+		if isIPv6Literal(raw) {
+			raw = "[" + raw + "]"
+		}
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(escapeZone(raw))
 	if err != nil {
-		return 0, err
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", &AddressError{Address: addr, Reason: err.Error()}
 	}
-	defer resp.Body.Close()
 
-	return float64(time.Since(startTime).Microseconds()) / 1000.0, nil
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", &AddressError{Address: addr, Reason: fmt.Sprintf("unsupported scheme %q, want http or https", u.Scheme)}
+	case u.Opaque != "" || u.Host == "":
+		return "", &AddressError{Address: addr, Reason: "missing host"}
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", &AddressError{Address: addr, Reason: fmt.Sprintf("port %s is not between 1 and 65535", port)}
+		}
+	}
+	host := u.Hostname()
+	if strings.HasPrefix(u.Host, "[") {
+		literal, _, _ := strings.Cut(host, "%")
+		if ip := net.ParseIP(literal); ip == nil || ip.To4() != nil {
+			return "", &AddressError{Address: addr, Reason: fmt.Sprintf("%s is not an IPv6 address", host)}
+		}
+	} else if !validHost(host) {
+		return "", &AddressError{Address: addr, Reason: fmt.Sprintf("invalid host %q", host)}
+	}
+	// Credentials would be sent as basic auth to every probed host
+	u.User = nil
+	return u.String(), nil
 }
 
 // createPongMessage creates a PongMessage with the given parameters
@@ -216,122 +559,427 @@ func createPongMessage(address string, sequence int, latency float64, success bo
 	}
 }
 
-// sendPongMessage sends the pong message through the websocket connection
-func sendPongMessage(conn *websocket.Conn, msg PongMessage) error {
-	if err := conn.WriteJSON(msg); err != nil {
+// sendPongMessage sends the pong message through the websocket session
+func sendPongMessage(session *tool.Session, msg PongMessage) error {
+	if err := session.WriteJSON(msg); err != nil {
 		return fmt.Errorf("error writing pong: %w", err)
 	}
 	return nil
 }
 
-// checkConnection verifies if the websocket connection is still alive
-func checkConnection(conn *websocket.Conn) error {
-	deadline := time.Now().Add(time.Second)
-	if err := conn.WriteControl(websocket.PingMessage, []byte{}, deadline); err != nil {
-		return fmt.Errorf("client disconnected: %w", err)
-	}
-	return nil
-}
-
-// logPingResult logs the ping result in the standard ping format
-func logPingResult(address string, sequence int, latency float64, success bool) {
+// formatPingResult formats a ping result in the standard ping format,
+// leaving out the TTL when it is not known
+func formatPingResult(address string, sequence, bytes, ttl int, latency float64, success bool) string {
 	if !success {
-		log.Printf("Request timeout for icmp_seq=%d", sequence)
-		return
+		return fmt.Sprintf("Request timeout for icmp_seq %d", sequence)
+	}
+	if ttl == 0 {
+		return fmt.Sprintf("%d bytes from %s: icmp_seq=%d time=%.3f ms", bytes, address, sequence, latency)
 	}
 
-	log.Printf("%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms",
-		defaultPacketSize,
+	return fmt.Sprintf("%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms",
+		bytes,
 		address,
 		sequence,
-		defaultTTL,
+		ttl,
 		latency,
 	)
 }
 
+// formatPortResult formats a tcp or udp probe result in the style of tcping
+func formatPortResult(protocol, address string, pong PongMessage) string {
+	switch {
+	case pong.Reply == udpPortUnreachable:
+		return fmt.Sprintf("Port unreachable from %s: udp_seq=%d time=%.3f ms", address, pong.Sequence, pong.Latency)
+	case pong.Reply == udpReply && pong.TTL > 0:
+		return fmt.Sprintf("Reply from %s: udp_seq=%d ttl=%d time=%.3f ms", address, pong.Sequence, pong.TTL, pong.Latency)
+	case pong.Reply == udpReply:
+		return fmt.Sprintf("Reply from %s: udp_seq=%d time=%.3f ms", address, pong.Sequence, pong.Latency)
+	case pong.Failure == "":
+		return fmt.Sprintf("Connected to %s: tcp_seq=%d time=%.3f ms", address, pong.Sequence, pong.Latency)
+	case pong.Failure == failureRefused:
+		return fmt.Sprintf("Connection refused by %s for tcp_seq %d", address, pong.Sequence)
+	case pong.Failure == failureTimeout:
+		return fmt.Sprintf("Request timeout for %s_seq %d", protocol, pong.Sequence)
+	default:
+		return fmt.Sprintf("Request failed for %s_seq %d", protocol, pong.Sequence)
+	}
+}
+
+// logPingResult logs the ping result in the standard ping format
+func logPingResult(address string, sequence int, latency float64, success bool) {
+	log.Print(formatPingResult(address, sequence, defaultPacketSize, 0, latency, success))
+}
+
 // PingHandler handles WebSocket ping requests
 func PingHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	session, err := tool.Upgrade(w, r, "ping")
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
 	}
-	defer conn.Close()
+	defer session.Close()
 
 	var pingMsg PingMessage
-	if err := conn.ReadJSON(&pingMsg); err != nil {
+	if err := session.ReadJSON(&pingMsg); err != nil {
 		log.Printf("Error reading ping message: %v", err)
 		return
 	}
 
+	session.SetVerbose(tool.GetOrDefault(pingMsg.Verbose, false))
 	opts, err := resolvePingOptions(&pingMsg)
 	if err != nil {
 		log.Printf("Invalid ping options: %v", err)
+		session.Log(tool.EventRejected, nil, "%v", err)
 		return
 	}
+	if opts.IsFlood {
+		session.Log(tool.EventClamped, map[string]any{"option": "wait", "value": minWait.String()}, "flood ping runs at the %s minimum wait", minWait)
+	}
+	count := opts.Count
+	if err := checkDemoPing(r.Context(), &opts); err != nil {
+		log.Printf("Refused demo ping: %v", err)
+		session.Log(tool.EventRejected, nil, "%v", err)
+		return
+	}
+	if opts.Count != count {
+		session.Log(tool.EventClamped, map[string]any{"option": "count", "requested": count, "value": opts.Count}, "continuous ping limited to %d probes in demo mode", opts.Count)
+	}
 
-	pingMsg.Address = formatAddress(pingMsg.Address)
-	log.Printf("PING %s (%s): %d data bytes", pingMsg.Address, pingMsg.Address, opts.PacketSize)
+	// Control messages are read while the ping runs, so a client can stop or
+	// adjust it without closing the socket
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	updates := make(chan ControlMessage)
+	session.Go(func() { readControls(ctx, session, cancel, updates) })
+
+	control := newPingControl(opts)
+	var wg sync.WaitGroup
+	for _, address := range opts.Targets {
+		run := &pingRun{session: session, opts: opts, control: control, address: address}
+		if len(opts.Targets) > 1 {
+			run.target = address
+		}
+		wg.Add(1)
+		session.Go(func() {
+			defer wg.Done()
+			run.run(ctx)
+		})
+	}
+	done := make(chan struct{})
+	session.Go(func() {
+		wg.Wait()
+		close(done)
+	})
+
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-updates:
+			if err := session.WriteJSON(control.apply(msg)); err != nil {
+				cancel(fmt.Errorf("error writing control acknowledgement: %w", err))
+			}
+		}
+	}
+}
+
+// pingRun pings one target of a ping session
+type pingRun struct {
+	session *tool.Session
+	opts    PingOptions
+	control *pingControl
+	address string // Address as requested
+	target  string // Target tag in sessions pinging several targets
+}
+
+// fail reports a target that could not be set up. Sessions pinging several
+// targets carry on with the others, so the error is sent as its summary.
+func (r *pingRun) fail(err error) {
+	if r.target == "" {
+		return
+	}
+	summary := SummaryMessage{Type: "summary", Timestamp: time.Now(), Address: r.address, Target: r.target, Error: err.Error()}
+	if err := r.session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
+
+// run pings the target until the count is reached, the client stops the
+// ping or ctx is canceled
+func (r *pingRun) run(ctx context.Context) {
+	if r.opts.Network != nil {
+		r.sweep(ctx)
+		return
+	}
 
-	client := &http.Client{
-		Timeout: time.Duration(opts.Timeout) * time.Second,
+	session, opts, address := r.session, r.opts, r.address
+	var (
+		p        prober
+		resolved string
+		results  <-chan probeResult
+		err      error
+	)
+	if opts.IsShared {
+		var unsubscribe func()
+		results, unsubscribe, resolved, err = sharedStreams.subscribe(opts, address)
+		if err != nil {
+			log.Printf("Failed to join shared probe stream: %v", err)
+			r.fail(err)
+			return
+		}
+		defer unsubscribe()
+	} else {
+		p, resolved, err = newProber(opts, address)
+		if err != nil {
+			log.Printf("Failed to create prober: %v", err)
+			r.fail(err)
+			return
+		}
+		defer p.close()
+	}
+
+	fields := map[string]any{"target": address, "resolved": resolved, "protocol": opts.Protocol}
+	if opts.SourceIP != nil {
+		fields["source"] = opts.SourceIP.String()
+	}
+	if opts.IsShared {
+		fields["shared"] = true
+	}
+	session.Log(tool.EventResolved, fields, "%s resolved to %s", address, resolved)
+
+	if opts.Protocol == protocolHTTP {
+		address = resolved
+	}
+	header := fmt.Sprintf("PING %s (%s): %d data bytes", address, resolved, opts.PacketSize)
+	if opts.Protocol == protocolTCP {
+		header = fmt.Sprintf("TCPING %s (%s)", address, resolved)
+	} else if opts.Protocol == protocolUDP {
+		header = fmt.Sprintf("UDPING %s (%s): %d data bytes", address, resolved, opts.PacketSize)
+	}
+	log.Print(header)
+	if opts.Format == tool.FormatText {
+		if err := session.WriteText(header); err != nil {
+			log.Printf("Failed to send text: %v", err)
+			return
+		}
 	}
 
-	ticker := time.NewTicker(time.Duration(opts.Wait) * time.Second)
+	ticker := time.NewTicker(opts.Wait)
 	defer ticker.Stop()
 
 	sequence := 0
+	var lastHash, lastBody string
+
+	// The footer is always logged; it is only sent when the run completes,
+	// as the client is gone when the loop exits early
+	stats := &pingStats{}
+	defer func() {
+		for _, line := range formatSummary(stats.summary(address)) {
+			log.Print(line)
+		}
+	}()
 
 	if opts.Preload > 0 {
 		for i := 0; i < opts.Preload; i++ {
-			go func() {
-				latency, err := measureLatency(client, pingMsg.Address)
+			session.CountProbe()
+			session.Go(func() {
+				latency, err := p.probe(-(i + 1), opts.PacketSize)
 				if err == nil {
-					logPingResult(pingMsg.Address, -1, latency, true)
+					logPingResult(address, -1, latency, true)
 				}
-			}()
+			})
 		}
 	}
 
-	for range ticker.C {
-		if opts.Count > 0 && sequence >= opts.Count {
+	ticks := ticker.C
+	if opts.IsShared {
+		ticks = nil
+	}
+
+pings:
+	for {
+		wait, count, changed := r.control.settings()
+		if count > 0 && sequence >= count {
 			break
 		}
+		if wait != opts.Wait && !opts.IsShared {
+			opts.Wait = wait
+			ticker.Reset(wait)
+		}
+
+		var latency float64
+		var ttl int
+		var info *probe.TCPInfo
+		select {
+		case <-ctx.Done():
+			if !errors.Is(context.Cause(ctx), errStopped) {
+				log.Printf("Ping of %s ended: %v", address, context.Cause(ctx))
+				return
+			}
+			break pings
+		case <-changed:
+			continue
+		case result := <-results:
+			latency, ttl, info, err = result.latency, result.ttl, result.tcpInfo, result.err
+		case <-ticks:
+		}
 		sequence++
 
 		currentPacketSize := opts.PacketSize
 		if opts.SweepMaxSize > 0 {
-			currentPacketSize = opts.SweepMinSize +
-				((sequence-1)*opts.SweepIncrSize)%
-					(opts.SweepMaxSize-opts.SweepMinSize+1)
+			currentPacketSize = sweepPacketSize(opts, sequence-1)
 		}
 
-		latency, err := measureLatency(client, pingMsg.Address)
+		if !opts.IsShared {
+			// Continuous pings are best effort and slow down under load
+			if count == 0 {
+				if delay := tool.Load.Backoff(opts.Wait); delay > 0 {
+					session.Log(tool.EventRateLimited, map[string]any{"sequence": sequence - 1, "delay": roundMs(float64(delay.Microseconds()) / 1000.0)}, "probe delayed by %s as the server is under load", delay)
+					select {
+					case <-ctx.Done():
+						// Handled at the top of the loop, which still sends
+						// the summary of stopped runs
+						continue
+					case <-time.After(delay):
+					}
+					ticker.Reset(opts.Wait)
+				}
+			}
+			session.CountProbe()
+			latency, err = p.probe(sequence-1, currentPacketSize)
+			if tp, ok := p.(ttlProber); ok && err == nil {
+				ttl = tp.replyTTL()
+			}
+			if tp, ok := p.(tcpInfoProber); ok && err == nil {
+				info = tp.tcpInfo()
+			}
+		}
 		success := err == nil
+		portUnreachable := errors.Is(err, errPortUnreachable)
+		if portUnreachable {
+			success = true
+		} else if err != nil {
+			session.Log(tool.EventProbeFailed, map[string]any{"sequence": sequence - 1, "error": err.Error()}, "probe %d failed: %v", sequence-1, err)
+		}
+
+		stats.add(latency, success)
 
-		pong := createPongMessage(pingMsg.Address, sequence-1, latency, success)
+		pong := createPongMessage(address, sequence-1, latency, success)
+		pong.Target = r.target
+		pong.Jitter = roundMs(stats.jitter)
+		pong.Loss = stats.loss()
+		pong.MovingAvg = stats.movingAvg()
 		pong.Bytes = currentPacketSize
+		pong.TTL = ttl
+		pong.TCPInfo = info
+		if opts.SourceIP != nil {
+			pong.Source = opts.SourceIP.String()
+		}
+		switch opts.Protocol {
+		case protocolTCP:
+			// A connect probe carries no payload
+			pong.Bytes = 0
+			pong.Port = targetPortOf(resolved)
+			if !success {
+				pong.Failure = probeFailure(err)
+			}
+		case protocolUDP:
+			pong.Port = targetPortOf(resolved)
+			switch {
+			case portUnreachable:
+				pong.Reply = udpPortUnreachable
+			case success:
+				pong.Reply = udpReply
+			default:
+				pong.Failure = probeFailure(err)
+			}
+		}
+		if opts.ClientAuth != nil {
+			status := opts.ClientAuth.Status(success)
+			pong.ClientCert = &status
+		}
+		if vp, ok := p.(valueProber); ok && success {
+			pong.Values = vp.values()
+		}
+		var change *ChangeMessage
+		if bp, ok := p.(bodyProber); ok && success && opts.TrackChanges {
+			body := bp.body()
+			sum := sha256.Sum256([]byte(body))
+			pong.BodyHash = hex.EncodeToString(sum[:])
+			if lastHash != "" && pong.BodyHash != lastHash {
+				change = &ChangeMessage{
+					Type:         "change",
+					Timestamp:    time.Now(),
+					Sequence:     pong.Sequence,
+					Address:      address,
+					Target:       r.target,
+					PreviousHash: lastHash,
+					Hash:         pong.BodyHash,
+					Diff:         diffLines(lastBody, body),
+				}
+			}
+			lastHash, lastBody = pong.BodyHash, body
+		}
 
 		if !opts.IsQuiet {
-			if err := sendPongMessage(conn, pong); err != nil {
+			if err := sendPongMessage(session, pong); err != nil {
 				log.Printf("Failed to send pong: %v", err)
 				return
 			}
+			if opts.Format == tool.FormatText {
+				// Classic ping counts the ICMP header in the reply size
+				bytes := pong.Bytes
+				if opts.Protocol == protocolICMP {
+					bytes += icmpHeaderSize
+				}
+				line := formatPingResult(resolved, pong.Sequence, bytes, pong.TTL, latency, success)
+				if opts.Protocol == protocolTCP || opts.Protocol == protocolUDP {
+					line = formatPortResult(opts.Protocol, resolved, pong)
+				}
+				if err := session.WriteText(line); err != nil {
+					log.Printf("Failed to send text: %v", err)
+					return
+				}
+			}
 		}
 
-		if !opts.IsQuiet {
-			logPingResult(pingMsg.Address, sequence-1, latency, success)
-		}
-
-		if !opts.IsFlood {
-			if err := checkConnection(conn); err != nil {
-				log.Printf("Connection check failed: %v", err)
+		if change != nil {
+			log.Printf("Content of %s changed at sequence %d", address, change.Sequence)
+			if err := session.WriteJSON(change); err != nil {
+				log.Printf("Failed to send change: %v", err)
 				return
 			}
+			if opts.Format == tool.FormatText {
+				line := fmt.Sprintf("Content changed at icmp_seq=%d (%.8s -> %.8s)", change.Sequence, change.PreviousHash, change.Hash)
+				if err := session.WriteText(line); err != nil {
+					log.Printf("Failed to send text: %v", err)
+					return
+				}
+			}
+		}
+
+		if !opts.IsQuiet {
+			logPingResult(address, sequence-1, latency, success)
 		}
 
 		if opts.IsFlood {
 			time.Sleep(time.Millisecond)
 		}
 	}
+
+	summary := stats.summary(address)
+	summary.Target = r.target
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+		return
+	}
+	if opts.Format == tool.FormatText {
+		for _, line := range formatSummary(summary) {
+			if err := session.WriteText(line); err != nil {
+				log.Printf("Failed to send text: %v", err)
+				return
+			}
+		}
+	}
 }
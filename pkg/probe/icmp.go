@@ -0,0 +1,290 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IANA protocol numbers for ICMP and ICMPv6, used when parsing replies
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// maxPacketSize is the receive buffer size, large enough for any IPv4 packet
+const maxPacketSize = 65535
+
+// ErrTimeout is returned when no matching reply arrives before the deadline
+var ErrTimeout = errors.New("request timeout")
+
+// DefaultPayloadSignature starts the payload of every echo request so target
+// operators can identify probes from this server
+const DefaultPayloadSignature = "net-tools"
+
+// payloadSignature is the signature written to the start of echo payloads
+var payloadSignature = []byte(DefaultPayloadSignature)
+
+// SetPayloadSignature sets the signature written to the start of echo
+// payloads. It must be called before any probe is sent.
+func SetPayloadSignature(signature string) {
+	payloadSignature = []byte(signature)
+}
+
+// Payload returns a probe payload of size bytes: the payload signature
+// followed by a counting byte pattern
+func Payload(size int) []byte {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	copy(payload, payloadSignature)
+	return payload
+}
+
+// PacketTooBigError is returned by Echo when a request with the DF bit set
+// does not fit the path, either because sending it failed locally or
+// because a router answered with fragmentation needed or packet too big
+type PacketTooBigError struct {
+	MTU  int    // Next hop MTU reported by the router, 0 when not known
+	From net.IP // Router that reported the error, nil when sending failed locally
+}
+
+func (e *PacketTooBigError) Error() string {
+	if e.From == nil {
+		return "packet too big for the local path MTU"
+	}
+	return fmt.Sprintf("packet too big for next hop MTU %d reported by %s", e.MTU, e.From)
+}
+
+// echoIDs hands out the echo IDs of raw sockets, starting from the pid. Raw
+// sockets see every echo reply the host receives, so each needs its own ID
+// to tell its replies from those of other sockets pinging the same address.
+var echoIDs atomic.Uint32
+
+func init() {
+	echoIDs.Store(uint32(os.Getpid()))
+}
+
+// nextEchoID returns the echo ID of a new socket
+func nextEchoID() int {
+	return int(echoIDs.Add(1) & 0xffff)
+}
+
+// errUnsupported is returned for socket options this platform cannot set
+var errUnsupported = errors.New("not supported on this platform")
+
+// ICMPConn is an ICMP echo socket. It prefers an unprivileged datagram socket
+// and falls back to a raw socket when those are not available.
+type ICMPConn struct {
+	mu         sync.Mutex
+	conn       net.PacketConn
+	ttl        *TTLConn
+	id         int
+	privileged bool
+	v6         bool
+}
+
+// ListenICMP opens an ICMP socket for sending echo requests. A non-nil
+// source sends them from that IPv4 address.
+func ListenICMP(source net.IP) (*ICMPConn, error) {
+	if source != nil && source.To4() == nil {
+		return nil, fmt.Errorf("ICMP source %s is not an IPv4 address", source)
+	}
+	return listenICMP(false, source)
+}
+
+// ListenICMPv6 opens an ICMPv6 socket for sending echo requests. A non-nil
+// source sends them from that IPv6 address.
+func ListenICMPv6(source net.IP) (*ICMPConn, error) {
+	if source != nil && source.To4() != nil {
+		return nil, fmt.Errorf("ICMPv6 source %s is not an IPv6 address", source)
+	}
+	return listenICMP(true, source)
+}
+
+// listenICMP opens an unprivileged ICMP or ICMPv6 socket, falling back to a
+// raw socket
+func listenICMP(v6 bool, source net.IP) (*ICMPConn, error) {
+	id := nextEchoID()
+	if conn, err := listenUnprivileged(v6, source); err == nil {
+		return &ICMPConn{conn: conn, ttl: NewTTLConn(conn, v6), id: id, v6: v6}, nil
+	}
+
+	network, local := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, local = "ip6:ipv6-icmp", "::"
+	}
+	if source != nil {
+		local = source.String()
+	}
+	conn, err := net.ListenPacket(network, local)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ICMP socket: %w", err)
+	}
+	return &ICMPConn{conn: conn, ttl: NewTTLConn(conn, v6), id: id, privileged: true, v6: v6}, nil
+}
+
+// SetDontFragment sets or clears the DF bit on outgoing packets
+func (c *ICMPConn) SetDontFragment(df bool) error {
+	return setDontFragment(c.conn, c.v6, df)
+}
+
+// BindToDevice sends and receives echo requests through the named interface
+// only, whatever the routing table says
+func (c *ICMPConn) BindToDevice(name string) error {
+	return bindToDevice(c.conn, name)
+}
+
+// SetTTL sets the TTL, or hop limit for ICMPv6, of outgoing packets
+func (c *ICMPConn) SetTTL(ttl int) error {
+	return c.ttl.SetTTL(ttl)
+}
+
+// SetTOS sets the TOS byte, or traffic class for ICMPv6, of outgoing packets
+func (c *ICMPConn) SetTOS(tos int) error {
+	return c.ttl.SetTOS(tos)
+}
+
+// destination converts an IP into the address type the socket expects
+func (c *ICMPConn) destination(dst *net.IPAddr) net.Addr {
+	if c.privileged {
+		return dst
+	}
+	return &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+}
+
+// Echo sends an echo request carrying size bytes of payload to dst and waits
+// up to timeout for the matching reply, returning the round-trip time and
+// the TTL the reply arrived with, 0 when the platform does not report it.
+// Requests that do not fit the path with the DF bit set fail with a
+// *PacketTooBigError. Routers' reports of them are only seen on raw sockets.
+func (c *ICMPConn) Echo(dst *net.IPAddr, sequence, size int, timeout time.Duration) (time.Duration, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payload := Payload(size)
+	var requestType icmp.Type = ipv4.ICMPTypeEcho
+	if c.v6 {
+		requestType = ipv6.ICMPTypeEchoRequest
+	}
+	// The kernel fills in the ICMPv6 checksum, which covers a pseudo header
+	request, err := (&icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: c.id, Seq: sequence & 0xffff, Data: payload},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := time.Now()
+	if _, err := c.conn.WriteTo(request, c.destination(dst)); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return 0, 0, &PacketTooBigError{}
+		}
+		return 0, 0, err
+	}
+	if err := c.conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return 0, 0, err
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, ttl, peer, err := c.ttl.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return 0, 0, ErrTimeout
+			}
+			return 0, 0, err
+		}
+		if tooBig := c.tooBig(buf[:n], peer, dst.IP, sequence); tooBig != nil {
+			return 0, 0, tooBig
+		}
+		if !c.matches(buf[:n], peer, dst.IP, sequence) {
+			continue
+		}
+		return time.Since(start), ttl, nil
+	}
+}
+
+// matches reports whether packet is the echo reply for our request
+func (c *ICMPConn) matches(packet []byte, peer net.Addr, ip net.IP, sequence int) bool {
+	var peerIP net.IP
+	switch addr := peer.(type) {
+	case *net.IPAddr:
+		peerIP = addr.IP
+	case *net.UDPAddr:
+		peerIP = addr.IP
+	}
+	if !peerIP.Equal(ip) {
+		return false
+	}
+
+	proto, replyType := protocolICMP, icmp.Type(ipv4.ICMPTypeEchoReply)
+	if c.v6 {
+		proto, replyType = protocolICMPv6, ipv6.ICMPTypeEchoReply
+	}
+	msg, err := icmp.ParseMessage(proto, packet)
+	if err != nil || msg.Type != replyType {
+		return false
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok || echo.Seq != sequence&0xffff {
+		return false
+	}
+	// The kernel rewrites the identifier of unprivileged echo sockets
+	return !c.privileged || echo.ID == c.id
+}
+
+// tooBig returns the error for packet if it reports that our request did
+// not fit the next hop, and nil otherwise
+func (c *ICMPConn) tooBig(packet []byte, peer net.Addr, ip net.IP, sequence int) *PacketTooBigError {
+	addr, ok := peer.(*net.IPAddr)
+	if !c.privileged || !ok || len(packet) < 8 {
+		return nil
+	}
+
+	// The error quotes the IP header of the request and the start of its
+	// echo header
+	var mtu int
+	var quoted []byte
+	switch {
+	case !c.v6 && packet[0] == byte(ipv4.ICMPTypeDestinationUnreachable) && packet[1] == 4:
+		mtu, quoted = int(binary.BigEndian.Uint16(packet[6:])), packet[8:]
+		if len(quoted) < 20 || quoted[0]>>4 != 4 || quoted[9] != protocolICMP {
+			return nil
+		}
+		headerLen := int(quoted[0]&0x0f) * 4
+		if len(quoted) < headerLen+8 || !net.IP(quoted[16:20]).Equal(ip) {
+			return nil
+		}
+		quoted = quoted[headerLen:]
+	case c.v6 && packet[0] == byte(ipv6.ICMPTypePacketTooBig):
+		mtu, quoted = int(binary.BigEndian.Uint32(packet[4:])), packet[8:]
+		if len(quoted) < 48 || quoted[6] != protocolICMPv6 || !net.IP(quoted[24:40]).Equal(ip) {
+			return nil
+		}
+		quoted = quoted[40:]
+	default:
+		return nil
+	}
+	if int(binary.BigEndian.Uint16(quoted[4:])) != c.id || int(binary.BigEndian.Uint16(quoted[6:])) != sequence&0xffff {
+		return nil
+	}
+	return &PacketTooBigError{MTU: mtu, From: addr.IP}
+}
+
+// Close closes the underlying socket
+func (c *ICMPConn) Close() error {
+	return c.conn.Close()
+}
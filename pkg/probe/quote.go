@@ -0,0 +1,227 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Kinds of ICMP messages answering a probe
+const (
+	QuoteTimeExceeded    = "time_exceeded"    // A router on the path dropped the probe when its TTL ran out
+	QuotePortUnreachable = "port_unreachable" // The destination itself answered a UDP probe
+	QuoteUnreachable     = "unreachable"      // A node reported the destination unreachable for another reason
+	QuoteEchoReply       = "echo_reply"       // The destination answered an echo request, quoting nothing
+)
+
+// ICMP and ICMPv6 types and codes that answer probes
+const (
+	icmpEchoReply                = 0
+	icmpDestinationUnreachable   = 3
+	icmpEchoRequest              = 8
+	icmpTimeExceeded             = 11
+	icmpPortUnreachable          = 3
+	icmpv6DestinationUnreachable = 1
+	icmpv6TimeExceeded           = 3
+	icmpv6PortUnreachable        = 4
+	icmpv6EchoRequest            = 128
+	icmpv6EchoReply              = 129
+	protocolTCP                  = 6
+	protocolUDP                  = 17
+)
+
+// Quote is an ICMP message answering a probe. Errors quote the probe's IP
+// header, which shows the probe as the reporting node received it.
+type Quote struct {
+	From    net.IP // Node that sent the ICMP message
+	Kind    string // Kind of message (time_exceeded, port_unreachable, unreachable, echo_reply)
+	TOS     int    // TOS byte or traffic class of the quoted header, 0 for echo replies
+	SrcPort int    // Source port of a quoted UDP or TCP header
+	DstPort int    // Destination port of a quoted UDP or TCP header
+	ID      int    // Identifier of a quoted echo request or of an echo reply
+	Seq     int    // Sequence number of a quoted echo request or of an echo reply
+
+	protocol int // IP protocol of the probe
+}
+
+// MatchUDP matches quotes of UDP probes sent from srcPort to dstPort
+func MatchUDP(srcPort, dstPort int) func(Quote) bool {
+	return func(q Quote) bool {
+		return q.protocol == protocolUDP && q.SrcPort == srcPort && q.DstPort == dstPort
+	}
+}
+
+// MatchTCP matches quotes of TCP probes sent from srcPort
+func MatchTCP(srcPort int) func(Quote) bool {
+	return func(q Quote) bool {
+		return q.protocol == protocolTCP && q.SrcPort == srcPort
+	}
+}
+
+// MatchEcho matches quotes of and replies to an echo request
+func MatchEcho(id, seq int) func(Quote) bool {
+	return func(q Quote) bool {
+		return (q.protocol == protocolICMP || q.protocol == protocolICMPv6) && q.ID == id && q.Seq == seq&0xffff
+	}
+}
+
+// QuoteConn reads the ICMP messages answering probes from a raw socket,
+// and sends echo requests for probing with ICMP
+type QuoteConn struct {
+	conn net.PacketConn
+	ttl  *TTLConn
+	v6   bool
+}
+
+// ListenQuotes opens a raw ICMP or ICMPv6 socket for reading the messages
+// that probes elicit
+func ListenQuotes(v6 bool) (*QuoteConn, error) {
+	network, local := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, local = "ip6:ipv6-icmp", "::"
+	}
+	conn, err := net.ListenPacket(network, local)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, ErrNotPrivileged
+		}
+		return nil, fmt.Errorf("error opening raw ICMP socket: %w", err)
+	}
+	return &QuoteConn{conn: conn, ttl: NewTTLConn(conn, v6), v6: v6}, nil
+}
+
+// SendEcho sends an echo request with the given TTL, or hop limit for
+// ICMPv6, carrying size bytes of payload
+func (c *QuoteConn) SendEcho(dst *net.IPAddr, id, sequence, ttl, size int) error {
+	if err := c.ttl.SetTTL(ttl); err != nil {
+		return err
+	}
+	var requestType icmp.Type = ipv4.ICMPTypeEcho
+	if c.v6 {
+		requestType = ipv6.ICMPTypeEchoRequest
+	}
+	// The kernel fills in the ICMPv6 checksum, which covers a pseudo header
+	request, err := (&icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: id, Seq: sequence & 0xffff, Data: Payload(size)},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.WriteTo(request, dst)
+	return err
+}
+
+// Read waits until deadline for an ICMP message that match accepts,
+// returning ErrTimeout when none arrives
+func (c *QuoteConn) Read(deadline time.Time, match func(Quote) bool) (Quote, error) {
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return Quote{}, err
+	}
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, peer, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return Quote{}, ErrTimeout
+			}
+			return Quote{}, err
+		}
+		quote, ok := c.parse(buf[:n])
+		if !ok || !match(quote) {
+			continue
+		}
+		if addr, ok := peer.(*net.IPAddr); ok {
+			quote.From = addr.IP
+		}
+		return quote, nil
+	}
+}
+
+// parse decodes an echo reply, or an ICMP error quoting an IP header
+// followed by the start of an echo request or of a UDP or TCP header
+func (c *QuoteConn) parse(packet []byte) (Quote, bool) {
+	if len(packet) < 8 {
+		return Quote{}, false
+	}
+	var quote Quote
+	icmpType, code, quoted := packet[0], packet[1], packet[8:]
+	echoReply, echoRequest, proto := icmpEchoReply, icmpEchoRequest, protocolICMP
+	if c.v6 {
+		echoReply, echoRequest, proto = icmpv6EchoReply, icmpv6EchoRequest, protocolICMPv6
+	}
+	if int(icmpType) == echoReply {
+		quote.Kind, quote.protocol = QuoteEchoReply, proto
+		quote.ID, quote.Seq = int(binary.BigEndian.Uint16(packet[4:])), int(binary.BigEndian.Uint16(packet[6:]))
+		return quote, true
+	}
+
+	if c.v6 {
+		switch {
+		case icmpType == icmpv6TimeExceeded:
+			quote.Kind = QuoteTimeExceeded
+		case icmpType == icmpv6DestinationUnreachable && code == icmpv6PortUnreachable:
+			quote.Kind = QuotePortUnreachable
+		case icmpType == icmpv6DestinationUnreachable:
+			quote.Kind = QuoteUnreachable
+		default:
+			return Quote{}, false
+		}
+		if len(quoted) < 40 || quoted[0]>>4 != 6 {
+			return Quote{}, false
+		}
+		quote.TOS = int(quoted[0]&0x0f)<<4 | int(quoted[1]>>4)
+		quote.protocol = int(quoted[6])
+		quoted = quoted[40:]
+	} else {
+		switch {
+		case icmpType == icmpTimeExceeded:
+			quote.Kind = QuoteTimeExceeded
+		case icmpType == icmpDestinationUnreachable && code == icmpPortUnreachable:
+			quote.Kind = QuotePortUnreachable
+		case icmpType == icmpDestinationUnreachable:
+			quote.Kind = QuoteUnreachable
+		default:
+			return Quote{}, false
+		}
+		if len(quoted) < 20 || quoted[0]>>4 != 4 {
+			return Quote{}, false
+		}
+		headerLen := int(quoted[0]&0x0f) * 4
+		if headerLen < 20 || len(quoted) < headerLen {
+			return Quote{}, false
+		}
+		quote.TOS = int(quoted[1])
+		quote.protocol = int(quoted[9])
+		quoted = quoted[headerLen:]
+	}
+
+	switch quote.protocol {
+	case protocolUDP, protocolTCP:
+		if len(quoted) < 4 {
+			return Quote{}, false
+		}
+		quote.SrcPort = int(binary.BigEndian.Uint16(quoted[0:]))
+		quote.DstPort = int(binary.BigEndian.Uint16(quoted[2:]))
+	case proto:
+		if len(quoted) < 8 || int(quoted[0]) != echoRequest {
+			return Quote{}, false
+		}
+		quote.ID, quote.Seq = int(binary.BigEndian.Uint16(quoted[4:])), int(binary.BigEndian.Uint16(quoted[6:]))
+	default:
+		return Quote{}, false
+	}
+	return quote, true
+}
+
+// Close closes the underlying socket
+func (c *QuoteConn) Close() error {
+	return c.conn.Close()
+}
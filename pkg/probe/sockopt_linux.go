@@ -0,0 +1,165 @@
+//go:build linux
+
+package probe
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenUnprivileged opens an ICMP or ICMPv6 datagram socket, which Linux
+// allows for groups listed in net.ipv4.ping_group_range without CAP_NET_RAW.
+// A non-nil source binds the socket to that address.
+func listenUnprivileged(v6 bool, source net.IP) (net.PacketConn, error) {
+	domain, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	if v6 {
+		domain, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if source != nil {
+		var sa syscall.Sockaddr
+		if v6 {
+			sa6 := &syscall.SockaddrInet6{}
+			copy(sa6.Addr[:], source.To16())
+			sa = sa6
+		} else {
+			sa4 := &syscall.SockaddrInet4{}
+			copy(sa4.Addr[:], source.To4())
+			sa = sa4
+		}
+		if err := syscall.Bind(fd, sa); err != nil {
+			syscall.Close(fd)
+			return nil, os.NewSyscallError("bind", err)
+		}
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+
+	return net.FilePacketConn(f)
+}
+
+// setDontFragment toggles path MTU discovery, which sets the DF bit and
+// makes oversized sends fail with EMSGSIZE instead of fragmenting locally
+func setDontFragment(conn net.PacketConn, v6, df bool) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	level, option, mode := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_WANT
+	if df {
+		mode = syscall.IP_PMTUDISC_DO
+	}
+	if v6 {
+		level, option, mode = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_WANT
+		if df {
+			mode = syscall.IPV6_PMTUDISC_DO
+		}
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, option, mode)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+// bindToDevice restricts a socket to sending and receiving through the
+// named interface
+func bindToDevice(conn net.PacketConn, name string) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.BindToDevice(int(fd), name)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+// setIPOptions sets the TTL, or hop limit, and the TOS, or traffic class, of
+// packets sent on a socket
+func setIPOptions(fd uintptr, v6 bool, ttl, tos int) error {
+	level, ttlOption, tosOption := syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_TOS
+	if v6 {
+		level, ttlOption, tosOption = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, syscall.IPV6_TCLASS
+	}
+	if err := syscall.SetsockoptInt(int(fd), level, ttlOption, ttl); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.SetsockoptInt(int(fd), level, tosOption, tos); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	return nil
+}
+
+// bindEphemeral binds a socket to the wildcard address and an ephemeral
+// port, and returns the port
+func bindEphemeral(fd uintptr, v6 bool) (int, error) {
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if v6 {
+		sa = &syscall.SockaddrInet6{}
+	}
+	if err := syscall.Bind(int(fd), sa); err != nil {
+		return 0, os.NewSyscallError("bind", err)
+	}
+	local, err := syscall.Getsockname(int(fd))
+	if err != nil {
+		return 0, os.NewSyscallError("getsockname", err)
+	}
+	switch local := local.(type) {
+	case *syscall.SockaddrInet4:
+		return local.Port, nil
+	case *syscall.SockaddrInet6:
+		return local.Port, nil
+	}
+	return 0, errUnsupported
+}
+
+// tcpInfo reads TCP_INFO from a TCP socket. Kernels older than 4.9 leave
+// the delivery rate at 0.
+func tcpInfo(fd uintptr) (*TCPInfo, error) {
+	info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockopt", err)
+	}
+	// Times are reported in microseconds
+	micros := func(v uint32) float64 {
+		return float64(v) / 1000.0
+	}
+	ssthresh := info.Snd_ssthresh
+	// The kernel reports "infinite" until the first loss ends slow start
+	if ssthresh >= 0x7fffffff {
+		ssthresh = 0
+	}
+	return &TCPInfo{
+		RTT:          micros(info.Rtt),
+		RTTVar:       micros(info.Rttvar),
+		MinRTT:       micros(info.Min_rtt),
+		Retransmits:  info.Total_retrans,
+		Lost:         info.Lost,
+		Cwnd:         info.Snd_cwnd,
+		SSThresh:     ssthresh,
+		MSS:          info.Snd_mss,
+		PMTU:         info.Pmtu,
+		DeliveryRate: info.Delivery_rate,
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package probe
+
+import "net"
+
+// listenUnprivileged is only implemented on Linux
+func listenUnprivileged(v6 bool, source net.IP) (net.PacketConn, error) {
+	return nil, errUnsupported
+}
+
+// setDontFragment is only implemented on Linux
+func setDontFragment(conn net.PacketConn, v6, df bool) error {
+	return errUnsupported
+}
+
+// bindToDevice is only implemented on Linux
+func bindToDevice(conn net.PacketConn, name string) error {
+	return errUnsupported
+}
+
+// setIPOptions is only implemented on Linux
+func setIPOptions(fd uintptr, v6 bool, ttl, tos int) error {
+	return errUnsupported
+}
+
+// bindEphemeral is only implemented on Linux
+func bindEphemeral(fd uintptr, v6 bool) (int, error) {
+	return 0, errUnsupported
+}
+
+// tcpInfo is only implemented on Linux
+func tcpInfo(fd uintptr) (*TCPInfo, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,46 @@
+package probe
+
+import (
+	"net"
+	"syscall"
+)
+
+// TCPInfo is the kernel's view of the path quality of a TCP connection,
+// read with the TCP_INFO socket option
+type TCPInfo struct {
+	RTT          float64 `json:"rtt"`           // Smoothed round-trip time in milliseconds
+	RTTVar       float64 `json:"rttvar"`        // Round-trip time variation in milliseconds
+	MinRTT       float64 `json:"min_rtt"`       // Lowest round-trip time seen in milliseconds
+	Retransmits  uint32  `json:"retransmits"`   // Segments retransmitted over the connection
+	Lost         uint32  `json:"lost"`          // Segments currently considered lost
+	Cwnd         uint32  `json:"cwnd"`          // Congestion window in segments
+	SSThresh     uint32  `json:"ssthresh"`      // Slow start threshold in segments, 0 while in slow start
+	MSS          uint32  `json:"mss"`           // Maximum segment size sent in bytes
+	PMTU         uint32  `json:"pmtu"`          // Path MTU in bytes
+	DeliveryRate uint64  `json:"delivery_rate"` // Most recent delivery rate in bytes per second
+}
+
+// ReadTCPInfo reads the TCP_INFO of a TCP connection, also when it is
+// wrapped in TLS. It returns an error on platforms other than Linux.
+func ReadTCPInfo(conn net.Conn) (*TCPInfo, error) {
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapped.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *TCPInfo
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		info, sockErr = tcpInfo(fd)
+	}); err != nil {
+		return nil, err
+	}
+	return info, sockErr
+}
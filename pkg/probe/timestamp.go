@@ -0,0 +1,134 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// msPerDay is the range of ICMP timestamps, which count milliseconds since midnight UTC
+const msPerDay = 24 * 60 * 60 * 1000
+
+// nonStandardTime is set in ICMP timestamps that are not milliseconds since
+// midnight UTC (RFC 792)
+const nonStandardTime = 1 << 31
+
+// ErrNotPrivileged is returned for probes that need a raw socket when only an
+// unprivileged echo socket is available
+var ErrNotPrivileged = errors.New("requires a raw ICMP socket (CAP_NET_RAW)")
+
+// TimestampReply holds the times of an ICMP timestamp exchange
+type TimestampReply struct {
+	Originate   uint32        // Our transmit time, ms since midnight UTC
+	Receive     uint32        // Remote receive time, ms since midnight UTC
+	Transmit    uint32        // Remote transmit time, ms since midnight UTC
+	Returned    uint32        // Our receive time, ms since midnight UTC
+	RTT         time.Duration // Round-trip time
+	NonStandard bool          // Remote clock does not follow the standard format
+}
+
+// ListenRawICMP opens a raw ICMP socket, needed for message types other than
+// echo which unprivileged sockets do not allow
+func ListenRawICMP() (*ICMPConn, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("error opening raw ICMP socket: %w", err)
+	}
+	return &ICMPConn{conn: conn, id: os.Getpid() & 0xffff, privileged: true}, nil
+}
+
+// millisOfDay returns t as milliseconds since midnight UTC
+func millisOfDay(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight).Milliseconds())
+}
+
+// Offset estimates the remote clock offset from the exchange, positive when
+// the remote clock is ahead
+func (r TimestampReply) Offset() time.Duration {
+	diff := func(a, b uint32) int64 {
+		d := (int64(a) - int64(b)) % msPerDay
+		if d > msPerDay/2 {
+			d -= msPerDay
+		} else if d < -msPerDay/2 {
+			d += msPerDay
+		}
+		return d
+	}
+	ms := (diff(r.Receive, r.Originate) + diff(r.Transmit, r.Returned)) / 2
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Timestamp sends an ICMP timestamp request to ip and waits up to timeout
+// for the reply
+func (c *ICMPConn) Timestamp(ip net.IP, sequence int, timeout time.Duration) (TimestampReply, error) {
+	var reply TimestampReply
+	if !c.privileged {
+		return reply, ErrNotPrivileged
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	reply.Originate = millisOfDay(start)
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint16(body[0:], uint16(c.id))
+	binary.BigEndian.PutUint16(body[2:], uint16(sequence))
+	binary.BigEndian.PutUint32(body[4:], reply.Originate)
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Body: &icmp.RawBody{Data: body},
+	}).Marshal(nil)
+	if err != nil {
+		return reply, err
+	}
+
+	if _, err := c.conn.WriteTo(request, c.destination(&net.IPAddr{IP: ip})); err != nil {
+		return reply, err
+	}
+	if err := c.conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return reply, err
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, peer, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return reply, ErrTimeout
+			}
+			return reply, err
+		}
+		now := time.Now()
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+		msg, err := icmp.ParseMessage(protocolICMP, buf[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+		raw, ok := msg.Body.(*icmp.RawBody)
+		if !ok || len(raw.Data) < 16 {
+			continue
+		}
+		data := raw.Data
+		if int(binary.BigEndian.Uint16(data[0:])) != c.id || int(binary.BigEndian.Uint16(data[2:])) != sequence&0xffff {
+			continue
+		}
+
+		reply.Receive = binary.BigEndian.Uint32(data[8:])
+		reply.Transmit = binary.BigEndian.Uint32(data[12:])
+		reply.Returned = millisOfDay(now)
+		reply.RTT = now.Sub(start)
+		reply.NonStandard = reply.Receive&nonStandardTime != 0 || reply.Transmit&nonStandardTime != 0
+		return reply, nil
+	}
+}
@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TTLConn sets the TTL and TOS of packets sent on a datagram socket and
+// reads replies together with the TTL or hop limit they arrived with
+type TTLConn struct {
+	conn net.PacketConn
+	p4   *ipv4.PacketConn
+	p6   *ipv6.PacketConn
+}
+
+// NewTTLConn wraps an IPv4 or IPv6 datagram or raw socket. Reply TTLs are
+// reported as 0 where the platform does not deliver them.
+func NewTTLConn(conn net.PacketConn, v6 bool) *TTLConn {
+	c := &TTLConn{conn: conn}
+	if v6 {
+		c.p6 = ipv6.NewPacketConn(conn)
+		c.p6.SetControlMessage(ipv6.FlagHopLimit, true)
+	} else {
+		c.p4 = ipv4.NewPacketConn(conn)
+		c.p4.SetControlMessage(ipv4.FlagTTL, true)
+	}
+	return c
+}
+
+// SetTTL sets the TTL, or the unicast hop limit for IPv6, of sent packets
+func (c *TTLConn) SetTTL(ttl int) error {
+	if c.p6 != nil {
+		return c.p6.SetHopLimit(ttl)
+	}
+	return c.p4.SetTTL(ttl)
+}
+
+// SetTOS sets the TOS byte, or the traffic class for IPv6, of sent packets.
+// Its upper six bits are the DSCP.
+func (c *TTLConn) SetTOS(tos int) error {
+	if c.p6 != nil {
+		return c.p6.SetTrafficClass(tos)
+	}
+	return c.p4.SetTOS(tos)
+}
+
+// SetDontFragment sets or clears the DF bit on sent packets
+func (c *TTLConn) SetDontFragment(df bool) error {
+	return setDontFragment(c.conn, c.p6 != nil, df)
+}
+
+// ReadFrom reads a packet and returns the TTL or hop limit it arrived with
+func (c *TTLConn) ReadFrom(b []byte) (n, ttl int, addr net.Addr, err error) {
+	if c.p6 != nil {
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = c.p6.ReadFrom(b)
+		if cm != nil {
+			ttl = cm.HopLimit
+		}
+		return n, ttl, addr, err
+	}
+	var cm *ipv4.ControlMessage
+	n, cm, addr, err = c.p4.ReadFrom(b)
+	if cm != nil {
+		ttl = cm.TTL
+	}
+	return n, ttl, addr, err
+}
+
+// DialControl returns a net.Dialer Control function that sets the TTL and
+// TOS of the connection's packets, for probes over TCP and UDP sockets
+func DialControl(ttl, tos int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		v6 := network == "tcp6" || network == "udp6"
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = setIPOptions(fd, v6, ttl, tos)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// DialTTL opens a TCP connection whose packets carry ttl. The socket is
+// bound before the handshake starts and bound is called with its local
+// port, so ICMP errors quoting the SYN can be matched while the dial is
+// still in progress.
+func DialTTL(network, address string, ttl int, timeout time.Duration, bound func(port int)) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			v6 := network == "tcp6"
+			var port int
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				if sockErr = setIPOptions(fd, v6, ttl, 0); sockErr == nil {
+					port, sockErr = bindEphemeral(fd, v6)
+				}
+			}); err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return sockErr
+			}
+			bound(port)
+			return nil
+		},
+	}
+	return dialer.Dial(network, address)
+}
@@ -0,0 +1,568 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/extract"
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/proxyproto"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Supported probe protocols
+const (
+	protocolHTTP = "http" // HTTP GET round trip
+	protocolICMP = "icmp" // ICMP echo request
+	protocolTCP  = "tcp"  // TCP connect handshake
+	protocolUDP  = "udp"  // UDP datagram
+)
+
+// Address families probes can be restricted to, named like the networks of
+// net.ResolveIPAddr
+const (
+	familyAny  = "ip"  // Whichever family the name resolves to first
+	familyIPv4 = "ip4" // IPv4 only (-4)
+	familyIPv6 = "ip6" // IPv6 only (-6)
+)
+
+// Reasons a TCP or UDP probe failed
+const (
+	failureRefused = "refused" // The port answered with a RST
+	failureTimeout = "timeout" // Nothing answered before the timeout
+	failureError   = "error"   // Any other failure, e.g. an unreachable network
+)
+
+// Kinds of answers to a UDP probe, both of which prove the host is reachable
+const (
+	udpReply           = "reply"            // The service answered with a datagram
+	udpPortUnreachable = "port_unreachable" // The host answered with ICMP port unreachable
+)
+
+// errPortUnreachable is returned by UDP probes answered with ICMP port
+// unreachable, which still confirms the host is reachable
+var errPortUnreachable = errors.New("port unreachable")
+
+// icmpHeaderSize is the size of an ICMP echo header in bytes
+const icmpHeaderSize = 8
+
+// maxPacketSize is the receive buffer size for UDP replies
+const maxPacketSize = 65535
+
+// maxExtractBody is the largest part of an HTTP body that is read for
+// extraction and change tracking
+const maxExtractBody = 1 << 20
+
+// prober measures the round-trip time of a single probe
+type prober interface {
+	// probe sends one probe with the given sequence number and payload size
+	// and returns the round-trip time in milliseconds
+	probe(sequence, size int) (float64, error)
+	// close releases any resources held by the prober
+	close() error
+}
+
+// valueProber is implemented by probers that extract values from replies
+type valueProber interface {
+	// values returns the values extracted from the latest reply
+	values() map[string]any
+}
+
+// bodyProber is implemented by probers that keep the latest reply body
+type bodyProber interface {
+	// body returns the normalized body of the latest reply
+	body() string
+}
+
+// ttlProber is implemented by probers that see the TTL of replies
+type ttlProber interface {
+	// replyTTL returns the TTL or hop limit of the latest reply, 0 when unknown
+	replyTTL() int
+}
+
+// tcpInfoProber is implemented by probers that measure over TCP connections
+type tcpInfoProber interface {
+	// tcpInfo returns the TCP_INFO of the latest probe's connection, nil
+	// when it could not be read
+	tcpInfo() *probe.TCPInfo
+}
+
+// httpProber measures latency with HTTP GET requests
+type httpProber struct {
+	client    *http.Client
+	address   string
+	extractor *extract.Extractor
+	normalize []*regexp.Regexp
+	readBody  bool
+
+	mu        sync.Mutex
+	extracted map[string]any
+	lastBody  string
+	info      *probe.TCPInfo
+}
+
+func (p *httpProber) probe(sequence, size int) (float64, error) {
+	req, err := http.NewRequest(http.MethodGet, p.address, nil)
+	if err != nil {
+		return 0, err
+	}
+	tool.Identify(req)
+	var conn net.Conn
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { conn = info.Conn },
+	}))
+
+	startTime := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	latency := float64(time.Since(startTime).Microseconds()) / 1000.0
+
+	if !p.readBody {
+		p.setTCPInfo(conn)
+		return latency, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExtractBody))
+	if err != nil {
+		return 0, err
+	}
+	p.setTCPInfo(conn)
+	text := string(body)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.extractor != nil {
+		p.extracted = p.extractor.Extract(text)
+	}
+	for _, re := range p.normalize {
+		text = re.ReplaceAllString(text, "")
+	}
+	p.lastBody = text
+	return latency, nil
+}
+
+func (p *httpProber) body() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastBody
+}
+
+func (p *httpProber) values() map[string]any {
+	if p.extractor == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.extracted
+}
+
+// setTCPInfo keeps the TCP_INFO of the connection the request used
+func (p *httpProber) setTCPInfo(conn net.Conn) {
+	var info *probe.TCPInfo
+	if conn != nil {
+		info, _ = probe.ReadTCPInfo(conn)
+	}
+	p.mu.Lock()
+	p.info = info
+	p.mu.Unlock()
+}
+
+func (p *httpProber) tcpInfo() *probe.TCPInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.info
+}
+
+func (p *httpProber) close() error {
+	return nil
+}
+
+// icmpProber measures latency with ICMP echo requests of the requested size
+type icmpProber struct {
+	conn    *probe.ICMPConn
+	dst     *net.IPAddr
+	timeout time.Duration
+
+	mu  sync.Mutex
+	ttl int
+}
+
+func (p *icmpProber) probe(sequence, size int) (float64, error) {
+	rtt, ttl, err := p.conn.Echo(p.dst, sequence, size, p.timeout)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	p.ttl = ttl
+	p.mu.Unlock()
+	return float64(rtt.Microseconds()) / 1000.0, nil
+}
+
+func (p *icmpProber) replyTTL() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ttl
+}
+
+func (p *icmpProber) close() error {
+	return p.conn.Close()
+}
+
+// tcpProber measures latency by timing TCP connect handshakes
+type tcpProber struct {
+	address      string
+	dialer       *net.Dialer
+	proxyVersion int            // PROXY protocol version sent after connecting, 0 for none
+	proxySource  netip.AddrPort // Client address claimed by the PROXY header
+
+	mu   sync.Mutex
+	info *probe.TCPInfo
+}
+
+func (p *tcpProber) probe(sequence, size int) (float64, error) {
+	startTime := time.Now()
+	conn, err := p.dialer.Dial("tcp", p.address)
+	if err != nil {
+		return 0, err
+	}
+	latency := float64(time.Since(startTime).Microseconds()) / 1000.0
+	if p.proxyVersion != 0 {
+		if err := sendProxyHeader(conn, p.proxyVersion, p.proxySource); err != nil {
+			conn.Close()
+			return 0, err
+		}
+	}
+	// The handshake is the only transfer, so the kernel's RTT is its sample
+	info, _ := probe.ReadTCPInfo(conn)
+	conn.Close()
+	p.mu.Lock()
+	p.info = info
+	p.mu.Unlock()
+	return latency, nil
+}
+
+func (p *tcpProber) tcpInfo() *probe.TCPInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.info
+}
+
+func (p *tcpProber) close() error {
+	return nil
+}
+
+// udpProber measures latency with UDP datagrams, counting both replies and
+// ICMP port unreachable errors as answers
+type udpProber struct {
+	conn    net.Conn
+	replies *probe.TTLConn
+	timeout time.Duration
+
+	mu  sync.Mutex
+	ttl int
+}
+
+func (p *udpProber) probe(sequence, size int) (float64, error) {
+	startTime := time.Now()
+	if _, err := p.conn.Write(probe.Payload(size)); err != nil {
+		return 0, err
+	}
+	if err := p.conn.SetReadDeadline(startTime.Add(p.timeout)); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, maxPacketSize)
+	_, ttl, _, err := p.replies.ReadFrom(buf)
+	latency := float64(time.Since(startTime).Microseconds()) / 1000.0
+	switch {
+	case err == nil:
+		p.mu.Lock()
+		p.ttl = ttl
+		p.mu.Unlock()
+		return latency, nil
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// The kernel reports ICMP port unreachable on connected UDP sockets
+		// as a refused read
+		return latency, errPortUnreachable
+	default:
+		return 0, err
+	}
+}
+
+func (p *udpProber) replyTTL() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ttl
+}
+
+func (p *udpProber) close() error {
+	return p.conn.Close()
+}
+
+// sendProxyHeader writes a PROXY protocol header for conn, claiming source
+// as the client or, when it is invalid, the connection's own address
+func sendProxyHeader(conn net.Conn, version int, source netip.AddrPort) error {
+	if !source.IsValid() {
+		source = conn.LocalAddr().(*net.TCPAddr).AddrPort()
+	}
+	header, err := proxyproto.Header{
+		Version:     version,
+		Command:     proxyproto.CommandProxy,
+		Source:      source,
+		Destination: conn.RemoteAddr().(*net.TCPAddr).AddrPort(),
+	}.Marshal()
+	if err != nil {
+		return fmt.Errorf("error encoding PROXY header: %w", err)
+	}
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("error sending PROXY header: %w", err)
+	}
+	return nil
+}
+
+// sourceDialer returns a dialer that connects from the source address of
+// opts, or from the address the kernel picks when there is none, and applies
+// any TTL and TOS set in opts. In demo mode it refuses addresses that are
+// not public.
+func sourceDialer(network string, opts PingOptions, timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if opts.customIP() {
+		dialer.Control = probe.DialControl(opts.TTL, opts.TOS)
+	}
+	dialer.Control = tool.Demo.Control(dialer.Control)
+	source := opts.SourceIP
+	if source != nil {
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: source}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: source}
+		}
+	}
+	return dialer
+}
+
+// probeFailure classifies a failed TCP or UDP probe
+func probeFailure(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failureRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return failureTimeout
+	default:
+		return failureError
+	}
+}
+
+// targetPort picks the port for a TCP or UDP probe: an explicit port wins,
+// then a port in the address, then the default port of an http:// or
+// https:// scheme, then fallback. A fallback of 0 makes the port required.
+func targetPort(address string, port, fallback int) (string, int, error) {
+	if port > 0 {
+		return hostFromAddress(address), port, nil
+	}
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		address = u.Host
+		port = 80
+		if u.Scheme == "https" {
+			port = 443
+		}
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		if port == 0 {
+			port = fallback
+		}
+		if port == 0 {
+			return "", 0, fmt.Errorf("address %q needs a port", address)
+		}
+		return strings.Trim(address, "[]"), port, nil
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in address %q", address)
+	}
+	return host, port, nil
+}
+
+// targetPortOf returns the port of a resolved tcp or udp probe target
+func targetPortOf(target string) int {
+	_, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+// hostFromAddress strips any scheme, path, port and brackets from a ping
+// address, keeping the zone of IPv6 literals
+func hostFromAddress(addr string) string {
+	if strings.Contains(addr, "://") {
+		if u, err := url.Parse(addr); err == nil {
+			return u.Hostname()
+		}
+	}
+	if isIPv6Literal(addr) {
+		return addr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// isIPv6Literal reports whether addr is a bare IPv6 address, optionally with
+// a zone such as fe80::1%eth0
+func isIPv6Literal(addr string) bool {
+	host, _, _ := strings.Cut(addr, "%")
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// familyNetwork narrows a network such as "tcp" to the address family
+// selected by family ("ip", "ip4" or "ip6")
+func familyNetwork(network, family string) string {
+	switch family {
+	case familyIPv4:
+		return network + "4"
+	case familyIPv6:
+		return network + "6"
+	default:
+		return network
+	}
+}
+
+// newProber creates the prober for the requested protocol and returns it
+// together with the resolved address being probed
+func newProber(opts PingOptions, address string) (prober, string, error) {
+	if tool.Simulation.Enabled() {
+		return newSimProber(opts, address)
+	}
+	timeout := time.Duration(opts.Timeout) * time.Second
+
+	switch opts.Protocol {
+	case protocolICMP:
+		ipAddr, err := net.ResolveIPAddr(opts.Family, hostFromAddress(address))
+		if err != nil {
+			return nil, "", fmt.Errorf("error resolving %s: %w", address, err)
+		}
+		if err := tool.Demo.CheckAddr(ipAddr.IP); err != nil {
+			return nil, "", err
+		}
+		listen := probe.ListenICMP
+		if ipAddr.IP.To4() == nil {
+			listen = probe.ListenICMPv6
+		}
+		conn, err := listen(opts.SourceIP)
+		if err != nil {
+			return nil, "", err
+		}
+		// Sweeps look for the size at which loss begins, so packets must not
+		// be fragmented along the way
+		if opts.SweepMaxSize > 0 {
+			if err := conn.SetDontFragment(true); err != nil {
+				conn.Close()
+				return nil, "", fmt.Errorf("error setting DF bit: %w", err)
+			}
+		}
+		if opts.customIP() {
+			if err := conn.SetTTL(opts.TTL); err != nil {
+				conn.Close()
+				return nil, "", fmt.Errorf("error setting TTL: %w", err)
+			}
+			if err := conn.SetTOS(opts.TOS); err != nil {
+				conn.Close()
+				return nil, "", fmt.Errorf("error setting TOS: %w", err)
+			}
+		}
+		return &icmpProber{conn: conn, dst: ipAddr, timeout: timeout}, ipAddr.String(), nil
+	case protocolTCP, protocolUDP:
+		fallback := defaultTCPPort
+		if opts.Protocol == protocolUDP {
+			fallback = 0
+		}
+		host, port, err := targetPort(address, opts.Port, fallback)
+		if err != nil {
+			return nil, "", err
+		}
+		ipAddr, err := net.ResolveIPAddr(opts.Family, host)
+		if err != nil {
+			return nil, "", fmt.Errorf("error resolving %s: %w", host, err)
+		}
+		if err := tool.Demo.CheckAddr(ipAddr.IP); err != nil {
+			return nil, "", err
+		}
+		target := net.JoinHostPort(ipAddr.String(), strconv.Itoa(port))
+		dialer := sourceDialer(opts.Protocol, opts, timeout)
+		if opts.Protocol == protocolTCP {
+			return &tcpProber{address: target, dialer: dialer, proxyVersion: opts.ProxyVersion, proxySource: opts.ProxySource}, target, nil
+		}
+		conn, err := dialer.Dial("udp", target)
+		if err != nil {
+			return nil, "", fmt.Errorf("error opening UDP socket: %w", err)
+		}
+		replies := probe.NewTTLConn(conn.(net.PacketConn), ipAddr.IP.To4() == nil)
+		if opts.SweepMaxSize > 0 {
+			if err := replies.SetDontFragment(true); err != nil {
+				conn.Close()
+				return nil, "", fmt.Errorf("error setting DF bit: %w", err)
+			}
+		}
+		return &udpProber{conn: conn, replies: replies, timeout: timeout}, target, nil
+	default:
+		address, err := formatAddress(address)
+		if err != nil {
+			return nil, "", err
+		}
+		client := &http.Client{Timeout: timeout}
+		if tool.Demo.Enabled() {
+			client.CheckRedirect = tool.Demo.CheckRedirect
+		}
+		// Demo mode checks the address each connection is made to, whatever
+		// the name resolved to when the request was checked
+		if tool.Demo.Enabled() || opts.ClientAuth != nil || opts.SourceIP != nil || opts.Family != familyAny || opts.customIP() || opts.ProxyVersion != 0 {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			if opts.ClientAuth != nil {
+				transport.TLSClientConfig = &tls.Config{}
+				opts.ClientAuth.Configure(transport.TLSClientConfig)
+			}
+			dialer := sourceDialer("tcp", opts, timeout)
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, familyNetwork("tcp", opts.Family), addr)
+				if err != nil || opts.ProxyVersion == 0 {
+					return conn, err
+				}
+				// The header precedes TLS, so it reaches the balancer's backend as is
+				if err := sendProxyHeader(conn, opts.ProxyVersion, opts.ProxySource); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return conn, nil
+			}
+			client.Transport = transport
+		}
+		return &httpProber{
+			client:    client,
+			address:   address,
+			extractor: opts.Extractor,
+			normalize: opts.Normalize,
+			readBody:  opts.Extractor != nil || opts.TrackChanges,
+		}, address, nil
+	}
+}
@@ -0,0 +1,125 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds the wait for the header of a new connection
+const headerTimeout = 10 * time.Second
+
+// Listener accepts connections whose peers, if in a trusted network, start
+// with a PROXY protocol header naming the real client. Connections from
+// other peers are passed on untouched.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+// NewListener wraps ln, expecting PROXY headers from peers in trusted
+func NewListener(ln net.Listener, trusted []netip.Prefix) *Listener {
+	return &Listener{Listener: ln, trusted: trusted}
+}
+
+// Accept waits for the next connection. Its header is read on first use,
+// so a slow peer does not hold up others.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	peer, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.trusts(peer.AddrPort().Addr().Unmap()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// trusts reports whether addr may send PROXY headers
+func (l *Listener) trusts(addr netip.Addr) bool {
+	for _, prefix := range l.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted peer. Its addresses are those of the
+// PROXY header, for PROXY commands, and it fails if the header is missing or
+// malformed.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once     sync.Once
+	header   Header
+	err      error
+	deadline time.Time // Read deadline set by the user of the connection
+	mu       sync.Mutex
+}
+
+// init reads the header, restoring the user's read deadline afterwards
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		c.header, c.err = Read(c.reader)
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.deadline)
+		c.mu.Unlock()
+	})
+}
+
+// Header returns the PROXY header of the connection
+func (c *Conn) Header() (Header, error) {
+	c.init()
+	return c.header, c.err
+}
+
+// Read reads data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the peer's
+// address for LOCAL commands
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.err == nil && c.header.Source.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to according to the
+// header, or the local address for LOCAL commands
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.err == nil && c.header.Destination.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Destination)
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
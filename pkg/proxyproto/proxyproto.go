@@ -0,0 +1,196 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol versions
+const (
+	V1 = 1 // Human readable header line
+	V2 = 2 // Binary header
+)
+
+// Commands of version 2 headers
+const (
+	CommandLocal = 0 // The connection was made by the proxy itself, e.g. a health check
+	CommandProxy = 1 // The connection is relayed on behalf of a client
+)
+
+// v1MaxLength is the longest version 1 header, including its CRLF
+const v1MaxLength = 107
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned when a connection does not start with a PROXY
+// protocol header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Header is a PROXY protocol header. Source and Destination are invalid for
+// LOCAL commands and version 1 UNKNOWN headers, whose connection addresses
+// stand.
+type Header struct {
+	Version     int
+	Command     int
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+// ParseVersion converts "v1" or "v2" to a PROXY protocol version
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	}
+	return 0, fmt.Errorf("unsupported PROXY protocol version %q, want v1 or v2", s)
+}
+
+// Marshal encodes the header of a TCP connection from source to
+// destination
+func (h Header) Marshal() ([]byte, error) {
+	src, dst := h.Source.Addr().Unmap(), h.Destination.Addr().Unmap()
+	if h.Command == CommandProxy && src.Is4() != dst.Is4() {
+		return nil, fmt.Errorf("source %s and destination %s are of different address families", src, dst)
+	}
+
+	if h.Version == V1 {
+		if h.Command != CommandProxy {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP4"
+		if !src.Is4() {
+			family = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src, dst, h.Source.Port(), h.Destination.Port())), nil
+	}
+
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|byte(h.Command))
+	if h.Command != CommandProxy {
+		return append(b, 0x00, 0, 0), nil
+	}
+	if src.Is4() {
+		b = append(b, 0x11) // TCP over IPv4
+		b = binary.BigEndian.AppendUint16(b, 12)
+		b = append(b, src.AsSlice()...)
+		b = append(b, dst.AsSlice()...)
+	} else {
+		b = append(b, 0x21) // TCP over IPv6
+		b = binary.BigEndian.AppendUint16(b, 36)
+		b = append(b, src.AsSlice()...)
+		b = append(b, dst.AsSlice()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, h.Source.Port())
+	return binary.BigEndian.AppendUint16(b, h.Destination.Port()), nil
+}
+
+// Read reads a version 1 or version 2 header from the start of a
+// connection
+func Read(r *bufio.Reader) (Header, error) {
+	start, err := r.Peek(5)
+	if err != nil {
+		return Header{}, err
+	}
+	switch {
+	case string(start) == "PROXY":
+		return readV1(r)
+	case bytes.Equal(start, v2Signature[:5]):
+		return readV2(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+// readV1 reads a header line such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (Header, error) {
+	h := Header{Version: V1, Command: CommandProxy}
+	var line []byte
+	for len(line) < v1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return h, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return h, errors.New("PROXY header line is too long or not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Command = CommandLocal
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return h, fmt.Errorf("malformed PROXY header %q", strings.TrimSpace(string(line)))
+	}
+	src, srcErr := netip.ParseAddr(fields[2])
+	dst, dstErr := netip.ParseAddr(fields[3])
+	srcPort, srcPortErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstPortErr := strconv.ParseUint(fields[5], 10, 16)
+	if err := errors.Join(srcErr, dstErr, srcPortErr, dstPortErr); err != nil {
+		return h, fmt.Errorf("malformed PROXY header: %w", err)
+	}
+	if src.Is4() != (fields[1] == "TCP4") || dst.Is4() != src.Is4() {
+		return h, fmt.Errorf("PROXY header addresses do not match %s", fields[1])
+	}
+	h.Source = netip.AddrPortFrom(src, uint16(srcPort))
+	h.Destination = netip.AddrPortFrom(dst, uint16(dstPort))
+	return h, nil
+}
+
+// readV2 reads a binary header. TLVs are skipped, as are the addresses of
+// families other than IPv4 and IPv6.
+func readV2(r *bufio.Reader) (Header, error) {
+	h := Header{Version: V2}
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return h, err
+	}
+	if !bytes.Equal(fixed[:12], v2Signature) {
+		return h, ErrNoHeader
+	}
+	if fixed[12]>>4 != 2 {
+		return h, fmt.Errorf("unsupported PROXY header version %d", fixed[12]>>4)
+	}
+	h.Command = int(fixed[12] & 0x0f)
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return h, fmt.Errorf("unsupported PROXY command %d", h.Command)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return h, err
+	}
+	if h.Command == CommandLocal {
+		return h, nil
+	}
+
+	switch fixed[13] >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return h, errors.New("truncated PROXY header addresses")
+		}
+		h.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[4:8])), binary.BigEndian.Uint16(body[10:]))
+	case 2:
+		if len(body) < 36 {
+			return h, errors.New("truncated PROXY header addresses")
+		}
+		h.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[0:16])), binary.BigEndian.Uint16(body[32:]))
+		h.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[16:32])), binary.BigEndian.Uint16(body[34:]))
+	}
+	return h, nil
+}
@@ -0,0 +1,257 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// readHeader reads a header from data and returns it with the bytes after it
+func readHeader(data []byte) (Header, string, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	h, err := Read(r)
+	rest, _ := io.ReadAll(r)
+	return h, string(rest), err
+}
+
+// v2Header builds a version 2 header with the raw version and command
+// byte, family byte and body
+func v2Header(command, family byte, body []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, command, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	v4Src, v4Dst := netip.MustParseAddrPort("192.0.2.1:56324"), netip.MustParseAddrPort("198.51.100.1:443")
+	v6Src, v6Dst := netip.MustParseAddrPort("[2001:db8::1]:56324"), netip.MustParseAddrPort("[2001:db8::2]:443")
+	mapped := netip.MustParseAddrPort("[::ffff:192.0.2.1]:56324")
+
+	tests := []struct {
+		name   string
+		header Header
+		wire   string // Expected encoding for version 1
+		want   Header // Header read back, the original when zero
+	}{
+		{name: "v1 tcp4", header: Header{Version: V1, Command: CommandProxy, Source: v4Src, Destination: v4Dst}, wire: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"},
+		{name: "v1 tcp6", header: Header{Version: V1, Command: CommandProxy, Source: v6Src, Destination: v6Dst}, wire: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"},
+		{name: "v1 unknown", header: Header{Version: V1, Command: CommandLocal}, wire: "PROXY UNKNOWN\r\n"},
+		{
+			name:   "v1 mapped source",
+			header: Header{Version: V1, Command: CommandProxy, Source: mapped, Destination: v4Dst},
+			wire:   "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+			want:   Header{Version: V1, Command: CommandProxy, Source: v4Src, Destination: v4Dst},
+		},
+		{name: "v2 ipv4", header: Header{Version: V2, Command: CommandProxy, Source: v4Src, Destination: v4Dst}},
+		{name: "v2 ipv6", header: Header{Version: V2, Command: CommandProxy, Source: v6Src, Destination: v6Dst}},
+		{name: "v2 local", header: Header{Version: V2, Command: CommandLocal}},
+		{
+			name:   "v2 mapped source",
+			header: Header{Version: V2, Command: CommandProxy, Source: mapped, Destination: v4Dst},
+			want:   Header{Version: V2, Command: CommandProxy, Source: v4Src, Destination: v4Dst},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire, err := tt.header.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if tt.wire != "" && string(wire) != tt.wire {
+				t.Errorf("Marshal() = %q, want %q", wire, tt.wire)
+			}
+			got, rest, err := readHeader(append(wire, "GET / HTTP/1.1\r\n"...))
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			want := tt.want
+			if want == (Header{}) {
+				want = tt.header
+			}
+			if got != want {
+				t.Errorf("Read() = %+v, want %+v", got, want)
+			}
+			if rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("data after the header = %q, want the request line", rest)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for s, want := range map[string]int{"v1": V1, "V2": V2, "1": V1, "2": V2, "v3": 0, "": 0} {
+		got, err := ParseVersion(s)
+		if got != want || (err != nil) != (want == 0) {
+			t.Errorf("ParseVersion(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+}
+
+func TestMarshalMixedFamilies(t *testing.T) {
+	h := Header{Version: V2, Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("[2001:db8::1]:2")}
+	if _, err := h.Marshal(); err == nil {
+		t.Error("Marshal() of mixed address families succeeded")
+	}
+}
+
+func TestRead(t *testing.T) {
+	v4Body := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	tlv := []byte{0x04, 0x00, 0x01, 0x00} // PP2_TYPE_NOOP with one byte
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Header
+		rest    string
+		wantErr string
+	}{
+		{name: "v1 unknown with addresses", data: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nrest"), want: Header{Version: V1, Command: CommandLocal}, rest: "rest"},
+		{name: "v1 longest line", data: []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+			want: Header{Version: V1, Command: CommandProxy,
+				Source:      netip.MustParseAddrPort("[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535"),
+				Destination: netip.MustParseAddrPort("[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535")}},
+		{name: "v2 tlvs are skipped", data: v2Header(0x21, 0x11, append(v4Body, tlv...)),
+			want: Header{Version: V2, Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:56324"), Destination: netip.MustParseAddrPort("198.51.100.1:443")}},
+		{name: "v2 unix family", data: v2Header(0x21, 0x31, make([]byte, 216)), want: Header{Version: V2, Command: CommandProxy}},
+		{name: "v2 local with addresses", data: append(v2Header(0x20, 0x11, v4Body), "rest"...), want: Header{Version: V2, Command: CommandLocal}, rest: "rest"},
+
+		{name: "no header", data: []byte("GET / HTTP/1.1\r\n\r\n"), wantErr: ErrNoHeader.Error()},
+		{name: "short connection", data: []byte("PRO"), wantErr: "EOF"},
+		{name: "v1 without crlf", data: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n"), wantErr: "not terminated by CRLF"},
+		{name: "v1 too long", data: []byte("PROXY TCP6 " + strings.Repeat("f", 120) + "\r\n"), wantErr: "too long"},
+		{name: "v1 truncated", data: []byte("PROXY TCP4 192.0.2.1"), wantErr: "EOF"},
+		{name: "v1 missing ports", data: []byte("PROXY TCP4 192.0.2.1 198.51.100.1\r\n"), wantErr: "malformed PROXY header"},
+		{name: "v1 unknown family", data: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n"), wantErr: "malformed PROXY header"},
+		{name: "v1 invalid address", data: []byte("PROXY TCP4 192.0.2 198.51.100.1 1 2\r\n"), wantErr: "malformed PROXY header"},
+		{name: "v1 port out of range", data: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 2\r\n"), wantErr: "malformed PROXY header"},
+		{name: "v1 family mismatch", data: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n"), wantErr: "do not match TCP4"},
+		{name: "v1 mixed families", data: []byte("PROXY TCP6 2001:db8::1 192.0.2.1 1 2\r\n"), wantErr: "do not match TCP6"},
+		{name: "v2 truncated signature", data: v2Signature[:8], wantErr: "EOF"},
+		{name: "v2 corrupt signature", data: append([]byte("\r\n\r\n\x00\r\nQUIX\n"), 0x21, 0x11, 0, 0), wantErr: ErrNoHeader.Error()},
+		{name: "v2 truncated fixed header", data: v2Header(0x21, 0x11, nil)[:14], wantErr: "EOF"},
+		{name: "v2 wrong version", data: v2Header(0x11, 0x11, v4Body), wantErr: "unsupported PROXY header version 1"},
+		{name: "v2 unknown command", data: v2Header(0x22, 0x11, v4Body), wantErr: "unsupported PROXY command 2"},
+		{name: "v2 truncated body", data: v2Header(0x21, 0x11, v4Body)[:20], wantErr: "EOF"},
+		{name: "v2 short ipv4 addresses", data: v2Header(0x21, 0x11, v4Body[:8]), wantErr: "truncated PROXY header addresses"},
+		{name: "v2 short ipv6 addresses", data: v2Header(0x21, 0x21, make([]byte, 35)), wantErr: "truncated PROXY header addresses"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := readHeader(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Read() = %+v, want %+v", got, tt.want)
+			}
+			if rest != tt.rest {
+				t.Errorf("data after the header = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tests := []struct {
+		name    string
+		trusted []netip.Prefix
+		send    string
+		remote  string // Remote address seen, the peer's when empty
+		read    string
+		wantErr bool
+	}{
+		{name: "trusted peer", trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, send: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello", remote: "192.0.2.1:56324", read: "hello"},
+		{name: "trusted health check", trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, send: "PROXY UNKNOWN\r\nhello", read: "hello"},
+		{name: "trusted peer without header", trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, send: "hello", read: "hello", wantErr: true},
+		{name: "untrusted peer", trusted: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, send: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", read: "PROXY TCP4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte(tt.send))
+
+			conn, err := NewListener(ln, tt.trusted).Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			remote := tt.remote
+			if remote == "" {
+				remote = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != remote && !tt.wantErr {
+				t.Errorf("RemoteAddr() = %s, want %s", got, remote)
+			}
+
+			buf := make([]byte, len(tt.read))
+			_, err = io.ReadFull(conn, buf)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoHeader) {
+					t.Errorf("Read() error = %v, want ErrNoHeader", err)
+				}
+				return
+			}
+			if err != nil || string(buf) != tt.read {
+				t.Errorf("Read() = %q, %v, want %q", buf, err, tt.read)
+			}
+		})
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, seed := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+		"PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET /",
+		"PROXY UNKNOWN\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 0080 1\r\n",
+		"GET / HTTP/1.1\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Add(v2Header(0x21, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb, 0x04, 0x00, 0x00}))
+	f.Add(v2Header(0x21, 0x21, make([]byte, 36)))
+	f.Add(v2Header(0x20, 0x00, nil))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, _, err := readHeader(data)
+		if err != nil || h.Command != CommandProxy || !h.Source.IsValid() {
+			return
+		}
+		// Headers read must survive being sent on
+		wire, err := h.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal() of read header %+v error = %v", h, err)
+		}
+		got, _, err := readHeader(wire)
+		if err != nil {
+			t.Fatalf("Read() of marshaled %q error = %v", wire, err)
+		}
+		if got.Source.Addr().Unmap() != h.Source.Addr().Unmap() || got.Destination.Addr().Unmap() != h.Destination.Addr().Unmap() ||
+			got.Source.Port() != h.Source.Port() || got.Destination.Port() != h.Destination.Port() {
+			t.Fatalf("Read() of marshaled %+v = %+v", h, got)
+		}
+	})
+}
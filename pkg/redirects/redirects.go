@@ -0,0 +1,278 @@
+package redirects
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for redirect tracing options
+const (
+	defaultMaxHops = 10 // Requests sent before giving up
+	defaultTimeout = 5  // 5 second timeout per hop
+	maxMaxHops     = 30
+	maxBodySize    = 64 * 1024
+)
+
+// RedirectMessage represents the incoming redirect trace request
+type RedirectMessage struct {
+	// Required
+	URL string `json:"url"` // URL the chain starts at
+
+	// Optional parameters
+	MaxHops  *int              `json:"max_hops,omitempty"` // Most requests to send
+	Method   *string           `json:"method,omitempty"`   // GET or HEAD, GET by default
+	Headers  map[string]string `json:"headers,omitempty"`  // Extra request headers sent on every hop
+	Timeout  *int              `json:"timeout,omitempty"`  // Timeout in seconds per hop
+	Insecure *bool             `json:"insecure,omitempty"` // Skip TLS certificate verification
+}
+
+// Cookie is a cookie set by a hop
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Secure   bool   `json:"secure"`
+	HTTPOnly bool   `json:"http_only"`
+	SameSite string `json:"same_site,omitempty"` // "lax", "strict" or "none" when set
+}
+
+// HopMessage reports one request of the chain
+type HopMessage struct {
+	Type      string   `json:"type"`                // Message type ("hop")
+	Hop       int      `json:"hop"`                 // Request number, from 1
+	URL       string   `json:"url"`                 // URL requested
+	Status    int      `json:"status,omitempty"`    // HTTP status code
+	Location  string   `json:"location,omitempty"`  // Location header as sent
+	Next      string   `json:"next,omitempty"`      // Absolute URL the Location points to
+	Downgrade bool     `json:"downgrade,omitempty"` // Whether the redirect goes from https to http
+	Upgrade   bool     `json:"upgrade,omitempty"`   // Whether the redirect goes from http to https
+	HSTS      string   `json:"hsts,omitempty"`      // Strict-Transport-Security header, which browsers only honor over https
+	Cookies   []Cookie `json:"cookies,omitempty"`   // Cookies set by the response
+	Latency   float64  `json:"latency"`             // Time to the response headers in milliseconds
+	Error     string   `json:"error,omitempty"`     // Error when the request failed
+}
+
+// SummaryMessage describes the whole chain
+type SummaryMessage struct {
+	Type      string  `json:"type"`             // Message type ("summary")
+	URL       string  `json:"url"`              // URL the chain started at
+	FinalURL  string  `json:"final_url"`        // Last URL requested
+	Status    int     `json:"status,omitempty"` // Status code of the last response
+	Hops      int     `json:"hops"`             // Requests sent
+	Redirects int     `json:"redirects"`        // Redirects followed
+	Complete  bool    `json:"complete"`         // Whether the chain ended in a response that is not a redirect
+	Loop      bool    `json:"loop"`             // Whether a redirect pointed back to a URL already requested
+	Downgrade bool    `json:"downgrade"`        // Whether any redirect went from https to http
+	HSTS      bool    `json:"hsts"`             // Whether any https hop sent Strict-Transport-Security
+	Latency   float64 `json:"latency"`          // Sum of the hop latencies in milliseconds
+	Error     string  `json:"error,omitempty"`  // Why the chain ended early
+}
+
+// RedirectOptions contains the resolved redirect tracing options
+type RedirectOptions struct {
+	URL        *url.URL
+	MaxHops    int
+	Method     string
+	Headers    map[string]string
+	Timeout    int
+	IsInsecure bool
+}
+
+// resolveRedirectOptions converts RedirectMessage to RedirectOptions with
+// defaults
+func resolveRedirectOptions(msg *RedirectMessage) (RedirectOptions, error) {
+	opts := RedirectOptions{
+		MaxHops:    tool.GetOrDefault(msg.MaxHops, defaultMaxHops),
+		Method:     strings.ToUpper(tool.GetOrDefault(msg.Method, http.MethodGet)),
+		Headers:    msg.Headers,
+		Timeout:    tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		IsInsecure: tool.GetOrDefault(msg.Insecure, false),
+	}
+
+	u, err := url.Parse(strings.TrimSpace(msg.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return opts, fmt.Errorf("invalid url %q", msg.URL)
+	}
+	opts.URL = u
+	if opts.MaxHops <= 0 || opts.MaxHops > maxMaxHops {
+		return opts, fmt.Errorf("max_hops must be between 1 and %d", maxMaxHops)
+	}
+	if opts.Method != http.MethodGet && opts.Method != http.MethodHead {
+		return opts, fmt.Errorf("method must be GET or HEAD")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// isRedirect reports whether status asks the client to follow Location
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// sameSite names the SameSite attribute of a cookie
+func sameSite(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return ""
+}
+
+// cookies returns the cookies set by a response
+func cookies(resp *http.Response) []Cookie {
+	var set []Cookie
+	for _, c := range resp.Cookies() {
+		set = append(set, Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+			SameSite: sameSite(c.SameSite),
+		})
+	}
+	return set
+}
+
+// Handler handles WebSocket redirect trace requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "redirects")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg RedirectMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading redirects message: %v", err)
+		return
+	}
+
+	opts, err := resolveRedirectOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid redirects options: %v", err)
+		return
+	}
+
+	// Redirects are followed by hand to report every hop, sending the
+	// cookies set on the way like a browser would. Each hop uses a new
+	// connection so its latency includes the handshakes.
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Timeout: time.Duration(opts.Timeout) * time.Second,
+		Jar:     jar,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: opts.IsInsecure},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	summary := SummaryMessage{Type: "summary", URL: opts.URL.String()}
+	visited := make(map[string]bool)
+	current := opts.URL
+	for summary.Hops < opts.MaxHops {
+		summary.Hops++
+		summary.FinalURL = current.String()
+		visited[current.String()] = true
+		hop := HopMessage{Type: "hop", Hop: summary.Hops, URL: current.String()}
+
+		req, err := http.NewRequestWithContext(r.Context(), opts.Method, current.String(), nil)
+		if err != nil {
+			log.Printf("Failed to create request: %v", err)
+			return
+		}
+		tool.Identify(req)
+		for name, value := range opts.Headers {
+			req.Header.Set(name, value)
+		}
+
+		session.CountProbe()
+		start := time.Now()
+		resp, err := client.Do(req)
+		hop.Latency = float64(time.Since(start).Microseconds()) / 1000.0
+		summary.Latency += hop.Latency
+		var next *url.URL
+		if err != nil {
+			hop.Error = err.Error()
+			summary.Error = hop.Error
+		} else {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+			resp.Body.Close()
+			hop.Status = resp.StatusCode
+			hop.Cookies = cookies(resp)
+			summary.Status = resp.StatusCode
+			if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != "" {
+				hop.HSTS = hsts
+				summary.HSTS = summary.HSTS || current.Scheme == "https"
+			}
+			hop.Location = resp.Header.Get("Location")
+			switch {
+			case !isRedirect(resp.StatusCode):
+				summary.Complete = true
+			case hop.Location == "":
+				summary.Error = fmt.Sprintf("%d redirect without a Location header", resp.StatusCode)
+			default:
+				next, err = current.Parse(hop.Location)
+				if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+					summary.Error = fmt.Sprintf("invalid Location %q", hop.Location)
+					next = nil
+					break
+				}
+				hop.Next = next.String()
+				hop.Downgrade = current.Scheme == "https" && next.Scheme == "http"
+				hop.Upgrade = current.Scheme == "http" && next.Scheme == "https"
+				summary.Downgrade = summary.Downgrade || hop.Downgrade
+				summary.Redirects++
+			}
+		}
+
+		if err := session.WriteJSON(hop); err != nil {
+			log.Printf("Failed to send hop: %v", err)
+			return
+		}
+		if next == nil {
+			break
+		}
+		if visited[next.String()] {
+			summary.Loop = true
+			summary.Error = fmt.Sprintf("redirect loop back to %s", next)
+			break
+		}
+		current = next
+	}
+	if !summary.Complete && summary.Error == "" {
+		summary.Error = fmt.Sprintf("stopped after %d hops", opts.MaxHops)
+	}
+
+	log.Printf("Redirect chain of %s: %d redirects to %s", summary.URL, summary.Redirects, summary.FinalURL)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime/metrics"
+	"strconv"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// Default values and hard limits for script execution
+const (
+	defaultTimeout  = 10        // 10 second wall-clock limit
+	maxTimeout      = 60        // Scripts may not run longer than a minute
+	defaultMaxSteps = 1_000_000 // Default execution step budget
+	maxMaxSteps     = 10_000_000
+	defaultMaxMem   = 64  // Default heap growth limit in MiB
+	maxMaxMem       = 256 // Scripts may never grow the heap by more than 256 MiB
+	memCheckPeriod  = 50 * time.Millisecond
+	maxScriptSize   = 64 << 10 // 64 KiB of source
+	maxReadSize     = 1 << 20  // Builtins return at most 1 MiB of data
+	maxBannerSize   = 4096     // tcp_banner returns at most 4 KiB
+	maxMessages     = 1000     // Messages a script may send to the client
+	netTimeout      = 5        // Default timeout for network builtins in seconds
+)
+
+// ScriptMessage represents the incoming script execution request
+type ScriptMessage struct {
+	// Required
+	Script string `json:"script"` // Starlark source to execute
+
+	// Optional parameters
+	Args     map[string]string `json:"args,omitempty"`      // Values exposed to the script as the args dict
+	Timeout  *int              `json:"timeout,omitempty"`   // Wall-clock limit in seconds
+	MaxSteps *int              `json:"max_steps,omitempty"` // Execution step budget
+
+	// Heap growth limit in MiB. The limit is best effort: the heap is sampled
+	// every memCheckPeriod, so a single string or list repeat or
+	// concatenation, which Starlark allows up to 1 GiB, completes before the
+	// script is stopped.
+	MaxMem *int `json:"max_mem,omitempty"`
+}
+
+// LogMessage carries the output of print() calls
+type LogMessage struct {
+	Type    string `json:"type"`    // Message type ("log")
+	Message string `json:"message"` // Printed text
+}
+
+// ResultMessage carries values passed to emit()
+type ResultMessage struct {
+	Type   string          `json:"type"`   // Message type ("result")
+	Values json.RawMessage `json:"values"` // Emitted values encoded as JSON
+}
+
+// DoneMessage reports how the script finished
+type DoneMessage struct {
+	Type     string  `json:"type"`            // Message type ("done")
+	Success  bool    `json:"success"`         // Whether the script ran to completion
+	Steps    uint64  `json:"steps"`           // Execution steps consumed
+	Duration float64 `json:"duration"`        // Run time in milliseconds
+	Error    string  `json:"error,omitempty"` // Error or limit that stopped the script
+}
+
+// ScriptOptions contains the resolved script options
+type ScriptOptions struct {
+	Script   string
+	Args     map[string]string
+	Timeout  int
+	MaxSteps int
+	MaxMem   int
+}
+
+// resolveScriptOptions converts ScriptMessage to ScriptOptions with defaults
+func resolveScriptOptions(msg *ScriptMessage) (ScriptOptions, error) {
+	opts := ScriptOptions{
+		Script:   msg.Script,
+		Args:     msg.Args,
+		Timeout:  tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		MaxSteps: tool.GetOrDefault(msg.MaxSteps, defaultMaxSteps),
+		MaxMem:   tool.GetOrDefault(msg.MaxMem, defaultMaxMem),
+	}
+
+	if opts.Script == "" {
+		return opts, fmt.Errorf("script is required")
+	}
+	if len(opts.Script) > maxScriptSize {
+		return opts, fmt.Errorf("script exceeds %d bytes", maxScriptSize)
+	}
+	if opts.Timeout <= 0 || opts.Timeout > maxTimeout {
+		return opts, fmt.Errorf("timeout must be between 1 and %d seconds", maxTimeout)
+	}
+	if opts.MaxSteps <= 0 || opts.MaxSteps > maxMaxSteps {
+		return opts, fmt.Errorf("max steps must be between 1 and %d", maxMaxSteps)
+	}
+	if opts.MaxMem <= 0 || opts.MaxMem > maxMaxMem {
+		return opts, fmt.Errorf("max mem must be between 1 and %d MiB", maxMaxMem)
+	}
+	return opts, nil
+}
+
+// runner executes one script and relays its output to the client
+type runner struct {
+	ctx      context.Context
+	session  *tool.Session
+	messages int
+	writeErr error
+}
+
+// send writes a message to the client, enforcing the per-script message limit
+func (r *runner) send(msg any) error {
+	if r.writeErr != nil {
+		return r.writeErr
+	}
+	r.messages++
+	if r.messages > maxMessages {
+		return fmt.Errorf("script exceeded %d messages", maxMessages)
+	}
+	if err := r.session.WriteJSON(msg); err != nil {
+		r.writeErr = fmt.Errorf("error writing message: %w", err)
+		return r.writeErr
+	}
+	return nil
+}
+
+// timeoutArg converts an optional timeout argument in seconds to a duration
+func timeoutArg(seconds float64) time.Duration {
+	if seconds <= 0 {
+		seconds = netTimeout
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// emit implements emit(value) and emit(key=value, ...)
+func (r *runner) emit(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	switch {
+	case len(args) == 1 && len(kwargs) == 0:
+		value = args[0]
+	case len(args) == 0:
+		dict := starlark.NewDict(len(kwargs))
+		for _, kv := range kwargs {
+			dict.SetKey(kv[0], kv[1])
+		}
+		value = dict
+	default:
+		return nil, fmt.Errorf("%s: expected a single value or keyword arguments", b.Name())
+	}
+
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{value}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.send(ResultMessage{Type: "result", Values: json.RawMessage(encoded.(starlark.String))}); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// resolve implements resolve(host) returning a list of addresses
+func (r *runner) resolve(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, netTimeout*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	list := make([]starlark.Value, len(addrs))
+	for i, addr := range addrs {
+		list[i] = starlark.String(addr)
+	}
+	return starlark.NewList(list), nil
+}
+
+// tcpConnect implements tcp_connect(host, port, timeout=5) returning the
+// connect time in milliseconds
+func (r *runner) tcpConnect(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host string
+	var port int
+	var timeout float64
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host, "port", &port, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, timeoutArg(timeout))
+	defer cancel()
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	conn.Close()
+	return starlark.Float(float64(time.Since(start).Microseconds()) / 1000.0), nil
+}
+
+// tcpBanner implements tcp_banner(host, port, send="", timeout=5) returning
+// the first bytes the server sends after the optional payload
+func (r *runner) tcpBanner(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host, send string
+	var port int
+	var timeout float64
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host, "port", &port, "send?", &send, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, timeoutArg(timeout))
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if send != "" {
+		if _, err := io.WriteString(conn, send); err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+	}
+
+	buf := make([]byte, maxBannerSize)
+	n, err := conn.Read(buf)
+	if err != nil && n == 0 {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(buf[:n]), nil
+}
+
+// httpGet implements http_get(url, timeout=5) returning a struct with
+// status, headers, body and latency fields
+func (r *runner) httpGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var rawURL string
+	var timeout float64
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &rawURL, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, timeoutArg(timeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	tool.Identify(req)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxReadSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	latency := float64(time.Since(start).Microseconds()) / 1000.0
+
+	headers := starlark.NewDict(len(resp.Header))
+	for name := range resp.Header {
+		headers.SetKey(starlark.String(name), starlark.String(resp.Header.Get(name)))
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"status":  starlark.MakeInt(resp.StatusCode),
+		"headers": headers,
+		"body":    starlark.String(body),
+		"latency": starlark.Float(latency),
+	}), nil
+}
+
+// predeclared returns the global names available to scripts
+func (r *runner) predeclared(args map[string]string) starlark.StringDict {
+	argsDict := starlark.NewDict(len(args))
+	for key, value := range args {
+		argsDict.SetKey(starlark.String(key), starlark.String(value))
+	}
+	argsDict.Freeze()
+
+	return starlark.StringDict{
+		"args":        argsDict,
+		"json":        starlarkjson.Module,
+		"struct":      starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"emit":        starlark.NewBuiltin("emit", r.emit),
+		"resolve":     starlark.NewBuiltin("resolve", r.resolve),
+		"tcp_connect": starlark.NewBuiltin("tcp_connect", r.tcpConnect),
+		"tcp_banner":  starlark.NewBuiltin("tcp_banner", r.tcpBanner),
+		"http_get":    starlark.NewBuiltin("http_get", r.httpGet),
+	}
+}
+
+// heapBytes returns the number of bytes currently held by live heap objects
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// watchMemory cancels the thread once the heap has grown by more than limit
+// bytes since the script started. Starlark has no per-thread allocator, so
+// this is an approximation that also counts other concurrent allocations.
+func watchMemory(ctx context.Context, thread *starlark.Thread, limit uint64) {
+	baseline := heapBytes()
+	ticker := time.NewTicker(memCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if current := heapBytes(); current > baseline && current-baseline > limit {
+				thread.Cancel("memory limit exceeded")
+				return
+			}
+		}
+	}
+}
+
+// run executes the script within the configured limits
+func (r *runner) run(opts ScriptOptions) DoneMessage {
+	thread := &starlark.Thread{
+		Name: "script",
+		Print: func(thread *starlark.Thread, msg string) {
+			if err := r.send(LogMessage{Type: "log", Message: msg}); err != nil {
+				thread.Cancel(err.Error())
+			}
+		},
+	}
+	thread.SetMaxExecutionSteps(uint64(opts.MaxSteps))
+
+	// Cancelling the thread also aborts any builtin blocked on the network
+	ctx, cancel := context.WithTimeout(r.ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	r.ctx = ctx
+	stop := context.AfterFunc(ctx, func() {
+		thread.Cancel("timeout exceeded")
+	})
+	defer stop()
+	go watchMemory(ctx, thread, uint64(opts.MaxMem)<<20)
+
+	start := time.Now()
+	_, err := starlark.ExecFileOptions(&syntax.FileOptions{TopLevelControl: true, GlobalReassign: true, While: true}, thread, "script.star", opts.Script, r.predeclared(opts.Args))
+
+	done := DoneMessage{
+		Type:     "done",
+		Success:  err == nil,
+		Steps:    thread.ExecutionSteps(),
+		Duration: float64(time.Since(start).Microseconds()) / 1000.0,
+	}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	return done
+}
+
+// Handler handles WebSocket script execution requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "script")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg ScriptMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading script message: %v", err)
+		return
+	}
+
+	opts, err := resolveScriptOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid script options: %v", err)
+		return
+	}
+
+	run := &runner{ctx: r.Context(), session: session}
+	done := run.run(opts)
+	log.Printf("Script finished after %d steps in %.3f ms: %s", done.Steps, done.Duration, done.Error)
+
+	if run.writeErr != nil {
+		log.Printf("Failed to send script output: %v", run.writeErr)
+		return
+	}
+	if err := session.WriteJSON(done); err != nil {
+		log.Printf("Failed to send done message: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+)
+
+// sharedQueue is the number of results buffered per subscriber of a shared stream
+const sharedQueue = 16
+
+// probeResult is the outcome of a single probe
+type probeResult struct {
+	latency float64
+	ttl     int            // TTL of the reply, 0 when unknown
+	tcpInfo *probe.TCPInfo // TCP_INFO of the probe's connection, nil when unknown
+	err     error
+}
+
+// sharedKey identifies the ping requests that can share one probe stream
+type sharedKey struct {
+	protocol string
+	address  string
+	port     int
+	source   string
+	family   string
+	wait     time.Duration
+	size     int
+	timeout  int
+	ttl      int
+	tos      int
+	proxy    int
+	proxySrc netip.AddrPort
+}
+
+// sharedStream runs one probe loop for a target and fans each result out to
+// every subscriber
+type sharedStream struct {
+	prober      prober
+	resolved    string
+	subscribers map[chan probeResult]struct{}
+	stop        chan struct{}
+}
+
+// sharedProbes holds the running shared probe streams
+type sharedProbes struct {
+	mu      sync.Mutex
+	streams map[sharedKey]*sharedStream
+}
+
+// sharedStreams is the process wide set of shared probe streams
+var sharedStreams = &sharedProbes{streams: make(map[sharedKey]*sharedStream)}
+
+// subscribe joins the probe stream for the target, starting one if the
+// target is not probed yet. It returns the result channel, a function to
+// leave the stream and the resolved address being probed.
+func (s *sharedProbes) subscribe(opts PingOptions, address string) (<-chan probeResult, func(), string, error) {
+	if opts.Protocol == protocolHTTP {
+		var err error
+		if address, err = formatAddress(address); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	key := sharedKey{
+		protocol: opts.Protocol,
+		address:  address,
+		port:     opts.Port,
+		source:   opts.SourceIP.String(),
+		family:   opts.Family,
+		wait:     opts.Wait,
+		size:     opts.PacketSize,
+		timeout:  opts.Timeout,
+		ttl:      opts.TTL,
+		tos:      opts.TOS,
+		proxy:    opts.ProxyVersion,
+		proxySrc: opts.ProxySource,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, ok := s.streams[key]
+	if !ok {
+		p, resolved, err := newProber(opts, address)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		stream = &sharedStream{
+			prober:      p,
+			resolved:    resolved,
+			subscribers: make(map[chan probeResult]struct{}),
+			stop:        make(chan struct{}),
+		}
+		s.streams[key] = stream
+		go s.run(stream, key)
+		log.Printf("Started shared probe stream for %s", resolved)
+	}
+
+	ch := make(chan probeResult, sharedQueue)
+	stream.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(stream.subscribers, ch)
+		if len(stream.subscribers) == 0 && s.streams[key] == stream {
+			delete(s.streams, key)
+			close(stream.stop)
+		}
+	}
+	return ch, unsubscribe, stream.resolved, nil
+}
+
+// run probes the target every wait interval until the last subscriber leaves
+func (s *sharedProbes) run(stream *sharedStream, key sharedKey) {
+	defer stream.prober.close()
+
+	ticker := time.NewTicker(key.wait)
+	defer ticker.Stop()
+
+	for sequence := 0; ; sequence++ {
+		select {
+		case <-stream.stop:
+			log.Printf("Stopped shared probe stream for %s", stream.resolved)
+			return
+		case <-ticker.C:
+		}
+
+		latency, err := stream.prober.probe(sequence, key.size)
+		result := probeResult{latency: latency, err: err}
+		if tp, ok := stream.prober.(ttlProber); ok && err == nil {
+			result.ttl = tp.replyTTL()
+		}
+		if tp, ok := stream.prober.(tcpInfoProber); ok && err == nil {
+			result.tcpInfo = tp.tcpInfo()
+		}
+
+		s.broadcast(stream, result)
+	}
+}
+
+// broadcast sends a result to every subscriber of the stream
+func (s *sharedProbes) broadcast(stream *sharedStream, result probeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range stream.subscribers {
+		select {
+		case ch <- result:
+		default:
+			// A subscriber that falls behind misses results instead of
+			// delaying everyone else
+		}
+	}
+}
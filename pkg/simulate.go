@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// simulatedTimeout is returned by simulated probes that were lost
+type simulatedTimeout struct{}
+
+func (simulatedTimeout) Error() string   { return "simulated timeout" }
+func (simulatedTimeout) Timeout() bool   { return true }
+func (simulatedTimeout) Temporary() bool { return true }
+
+// simProber returns synthetic results in simulation mode, taking as long as
+// a real probe would
+type simProber struct {
+	target  *tool.SimulatedTarget
+	timeout time.Duration
+}
+
+func (p *simProber) probe(sequence, size int) (float64, error) {
+	rtt, ok := p.target.Probe()
+	if !ok || rtt > p.timeout {
+		time.Sleep(p.timeout)
+		return 0, simulatedTimeout{}
+	}
+	time.Sleep(rtt)
+	return float64(rtt.Microseconds()) / 1000.0, nil
+}
+
+func (p *simProber) close() error {
+	return nil
+}
+
+// simulatedIP stands in for the address a name resolves to, picked from the
+// documentation ranges so it is never mistaken for a real host
+func simulatedIP(host, family string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	n := byte(h.Sum32()%254 + 1)
+	if family == familyIPv6 {
+		return net.ParseIP(fmt.Sprintf("2001:db8::%x", n))
+	}
+	return net.IPv4(192, 0, 2, n)
+}
+
+// newSimProber creates the prober used instead of newProber in simulation
+// mode. Nothing is resolved or sent, so no network access or privileges are
+// needed.
+func newSimProber(opts PingOptions, address string) (prober, string, error) {
+	timeout := time.Duration(opts.Timeout) * time.Second
+	var resolved string
+	switch opts.Protocol {
+	case protocolICMP:
+		resolved = simulatedIP(hostFromAddress(address), opts.Family).String()
+	case protocolTCP, protocolUDP:
+		fallback := defaultTCPPort
+		if opts.Protocol == protocolUDP {
+			fallback = 0
+		}
+		host, port, err := targetPort(address, opts.Port, fallback)
+		if err != nil {
+			return nil, "", err
+		}
+		resolved = net.JoinHostPort(simulatedIP(host, opts.Family).String(), strconv.Itoa(port))
+	default:
+		var err error
+		if resolved, err = formatAddress(address); err != nil {
+			return nil, "", err
+		}
+	}
+	return &simProber{target: tool.Simulation.Target(resolved), timeout: timeout}, resolved, nil
+}
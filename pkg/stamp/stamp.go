@@ -0,0 +1,133 @@
+package stamp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Packet layout of unauthenticated STAMP test packets (RFC 8762 section 4)
+const (
+	packetSize     = 44    // Minimum size of sender and reflector packets
+	maxPacketSize  = 65535 // Largest datagram accepted
+	maxSessions    = 4096  // Sender sessions tracked for stateful sequence numbers
+	sessionTimeout = 10 * time.Minute
+	reflectorTTL   = 255 // TTL of reflected packets (RFC 8762 section 4.3)
+	// ntpEpochOffset is the number of seconds between 1900 and 1970
+	ntpEpochOffset = 2208988800
+	// errorEstimate claims an unsynchronized NTP format clock with an error
+	// of about one millisecond (scale 22, multiplier 1)
+	errorEstimate = 22<<8 | 1
+)
+
+// session tracks the reflector sequence number of one sender
+type session struct {
+	sequence uint32
+	lastSeen time.Time
+}
+
+// reflector answers STAMP test packets in stateful mode, numbering replies
+// per sender session
+type reflector struct {
+	mu       sync.Mutex
+	conn     *ipv4.PacketConn
+	sessions map[string]*session
+}
+
+// ntpTimestamp encodes t as a 64 bit NTP timestamp
+func ntpTimestamp(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	return seconds<<32 | fraction
+}
+
+// ListenReflector starts a STAMP session-reflector on the UDP address
+func ListenReflector(addr string) error {
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("error starting STAMP reflector: %w", err)
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if err := pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		pc.Close()
+		return fmt.Errorf("error enabling TTL reporting: %w", err)
+	}
+	if err := pc.SetTTL(reflectorTTL); err != nil {
+		pc.Close()
+		return fmt.Errorf("error setting reflector TTL: %w", err)
+	}
+
+	r := &reflector{conn: pc, sessions: make(map[string]*session)}
+	go r.serve()
+	return nil
+}
+
+// nextSequence returns the reflector sequence number for a sender
+func (r *reflector) nextSequence(peer string, now time.Time) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[peer]
+	if !ok {
+		if len(r.sessions) >= maxSessions {
+			for key, old := range r.sessions {
+				if now.Sub(old.lastSeen) > sessionTimeout {
+					delete(r.sessions, key)
+				}
+			}
+		}
+		s = &session{}
+		if len(r.sessions) < maxSessions {
+			r.sessions[peer] = s
+		}
+	}
+	s.lastSeen = now
+	sequence := s.sequence
+	s.sequence++
+	return sequence
+}
+
+// serve reflects test packets until the socket is closed
+func (r *reflector) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, cm, peer, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("STAMP reflector stopped: %v", err)
+			return
+		}
+		received := time.Now()
+		if n < packetSize {
+			continue
+		}
+		ttl := 0
+		if cm != nil {
+			ttl = cm.TTL
+		}
+		reply := r.reflect(buf[:n], peer.String(), ttl, received)
+		if _, err := r.conn.WriteTo(reply, nil, peer); err != nil {
+			log.Printf("Failed to send STAMP reply to %s: %v", peer, err)
+		}
+	}
+}
+
+// reflect builds the reflector packet for a sender test packet. The reply is
+// as long as the test packet so both directions carry the same size.
+func (r *reflector) reflect(packet []byte, peer string, ttl int, received time.Time) []byte {
+	reply := make([]byte, len(packet))
+	binary.BigEndian.PutUint32(reply[0:], r.nextSequence(peer, received))
+	binary.BigEndian.PutUint16(reply[12:], errorEstimate)
+	binary.BigEndian.PutUint64(reply[16:], ntpTimestamp(received))
+	copy(reply[24:28], packet[0:4])   // Session-Sender sequence number
+	copy(reply[28:36], packet[4:12])  // Session-Sender timestamp
+	copy(reply[36:38], packet[12:14]) // Session-Sender error estimate
+	reply[40] = byte(ttl)
+	// The transmit timestamp is written last, as close to sending as possible
+	binary.BigEndian.PutUint64(reply[4:], ntpTimestamp(time.Now()))
+	return reply
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rolling statistics tuning
+const (
+	jitterGain       = 16 // RFC 3550 smoothing: each sample moves jitter by 1/16 of the change
+	movingAvgSamples = 10 // Latest round-trip times averaged for the moving average
+)
+
+// SummaryMessage reports the statistics of a finished ping run, mirroring
+// the footer printed by classic ping
+type SummaryMessage struct {
+	Type        string    `json:"type"`             // Message type ("summary")
+	Timestamp   time.Time `json:"timestamp"`        // Time the run ended
+	Address     string    `json:"address"`          // Address that was pinged
+	Target      string    `json:"target,omitempty"` // Entry of targets the summary belongs to
+	Error       string    `json:"error,omitempty"`  // Error when the target could not be pinged
+	Transmitted int       `json:"transmitted"`      // Probes sent
+	Received    int       `json:"received"`         // Probes answered
+	Loss        float64   `json:"loss"`             // Packet loss in percent
+	Min         float64   `json:"min"`              // Minimum round-trip time in milliseconds
+	Avg         float64   `json:"avg"`              // Average round-trip time in milliseconds
+	Max         float64   `json:"max"`              // Maximum round-trip time in milliseconds
+	StdDev      float64   `json:"stddev"`           // Standard deviation of the round-trip time in milliseconds
+	Jitter      float64   `json:"jitter"`           // Final RFC 3550 style jitter in milliseconds
+}
+
+// pingStats accumulates the results of a ping run
+type pingStats struct {
+	transmitted int
+	received    int
+	min, max    float64
+	sum, sumSq  float64
+
+	jitter float64   // RFC 3550 style interarrival jitter
+	last   float64   // Previous answered round-trip time
+	window []float64 // Latest round-trip times, oldest first
+}
+
+// add records the result of one probe
+func (s *pingStats) add(latency float64, success bool) {
+	s.transmitted++
+	if !success {
+		return
+	}
+	if s.received == 0 || latency < s.min {
+		s.min = latency
+	}
+	if latency > s.max {
+		s.max = latency
+	}
+	// Jitter follows RFC 3550 with round-trip times in place of transit
+	// times, which cancels the unknown clock offset the same way
+	if s.received > 0 {
+		s.jitter += (math.Abs(latency-s.last) - s.jitter) / jitterGain
+	}
+	s.last = latency
+	if len(s.window) == movingAvgSamples {
+		s.window = s.window[1:]
+	}
+	s.window = append(s.window, latency)
+
+	s.received++
+	s.sum += latency
+	s.sumSq += latency * latency
+}
+
+// roundMs rounds milliseconds to the microsecond resolution probes measure
+func roundMs(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// loss returns the cumulative packet loss in percent
+func (s *pingStats) loss() float64 {
+	if s.transmitted == 0 {
+		return 0
+	}
+	return float64(s.transmitted-s.received) / float64(s.transmitted) * 100
+}
+
+// movingAvg returns the average of the latest round-trip times
+func (s *pingStats) movingAvg() float64 {
+	if len(s.window) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, latency := range s.window {
+		sum += latency
+	}
+	return roundMs(sum / float64(len(s.window)))
+}
+
+// summary returns the statistics as a summary message
+func (s *pingStats) summary(address string) SummaryMessage {
+	msg := SummaryMessage{
+		Type:        "summary",
+		Timestamp:   time.Now(),
+		Address:     address,
+		Transmitted: s.transmitted,
+		Received:    s.received,
+	}
+	msg.Loss = s.loss()
+	msg.Jitter = roundMs(s.jitter)
+	if s.received > 0 {
+		n := float64(s.received)
+		msg.Min = s.min
+		msg.Max = s.max
+		avg := s.sum / n
+		msg.Avg = roundMs(avg)
+		msg.StdDev = roundMs(math.Sqrt(math.Max(s.sumSq/n-avg*avg, 0)))
+	}
+	return msg
+}
+
+// formatSummary formats a summary as the footer lines of classic ping
+func formatSummary(msg SummaryMessage) []string {
+	lines := []string{
+		fmt.Sprintf("--- %s ping statistics ---", msg.Address),
+		fmt.Sprintf("%d packets transmitted, %d packets received, %.1f%% packet loss",
+			msg.Transmitted, msg.Received, msg.Loss),
+	}
+	if msg.Received > 0 {
+		lines = append(lines, fmt.Sprintf("round-trip min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms",
+			msg.Min, msg.Avg, msg.Max, msg.StdDev))
+	}
+	return lines
+}
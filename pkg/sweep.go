@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// CIDR sweep limits
+const (
+	defaultConcurrency = 32   // Hosts probed at once by default
+	maxConcurrency     = 256  // Most hosts probed at once
+	maxSweepHosts      = 4096 // Most hosts in a swept network (a /20 for IPv4)
+)
+
+// HostMessage reports whether one host of a CIDR sweep answered
+type HostMessage struct {
+	Type      string    `json:"type"`              // Message type ("host")
+	Timestamp time.Time `json:"timestamp"`         // Time the host was found up or given up on
+	Address   string    `json:"address"`           // Host that was probed
+	Up        bool      `json:"up"`                // Whether any probe was answered
+	Latency   float64   `json:"latency,omitempty"` // Round-trip time of the first answer in milliseconds
+	Probes    int       `json:"probes"`            // Probes sent to the host
+}
+
+// SweepMessage ends a CIDR sweep with the hosts that answered
+type SweepMessage struct {
+	Type      string    `json:"type"`      // Message type ("sweep")
+	Timestamp time.Time `json:"timestamp"` // Time the sweep ended
+	Network   string    `json:"network"`   // Network that was swept
+	Scanned   int       `json:"scanned"`   // Hosts probed
+	Alive     []string  `json:"alive"`     // Hosts that answered, in address order
+}
+
+// sweepSize returns the number of hosts swept in network, without the
+// network and broadcast addresses of IPv4 networks larger than a /31
+func sweepSize(network *net.IPNet) *big.Int {
+	ones, bits := network.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if bits == 32 && bits-ones > 1 {
+		size.Sub(size, big.NewInt(2))
+	}
+	return size
+}
+
+// sweepHosts returns the addresses swept in network, which must be at most
+// maxSweepHosts in size
+func sweepHosts(network *net.IPNet) []net.IP {
+	ones, bits := network.Mask.Size()
+	first := new(big.Int).SetBytes(network.IP.Mask(network.Mask))
+	count := 1 << (bits - ones)
+	skipEnds := bits == 32 && bits-ones > 1
+
+	hosts := make([]net.IP, 0, count)
+	for i := 0; i < count; i++ {
+		if skipEnds && (i == 0 || i == count-1) {
+			continue
+		}
+		n := new(big.Int).Add(first, big.NewInt(int64(i)))
+		ip := make(net.IP, bits/8)
+		n.FillBytes(ip)
+		hosts = append(hosts, ip)
+	}
+	return hosts
+}
+
+// probeHost sends up to attempts probes to a host and reports it up on the
+// first answer. Refused TCP connections and ICMP port unreachable errors
+// come from the host itself, so they count as answers too.
+func probeHost(session *tool.Session, opts PingOptions, ip net.IP, attempts int) HostMessage {
+	result := HostMessage{Type: "host", Address: ip.String()}
+	p, _, err := newProber(opts, ip.String())
+	if err != nil {
+		log.Printf("Failed to create prober for %s: %v", ip, err)
+		result.Timestamp = time.Now()
+		return result
+	}
+	defer p.close()
+
+	for sequence := 0; sequence < attempts; sequence++ {
+		result.Probes++
+		latency, err := p.probe(sequence, opts.PacketSize)
+		if err == nil || errors.Is(err, errPortUnreachable) || probeFailure(err) == failureRefused {
+			result.Up, result.Latency = true, latency
+			break
+		}
+		if sequence+1 < attempts {
+			session.Log(tool.EventRetry, map[string]any{"address": result.Address, "attempt": sequence + 2, "error": err.Error()}, "retrying %s after: %v", result.Address, err)
+		}
+	}
+	result.Timestamp = time.Now()
+	return result
+}
+
+// formatHostResult formats a sweep result in the style of fping
+func formatHostResult(host HostMessage) string {
+	if host.Up {
+		return fmt.Sprintf("%s is alive (%.3f ms)", host.Address, host.Latency)
+	}
+	return fmt.Sprintf("%s is unreachable", host.Address)
+}
+
+// sweep probes every host of the network with at most opts.Concurrency
+// probes in flight, streaming each result, and ends with the alive hosts.
+// Each host gets up to count probes, or one when pinging continuously.
+func (r *pingRun) sweep(ctx context.Context) {
+	session, opts := r.session, r.opts
+	hosts := sweepHosts(opts.Network)
+	_, count, _ := r.control.settings()
+	attempts := max(count, 1)
+	if count == 0 {
+		session.Log(tool.EventClamped, map[string]any{"option": "count", "requested": 0, "value": attempts}, "sweeps cannot ping continuously, each host gets %d probe", attempts)
+	}
+	workers := min(opts.Concurrency, len(hosts))
+
+	header := fmt.Sprintf("SWEEP %s: %d hosts, %d at a time", opts.Network, len(hosts), workers)
+	log.Print(header)
+	if opts.Format == tool.FormatText {
+		if err := session.WriteText(header); err != nil {
+			log.Printf("Failed to send text: %v", err)
+			return
+		}
+	}
+
+	// Hosts are handed out until the sweep is stopped; probes in flight
+	// still report their result
+	results := make(chan HostMessage)
+	pending := make(chan net.IP)
+	session.Go(func() {
+		defer close(pending)
+		for _, ip := range hosts {
+			select {
+			case pending <- ip:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		session.Go(func() {
+			defer func() { done <- struct{}{} }()
+			for ip := range pending {
+				session.CountProbe()
+				results <- probeHost(session, opts, ip, attempts)
+			}
+		})
+	}
+
+	summary := SweepMessage{Type: "sweep", Network: opts.Network.String(), Alive: []string{}}
+	var alive []net.IP
+	failed := false
+	for workers > 0 {
+		select {
+		case <-done:
+			workers--
+			continue
+		case host := <-results:
+			summary.Scanned++
+			if host.Up {
+				alive = append(alive, net.ParseIP(host.Address))
+			}
+			if failed || opts.IsQuiet {
+				continue
+			}
+			if err := session.WriteJSON(host); err != nil {
+				log.Printf("Failed to send host: %v", err)
+				failed = true
+				continue
+			}
+			if opts.Format == tool.FormatText {
+				if err := session.WriteText(formatHostResult(host)); err != nil {
+					log.Printf("Failed to send text: %v", err)
+					failed = true
+				}
+			}
+		}
+	}
+
+	sort.Slice(alive, func(i, j int) bool { return bytes.Compare(alive[i].To16(), alive[j].To16()) < 0 })
+	for _, ip := range alive {
+		summary.Alive = append(summary.Alive, ip.String())
+	}
+	summary.Timestamp = time.Now()
+	footer := fmt.Sprintf("%d/%d hosts alive in %s", len(summary.Alive), summary.Scanned, summary.Network)
+	log.Print(footer)
+	if failed || (ctx.Err() != nil && !errors.Is(context.Cause(ctx), errStopped)) {
+		return
+	}
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send sweep summary: %v", err)
+		return
+	}
+	if opts.Format == tool.FormatText {
+		if err := session.WriteText(footer); err != nil {
+			log.Printf("Failed to send text: %v", err)
+		}
+	}
+}
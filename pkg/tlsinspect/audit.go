@@ -0,0 +1,214 @@
+package tlsinspect
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// auditVersions are the protocol versions an audit probes, oldest first
+var auditVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// Grades of an audit, best first. GradeT marks a chain that does not verify,
+// whatever the rest of the configuration.
+const (
+	GradeA = "A"
+	GradeB = "B"
+	GradeC = "C"
+	GradeF = "F"
+	GradeT = "T"
+)
+
+// Weaknesses of cipher suites
+const (
+	weakRC4   = "rc4"    // Biased keystream (RFC 7465)
+	weak3DES  = "3des"   // 64-bit block, open to Sweet32
+	weakNoPFS = "no_pfs" // RSA key exchange, no forward secrecy
+)
+
+// CipherSuiteInfo describes a cipher suite a server accepted
+type CipherSuiteInfo struct {
+	Name string   `json:"name"`           // IANA name of the suite
+	ID   string   `json:"id"`             // Suite ID in hex, e.g. 0xc02f
+	Weak []string `json:"weak,omitempty"` // Weaknesses of the suite: rc4, 3des or no_pfs
+}
+
+// ProtocolMessage reports whether a server accepts one protocol version and
+// the cipher suites it accepts with it, in the server's order of preference
+type ProtocolMessage struct {
+	Type         string            `json:"type"`                    // Message type ("protocol")
+	Version      string            `json:"version"`                 // Protocol version, e.g. TLS 1.2
+	Supported    bool              `json:"supported"`               // Whether a handshake with the version succeeded
+	CipherSuites []CipherSuiteInfo `json:"cipher_suites,omitempty"` // Suites accepted with the version
+}
+
+// Finding is a weakness an audit found and the grade it caps the server at
+type Finding struct {
+	ID          string `json:"id"`          // Stable identifier, e.g. tls10
+	Description string `json:"description"` // What was found
+	Grade       string `json:"grade"`       // Best grade the server can get with it
+}
+
+// suiteID formats a cipher suite ID as it is usually written
+func suiteID(id uint16) string {
+	return fmt.Sprintf("0x%04x", id)
+}
+
+// weaknesses returns the weaknesses of a cipher suite; TLS 1.3 suites
+// always have forward secrecy
+func weaknesses(name string) []string {
+	var weak []string
+	if strings.Contains(name, "_RC4_") {
+		weak = append(weak, weakRC4)
+	}
+	if strings.Contains(name, "_3DES_") {
+		weak = append(weak, weak3DES)
+	}
+	if strings.HasPrefix(name, "TLS_RSA_") {
+		weak = append(weak, weakNoPFS)
+	}
+	return weak
+}
+
+// suitesFor returns the IDs of every cipher suite the client implements for
+// a TLS 1.0 to 1.2 version, including insecure ones
+func suitesFor(version uint16) []uint16 {
+	var ids []uint16
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.Contains(suite.SupportedVersions, version) {
+			ids = append(ids, suite.ID)
+		}
+	}
+	return ids
+}
+
+// probeVersion finds the cipher suites the server accepts with version by
+// offering every remaining suite until a handshake fails, so the suites are
+// found in the server's order of preference. TLS 1.3 suites cannot be
+// offered selectively, so only the suite the server picks is reported.
+func probeVersion(ctx context.Context, session *tool.Session, opts TLSOptions, version uint16) ProtocolMessage {
+	msg := ProtocolMessage{Type: "protocol", Version: tls.VersionName(version)}
+	var remaining []uint16
+	if version != tls.VersionTLS13 {
+		remaining = suitesFor(version)
+	}
+	for ctx.Err() == nil {
+		config := clientConfig(opts)
+		config.MinVersion, config.MaxVersion = version, version
+		config.CipherSuites = remaining
+		session.CountProbe()
+		conn, err := handshake(ctx, opts, config)
+		if err != nil {
+			break
+		}
+		chosen := conn.ConnectionState().CipherSuite
+		conn.Close()
+		msg.Supported = true
+		name := tls.CipherSuiteName(chosen)
+		msg.CipherSuites = append(msg.CipherSuites, CipherSuiteInfo{Name: name, ID: suiteID(chosen), Weak: weaknesses(name)})
+
+		i := slices.Index(remaining, chosen)
+		if i < 0 {
+			break
+		}
+		if remaining = slices.Delete(remaining, i, i+1); len(remaining) == 0 {
+			break
+		}
+	}
+	return msg
+}
+
+// capGrade returns the worse of two grades
+func capGrade(grade, limit string) string {
+	order := []string{GradeA, GradeB, GradeC, GradeF}
+	if slices.Index(order, limit) > slices.Index(order, grade) {
+		return limit
+	}
+	return grade
+}
+
+// grade rates the configuration found by an audit, loosely following the
+// SSL Labs rating guide: legacy protocols, missing forward secrecy and small
+// RSA keys cap the grade at B, RC4, 3DES and a missing TLS 1.2 at C
+func grade(summary SummaryMessage, chain []CertificateMessage, protocols []ProtocolMessage) (string, []Finding) {
+	var findings []Finding
+	add := func(id, description, grade string) {
+		findings = append(findings, Finding{ID: id, Description: description, Grade: grade})
+	}
+
+	supported := make(map[string]bool)
+	pfs, weak := false, make(map[string]bool)
+	for _, p := range protocols {
+		supported[p.Version] = p.Supported
+		for _, suite := range p.CipherSuites {
+			for _, w := range suite.Weak {
+				weak[w] = true
+			}
+			if !slices.Contains(suite.Weak, weakNoPFS) {
+				pfs = true
+			}
+		}
+	}
+	if supported[tls.VersionName(tls.VersionTLS10)] {
+		add("tls10", "TLS 1.0 is supported", GradeB)
+	}
+	if supported[tls.VersionName(tls.VersionTLS11)] {
+		add("tls11", "TLS 1.1 is supported", GradeB)
+	}
+	if !supported[tls.VersionName(tls.VersionTLS12)] && !supported[tls.VersionName(tls.VersionTLS13)] {
+		add("no_tls12", "Neither TLS 1.2 nor TLS 1.3 is supported", GradeC)
+	}
+	if weak[weakRC4] {
+		add("rc4", "RC4 cipher suites are accepted", GradeC)
+	}
+	if weak[weak3DES] {
+		add("3des", "3DES cipher suites are accepted (Sweet32)", GradeC)
+	}
+	switch {
+	case !pfs:
+		add("no_pfs", "No cipher suite with forward secrecy is accepted", GradeB)
+	case weak[weakNoPFS]:
+		add("partial_pfs", "Cipher suites without forward secrecy are accepted", GradeA)
+	}
+	if len(chain) > 0 {
+		leaf := chain[0]
+		if leaf.KeyType == "RSA" && leaf.KeySize < 2048 {
+			add("weak_key", fmt.Sprintf("The certificate has a %d-bit RSA key", leaf.KeySize), GradeB)
+		}
+		if strings.Contains(leaf.SignatureAlgorithm, "SHA1") || strings.Contains(leaf.SignatureAlgorithm, "MD5") {
+			add("weak_signature", "The certificate is signed with "+leaf.SignatureAlgorithm, GradeC)
+		}
+	}
+
+	result := GradeA
+	for _, f := range findings {
+		result = capGrade(result, f.Grade)
+	}
+	if !summary.Verified {
+		add("untrusted", "The certificate chain does not verify: "+summary.VerifyError, GradeT)
+		result = GradeT
+	}
+	return result, findings
+}
+
+// audit probes every protocol version and its cipher suites, streaming a
+// protocol message per version, and grades the server in summary
+func audit(ctx context.Context, session *tool.Session, opts TLSOptions, summary *SummaryMessage, chain []CertificateMessage) error {
+	var protocols []ProtocolMessage
+	for _, version := range auditVersions {
+		msg := probeVersion(ctx, session, opts, version)
+		if err := session.WriteJSON(msg); err != nil {
+			return err
+		}
+		protocols = append(protocols, msg)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	summary.Grade, summary.Findings = grade(*summary, chain, protocols)
+	return nil
+}
@@ -0,0 +1,309 @@
+package tlsinspect
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for TLS inspection options
+const (
+	defaultPort    = 443
+	defaultTimeout = 10 // 10 second timeout for connecting and the handshake
+)
+
+// defaultALPN are the protocols offered when a request names none
+var defaultALPN = []string{"h2", "http/1.1"}
+
+// TLSMessage represents the incoming TLS inspection request
+type TLSMessage struct {
+	// Required
+	Host string `json:"host"` // Host to connect to, optionally with a port
+
+	// Optional parameters
+	Port    *int     `json:"port,omitempty"`    // Port to connect to, 443 unless host has one
+	SNI     *string  `json:"sni,omitempty"`     // Server name sent, the host unless it is an IP address
+	ALPN    []string `json:"alpn,omitempty"`    // Protocols offered, h2 and http/1.1 by default
+	Timeout *int     `json:"timeout,omitempty"` // Timeout in seconds for connecting and each handshake
+	Audit   *bool    `json:"audit,omitempty"`   // Enumerate protocol versions and cipher suites and grade them
+}
+
+// CertificateMessage describes one certificate of the chain a server sent
+type CertificateMessage struct {
+	Type               string    `json:"type"`                      // Message type ("certificate")
+	Index              int       `json:"index"`                     // Position in the chain, 0 for the leaf
+	Subject            string    `json:"subject"`                   // Subject distinguished name
+	Issuer             string    `json:"issuer"`                    // Issuer distinguished name
+	Serial             string    `json:"serial"`                    // Serial number in hex
+	DNSNames           []string  `json:"dns_names,omitempty"`       // DNS subject alternative names
+	IPAddresses        []string  `json:"ip_addresses,omitempty"`    // IP address subject alternative names
+	EmailAddresses     []string  `json:"email_addresses,omitempty"` // Email subject alternative names
+	URIs               []string  `json:"uris,omitempty"`            // URI subject alternative names
+	NotBefore          time.Time `json:"not_before"`                // Start of validity
+	NotAfter           time.Time `json:"not_after"`                 // End of validity
+	DaysUntilExpiry    int       `json:"days_until_expiry"`         // Whole days until not_after, negative once expired
+	KeyType            string    `json:"key_type"`                  // Public key algorithm, e.g. RSA, ECDSA, Ed25519
+	KeySize            int       `json:"key_size"`                  // Public key size in bits
+	Curve              string    `json:"curve,omitempty"`           // Curve of ECDSA keys
+	SignatureAlgorithm string    `json:"signature_algorithm"`       // Algorithm the issuer signed with
+	IsCA               bool      `json:"is_ca"`                     // Whether the certificate may issue others
+	SelfSigned         bool      `json:"self_signed"`               // Whether subject and issuer match
+	Fingerprint        string    `json:"fingerprint"`               // SHA-256 fingerprint
+}
+
+// SummaryMessage describes the negotiated connection
+type SummaryMessage struct {
+	Type            string    `json:"type"`                   // Message type ("summary")
+	Host            string    `json:"host"`                   // Host that was inspected
+	Address         string    `json:"address,omitempty"`      // Address connected to
+	SNI             string    `json:"sni,omitempty"`          // Server name sent
+	Version         string    `json:"version,omitempty"`      // Negotiated protocol version, e.g. TLS 1.3
+	CipherSuite     string    `json:"cipher_suite,omitempty"` // Negotiated cipher suite
+	ALPN            string    `json:"alpn,omitempty"`         // Negotiated application protocol
+	Resumed         bool      `json:"resumed"`                // Whether a session was resumed
+	OCSPStapled     bool      `json:"ocsp_stapled"`           // Whether an OCSP response was stapled
+	Chain           int       `json:"chain"`                  // Certificates the server sent
+	Verified        bool      `json:"verified"`               // Whether the chain verifies for the server name
+	VerifyError     string    `json:"verify_error,omitempty"` // Why verification failed
+	DaysUntilExpiry int       `json:"days_until_expiry"`      // Whole days until the leaf certificate expires
+	Duration        float64   `json:"duration"`               // Connect and handshake time in milliseconds
+	Grade           string    `json:"grade,omitempty"`        // Letter grade of an audit, A to F, or T for an untrusted chain
+	Findings        []Finding `json:"findings,omitempty"`     // Weaknesses an audit found
+	Error           string    `json:"error,omitempty"`        // Error that prevented the handshake
+}
+
+// TLSOptions contains the resolved TLS inspection options
+type TLSOptions struct {
+	Host    string
+	Address string // host:port connected to
+	SNI     string
+	ALPN    []string
+	Timeout int
+	Audit   bool
+}
+
+// resolveTLSOptions converts TLSMessage to TLSOptions with defaults
+func resolveTLSOptions(msg *TLSMessage) (TLSOptions, error) {
+	opts := TLSOptions{
+		Host:    strings.TrimSpace(msg.Host),
+		ALPN:    msg.ALPN,
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		Audit:   tool.GetOrDefault(msg.Audit, false),
+	}
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	host, port := opts.Host, tool.GetOrDefault(msg.Port, defaultPort)
+	if h, p, err := net.SplitHostPort(opts.Host); err == nil {
+		if msg.Port != nil {
+			return opts, fmt.Errorf("port cannot be set when host has one")
+		}
+		if port, err = strconv.Atoi(p); err != nil {
+			return opts, fmt.Errorf("invalid port %q", p)
+		}
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if port <= 0 || port > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535")
+	}
+	opts.Host = host
+	opts.Address = net.JoinHostPort(host, strconv.Itoa(port))
+	if net.ParseIP(host) == nil {
+		opts.SNI = host
+	}
+	opts.SNI = tool.GetOrDefault(msg.SNI, opts.SNI)
+	if len(opts.ALPN) == 0 {
+		opts.ALPN = defaultALPN
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// daysUntil returns the whole days until t, negative once it passed
+func daysUntil(t time.Time) int {
+	return int(math.Floor(time.Until(t).Hours() / 24))
+}
+
+// publicKey returns the algorithm, size in bits and curve of a public key
+func publicKey(cert *x509.Certificate) (string, int, string) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen(), ""
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize, key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519", 256, ""
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0, ""
+	}
+}
+
+// describe converts a certificate of the chain to its message
+func describe(index int, cert *x509.Certificate) CertificateMessage {
+	sum := sha256.Sum256(cert.Raw)
+	keyType, keySize, curve := publicKey(cert)
+	msg := CertificateMessage{
+		Type:               "certificate",
+		Index:              index,
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		Serial:             cert.SerialNumber.Text(16),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysUntilExpiry:    daysUntil(cert.NotAfter),
+		KeyType:            keyType,
+		KeySize:            keySize,
+		Curve:              curve,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+		SelfSigned:         cert.Subject.String() == cert.Issuer.String(),
+		Fingerprint:        hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		msg.IPAddresses = append(msg.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		msg.URIs = append(msg.URIs, uri.String())
+	}
+	return msg
+}
+
+// verify checks the chain of state against the system roots for the server
+// name, or the host when no name was sent
+func verify(state tls.ConnectionState, opts TLSOptions) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	name := opts.SNI
+	if name == "" {
+		name = opts.Host
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: name, Intermediates: intermediates})
+	return err
+}
+
+// clientConfig returns the configuration handshakes with the server of opts
+// start from
+func clientConfig(opts TLSOptions) *tls.Config {
+	return &tls.Config{
+		ServerName: opts.SNI,
+		NextProtos: opts.ALPN,
+		// The chain is verified afterwards so that invalid certificates are
+		// reported rather than aborting the handshake
+		InsecureSkipVerify: true,
+	}
+}
+
+// handshake connects to the server of opts and completes a handshake with
+// config within the timeout of opts
+func handshake(ctx context.Context, opts TLSOptions, config *tls.Config) (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*tls.Conn), nil
+}
+
+// Inspect connects to the server of opts and completes a handshake,
+// returning the connection summary and the certificate chain sent
+func Inspect(ctx context.Context, opts TLSOptions) (SummaryMessage, []CertificateMessage) {
+	summary := SummaryMessage{Type: "summary", Host: opts.Host, SNI: opts.SNI}
+	start := time.Now()
+	conn, err := handshake(ctx, opts, clientConfig(opts))
+	summary.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		summary.Error = err.Error()
+		return summary, nil
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	summary.Address = conn.RemoteAddr().String()
+	summary.Version = tls.VersionName(state.Version)
+	summary.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	summary.ALPN = state.NegotiatedProtocol
+	summary.Resumed = state.DidResume
+	summary.OCSPStapled = len(state.OCSPResponse) > 0
+	summary.Chain = len(state.PeerCertificates)
+	if len(state.PeerCertificates) == 0 {
+		summary.VerifyError = "server sent no certificate"
+		return summary, nil
+	}
+
+	chain := make([]CertificateMessage, 0, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		chain = append(chain, describe(i, cert))
+	}
+	summary.DaysUntilExpiry = chain[0].DaysUntilExpiry
+	if err := verify(state, opts); err != nil {
+		summary.VerifyError = err.Error()
+	} else {
+		summary.Verified = true
+	}
+	return summary, chain
+}
+
+// Handler handles WebSocket TLS inspection requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "tls")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg TLSMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading TLS message: %v", err)
+		return
+	}
+
+	opts, err := resolveTLSOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid TLS options: %v", err)
+		return
+	}
+
+	session.CountProbe()
+	summary, chain := Inspect(r.Context(), opts)
+	for _, cert := range chain {
+		if err := session.WriteJSON(cert); err != nil {
+			log.Printf("Failed to send certificate: %v", err)
+			return
+		}
+	}
+	if opts.Audit && summary.Error == "" {
+		if err := audit(r.Context(), session, opts, &summary, chain); err != nil {
+			log.Printf("TLS audit of %s ended: %v", opts.Address, err)
+			return
+		}
+	}
+	log.Printf("TLS %s: %s %s, %d certificates, verified=%t, grade=%q", opts.Address, summary.Version, summary.CipherSuite, summary.Chain, summary.Verified, summary.Grade)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
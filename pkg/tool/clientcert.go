@@ -0,0 +1,109 @@
+package tool
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// Redacted replaces client certificate keys in stored requests
+const Redacted = "[redacted]"
+
+// clientCertField is the request field client certificates are sent in
+var clientCertField = []byte(`"client_cert"`)
+
+// ClientCertificate is a PEM encoded client certificate used for mutual TLS
+type ClientCertificate struct {
+	Cert string `json:"cert"` // PEM encoded certificate chain
+	Key  string `json:"key"`  // PEM encoded private key
+}
+
+// ClientAuthStatus reports how a server handled the client certificate
+type ClientAuthStatus struct {
+	Requested bool `json:"requested"` // Whether the server asked for a client certificate
+	Accepted  bool `json:"accepted"`  // Whether the server accepted the presented certificate
+}
+
+// ClientAuth presents a client certificate during TLS handshakes and records
+// whether the server asked for it
+type ClientAuth struct {
+	cert      tls.Certificate
+	requested atomic.Bool
+}
+
+// NewClientAuth parses a client certificate. It returns nil when no
+// certificate is given.
+func NewClientAuth(c *ClientCertificate) (*ClientAuth, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cert, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %w", err)
+	}
+	return &ClientAuth{cert: cert}, nil
+}
+
+// Configure makes cfg present the client certificate when the server requests one
+func (a *ClientAuth) Configure(cfg *tls.Config) {
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		a.requested.Store(true)
+		return &a.cert, nil
+	}
+}
+
+// Requested reports whether any server asked for the client certificate so far
+func (a *ClientAuth) Requested() bool {
+	return a.requested.Load()
+}
+
+// Status reports the client certificate outcome of an exchange, where
+// accepted tells whether the server went on to answer after the handshake
+func (a *ClientAuth) Status(accepted bool) ClientAuthStatus {
+	requested := a.Requested()
+	return ClientAuthStatus{Requested: requested, Accepted: requested && accepted}
+}
+
+// RedactClientKeys returns a request with the private key of any client_cert
+// object replaced by Redacted, so requests can be recorded, filed in the
+// history and saved as templates without keeping the key. Requests without
+// a client certificate are returned as they are.
+func RedactClientKeys(request []byte) []byte {
+	if !bytes.Contains(request, clientCertField) {
+		return request
+	}
+	var v any
+	if json.Unmarshal(request, &v) != nil || !redactClientKeys(v) {
+		return request
+	}
+	redacted, err := json.Marshal(v)
+	if err != nil {
+		return request
+	}
+	return redacted
+}
+
+// redactClientKeys replaces the keys of client_cert objects in a decoded
+// JSON value and reports whether it found any
+func redactClientKeys(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		for name, value := range v {
+			if cert, ok := value.(map[string]any); ok && name == "client_cert" {
+				if key, ok := cert["key"].(string); ok && key != "" && key != Redacted {
+					cert["key"] = Redacted
+					found = true
+				}
+			}
+			found = redactClientKeys(value) || found
+		}
+	case []any:
+		for _, value := range v {
+			found = redactClientKeys(value) || found
+		}
+	}
+	return found
+}
@@ -0,0 +1,89 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Limits applied to every JSON message accepted from clients
+const (
+	MaxMessageSize = 256 << 10 // Largest WebSocket message or REST body in bytes
+	MaxJSONDepth   = 32        // Deepest nesting of objects and arrays
+)
+
+// Errors returned for messages that break the decoding limits
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrMessageTooDeep  = errors.New("message nested too deeply")
+	ErrTrailingData    = errors.New("unexpected data after JSON value")
+)
+
+// DecodeJSON decodes exactly one JSON value from client supplied data into
+// v. Messages larger than MaxMessageSize or nested deeper than MaxJSONDepth
+// are rejected before decoding, as is anything following the value.
+func DecodeJSON(data []byte, v any) error {
+	if len(data) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	if err := checkDepth(data); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// DecodeRequest reads a REST request body of at most MaxMessageSize bytes
+// and decodes it with DecodeJSON
+func DecodeRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxMessageSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return ErrMessageTooLarge
+		}
+		return err
+	}
+	return DecodeJSON(data, v)
+}
+
+// checkDepth scans data for object and array nesting deeper than
+// MaxJSONDepth without decoding it. Syntax errors are left to the decoder.
+func checkDepth(data []byte) error {
+	depth := 0
+	inString, escaped := false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > MaxJSONDepth {
+				return ErrMessageTooDeep
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
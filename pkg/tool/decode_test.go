@@ -0,0 +1,80 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fuzzMessage mixes the field types tool messages use
+type fuzzMessage struct {
+	Address string            `json:"address"`
+	Count   *int              `json:"count,omitempty"`
+	Flag    *bool             `json:"flag,omitempty"`
+	Names   []string          `json:"names,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Nested  *fuzzMessage      `json:"nested,omitempty"`
+	Extra   json.RawMessage   `json:"extra,omitempty"`
+}
+
+func TestDecodeJSONLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"valid", `{"address": "example.com", "count": 3}`, nil},
+		{"trailing whitespace", "{\"address\": \"a\"}\n", nil},
+		{"brackets in strings", `{"address": "` + strings.Repeat("[{", MaxJSONDepth) + `"}`, nil},
+		{"max depth", strings.Repeat("[", MaxJSONDepth) + strings.Repeat("]", MaxJSONDepth), nil},
+		{"too deep", strings.Repeat("[", MaxJSONDepth+1) + strings.Repeat("]", MaxJSONDepth+1), ErrMessageTooDeep},
+		{"too large", `{"address": "` + strings.Repeat("a", MaxMessageSize) + `"}`, ErrMessageTooLarge},
+		{"trailing value", `{"address": "a"} {"address": "b"}`, ErrTrailingData},
+		{"trailing garbage", `{"address": "a"}x`, ErrTrailingData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := DecodeJSON([]byte(tt.data), &v); !errors.Is(err, tt.err) {
+				t.Errorf("DecodeJSON() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	seeds := []string{
+		`{"address": "example.com", "count": 3, "flag": true}`,
+		`{"address": "a", "names": ["x", "y"], "headers": {"Host": "h"}}`,
+		`{"nested": {"nested": {"address": "\"\\[{"}}}`,
+		`{"extra": [1, 2.5e10, null, {"k": "v"}]}`,
+		`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]`,
+		`{"address": "a"} trailing`,
+		`{"count": 99999999999999999999}`,
+		`"\ud800"`,
+		``,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg fuzzMessage
+		DecodeJSON(data, &msg)
+
+		// Within the limits DecodeJSON must agree with the standard decoder
+		var got, want any
+		err := DecodeJSON(data, &got)
+		wantErr := json.Unmarshal(data, &want)
+		switch {
+		case err == nil && wantErr != nil:
+			t.Fatalf("DecodeJSON accepted %q, json.Unmarshal failed: %v", data, wantErr)
+		case err == nil && len(data) > MaxMessageSize:
+			t.Fatalf("DecodeJSON accepted %d bytes, more than MaxMessageSize", len(data))
+		case errors.Is(err, ErrMessageTooDeep) || errors.Is(err, ErrMessageTooLarge):
+		case err != nil && wantErr == nil:
+			t.Fatalf("DecodeJSON rejected %q within the limits: %v", data, err)
+		}
+	})
+}
@@ -0,0 +1,343 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DemoTools are the tools a demo instance serves
+var DemoTools = []string{"ping", "dns", "traceroute"}
+
+// ErrRateLimited is returned when a demo client exceeds its request limits
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrNotPublic is returned when a demo request targets an address that is
+// not publicly routable
+var ErrNotPublic = errors.New("only public targets are allowed in demo mode")
+
+// demoWindow is the interval request limits are counted over
+const demoWindow = time.Minute
+
+// nonPublicPrefixes are the special purpose ranges IsGlobalUnicast and
+// IsPrivate leave out
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // This network
+	netip.MustParsePrefix("100.64.0.0/10"),   // Shared address space (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // TEST-NET-1
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // TEST-NET-2
+	netip.MustParsePrefix("203.0.113.0/24"),  // TEST-NET-3
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+}
+
+// DemoLimits are the limits demo mode applies to each client and session
+type DemoLimits struct {
+	Requests   int           // Requests per client and minute
+	Concurrent int           // Requests in progress per client, which includes open sessions
+	Duration   time.Duration // Longest session, after which it is closed
+	Count      int           // Most probes of a ping
+	Targets    int           // Most targets of a ping
+	Wait       time.Duration // Shortest interval between pings
+}
+
+// DefaultDemoLimits are used for limits a demo configuration leaves out
+var DefaultDemoLimits = DemoLimits{
+	Requests:   20,
+	Concurrent: 2,
+	Duration:   time.Minute,
+	Count:      10,
+	Targets:    3,
+	Wait:       time.Second,
+}
+
+// DemoMode restricts a public demo instance to a safe subset of tools,
+// public targets and low limits when enabled
+type DemoMode struct {
+	mu       sync.Mutex
+	enabled  bool
+	limits   DemoLimits
+	window   time.Time      // Start of the current request window
+	requests map[string]int // Requests of each client in the window
+	active   map[string]int // Requests in progress of each client
+}
+
+// Demo is the demo mode used by the tools it restricts
+var Demo = &DemoMode{limits: DefaultDemoLimits}
+
+// Configure enables demo mode with limits such as
+// "requests=20,concurrent=2,duration=1m,count=10,targets=3,wait=1s"
+func (d *DemoMode) Configure(spec string) error {
+	limits := DefaultDemoLimits
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid demo limit %q, want key=value", entry)
+		}
+		var err error
+		switch key {
+		case "requests":
+			limits.Requests, err = parsePositive(value)
+		case "concurrent":
+			limits.Concurrent, err = parsePositive(value)
+		case "count":
+			limits.Count, err = parsePositive(value)
+		case "targets":
+			limits.Targets, err = parsePositive(value)
+		case "duration":
+			limits.Duration, err = time.ParseDuration(value)
+		case "wait":
+			limits.Wait, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown limit")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid demo limit %q: %w", entry, err)
+		}
+	}
+	if limits.Duration <= 0 || limits.Wait <= 0 {
+		return fmt.Errorf("demo duration and wait must be positive")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.enabled = true
+	d.limits = limits
+	d.requests = make(map[string]int)
+	d.active = make(map[string]int)
+	return nil
+}
+
+// parsePositive parses a number of at least 1
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("must be a positive number")
+	}
+	return n, nil
+}
+
+// Enabled reports whether demo mode is on
+func (d *DemoMode) Enabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.enabled
+}
+
+// Limits returns the limits of demo mode
+func (d *DemoMode) Limits() DemoLimits {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.limits
+}
+
+// AllowCount reports whether a ping may send count probes, where 0 pings
+// continuously. Any count is allowed outside demo mode.
+func (d *DemoMode) AllowCount(count int) bool {
+	if !d.Enabled() {
+		return true
+	}
+	return count > 0 && count <= d.Limits().Count
+}
+
+// CheckTarget returns an error wrapping ErrNotPublic unless every address
+// target resolves to is public. Targets may be hosts, host:port pairs or
+// URLs; networks are refused. Every target is allowed outside demo mode.
+func (d *DemoMode) CheckTarget(ctx context.Context, target string) error {
+	if !d.Enabled() {
+		return nil
+	}
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" && u.Host != "" {
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.Contains(host, "/") {
+		return fmt.Errorf("%w: %s is a network", ErrNotPublic, host)
+	}
+	if host == "" {
+		return fmt.Errorf("%w: no target given", ErrNotPublic)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if addr = addr.Unmap(); !publicAddress(addr) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrNotPublic, host, addr)
+		}
+	}
+	return nil
+}
+
+// PinTarget checks a host:port target like CheckTarget and returns it with
+// the host replaced by the first address it resolved to, so the target
+// cannot be rebound to another address before it is dialed. Targets are
+// returned as they are outside demo mode.
+func (d *DemoMode) PinTarget(ctx context.Context, target string) (string, error) {
+	if !d.Enabled() {
+		return target, nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if addr = addr.Unmap(); !publicAddress(addr) {
+			return "", fmt.Errorf("%w: %s resolves to %s", ErrNotPublic, host, addr)
+		}
+	}
+	return net.JoinHostPort(addrs[0].Unmap().String(), port), nil
+}
+
+// CheckAddr returns an error wrapping ErrNotPublic when addr is not public.
+// Names are checked by CheckTarget when a request arrives; tools check the
+// address they resolved again before probing it, as the name may resolve
+// elsewhere by then. Every address is allowed outside demo mode.
+func (d *DemoMode) CheckAddr(addr net.IP) error {
+	if !d.Enabled() {
+		return nil
+	}
+	ip, ok := netip.AddrFromSlice(addr)
+	if !ok || !publicAddress(ip.Unmap()) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, addr)
+	}
+	return nil
+}
+
+// Control wraps the Control function of a net.Dialer, which may be nil, to
+// refuse connections to addresses that are not public in demo mode. It checks
+// the address actually dialed, so names cannot be rebound to other addresses
+// after CheckTarget resolved them.
+func (d *DemoMode) Control(next func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	if !d.Enabled() {
+		return next
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		if err := d.CheckAddr(net.ParseIP(host)); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(network, address, c)
+		}
+		return nil
+	}
+}
+
+// CheckRedirect refuses redirects to targets CheckTarget refuses, for use as
+// the CheckRedirect of HTTP clients
+func (d *DemoMode) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return d.CheckTarget(req.Context(), req.URL.Host)
+}
+
+// publicAddress reports whether addr is a globally routable unicast address
+func publicAddress(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// demoClient returns the key requests of a client are counted under: its
+// address, or its /64 for IPv6 clients, which usually hold a whole one
+func demoClient(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if addr = addr.Unmap(); addr.Is6() {
+		prefix, _ := addr.WithZone("").Prefix(64)
+		return prefix.String()
+	}
+	return addr.String()
+}
+
+// admit counts a request of client, returning the seconds until it may
+// retry when it is over its limits
+func (d *DemoMode) admit(client string) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Sub(d.window) >= demoWindow {
+		d.window = now
+		clear(d.requests)
+	}
+	retry := int(d.window.Add(demoWindow).Sub(now).Seconds()) + 1
+	if d.requests[client] >= d.limits.Requests {
+		return retry, false
+	}
+	if d.active[client] >= d.limits.Concurrent {
+		return 1, false
+	}
+	d.requests[client]++
+	d.active[client]++
+	return 0, true
+}
+
+// release ends a request of client admitted by admit
+func (d *DemoMode) release(client string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active[client]--; d.active[client] <= 0 {
+		delete(d.active, client)
+	}
+}
+
+// Limit is a middleware rejecting requests of clients over their demo
+// request limits with 429 Too Many Requests. It does nothing outside demo mode.
+func (d *DemoMode) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := demoClient(r.RemoteAddr)
+		retry, ok := d.admit(client)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			WriteError(w, http.StatusTooManyRequests, ErrRateLimited)
+			return
+		}
+		defer d.release(client)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxDurationSeconds is the longest Duration in seconds, about 292 years
+const maxDurationSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+// Duration is a time span in client messages, given either as a number of
+// seconds, which may be fractional, or as a duration string such as "200ms".
+// It is sent back to clients as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON accepts a number of seconds or a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("duration must be a number of seconds or a string such as \"200ms\"")
+	}
+	if seconds > maxDurationSeconds || seconds < -maxDurationSeconds {
+		return fmt.Errorf("duration of %g seconds is out of range", seconds)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON sends the duration as a number of seconds
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
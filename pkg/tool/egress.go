@@ -0,0 +1,157 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownPool is returned when a request names an egress pool that is not configured
+var ErrUnknownPool = errors.New("unknown egress pool")
+
+// EgressPool hands out source addresses for outbound probes in round-robin order
+type EgressPool struct {
+	addrs []net.IP
+	next  atomic.Uint64
+}
+
+// NewEgressPool parses a comma separated list of source IPs and interface
+// names. Interfaces contribute all of their unicast addresses.
+func NewEgressPool(spec string) (*EgressPool, error) {
+	pool := &EgressPool{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			pool.addrs = append(pool.addrs, ip)
+			continue
+		}
+		ips, err := interfaceAddrs(entry)
+		if err != nil {
+			return nil, err
+		}
+		pool.addrs = append(pool.addrs, ips...)
+	}
+	if len(pool.addrs) == 0 {
+		return nil, fmt.Errorf("egress pool %q has no addresses", spec)
+	}
+	return pool, nil
+}
+
+// interfaceAddrs returns the global unicast addresses of an interface
+func interfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress address or interface %q: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("error reading addresses of %s: %w", name, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable addresses", name)
+	}
+	return ips, nil
+}
+
+// Next returns the next source address of the pool
+func (p *EgressPool) Next() net.IP {
+	return p.addrs[(p.next.Add(1)-1)%uint64(len(p.addrs))]
+}
+
+// Addresses returns the addresses in the pool
+func (p *EgressPool) Addresses() []string {
+	addrs := make([]string, 0, len(p.addrs))
+	for _, ip := range p.addrs {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs
+}
+
+// EgressPools holds the default egress pool and the named pools that
+// requests can ask for, e.g. to keep a tenant on allow-listed addresses
+type EgressPools struct {
+	mu    sync.RWMutex
+	def   *EgressPool
+	named map[string]*EgressPool
+}
+
+// Egress is the process wide set of egress pools. Without configuration
+// probes use the source address the kernel picks.
+var Egress = &EgressPools{named: make(map[string]*EgressPool)}
+
+// Configure sets the default pool from a comma separated address list and
+// the named pools from a semicolon separated list of name=addresses entries,
+// e.g. "eu=10.0.0.1,10.0.0.2;us=eth1". Empty specs leave a pool unset.
+func (e *EgressPools) Configure(defaultSpec, namedSpec string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if defaultSpec != "" {
+		pool, err := NewEgressPool(defaultSpec)
+		if err != nil {
+			return err
+		}
+		e.def = pool
+	}
+	for _, entry := range strings.Split(namedSpec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid egress pool %q, want name=addresses", entry)
+		}
+		pool, err := NewEgressPool(spec)
+		if err != nil {
+			return err
+		}
+		e.named[strings.TrimSpace(name)] = pool
+	}
+	return nil
+}
+
+// Pick returns the next source address of the named pool, or of the default
+// pool when name is empty. It returns nil when no default pool is configured.
+func (e *EgressPools) Pick(name string) (net.IP, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if name == "" {
+		if e.def == nil {
+			return nil, nil
+		}
+		return e.def.Next(), nil
+	}
+	pool, ok := e.named[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPool, name)
+	}
+	return pool.Next(), nil
+}
+
+// Names returns the names of the configured named pools
+func (e *EgressPools) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	names := make([]string, 0, len(e.named))
+	for name := range e.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
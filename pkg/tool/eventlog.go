@@ -0,0 +1,52 @@
+package tool
+
+import (
+	"fmt"
+	"time"
+)
+
+// Events streamed to verbose sessions
+const (
+	EventResolved    = "resolved"     // A target was resolved to the address probed
+	EventRetry       = "retry"        // A probe is repeated after a failure
+	EventRateLimited = "rate_limited" // The server slowed down or ended the session to protect itself
+	EventClamped     = "clamped"      // An option was changed from what was requested
+	EventRejected    = "rejected"     // The request was refused before anything was sent
+	EventProbeFailed = "probe_failed" // A probe failed, with the error behind it
+	EventTruncated   = "truncated"    // A message was cut down to the write limit
+)
+
+// LogMessage is an internal event of the server about the session, sent to
+// verbose sessions so clients can see why a tool behaved as it did
+type LogMessage struct {
+	Type      string         `json:"type"`             // Message type ("log")
+	Timestamp time.Time      `json:"timestamp"`        // Time of the event
+	Event     string         `json:"event"`            // Kind of event, one of the Event constants
+	Message   string         `json:"message"`          // Human readable description
+	Fields    map[string]any `json:"fields,omitempty"` // Details of the event
+}
+
+// SetVerbose sets whether the session is sent log messages
+func (s *Session) SetVerbose(verbose bool) {
+	s.verbose.Store(verbose)
+}
+
+// Verbose reports whether the session is sent log messages
+func (s *Session) Verbose() bool {
+	return s.verbose.Load()
+}
+
+// Log sends an event to the client when the session is verbose. Events are
+// best effort: a failed write shows up on the handler's next write.
+func (s *Session) Log(event string, fields map[string]any, format string, args ...any) {
+	if !s.Verbose() {
+		return
+	}
+	s.WriteJSON(LogMessage{
+		Type:      "log",
+		Timestamp: time.Now(),
+		Event:     event,
+		Message:   fmt.Sprintf(format, args...),
+		Fields:    fields,
+	})
+}
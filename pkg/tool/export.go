@@ -0,0 +1,186 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Export formats
+const (
+	ExportJSON   = "json"   // JSON array of messages
+	ExportNDJSON = "ndjson" // One message per line
+	ExportCSV    = "csv"    // One row per message, one column per field
+)
+
+// exportContentTypes are the media types of the export formats
+var exportContentTypes = map[string]string{
+	ExportJSON:   "application/json",
+	ExportNDJSON: "application/x-ndjson",
+	ExportCSV:    "text/csv",
+}
+
+// bookkeeping are the message types of the session protocol itself, which
+// are not results
+var bookkeeping = []string{"session", "sync"}
+
+// exportedMessage is a result message of a recording
+type exportedMessage struct {
+	offset float64
+	data   json.RawMessage
+}
+
+// messageType returns the type field of a message
+func messageType(data []byte) string {
+	var msg struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &msg)
+	return msg.Type
+}
+
+// results returns the messages the server sent in rec, leaving out the
+// session protocol, optionally only those of the given types
+func results(rec *Recording, types []string) []exportedMessage {
+	var messages []exportedMessage
+	for _, msg := range rec.Messages {
+		if msg.Direction != DirectionOut {
+			continue
+		}
+		kind := messageType(msg.Data)
+		if slices.Contains(bookkeeping, kind) || (len(types) > 0 && !slices.Contains(types, kind)) {
+			continue
+		}
+		messages = append(messages, exportedMessage{offset: msg.Offset, data: msg.Data})
+	}
+	return messages
+}
+
+// objectFields returns the top-level fields of a JSON object in the order
+// they appear
+func objectFields(data []byte) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, fmt.Errorf("message is not a JSON object")
+	}
+	var names []string
+	values := make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		name, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+	return names, values, nil
+}
+
+// csvCell formats a field value: strings without quotes, null as empty and
+// anything else, such as nested objects, as its JSON
+func csvCell(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	if string(value) == "null" {
+		return ""
+	}
+	return string(value)
+}
+
+// writeCSV writes messages with a column per field seen in any of them, in
+// the order first seen, after the offset of each message
+func writeCSV(w http.ResponseWriter, messages []exportedMessage) {
+	columns := []string{"offset"}
+	rows := make([]map[string]json.RawMessage, 0, len(messages))
+	for _, msg := range messages {
+		names, values, err := objectFields(msg.data)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if !slices.Contains(columns, name) {
+				columns = append(columns, name)
+			}
+		}
+		values["offset"] = json.RawMessage(strconv.FormatFloat(msg.offset, 'f', 3, 64))
+		rows = append(rows, values)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write(columns)
+	record := make([]string, len(columns))
+	for _, values := range rows {
+		for i, column := range columns {
+			record[i] = csvCell(values[column])
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+}
+
+// ExportHandler serves the results of the session named by the id URL
+// parameter as a download, in the format of the format query parameter
+// (json, ndjson or csv, json by default). Sessions still running export
+// what they sent so far. The type query parameter, given once or more,
+// exports only messages of those types, e.g. type=pong.
+func (r *Recorder) ExportHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = ExportJSON
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, want json, ndjson or csv", format))
+		return
+	}
+	rec, err := r.Get(chi.URLParam(req, "id"))
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	var types []string
+	for _, value := range query["type"] {
+		types = append(types, strings.Split(value, ",")...)
+	}
+	messages := results(rec, types)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, rec.Tool, rec.ID, format))
+	switch format {
+	case ExportCSV:
+		writeCSV(w, messages)
+	case ExportNDJSON:
+		for _, msg := range messages {
+			w.Write(msg.data)
+			w.Write([]byte("\n"))
+		}
+	default:
+		data := make([]json.RawMessage, 0, len(messages))
+		for _, msg := range messages {
+			data = append(data, msg.data)
+		}
+		encoded, err := encodeJSON(data)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Write(encoded)
+	}
+}
@@ -0,0 +1,141 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHistory is the number of runs kept per user by default
+const defaultHistory = 50
+
+// historyTargetFields are the request fields naming what a tool ran
+// against, in order of preference
+var historyTargetFields = []string{"host", "target", "address", "name", "zone", "url", "domain", "query", "cidr", "targets", "hosts"}
+
+// summaryPrefix starts the summary message most tools end a session with
+var summaryPrefix = []byte(`{"type":"summary"`)
+
+// HistoryEntry is one run of a tool by a user
+type HistoryEntry struct {
+	ID       string          `json:"id"`                // Session ID, whose recording may still be kept
+	Tool     string          `json:"tool"`              // Tool that ran
+	Path     string          `json:"path"`              // Route of the tool, to connect to when re-running
+	Target   string          `json:"target,omitempty"`  // Host or other target the run was about
+	Started  time.Time       `json:"started"`           // When the session started
+	Duration float64         `json:"duration"`          // Milliseconds the session lasted
+	Request  json.RawMessage `json:"request"`           // Request as sent; sending it again re-runs the tool
+	Summary  json.RawMessage `json:"summary,omitempty"` // Last summary message sent, when the tool sends one
+}
+
+// HistoryStore keeps the recent runs of each user in memory
+type HistoryStore struct {
+	mu       sync.RWMutex
+	capacity int
+	entries  map[string][]HistoryEntry
+}
+
+// History is the run history sessions of identified users are added to
+var History = NewHistoryStore(defaultHistory)
+
+// NewHistoryStore creates a history keeping at most capacity runs per user
+func NewHistoryStore(capacity int) *HistoryStore {
+	return &HistoryStore{capacity: capacity, entries: make(map[string][]HistoryEntry)}
+}
+
+// SetCapacity changes how many runs are kept per user, 0 disables history
+func (h *HistoryStore) SetCapacity(capacity int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.capacity = capacity
+	for user, entries := range h.entries {
+		if len(entries) > capacity {
+			h.entries[user] = entries[len(entries)-capacity:]
+		}
+	}
+}
+
+// add appends a run to the history of user, dropping the oldest above capacity
+func (h *HistoryStore) add(user string, entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.capacity <= 0 {
+		return
+	}
+	entries := append(h.entries[user], entry)
+	if len(entries) > h.capacity {
+		entries = entries[len(entries)-h.capacity:]
+	}
+	h.entries[user] = entries
+}
+
+// List returns the runs of user, newest first, optionally only those of
+// one tool and at most limit of them when limit is positive
+func (h *HistoryStore) List(user, toolName string, limit int) []HistoryEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	entries := h.entries[user]
+	list := make([]HistoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0 && (limit <= 0 || len(list) < limit); i-- {
+		if toolName == "" || entries[i].Tool == toolName {
+			list = append(list, entries[i])
+		}
+	}
+	return list
+}
+
+// Get returns the run of user with the session ID
+func (h *HistoryStore) Get(user, id string) (HistoryEntry, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, entry := range h.entries[user] {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// Handler serves the history of the user identified by the request's
+// bearer token, filtered by the tool and limit query parameters
+func (h *HistoryStore) Handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			WriteError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+		limit = n
+	}
+	WriteJSON(w, http.StatusOK, h.List(user, r.URL.Query().Get("tool"), limit))
+}
+
+// historyTarget picks the target of a run from its request, joining lists
+// such as the targets of a multi-target ping with commas
+func historyTarget(request []byte) string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(request, &fields) != nil {
+		return ""
+	}
+	for _, name := range historyTargetFields {
+		var value string
+		if json.Unmarshal(fields[name], &value) == nil && value != "" {
+			return value
+		}
+		var values []string
+		if json.Unmarshal(fields[name], &values) == nil && len(values) > 0 {
+			return strings.Join(values, ",")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package tool
+
+import "net/http"
+
+// DefaultUserAgent identifies outbound HTTP probes unless overridden
+const DefaultUserAgent = "net-tools (+https://github.com/cksidharthan/net-tools)"
+
+// Identification sent with outbound HTTP probes so target operators can tell
+// who is probing them. Set once at startup with SetIdentity.
+var (
+	probeUserAgent = DefaultUserAgent
+	probeFrom      string
+)
+
+// SetIdentity sets the User-Agent and optional From header sent with
+// outbound HTTP probes. It must be called before any tool is served.
+func SetIdentity(userAgent, from string) {
+	probeUserAgent = userAgent
+	probeFrom = from
+}
+
+// Identify adds the identification headers to an outbound probe request.
+// Callers apply user supplied headers afterwards so they can override them.
+func Identify(req *http.Request) {
+	if probeUserAgent != "" {
+		req.Header.Set("User-Agent", probeUserAgent)
+	}
+	if probeFrom != "" {
+		req.Header.Set("From", probeFrom)
+	}
+}
@@ -0,0 +1,105 @@
+package tool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Default WebSocket message limits of tools without their own
+const (
+	DefaultReadLimit  = MaxMessageSize // Largest message read from clients in bytes
+	DefaultWriteLimit = 1 << 20        // Largest message sent to clients in bytes
+	minWriteLimit     = 1024           // Smallest write limit, which still fits a truncation marker
+	truncatedOverhead = 128            // Room left for the fields of a truncation marker
+)
+
+// MessageLimits bounds the size of the WebSocket messages of one tool
+type MessageLimits struct {
+	Read  int64 // Largest message accepted from the client, at most MaxMessageSize
+	Write int   // Largest message sent to the client; larger ones are truncated
+}
+
+// TruncatedMessage replaces a message larger than the write limit of a tool
+type TruncatedMessage struct {
+	Type   string `json:"type"`   // Message type ("truncated")
+	Size   int    `json:"size"`   // Size of the original message in bytes
+	Limit  int    `json:"limit"`  // Write limit of the tool in bytes
+	Prefix string `json:"prefix"` // Start of the original message
+}
+
+// MessageLimitTable holds the message limits of each tool
+type MessageLimitTable struct {
+	mu     sync.RWMutex
+	limits map[string]MessageLimits
+}
+
+// Limits is the message limit table used by Upgrade
+var Limits = &MessageLimitTable{limits: make(map[string]MessageLimits)}
+
+// Get returns the limits of the named tool, or the defaults
+func (t *MessageLimitTable) Get(toolName string) MessageLimits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if limits, ok := t.limits[toolName]; ok {
+		return limits
+	}
+	return MessageLimits{Read: DefaultReadLimit, Write: DefaultWriteLimit}
+}
+
+// Configure parses per tool limits in the form "ping=4096:65536;script=:4194304".
+// Either limit may be left empty to keep its default.
+func (t *MessageLimitTable) Configure(spec string) error {
+	limits := make(map[string]MessageLimits)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sizes, ok := strings.Cut(entry, "=")
+		readStr, writeStr, ok2 := strings.Cut(sizes, ":")
+		name = strings.TrimSpace(name)
+		if !ok || !ok2 || name == "" {
+			return fmt.Errorf("invalid message limits %q, want name=read:write", entry)
+		}
+		l := MessageLimits{Read: DefaultReadLimit, Write: DefaultWriteLimit}
+		if readStr = strings.TrimSpace(readStr); readStr != "" {
+			read, err := strconv.ParseInt(readStr, 10, 64)
+			if err != nil || read <= 0 || read > MaxMessageSize {
+				return fmt.Errorf("read limit of %s must be between 1 and %d bytes", name, MaxMessageSize)
+			}
+			l.Read = read
+		}
+		if writeStr = strings.TrimSpace(writeStr); writeStr != "" {
+			write, err := strconv.Atoi(writeStr)
+			if err != nil || write < minWriteLimit {
+				return fmt.Errorf("write limit of %s must be at least %d bytes", name, minWriteLimit)
+			}
+			l.Write = write
+		}
+		limits[name] = l
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits = limits
+	return nil
+}
+
+// truncate encodes a TruncatedMessage standing in for data, keeping as
+// much of data as fits within limit bytes once escaped
+func truncate(data []byte, limit int) ([]byte, error) {
+	n := min(len(data), limit-truncatedOverhead)
+	for {
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		encoded, err := encodeJSON(TruncatedMessage{Type: "truncated", Size: len(data), Limit: limit, Prefix: string(data[:n])})
+		if err != nil || len(encoded) <= limit || n == 0 {
+			return encoded, err
+		}
+		n = max(0, n-(len(encoded)-limit))
+	}
+}
@@ -0,0 +1,190 @@
+package tool
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load levels, from normal operation to rejecting new sessions
+const (
+	LoadNormal   = "normal"   // Everything runs at the requested rate
+	LoadElevated = "elevated" // Best-effort sessions are slowed down
+	LoadCritical = "critical" // New sessions are rejected
+)
+
+// Load shedding tuning
+const (
+	loadSampleInterval = time.Second // Time between resource usage samples
+	elevatedFraction   = 0.8         // Fraction of a limit at which load becomes elevated
+	shedRetryAfter     = 30          // Seconds clients are asked to wait after a rejection
+)
+
+// ErrOverloaded is returned when a session is rejected to shed load
+var ErrOverloaded = errors.New("server overloaded")
+
+// LoadLimits are the resource limits load shedding keeps the server under.
+// A zero limit is not enforced.
+type LoadLimits struct {
+	CPU     float64 // Fraction of all CPUs the process may use
+	Memory  uint64  // Heap bytes in use
+	FDs     float64 // Fraction of the open file limit
+	Sockets int     // Open sockets
+}
+
+// LoadSample is a snapshot of the server's resource usage and shedding
+type LoadSample struct {
+	Level      string  `json:"level"`      // Current load level
+	CPU        float64 `json:"cpu"`        // Fraction of all CPUs used since the previous sample
+	Memory     uint64  `json:"memory"`     // Heap bytes in use
+	FDs        int     `json:"fds"`        // Open file descriptors
+	FDLimit    int     `json:"fd_limit"`   // Open file limit
+	Sockets    int     `json:"sockets"`    // Open sockets
+	Goroutines int     `json:"goroutines"` // Running goroutines
+	Rejected   uint64  `json:"rejected"`   // Sessions rejected while critical
+	Slowed     uint64  `json:"slowed"`     // Best-effort probes delayed while elevated or critical
+}
+
+// LoadShedder samples the server's resource usage and sheds load before the
+// server runs out of CPU, memory or file descriptors
+type LoadShedder struct {
+	mu       sync.RWMutex
+	limits   LoadLimits
+	sample   LoadSample
+	lastCPU  time.Duration
+	lastTime time.Time
+	started  bool
+
+	rejected atomic.Uint64
+	slowed   atomic.Uint64
+}
+
+// Load is the process wide load shedder. It does nothing until started.
+var Load = &LoadShedder{sample: LoadSample{Level: LoadNormal}}
+
+// Start begins sampling resource usage against limits and publishes the
+// samples as the "load" expvar
+func (l *LoadShedder) Start(limits LoadLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.started {
+		return
+	}
+	l.started = true
+	l.limits = limits
+	l.lastCPU, _ = cpuTime()
+	l.lastTime = time.Now()
+
+	expvar.Publish("load", expvar.Func(func() any { return l.Snapshot() }))
+	go func() {
+		for range time.Tick(loadSampleInterval) {
+			l.update()
+		}
+	}()
+}
+
+// update takes a new sample and recomputes the load level
+func (l *LoadShedder) update() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	cpu, _ := cpuTime()
+	fds, sockets, _ := openFiles()
+	fdLimit, _ := fileLimit()
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sample := LoadSample{
+		Memory:     mem.HeapInuse,
+		FDs:        fds,
+		FDLimit:    fdLimit,
+		Sockets:    sockets,
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if elapsed := now.Sub(l.lastTime); elapsed > 0 {
+		sample.CPU = float64(cpu-l.lastCPU) / float64(elapsed) / float64(runtime.NumCPU())
+	}
+	l.lastCPU, l.lastTime = cpu, now
+
+	// The level follows whichever resource is closest to its limit
+	usage := 0.0
+	if l.limits.CPU > 0 {
+		usage = math.Max(usage, sample.CPU/l.limits.CPU)
+	}
+	if l.limits.Memory > 0 {
+		usage = math.Max(usage, float64(sample.Memory)/float64(l.limits.Memory))
+	}
+	if l.limits.FDs > 0 && fdLimit > 0 {
+		usage = math.Max(usage, float64(fds)/(float64(fdLimit)*l.limits.FDs))
+	}
+	if l.limits.Sockets > 0 {
+		usage = math.Max(usage, float64(sockets)/float64(l.limits.Sockets))
+	}
+	switch {
+	case usage >= 1:
+		sample.Level = LoadCritical
+	case usage >= elevatedFraction:
+		sample.Level = LoadElevated
+	default:
+		sample.Level = LoadNormal
+	}
+	if sample.Level != l.sample.Level {
+		log.Printf("Load level changed from %s to %s (cpu=%.2f heap=%d fds=%d sockets=%d)",
+			l.sample.Level, sample.Level, sample.CPU, sample.Memory, fds, sockets)
+	}
+	l.sample = sample
+}
+
+// Level returns the current load level
+func (l *LoadShedder) Level() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sample.Level
+}
+
+// Snapshot returns the latest sample with the shedding counters
+func (l *LoadShedder) Snapshot() LoadSample {
+	l.mu.RLock()
+	sample := l.sample
+	l.mu.RUnlock()
+	sample.Rejected = l.rejected.Load()
+	sample.Slowed = l.slowed.Load()
+	return sample
+}
+
+// Admit reports whether a new session may start. When load is critical it
+// responds with 503 and a Retry-After header and returns false.
+func (l *LoadShedder) Admit(w http.ResponseWriter) bool {
+	if l.Level() != LoadCritical {
+		return true
+	}
+	l.rejected.Add(1)
+	w.Header().Set("Retry-After", strconv.Itoa(shedRetryAfter))
+	WriteError(w, http.StatusServiceUnavailable, ErrOverloaded)
+	return false
+}
+
+// Backoff returns how much longer a best-effort session should wait before
+// its next probe: one extra interval when load is elevated and three when it
+// is critical, halving or quartering its rate
+func (l *LoadShedder) Backoff(interval time.Duration) time.Duration {
+	var delay time.Duration
+	switch l.Level() {
+	case LoadElevated:
+		delay = interval
+	case LoadCritical:
+		delay = 3 * interval
+	default:
+		return 0
+	}
+	l.slowed.Add(1)
+	return delay
+}
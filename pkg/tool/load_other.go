@@ -0,0 +1,26 @@
+//go:build !linux
+
+package tool
+
+import (
+	"errors"
+	"time"
+)
+
+// errUnsupported is returned for resource usage this platform cannot report
+var errUnsupported = errors.New("not supported on this platform")
+
+// cpuTime is only implemented on Linux
+func cpuTime() (time.Duration, error) {
+	return 0, errUnsupported
+}
+
+// openFiles is only implemented on Linux
+func openFiles() (int, int, error) {
+	return 0, 0, errUnsupported
+}
+
+// fileLimit is only implemented on Linux
+func fileLimit() (int, error) {
+	return 0, errUnsupported
+}
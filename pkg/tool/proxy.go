@@ -0,0 +1,64 @@
+package tool
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/cksidharthan/net-tools/pkg/proxyproto"
+)
+
+// ProxyPolicy lists the networks, such as those of L4 load balancers, whose
+// connections to the server's TCP listeners start with a PROXY protocol
+// header naming the real client
+type ProxyPolicy struct {
+	mu      sync.RWMutex
+	trusted []netip.Prefix
+}
+
+// ProxyProtocol is the process wide PROXY protocol policy. Without
+// configuration no headers are expected.
+var ProxyProtocol = &ProxyPolicy{}
+
+// Configure trusts a comma separated list of networks or addresses to send
+// PROXY headers, e.g. "10.0.0.0/8,192.0.2.10". An empty spec trusts none.
+func (p *ProxyPolicy) Configure(spec string) error {
+	var trusted []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return fmt.Errorf("invalid PROXY protocol network %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trusted = trusted
+	return nil
+}
+
+// Listen opens a TCP listener on addr that reads PROXY headers from trusted
+// peers
+func (p *ProxyPolicy) Listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.trusted) == 0 {
+		return ln, nil
+	}
+	return proxyproto.NewListener(ln, p.trusted), nil
+}
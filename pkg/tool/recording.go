@@ -0,0 +1,288 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// Recording limits
+const (
+	defaultRecordings   = 100       // Sessions kept by default
+	maxRecordedMessages = 10000     // Messages recorded per session
+	maxRecordedBytes    = 8 << 20   // Message data recorded per session
+	maxRecorderBytes    = 128 << 20 // Message data kept across all recordings
+	maxReplaySpeed      = 100.0     // Fastest replay speed multiplier
+	defaultReplaySpeed  = 1.0       // Replay at the original speed
+	replayWriteTimeout  = 10 * time.Second
+)
+
+// ErrUnknownSession is returned when a session ID has no recording
+var ErrUnknownSession = errors.New("unknown session")
+
+// RecordedMessage is a single message of a recorded session
+type RecordedMessage struct {
+	Offset    float64         `json:"offset"`    // Milliseconds since the session started
+	Direction string          `json:"direction"` // "in" (client to server) or "out"
+	Data      json.RawMessage `json:"data"`      // The message as sent on the wire
+}
+
+// Recording is the full message exchange of a session
+type Recording struct {
+	mu        sync.RWMutex
+	ID        string            `json:"id"`        // Session ID
+	Tool      string            `json:"tool"`      // Tool serving the session
+	Started   time.Time         `json:"started"`   // When the session started
+	Ended     *time.Time        `json:"ended"`     // When the session ended, nil while active
+	Truncated bool              `json:"truncated"` // Whether recording stopped at a limit
+	Messages  []RecordedMessage `json:"messages"`  // Messages in the order they were sent
+
+	bytes  int           // Size of the recorded message data
+	budget *atomic.Int64 // Message data of all kept recordings, nil when not kept
+}
+
+// RecordingSummary describes a recording without its messages
+type RecordingSummary struct {
+	ID       string     `json:"id"`       // Session ID
+	Tool     string     `json:"tool"`     // Tool serving the session
+	Started  time.Time  `json:"started"`  // When the session started
+	Ended    *time.Time `json:"ended"`    // When the session ended, nil while active
+	Messages int        `json:"messages"` // Number of recorded messages
+}
+
+// add appends a message to the recording, stopping the recording once it
+// reaches a limit
+func (rec *Recording) add(direction string, data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.Truncated {
+		return
+	}
+	if len(rec.Messages) >= maxRecordedMessages || rec.bytes+len(data) > maxRecordedBytes ||
+		rec.budget != nil && rec.budget.Load()+int64(len(data)) > maxRecorderBytes {
+		rec.Truncated = true
+		return
+	}
+	rec.Messages = append(rec.Messages, RecordedMessage{
+		Offset:    float64(time.Since(rec.Started).Microseconds()) / 1000.0,
+		Direction: direction,
+		Data:      append(json.RawMessage(nil), data...),
+	})
+	rec.bytes += len(data)
+	if rec.budget != nil {
+		rec.budget.Add(int64(len(data)))
+	}
+}
+
+// release drops the messages of an evicted recording, which is no longer
+// served, and stops recording it
+func (rec *Recording) release() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.budget != nil {
+		rec.budget.Add(-int64(rec.bytes))
+		rec.budget = nil
+	}
+	rec.Truncated, rec.Messages, rec.bytes = true, nil, 0
+}
+
+// size returns the number of message bytes held by the recording
+func (rec *Recording) size() int {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	return rec.bytes
+}
+
+// finish marks the recording as ended
+func (rec *Recording) finish() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.Ended == nil {
+		now := time.Now()
+		rec.Ended = &now
+	}
+}
+
+// summary returns the recording without its messages
+func (rec *Recording) summary() RecordingSummary {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	return RecordingSummary{
+		ID:       rec.ID,
+		Tool:     rec.Tool,
+		Started:  rec.Started,
+		Ended:    rec.Ended,
+		Messages: len(rec.Messages),
+	}
+}
+
+// snapshot returns a copy of the recording that is safe to read
+func (rec *Recording) snapshot() *Recording {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	return &Recording{
+		ID:        rec.ID,
+		Tool:      rec.Tool,
+		Started:   rec.Started,
+		Ended:     rec.Ended,
+		Truncated: rec.Truncated,
+		Messages:  append([]RecordedMessage(nil), rec.Messages...),
+	}
+}
+
+// Recorder keeps the recordings of the most recent sessions in memory
+type Recorder struct {
+	mu         sync.RWMutex
+	capacity   int
+	order      []string
+	recordings map[string]*Recording
+	bytes      atomic.Int64 // Message data held by the kept recordings
+}
+
+// Recordings is the recorder used by all sessions
+var Recordings = NewRecorder(defaultRecordings)
+
+// NewRecorder creates a recorder that keeps at most capacity sessions
+func NewRecorder(capacity int) *Recorder {
+	return &Recorder{
+		capacity:   capacity,
+		recordings: make(map[string]*Recording),
+	}
+}
+
+// SetCapacity changes how many sessions are kept, 0 disables recording
+func (r *Recorder) SetCapacity(capacity int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.capacity = capacity
+	r.evict()
+}
+
+// evict drops the oldest recordings above capacity, and while the kept
+// recordings leave less than one recording's worth of maxRecorderBytes for
+// the newest. Callers must hold mu.
+func (r *Recorder) evict() {
+	for len(r.order) > r.capacity || len(r.order) > 1 && r.bytes.Load() > maxRecorderBytes-maxRecordedBytes {
+		r.recordings[r.order[0]].release()
+		delete(r.recordings, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// start begins a new recording for a session
+func (r *Recorder) start(id, toolName string) *Recording {
+	rec := &Recording{ID: id, Tool: toolName, Started: time.Now(), Messages: []RecordedMessage{}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.capacity > 0 {
+		rec.budget = &r.bytes
+		r.recordings[id] = rec
+		r.order = append(r.order, id)
+		r.evict()
+	}
+	return rec
+}
+
+// Get returns a copy of the recording for a session
+func (r *Recorder) Get(id string) (*Recording, error) {
+	r.mu.RLock()
+	rec, ok := r.recordings[id]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+	return rec.snapshot(), nil
+}
+
+// List returns the summaries of all kept recordings, newest first
+func (r *Recorder) List() []RecordingSummary {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	summaries := make([]RecordingSummary, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		summaries = append(summaries, r.recordings[r.order[i]].summary())
+	}
+	return summaries
+}
+
+// ListHandler serves the summaries of all kept recordings
+func (r *Recorder) ListHandler(w http.ResponseWriter, req *http.Request) {
+	WriteJSON(w, http.StatusOK, r.List())
+}
+
+// GetHandler serves the recording of the session named by the id URL parameter
+func (r *Recorder) GetHandler(w http.ResponseWriter, req *http.Request) {
+	rec, err := r.Get(chi.URLParam(req, "id"))
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, rec)
+}
+
+// ReplayHandler replays the server messages of a recorded session over a
+// WebSocket. The speed query parameter scales the original timing; 0
+// replays as fast as possible.
+func (r *Recorder) ReplayHandler(w http.ResponseWriter, req *http.Request) {
+	rec, err := r.Get(chi.URLParam(req, "id"))
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	speed := defaultReplaySpeed
+	if value := req.URL.Query().Get("speed"); value != "" {
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || speed < 0 || speed > maxReplaySpeed {
+			WriteError(w, http.StatusBadRequest, errors.New("speed must be a number between 0 and 100"))
+			return
+		}
+	}
+
+	conn, err := Upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The request context is not cancelled when a hijacked connection is
+	// closed, so watch for the client going away by reading from it
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	replayStart := time.Now()
+	for _, msg := range rec.Messages {
+		if msg.Direction != DirectionOut {
+			continue
+		}
+		if speed > 0 {
+			due := time.Duration(msg.Offset / speed * float64(time.Millisecond))
+			select {
+			case <-time.After(time.Until(replayStart.Add(due))):
+			case <-closed:
+				return
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(replayWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, msg.Data); err != nil {
+			return
+		}
+	}
+}
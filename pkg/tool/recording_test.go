@@ -0,0 +1,63 @@
+package tool
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordingLimits(t *testing.T) {
+	r := NewRecorder(defaultRecordings)
+	rec := r.start("bytes", "ping")
+	chunk := make([]byte, maxRecordedBytes/4)
+	for range 4 {
+		rec.add(DirectionOut, chunk)
+	}
+	if rec.Truncated {
+		t.Fatal("recording truncated at exactly the byte limit")
+	}
+	rec.add(DirectionOut, []byte("{}"))
+	rec.add(DirectionIn, nil)
+	if !rec.Truncated || len(rec.Messages) != 4 || rec.size() != maxRecordedBytes {
+		t.Errorf("recording past the byte limit kept %d messages of %d bytes, truncated %t", len(rec.Messages), rec.size(), rec.Truncated)
+	}
+
+	rec = r.start("messages", "ping")
+	for range maxRecordedMessages + 1 {
+		rec.add(DirectionOut, []byte("{}"))
+	}
+	if !rec.Truncated || len(rec.Messages) != maxRecordedMessages {
+		t.Errorf("recording past the message limit kept %d messages, truncated %t", len(rec.Messages), rec.Truncated)
+	}
+}
+
+func TestRecorderEvictsByBytes(t *testing.T) {
+	r := NewRecorder(defaultRecordings)
+	full := make([]byte, maxRecordedBytes)
+	var first *Recording
+	for i := range maxRecorderBytes/maxRecordedBytes + 1 {
+		rec := r.start(fmt.Sprint(i), "ping")
+		rec.add(DirectionOut, full)
+		if first == nil {
+			first = rec
+		}
+	}
+	if got := r.bytes.Load(); got > maxRecorderBytes {
+		t.Fatalf("recordings hold %d bytes, more than %d", got, maxRecorderBytes)
+	}
+	if _, err := r.Get("0"); err == nil {
+		t.Error("oldest recording kept past the byte budget")
+	}
+	if !first.Truncated || first.size() != 0 {
+		t.Error("evicted recording still records")
+	}
+
+	// Recordings that are not kept do not count against the budget
+	r.SetCapacity(0)
+	if got := r.bytes.Load(); got != 0 {
+		t.Errorf("recordings hold %d bytes after disabling recording", got)
+	}
+	r.start("off", "ping").add(DirectionOut, full)
+	if got := r.bytes.Load(); got != 0 {
+		t.Errorf("unkept recording counted %d bytes", got)
+	}
+}
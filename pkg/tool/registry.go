@@ -0,0 +1,235 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ErrUnknownTool is returned when a tool name is not registered
+var ErrUnknownTool = errors.New("unknown tool")
+
+// Tool describes a tool served by the server
+type Tool struct {
+	Name        string           // Unique tool name used by the admin API
+	Path        string           // Route the tool is served on
+	Description string           // Short human readable description
+	Handler     http.HandlerFunc // Handler serving the tool
+}
+
+// Capability is the public description of a tool and its state
+type Capability struct {
+	Name        string `json:"name"`        // Tool name
+	Path        string `json:"path"`        // Route the tool is served on
+	Description string `json:"description"` // Short human readable description
+	Enabled     bool   `json:"enabled"`     // Whether the tool currently accepts requests
+}
+
+// registryState is the persisted part of the registry
+type registryState struct {
+	Disabled []string `json:"disabled"` // Names of disabled tools
+}
+
+// Registry keeps track of registered tools and whether they are enabled.
+// The set of disabled tools is persisted to a state file when one is set.
+type Registry struct {
+	mu        sync.RWMutex
+	tools     map[string]Tool
+	disabled  map[string]bool
+	statePath string
+}
+
+// NewRegistry creates a registry, loading the disabled tools from statePath
+// if it exists. An empty statePath keeps the state in memory only.
+func NewRegistry(statePath string) (*Registry, error) {
+	r := &Registry{
+		tools:     make(map[string]Tool),
+		disabled:  make(map[string]bool),
+		statePath: statePath,
+	}
+	if statePath == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	var state registryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %w", err)
+	}
+	for _, name := range state.Disabled {
+		r.disabled[name] = true
+	}
+	return r, nil
+}
+
+// Register adds a tool to the registry
+func (r *Registry) Register(t Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[t.Name] = t
+}
+
+// Restrict removes every tool not named in names, before the registry is
+// mounted
+func (r *Registry) Restrict(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	for name := range r.tools {
+		if !keep[name] {
+			delete(r.tools, name)
+		}
+	}
+}
+
+// Lookup returns the named tool
+func (r *Registry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Enabled reports whether the named tool is registered and enabled
+func (r *Registry) Enabled(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.tools[name]
+	return ok && !r.disabled[name]
+}
+
+// SetEnabled enables or disables a tool and persists the new state
+func (r *Registry) SetEnabled(name string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tools[name]; !ok {
+		return ErrUnknownTool
+	}
+	if enabled {
+		delete(r.disabled, name)
+	} else {
+		r.disabled[name] = true
+	}
+	return r.save()
+}
+
+// save writes the disabled tools to the state file. Callers must hold mu.
+func (r *Registry) save() error {
+	if r.statePath == "" {
+		return nil
+	}
+
+	state := registryState{Disabled: []string{}}
+	for name := range r.disabled {
+		state.Disabled = append(state.Disabled, name)
+	}
+	sort.Strings(state.Disabled)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(r.statePath, data); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file first and renames it into
+// place, so a crash never leaves a torn file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Capabilities returns every registered tool sorted by name
+func (r *Registry) Capabilities() []Capability {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	caps := make([]Capability, 0, len(r.tools))
+	for _, t := range r.tools {
+		caps = append(caps, Capability{
+			Name:        t.Name,
+			Path:        t.Path,
+			Description: t.Description,
+			Enabled:     !r.disabled[t.Name],
+		})
+	}
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Name < caps[j].Name })
+	return caps
+}
+
+// Mount registers a route for every tool, rejecting requests to disabled tools
+func (r *Registry) Mount(router chi.Router) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tools {
+		name, handler := t.Name, t.Handler
+		router.HandleFunc(t.Path, func(w http.ResponseWriter, req *http.Request) {
+			if !r.Enabled(name) {
+				WriteError(w, http.StatusServiceUnavailable, fmt.Errorf("tool %s is disabled", name))
+				return
+			}
+			handler(w, req)
+		})
+	}
+}
+
+// CapabilitiesHandler serves the list of tools and their state
+func (r *Registry) CapabilitiesHandler(w http.ResponseWriter, req *http.Request) {
+	WriteJSON(w, http.StatusOK, r.Capabilities())
+}
+
+// SetEnabledHandler serves PUT /api/admin/tools/{name} with {"enabled": bool}
+func (r *Registry) SetEnabledHandler(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := DecodeRequest(w, req, &body); err != nil || body.Enabled == nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("expected a JSON body with an enabled field"))
+		return
+	}
+
+	name := chi.URLParam(req, "name")
+	if err := r.SetEnabled(name, *body.Enabled); err != nil {
+		if errors.Is(err, ErrUnknownTool) {
+			WriteError(w, http.StatusNotFound, fmt.Errorf("%w: %s", err, name))
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, r.Capabilities())
+}
@@ -0,0 +1,175 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// Limits of runs started over the REST API
+const (
+	defaultRunTimeout = time.Minute      // How long a run may take by default
+	maxRunTimeout     = 10 * time.Minute // Longest run, as the caller waits for it to finish
+)
+
+// RunResult is the outcome of a run started over the REST API
+type RunResult struct {
+	Session   string            `json:"session"`   // ID of the new session, whose recording may be kept
+	Tool      string            `json:"tool"`      // Tool that ran
+	Request   json.RawMessage   `json:"request"`   // Request sent to the tool
+	Messages  []json.RawMessage `json:"messages"`  // Messages the tool sent, after the session message
+	TimedOut  bool              `json:"timed_out"` // Whether the run was ended at the timeout
+	Truncated bool              `json:"truncated"` // Whether messages beyond the recording limit were dropped
+}
+
+// pipeListener accepts a single in-memory connection, serving it with the
+// router without going through a socket
+type pipeListener struct {
+	conn      net.Conn
+	accepted  sync.Once
+	closeOnce sync.Once // Serve closes the listener too
+	closed    chan struct{}
+}
+
+// Accept returns the connection once, then blocks until the listener closes
+func (l *pipeListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.accepted.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+// Close ends a blocked Accept
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr returns the address of the in-memory connection
+func (l *pipeListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Runner runs tools on behalf of REST requests by opening a WebSocket
+// session to the router in memory, so runs go through the same handlers,
+// limits, recordings and history as sessions of clients
+type Runner struct {
+	handler http.Handler
+}
+
+// NewRunner creates a runner for tools served by handler
+func NewRunner(handler http.Handler) *Runner {
+	return &Runner{handler: handler}
+}
+
+// Run connects to the tool at path with the bearer token in header, so the
+// run joins its user's history, sends request and collects the tool's
+// messages until it ends the session or timeout passes
+func (rn *Runner) Run(ctx context.Context, path string, header http.Header, request json.RawMessage, timeout time.Duration) (RunResult, int, error) {
+	result := RunResult{Request: request, Messages: []json.RawMessage{}}
+	client, server := net.Pipe()
+	ln := &pipeListener{conn: server, closed: make(chan struct{})}
+	defer ln.Close()
+	go http.Serve(ln, rn.handler)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer := websocket.Dialer{
+		NetDialContext: func(context.Context, string, string) (net.Conn, error) { return client, nil },
+	}
+	// Only the bearer token carries over; signatures cover the original
+	// request's URI and nonce
+	handshake := http.Header{}
+	if value := header.Get("Authorization"); value != "" {
+		handshake.Set("Authorization", value)
+	}
+	conn, resp, err := dialer.DialContext(ctx, "ws://net-tools"+path, handshake)
+	if err != nil {
+		client.Close()
+		if resp != nil {
+			return result, resp.StatusCode, fmt.Errorf("tool refused the session: %s", resp.Status)
+		}
+		return result, http.StatusBadGateway, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+
+	var session SessionMessage
+	if err := conn.ReadJSON(&session); err != nil {
+		return result, http.StatusBadGateway, fmt.Errorf("error reading session message: %w", err)
+	}
+	result.Session, result.Tool = session.ID, session.Tool
+	if err := conn.WriteMessage(websocket.TextMessage, request); err != nil {
+		return result, http.StatusBadGateway, err
+	}
+	size := 0
+	for {
+		_, data, err := conn.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			result.TimedOut = true
+			break
+		}
+		if err != nil {
+			break
+		}
+		if !result.Truncated && len(result.Messages) < maxRecordedMessages && size+len(data) <= maxRecordedBytes {
+			result.Messages = append(result.Messages, data)
+			size += len(data)
+		} else {
+			result.Truncated = true
+		}
+	}
+	return result, http.StatusOK, nil
+}
+
+// runTimeout reads the timeout query parameter of a run, e.g. "30s"
+func runTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return defaultRunTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 || timeout > maxRunTimeout {
+		return 0, fmt.Errorf("timeout must be a duration up to %s", maxRunTimeout)
+	}
+	return timeout, nil
+}
+
+// RerunHandler runs the request of the caller's history entry named by the
+// id URL parameter again and returns the tool's messages
+func (rn *Runner) RerunHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	entry, ok := History.Get(user, chi.URLParam(r, "id"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, ErrUnknownSession)
+		return
+	}
+	timeout, err := runTimeout(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, status, err := rn.Run(r.Context(), entry.Path, r.Header, entry.Request, timeout)
+	if err != nil {
+		WriteError(w, status, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,297 @@
+package tool
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeTimeout bounds how long Close waits for the client to acknowledge
+const closeTimeout = time.Second
+
+// ErrInvalidMessage wraps decoding errors returned by ReadJSON, which leave
+// the connection usable unlike read errors
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Message directions in a recording
+const (
+	DirectionIn  = "in"  // Client to server
+	DirectionOut = "out" // Server to client
+)
+
+// SessionMessage is sent as the first frame of every session
+type SessionMessage struct {
+	Type       string    `json:"type"`        // Message type ("session")
+	ID         string    `json:"id"`          // Session ID used by the sessions API
+	Tool       string    `json:"tool"`        // Tool serving the session
+	ServerTime time.Time `json:"server_time"` // Server clock, which a SyncMessage may answer
+}
+
+// TextMessage carries one line of classic CLI output
+type TextMessage struct {
+	Type string `json:"type"` // Message type ("text")
+	Line string `json:"line"` // Output line without a trailing newline
+}
+
+// Session is a WebSocket session with a client whose messages are recorded
+type Session struct {
+	ID      string
+	Tool    string
+	Remote  string
+	Started time.Time
+
+	conn      *websocket.Conn
+	rec       *Recording
+	user      string // User identified by the handshake, whose runs are kept in History
+	path      string
+	request   []byte     // First message read, the tool's request
+	summary   []byte     // Last summary message written
+	histMu    sync.Mutex // Guards request and summary, as the locks of reads and writes are held while blocked
+	limits    MessageLimits
+	expiry    *time.Timer // Closes the session at the demo duration limit
+	announced time.Time   // When the session message was sent, starting the round trip a SyncMessage completes
+	readMu    sync.Mutex  // Serializes reads, which Close also performs
+	read      bool        // Whether a message was read, guarded by readMu
+	writeMu   sync.Mutex  // Serializes writes from the goroutines of a handler
+
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	probes      atomic.Uint64
+	goroutines  atomic.Int64
+	verbose     atomic.Bool  // Whether log messages are sent, see Log
+	synced      atomic.Bool  // Whether the client synced its clock, see SyncMessage
+	clockOffset atomic.Int64 // Client clock minus server clock in nanoseconds
+}
+
+// newSessionID returns a random, unguessable session ID
+func newSessionID() string {
+	p := make([]byte, 16)
+	rand.Read(p)
+	return hex.EncodeToString(p)
+}
+
+// Upgrade upgrades the request to a WebSocket session for the named tool,
+// starts recording it and announces the session ID to the client. New
+// sessions are rejected with ErrOverloaded while load is critical, and closed
+// after the session duration limit in demo mode.
+func Upgrade(w http.ResponseWriter, r *http.Request, toolName string) (*Session, error) {
+	if !Load.Admit(w) {
+		return nil, ErrOverloaded
+	}
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	limits := Limits.Get(toolName)
+	conn.SetReadLimit(limits.Read)
+
+	s := &Session{
+		ID:      newSessionID(),
+		Tool:    toolName,
+		Remote:  r.RemoteAddr,
+		Started: time.Now(),
+		conn:    conn,
+		limits:  limits,
+		user:    Users.Identify(r),
+		path:    r.URL.Path,
+	}
+	s.rec = Recordings.start(s.ID, toolName)
+	ActiveSessions.add(s)
+	if Demo.Enabled() {
+		duration := Demo.Limits().Duration
+		s.expiry = time.AfterFunc(duration, func() {
+			s.Log(EventRateLimited, map[string]any{"duration": duration.String()}, "session duration limit of %s reached", duration)
+			s.kill()
+		})
+	}
+
+	s.announced = time.Now()
+	if err := s.WriteJSON(SessionMessage{Type: "session", ID: s.ID, Tool: toolName, ServerTime: s.announced}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error writing session message: %w", err)
+	}
+	return s, nil
+}
+
+// ReadJSON reads the next JSON message from the client into v. Messages
+// breaking the DecodeJSON limits are rejected, and oversized frames end the
+// connection. Decoding errors wrap ErrInvalidMessage. A SyncMessage sent
+// first is answered here, and the message after it read instead.
+func (s *Session) ReadJSON(v any) error {
+	s.readMu.Lock()
+	_, data, err := s.conn.ReadMessage()
+	received, first := time.Now(), !s.read
+	s.read = true
+	s.readMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.bytesIn.Add(uint64(len(data)))
+	s.rec.add(DirectionIn, RedactClientKeys(data))
+	if first {
+		if msg, ok := ParseSync(data); ok {
+			if err := s.sync(msg, received); err != nil {
+				return fmt.Errorf("error writing sync message: %w", err)
+			}
+			return s.ReadJSON(v)
+		}
+	}
+	if err := DecodeJSON(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	if s.user != "" {
+		s.histMu.Lock()
+		if s.request == nil {
+			s.request = RedactClientKeys(data)
+		}
+		s.histMu.Unlock()
+	}
+	return nil
+}
+
+// WriteJSON sends v to the client as a JSON message. Messages larger than
+// the write limit of the tool are replaced by a TruncatedMessage. It is safe
+// to call from several goroutines.
+func (s *Session) WriteJSON(v any) error {
+	data, err := encodeJSON(v)
+	if err != nil {
+		return err
+	}
+	if s.synced.Load() {
+		data = s.withClientTimestamp(data)
+	}
+	if len(data) > s.limits.Write {
+		log.Printf("Truncating %d byte %s message to the %d byte limit", len(data), s.Tool, s.limits.Write)
+		s.Log(EventTruncated, map[string]any{"size": len(data), "limit": s.limits.Write}, "%d byte message truncated to the %d byte limit", len(data), s.limits.Write)
+		if data, err = truncate(data, s.limits.Write); err != nil {
+			return err
+		}
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	s.bytesOut.Add(uint64(len(data)))
+	s.rec.add(DirectionOut, data)
+	if s.user != "" && bytes.HasPrefix(data, summaryPrefix) {
+		s.histMu.Lock()
+		s.summary = data
+		s.histMu.Unlock()
+	}
+	return nil
+}
+
+// encodeJSON encodes v without a trailing newline. Text output such as dig
+// headers contains <, > and &, which are clearer unescaped.
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// WriteText sends a line of classic CLI output to the client
+func (s *Session) WriteText(line string) error {
+	return s.WriteJSON(TextMessage{Type: "text", Line: line})
+}
+
+// WriteControl sends a WebSocket control frame such as a ping
+func (s *Session) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return s.conn.WriteControl(messageType, data, deadline)
+}
+
+// Close finishes the recording and closes the connection. It sends a close
+// frame and drains the client's remaining frames first, as closing a socket
+// with unread data resets it and can discard the last messages sent.
+func (s *Session) Close() error {
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	ActiveSessions.remove(s)
+	s.rec.finish()
+	s.addHistory()
+	deadline := time.Now().Add(closeTimeout)
+	if err := s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline); err == nil {
+		// The deadline also ends a read in progress in another goroutine,
+		// which would otherwise hold readMu until the client closes
+		s.conn.SetReadDeadline(deadline)
+		s.readMu.Lock()
+		for {
+			if _, _, err := s.conn.NextReader(); err != nil {
+				break
+			}
+		}
+		s.readMu.Unlock()
+	}
+	return s.conn.Close()
+}
+
+// addHistory adds the session to the history of its user once it made a
+// request
+func (s *Session) addHistory() {
+	s.histMu.Lock()
+	request, summary := s.request, s.summary
+	s.histMu.Unlock()
+	if s.user == "" || request == nil {
+		return
+	}
+	History.add(s.user, HistoryEntry{
+		ID:       s.ID,
+		Tool:     s.Tool,
+		Path:     s.path,
+		Target:   historyTarget(request),
+		Started:  s.Started,
+		Duration: float64(time.Since(s.Started).Microseconds()) / 1000.0,
+		Request:  request,
+		Summary:  summary,
+	})
+}
+
+// CountProbe records that the session sent a probe, for usage accounting
+func (s *Session) CountProbe() {
+	s.probes.Add(1)
+}
+
+// Go runs fn in a goroutine that is counted in the session's usage
+func (s *Session) Go(fn func()) {
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Add(-1)
+		fn()
+	}()
+}
+
+// Usage returns the approximate resource usage of the session
+func (s *Session) Usage() SessionUsage {
+	return SessionUsage{
+		ID:         s.ID,
+		Tool:       s.Tool,
+		Remote:     s.Remote,
+		Started:    s.Started,
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+		Buffered:   s.rec.size(),
+		Probes:     s.probes.Load(),
+		Goroutines: s.goroutines.Load() + 1,
+	}
+}
+
+// kill closes the connection without a closing handshake, making the
+// handler's next read or write fail so it ends the session
+func (s *Session) kill() error {
+	return s.conn.NetConn().Close()
+}
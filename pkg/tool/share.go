@@ -0,0 +1,220 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Share link limits
+const (
+	defaultShareTTL     = 24 * time.Hour
+	maxShareTTL         = 7 * 24 * time.Hour
+	maxSharesPerSession = 10    // Unexpired tokens of one session
+	maxShares           = 10000 // Unexpired tokens of all sessions
+)
+
+// ErrUnknownShare is returned for share tokens that do not exist or have expired
+var ErrUnknownShare = errors.New("unknown or expired share token")
+
+// ErrTooManyShares is returned when a session or the server has as many
+// unexpired share tokens as allowed
+var ErrTooManyShares = errors.New("too many share links")
+
+// ShareRequest is the optional body of a share request
+type ShareRequest struct {
+	TTL *string `json:"ttl,omitempty"` // Link lifetime as a Go duration, e.g. "24h"
+}
+
+// ShareResponse describes a newly created share link
+type ShareResponse struct {
+	Token   string    `json:"token"`    // Share token
+	URL     string    `json:"url"`      // Read-only page for the results
+	JSONURL string    `json:"json_url"` // Read-only JSON endpoint for the results
+	Expires time.Time `json:"expires"`  // When the link stops working
+}
+
+// SharedResults is the read-only view of a session exposed through a share link
+type SharedResults struct {
+	Tool    string            `json:"tool"`    // Tool that produced the results
+	Started time.Time         `json:"started"` // When the session started
+	Ended   *time.Time        `json:"ended"`   // When the session ended, nil while active
+	Expires time.Time         `json:"expires"` // When the link stops working
+	Results []json.RawMessage `json:"results"` // Messages the server sent in order
+}
+
+// share maps a token to the session it exposes
+type share struct {
+	sessionID string
+	expires   time.Time
+}
+
+// Shares issues and resolves share tokens for recorded sessions
+type Shares struct {
+	mu       sync.Mutex
+	recorder *Recorder
+	shares   map[string]share
+}
+
+// SharedSessions is the share store backed by the session recordings
+var SharedSessions = NewShares(Recordings)
+
+// NewShares creates a share store for the recordings kept by recorder
+func NewShares(recorder *Recorder) *Shares {
+	return &Shares{recorder: recorder, shares: make(map[string]share)}
+}
+
+// Create issues a token exposing the session's results until ttl elapses
+func (s *Shares) Create(sessionID string, ttl time.Duration) (string, time.Time, error) {
+	if _, err := s.recorder.Get(sessionID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := newSessionID()
+	expires := time.Now().Add(ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge()
+	if len(s.shares) >= maxShares {
+		return "", time.Time{}, fmt.Errorf("%w: at most %d can be active", ErrTooManyShares, maxShares)
+	}
+	count := 0
+	for _, sh := range s.shares {
+		if sh.sessionID == sessionID {
+			count++
+		}
+	}
+	if count >= maxSharesPerSession {
+		return "", time.Time{}, fmt.Errorf("%w: at most %d can be active per session", ErrTooManyShares, maxSharesPerSession)
+	}
+	s.shares[token] = share{sessionID: sessionID, expires: expires}
+	return token, expires, nil
+}
+
+// purge drops expired tokens. Callers must hold mu.
+func (s *Shares) purge() {
+	now := time.Now()
+	for token, sh := range s.shares {
+		if now.After(sh.expires) {
+			delete(s.shares, token)
+		}
+	}
+}
+
+// Resolve returns the results exposed by a token
+func (s *Shares) Resolve(token string) (*SharedResults, error) {
+	s.mu.Lock()
+	s.purge()
+	sh, ok := s.shares[token]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownShare
+	}
+
+	rec, err := s.recorder.Get(sh.sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("session recording is no longer available: %w", err)
+	}
+
+	results := &SharedResults{
+		Tool:    rec.Tool,
+		Started: rec.Started,
+		Ended:   rec.Ended,
+		Expires: sh.expires,
+		Results: []json.RawMessage{},
+	}
+	for _, msg := range rec.Messages {
+		if msg.Direction != DirectionOut {
+			continue
+		}
+		// The session message carries the session ID, which grants more
+		// access than the read-only link, so it is never shared
+		var header struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(msg.Data, &header) == nil && header.Type == "session" {
+			continue
+		}
+		results.Results = append(results.Results, msg.Data)
+	}
+	return results, nil
+}
+
+// CreateHandler serves POST /api/sessions/{id}/share
+func (s *Shares) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var body ShareRequest
+	if r.ContentLength != 0 {
+		if err := DecodeRequest(w, r, &body); err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid share request: %w", err))
+			return
+		}
+	}
+
+	ttl := defaultShareTTL
+	if body.TTL != nil {
+		var err error
+		ttl, err = time.ParseDuration(*body.TTL)
+		if err != nil || ttl <= 0 || ttl > maxShareTTL {
+			WriteError(w, http.StatusBadRequest, fmt.Errorf("ttl must be a duration between 0 and %s", maxShareTTL))
+			return
+		}
+	}
+
+	token, expires, err := s.Create(chi.URLParam(r, "id"), ttl)
+	if errors.Is(err, ErrTooManyShares) {
+		WriteError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, ShareResponse{
+		Token:   token,
+		URL:     "/share/" + token,
+		JSONURL: "/api/share/" + token,
+		Expires: expires,
+	})
+}
+
+// JSONHandler serves GET /api/share/{token}
+func (s *Shares) JSONHandler(w http.ResponseWriter, r *http.Request) {
+	results, err := s.Resolve(chi.URLParam(r, "token"))
+	if err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, results)
+}
+
+// sharePage renders shared results as a plain read-only page
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Tool}} results</title></head>
+<body>
+<h1>{{.Tool}} results</h1>
+<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}{{if .Ended}}, ended {{.Ended.Format "2006-01-02 15:04:05 MST"}}{{else}}, still running{{end}}.
+This link expires {{.Expires.Format "2006-01-02 15:04:05 MST"}}.</p>
+<pre>{{range .Results}}{{printf "%s" .}}
+{{end}}</pre>
+</body>
+</html>
+`))
+
+// PageHandler serves GET /share/{token}
+func (s *Shares) PageHandler(w http.ResponseWriter, r *http.Request) {
+	results, err := s.Resolve(chi.URLParam(r, "token"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	sharePage.Execute(w, results)
+}
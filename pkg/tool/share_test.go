@@ -0,0 +1,50 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShareLimits(t *testing.T) {
+	r := NewRecorder(defaultRecordings)
+	r.start("a", "ping")
+	r.start("b", "ping")
+	s := NewShares(r)
+
+	for range maxSharesPerSession {
+		if _, _, err := s.Create("a", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := s.Create("a", time.Hour); !errors.Is(err, ErrTooManyShares) {
+		t.Errorf("Create() past the session limit error = %v, want ErrTooManyShares", err)
+	}
+	if _, _, err := s.Create("b", time.Hour); err != nil {
+		t.Errorf("Create() for another session error = %v", err)
+	}
+
+	// Expired tokens no longer count and are swept when any token is looked up
+	s.mu.Lock()
+	for token, sh := range s.shares {
+		sh.expires = time.Now().Add(-time.Second)
+		s.shares[token] = sh
+	}
+	s.mu.Unlock()
+	if _, err := s.Resolve("missing"); !errors.Is(err, ErrUnknownShare) {
+		t.Errorf("Resolve() error = %v, want ErrUnknownShare", err)
+	}
+	if len(s.shares) != 0 {
+		t.Errorf("%d expired tokens kept after a lookup", len(s.shares))
+	}
+
+	s.mu.Lock()
+	for i := range maxShares {
+		s.shares[fmt.Sprint(i)] = share{sessionID: fmt.Sprint(i), expires: time.Now().Add(time.Hour)}
+	}
+	s.mu.Unlock()
+	if _, _, err := s.Create("b", time.Hour); !errors.Is(err, ErrTooManyShares) {
+		t.Errorf("Create() past the total limit error = %v, want ErrTooManyShares", err)
+	}
+}
@@ -0,0 +1,158 @@
+package tool
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers carrying an HMAC request signature
+const (
+	SignatureHeader = "X-Signature"           // Hex encoded HMAC-SHA256 of the canonical request
+	TimestampHeader = "X-Signature-Timestamp" // Unix time the request was signed at
+	NonceHeader     = "X-Signature-Nonce"     // Unique value per request
+)
+
+// Limits for signed requests
+const (
+	signatureWindow = 5 * time.Minute // Largest accepted clock difference
+	maxNonceLength  = 128
+	maxNonces       = 100000  // Nonces remembered before new signed requests are refused
+	maxSignedBody   = 1 << 20 // Largest request body that is signed
+)
+
+// nonceCache remembers the nonces of recent signed requests so they cannot be replayed
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// use records nonce, reporting false when it was already used. Nonces are
+// forgotten once their timestamp falls outside the signature window.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	if len(c.seen) >= maxNonces {
+		for n, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, n)
+			}
+		}
+		if len(c.seen) >= maxNonces {
+			return false
+		}
+	}
+	c.seen[nonce] = now.Add(2 * signatureWindow)
+	return true
+}
+
+// Sign returns the signature of a request: the HMAC-SHA256 over the
+// timestamp, nonce, method, path with query and body, separated by newlines
+func Sign(secret, timestamp, nonce, method, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n", timestamp, nonce, method, uri)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the signature headers of r, restoring the body for
+// the next handler
+func verifySignature(r *http.Request, secret string, nonces *nonceCache) error {
+	signature := r.Header.Get(SignatureHeader)
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return errors.New("missing signature headers")
+	}
+	if len(nonce) > maxNonceLength {
+		return errors.New("nonce too long")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return errors.New("signature timestamp outside the allowed window")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return fmt.Errorf("error reading body: %w", err)
+	}
+	if len(body) > maxSignedBody {
+		return errors.New("request body too large to sign")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := Sign(secret, timestamp, nonce, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	// The nonce is only consumed by correctly signed requests, so forged
+	// requests cannot burn nonces of legitimate clients
+	if !nonces.use(nonce, now) {
+		return errors.New("nonce already used")
+	}
+	return nil
+}
+
+// RequireAuth rejects requests that carry neither the bearer token nor a
+// valid HMAC signature made with secret. Either credential is disabled when
+// it is empty.
+func RequireAuth(token, secret string) func(http.Handler) http.Handler {
+	nonces := &nonceCache{seen: make(map[string]time.Time)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret != "" && r.Header.Get(SignatureHeader) != "" {
+				if err := verifySignature(r, secret, nonces); err != nil {
+					WriteError(w, http.StatusUnauthorized, err)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				WriteError(w, http.StatusUnauthorized, errors.New("invalid or missing credentials"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerFromQuery moves an access_token query parameter (RFC 6750) into the
+// Authorization header, for browser WebSocket clients that cannot set
+// headers. It strips the token from the URL, so it must run before the
+// request logger.
+func BearerFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if token := query.Get("access_token"); token != "" {
+			if r.Header.Get("Authorization") == "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("access_token")
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
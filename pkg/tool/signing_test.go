@@ -0,0 +1,36 @@
+package tool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAuthBearer(t *testing.T) {
+	handler := RequireAuth("secret", "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "bearer token", authorization: "Bearer secret", status: http.StatusOK},
+		{name: "bare token", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic secret", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer other", status: http.StatusUnauthorized},
+		{name: "empty token", authorization: "Bearer ", status: http.StatusUnauthorized},
+		{name: "missing", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/admin", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package tool
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency distributions of simulated probes
+const (
+	DistConstant  = "constant"  // Always the base latency
+	DistUniform   = "uniform"   // Uniform within the base latency plus or minus the jitter
+	DistNormal    = "normal"    // Normal around the base latency with the jitter as deviation
+	DistLogNormal = "lognormal" // Long tailed with the base latency as median
+)
+
+// minSimulatedLatency keeps sampled latencies positive
+const minSimulatedLatency = 50 * time.Microsecond
+
+// SimulationProfile describes the synthetic results of simulated probes
+type SimulationProfile struct {
+	Dist    string        // Latency distribution
+	Latency time.Duration // Base latency of a target, before per target variation
+	Jitter  time.Duration // Spread of the distribution
+	Loss    float64       // Fraction of probes that are lost
+	Down    float64       // Fraction of targets that never answer
+	Seed    uint64        // Seed making the results of each target repeatable
+}
+
+// DefaultSimulationProfile is used for settings a profile leaves out
+var DefaultSimulationProfile = SimulationProfile{
+	Dist:    DistLogNormal,
+	Latency: 30 * time.Millisecond,
+	Jitter:  5 * time.Millisecond,
+	Loss:    0.01,
+}
+
+// Simulator replaces real probes with synthetic results when enabled
+type Simulator struct {
+	mu      sync.RWMutex
+	enabled bool
+	profile SimulationProfile
+}
+
+// Simulation is the simulator used by probes
+var Simulation = &Simulator{profile: DefaultSimulationProfile}
+
+// Configure enables simulation with a profile such as
+// "dist=normal,latency=20ms,jitter=4ms,loss=0.02,down=0.5"
+func (s *Simulator) Configure(spec string, seed uint64) error {
+	profile := DefaultSimulationProfile
+	profile.Seed = seed
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid simulation setting %q, want key=value", entry)
+		}
+		var err error
+		switch key {
+		case "dist":
+			switch value {
+			case DistConstant, DistUniform, DistNormal, DistLogNormal:
+				profile.Dist = value
+			default:
+				err = fmt.Errorf("unknown distribution %q", value)
+			}
+		case "latency":
+			profile.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			profile.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			profile.Loss, err = parseFraction(value)
+		case "down":
+			profile.Down, err = parseFraction(value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid simulation setting %q: %w", entry, err)
+		}
+	}
+	if profile.Latency <= 0 || profile.Jitter < 0 {
+		return fmt.Errorf("simulated latency must be positive and jitter not negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = true
+	s.profile = profile
+	return nil
+}
+
+// parseFraction parses a number between 0 and 1
+func parseFraction(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1")
+	}
+	return f, nil
+}
+
+// Enabled reports whether probes are simulated
+func (s *Simulator) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled
+}
+
+// SimulatedTarget produces the results of probes to one target. The same
+// target and seed always produce the same sequence of results.
+type SimulatedTarget struct {
+	profile SimulationProfile
+	base    time.Duration // Base latency of this target
+	down    bool
+	rng     *rand.Rand
+}
+
+// Target returns the simulated results for a target. Each target gets its
+// own base latency between half and one and a half times the profile's.
+func (s *Simulator) Target(target string) *SimulatedTarget {
+	s.mu.RLock()
+	profile := s.profile
+	s.mu.RUnlock()
+
+	h := fnv.New64a()
+	h.Write([]byte(target))
+	// The first draws pick the traits of the target, later ones its probes
+	rng := rand.New(rand.NewPCG(profile.Seed, h.Sum64()))
+	return &SimulatedTarget{
+		profile: profile,
+		base:    time.Duration(float64(profile.Latency) * (0.5 + rng.Float64())),
+		down:    rng.Float64() < profile.Down,
+		rng:     rng,
+	}
+}
+
+// Probe returns the round-trip time of the next probe, or false if it is lost
+func (t *SimulatedTarget) Probe() (time.Duration, bool) {
+	if t.down {
+		return 0, false
+	}
+	lost := t.rng.Float64() < t.profile.Loss
+
+	base, jitter := float64(t.base), float64(t.profile.Jitter)
+	var rtt float64
+	switch t.profile.Dist {
+	case DistConstant:
+		rtt = base
+	case DistUniform:
+		rtt = base + (t.rng.Float64()*2-1)*jitter
+	case DistNormal:
+		rtt = base + t.rng.NormFloat64()*jitter
+	default:
+		rtt = base * math.Exp(t.rng.NormFloat64()*jitter/base)
+	}
+	if lost {
+		return 0, false
+	}
+	return max(time.Duration(rtt), minSimulatedLatency), true
+}
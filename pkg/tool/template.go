@@ -0,0 +1,251 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxTemplates is the number of templates each user can save
+const maxTemplates = 100
+
+// templateName matches valid template names
+var templateName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ErrUnknownTemplate is returned when a user has no template of a name
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// Template is a named request a user saved for a tool
+type Template struct {
+	Name        string          `json:"name"`                  // Name unique per user
+	Tool        string          `json:"tool"`                  // Tool the request is for
+	Description string          `json:"description,omitempty"` // What the template is for
+	Request     json.RawMessage `json:"request"`               // Request object sent to the tool
+	Updated     time.Time       `json:"updated"`               // When the template was last saved
+}
+
+// TemplateStore keeps the templates of each user, persisting them to a file
+// when one is set
+type TemplateStore struct {
+	mu        sync.RWMutex
+	path      string
+	templates map[string]map[string]Template // By user, then name
+	registry  *Registry
+	runner    *Runner
+}
+
+// NewTemplateStore creates a template store for the tools of registry,
+// loading saved templates from path if it exists. An empty path keeps
+// templates in memory only.
+func NewTemplateStore(path string, registry *Registry, runner *Runner) (*TemplateStore, error) {
+	s := &TemplateStore{
+		path:      path,
+		templates: make(map[string]map[string]Template),
+		registry:  registry,
+		runner:    runner,
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading templates file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.templates); err != nil {
+		return nil, fmt.Errorf("error parsing templates file: %w", err)
+	}
+	return s, nil
+}
+
+// save writes all templates to the file. Callers must hold mu.
+func (s *TemplateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.templates, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("error writing templates file: %w", err)
+	}
+	return nil
+}
+
+// get returns the template of user with the name
+func (s *TemplateStore) get(user, name string) (Template, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.templates[user][name]
+	return t, ok
+}
+
+// mergeRequest overlays the fields of overrides on a request object
+func mergeRequest(request, overrides json.RawMessage) (json.RawMessage, error) {
+	// null decodes to a nil map without error
+	var fields, extra map[string]json.RawMessage
+	if json.Unmarshal(request, &fields) != nil || fields == nil {
+		return nil, errors.New("request must be a JSON object")
+	}
+	if json.Unmarshal(overrides, &extra) != nil || extra == nil {
+		return nil, errors.New("overrides must be a JSON object")
+	}
+	for name, value := range extra {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
+
+// ListHandler serves the caller's templates sorted by name
+func (s *TemplateStore) ListHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	s.mu.RLock()
+	list := make([]Template, 0, len(s.templates[user]))
+	for _, t := range s.templates[user] {
+		list = append(list, t)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// GetHandler serves the caller's template named by the name URL parameter
+func (s *TemplateStore) GetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	t, ok := s.get(user, chi.URLParam(r, "name"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, ErrUnknownTemplate)
+		return
+	}
+	WriteJSON(w, http.StatusOK, t)
+}
+
+// PutHandler saves the template in the body under the name URL parameter,
+// replacing any template of that name
+func (s *TemplateStore) PutHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var t Template
+	if err := DecodeRequest(w, r, &t); err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.Name, t.Updated = chi.URLParam(r, "name"), time.Now().UTC()
+	if !templateName.MatchString(t.Name) {
+		WriteError(w, http.StatusBadRequest, errors.New("template names are 1 to 64 letters, digits, dots, dashes or underscores"))
+		return
+	}
+	if _, ok := s.registry.Lookup(t.Tool); !ok {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("%w %q", ErrUnknownTool, t.Tool))
+		return
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(t.Request, &fields) != nil || fields == nil {
+		WriteError(w, http.StatusBadRequest, errors.New("request must be a JSON object"))
+		return
+	}
+	t.Request = RedactClientKeys(t.Request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	templates := s.templates[user]
+	if templates == nil {
+		templates = make(map[string]Template)
+		s.templates[user] = templates
+	}
+	if _, exists := templates[t.Name]; !exists && len(templates) >= maxTemplates {
+		WriteError(w, http.StatusConflict, fmt.Errorf("at most %d templates can be saved", maxTemplates))
+		return
+	}
+	templates[t.Name] = t
+	if err := s.save(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, t)
+}
+
+// DeleteHandler removes the caller's template named by the name URL parameter
+func (s *TemplateStore) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.templates[user][name]; !ok {
+		WriteError(w, http.StatusNotFound, ErrUnknownTemplate)
+		return
+	}
+	delete(s.templates[user], name)
+	if err := s.save(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunHandler runs the caller's template named by the name URL parameter,
+// with the fields of an optional JSON object body overriding those of the
+// saved request, and returns the tool's messages
+func (s *TemplateStore) RunHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	t, ok := s.get(user, chi.URLParam(r, "name"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, ErrUnknownTemplate)
+		return
+	}
+	tool, ok := s.registry.Lookup(t.Tool)
+	if !ok {
+		WriteError(w, http.StatusNotFound, fmt.Errorf("%w %q", ErrUnknownTool, t.Tool))
+		return
+	}
+	timeout, err := runTimeout(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := t.Request
+	if r.ContentLength != 0 {
+		var overrides json.RawMessage
+		if err := DecodeRequest(w, r, &overrides); err != nil {
+			WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if request, err = mergeRequest(request, overrides); err != nil {
+			WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	result, status, err := s.runner.Run(r.Context(), tool.Path, r.Header, request, timeout)
+	if err != nil {
+		WriteError(w, status, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, result)
+}
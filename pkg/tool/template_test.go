@@ -0,0 +1,92 @@
+package tool
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMergeRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   string
+		overrides string
+		want      string
+		wantErr   string
+	}{
+		{name: "override", request: `{"host":"a","count":4}`, overrides: `{"host":"b"}`, want: `{"count":4,"host":"b"}`},
+		{name: "add field", request: `{"host":"a"}`, overrides: `{"count":1}`, want: `{"count":1,"host":"a"}`},
+		{name: "empty overrides", request: `{"host":"a"}`, overrides: `{}`, want: `{"host":"a"}`},
+		{name: "null request", request: `null`, overrides: `{"host":"b"}`, wantErr: "request must be a JSON object"},
+		{name: "array request", request: `["host"]`, overrides: `{"host":"b"}`, wantErr: "request must be a JSON object"},
+		{name: "null overrides", request: `{"host":"a"}`, overrides: `null`, wantErr: "overrides must be a JSON object"},
+		{name: "string overrides", request: `{"host":"a"}`, overrides: `"b"`, wantErr: "overrides must be a JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeRequest(json.RawMessage(tt.request), json.RawMessage(tt.overrides))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("mergeRequest() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeRequest() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeRequest() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutTemplate(t *testing.T) {
+	if err := Users.Configure("alice=secret"); err != nil {
+		t.Fatal(err)
+	}
+	defer Users.Configure("")
+	registry, err := NewRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Register(Tool{Name: "ping", Path: "/ws/ping"})
+	store, err := NewTemplateStore("", registry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Put("/api/templates/{name}", store.PutHandler)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "object", body: `{"tool":"ping","request":{"host":"example.com"}}`, status: http.StatusOK},
+		{name: "empty object", body: `{"tool":"ping","request":{}}`, status: http.StatusOK},
+		{name: "null request", body: `{"tool":"ping","request":null}`, status: http.StatusBadRequest},
+		{name: "missing request", body: `{"tool":"ping"}`, status: http.StatusBadRequest},
+		{name: "array request", body: `{"tool":"ping","request":[{"host":"example.com"}]}`, status: http.StatusBadRequest},
+		{name: "string request", body: `{"tool":"ping","request":"example.com"}`, status: http.StatusBadRequest},
+		{name: "unknown tool", body: `{"tool":"nope","request":{}}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/templates/check", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if _, ok := store.get("alice", "check"); !ok {
+		t.Error("valid template was not saved")
+	}
+}
@@ -0,0 +1,76 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// syncType is the type of time sync messages
+var syncType = []byte(`"sync"`)
+
+// SyncMessage is the optional time sync exchange at the start of a session.
+// A client answers the session message with its clock as client_time, before
+// sending its request, and the server replies with the offset of the client
+// clock. The session message going out and the sync coming back form a
+// round trip the server times, so the offset is corrected for the network
+// delay like an NTP exchange.
+type SyncMessage struct {
+	Type       string    `json:"type"`        // Message type ("sync")
+	ClientTime float64   `json:"client_time"` // Client clock in Unix milliseconds, e.g. Date.now()
+	ServerTime time.Time `json:"server_time"` // Time the server received the sync, in the reply
+	Offset     float64   `json:"offset"`      // Client clock minus server clock in milliseconds, in the reply
+	RTT        float64   `json:"rtt"`         // Round trip of the exchange in milliseconds, in the reply
+}
+
+// ParseSync returns the sync message data holds, if it is one
+func ParseSync(data []byte) (SyncMessage, bool) {
+	var msg SyncMessage
+	if !bytes.Contains(data, syncType) || json.Unmarshal(data, &msg) != nil || msg.Type != "sync" || msg.ClientTime <= 0 {
+		return msg, false
+	}
+	return msg, true
+}
+
+// SyncReply answers a sync message received at received for a session
+// announced at announced. The client clock is assumed to have been read
+// halfway through the round trip.
+func SyncReply(msg SyncMessage, announced, received time.Time) SyncMessage {
+	rtt := received.Sub(announced)
+	midpoint := announced.Add(rtt / 2)
+	clientTime := time.UnixMicro(int64(msg.ClientTime * 1000))
+	return SyncMessage{
+		Type:       "sync",
+		ClientTime: msg.ClientTime,
+		ServerTime: received,
+		Offset:     float64(clientTime.Sub(midpoint).Microseconds()) / 1000.0,
+		RTT:        float64(rtt.Microseconds()) / 1000.0,
+	}
+}
+
+// sync replies to a sync message of the client and starts adding client
+// timestamps to the messages sent
+func (s *Session) sync(msg SyncMessage, received time.Time) error {
+	reply := SyncReply(msg, s.announced, received)
+	s.clockOffset.Store(int64(reply.Offset * float64(time.Millisecond)))
+	s.synced.Store(true)
+	return s.WriteJSON(reply)
+}
+
+// withClientTimestamp adds the timestamp of a message shifted to the client
+// clock as client_timestamp, so clients can place results on their own
+// timeline. Messages without a timestamp are returned as they are.
+func (s *Session) withClientTimestamp(data []byte) []byte {
+	var stamped struct {
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if len(data) < 2 || data[len(data)-1] != '}' || json.Unmarshal(data, &stamped) != nil || stamped.Timestamp.IsZero() {
+		return data
+	}
+	client := stamped.Timestamp.Add(time.Duration(s.clockOffset.Load()))
+	out := make([]byte, 0, len(data)+64)
+	out = append(out, data[:len(data)-1]...)
+	out = append(out, `,"client_timestamp":"`...)
+	out = client.AppendFormat(out, time.RFC3339Nano)
+	return append(out, `"}`...)
+}
@@ -0,0 +1,63 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketBuffer is the WebSocket read and write buffer size in bytes
+const websocketBuffer = 1024
+
+// Output formats supported by tools that can mimic classic CLI output
+const (
+	FormatJSON = "json" // Structured JSON messages only
+	FormatText = "text" // JSON messages plus pre-formatted text lines
+)
+
+// Upgrader is the WebSocket upgrader shared by all tool handlers
+var Upgrader = websocket.Upgrader{
+	ReadBufferSize:  websocketBuffer,
+	WriteBufferSize: websocketBuffer,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// GetOrDefault is a helper function to handle optional values
+func GetOrDefault[T any](value *T, defaultValue T) T {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
+
+// ValidateFormat checks that format is a supported output format
+func ValidateFormat(format string) error {
+	if format != FormatJSON && format != FormatText {
+		return fmt.Errorf("unsupported format %q", format)
+	}
+	return nil
+}
+
+// ErrorResponse is the body of REST error responses
+type ErrorResponse struct {
+	Error string `json:"error"` // Error message
+}
+
+// WriteJSON writes v as a JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// WriteError writes err as a JSON error response with the given status code
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, ErrorResponse{Error: err.Error()})
+}
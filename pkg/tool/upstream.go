@@ -0,0 +1,26 @@
+package tool
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSExchanger sends DNS queries to upstream nameservers
+type DNSExchanger interface {
+	// Exchange sends msg to server over network ("udp" or "tcp") and returns
+	// the response and its round-trip time
+	Exchange(msg *dns.Msg, network, server string, timeout time.Duration) (*dns.Msg, time.Duration, error)
+}
+
+// directExchanger sends queries on the wire
+type directExchanger struct{}
+
+func (directExchanger) Exchange(msg *dns.Msg, network, server string, timeout time.Duration) (*dns.Msg, time.Duration, error) {
+	client := &dns.Client{Net: network, Timeout: timeout}
+	return client.Exchange(msg, server)
+}
+
+// UpstreamDNS sends the queries of tools that talk to nameservers directly.
+// Tests replace it to record and replay upstream interactions.
+var UpstreamDNS DNSExchanger = directExchanger{}
@@ -0,0 +1,115 @@
+package tool
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionUsage is the approximate resource usage of an active session
+type SessionUsage struct {
+	ID         string    `json:"id"`         // Session ID
+	Tool       string    `json:"tool"`       // Tool serving the session
+	Remote     string    `json:"remote"`     // Client address
+	Started    time.Time `json:"started"`    // When the session started
+	BytesIn    uint64    `json:"bytes_in"`   // Message bytes received from the client
+	BytesOut   uint64    `json:"bytes_out"`  // Message bytes sent to the client
+	Buffered   int       `json:"buffered"`   // Message bytes held in memory by the recording
+	Probes     uint64    `json:"probes"`     // Probes executed for the session
+	Goroutines int64     `json:"goroutines"` // Goroutines serving the session, including the handler
+}
+
+// AdminSession is a session as listed by the admin API: its recording
+// summary plus its usage while it is still active
+type AdminSession struct {
+	RecordingSummary
+	Usage *SessionUsage `json:"usage,omitempty"` // Resource usage, set while the session is active
+}
+
+// SessionRegistry keeps track of the active sessions
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// ActiveSessions is the registry of all active sessions
+var ActiveSessions = &SessionRegistry{sessions: make(map[string]*Session)}
+
+// add registers an active session
+func (a *SessionRegistry) add(s *Session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[s.ID] = s
+}
+
+// remove unregisters a session that ended
+func (a *SessionRegistry) remove(s *Session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, s.ID)
+}
+
+// Usage returns the usage of every active session
+func (a *SessionRegistry) Usage() map[string]SessionUsage {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	usage := make(map[string]SessionUsage, len(a.sessions))
+	for id, s := range a.sessions {
+		usage[id] = s.Usage()
+	}
+	return usage
+}
+
+// Kill ends an active session by closing its connection
+func (a *SessionRegistry) Kill(id string) error {
+	a.mu.RLock()
+	s, ok := a.sessions[id]
+	a.mu.RUnlock()
+	if !ok {
+		return ErrUnknownSession
+	}
+	return s.kill()
+}
+
+// ListHandler serves the recorded and active sessions, newest first, with
+// the usage of active ones. The active=true query parameter lists only
+// active sessions.
+func (a *SessionRegistry) ListHandler(w http.ResponseWriter, r *http.Request) {
+	activeOnly := r.URL.Query().Get("active") == "true"
+	usage := a.Usage()
+
+	sessions := []AdminSession{}
+	for _, summary := range Recordings.List() {
+		session := AdminSession{RecordingSummary: summary}
+		if u, ok := usage[summary.ID]; ok {
+			session.Usage = &u
+			delete(usage, summary.ID)
+		} else if activeOnly {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	// Sessions whose recordings were evicted or never kept
+	for _, u := range usage {
+		sessions = append(sessions, AdminSession{
+			RecordingSummary: RecordingSummary{ID: u.ID, Tool: u.Tool, Started: u.Started},
+			Usage:            &u,
+		})
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Started.After(sessions[j].Started)
+	})
+	WriteJSON(w, http.StatusOK, sessions)
+}
+
+// KillHandler ends the active session named by the id URL parameter
+func (a *SessionRegistry) KillHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Kill(chi.URLParam(r, "id")); err != nil {
+		WriteError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package tool
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// UserTable maps the bearer tokens of users to their names
+type UserTable struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+// Users is the user table sessions and the history API identify callers with
+var Users = &UserTable{tokens: make(map[string]string)}
+
+// Configure parses users in the form "alice=token1,bob=token2"
+func (t *UserTable) Configure(spec string) error {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(entry, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return fmt.Errorf("invalid user %q, want name=token", entry)
+		}
+		if _, ok := tokens[token]; ok {
+			return fmt.Errorf("user %s shares a token with another user", name)
+		}
+		tokens[token] = name
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = tokens
+	return nil
+}
+
+// Identify returns the name of the user whose bearer token r carries, or ""
+// for anonymous requests. Every token is compared so the time taken does not
+// reveal which prefix matched.
+func (t *UserTable) Identify(r *http.Request) string {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || got == "" {
+		return ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var user string
+	for token, name := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			user = name
+		}
+	}
+	return user
+}
+
+// requireUser identifies the caller of a per-user API, answering 401 for
+// anonymous requests
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user := Users.Identify(r)
+	if user == "" {
+		WriteError(w, http.StatusUnauthorized, errors.New("invalid or missing credentials"))
+	}
+	return user, user != ""
+}
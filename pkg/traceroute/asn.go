@@ -0,0 +1,121 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Team Cymru IP to ASN mapping zones, queried over DNS
+const (
+	cymruOrigin4 = "origin.asn.cymru.com"
+	cymruOrigin6 = "origin6.asn.cymru.com"
+	cymruASN     = "asn.cymru.com"
+)
+
+// asInfo is the origin AS of a hop address
+type asInfo struct {
+	asn    int
+	name   string
+	prefix string
+}
+
+// asLookup caches the origin ASes of hop addresses and the names of ASes
+// for one trace
+type asLookup struct {
+	origins map[string]asInfo
+	names   map[int]string
+}
+
+// newASLookup returns an empty AS lookup cache
+func newASLookup() *asLookup {
+	return &asLookup{origins: make(map[string]asInfo), names: make(map[int]string)}
+}
+
+// originName returns the Team Cymru origin query name for ip: the reversed
+// octets of IPv4 addresses or nibbles of IPv6 addresses
+func originName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.%s", ip4[3], ip4[2], ip4[1], ip4[0], cymruOrigin4)
+	}
+	ip16 := ip.To16()
+	labels := make([]string, 0, 33)
+	for i := len(ip16) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(ip16[i]&0x0f), 16), strconv.FormatUint(uint64(ip16[i]>>4), 16))
+	}
+	return strings.Join(append(labels, cymruOrigin6), ".")
+}
+
+// fields splits a Team Cymru TXT record such as
+// "23028 | 216.90.108.0/24 | US | arin | 1998-09-25"
+func fields(record string) []string {
+	parts := strings.Split(record, "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// lookup returns the origin AS of address. Addresses that are not globally
+// routed, and lookups that fail, have an AS number of 0.
+func (l *asLookup) lookup(ctx context.Context, address string) asInfo {
+	if info, ok := l.origins[address]; ok {
+		return info
+	}
+	var info asInfo
+	ip := net.ParseIP(address)
+	if ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+		info = l.origin(ctx, ip)
+	}
+	l.origins[address] = info
+	return info
+}
+
+// origin queries the origin AS of ip and its name
+func (l *asLookup) origin(ctx context.Context, ip net.IP) asInfo {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	records, err := net.DefaultResolver.LookupTXT(ctx, originName(ip))
+	if err != nil || len(records) == 0 {
+		return asInfo{}
+	}
+	parts := fields(records[0])
+	if len(parts) < 2 {
+		return asInfo{}
+	}
+	// Prefixes announced by several ASes list all of them
+	origins := strings.Fields(parts[0])
+	if len(origins) == 0 {
+		return asInfo{}
+	}
+	asn, err := strconv.Atoi(origins[0])
+	if err != nil {
+		return asInfo{}
+	}
+	info := asInfo{asn: asn, prefix: parts[1]}
+
+	name, ok := l.names[asn]
+	if !ok {
+		// "23028 | US | arin | 2002-01-04 | TEAMCYMRU - SAUNET, US"
+		records, err := net.DefaultResolver.LookupTXT(ctx, fmt.Sprintf("AS%d.%s", asn, cymruASN))
+		if err == nil && len(records) > 0 {
+			if parts := fields(records[0]); len(parts) >= 5 {
+				name = parts[4]
+			}
+		}
+		l.names[asn] = name
+	}
+	info.name = name
+	return info
+}
+
+// appendASPath appends asn to the AS path unless it is unknown or the AS
+// the path already ends in
+func appendASPath(path []int, asn int) []int {
+	if asn == 0 || (len(path) > 0 && path[len(path)-1] == asn) {
+		return path
+	}
+	return append(path, asn)
+}
@@ -0,0 +1,309 @@
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for MTR options
+const (
+	defaultInterval = time.Second // Time between the starts of cycles
+	minInterval     = 100 * time.Millisecond
+)
+
+// MTRMessage represents the incoming MTR request. Queries is ignored, every
+// cycle sends one probe per hop.
+type MTRMessage struct {
+	TracerouteMessage
+
+	Cycles   *int           `json:"cycles,omitempty"`   // Cycles to run, 0 runs until stopped (-c)
+	Interval *tool.Duration `json:"interval,omitempty"` // Time between the starts of cycles (-i) in seconds or as a duration string
+}
+
+// MTRControlMessage stops a running MTR. It may be sent at any time after
+// the MTRMessage.
+type MTRControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// MTRHop holds the statistics of one hop over every cycle so far
+type MTRHop struct {
+	Hop       int      `json:"hop"`                 // Hop number, the TTL of its probes
+	Address   string   `json:"address,omitempty"`   // First node that answered
+	Hostname  string   `json:"hostname,omitempty"`  // Reverse DNS name of the address
+	ASN       int      `json:"asn,omitempty"`       // Origin AS of the address
+	ASName    string   `json:"as_name,omitempty"`   // Name of the origin AS
+	Prefix    string   `json:"prefix,omitempty"`    // Announced prefix covering the address
+	Addresses []string `json:"addresses,omitempty"` // Every node that answered, when they differ
+	Sent      int      `json:"sent"`                // Probes sent
+	Received  int      `json:"received"`            // Probes answered
+	Loss      float64  `json:"loss"`                // Probes without an answer in percent
+	Last      *float64 `json:"last"`                // Round-trip time of the latest probe in milliseconds, null when lost
+	Avg       float64  `json:"avg"`                 // Average round-trip time in milliseconds
+	Best      float64  `json:"best"`                // Minimum round-trip time in milliseconds
+	Worst     float64  `json:"worst"`               // Maximum round-trip time in milliseconds
+	StdDev    float64  `json:"stddev"`              // Standard deviation of the round-trip time in milliseconds
+}
+
+// TableMessage is the live table sent after every cycle, and as the
+// summary when the run ends
+type TableMessage struct {
+	Type     string   `json:"type"`              // Message type ("table" or "summary")
+	Host     string   `json:"host"`              // Host that is traced
+	Address  string   `json:"address"`           // Resolved address
+	Protocol string   `json:"protocol"`          // Probe protocol
+	Cycle    int      `json:"cycle"`             // Cycles completed
+	Sent     int      `json:"sent"`              // Probes sent
+	Reached  bool     `json:"reached"`           // Whether the destination answered
+	Hops     []MTRHop `json:"hops"`              // Hops up to the destination, or the furthest that answered
+	ASPath   []int    `json:"as_path,omitempty"` // ASes the path traverses in order, with asn
+	Error    string   `json:"error,omitempty"`   // Error that ended the run
+}
+
+// MTROptions contains the resolved MTR options
+type MTROptions struct {
+	TracerouteOptions
+	Cycles   int
+	Interval time.Duration
+}
+
+// resolveMTROptions converts MTRMessage to MTROptions with defaults
+func resolveMTROptions(msg *MTRMessage) (MTROptions, error) {
+	one := 1
+	msg.Queries = &one
+	traceOpts, err := resolveTracerouteOptions(&msg.TracerouteMessage)
+	opts := MTROptions{
+		TracerouteOptions: traceOpts,
+		Cycles:            tool.GetOrDefault(msg.Cycles, 0),
+		Interval:          time.Duration(tool.GetOrDefault(msg.Interval, tool.Duration(defaultInterval))),
+	}
+	if err != nil {
+		return opts, err
+	}
+	if opts.Cycles < 0 {
+		return opts, fmt.Errorf("cycles must not be negative")
+	}
+	if opts.Interval < minInterval {
+		return opts, fmt.Errorf("interval must be at least %v", minInterval)
+	}
+	return opts, nil
+}
+
+// hopStats accumulates the results of one hop
+type hopStats struct {
+	hop      MTRHop
+	sum      float64
+	sumSq    float64
+	answered bool
+}
+
+// add records the result of one probe, rtt being nil when it was lost
+func (s *hopStats) add(from string, rtt *float64) {
+	s.hop.Sent++
+	s.hop.Last = rtt
+	if rtt != nil {
+		if s.hop.Address == "" {
+			s.hop.Address = from
+		}
+		if !slices.Contains(s.hop.Addresses, from) {
+			s.hop.Addresses = append(s.hop.Addresses, from)
+		}
+		if s.hop.Received == 0 || *rtt < s.hop.Best {
+			s.hop.Best = *rtt
+		}
+		s.hop.Worst = max(s.hop.Worst, *rtt)
+		s.hop.Received++
+		s.sum += *rtt
+		s.sumSq += *rtt * *rtt
+	}
+	s.hop.Loss = float64(s.hop.Sent-s.hop.Received) / float64(s.hop.Sent) * 100
+}
+
+// row returns the statistics as a table row
+func (s *hopStats) row() MTRHop {
+	row := s.hop
+	if row.Received > 0 {
+		n := float64(row.Received)
+		avg := s.sum / n
+		row.Avg = math.Round(avg*1000) / 1000
+		row.StdDev = math.Round(math.Sqrt(math.Max(s.sumSq/n-avg*avg, 0))*1000) / 1000
+	}
+	if len(row.Addresses) < 2 {
+		row.Addresses = nil
+	} else {
+		row.Addresses = slices.Clone(row.Addresses)
+	}
+	return row
+}
+
+// MTR probes every hop once per cycle, calling report with the table after
+// each cycle until cycles have run (forever when 0) or ctx is done. Hops
+// beyond the first one the destination answered at are no longer probed.
+// An error returned by report ends the run.
+func MTR(ctx context.Context, opts MTROptions, report func(TableMessage) error) TableMessage {
+	table := TableMessage{Type: "table", Host: opts.Host, Protocol: opts.Protocol, Hops: []MTRHop{}}
+	t, err := newTracer(opts.TracerouteOptions)
+	if err != nil {
+		table.Type, table.Error = "summary", err.Error()
+		return table
+	}
+	defer t.Close()
+	table.Address = t.dst.String()
+
+	names := make(hostnames)
+	ases := newASLookup()
+	stats := make([]hopStats, opts.MaxHops)
+	for i := range stats {
+		stats[i].hop.Hop = i + 1
+	}
+	limit := opts.MaxHops
+	timeout := time.Duration(opts.Timeout) * time.Second
+	for opts.Cycles == 0 || table.Cycle < opts.Cycles {
+		started := time.Now()
+		probes := make([]*pending, 0, limit)
+		for ttl := 1; ttl <= limit; ttl++ {
+			p, err := t.launch(ttl)
+			if err != nil {
+				table.Error = err.Error()
+				break
+			}
+			probes = append(probes, p)
+			table.Sent++
+		}
+		if table.Error == "" && len(probes) > 0 {
+			if err := t.collect(probes, probes[len(probes)-1].start.Add(timeout)); err != nil {
+				table.Error = err.Error()
+			}
+		}
+		if table.Error != "" {
+			break
+		}
+
+		for i, p := range probes {
+			if p.err == nil && p.answer.reached {
+				limit = min(limit, i+1)
+				table.Reached = table.Reached || p.answer.err == ""
+				break
+			}
+		}
+		for i, p := range probes[:limit] {
+			switch {
+			case errors.Is(p.err, probe.ErrTimeout):
+				stats[i].add("", nil)
+			case p.err != nil:
+				table.Error = p.err.Error()
+			default:
+				rtt := float64(p.answer.rtt.Microseconds()) / 1000.0
+				stats[i].add(p.answer.from.String(), &rtt)
+				stats[i].answered = true
+			}
+		}
+		if table.Error != "" {
+			break
+		}
+		table.Cycle++
+
+		// The table ends at the destination, or at the furthest hop that
+		// ever answered while the destination does not
+		last := 0
+		for i := range stats[:limit] {
+			if stats[i].answered {
+				last = i + 1
+			}
+		}
+		if table.Reached {
+			last = limit
+		}
+		table.Hops = make([]MTRHop, 0, last)
+		table.ASPath = nil
+		for i := range stats[:last] {
+			row := stats[i].row()
+			if row.Address != "" && !opts.NoDNS {
+				row.Hostname = names.lookup(ctx, row.Address)
+			}
+			if row.Address != "" && opts.ASN {
+				info := ases.lookup(ctx, row.Address)
+				row.ASN, row.ASName, row.Prefix = info.asn, info.name, info.prefix
+				table.ASPath = appendASPath(table.ASPath, info.asn)
+			}
+			table.Hops = append(table.Hops, row)
+		}
+		if err := report(table); err != nil {
+			table.Error = err.Error()
+			break
+		}
+
+		if opts.Cycles != 0 && table.Cycle >= opts.Cycles {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(started.Add(opts.Interval))):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	table.Type = "summary"
+	return table
+}
+
+// MTRHandler handles WebSocket MTR requests. A stop control message, or
+// the client disconnecting, ends a run.
+func MTRHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "mtr")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg MTRMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading mtr message: %v", err)
+		return
+	}
+	opts, err := resolveMTROptions(&msg)
+	if err != nil {
+		log.Printf("Invalid mtr options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control MTRControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	counted := 0
+	summary := MTR(ctx, opts, func(table TableMessage) error {
+		for ; counted < table.Sent; counted++ {
+			session.CountProbe()
+		}
+		return session.WriteJSON(table)
+	})
+	log.Printf("MTR to %s over %s: %d cycles, reached=%t", opts.Host, opts.Protocol, summary.Cycle, summary.Reached)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
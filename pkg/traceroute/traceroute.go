@@ -0,0 +1,498 @@
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Supported probe protocols
+const (
+	ProtocolICMP = "icmp" // ICMP echo requests, answered by an echo reply
+	ProtocolUDP  = "udp"  // UDP datagrams to unused ports, answered by port unreachable
+	ProtocolTCP  = "tcp"  // TCP SYNs, answered by a SYN/ACK or RST
+)
+
+// Default values for traceroute options
+const (
+	defaultProtocol = ProtocolUDP
+	defaultUDPPort  = 33434 // First port of classic traceroute
+	defaultTCPPort  = 80
+	defaultMaxHops  = 30
+	defaultQueries  = 3
+	defaultTimeout  = 2 // 2 second timeout per probe
+	maxHops         = 64
+	maxQueries      = 10
+	payloadSize     = 32
+	lookupTimeout   = 2 * time.Second
+	// dialPoll is how often TCP probes check for ICMP errors while the
+	// handshake is in progress
+	dialPoll = 20 * time.Millisecond
+)
+
+// TracerouteMessage represents the incoming traceroute request
+type TracerouteMessage struct {
+	// Required
+	Host string `json:"host"` // Host to trace the route to
+
+	// Optional parameters
+	Protocol *string `json:"protocol,omitempty"` // Probe protocol (icmp, udp, tcp)
+	Family   *string `json:"family,omitempty"`   // Address family to use ("ip4" or "ip6")
+	Port     *int    `json:"port,omitempty"`     // Destination port for tcp, first port for udp (-p)
+	MaxHops  *int    `json:"max_hops,omitempty"` // Maximum TTL (-m)
+	Queries  *int    `json:"queries,omitempty"`  // Probes per hop (-q)
+	Timeout  *int    `json:"timeout,omitempty"`  // Timeout per probe in seconds (-w)
+	NoDNS    *bool   `json:"no_dns,omitempty"`   // Skip reverse DNS lookups of hops (-n)
+	ASN      *bool   `json:"asn,omitempty"`      // Look up the origin AS of hops (-z)
+}
+
+// HopMessage reports the probes of one hop
+type HopMessage struct {
+	Type      string     `json:"type"`                // Message type ("hop")
+	Hop       int        `json:"hop"`                 // Hop number, the TTL of its probes
+	Address   string     `json:"address,omitempty"`   // First node that answered
+	Hostname  string     `json:"hostname,omitempty"`  // Reverse DNS name of the address
+	ASN       int        `json:"asn,omitempty"`       // Origin AS of the address
+	ASName    string     `json:"as_name,omitempty"`   // Name of the origin AS
+	Prefix    string     `json:"prefix,omitempty"`    // Announced prefix covering the address
+	Addresses []string   `json:"addresses,omitempty"` // Every node that answered, when they differ
+	RTTs      []*float64 `json:"rtts"`                // Round-trip time of each probe in milliseconds, null when lost
+	Loss      float64    `json:"loss"`                // Probes without an answer in percent
+	Reached   bool       `json:"reached"`             // Whether the destination answered
+	Error     string     `json:"error,omitempty"`     // Unreachable error reported for the destination
+}
+
+// SummaryMessage reports the end of the trace
+type SummaryMessage struct {
+	Type     string `json:"type"`              // Message type ("summary")
+	Host     string `json:"host"`              // Host that was traced
+	Address  string `json:"address"`           // Resolved address
+	Protocol string `json:"protocol"`          // Probe protocol
+	Hops     int    `json:"hops"`              // Hops probed
+	Reached  bool   `json:"reached"`           // Whether the destination answered
+	ASPath   []int  `json:"as_path,omitempty"` // ASes the path traverses in order, with asn
+	Error    string `json:"error,omitempty"`   // Error that ended the trace
+}
+
+// TracerouteOptions contains the resolved traceroute options
+type TracerouteOptions struct {
+	Host     string
+	Protocol string
+	Family   string
+	Port     int
+	MaxHops  int
+	Queries  int
+	Timeout  int
+	NoDNS    bool
+	ASN      bool
+}
+
+// resolveTracerouteOptions converts TracerouteMessage to TracerouteOptions with defaults
+func resolveTracerouteOptions(msg *TracerouteMessage) (TracerouteOptions, error) {
+	opts := TracerouteOptions{
+		Host:     strings.Trim(strings.TrimSpace(msg.Host), "[]"),
+		Protocol: tool.GetOrDefault(msg.Protocol, defaultProtocol),
+		Family:   tool.GetOrDefault(msg.Family, "ip"),
+		MaxHops:  tool.GetOrDefault(msg.MaxHops, defaultMaxHops),
+		Queries:  tool.GetOrDefault(msg.Queries, defaultQueries),
+		Timeout:  tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		NoDNS:    tool.GetOrDefault(msg.NoDNS, false),
+		ASN:      tool.GetOrDefault(msg.ASN, false),
+	}
+	defaultPort := defaultUDPPort
+	if opts.Protocol == ProtocolTCP {
+		defaultPort = defaultTCPPort
+	}
+	opts.Port = tool.GetOrDefault(msg.Port, defaultPort)
+
+	if opts.Host == "" {
+		return opts, fmt.Errorf("host is required")
+	}
+	if opts.Protocol != ProtocolICMP && opts.Protocol != ProtocolUDP && opts.Protocol != ProtocolTCP {
+		return opts, fmt.Errorf("unsupported protocol %q", opts.Protocol)
+	}
+	if opts.Family != "ip" && opts.Family != "ip4" && opts.Family != "ip6" {
+		return opts, fmt.Errorf("family must be ip4 or ip6")
+	}
+	if opts.MaxHops <= 0 || opts.MaxHops > maxHops {
+		return opts, fmt.Errorf("max_hops must be between 1 and %d", maxHops)
+	}
+	if opts.Queries <= 0 || opts.Queries > maxQueries {
+		return opts, fmt.Errorf("queries must be between 1 and %d", maxQueries)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	last := opts.Port
+	if opts.Protocol == ProtocolUDP {
+		// Each UDP probe goes to the next port, so replies identify it
+		last += opts.MaxHops*opts.Queries - 1
+	}
+	if opts.Port <= 0 || last > 65535 {
+		return opts, fmt.Errorf("port must be between 1 and 65535 for every probe")
+	}
+	return opts, nil
+}
+
+// answer is the outcome of a single probe
+type answer struct {
+	from    net.IP
+	rtt     time.Duration
+	reached bool   // The destination answered
+	err     string // Unreachable error for the destination
+}
+
+// tracer sends the probes of one trace
+type tracer struct {
+	opts     TracerouteOptions
+	dst      *net.IPAddr
+	quotes   *probe.QuoteConn
+	udp      net.PacketConn
+	udpTTL   *probe.TTLConn
+	udpPort  int
+	id       int
+	sequence int
+}
+
+// newTracer resolves the host and opens the sockets probes are sent and
+// answered on
+func newTracer(opts TracerouteOptions) (*tracer, error) {
+	ipAddr, err := net.ResolveIPAddr(opts.Family, opts.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", opts.Host, err)
+	}
+	if err := tool.Demo.CheckAddr(ipAddr.IP); err != nil {
+		return nil, err
+	}
+	v6 := ipAddr.IP.To4() == nil
+
+	quotes, err := probe.ListenQuotes(v6)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{opts: opts, dst: ipAddr, quotes: quotes, id: rand.IntN(0x10000)}
+
+	if opts.Protocol == ProtocolUDP {
+		network := "udp4"
+		if v6 {
+			network = "udp6"
+		}
+		conn, err := net.ListenPacket(network, "")
+		if err != nil {
+			quotes.Close()
+			return nil, fmt.Errorf("error opening UDP socket: %v", err)
+		}
+		t.udp, t.udpTTL = conn, probe.NewTTLConn(conn, v6)
+		t.udpPort = conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return t, nil
+}
+
+// Close closes the sockets of the tracer
+func (t *tracer) Close() {
+	t.quotes.Close()
+	if t.udp != nil {
+		t.udp.Close()
+	}
+}
+
+// pending is a probe waiting for its answer
+type pending struct {
+	start   time.Time
+	match   func(probe.Quote) bool
+	done    chan dialResult // Outcome of the dial, for TCP probes
+	answer  answer
+	err     error // ErrTimeout for lost probes
+	settled bool
+}
+
+// dialResult is the outcome of a TCP probe's dial
+type dialResult struct {
+	rtt time.Duration
+	err error
+}
+
+// launch sends one probe with the given TTL without waiting for its answer
+func (t *tracer) launch(ttl int) (*pending, error) {
+	sequence := t.sequence
+	t.sequence++
+
+	p := &pending{start: time.Now()}
+	switch t.opts.Protocol {
+	case ProtocolICMP:
+		if err := t.quotes.SendEcho(t.dst, t.id, sequence, ttl, payloadSize); err != nil {
+			return nil, fmt.Errorf("error sending probe: %w", err)
+		}
+		p.match = probe.MatchEcho(t.id, sequence)
+	case ProtocolUDP:
+		if err := t.udpTTL.SetTTL(ttl); err != nil {
+			return nil, fmt.Errorf("error setting TTL: %w", err)
+		}
+		// Ports wrap around within the range the options were validated for
+		port := t.opts.Port + sequence%(t.opts.MaxHops*t.opts.Queries)
+		if _, err := t.udp.WriteTo(probe.Payload(payloadSize), &net.UDPAddr{IP: t.dst.IP, Port: port, Zone: t.dst.Zone}); err != nil {
+			return nil, fmt.Errorf("error sending probe: %w", err)
+		}
+		p.match = probe.MatchUDP(t.udpPort, port)
+	case ProtocolTCP:
+		target := net.JoinHostPort(t.dst.String(), strconv.Itoa(t.opts.Port))
+		timeout := time.Duration(t.opts.Timeout) * time.Second
+		ports := make(chan int, 1)
+		p.done = make(chan dialResult, 1)
+		go func() {
+			conn, err := probe.DialTTL("tcp", target, ttl, timeout, func(port int) { ports <- port })
+			rtt := time.Since(p.start)
+			if err == nil {
+				conn.Close()
+			}
+			p.done <- dialResult{rtt: rtt, err: err}
+		}()
+		select {
+		case port := <-ports:
+			p.match = probe.MatchTCP(port)
+		case result := <-p.done:
+			// The dial failed before the SYN was sent
+			return nil, result.err
+		}
+	}
+	return p, nil
+}
+
+// settleDial settles a TCP probe whose dial completed
+func (t *tracer) settleDial(p *pending, result dialResult) {
+	p.settled = true
+	// A SYN/ACK or RST can only come from the destination
+	if result.err == nil || errors.Is(result.err, syscall.ECONNREFUSED) {
+		p.answer = answer{from: t.dst.IP, rtt: result.rtt, reached: true}
+		return
+	}
+	var netErr net.Error
+	if errors.As(result.err, &netErr) && netErr.Timeout() {
+		p.err = probe.ErrTimeout
+		return
+	}
+	// Errors such as host unreachable are reported to the socket too and
+	// are usually caught by the quote read in time
+	p.err = result.err
+}
+
+// collect waits until deadline for the answers to probes, settling each.
+// The error is only set when reading answers failed.
+func (t *tracer) collect(probes []*pending, deadline time.Time) error {
+	match := func(q probe.Quote) bool {
+		for _, p := range probes {
+			if !p.settled && p.match(q) {
+				return true
+			}
+		}
+		return false
+	}
+	for {
+		dialing := false
+		unsettled := 0
+		for _, p := range probes {
+			if !p.settled && p.done != nil {
+				select {
+				case result := <-p.done:
+					t.settleDial(p, result)
+				default:
+					dialing = true
+				}
+			}
+			if !p.settled {
+				unsettled++
+			}
+		}
+		if unsettled == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			for _, p := range probes {
+				if !p.settled {
+					p.settled, p.err = true, probe.ErrTimeout
+				}
+			}
+			return nil
+		}
+
+		// Dials are polled for while waiting for ICMP errors about the SYNs
+		wait := deadline
+		if poll := time.Now().Add(dialPoll); dialing && poll.Before(deadline) {
+			wait = poll
+		}
+		quote, err := t.quotes.Read(wait, match)
+		if errors.Is(err, probe.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, p := range probes {
+			if !p.settled && p.match(quote) {
+				p.settled, p.answer = true, quoteAnswer(quote, time.Since(p.start))
+				break
+			}
+		}
+	}
+}
+
+// send sends one probe with the given TTL and waits for its answer. It
+// returns ErrTimeout for lost probes.
+func (t *tracer) send(ttl int) (answer, error) {
+	p, err := t.launch(ttl)
+	if err != nil {
+		return answer{}, err
+	}
+	if err := t.collect([]*pending{p}, p.start.Add(time.Duration(t.opts.Timeout)*time.Second)); err != nil {
+		return answer{}, err
+	}
+	return p.answer, p.err
+}
+
+// quoteAnswer converts the ICMP message answering a probe
+func quoteAnswer(quote probe.Quote, rtt time.Duration) answer {
+	a := answer{from: quote.From, rtt: rtt}
+	switch quote.Kind {
+	case probe.QuotePortUnreachable, probe.QuoteEchoReply:
+		a.reached = true
+	case probe.QuoteUnreachable:
+		a.reached, a.err = true, "destination unreachable reported by "+quote.From.String()
+	}
+	return a
+}
+
+// hostnames caches the reverse DNS names of hop addresses
+type hostnames map[string]string
+
+// lookup returns the reverse DNS name of address, or an empty string
+func (h hostnames) lookup(ctx context.Context, address string) string {
+	if name, ok := h[address]; ok {
+		return name
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	name := ""
+	if names, err := net.DefaultResolver.LookupAddr(ctx, address); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	h[address] = name
+	return name
+}
+
+// Trace probes hop by hop until the destination answers or the hops run
+// out, calling report for each hop. An error returned by report ends the
+// trace.
+func Trace(ctx context.Context, opts TracerouteOptions, report func(HopMessage) error) SummaryMessage {
+	summary := SummaryMessage{Type: "summary", Host: opts.Host, Protocol: opts.Protocol}
+	t, err := newTracer(opts)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	defer t.Close()
+	summary.Address = t.dst.String()
+
+	names := make(hostnames)
+	ases := newASLookup()
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		summary.Hops = ttl
+		hop := HopMessage{Type: "hop", Hop: ttl, RTTs: make([]*float64, 0, opts.Queries)}
+		lost := 0
+		for range opts.Queries {
+			a, err := t.send(ttl)
+			if errors.Is(err, probe.ErrTimeout) {
+				hop.RTTs = append(hop.RTTs, nil)
+				lost++
+				continue
+			}
+			if err != nil {
+				summary.Error = err.Error()
+				return summary
+			}
+			rtt := float64(a.rtt.Microseconds()) / 1000.0
+			hop.RTTs = append(hop.RTTs, &rtt)
+			from := a.from.String()
+			if hop.Address == "" {
+				hop.Address = from
+			}
+			if !slices.Contains(hop.Addresses, from) {
+				hop.Addresses = append(hop.Addresses, from)
+			}
+			hop.Reached = hop.Reached || a.reached
+			if a.err != "" {
+				hop.Error = a.err
+			}
+		}
+		hop.Loss = float64(lost) / float64(opts.Queries) * 100
+		if len(hop.Addresses) < 2 {
+			hop.Addresses = nil
+		}
+		if hop.Address != "" && !opts.NoDNS {
+			hop.Hostname = names.lookup(ctx, hop.Address)
+		}
+		if hop.Address != "" && opts.ASN {
+			info := ases.lookup(ctx, hop.Address)
+			hop.ASN, hop.ASName, hop.Prefix = info.asn, info.name, info.prefix
+			summary.ASPath = appendASPath(summary.ASPath, info.asn)
+		}
+
+		if err := report(hop); err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+		if hop.Reached {
+			summary.Reached = hop.Error == ""
+			break
+		}
+	}
+	return summary
+}
+
+// Handler handles WebSocket traceroute requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "traceroute")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg TracerouteMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading traceroute message: %v", err)
+		return
+	}
+
+	opts, err := resolveTracerouteOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid traceroute options: %v", err)
+		return
+	}
+	if err := tool.Demo.CheckTarget(r.Context(), opts.Host); err != nil {
+		log.Printf("Refused demo traceroute: %v", err)
+		return
+	}
+
+	summary := Trace(r.Context(), opts, func(hop HopMessage) error {
+		for range len(hop.RTTs) {
+			session.CountProbe()
+		}
+		return session.WriteJSON(hop)
+	})
+	log.Printf("Traceroute to %s over %s: %d hops, reached=%t", opts.Host, opts.Protocol, summary.Hops, summary.Reached)
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
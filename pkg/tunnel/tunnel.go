@@ -0,0 +1,480 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/probe"
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for tunnel check options
+const (
+	defaultCount           = 10
+	maxCount               = 1000
+	defaultInterval        = time.Second
+	minInterval            = 100 * time.Millisecond
+	defaultTimeout         = 2   // Seconds to wait for each reply
+	defaultMaxHandshakeAge = 180 // WireGuard stops using session keys after 180 seconds
+	payloadSize            = 56
+)
+
+// Paths echo requests take
+const (
+	PathInside  = "inside"  // Through the tunnel interface
+	PathOutside = "outside" // Over the default route, as the tunnel's own packets travel
+)
+
+// Handshake states of WireGuard peers
+const (
+	HandshakeFresh = "fresh" // The latest handshake is recent enough for the session to be in use
+	HandshakeStale = "stale" // The latest handshake is older than the maximum age
+	HandshakeNever = "never" // The peer never completed a handshake
+)
+
+// TunnelMessage represents the incoming tunnel check request
+type TunnelMessage struct {
+	// Required
+	Interface string `json:"interface"` // Tunnel interface, e.g. wg0, gre1 or ipsec0
+
+	// Optional parameters
+	Peer            *string        `json:"peer,omitempty"`              // Public key of the WireGuard peer to check, defaults to every peer
+	Inside          *string        `json:"inside,omitempty"`            // Host pinged through the tunnel interface, e.g. the far end's tunnel address
+	Outside         *string        `json:"outside,omitempty"`           // Host pinged over the default route, defaults to the WireGuard peer's endpoint
+	Count           *int           `json:"count,omitempty"`             // Echo requests sent on each path
+	Interval        *tool.Duration `json:"interval,omitempty"`          // Time between rounds in seconds or as a duration string
+	Timeout         *int           `json:"timeout,omitempty"`           // Seconds to wait for each reply
+	MaxHandshakeAge *int           `json:"max_handshake_age,omitempty"` // Seconds after which a WireGuard handshake is stale
+}
+
+// TunnelControlMessage stops a running check. It may be sent at any time
+// after the TunnelMessage.
+type TunnelControlMessage struct {
+	Action string `json:"action"` // "stop"
+}
+
+// InterfaceMessage describes the tunnel interface
+type InterfaceMessage struct {
+	Type       string   `json:"type"`                  // Message type ("interface")
+	Interface  string   `json:"interface"`             // Interface name
+	Index      int      `json:"index"`                 // Interface index
+	MTU        int      `json:"mtu"`                   // Interface MTU
+	Up         bool     `json:"up"`                    // Whether the interface is administratively up
+	Addresses  []string `json:"addresses"`             // Addresses assigned to the interface
+	WireGuard  bool     `json:"wireguard"`             // Whether the interface is a WireGuard device
+	PublicKey  string   `json:"public_key,omitempty"`  // WireGuard public key of the interface
+	ListenPort int      `json:"listen_port,omitempty"` // WireGuard UDP port
+	Error      string   `json:"error,omitempty"`       // Error reading the WireGuard state
+}
+
+// PeerMessage reports the handshake freshness and traffic of a WireGuard
+// peer
+type PeerMessage struct {
+	Type          string     `json:"type"`                     // Message type ("peer")
+	PublicKey     string     `json:"public_key"`               // Public key of the peer
+	Endpoint      string     `json:"endpoint,omitempty"`       // Latest outer address of the peer
+	LastHandshake *time.Time `json:"last_handshake,omitempty"` // Time of the latest handshake
+	HandshakeAge  *float64   `json:"handshake_age,omitempty"`  // Seconds since the latest handshake
+	Handshake     string     `json:"handshake"`                // Handshake state (fresh, stale, never)
+	Keepalive     int        `json:"keepalive,omitempty"`      // Persistent keepalive interval in seconds
+	RxBytes       int64      `json:"rx_bytes"`                 // Bytes received from the peer
+	TxBytes       int64      `json:"tx_bytes"`                 // Bytes sent to the peer
+	AllowedIPs    []string   `json:"allowed_ips"`              // Networks routed to the peer
+}
+
+// ProbeMessage reports one echo request
+type ProbeMessage struct {
+	Type     string  `json:"type"`            // Message type ("probe")
+	Path     string  `json:"path"`            // Path of the request (inside, outside)
+	Target   string  `json:"target"`          // Address that was pinged
+	Sequence int     `json:"sequence"`        // Round of the request
+	Success  bool    `json:"success"`         // Whether a reply arrived
+	Latency  float64 `json:"latency"`         // Round-trip time in milliseconds
+	Error    string  `json:"error,omitempty"` // Error when no reply arrived
+}
+
+// PathStats summarizes the echo requests of one path
+type PathStats struct {
+	Target   string  `json:"target"`   // Address that was pinged
+	Sent     int     `json:"sent"`     // Requests sent
+	Received int     `json:"received"` // Replies received
+	Loss     float64 `json:"loss"`     // Requests without a reply in percent
+	Min      float64 `json:"min"`      // Minimum round-trip time in milliseconds
+	Avg      float64 `json:"avg"`      // Average round-trip time in milliseconds
+	Max      float64 `json:"max"`      // Maximum round-trip time in milliseconds
+}
+
+// SummaryMessage reports the health of the tunnel
+type SummaryMessage struct {
+	Type       string     `json:"type"`               // Message type ("summary")
+	Interface  string     `json:"interface"`          // Interface that was checked
+	WireGuard  bool       `json:"wireguard"`          // Whether the interface is a WireGuard device
+	Peers      int        `json:"peers"`              // WireGuard peers checked
+	FreshPeers int        `json:"fresh_peers"`        // Peers with a fresh handshake
+	Inside     *PathStats `json:"inside,omitempty"`   // Echo requests through the tunnel
+	Outside    *PathStats `json:"outside,omitempty"`  // Echo requests over the default route
+	Overhead   *float64   `json:"overhead,omitempty"` // Average inside minus outside round-trip time in milliseconds
+	Healthy    bool       `json:"healthy"`            // Whether every check passed
+	Problems   []string   `json:"problems"`           // Checks that failed
+	Error      string     `json:"error,omitempty"`    // Error that ended the check
+}
+
+// TunnelOptions contains the resolved tunnel check options
+type TunnelOptions struct {
+	Interface       string
+	Peer            string
+	Inside          string
+	Outside         string
+	Count           int
+	Interval        time.Duration
+	Timeout         time.Duration
+	MaxHandshakeAge time.Duration
+}
+
+// resolveTunnelOptions converts TunnelMessage to TunnelOptions with defaults
+func resolveTunnelOptions(msg *TunnelMessage) (TunnelOptions, error) {
+	opts := TunnelOptions{
+		Interface:       strings.TrimSpace(msg.Interface),
+		Peer:            strings.TrimSpace(tool.GetOrDefault(msg.Peer, "")),
+		Inside:          strings.TrimSpace(tool.GetOrDefault(msg.Inside, "")),
+		Outside:         strings.TrimSpace(tool.GetOrDefault(msg.Outside, "")),
+		Count:           tool.GetOrDefault(msg.Count, defaultCount),
+		Interval:        time.Duration(tool.GetOrDefault(msg.Interval, tool.Duration(defaultInterval))),
+		Timeout:         time.Duration(tool.GetOrDefault(msg.Timeout, defaultTimeout)) * time.Second,
+		MaxHandshakeAge: time.Duration(tool.GetOrDefault(msg.MaxHandshakeAge, defaultMaxHandshakeAge)) * time.Second,
+	}
+	if opts.Interface == "" {
+		return opts, fmt.Errorf("interface is required")
+	}
+	if opts.Count <= 0 || opts.Count > maxCount {
+		return opts, fmt.Errorf("count must be between 1 and %d", maxCount)
+	}
+	if opts.Interval < minInterval {
+		return opts, fmt.Errorf("interval must be at least %v", minInterval)
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	if opts.MaxHandshakeAge <= 0 {
+		return opts, fmt.Errorf("max_handshake_age must be positive")
+	}
+	return opts, nil
+}
+
+// describeInterface reports the addresses and flags of iface
+func describeInterface(iface *net.Interface) InterfaceMessage {
+	msg := InterfaceMessage{
+		Type:      "interface",
+		Interface: iface.Name,
+		Index:     iface.Index,
+		MTU:       iface.MTU,
+		Up:        iface.Flags&net.FlagUp != 0,
+		Addresses: []string{},
+	}
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		msg.Addresses = append(msg.Addresses, addr.String())
+	}
+	return msg
+}
+
+// peerMessage reports the state of p, its handshake being stale after
+// maxAge
+func peerMessage(p peer, maxAge time.Duration) PeerMessage {
+	msg := PeerMessage{
+		Type:       "peer",
+		PublicKey:  p.publicKey,
+		Endpoint:   p.endpoint,
+		Handshake:  HandshakeNever,
+		Keepalive:  p.keepalive,
+		RxBytes:    p.rxBytes,
+		TxBytes:    p.txBytes,
+		AllowedIPs: p.allowedIPs,
+	}
+	if msg.AllowedIPs == nil {
+		msg.AllowedIPs = []string{}
+	}
+	if !p.lastHandshake.IsZero() {
+		last := p.lastHandshake
+		age := math.Round(time.Since(last).Seconds()*1000) / 1000
+		msg.LastHandshake, msg.HandshakeAge = &last, &age
+		msg.Handshake = HandshakeFresh
+		if time.Since(last) > maxAge {
+			msg.Handshake = HandshakeStale
+		}
+	}
+	return msg
+}
+
+// endpointHost returns the address of a WireGuard endpoint without its port
+func endpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// pathStats accumulates the echo requests of one path
+type pathStats struct {
+	stats PathStats
+	sum   float64
+}
+
+// add records one echo request
+func (s *pathStats) add(msg ProbeMessage) {
+	s.stats.Sent++
+	if msg.Success {
+		if s.stats.Received == 0 || msg.Latency < s.stats.Min {
+			s.stats.Min = msg.Latency
+		}
+		s.stats.Max = max(s.stats.Max, msg.Latency)
+		s.stats.Received++
+		s.sum += msg.Latency
+		s.stats.Avg = math.Round(s.sum/float64(s.stats.Received)*1000) / 1000
+	}
+	s.stats.Loss = float64(s.stats.Sent-s.stats.Received) / float64(s.stats.Sent) * 100
+}
+
+// pinger sends echo requests to one target on one path
+type pinger struct {
+	path  string
+	ip    *net.IPAddr
+	conn  *probe.ICMPConn
+	stats pathStats
+}
+
+// newPinger resolves host and opens an ICMP socket for it, bound to device
+// unless it is empty
+func newPinger(path, host, device string) (*pinger, error) {
+	ip, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s target %s: %w", path, host, err)
+	}
+	var conn *probe.ICMPConn
+	if ip.IP.To4() != nil {
+		conn, err = probe.ListenICMP(nil)
+	} else {
+		conn, err = probe.ListenICMPv6(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device != "" {
+		if err := conn.BindToDevice(device); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error binding to %s: %w", device, err)
+		}
+	}
+	return &pinger{path: path, ip: ip, conn: conn, stats: pathStats{stats: PathStats{Target: ip.String()}}}, nil
+}
+
+// ping sends one echo request
+func (p *pinger) ping(sequence int, timeout time.Duration) ProbeMessage {
+	msg := ProbeMessage{Type: "probe", Path: p.path, Target: p.ip.String(), Sequence: sequence}
+	rtt, _, err := p.conn.Echo(p.ip, sequence, payloadSize, timeout)
+	if err != nil {
+		msg.Error = err.Error()
+	} else {
+		msg.Success = true
+		msg.Latency = float64(rtt.Microseconds()) / 1000.0
+	}
+	p.stats.add(msg)
+	return msg
+}
+
+// Check reads the WireGuard state of the interface, if it is a WireGuard
+// device, then pings the inside and outside targets once per round until
+// count rounds have run or ctx is done. Every message is passed to report;
+// an error it returns ends the check.
+func Check(ctx context.Context, opts TunnelOptions, report func(any) error) SummaryMessage {
+	summary := SummaryMessage{Type: "summary", Interface: opts.Interface, Problems: []string{}}
+	fail := func(err error) SummaryMessage {
+		summary.Error = err.Error()
+		summary.Problems = append(summary.Problems, err.Error())
+		return summary
+	}
+
+	iface, err := net.InterfaceByName(opts.Interface)
+	if err != nil {
+		return fail(fmt.Errorf("interface %s not found", opts.Interface))
+	}
+	info := describeInterface(iface)
+	if !info.Up {
+		summary.Problems = append(summary.Problems, fmt.Sprintf("interface %s is down", iface.Name))
+	}
+
+	dev, err := readDevice(iface.Name)
+	var peers []PeerMessage
+	switch {
+	case err == nil:
+		info.WireGuard, info.PublicKey, info.ListenPort = true, dev.publicKey, dev.listenPort
+		for _, p := range dev.peers {
+			if opts.Peer == "" || p.publicKey == opts.Peer {
+				peers = append(peers, peerMessage(p, opts.MaxHandshakeAge))
+			}
+		}
+	case !errors.Is(err, errNotWireGuard):
+		info.Error = err.Error()
+		summary.Problems = append(summary.Problems, "reading WireGuard state: "+err.Error())
+	}
+	summary.WireGuard = info.WireGuard
+	if err := report(info); err != nil {
+		return summary
+	}
+	if opts.Peer != "" && info.WireGuard && len(peers) == 0 {
+		summary.Problems = append(summary.Problems, fmt.Sprintf("peer %s not found", opts.Peer))
+	}
+	for _, p := range peers {
+		summary.Peers++
+		switch p.Handshake {
+		case HandshakeFresh:
+			summary.FreshPeers++
+		case HandshakeStale:
+			summary.Problems = append(summary.Problems, fmt.Sprintf("peer %s: latest handshake %.0f seconds ago", p.PublicKey, *p.HandshakeAge))
+		case HandshakeNever:
+			summary.Problems = append(summary.Problems, fmt.Sprintf("peer %s: no handshake", p.PublicKey))
+		}
+		if err := report(p); err != nil {
+			return summary
+		}
+	}
+
+	outside := opts.Outside
+	if outside == "" && len(peers) == 1 {
+		outside = endpointHost(peers[0].Endpoint)
+	}
+	var pingers []*pinger
+	defer func() {
+		for _, p := range pingers {
+			p.conn.Close()
+		}
+	}()
+	if opts.Inside != "" {
+		p, err := newPinger(PathInside, opts.Inside, iface.Name)
+		if err != nil {
+			return fail(err)
+		}
+		pingers = append(pingers, p)
+	}
+	if outside != "" {
+		p, err := newPinger(PathOutside, outside, "")
+		if err != nil {
+			return fail(err)
+		}
+		pingers = append(pingers, p)
+	}
+	if len(pingers) == 0 && !info.WireGuard {
+		return fail(fmt.Errorf("inside is required for interfaces that are not WireGuard devices"))
+	}
+
+	// Both paths are pinged at the same time, so their round trips are taken
+	// under the same conditions
+	for round := 1; round <= opts.Count && len(pingers) > 0; round++ {
+		start := time.Now()
+		results := make([]ProbeMessage, len(pingers))
+		var wg sync.WaitGroup
+		for i, p := range pingers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = p.ping(round, opts.Timeout)
+			}()
+		}
+		wg.Wait()
+		for _, result := range results {
+			if err := report(result); err != nil {
+				return summary
+			}
+		}
+		if round == opts.Count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return finish(summary, pingers)
+		case <-time.After(time.Until(start.Add(opts.Interval))):
+		}
+	}
+	return finish(summary, pingers)
+}
+
+// finish adds the path statistics and the overhead of the tunnel to the
+// summary
+func finish(summary SummaryMessage, pingers []*pinger) SummaryMessage {
+	for _, p := range pingers {
+		stats := p.stats.stats
+		if p.path == PathInside {
+			summary.Inside = &stats
+		} else {
+			summary.Outside = &stats
+		}
+		if stats.Sent > 0 && stats.Received == 0 {
+			summary.Problems = append(summary.Problems, fmt.Sprintf("no replies from %s target %s", p.path, stats.Target))
+		}
+	}
+	if summary.Inside != nil && summary.Outside != nil && summary.Inside.Received > 0 && summary.Outside.Received > 0 {
+		overhead := math.Round((summary.Inside.Avg-summary.Outside.Avg)*1000) / 1000
+		summary.Overhead = &overhead
+	}
+	summary.Healthy = len(summary.Problems) == 0
+	return summary
+}
+
+// Handler handles WebSocket tunnel check requests. A stop control message,
+// or the client disconnecting, ends a check early.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "tunnel")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg TunnelMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading tunnel message: %v", err)
+		return
+	}
+
+	opts, err := resolveTunnelOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid tunnel options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session.Go(func() {
+		defer cancel()
+		for {
+			var control TunnelControlMessage
+			err := session.ReadJSON(&control)
+			if errors.Is(err, tool.ErrInvalidMessage) {
+				log.Printf("Ignoring control message: %v", err)
+				continue
+			}
+			if err != nil || control.Action == "stop" {
+				return
+			}
+		}
+	})
+
+	summary := Check(ctx, opts, func(msg any) error {
+		if _, ok := msg.(ProbeMessage); ok {
+			session.CountProbe()
+		}
+		return session.WriteJSON(msg)
+	})
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uapiDir holds the control sockets of userspace WireGuard implementations
+// such as wireguard-go and boringtun
+const uapiDir = "/var/run/wireguard"
+
+// errNotWireGuard is returned for interfaces that are not WireGuard devices
+var errNotWireGuard = errors.New("not a WireGuard interface")
+
+// device is the state of a WireGuard interface
+type device struct {
+	publicKey  string
+	listenPort int
+	peers      []peer
+}
+
+// peer is the state of one WireGuard peer
+type peer struct {
+	publicKey     string
+	endpoint      string
+	lastHandshake time.Time
+	keepalive     int
+	rxBytes       int64
+	txBytes       int64
+	allowedIPs    []string
+}
+
+// readDevice reads the state of a WireGuard interface from the kernel, or
+// from the control socket of a userspace implementation. Other interfaces
+// return errNotWireGuard.
+func readDevice(name string) (device, error) {
+	dev, err := kernelDevice(name)
+	if err == nil {
+		return dev, nil
+	}
+	uapi, uapiErr := uapiDevice(name)
+	switch {
+	case uapiErr == nil:
+		return uapi, nil
+	case errors.Is(err, errNotWireGuard) && errors.Is(uapiErr, fs.ErrNotExist):
+		return dev, errNotWireGuard
+	case errors.Is(err, errNotWireGuard):
+		return dev, uapiErr
+	}
+	return dev, err
+}
+
+// uapiDevice queries the control socket of a userspace WireGuard
+// implementation, which answers get=1 with key=value lines
+func uapiDevice(name string) (device, error) {
+	var dev device
+	conn, err := net.DialTimeout("unix", filepath.Join(uapiDir, name+".sock"), time.Second)
+	if err != nil {
+		return dev, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("get=1\n\n")); err != nil {
+		return dev, err
+	}
+
+	var current *peer
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "listen_port":
+			dev.listenPort, _ = strconv.Atoi(value)
+		case "public_key":
+			raw, err := hex.DecodeString(value)
+			if err != nil {
+				return dev, fmt.Errorf("invalid peer key %q", value)
+			}
+			dev.peers = append(dev.peers, peer{publicKey: base64.StdEncoding.EncodeToString(raw)})
+			current = &dev.peers[len(dev.peers)-1]
+		case "errno":
+			if value != "0" {
+				return dev, fmt.Errorf("WireGuard control socket error %s", value)
+			}
+		}
+		if current == nil {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch key {
+		case "endpoint":
+			current.endpoint = value
+		case "last_handshake_time_sec":
+			if n > 0 {
+				current.lastHandshake = time.Unix(n, int64(current.lastHandshake.Nanosecond()))
+			}
+		case "last_handshake_time_nsec":
+			if !current.lastHandshake.IsZero() {
+				current.lastHandshake = time.Unix(current.lastHandshake.Unix(), n)
+			}
+		case "persistent_keepalive_interval":
+			current.keepalive = int(n)
+		case "rx_bytes":
+			current.rxBytes = n
+		case "tx_bytes":
+			current.txBytes = n
+		case "allowed_ip":
+			current.allowedIPs = append(current.allowedIPs, value)
+		}
+	}
+	return dev, scanner.Err()
+}
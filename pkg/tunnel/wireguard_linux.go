@@ -0,0 +1,320 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// WireGuard generic netlink commands and attributes, from
+// include/uapi/linux/wireguard.h
+const (
+	wgGenlName      = "wireguard"
+	wgGenlVersion   = 1
+	wgCmdGetDevice  = 0
+	wgDeviceIfname  = 2
+	wgDevicePubKey  = 4
+	wgDevicePort    = 6
+	wgDevicePeers   = 8
+	wgPeerPubKey    = 1
+	wgPeerEndpoint  = 4
+	wgPeerKeepalive = 5
+	wgPeerHandshake = 6
+	wgPeerRxBytes   = 7
+	wgPeerTxBytes   = 8
+	wgPeerAllowed   = 9
+	wgAllowedAddr   = 2
+	wgAllowedMask   = 3
+
+	// nlaTypeMask clears the nested and byte order flags of attribute types
+	nlaTypeMask = 0x3fff
+	// genlHeaderSize is the size of struct genlmsghdr
+	genlHeaderSize = 4
+)
+
+// netlinkAttr is one netlink attribute
+type netlinkAttr struct {
+	kind uint16
+	data []byte
+}
+
+// parseAttrs splits netlink attributes, dropping the nested and byte order
+// flags from their types
+func parseAttrs(b []byte) []netlinkAttr {
+	var attrs []netlinkAttr
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(b))
+		if length < unix.SizeofNlAttr || length > len(b) {
+			break
+		}
+		attrs = append(attrs, netlinkAttr{kind: binary.NativeEndian.Uint16(b[2:]) & nlaTypeMask, data: b[unix.SizeofNlAttr:length]})
+		b = b[min(nlAlign(length), len(b)):]
+	}
+	return attrs
+}
+
+// nlAlign rounds n up to the netlink alignment
+func nlAlign(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+// appendAttr appends a netlink attribute holding a NUL terminated string
+func appendAttr(b []byte, kind uint16, value string) []byte {
+	length := unix.SizeofNlAttr + len(value) + 1
+	b = binary.NativeEndian.AppendUint16(b, uint16(length))
+	b = binary.NativeEndian.AppendUint16(b, kind)
+	b = append(b, value...)
+	b = append(b, 0)
+	return append(b, make([]byte, nlAlign(length)-length)...)
+}
+
+// genlConn is a generic netlink socket
+type genlConn struct {
+	fd  int
+	seq uint32
+}
+
+// dialGenl opens a generic netlink socket
+func dialGenl() (*genlConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	tv := unix.NsecToTimeval(int64(5 * time.Second))
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	return &genlConn{fd: fd}, nil
+}
+
+// Close closes the socket
+func (c *genlConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// request sends a generic netlink command and returns the attributes of
+// every message of the reply, reading on to the end of dumps
+func (c *genlConn) request(family uint16, flags uint16, cmd, version uint8, attrs []byte) ([][]netlinkAttr, error) {
+	c.seq++
+	length := unix.SizeofNlMsghdr + genlHeaderSize + len(attrs)
+	msg := make([]byte, 0, length)
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(length))
+	msg = binary.NativeEndian.AppendUint16(msg, family)
+	msg = binary.NativeEndian.AppendUint16(msg, unix.NLM_F_REQUEST|flags)
+	msg = binary.NativeEndian.AppendUint32(msg, c.seq)
+	msg = binary.NativeEndian.AppendUint32(msg, 0)
+	msg = append(msg, cmd, version, 0, 0)
+	msg = append(msg, attrs...)
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var replies [][]netlinkAttr
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		b := buf[:n]
+		for len(b) >= unix.SizeofNlMsghdr {
+			length := int(binary.NativeEndian.Uint32(b))
+			kind := binary.NativeEndian.Uint16(b[4:])
+			seq := binary.NativeEndian.Uint32(b[8:])
+			if length < unix.SizeofNlMsghdr || length > len(b) {
+				return nil, errors.New("truncated netlink message")
+			}
+			body := b[unix.SizeofNlMsghdr:length]
+			b = b[min(nlAlign(length), len(b)):]
+			if seq != c.seq {
+				continue
+			}
+			switch kind {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(body) < 4 {
+					return nil, errors.New("truncated netlink error")
+				}
+				if errno := int32(binary.NativeEndian.Uint32(body)); errno != 0 {
+					return nil, unix.Errno(-errno)
+				}
+				return replies, nil
+			}
+			if len(body) >= genlHeaderSize {
+				replies = append(replies, parseAttrs(body[genlHeaderSize:]))
+			}
+			if flags&unix.NLM_F_DUMP == 0 {
+				return replies, nil
+			}
+		}
+	}
+}
+
+// family resolves the ID of a generic netlink family
+func (c *genlConn) family(name string) (uint16, error) {
+	replies, err := c.request(unix.GENL_ID_CTRL, 0, unix.CTRL_CMD_GETFAMILY, 1, appendAttr(nil, unix.CTRL_ATTR_FAMILY_NAME, name))
+	if err != nil {
+		return 0, err
+	}
+	for _, attrs := range replies {
+		for _, attr := range attrs {
+			if attr.kind == unix.CTRL_ATTR_FAMILY_ID && len(attr.data) >= 2 {
+				return binary.NativeEndian.Uint16(attr.data), nil
+			}
+		}
+	}
+	return 0, unix.ENOENT
+}
+
+// kernelDevice reads the state of a kernel WireGuard interface over
+// generic netlink, which needs CAP_NET_ADMIN
+func kernelDevice(name string) (device, error) {
+	var dev device
+	conn, err := dialGenl()
+	if err != nil {
+		return dev, err
+	}
+	defer conn.Close()
+
+	// Without the kernel module there are no kernel WireGuard interfaces
+	family, err := conn.family(wgGenlName)
+	if errors.Is(err, unix.ENOENT) {
+		return dev, errNotWireGuard
+	}
+	if err != nil {
+		return dev, fmt.Errorf("error resolving WireGuard netlink family: %w", err)
+	}
+	replies, err := conn.request(family, unix.NLM_F_DUMP, wgCmdGetDevice, wgGenlVersion, appendAttr(nil, wgDeviceIfname, name))
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENODEV) {
+		return dev, errNotWireGuard
+	}
+	if err != nil {
+		return dev, fmt.Errorf("error reading WireGuard interface %s: %w", name, err)
+	}
+
+	// Large devices are dumped in several messages, a peer continuing in the
+	// next one when its allowed IPs do not fit
+	for _, attrs := range replies {
+		for _, attr := range attrs {
+			switch attr.kind {
+			case wgDevicePubKey:
+				dev.publicKey = base64.StdEncoding.EncodeToString(attr.data)
+			case wgDevicePort:
+				if len(attr.data) >= 2 {
+					dev.listenPort = int(binary.NativeEndian.Uint16(attr.data))
+				}
+			case wgDevicePeers:
+				for _, nested := range parseAttrs(attr.data) {
+					p := parsePeer(parseAttrs(nested.data))
+					if n := len(dev.peers); n > 0 && dev.peers[n-1].publicKey == p.publicKey {
+						dev.peers[n-1].allowedIPs = append(dev.peers[n-1].allowedIPs, p.allowedIPs...)
+						continue
+					}
+					dev.peers = append(dev.peers, p)
+				}
+			}
+		}
+	}
+	return dev, nil
+}
+
+// parsePeer decodes the attributes of a WireGuard peer
+func parsePeer(attrs []netlinkAttr) peer {
+	var p peer
+	for _, attr := range attrs {
+		switch attr.kind {
+		case wgPeerPubKey:
+			p.publicKey = base64.StdEncoding.EncodeToString(attr.data)
+		case wgPeerEndpoint:
+			p.endpoint = parseSockaddr(attr.data)
+		case wgPeerKeepalive:
+			if len(attr.data) >= 2 {
+				p.keepalive = int(binary.NativeEndian.Uint16(attr.data))
+			}
+		case wgPeerHandshake:
+			// struct __kernel_timespec, zero when there was none
+			if len(attr.data) >= 16 {
+				sec := int64(binary.NativeEndian.Uint64(attr.data))
+				nsec := int64(binary.NativeEndian.Uint64(attr.data[8:]))
+				if sec != 0 || nsec != 0 {
+					p.lastHandshake = time.Unix(sec, nsec)
+				}
+			}
+		case wgPeerRxBytes:
+			if len(attr.data) >= 8 {
+				p.rxBytes = int64(binary.NativeEndian.Uint64(attr.data))
+			}
+		case wgPeerTxBytes:
+			if len(attr.data) >= 8 {
+				p.txBytes = int64(binary.NativeEndian.Uint64(attr.data))
+			}
+		case wgPeerAllowed:
+			for _, nested := range parseAttrs(attr.data) {
+				if prefix, ok := parseAllowedIP(parseAttrs(nested.data)); ok {
+					p.allowedIPs = append(p.allowedIPs, prefix)
+				}
+			}
+		}
+	}
+	return p
+}
+
+// parseAllowedIP decodes an allowed IP attribute as a prefix
+func parseAllowedIP(attrs []netlinkAttr) (string, bool) {
+	var addr netip.Addr
+	bits := -1
+	for _, attr := range attrs {
+		switch attr.kind {
+		case wgAllowedAddr:
+			addr, _ = netip.AddrFromSlice(attr.data)
+		case wgAllowedMask:
+			if len(attr.data) >= 1 {
+				bits = int(attr.data[0])
+			}
+		}
+	}
+	if !addr.IsValid() || bits < 0 {
+		return "", false
+	}
+	return netip.PrefixFrom(addr, bits).String(), true
+}
+
+// parseSockaddr decodes a struct sockaddr_in or sockaddr_in6 as host:port
+func parseSockaddr(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	port := binary.BigEndian.Uint16(b[2:])
+	var addr netip.Addr
+	switch binary.NativeEndian.Uint16(b) {
+	case unix.AF_INET:
+		if len(b) >= 8 {
+			addr = netip.AddrFrom4([4]byte(b[4:8]))
+		}
+	case unix.AF_INET6:
+		if len(b) >= 24 {
+			addr = netip.AddrFrom16([16]byte(b[8:24]))
+			if len(b) >= 28 {
+				if scope := binary.NativeEndian.Uint32(b[24:]); scope != 0 {
+					addr = addr.WithZone(strconv.FormatUint(uint64(scope), 10))
+				}
+			}
+		}
+	}
+	if !addr.IsValid() {
+		return ""
+	}
+	return netip.AddrPortFrom(addr, port).String()
+}
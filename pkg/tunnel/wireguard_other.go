@@ -0,0 +1,11 @@
+//go:build !linux
+
+package tunnel
+
+import "errors"
+
+// kernelDevice is only implemented on Linux, elsewhere WireGuard runs in
+// userspace and answers on its control socket
+func kernelDevice(name string) (device, error) {
+	return device{}, errors.New("WireGuard kernel interfaces are only supported on Linux")
+}
@@ -0,0 +1,236 @@
+package vcr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSInteraction is one DNS query and its response. Records are kept in zone
+// file syntax so cassettes can be read and edited by hand; the EDNS OPT
+// record of the response is not kept.
+type DNSInteraction struct {
+	Server   string   `json:"server"`           // Nameserver the query was sent to
+	Network  string   `json:"network"`          // "udp" or "tcp"
+	Question string   `json:"question"`         // Question, e.g. "example.com. IN A"
+	Rcode    string   `json:"rcode,omitempty"`  // Response code, e.g. "NOERROR"
+	Flags    []string `json:"flags,omitempty"`  // Header flags set in the response, e.g. "aa"
+	Answer   []string `json:"answer,omitempty"` // Answer section
+	Ns       []string `json:"ns,omitempty"`     // Authority section
+	Extra    []string `json:"extra,omitempty"`  // Additional section
+	Duration float64  `json:"duration"`         // Round-trip time in milliseconds
+	Error    string   `json:"error,omitempty"`  // Error of the exchange, replayed as is
+}
+
+// question returns the first question of msg with a lower case name
+func question(msg *dns.Msg) string {
+	if len(msg.Question) == 0 {
+		return ""
+	}
+	q := msg.Question[0]
+	return fmt.Sprintf("%s %s %s", strings.ToLower(q.Name), dns.ClassToString[q.Qclass], dns.TypeToString[q.Qtype])
+}
+
+// Exchange answers a query from the cassette in replay mode and sends it to
+// server in record mode. The cassette is a tool.DNSExchanger.
+func (c *Cassette) Exchange(msg *dns.Msg, network, server string, timeout time.Duration) (*dns.Msg, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.exchange(ctx, msg, network, server)
+}
+
+func (c *Cassette) exchange(ctx context.Context, msg *dns.Msg, network, server string) (*dns.Msg, time.Duration, error) {
+	if c.mode == ModeRecord {
+		client := &dns.Client{Net: network}
+		resp, rtt, err := client.ExchangeContext(ctx, msg, server)
+		c.record(&Interaction{Kind: "dns", DNS: newDNSInteraction(msg, resp, network, server, rtt, err)})
+		return resp, rtt, err
+	}
+
+	q := question(msg)
+	exact := func(i *Interaction) bool { return i.DNS.Question == q && i.DNS.Server == server }
+	loose := func(i *Interaction) bool { return i.DNS.Question == q }
+	i, err := c.find("dns", exact, loose, fmt.Sprintf("%s query %s", server, q))
+	if err != nil {
+		return nil, 0, err
+	}
+	return i.DNS.response(msg)
+}
+
+// newDNSInteraction records the response to a query
+func newDNSInteraction(msg, resp *dns.Msg, network, server string, rtt time.Duration, err error) *DNSInteraction {
+	i := &DNSInteraction{
+		Server:   server,
+		Network:  network,
+		Question: question(msg),
+		Duration: float64(rtt.Microseconds()) / 1000.0,
+	}
+	if err != nil {
+		i.Error = err.Error()
+		return i
+	}
+
+	i.Rcode = dns.RcodeToString[resp.Rcode]
+	flags := []struct {
+		name string
+		set  bool
+	}{
+		{"aa", resp.Authoritative},
+		{"tc", resp.Truncated},
+		{"rd", resp.RecursionDesired},
+		{"ra", resp.RecursionAvailable},
+		{"ad", resp.AuthenticatedData},
+		{"cd", resp.CheckingDisabled},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			i.Flags = append(i.Flags, flag.name)
+		}
+	}
+	i.Answer = recordStrings(resp.Answer)
+	i.Ns = recordStrings(resp.Ns)
+	i.Extra = recordStrings(resp.Extra)
+	return i
+}
+
+// recordStrings formats records in zone file syntax, leaving out OPT records
+func recordStrings(rrs []dns.RR) []string {
+	var records []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			records = append(records, rr.String())
+		}
+	}
+	return records
+}
+
+// parseRecords parses records in zone file syntax
+func parseRecords(records []string) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, fmt.Errorf("vcr: invalid recorded record %q: %w", record, err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// response rebuilds the recorded response as the answer to msg
+func (i *DNSInteraction) response(msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	rtt := time.Duration(i.Duration * float64(time.Millisecond))
+	if i.Error != "" {
+		return nil, rtt, errors.New(i.Error)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	rcode, ok := dns.StringToRcode[i.Rcode]
+	if !ok {
+		return nil, 0, fmt.Errorf("vcr: invalid recorded rcode %q", i.Rcode)
+	}
+	resp.Rcode = rcode
+	resp.RecursionDesired = false
+	for _, flag := range i.Flags {
+		switch flag {
+		case "aa":
+			resp.Authoritative = true
+		case "tc":
+			resp.Truncated = true
+		case "rd":
+			resp.RecursionDesired = true
+		case "ra":
+			resp.RecursionAvailable = true
+		case "ad":
+			resp.AuthenticatedData = true
+		case "cd":
+			resp.CheckingDisabled = true
+		}
+	}
+
+	var err error
+	if resp.Answer, err = parseRecords(i.Answer); err != nil {
+		return nil, 0, err
+	}
+	if resp.Ns, err = parseRecords(i.Ns); err != nil {
+		return nil, 0, err
+	}
+	if resp.Extra, err = parseRecords(i.Extra); err != nil {
+		return nil, 0, err
+	}
+	return resp, rtt, nil
+}
+
+// dialDNS is the Dial function of the resolver installed by the cassette
+func (c *Cassette) dialDNS(ctx context.Context, network, address string) (net.Conn, error) {
+	return &dnsConn{cassette: c, ctx: ctx, network: network, server: address}, nil
+}
+
+// dnsConn passes the queries of the Go resolver through the cassette. The
+// resolver frames messages with a length prefix on connections that are not
+// packet connections, so the same framing serves UDP and TCP queries.
+type dnsConn struct {
+	cassette *Cassette
+	ctx      context.Context
+	network  string
+	server   string
+
+	mu      sync.Mutex
+	queries bytes.Buffer // Query bytes written but not yet complete
+	replies bytes.Buffer // Framed responses not yet read
+}
+
+func (conn *dnsConn) Write(b []byte) (int, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.queries.Write(b)
+	for conn.queries.Len() >= 2 {
+		size := int(binary.BigEndian.Uint16(conn.queries.Bytes()))
+		if conn.queries.Len() < 2+size {
+			break
+		}
+		conn.queries.Next(2)
+		msg := new(dns.Msg)
+		if err := msg.Unpack(conn.queries.Next(size)); err != nil {
+			return 0, err
+		}
+		resp, _, err := conn.cassette.exchange(conn.ctx, msg, conn.network, conn.server)
+		if err != nil {
+			return 0, err
+		}
+		data, err := resp.Pack()
+		if err != nil {
+			return 0, err
+		}
+		conn.replies.Write(binary.BigEndian.AppendUint16(nil, uint16(len(data))))
+		conn.replies.Write(data)
+	}
+	return len(b), nil
+}
+
+func (conn *dnsConn) Read(b []byte) (int, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.replies.Len() == 0 {
+		return 0, io.EOF
+	}
+	return conn.replies.Read(b)
+}
+
+func (conn *dnsConn) Close() error                       { return nil }
+func (conn *dnsConn) LocalAddr() net.Addr                { return &net.UDPAddr{IP: net.IPv4zero} }
+func (conn *dnsConn) RemoteAddr() net.Addr               { return &net.UDPAddr{IP: net.IPv4zero} }
+func (conn *dnsConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *dnsConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *dnsConn) SetWriteDeadline(t time.Time) error { return nil }
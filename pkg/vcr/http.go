@@ -0,0 +1,104 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// HTTPInteraction is one HTTP request and its response. Requests are matched
+// on method and URL; request bodies are not recorded.
+type HTTPInteraction struct {
+	Method   string      `json:"method"`           // Request method
+	URL      string      `json:"url"`              // Request URL
+	Status   int         `json:"status,omitempty"` // Response status code
+	Header   http.Header `json:"header,omitempty"` // Response header
+	Body     string      `json:"body,omitempty"`   // Response body
+	Base64   bool        `json:"base64,omitempty"` // Whether the body is base64 encoded, for bodies that are not UTF-8
+	Duration float64     `json:"duration"`         // Time until the body was read in milliseconds
+	Error    string      `json:"error,omitempty"`  // Error of the request, replayed as is
+}
+
+// RoundTrip answers a request from the cassette in replay mode and sends it
+// to the real upstream in record mode. The cassette is installed as
+// http.DefaultTransport.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.mode == ModeRecord {
+		return c.recordHTTP(req)
+	}
+
+	url := req.URL.String()
+	match := func(i *Interaction) bool { return i.HTTP.Method == req.Method && i.HTTP.URL == url }
+	i, err := c.find("http", match, match, req.Method+" "+url)
+	if err != nil {
+		return nil, err
+	}
+	return i.HTTP.response(req)
+}
+
+// recordHTTP sends the request upstream and records the response, reading
+// the whole body so it can be stored
+func (c *Cassette) recordHTTP(req *http.Request) (*http.Response, error) {
+	i := &HTTPInteraction{Method: req.Method, URL: req.URL.String()}
+	defer c.record(&Interaction{Kind: "http", HTTP: i})
+
+	start := time.Now()
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		i.Error = err.Error()
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	i.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	if err != nil {
+		i.Error = err.Error()
+		return nil, err
+	}
+
+	i.Status, i.Header = resp.StatusCode, resp.Header
+	if utf8.Valid(body) {
+		i.Body = string(body)
+	} else {
+		i.Body, i.Base64 = base64.StdEncoding.EncodeToString(body), true
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// response rebuilds the recorded response as the answer to req
+func (i *HTTPInteraction) response(req *http.Request) (*http.Response, error) {
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+	body := []byte(i.Body)
+	if i.Base64 {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(i.Body); err != nil {
+			return nil, fmt.Errorf("vcr: invalid recorded body of %s: %w", i.URL, err)
+		}
+	}
+
+	header := i.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,178 @@
+// Package vcr records the upstream DNS and HTTP interactions of tools into
+// cassettes and replays them, so that tests of tools that talk to the
+// Internet run hermetically and don't break when an upstream is flaky
+package vcr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Cassette modes
+const (
+	ModeReplay = "replay" // Answer from the cassette without touching the network
+	ModeRecord = "record" // Talk to the real upstreams and save what they answer
+)
+
+// RecordEnv selects ModeRecord for Start when set to a non-empty value
+const RecordEnv = "VCR_RECORD"
+
+// ErrNotRecorded is returned in replay mode for requests the cassette has no
+// interaction for
+var ErrNotRecorded = errors.New("vcr: interaction not recorded")
+
+// Interaction is one upstream request and its response
+type Interaction struct {
+	Kind string           `json:"kind"`           // "dns" or "http"
+	DNS  *DNSInteraction  `json:"dns,omitempty"`  // Set for DNS queries
+	HTTP *HTTPInteraction `json:"http,omitempty"` // Set for HTTP requests
+
+	used bool // Whether the interaction was replayed
+}
+
+// Cassette holds the interactions recorded for a test
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+
+	mu        sync.Mutex
+	path      string
+	mode      string
+	transport http.RoundTripper // Transport that reaches the real upstreams
+	misses    []string          // Requests replay found no interaction for
+}
+
+// Start installs the cassette testdata/cassettes/<name>.json for the rest of
+// the test. It replays the cassette unless VCR_RECORD is set, in which case
+// the test talks to the real upstreams and the cassette is rewritten when
+// the test passes.
+func Start(t testing.TB, name string) *Cassette {
+	mode := ModeReplay
+	if os.Getenv(RecordEnv) != "" {
+		mode = ModeRecord
+	}
+	return StartFile(t, filepath.Join("testdata", "cassettes", name+".json"), mode)
+}
+
+// StartFile installs the cassette stored at path in the given mode for the
+// rest of the test. Cassettes replace process wide transports, so tests using
+// them must not run in parallel.
+func StartFile(t testing.TB, path, mode string) *Cassette {
+	t.Helper()
+	// Setenv panics in parallel tests, which a cassette would leak into
+	t.Setenv("VCR_CASSETTE", path)
+
+	c, err := open(path, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := c.install()
+	t.Cleanup(func() {
+		restore()
+		for _, miss := range c.misses {
+			t.Logf("%v: %s", ErrNotRecorded, miss)
+		}
+		if c.mode == ModeRecord && !t.Failed() {
+			if err := c.save(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	return c
+}
+
+// open loads the cassette at path, or starts an empty one for recording
+func open(path, mode string) (*Cassette, error) {
+	c := &Cassette{path: path, mode: mode, Interactions: []*Interaction{}}
+	switch mode {
+	case ModeRecord:
+		return c, nil
+	case ModeReplay:
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cassette, record it with %s=1: %w", RecordEnv, err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error decoding cassette %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// save writes the recorded interactions to the cassette file
+func (c *Cassette) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("error creating cassette directory: %w", err)
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+// install routes the default HTTP transport, the default resolver and
+// tool.UpstreamDNS through the cassette and returns a function undoing it
+func (c *Cassette) install() func() {
+	transport, resolver, upstream := http.DefaultTransport, net.DefaultResolver, tool.UpstreamDNS
+	c.transport = transport
+
+	http.DefaultTransport = c
+	net.DefaultResolver = &net.Resolver{PreferGo: true, Dial: c.dialDNS}
+	tool.UpstreamDNS = c
+	return func() {
+		http.DefaultTransport, net.DefaultResolver, tool.UpstreamDNS = transport, resolver, upstream
+	}
+}
+
+// record appends an interaction in record mode
+func (c *Cassette) record(i *Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, i)
+}
+
+// find returns the interaction answering a request. Unused interactions
+// matching exactly come first, then unused ones matching loosely; once all
+// matches were used the last one is replayed again, so repeated requests get
+// the last recorded answer. Requests without any match are recorded as
+// misses.
+func (c *Cassette) find(kind string, exact, loose func(*Interaction) bool, request string) (*Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reuse [2]*Interaction
+	for n, match := range []func(*Interaction) bool{exact, loose} {
+		for _, i := range c.Interactions {
+			if i.Kind != kind || !match(i) {
+				continue
+			}
+			if !i.used {
+				i.used = true
+				return i, nil
+			}
+			reuse[n] = i
+		}
+	}
+	for _, i := range reuse {
+		if i != nil {
+			return i, nil
+		}
+	}
+	c.misses = append(c.misses, request)
+	return nil, fmt.Errorf("%w: %s", ErrNotRecorded, request)
+}
@@ -0,0 +1,141 @@
+package vcr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"github.com/miekg/dns"
+)
+
+// newNameserver starts a local nameserver answering every A and AAAA query
+// with a documentation address and returns its address
+func newNameserver(t *testing.T) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		resp.Authoritative = true
+		q := r.Question[0]
+		switch q.Qtype {
+		case dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 300 IN A 192.0.2.10")
+			resp.Answer = append(resp.Answer, rr)
+		case dns.TypeAAAA:
+			rr, _ := dns.NewRR(q.Name + " 300 IN AAAA 2001:db8::10")
+			resp.Answer = append(resp.Answer, rr)
+		}
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	return conn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func query(name string, qtype uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	return msg
+}
+
+func TestDNSRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns.json")
+	server, shutdown := newNameserver(t)
+
+	t.Run("record", func(t *testing.T) {
+		StartFile(t, path, ModeRecord)
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if _, _, err := tool.UpstreamDNS.Exchange(query("host.example.com.", qtype), "udp", server, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	shutdown()
+
+	t.Run("replay", func(t *testing.T) {
+		StartFile(t, path, ModeReplay)
+		resp, _, err := tool.UpstreamDNS.Exchange(query("HOST.example.com.", dns.TypeA), "udp", server, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.0.2.10" || !resp.Authoritative {
+			t.Fatalf("unexpected replayed response %v", resp)
+		}
+		if _, _, err := tool.UpstreamDNS.Exchange(query("other.example.com.", dns.TypeA), "udp", server, time.Second); !errors.Is(err, ErrNotRecorded) {
+			t.Fatalf("got %v for a query that was not recorded, want ErrNotRecorded", err)
+		}
+	})
+
+	t.Run("resolver", func(t *testing.T) {
+		// The resolver asks the system nameserver, which the queries were
+		// not recorded against, so they match on the question alone
+		StartFile(t, path, ModeReplay)
+		addrs, err := net.DefaultResolver.LookupHost(context.Background(), "host.example.com.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(addrs)
+		if want := []string{"192.0.2.10", "2001:db8::10"}; !slices.Equal(addrs, want) {
+			t.Fatalf("got addresses %v, want %v", addrs, want)
+		}
+	})
+}
+
+func TestHTTPRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.json")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/binary" {
+			w.Write([]byte{0xff, 0x00, 0xfe})
+			return
+		}
+		w.Header().Set("X-Upstream", "recorded")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello\n"))
+	}))
+
+	get := func(t *testing.T, url string) (*http.Response, []byte) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	t.Run("record", func(t *testing.T) {
+		StartFile(t, path, ModeRecord)
+		get(t, upstream.URL+"/text")
+		get(t, upstream.URL+"/binary")
+	})
+	upstream.Close()
+
+	t.Run("replay", func(t *testing.T) {
+		StartFile(t, path, ModeReplay)
+		for range 2 {
+			resp, body := get(t, upstream.URL+"/text")
+			if resp.StatusCode != http.StatusTeapot || resp.Header.Get("X-Upstream") != "recorded" || string(body) != "hello\n" {
+				t.Fatalf("unexpected replayed response %d %v %q", resp.StatusCode, resp.Header, body)
+			}
+		}
+		if _, body := get(t, upstream.URL+"/binary"); string(body) != "\xff\x00\xfe" {
+			t.Fatalf("got binary body %q", body)
+		}
+		if _, err := http.Get(upstream.URL + "/missing"); !errors.Is(err, ErrNotRecorded) {
+			t.Fatalf("got %v for a request that was not recorded, want ErrNotRecorded", err)
+		}
+	})
+}
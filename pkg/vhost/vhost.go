@@ -0,0 +1,287 @@
+package vhost
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for virtual host matrix options
+const (
+	defaultPath    = "/" // Path requested from every virtual host
+	defaultTimeout = 10  // 10 second timeout per virtual host
+	maxBodySize    = 1 << 20
+	maxNames       = 64
+)
+
+// titlePattern extracts the HTML title of a response body
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// VHostMessage represents the incoming virtual host matrix request
+type VHostMessage struct {
+	// Required
+	Address string   `json:"address"` // IP or host (optionally with port) every request is sent to
+	Names   []string `json:"names"`   // SNI values and Host headers to try
+
+	// Optional parameters
+	Path    *string `json:"path,omitempty"`    // Path to request
+	TLS     *bool   `json:"tls,omitempty"`     // Use TLS with SNI (false sends plain HTTP with only a Host header)
+	Timeout *int    `json:"timeout,omitempty"` // Timeout in seconds per name
+}
+
+// CertificateInfo describes the certificate a server returned
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`                // Subject common name
+	Issuer      string    `json:"issuer"`                 // Issuer common name
+	DNSNames    []string  `json:"dns_names"`              // Subject alternative names
+	NotAfter    time.Time `json:"not_after"`              // Expiry time
+	Fingerprint string    `json:"fingerprint"`            // SHA-256 fingerprint of the leaf certificate
+	Valid       bool      `json:"valid"`                  // Whether the chain verifies for the requested name
+	VerifyError string    `json:"verify_error,omitempty"` // Why verification failed
+}
+
+// HostMessage reports what one name returned
+type HostMessage struct {
+	Type          string           `json:"type"`                  // Message type ("host")
+	Name          string           `json:"name"`                  // SNI value and Host header sent
+	Success       bool             `json:"success"`               // Whether a response was received
+	Error         string           `json:"error,omitempty"`       // Error when no response was received
+	TLSVersion    string           `json:"tls_version,omitempty"` // Negotiated TLS version
+	Certificate   *CertificateInfo `json:"certificate,omitempty"` // Certificate presented for the name
+	Status        int              `json:"status,omitempty"`      // HTTP status code
+	Server        string           `json:"server,omitempty"`      // Server response header
+	Location      string           `json:"location,omitempty"`    // Redirect target
+	ContentLength int              `json:"content_length"`        // Bytes of body read
+	BodyHash      string           `json:"body_hash,omitempty"`   // SHA-256 of the body
+	Title         string           `json:"title,omitempty"`       // HTML title of the body
+	Duration      float64          `json:"duration"`              // Request duration in milliseconds
+}
+
+// SummaryMessage groups names by the certificate and content they returned
+type SummaryMessage struct {
+	Type         string              `json:"type"`         // Message type ("summary")
+	Address      string              `json:"address"`      // Address that was tested
+	Certificates map[string][]string `json:"certificates"` // Names per certificate fingerprint
+	Bodies       map[string][]string `json:"bodies"`       // Names per body hash
+	Failed       []string            `json:"failed"`       // Names that got no response
+}
+
+// VHostOptions contains the resolved virtual host matrix options
+type VHostOptions struct {
+	Address string
+	Names   []string
+	Path    string
+	UseTLS  bool
+	Timeout int
+}
+
+// resolveVHostOptions converts VHostMessage to VHostOptions with defaults
+func resolveVHostOptions(msg *VHostMessage) (VHostOptions, error) {
+	opts := VHostOptions{
+		Address: strings.TrimSpace(msg.Address),
+		Names:   msg.Names,
+		Path:    tool.GetOrDefault(msg.Path, defaultPath),
+		UseTLS:  tool.GetOrDefault(msg.TLS, true),
+		Timeout: tool.GetOrDefault(msg.Timeout, defaultTimeout),
+	}
+
+	if opts.Address == "" {
+		return opts, fmt.Errorf("address is required")
+	}
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		port := "80"
+		if opts.UseTLS {
+			port = "443"
+		}
+		opts.Address = net.JoinHostPort(strings.Trim(opts.Address, "[]"), port)
+	}
+	if len(opts.Names) == 0 {
+		return opts, fmt.Errorf("at least one name is required")
+	}
+	if len(opts.Names) > maxNames {
+		return opts, fmt.Errorf("at most %d names can be tested", maxNames)
+	}
+	for _, name := range opts.Names {
+		if name == "" || strings.ContainsAny(name, " /") {
+			return opts, fmt.Errorf("invalid name %q", name)
+		}
+	}
+	if !strings.HasPrefix(opts.Path, "/") {
+		return opts, fmt.Errorf("path must start with /")
+	}
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+	return opts, nil
+}
+
+// certificateInfo describes the leaf certificate and verifies the chain for name
+func certificateInfo(state *tls.ConnectionState, name string) *CertificateInfo {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+	sum := sha256.Sum256(leaf.Raw)
+	info := &CertificateInfo{
+		Subject:     leaf.Subject.CommonName,
+		Issuer:      leaf.Issuer.CommonName,
+		DNSNames:    leaf.DNSNames,
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	host, _, err := net.SplitHostPort(name)
+	if err != nil {
+		host = name
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	info.Valid = err == nil
+	if err != nil {
+		info.VerifyError = err.Error()
+	}
+	return info
+}
+
+// probe sends one request to the fixed address using name for SNI and Host
+func probe(ctx context.Context, opts VHostOptions, name string) (result HostMessage) {
+	result = HostMessage{Type: "host", Name: name}
+	start := time.Now()
+	defer func() {
+		result.Duration = float64(time.Since(start).Microseconds()) / 1000.0
+	}()
+
+	sni, _, err := net.SplitHostPort(name)
+	if err != nil {
+		sni = name
+	}
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, opts.Address)
+		},
+		// Certificates are verified per name in certificateInfo so that
+		// mismatches are reported rather than aborting the request
+		TLSClientConfig:   &tls.Config{ServerName: sni, InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	scheme := "http"
+	if opts.UseTLS {
+		scheme = "https"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+name+opts.Path, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	tool.Identify(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Success = true
+	result.Status = resp.StatusCode
+	result.Server = resp.Header.Get("Server")
+	result.Location = resp.Header.Get("Location")
+	if resp.TLS != nil {
+		result.TLSVersion = tls.VersionName(resp.TLS.Version)
+		result.Certificate = certificateInfo(resp.TLS, name)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		result.Error = fmt.Sprintf("error reading body: %v", err)
+	}
+	sum := sha256.Sum256(body)
+	result.ContentLength = len(body)
+	result.BodyHash = hex.EncodeToString(sum[:])
+	if m := titlePattern.FindSubmatch(body); m != nil {
+		result.Title = strings.TrimSpace(html.UnescapeString(string(m[1])))
+	}
+	return result
+}
+
+// Handler handles WebSocket virtual host matrix requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "vhost")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg VHostMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading vhost message: %v", err)
+		return
+	}
+
+	opts, err := resolveVHostOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid vhost options: %v", err)
+		return
+	}
+
+	summary := SummaryMessage{
+		Type:         "summary",
+		Address:      opts.Address,
+		Certificates: make(map[string][]string),
+		Bodies:       make(map[string][]string),
+		Failed:       []string{},
+	}
+	for _, name := range opts.Names {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+		result := probe(ctx, opts, name)
+		cancel()
+
+		switch {
+		case !result.Success:
+			summary.Failed = append(summary.Failed, name)
+			log.Printf("vhost %s at %s: %s", name, opts.Address, result.Error)
+		default:
+			if result.Certificate != nil {
+				fp := result.Certificate.Fingerprint
+				summary.Certificates[fp] = append(summary.Certificates[fp], name)
+			}
+			key := strconv.Itoa(result.Status) + " " + result.BodyHash
+			summary.Bodies[key] = append(summary.Bodies[key], name)
+		}
+
+		if err := session.WriteJSON(result); err != nil {
+			log.Printf("Failed to send host result: %v", err)
+			return
+		}
+	}
+
+	if err := session.WriteJSON(summary); err != nil {
+		log.Printf("Failed to send summary: %v", err)
+	}
+}
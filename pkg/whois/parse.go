@@ -0,0 +1,228 @@
+package whois
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Record holds the fields of interest across the formats registries and
+// registrars use. Dates are RFC 3339 when they could be parsed and as given
+// otherwise.
+type Record struct {
+	// Domains
+	Domain      string   `json:"domain,omitempty"`       // Domain name
+	Registrar   string   `json:"registrar,omitempty"`    // Sponsoring registrar
+	WhoisServer string   `json:"whois_server,omitempty"` // Registrar whois server
+	Nameservers []string `json:"nameservers,omitempty"`  // Delegated nameservers
+	Status      []string `json:"status,omitempty"`       // EPP status codes, e.g. clientTransferProhibited
+	DNSSEC      string   `json:"dnssec,omitempty"`       // Whether the delegation is signed
+
+	// IP networks and autonomous systems
+	Network      string `json:"network,omitempty"`      // Address range, e.g. 192.0.2.0 - 192.0.2.255
+	CIDR         string `json:"cidr,omitempty"`         // Range as prefixes
+	NetName      string `json:"net_name,omitempty"`     // Network name
+	ASN          string `json:"asn,omitempty"`          // Autonomous system number
+	ASName       string `json:"as_name,omitempty"`      // Autonomous system name
+	OriginAS     string `json:"origin_as,omitempty"`    // Autonomous system announcing the network
+	Organization string `json:"organization,omitempty"` // Holder of the domain, network or AS
+	Country      string `json:"country,omitempty"`      // Country code of the holder
+
+	// Both
+	Created     string   `json:"created,omitempty"`      // Registration date
+	Updated     string   `json:"updated,omitempty"`      // Last change
+	Expires     string   `json:"expires,omitempty"`      // Expiry date of domains
+	AbuseEmails []string `json:"abuse_emails,omitempty"` // Where to report abuse
+	AbusePhones []string `json:"abuse_phones,omitempty"` // Abuse contact phone numbers
+
+	referral string // Server the response refers to
+}
+
+// abuseComment matches the abuse contact RIPE and AFRINIC put in comments
+var abuseComment = regexp.MustCompile(`(?i)^%\s*abuse contact for .* is '([^']+)'`)
+
+// dateLayouts are the date formats seen in whois responses
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05.0Z",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006.01.02",
+	"2006/01/02",
+	"02-Jan-2006",
+	"02-January-2006",
+	"2006-01-02 (YYYY-MM-DD)",
+	"20060102",
+}
+
+// normalizeDate converts a date to RFC 3339 in UTC, returning it unchanged
+// when no layout matches
+func normalizeDate(value string) string {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return value
+}
+
+// referralServer returns the host of a referral, which ARIN gives as a
+// whois:// URL. Referrals to rwhois and web servers are not followed.
+func referralServer(value string) string {
+	value = strings.TrimSpace(value)
+	if rest, ok := strings.CutPrefix(strings.ToLower(value), "whois://"); ok {
+		value = strings.TrimSuffix(rest, "/")
+	}
+	if value == "" || strings.Contains(value, "://") || strings.ContainsAny(value, " /") {
+		return ""
+	}
+	return strings.ToLower(value)
+}
+
+// appendUnique appends value unless it is empty or already present
+func appendUnique(values []string, value string) []string {
+	if value == "" || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// set stores one key and value of a response
+func (r *Record) set(key, value string) {
+	first, _, _ := strings.Cut(value, " ")
+	switch key {
+	case "domain name", "domain":
+		r.Domain = strings.ToLower(value)
+	case "registrar", "sponsoring registrar", "registrar name":
+		r.Registrar = value
+	case "registrar whois server", "whois server":
+		r.WhoisServer = value
+		r.referral = referralServer(value)
+	case "refer", "whois", "referralserver":
+		if server := referralServer(value); server != "" {
+			r.referral = server
+		}
+	case "name server", "nameserver", "nameservers", "nserver", "name servers":
+		r.Nameservers = appendUnique(r.Nameservers, strings.TrimSuffix(strings.ToLower(first), "."))
+	case "domain status", "status", "state":
+		if r.Domain != "" {
+			r.Status = appendUnique(r.Status, first)
+		}
+	case "dnssec":
+		r.DNSSEC = value
+	case "netrange", "inetnum", "inet6num":
+		r.Network = value
+	case "cidr", "route", "route6":
+		r.CIDR = value
+	case "netname":
+		r.NetName = value
+	case "asnumber", "aut-num":
+		r.ASN = strings.TrimPrefix(strings.ToUpper(value), "AS")
+	case "asname", "as-name":
+		r.ASName = value
+	case "originas", "origin":
+		r.OriginAS = value
+	case "registrant organization", "registrant organisation", "orgname", "org-name", "organization", "organisation", "descr":
+		if r.Organization == "" {
+			r.Organization = value
+		}
+	case "registrant country", "country":
+		if r.Country == "" {
+			r.Country = strings.ToUpper(value)
+		}
+	case "creation date", "created", "created on", "registered on", "registration time", "regdate", "registered":
+		if r.Created == "" {
+			r.Created = normalizeDate(value)
+		}
+	case "updated date", "updated", "last updated", "last-modified", "changed", "last modified":
+		r.Updated = normalizeDate(value)
+	case "registry expiry date", "registrar registration expiration date", "expiry date", "expiration date", "expires", "expires on", "paid-till", "expiration time":
+		if r.Expires == "" {
+			r.Expires = normalizeDate(value)
+		}
+	case "registrar abuse contact email", "orgabuseemail", "abuse-mailbox", "abuse email":
+		r.AbuseEmails = appendUnique(r.AbuseEmails, strings.ToLower(value))
+	case "registrar abuse contact phone", "orgabusephone", "abuse phone":
+		r.AbusePhones = appendUnique(r.AbusePhones, value)
+	}
+}
+
+// parse extracts the fields of a response of "key: value" lines. Keys
+// without a value, as .uk and .eu use, take each following line until a
+// blank line as a value. Parsing stops at the ">>> Last update" line that
+// ends the record in ICANN formatted responses.
+func parse(raw string) Record {
+	var r Record
+	var block string
+	for _, line := range strings.Split(raw, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, ">>>") {
+			break
+		}
+		if m := abuseComment.FindStringSubmatch(trimmed); m != nil {
+			r.AbuseEmails = appendUnique(r.AbuseEmails, strings.ToLower(m[1]))
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "#") {
+			block = ""
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if ok && value == "" {
+			block = key
+			continue
+		}
+		// Colons without a following space are part of values such as IPv6
+		// addresses and URLs
+		if block != "" && !strings.Contains(trimmed, ": ") && !strings.Contains(trimmed, ":\t") {
+			r.set(block, trimmed)
+			continue
+		}
+		if ok {
+			r.set(key, value)
+		}
+	}
+	return r
+}
+
+// merge fills the empty fields of r from other
+func (r *Record) merge(other Record) {
+	for _, field := range []struct{ dst, src *string }{
+		{&r.Domain, &other.Domain},
+		{&r.Registrar, &other.Registrar},
+		{&r.WhoisServer, &other.WhoisServer},
+		{&r.DNSSEC, &other.DNSSEC},
+		{&r.Network, &other.Network},
+		{&r.CIDR, &other.CIDR},
+		{&r.NetName, &other.NetName},
+		{&r.ASN, &other.ASN},
+		{&r.ASName, &other.ASName},
+		{&r.OriginAS, &other.OriginAS},
+		{&r.Organization, &other.Organization},
+		{&r.Country, &other.Country},
+		{&r.Created, &other.Created},
+		{&r.Updated, &other.Updated},
+		{&r.Expires, &other.Expires},
+	} {
+		if *field.dst == "" {
+			*field.dst = *field.src
+		}
+	}
+	for _, field := range []struct{ dst, src *[]string }{
+		{&r.Nameservers, &other.Nameservers},
+		{&r.Status, &other.Status},
+		{&r.AbuseEmails, &other.AbuseEmails},
+		{&r.AbusePhones, &other.AbusePhones},
+	} {
+		if len(*field.dst) == 0 {
+			*field.dst = *field.src
+		}
+	}
+}
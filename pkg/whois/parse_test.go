@@ -0,0 +1,187 @@
+package whois
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const icannResponse = `   Domain Name: EXAMPLE.COM
+   Registry Domain ID: 2336799_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.example-registrar.com
+   Registrar: Example Registrar, Inc.
+   Updated Date: 2024-08-14T07:01:34Z
+   Creation Date: 1995-08-14T04:00:00Z
+   Registry Expiry Date: 2025-08-13T04:00:00Z
+   Registrar Abuse Contact Email: Abuse@Example-Registrar.com
+   Registrar Abuse Contact Phone: +1.5555551234
+   Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+   Name Server: A.IANA-SERVERS.NET
+   Name Server: B.IANA-SERVERS.NET.
+   Name Server: a.iana-servers.net
+   DNSSEC: signedDelegation
+>>> Last update of whois database: 2024-09-01T00:00:00Z <<<
+
+Registrant Organization: Terms of use
+`
+
+const ripeResponse = `% This is the RIPE Database query service.
+% Abuse contact for '192.0.2.0 - 192.0.2.255' is 'Abuse@Example.net'
+
+inetnum:        192.0.2.0 - 192.0.2.255
+netname:        EXAMPLE-NET
+descr:          Example Networks
+descr:          Amsterdam
+country:        nl
+status:         ASSIGNED PA
+created:        2010-01-01T00:00:00Z
+last-modified:  2020-02-03T04:05:06Z
+
+route:          192.0.2.0/24
+origin:         AS64496
+`
+
+const ukResponse = `
+    Domain name:
+        example.co.uk
+
+    Registrar:
+        Example Registrar Ltd [Tag = EXAMPLE]
+        URL: https://www.example.net
+
+    Relevant dates:
+        Registered on: 01-Jan-2000
+        Expiry date:  01-Jan-2030
+        Last updated:  02-Feb-2024
+
+    Name servers:
+        ns1.example.net   192.0.2.1
+        ns2.example.net   2001:db8::1
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Record
+	}{
+		{name: "icann", raw: icannResponse, want: Record{
+			Domain:      "example.com",
+			Registrar:   "Example Registrar, Inc.",
+			WhoisServer: "whois.example-registrar.com",
+			Nameservers: []string{"a.iana-servers.net", "b.iana-servers.net"},
+			Status:      []string{"clientDeleteProhibited", "clientTransferProhibited"},
+			DNSSEC:      "signedDelegation",
+			Created:     "1995-08-14T04:00:00Z",
+			Updated:     "2024-08-14T07:01:34Z",
+			Expires:     "2025-08-13T04:00:00Z",
+			AbuseEmails: []string{"abuse@example-registrar.com"},
+			AbusePhones: []string{"+1.5555551234"},
+			referral:    "whois.example-registrar.com",
+		}},
+		{name: "ripe", raw: ripeResponse, want: Record{
+			Network:      "192.0.2.0 - 192.0.2.255",
+			CIDR:         "192.0.2.0/24",
+			NetName:      "EXAMPLE-NET",
+			OriginAS:     "AS64496",
+			Organization: "Example Networks",
+			Country:      "NL",
+			Created:      "2010-01-01T00:00:00Z",
+			Updated:      "2020-02-03T04:05:06Z",
+			AbuseEmails:  []string{"abuse@example.net"},
+		}},
+		{name: "block values", raw: ukResponse, want: Record{
+			Domain:      "example.co.uk",
+			Registrar:   "Example Registrar Ltd [Tag = EXAMPLE]",
+			Nameservers: []string{"ns1.example.net", "ns2.example.net"},
+			Created:     "2000-01-01T00:00:00Z",
+			Updated:     "2024-02-02T00:00:00Z",
+			Expires:     "2030-01-01T00:00:00Z",
+		}},
+		{name: "autonomous system", raw: "aut-num:   as64496\nas-name:   EXAMPLE-AS\norg-name:  Example Org\n", want: Record{
+			ASN:          "64496",
+			ASName:       "EXAMPLE-AS",
+			Organization: "Example Org",
+		}},
+		{name: "referral", raw: "NetRange: 192.0.2.0 - 192.0.2.255\nReferralServer: whois://whois.ripe.net\nReferralServer: rwhois://rwhois.example.net:4321\n", want: Record{
+			Network:  "192.0.2.0 - 192.0.2.255",
+			referral: "whois.ripe.net",
+		}},
+		{name: "empty", raw: "", want: Record{}},
+		{name: "no keys", raw: "No match for \"EXAMPLE.INVALID\".\r\n", want: Record{}},
+		{name: "key without block", raw: "Domain Name:\n\nexample.com\n", want: Record{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parse(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	tests := map[string]string{
+		"2024-08-14T07:01:34Z":      "2024-08-14T07:01:34Z",
+		"2024-08-14T07:01:34+02:00": "2024-08-14T05:01:34Z",
+		"2024-08-14T07:01:34.0Z":    "2024-08-14T07:01:34Z",
+		"2024-08-14 07:01:34":       "2024-08-14T07:01:34Z",
+		"2024-08-14":                "2024-08-14T00:00:00Z",
+		"2024.08.14":                "2024-08-14T00:00:00Z",
+		"2024/08/14":                "2024-08-14T00:00:00Z",
+		"14-Aug-2024":               "2024-08-14T00:00:00Z",
+		"14-August-2024":            "2024-08-14T00:00:00Z",
+		"2024-08-14 (YYYY-MM-DD)":   "2024-08-14T00:00:00Z",
+		"20240814":                  "2024-08-14T00:00:00Z",
+		"before Aug-1996":           "before Aug-1996",
+		"":                          "",
+	}
+	for value, want := range tests {
+		if got := normalizeDate(value); got != want {
+			t.Errorf("normalizeDate(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestReferralServer(t *testing.T) {
+	tests := map[string]string{
+		"whois.ripe.net":                   "whois.ripe.net",
+		" Whois.Example-Registrar.com ":    "whois.example-registrar.com",
+		"whois://whois.apnic.net":          "whois.apnic.net",
+		"WHOIS://whois.lacnic.net/":        "whois.lacnic.net",
+		"whois://whois.example.net:4343":   "whois.example.net:4343",
+		"whois://whois.example.net/path":   "",
+		"whois://see the registrar":        "",
+		"rwhois://rwhois.example.net:4321": "",
+		"https://rdap.example.net":         "",
+		"see the registrar":                "",
+		"":                                 "",
+	}
+	for value, want := range tests {
+		if got := referralServer(value); got != want {
+			t.Errorf("referralServer(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{icannResponse, ripeResponse, ukResponse, "refer: whois.verisign-grs.com\n", "ReferralServer: whois://whois.example.net/a b\n", "Name servers:\n:\n\t:x\n"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		r := parse(raw)
+		for _, values := range [][]string{r.Nameservers, r.Status, r.AbuseEmails, r.AbusePhones} {
+			for i, v := range values {
+				if v == "" || slices.Contains(values[i+1:], v) {
+					t.Fatalf("parse() list %q has empty or repeated values", values)
+				}
+			}
+		}
+		if strings.ContainsAny(r.referral, " /") {
+			t.Fatalf("parse() referral %q is not a host", r.referral)
+		}
+	})
+}
@@ -0,0 +1,397 @@
+package whois
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// RDAP bootstrap registries (RFC 9224)
+const (
+	bootstrapURL = "https://data.iana.org/rdap/"
+	bootstrapTTL = 24 * time.Hour // How long a fetched registry is used
+)
+
+// rdapClient fetches bootstrap registries and RDAP responses. Redirects are
+// followed, as registries commonly redirect to the authoritative server.
+var rdapClient = &http.Client{}
+
+// Bootstrap files of each query kind
+var bootstrapFiles = map[string]string{
+	KindDomain: "dns.json",
+	"ipv4":     "ipv4.json",
+	"ipv6":     "ipv6.json",
+	KindASN:    "asn.json",
+}
+
+// bootstrapService maps the entries of a registry, TLDs, prefixes or AS
+// number ranges, to the base URLs of their RDAP servers
+type bootstrapService struct {
+	entries []string
+	urls    []string
+}
+
+// bootstrapFile is a fetched registry
+type bootstrapFile struct {
+	fetched  time.Time
+	services []bootstrapService
+}
+
+// bootstrapCache keeps the registries fetched from IANA
+type bootstrapCache struct {
+	mu    sync.Mutex
+	files map[string]bootstrapFile
+}
+
+// bootstrap is the registry cache used by RDAP lookups
+var bootstrap = &bootstrapCache{files: make(map[string]bootstrapFile)}
+
+// get returns the services of a registry file, fetching it when it is not
+// cached or has expired
+func (c *bootstrapCache) get(ctx context.Context, name string, timeout time.Duration) ([]bootstrapService, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if file, ok := c.files[name]; ok && time.Since(file.fetched) < bootstrapTTL {
+		return file.services, nil
+	}
+
+	var registry struct {
+		Services [][][]string `json:"services"`
+	}
+	body, _, err := fetch(ctx, bootstrapURL+name, timeout)
+	if err == nil {
+		err = json.Unmarshal(body, &registry)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching RDAP bootstrap registry: %w", err)
+	}
+	file := bootstrapFile{fetched: time.Now()}
+	for _, service := range registry.Services {
+		if len(service) == 2 {
+			file.services = append(file.services, bootstrapService{entries: service[0], urls: service[1]})
+		}
+	}
+	c.files[name] = file
+	return file.services, nil
+}
+
+// fetch gets url, returning the body and the URL it came from after
+// redirects. Responses other than 200 OK are errors, as RDAP servers answer
+// unknown objects with 404.
+func fetch(ctx context.Context, url string, timeout time.Duration) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, url, err
+	}
+	tool.Identify(req)
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	resp, err := rdapClient.Do(req)
+	if err != nil {
+		return nil, url, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return nil, url, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, resp.Request.URL.String(), fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return body, resp.Request.URL.String(), nil
+}
+
+// preferHTTPS picks the first HTTPS base URL of a service, or its first URL
+func preferHTTPS(urls []string) string {
+	for _, u := range urls {
+		if strings.HasPrefix(u, "https://") {
+			return u
+		}
+	}
+	if len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
+
+// rdapServer finds the base URL of the RDAP server for a query in the
+// bootstrap registries: the longest matching TLD or prefix, or the AS
+// number range holding the number
+func rdapServer(ctx context.Context, kind, query string, timeout time.Duration) (string, error) {
+	file := kind
+	var addr netip.Addr
+	if kind == KindIP {
+		prefix, err := netip.ParsePrefix(query)
+		if err != nil {
+			a, err := netip.ParseAddr(query)
+			if err != nil {
+				return "", err
+			}
+			prefix = netip.PrefixFrom(a, a.BitLen())
+		}
+		addr = prefix.Addr()
+		file = "ipv6"
+		if addr.Is4() {
+			file = "ipv4"
+		}
+	}
+	services, err := bootstrap.get(ctx, bootstrapFiles[file], timeout)
+	if err != nil {
+		return "", err
+	}
+
+	best, bestLen := "", -1
+	for _, service := range services {
+		for _, entry := range service.entries {
+			switch kind {
+			case KindDomain:
+				if entry = strings.ToLower(entry); (query == entry || strings.HasSuffix(query, "."+entry)) && len(entry) > bestLen {
+					best, bestLen = preferHTTPS(service.urls), len(entry)
+				}
+			case KindIP:
+				if p, err := netip.ParsePrefix(entry); err == nil && p.Contains(addr) && p.Bits() > bestLen {
+					best, bestLen = preferHTTPS(service.urls), p.Bits()
+				}
+			case KindASN:
+				lo, hi, isRange := strings.Cut(entry, "-")
+				if !isRange {
+					hi = lo
+				}
+				n, _ := strconv.ParseUint(strings.TrimPrefix(query, "AS"), 10, 32)
+				first, loErr := strconv.ParseUint(lo, 10, 32)
+				last, hiErr := strconv.ParseUint(hi, 10, 32)
+				if loErr == nil && hiErr == nil && n >= first && n <= last {
+					best, bestLen = preferHTTPS(service.urls), 0
+				}
+			}
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no RDAP server is registered for %s", query)
+	}
+	return best, nil
+}
+
+// rdapPath returns the RDAP path of a query (RFC 9082)
+func rdapPath(kind, query string) string {
+	switch kind {
+	case KindIP:
+		return "ip/" + query
+	case KindASN:
+		return "autnum/" + strings.TrimPrefix(query, "AS")
+	}
+	return "domain/" + query
+}
+
+// rdapEntity is the part of an RDAP entity (RFC 9083) the record uses
+type rdapEntity struct {
+	Roles    []string        `json:"roles"`
+	VCard    json.RawMessage `json:"vcardArray"`
+	Entities []rdapEntity    `json:"entities"`
+}
+
+// rdapObject is the part of an RDAP domain, IP network or autnum object
+// the record uses
+type rdapObject struct {
+	LDHName     string   `json:"ldhName"`
+	Name        string   `json:"name"`
+	Status      []string `json:"status"`
+	Country     string   `json:"country"`
+	Port43      string   `json:"port43"`
+	Start       string   `json:"startAddress"`
+	End         string   `json:"endAddress"`
+	StartAutnum *uint32  `json:"startAutnum"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+	SecureDNS *struct {
+		DelegationSigned bool `json:"delegationSigned"`
+	} `json:"secureDNS"`
+	CIDRs []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+	Events []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+	Entities []rdapEntity `json:"entities"`
+	Links    []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+		Type string `json:"type"`
+	} `json:"links"`
+}
+
+// vcardValues returns the text values of a jCard (RFC 7095) property, such
+// as the fn, org, email or tel of an entity
+func vcardValues(vcard json.RawMessage, property string) []string {
+	var card []json.RawMessage
+	if json.Unmarshal(vcard, &card) != nil || len(card) != 2 {
+		return nil
+	}
+	var props [][]json.RawMessage
+	if json.Unmarshal(card[1], &props) != nil {
+		return nil
+	}
+	var values []string
+	for _, prop := range props {
+		var name, value string
+		if len(prop) < 4 || json.Unmarshal(prop[0], &name) != nil || name != property {
+			continue
+		}
+		if json.Unmarshal(prop[3], &value) == nil {
+			values = append(values, strings.TrimPrefix(value, "tel:"))
+		}
+	}
+	return values
+}
+
+// hasRole reports whether an entity has the role
+func (e rdapEntity) hasRole(role string) bool {
+	for _, r := range e.Roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// addEntities fills the registrar, holder and abuse contacts of r from
+// entities and the entities nested in them, where registries put the abuse
+// contact of the registrar
+func (r *Record) addEntities(entities []rdapEntity) {
+	for _, e := range entities {
+		fn := vcardValues(e.VCard, "fn")
+		switch {
+		case e.hasRole("registrar") && len(fn) > 0 && r.Registrar == "":
+			r.Registrar = fn[0]
+		case e.hasRole("registrant") && r.Organization == "":
+			if org := vcardValues(e.VCard, "org"); len(org) > 0 {
+				r.Organization = org[0]
+			} else if len(fn) > 0 {
+				r.Organization = fn[0]
+			}
+		case e.hasRole("abuse"):
+			for _, email := range vcardValues(e.VCard, "email") {
+				r.AbuseEmails = appendUnique(r.AbuseEmails, strings.ToLower(email))
+			}
+			for _, tel := range vcardValues(e.VCard, "tel") {
+				r.AbusePhones = appendUnique(r.AbusePhones, tel)
+			}
+		}
+		r.addEntities(e.Entities)
+	}
+}
+
+// parseRDAP converts an RDAP object to a record. Dates are RFC 3339 already.
+func parseRDAP(body []byte) (Record, error) {
+	var obj rdapObject
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return Record{}, fmt.Errorf("invalid RDAP response: %w", err)
+	}
+	r := Record{
+		Domain:      strings.ToLower(obj.LDHName),
+		WhoisServer: obj.Port43,
+		Status:      obj.Status,
+		Country:     strings.ToUpper(obj.Country),
+	}
+	for _, ns := range obj.Nameservers {
+		r.Nameservers = appendUnique(r.Nameservers, strings.TrimSuffix(strings.ToLower(ns.LDHName), "."))
+	}
+	if obj.SecureDNS != nil {
+		r.DNSSEC = "unsigned"
+		if obj.SecureDNS.DelegationSigned {
+			r.DNSSEC = "signedDelegation"
+		}
+	}
+	if obj.Start != "" {
+		r.Network = obj.Start + " - " + obj.End
+		r.NetName = obj.Name
+		var cidrs []string
+		for _, c := range obj.CIDRs {
+			cidrs = append(cidrs, fmt.Sprintf("%s%s/%d", c.V4Prefix, c.V6Prefix, c.Length))
+		}
+		r.CIDR = strings.Join(cidrs, ", ")
+	}
+	if obj.StartAutnum != nil {
+		r.ASN = strconv.FormatUint(uint64(*obj.StartAutnum), 10)
+		r.ASName = obj.Name
+	}
+	for _, event := range obj.Events {
+		switch event.Action {
+		case "registration":
+			r.Created = normalizeDate(event.Date)
+		case "last changed":
+			r.Updated = normalizeDate(event.Date)
+		case "expiration":
+			r.Expires = normalizeDate(event.Date)
+		}
+	}
+	r.addEntities(obj.Entities)
+
+	// Thin registries link to the registrar's own RDAP record of the domain
+	for _, link := range obj.Links {
+		if link.Rel == "related" && strings.Contains(link.Type, "rdap") && strings.Contains(link.Href, "/domain/") {
+			r.referral = link.Href
+		}
+	}
+	return r, nil
+}
+
+// LookupRDAP queries the RDAP server at the base URL opts.Server, or the one
+// the IANA bootstrap registries name, and follows related links to
+// registrars. The timeout applies to each request.
+func LookupRDAP(ctx context.Context, opts LookupOptions) Result {
+	result := Result{Query: opts.Query, Kind: opts.Kind, Format: FormatRDAP, Responses: []Response{}}
+	base := opts.Server
+	if base == "" {
+		var err error
+		if base, err = rdapServer(ctx, opts.Kind, opts.Query, opts.Timeout); err != nil {
+			result.Responses = append(result.Responses, Response{Server: bootstrapURL, Error: err.Error()})
+			return result
+		}
+	}
+	url := strings.TrimSuffix(base, "/") + "/" + rdapPath(opts.Kind, opts.Query)
+
+	seen := map[string]bool{}
+	var records []Record
+	for len(result.Responses) <= maxReferrals && url != "" && !seen[url] {
+		seen[url] = true
+		body, final, err := fetch(ctx, url, opts.Timeout)
+		response := Response{Server: final, Query: url, Raw: string(body)}
+		if err == nil {
+			var record Record
+			if record, err = parseRDAP(body); err == nil {
+				records = append(records, record)
+				result.Server = final
+				url = ""
+				if opts.Follow {
+					url = record.referral
+				}
+			}
+		}
+		if err != nil {
+			response.Error = err.Error()
+			result.Responses = append(result.Responses, response)
+			break
+		}
+		result.Responses = append(result.Responses, response)
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		result.Record.merge(records[i])
+	}
+	return result
+}
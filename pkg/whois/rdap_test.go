@@ -0,0 +1,141 @@
+package whois
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const rdapDomain = `{
+  "objectClassName": "domain",
+  "ldhName": "EXAMPLE.COM",
+  "status": ["client transfer prohibited"],
+  "port43": "whois.example-registrar.com",
+  "nameservers": [{"ldhName": "A.IANA-SERVERS.NET."}, {"ldhName": "b.iana-servers.net"}],
+  "secureDNS": {"delegationSigned": true},
+  "events": [
+    {"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+    {"eventAction": "expiration", "eventDate": "2025-08-13T04:00:00Z"},
+    {"eventAction": "last changed", "eventDate": "2024-08-14T07:01:34Z"}
+  ],
+  "entities": [
+    {
+      "roles": ["registrar"],
+      "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Registrar, Inc."]]],
+      "entities": [{
+        "roles": ["abuse"],
+        "vcardArray": ["vcard", [
+          ["email", {}, "text", "Abuse@Example-Registrar.com"],
+          ["tel", {"type": "voice"}, "uri", "tel:+1.5555551234"]
+        ]]
+      }]
+    },
+    {"roles": ["registrant"], "vcardArray": ["vcard", [["fn", {}, "text", "Jane Doe"], ["org", {}, "text", "Example Org"]]]}
+  ],
+  "links": [
+    {"rel": "self", "href": "https://rdap.verisign.com/com/v1/domain/EXAMPLE.COM", "type": "application/rdap+json"},
+    {"rel": "related", "href": "https://rdap.example-registrar.com/domain/EXAMPLE.COM", "type": "application/rdap+json"}
+  ]
+}`
+
+const rdapNetwork = `{
+  "objectClassName": "ip network",
+  "startAddress": "192.0.2.0",
+  "endAddress": "192.0.2.255",
+  "name": "EXAMPLE-NET",
+  "country": "nl",
+  "status": ["active"],
+  "cidr0_cidrs": [{"v4prefix": "192.0.2.0", "length": 24}],
+  "entities": [{"roles": ["registrant", "abuse"], "vcardArray": ["vcard", [["fn", {}, "text", "Example Networks"]]]}]
+}`
+
+func TestParseRDAP(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Record
+		wantErr bool
+	}{
+		{name: "domain", body: rdapDomain, want: Record{
+			Domain:       "example.com",
+			Registrar:    "Example Registrar, Inc.",
+			WhoisServer:  "whois.example-registrar.com",
+			Nameservers:  []string{"a.iana-servers.net", "b.iana-servers.net"},
+			Status:       []string{"client transfer prohibited"},
+			DNSSEC:       "signedDelegation",
+			Organization: "Example Org",
+			Created:      "1995-08-14T04:00:00Z",
+			Updated:      "2024-08-14T07:01:34Z",
+			Expires:      "2025-08-13T04:00:00Z",
+			AbuseEmails:  []string{"abuse@example-registrar.com"},
+			AbusePhones:  []string{"+1.5555551234"},
+			referral:     "https://rdap.example-registrar.com/domain/EXAMPLE.COM",
+		}},
+		{name: "network", body: rdapNetwork, want: Record{
+			Network:      "192.0.2.0 - 192.0.2.255",
+			CIDR:         "192.0.2.0/24",
+			NetName:      "EXAMPLE-NET",
+			Organization: "Example Networks",
+			Country:      "NL",
+			Status:       []string{"active"},
+		}},
+		{name: "autnum", body: `{"objectClassName": "autnum", "startAutnum": 64496, "name": "EXAMPLE-AS"}`, want: Record{ASN: "64496", ASName: "EXAMPLE-AS"}},
+		{name: "unsigned", body: `{"secureDNS": {"delegationSigned": false}}`, want: Record{DNSSEC: "unsigned"}},
+		{name: "broken vcards", body: `{"entities": [{"roles": ["registrar"], "vcardArray": "vcard"}, {"roles": ["abuse"], "vcardArray": ["vcard", [["email"]]]}]}`, want: Record{}},
+		{name: "invalid json", body: `{"ldhName": `, wantErr: true},
+		{name: "wrong type", body: `{"startAutnum": "64496"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRDAP([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRDAP() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRDAP() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVCardValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		vcard string
+		want  []string
+	}{
+		{name: "values", vcard: `["vcard", [["email", {}, "text", "a@example.net"], ["fn", {}, "text", "A"], ["email", {}, "text", "b@example.net"]]]`, want: []string{"a@example.net", "b@example.net"}},
+		{name: "tel uri", vcard: `["vcard", [["email", {"type": "work"}, "uri", "tel:+1.555"]]]`, want: []string{"+1.555"}},
+		{name: "structured value", vcard: `["vcard", [["email", {}, "text", ["a", "b"]], ["email", {}, "text", 5]]]`},
+		{name: "short property", vcard: `["vcard", [["email", {}, "text"], []]]`},
+		{name: "name not a string", vcard: `["vcard", [[1, {}, "text", "a@example.net"]]]`},
+		{name: "no properties", vcard: `["vcard"]`},
+		{name: "properties not a list", vcard: `["vcard", {"email": "a@example.net"}]`},
+		{name: "extra members", vcard: `["vcard", [], []]`},
+		{name: "not a list", vcard: `"vcard"`},
+		{name: "missing", vcard: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vcardValues(json.RawMessage(tt.vcard), "email"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vcardValues() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseRDAP(f *testing.F) {
+	for _, seed := range []string{rdapDomain, rdapNetwork, `{"entities": [{"entities": [{"roles": ["abuse"]}]}]}`, `["vcard"]`, `null`} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		r, err := parseRDAP(body)
+		if err != nil {
+			return
+		}
+		if _, err := json.Marshal(r); err != nil {
+			t.Fatalf("record of %q does not encode: %v", body, err)
+		}
+	})
+}
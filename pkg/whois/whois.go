@@ -0,0 +1,228 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+	"golang.org/x/net/idna"
+)
+
+// Whois query limits
+const (
+	rootServer     = "whois.iana.org"
+	defaultTimeout = 10      // Seconds allowed per server
+	maxReferrals   = 4       // Servers followed after the first
+	maxResponse    = 1 << 20 // Largest response read from a server in bytes
+)
+
+// Kinds of whois queries
+const (
+	KindDomain = "domain"
+	KindIP     = "ip"
+	KindASN    = "asn"
+)
+
+// Lookup protocols
+const (
+	FormatWhois = "whois" // Whois over TCP port 43 (RFC 3912)
+	FormatRDAP  = "rdap"  // RDAP over HTTPS (RFC 7482)
+)
+
+// asnPattern matches autonomous system numbers such as AS15169
+var asnPattern = regexp.MustCompile(`(?i)^AS(\d+)$`)
+
+// Response is the raw answer of one server
+type Response struct {
+	Server string `json:"server"`          // Server queried, as host:port, or the URL answering for RDAP
+	Query  string `json:"query"`           // Query line sent, or the URL requested
+	Raw    string `json:"raw"`             // Response text, JSON for RDAP
+	Error  string `json:"error,omitempty"` // Error querying the server
+}
+
+// Result is a whois lookup with its referrals followed
+type Result struct {
+	Query     string     `json:"query"`     // Query as normalized
+	Kind      string     `json:"kind"`      // domain, ip or asn
+	Format    string     `json:"format"`    // Protocol used, whois or rdap
+	Server    string     `json:"server"`    // Last server that answered, usually the most specific; a URL for RDAP
+	Record    Record     `json:"record"`    // Fields parsed from the responses
+	Responses []Response `json:"responses"` // Raw responses in the order the servers were queried
+}
+
+// LookupOptions contains the resolved whois lookup options
+type LookupOptions struct {
+	Query   string
+	Kind    string
+	Server  string
+	Follow  bool
+	Timeout time.Duration
+}
+
+// normalize classifies a query and converts domain names to ASCII
+func normalize(query string) (string, string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", "", errors.New("query is required")
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(query, "[]")); err == nil {
+		return addr.Unmap().String(), KindIP, nil
+	}
+	if prefix, err := netip.ParsePrefix(query); err == nil {
+		return prefix.Masked().String(), KindIP, nil
+	}
+	if m := asnPattern.FindStringSubmatch(query); m != nil {
+		return "AS" + m[1], KindASN, nil
+	}
+	name, err := idna.Lookup.ToASCII(strings.TrimSuffix(query, "."))
+	if err != nil || !strings.Contains(name, ".") {
+		return "", "", fmt.Errorf("invalid query %q: want a domain, IP address, network or AS number", query)
+	}
+	return strings.ToLower(name), KindDomain, nil
+}
+
+// serverAddress adds the whois port to a server given without one
+func serverAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "43")
+}
+
+// queryLine formats the query for servers that need flags to return the
+// records wanted, as ARIN does for networks and Verisign for domains
+func queryLine(server, kind, query string) string {
+	host, _, _ := net.SplitHostPort(server)
+	switch {
+	case host == "whois.arin.net" && kind == KindIP:
+		return "n + " + query
+	case host == "whois.arin.net" && kind == KindASN:
+		return "a + " + strings.TrimPrefix(query, "AS")
+	case host == "whois.verisign-grs.com" && kind == KindDomain:
+		return "domain " + query
+	case host == "whois.denic.de" && kind == KindDomain:
+		return "-T dn " + query
+	}
+	return query
+}
+
+// query sends one query line and reads the response until the server
+// closes the connection
+func query(ctx context.Context, server, line string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := io.WriteString(conn, line+"\r\n"); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(conn, maxResponse))
+	if err != nil && len(data) == 0 {
+		return "", err
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// Lookup queries the whois server, IANA by default, and follows the referrals of
+// each response to the registry and registrar servers
+func Lookup(ctx context.Context, opts LookupOptions) Result {
+	result := Result{Query: opts.Query, Kind: opts.Kind, Format: FormatWhois, Responses: []Response{}}
+	server := serverAddress(tool.GetOrDefault(&opts.Server, rootServer))
+	seen := map[string]bool{}
+	var records []Record
+	for len(result.Responses) <= maxReferrals && server != "" && !seen[server] {
+		seen[server] = true
+		line := queryLine(server, opts.Kind, opts.Query)
+		raw, err := query(ctx, server, line, opts.Timeout)
+		response := Response{Server: server, Query: line, Raw: raw}
+		if err != nil {
+			response.Error = err.Error()
+			result.Responses = append(result.Responses, response)
+			break
+		}
+		result.Responses = append(result.Responses, response)
+		result.Server = server
+
+		record := parse(raw)
+		following := opts.Follow && record.referral != ""
+		// IANA's referral describes the TLD or address block, not the query
+		if !following || server != serverAddress(rootServer) {
+			records = append(records, record)
+		}
+		if !following {
+			break
+		}
+		server = serverAddress(record.referral)
+	}
+
+	// Later responses come from more specific servers, whose fields win
+	for i := len(records) - 1; i >= 0; i-- {
+		result.Record.merge(records[i])
+	}
+	return result
+}
+
+// Handler looks up the query parameter, a domain, IP address, network or AS
+// number, over whois or, with format=rdap, RDAP. The server parameter
+// queries another whois server than IANA, or another RDAP base URL than the
+// bootstrap registries name, and follow=false stops at its answer instead of
+// following referrals.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, kind, err := normalize(params.Get("query"))
+	if err != nil {
+		tool.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := params.Get("format")
+	if format == "" {
+		format = FormatWhois
+	}
+	if format != FormatWhois && format != FormatRDAP {
+		tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, want whois or rdap", format))
+		return
+	}
+	opts := LookupOptions{Query: q, Kind: kind, Server: strings.TrimSpace(params.Get("server")), Follow: true, Timeout: defaultTimeout * time.Second}
+	if follow := params.Get("follow"); follow != "" {
+		if opts.Follow, err = strconv.ParseBool(follow); err != nil {
+			tool.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid follow %q", follow))
+			return
+		}
+	}
+	if timeout := params.Get("timeout"); timeout != "" {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil || seconds <= 0 {
+			tool.WriteError(w, http.StatusBadRequest, errors.New("timeout must be a positive number of seconds"))
+			return
+		}
+		opts.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	var result Result
+	if format == FormatRDAP {
+		result = LookupRDAP(r.Context(), opts)
+	} else {
+		result = Lookup(r.Context(), opts)
+	}
+	if result.Server == "" {
+		tool.WriteError(w, http.StatusBadGateway, fmt.Errorf("error querying %s: %s", result.Responses[0].Server, result.Responses[0].Error))
+		return
+	}
+	tool.WriteJSON(w, http.StatusOK, result)
+}
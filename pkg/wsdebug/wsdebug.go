@@ -0,0 +1,415 @@
+package wsdebug
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cksidharthan/net-tools/pkg/tool"
+)
+
+// Default values for handshake options
+const (
+	defaultTimeout = 10 // 10 second timeout for the whole handshake
+)
+
+// Handshake steps in the order they are attempted
+const (
+	StepDNS         = "dns"
+	StepTCP         = "tcp"
+	StepTLS         = "tls"
+	StepUpgrade     = "upgrade"
+	StepSubprotocol = "subprotocol"
+)
+
+// websocketGUID is the magic value used to derive Sec-WebSocket-Accept (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HandshakeMessage represents the incoming handshake debug request
+type HandshakeMessage struct {
+	// Required
+	URL string `json:"url"` // The ws:// or wss:// URL to connect to
+
+	// Optional parameters
+	Origin       *string           `json:"origin,omitempty"`       // Origin header to send
+	Subprotocols []string          `json:"subprotocols,omitempty"` // Subprotocols to offer
+	Headers      map[string]string `json:"headers,omitempty"`      // Extra request headers
+	Timeout      *int              `json:"timeout,omitempty"`      // Timeout in seconds
+	Insecure     *bool             `json:"insecure,omitempty"`     // Skip TLS certificate verification
+
+	ClientCert *tool.ClientCertificate `json:"client_cert,omitempty"` // Client certificate for mutual TLS
+}
+
+// StepMessage reports the outcome of a single handshake step
+type StepMessage struct {
+	Type     string  `json:"type"`            // Message type ("step")
+	Step     string  `json:"step"`            // Step name (dns, tcp, tls, upgrade, subprotocol)
+	Success  bool    `json:"success"`         // Whether the step succeeded
+	Duration float64 `json:"duration"`        // Step duration in milliseconds
+	Detail   string  `json:"detail"`          // Human readable detail about the step
+	Error    string  `json:"error,omitempty"` // Error message when the step failed
+}
+
+// ResultMessage summarises the whole handshake attempt
+type ResultMessage struct {
+	Type        string              `json:"type"`                  // Message type ("result")
+	Success     bool                `json:"success"`               // Whether the handshake completed
+	FailedStep  string              `json:"failed_step,omitempty"` // First step that failed
+	Status      int                 `json:"status,omitempty"`      // HTTP status code of the upgrade response
+	Headers     map[string][]string `json:"headers,omitempty"`     // Upgrade response headers
+	Subprotocol string              `json:"subprotocol,omitempty"` // Subprotocol selected by the server
+	Duration    float64             `json:"duration"`              // Total duration in milliseconds
+
+	ClientCert *tool.ClientAuthStatus `json:"client_cert,omitempty"` // Client certificate outcome when one was presented
+}
+
+// HandshakeOptions contains the resolved handshake options
+type HandshakeOptions struct {
+	URL          *url.URL
+	Origin       string
+	Subprotocols []string
+	Headers      map[string]string
+	Timeout      int
+	IsInsecure   bool
+	ClientAuth   *tool.ClientAuth
+}
+
+// resolveHandshakeOptions converts HandshakeMessage to HandshakeOptions with defaults
+func resolveHandshakeOptions(msg *HandshakeMessage) (HandshakeOptions, error) {
+	opts := HandshakeOptions{
+		Origin:       tool.GetOrDefault(msg.Origin, ""),
+		Subprotocols: msg.Subprotocols,
+		Headers:      msg.Headers,
+		Timeout:      tool.GetOrDefault(msg.Timeout, defaultTimeout),
+		IsInsecure:   tool.GetOrDefault(msg.Insecure, false),
+	}
+
+	if opts.Timeout <= 0 {
+		return opts, fmt.Errorf("timeout must be positive")
+	}
+
+	clientAuth, err := tool.NewClientAuth(msg.ClientCert)
+	if err != nil {
+		return opts, err
+	}
+	opts.ClientAuth = clientAuth
+
+	u, err := url.Parse(msg.URL)
+	if err != nil {
+		return opts, fmt.Errorf("invalid url: %w", err)
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return opts, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return opts, fmt.Errorf("url has no host")
+	}
+	opts.URL = u
+
+	return opts, nil
+}
+
+// elapsed returns the time since start in milliseconds
+func elapsed(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000.0
+}
+
+// debugger runs the handshake steps and reports each of them to the client
+type debugger struct {
+	session *tool.Session
+	opts    HandshakeOptions
+	result  ResultMessage
+}
+
+// report sends a step message and records the first failing step
+func (d *debugger) report(step string, start time.Time, detail string, err error) error {
+	msg := StepMessage{
+		Type:     "step",
+		Step:     step,
+		Success:  err == nil,
+		Duration: elapsed(start),
+		Detail:   detail,
+	}
+	if err != nil {
+		msg.Error = err.Error()
+		if d.result.FailedStep == "" {
+			d.result.FailedStep = step
+		}
+	}
+	log.Printf("wsdebug %s: %s (success=%t) %s", d.opts.URL, step, msg.Success, msg.Error)
+	return d.session.WriteJSON(msg)
+}
+
+// resolve looks up the addresses of the target host
+func (d *debugger) resolve(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	host := d.opts.URL.Hostname()
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, d.report(StepDNS, start, "host is an IP literal", nil)
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, d.report(StepDNS, start, "", err)
+	}
+	return addrs, d.report(StepDNS, start, strings.Join(addrs, ", "), nil)
+}
+
+// dial opens a TCP connection to the first reachable address
+func (d *debugger) dial(ctx context.Context, addrs []string) (net.Conn, error) {
+	start := time.Now()
+	port := d.opts.URL.Port()
+	if port == "" {
+		port = "80"
+		if d.opts.URL.Scheme == "wss" {
+			port = "443"
+		}
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, d.report(StepTCP, start, "connected to "+conn.RemoteAddr().String(), nil)
+	}
+	return nil, d.report(StepTCP, start, "", lastErr)
+}
+
+// handshakeTLS performs the TLS handshake for wss:// URLs
+func (d *debugger) handshakeTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	start := time.Now()
+	cfg := &tls.Config{
+		ServerName:         d.opts.URL.Hostname(),
+		InsecureSkipVerify: d.opts.IsInsecure,
+		NextProtos:         []string{"http/1.1"},
+	}
+	if d.opts.ClientAuth != nil {
+		d.opts.ClientAuth.Configure(cfg)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, d.report(StepTLS, start, "", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	detail := fmt.Sprintf("%s %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if d.opts.ClientAuth != nil && d.opts.ClientAuth.Requested() {
+		detail += ", client certificate requested"
+	}
+	return tlsConn, d.report(StepTLS, start, detail, nil)
+}
+
+// newChallengeKey generates a random Sec-WebSocket-Key
+func newChallengeKey() (string, error) {
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(p), nil
+}
+
+// acceptKey computes the expected Sec-WebSocket-Accept value for a key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContainsToken reports whether a comma separated header contains token
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade sends the HTTP upgrade request and validates the 101 response
+func (d *debugger) upgrade(conn net.Conn) (*http.Response, error) {
+	start := time.Now()
+
+	key, err := newChallengeKey()
+	if err != nil {
+		return nil, d.report(StepUpgrade, start, "", err)
+	}
+
+	u := *d.opts.URL
+	u.Scheme = "http"
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       d.opts.URL.Host,
+	}
+	tool.Identify(req)
+	for name, value := range d.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if d.opts.Origin != "" {
+		req.Header.Set("Origin", d.opts.Origin)
+	}
+	if len(d.opts.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.opts.Subprotocols, ", "))
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, d.report(StepUpgrade, start, "", fmt.Errorf("error writing upgrade request: %w", err))
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, d.report(StepUpgrade, start, "", fmt.Errorf("error reading upgrade response: %w", err))
+	}
+	resp.Body.Close()
+
+	d.result.Status = resp.StatusCode
+	d.result.Headers = resp.Header
+
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		err = fmt.Errorf("expected status 101, got %s", resp.Status)
+	case !headerContainsToken(resp.Header, "Upgrade", "websocket"):
+		err = fmt.Errorf("missing or invalid Upgrade header %q", resp.Header.Get("Upgrade"))
+	case !headerContainsToken(resp.Header, "Connection", "upgrade"):
+		err = fmt.Errorf("missing or invalid Connection header %q", resp.Header.Get("Connection"))
+	case resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key):
+		err = fmt.Errorf("invalid Sec-WebSocket-Accept %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	if err != nil {
+		return resp, d.report(StepUpgrade, start, resp.Status, err)
+	}
+	return resp, d.report(StepUpgrade, start, resp.Status, nil)
+}
+
+// checkSubprotocol verifies the server selected one of the offered subprotocols
+func (d *debugger) checkSubprotocol(resp *http.Response) error {
+	start := time.Now()
+	selected := resp.Header.Get("Sec-WebSocket-Protocol")
+	d.result.Subprotocol = selected
+
+	if len(d.opts.Subprotocols) == 0 {
+		if selected != "" {
+			return d.report(StepSubprotocol, start, "", fmt.Errorf("server selected %q but none were offered", selected))
+		}
+		return d.report(StepSubprotocol, start, "no subprotocol offered", nil)
+	}
+	for _, protocol := range d.opts.Subprotocols {
+		if protocol == selected {
+			return d.report(StepSubprotocol, start, "server selected "+selected, nil)
+		}
+	}
+	if selected == "" {
+		return d.report(StepSubprotocol, start, "", fmt.Errorf("server did not select any of %s", strings.Join(d.opts.Subprotocols, ", ")))
+	}
+	return d.report(StepSubprotocol, start, "", fmt.Errorf("server selected %q which was not offered", selected))
+}
+
+// run performs every handshake step, stopping at the first failure
+func (d *debugger) run(ctx context.Context) error {
+	addrs, err := d.resolve(ctx)
+	if err != nil || d.result.FailedStep != "" {
+		return err
+	}
+
+	conn, err := d.dial(ctx, addrs)
+	if err != nil || d.result.FailedStep != "" {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if d.opts.URL.Scheme == "wss" {
+		conn, err = d.handshakeTLS(ctx, conn)
+		if err != nil || d.result.FailedStep != "" {
+			return err
+		}
+	}
+
+	resp, err := d.upgrade(conn)
+	if err != nil || d.result.FailedStep != "" {
+		return err
+	}
+
+	return d.checkSubprotocol(resp)
+}
+
+// Handler handles WebSocket handshake debug requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	session, err := tool.Upgrade(w, r, "wsdebug")
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer session.Close()
+
+	var msg HandshakeMessage
+	if err := session.ReadJSON(&msg); err != nil {
+		log.Printf("Error reading handshake message: %v", err)
+		return
+	}
+
+	opts, err := resolveHandshakeOptions(&msg)
+	if err != nil {
+		log.Printf("Invalid handshake options: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	d := &debugger{
+		session: session,
+		opts:    opts,
+		result:  ResultMessage{Type: "result"},
+	}
+	if err := d.run(ctx); err != nil {
+		log.Printf("Failed to send step: %v", err)
+		return
+	}
+
+	d.result.Success = d.result.FailedStep == ""
+	if d.opts.ClientAuth != nil {
+		// The server accepted the certificate if it answered the upgrade request
+		status := d.opts.ClientAuth.Status(d.result.Status != 0)
+		d.result.ClientCert = &status
+	}
+	d.result.Duration = elapsed(start)
+	if err := session.WriteJSON(d.result); err != nil {
+		log.Printf("Failed to send result: %v", err)
+	}
+}